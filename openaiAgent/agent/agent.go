@@ -0,0 +1,1498 @@
+// Package agent runs a tool calling agent over the Store Sales Price Elasticity Promotions dataset.
+//
+// Create an Agent with New, which holds its own chat client, database and tracer,
+// then call Run for each prompt and Close once done.
+package agent
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/anthropic"
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/config"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/language"
+	"github.com/EzequielGhR/goProjects/openaiAgent/llmdebug"
+	"github.com/EzequielGhR/goProjects/openaiAgent/prompts"
+	"github.com/EzequielGhR/goProjects/openaiAgent/render"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+---------------------------------
+Tools Json structure with structs
+---------------------------------
+*/
+
+// Property information for tool function parameter
+type toolFunctionParameterPropertyInfo struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+// Properties for tool function
+type toolFunctionParameterProperties struct {
+	Data              toolFunctionParameterPropertyInfo `json:"data"`
+	DataRef           toolFunctionParameterPropertyInfo `json:"dataRef"`
+	Prompt            toolFunctionParameterPropertyInfo `json:"prompt"`
+	VisualizationGoal toolFunctionParameterPropertyInfo `json:"visualizationGoal"`
+	ReportName        toolFunctionParameterPropertyInfo `json:"reportName"`
+	Theme             toolFunctionParameterPropertyInfo `json:"theme"`
+	Query             toolFunctionParameterPropertyInfo `json:"query"`
+	Metric            toolFunctionParameterPropertyInfo `json:"metric"`
+	GroupBy           toolFunctionParameterPropertyInfo `json:"groupBy"`
+	Period            toolFunctionParameterPropertyInfo `json:"period"`
+	BaselinePeriod    toolFunctionParameterPropertyInfo `json:"baselinePeriod"`
+}
+
+// Parameters information fot tool function
+type toolFunctionParametersInfo struct {
+	Type       string                          `json:"type"`
+	Properties toolFunctionParameterProperties `json:"properties"`
+	Required   []string                        `json:"required"`
+}
+
+// Main level of configuration for tool function
+type toolFunctionConfig struct {
+	Name        string                     `json:"name"`
+	Description string                     `json:"description"`
+	Parameters  toolFunctionParametersInfo `json:"parameters"`
+}
+
+// Tool config base level
+type toolConfig struct {
+	Type     string             `json:"type"`
+	Function toolFunctionConfig `json:"function"`
+}
+
+type toolFunctionArgs struct {
+	Data              string `json:"data"`
+	DataRef           string `json:"dataRef"`
+	Prompt            string `json:"prompt"`
+	VisualizationGoal string `json:"visualizationGoal"`
+	ReportName        string `json:"reportName"`
+	Theme             string `json:"theme"`
+	Query             string `json:"query"`
+	Metric            string `json:"metric"`
+	GroupBy           string `json:"groupBy"`
+	Period            string `json:"period"`
+	BaselinePeriod    string `json:"baselinePeriod"`
+}
+
+// Result of an agent run
+type RunResult struct {
+	Answer       string            // Final answer of the agent
+	RunID        string            // Identifier of the run, also the name of its artifacts directory
+	RunDir       string            // Directory holding the run artifacts
+	Artifacts    []string          // Artifacts produced by the tools, relative to RunDir
+	ArtifactURLs map[string]string // URLs of the published artifacts, keyed by their name
+	FollowUps    []string          // Suggested follow up questions, if enabled
+	Prompt       string            // Latest user prompt of the run
+	Tools        []string          // Function names of the tools enabled for the run
+	TraceID      string            // Trace ID of the AgentRun span, empty if the tracer records nothing
+	Model        string            // Chat model of the run
+	Language     string            // Language code the run answered in
+	Verbosity    string            // Answer verbosity level of the run
+	Sample       string            // Sample of the dataset the run answered on, like "5%". Empty for the whole data
+	Tags         map[string]string // Metadata tags of the run, redacted. Nil when there are none
+	ToolCalls    int               // Amount of tool calls the router made
+	Iterations   int               // Amount of router calls made
+	Completed    bool              // The router gave a final answer, the run can't be resumed
+
+	Ungrounded       bool   // The router answered with figures without looking up any data
+	UngroundedAction string // What was done about it: UngroundedWarned, UngroundedRetried or UngroundedRetriedWarned
+
+	ResumedFrom string // Trace ID of the run this one resumed, if any
+
+	Interim []InterimContent // Content the router sent along its tool calls, in order. Never part of the answer
+
+	Usage       tools.Usage                 // Token usage of every completion made on the run
+	Completions []tools.CompletionCall      // Completion requests of the run, with their OpenAI request IDs
+	Retrieval   tools.RetrievalStats        // Data read by the lookups of the run
+	Queries     []tools.AuditEntry          // SQL executed by the tools of the run, in order
+	Timings     map[string]tools.StepTiming // Time spent per step of the run, keyed by tools.Timing* and tools.ToolTiming names
+	Provenance  *Provenance                 // Steps, queries and models behind the answer, only with Config.ExplainAnswer
+	Refusal     *tools.EntityMatch          // Sensitive column value the question was refused for, if any
+
+	PlanDivergence *PlanDivergence // Planned against called tools, only for runs made with RunWithPlan
+
+	Degraded []string // Optional steps skipped because the run deadline was near, Degraded* constants
+
+	Deterministic bool  // Whether completions used a fixed seed and zero temperature
+	Seed          int64 // Seed of deterministic runs
+
+	// Conversation messages of the run, including tool calls and results
+	Messages []openai.ChatCompletionMessageParamUnion
+}
+
+// Content the router sent along tool calls, usually explaining what it's about to do
+type InterimContent struct {
+	Iteration int      `json:"iteration"` // Router call that produced it, starting at 1
+	Content   string   `json:"content"`
+	ToolCalls []string `json:"toolCalls"` // Functions called on the same response
+}
+
+// Agent configuration. Zero values fall back to the default noted on each field
+type Config struct {
+	Model              string                  // Chat model, defaults to tools.DefaultModel
+	AllowUnknownModels bool                    // Accept models missing from the completion model registry, like fine tuned ones. Otherwise New fails on them
+	DataPath           string                  // Parquet data path, s3://, gs://, https:// URL, .duckdb file or md: DSN, defaults to tools.DefaultDataPath
+	ToolsJsonPath      string                  // Tools json config, defaults to tools.DefaultToolsJsonPath
+	OutputDir          string                  // Base directory for per run artifacts, defaults to "runs"
+	DatabasePath       string                  // DuckDB database file, defaults to <OutputDir>/data.db
+	MaxDBSizeMB        int                     // Size cap of the database file checked on warm up, see DBSizeAction. Zero has no cap
+	DBSizeAction       string                  // What the warm up does over MaxDBSizeMB: DBSizeWarn or DBSizeVacuum. Empty warns
+	StorageBackend     string                  // tools.StorageDuckDB or tools.StorageMemory. Empty reads STORAGE_BACKEND, then falls back to memory if DuckDB can't start
+	AuditPath          string                  // SQL audit log, defaults to <OutputDir>/audit.jsonl
+	ReportsDir         string                  // Directory of the saved reports, defaults to <OutputDir>/reports
+	ThemesDir          string                  // Directory of the chart theme files, defaults to tools.DefaultThemesDir
+	ChartTheme         string                  // Theme of the charts unless a visualization call names another. Empty uses the built in colorblind safe one
+	DisableAudit       bool                    // Don't record executed SQL on the audit log
+	SkipDatasetCheck   bool                    // Skip row count and schema validation, for intentionally empty datasets
+	ForceRefresh       bool                    // Reload the dataset table even if the data file fingerprint is unchanged
+	ReadOnly           bool                    // Open the database read-only, after bootstrapping the dataset on a separate connection. Temporary tables and reloads are disabled
+	Production         bool                    // Deployment is production: New fails without ReadOnly, and so does the warm up on a connection that can write
+	Sample             tools.Sample            // Part of the parquet data loaded, to cut costs while developing. Zero loads the whole file
+	SampledExport      bool                    // Publish the artifacts of sampled runs. Without it a Publisher fails New on sampled data
+	ExplainQueries     bool                    // Profile lookup queries to report the rows they scan. Doubles their cost
+	ScanGuard          tools.ScanGuard         // Rows lookup query plans may estimate with full scans before they're regenerated or warned about. Zero disables it
+	ReloadTools        bool                    // Re-stat the tools json before each run and reload it when it changed
+	WatchData          bool                    // Check the data file fingerprint before each run and reload the dataset when it changed
+	ToolTimeouts       tools.ToolTimeouts      // Max duration of each tool call, zero values default to tools.DefaultToolTimeouts
+	MaxIterations      int                     // Max router calls of a run before it's aborted, defaults to DefaultMaxIterations
+	MaxArgumentBytes   int                     // Max bytes of each tool call argument, longer data is truncated. Defaults to a tenth of the Model context window
+	ToolModels         tools.ToolModels        // Models of single tools, empty ones use Model. Claude models are completed by Anthropic
+	RateLimits         completion.RateLimits   // Requests and tokens per minute budgets. Zero values use OPENAI_RPM and OPENAI_TPM
+	HTTP               completion.HTTPSettings // Connection pool and timeouts of the OpenAI client. Zero values use the shared client, tuned by OPENAI_MAX_IDLE_CONNS_PER_HOST and OPENAI_RESPONSE_TIMEOUT
+	Language           string                  // Answer language code, like "es". Empty detects it from each prompt
+	Verbosity          string                  // Answer depth: prompts.VerbosityBrief, VerbosityNormal or VerbosityDetailed. Empty is normal
+	Ungrounded         string                  // What to do with answers stating figures when no data was looked up: UngroundedWarn, UngroundedRetry or UngroundedOff. Empty warns
+	GroundedAnalysis   bool                    // Have analyses cite the data rows supporting them, verified before answering
+	MergeSystemPrompt  bool                    // Merge the dataset system prompt into the first system message of conversations bringing one, instead of leaving it out
+	InjectionPatterns  []string                // Case-insensitive patterns of instruction-like phrases neutralized in tool results. Empty uses DefaultInjectionPatterns
+	Tools              []string                // Allowed tools, by short name ("lookup", "analyze", "visualize") or function name. Empty allows all
+	SuggestFollowUps   bool                    // Append suggested follow up questions to the final answer
+	DegradeFloor       time.Duration           // Runs whose context deadline leaves less than this skip optional steps, see Degraded*. Defaults to DefaultDegradeFloor
+	RawNumbers         bool                    // Leave the numbers of the final answer as the model wrote them instead of formatting them
+	NumberLocale       string                  // Separators of formatted numbers, like "fr". Empty uses the answer language
+	NumberDecimals     int                     // Max decimals of formatted numbers, defaults to render.DefaultNumberDecimals
+	ExplainAnswer      bool                    // Append the tools, SQL queries and models behind the answer, also set as RunResult.Provenance
+	ProfilePrompt      bool                    // Describe the dataset to the router with its cached profile, computed on New if missing
+	DatasetDescription string                  // Few sentences on what the dataset is, added to the router prompt and the lookup tool description. See DescribeDataset
+	Glossary           []tools.GlossaryTerm    // Business terms mapped to columns, besides the dataset ones. Unknown columns fail New
+	ColumnUnits        []tools.ColumnUnit      // Units of columns, besides the dataset ones. Unknown or mismatched units are logged by New
+	DateOrder          tools.DateOrder         // Day and month order of numeric dates on lookup prompts. Unset has lookups ask the user about ambiguous ones
+	Today              time.Time               // Day relative dates like "last week" resolve against. Zero is the day of each run
+	SensitiveColumns   []string                // Columns identifying individuals, like customer or employee ids. Questions filtering on them are refused
+	Principal          string                  // User running the agent. Principals on ENTITY_GUARD_ALLOWLIST skip the sensitive column check
+	Role               string                  // Role of the principal, checked against the roles allowed on each dataset. Empty has full access, like the CLI
+	Access             *tools.AccessPolicy     // Roles of the API keys and roles allowed per dataset, over the dataset schemas. Nil uses the schemas only
+	User               string                  // End user completions are attributed to on the OpenAI user field. Emails are sent hashed
+	Tags               map[string]string       // Metadata tags of every run, like the experiment name, set as metadata.<key> span attributes. trace_id and model are reserved
+	RedactTag          TagRedactor             // Redaction hook tag values pass through before they're recorded. Nil uses RedactTagValue
+	FineTunePath       string                  // JSONL file SQL generations are captured to, for fine tuning datasets. Empty captures nothing
+	FineTuneSample     float64                 // Share of the SQL generations captured, in (0, 1]. Zero captures all of them
+	AnalysesIndexPath  string                  // JSONL index of past questions and answers, appended after completed runs and searched by SearchPastAnalyses. Empty turns both off
+	EmbeddingModel     string                  // Model embedding the past analyses, defaults to tools.DefaultEmbeddingModel
+	Deterministic      bool                    // Request every completion with Seed and zero temperature, for reproducible runs
+	Seed               int64                   // Seed for deterministic runs, defaults to tools.DefaultSeed
+	Completer          tools.ChatCompleter     // Chat client, defaults to the shared OpenAI client, which needs OPENAI_API_KEY
+	Embedder           tools.Embedder          // Embeddings client of the past analyses, defaults to the shared OpenAI client
+	Tracer             *tracing.Tracer         // Span tracer, defaults to a tracer that records nothing
+	Publisher          tools.ArtifactPublisher // Object storage artifacts are published to, defaults to the one of ARTIFACT_PUBLISH_URL. Nil keeps them local
+	ConfirmSQL         tools.SQLConfirmer      // Asked before each generated query runs, like the -confirm-sql terminal prompt. Nil runs them unconfirmed
+}
+
+// Tool calling agent. Safe to reuse for several runs, each one gets its own tools and artifacts directory
+type Agent struct {
+	config    Config
+	completer tools.ChatCompleter
+	store     tools.Store
+	tracer    *tracing.Tracer
+	audit     *tools.AuditLog
+	toolSet   *toolSet
+
+	liveTables        []string                // Tables of a live database, discovered on New
+	limiter           *completion.RateLimiter // Budgets every completion waits for, nil when unlimited
+	fineTune          *tools.FineTuneCapture  // Capture of the SQL generations, nil unless Config.FineTunePath is set
+	pastAnalyses      *tools.AnalysisIndex    // Index of past analyses, nil unless Config.AnalysesIndexPath is set
+	chartTheme        tools.ChartTheme        // Loaded Config.ChartTheme
+	sensitiveColumns  []string                // Configured sensitive columns found on the dataset
+	glossary          *tools.Glossary         // Dataset and configured business terms, checked against the dataset columns
+	units             *tools.ColumnUnits      // Dataset and configured column units, checked against the dataset columns
+	entityGuardBypass bool                    // Principal is allowlisted, questions about individuals aren't refused
+	injectionPatterns []*regexp.Regexp        // Compiled Config.InjectionPatterns, applied to every tool result
+	data              *dataState              // Dataset profile and reloads, shared with the derived agents
+	sharedDB          bool                    // Dataset store belongs to the agent this one was derived from
+	dbMutex           *sync.RWMutex           // Held for reading by runs and reports, and for writing by vacuums
+	live              *liveRuns               // Latest checkpoint of the runs going on, shared with the derived agents
+	warmup            *warmupState
+}
+
+// Agent input interface
+type AgentInput interface {
+	string | []openai.ChatCompletionMessageParamUnion
+}
+
+/*
+---------
+Constants
+---------
+*/
+
+// Router calls a run may make by default before it's aborted
+const DefaultMaxIterations = 10
+
+const systemPrompt = "You are a helpful assistant that can answer questions about the Store Sales Price Elasticity Promotions dataset." +
+	" To chart data already looked up on this conversation, like when asked to \"chart that\", pass the most recent result_ref" +
+	" as dataRef to GenerateVisualization instead of looking the data up again." +
+	untrustedToolOutputPrompt
+
+// The router kept calling tools through every router call the run was allowed
+type ErrMaxIterations struct {
+	Limit int
+}
+
+func (e *ErrMaxIterations) Error() string {
+	return fmt.Sprintf("no final answer after %d router calls", e.Limit)
+}
+func (e *ErrMaxIterations) ExitClass() exitcode.Class { return exitcode.ClassBudget }
+
+/*
+-------------
+Aux functions
+-------------
+*/
+
+// Handle the different tool calls and append result messages to ongoing conversation.
+// Receives the router span context, the run tools, an array of tool calls and an array of current conversation messages.
+// checkpoint is called with the conversation after each tool result.
+// Failed calls are answered with a JSON tool error. Returns an error if any failure isn't retryable
+func (a *Agent) handleToolCalls(
+	routerCtx context.Context,
+	toolbox *tools.Toolbox,
+	toolCalls []openai.ChatCompletionMessageToolCall,
+	messages []openai.ChatCompletionMessageParamUnion,
+	checkpoint func([]openai.ChatCompletionMessageParamUnion),
+) ([]openai.ChatCompletionMessageParamUnion, error) {
+	// Start Span as sub span of the router call's
+	ctx, span := a.tracer.StartOpenInferenceSpan(routerCtx, "HandleToolCalls", tracing.ChainKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	// Track input and output for span's attribute set up
+	inputAttr := []string{}
+	outputAttr := []string{}
+
+	// Track sanitization of tool results
+	neutralizedTotal := 0
+	sanitizedCalls := []string{}
+	blockedCalls := []string{}
+	errorClasses := []string{}
+	truncatedCalls := []string{}
+	originalBytes, truncatedBytes := []int{}, []int{}
+	var fatalErr error
+	for _, toolCall := range toolCalls {
+		// Update the input attribute
+		inputAttr = append(inputAttr, toolCall.JSON.RawJSON())
+
+		functionName := toolCall.Function.Name
+		functionArgs := toolFunctionArgs{}
+
+		log.Printf("Processing Tool Call '%s' for function '%s'\n", toolCall.ID, functionName)
+
+		// The model may call a tool it wasn't offered. Never execute it
+		result := ""
+		var err error
+		if !slices.Contains(a.config.Tools, functionName) {
+			log.Printf("WARNING: Blocked call to disabled tool '%s'\n", functionName)
+			result = fmt.Sprintf(language.Message(toolbox.Language(), language.ToolDisabled), functionName)
+			blockedCalls = append(blockedCalls, toolCall.ID)
+		} else if err = json.Unmarshal([]byte(toolCall.Function.Arguments), &functionArgs); err != nil {
+			err = &tools.ErrInvalidArguments{Tool: functionName, Reason: err.Error()}
+		} else {
+			// Pasted data blobs can blow up the tool prompts, cut them down to the cap
+			var truncation *ArgumentTruncation
+			truncation, err = capToolArguments(functionName, &functionArgs, a.config.MaxArgumentBytes)
+			if err == nil {
+				start := time.Now()
+				result, err = a.dispatchToolCall(ctx, toolbox, functionName, functionArgs)
+				tools.TimingsFrom(ctx).Since(tools.ToolTiming(functionName), start)
+			}
+			if truncation != nil {
+				log.Printf("WARNING: Truncated the data of tool call '%s' from %d to %d bytes\n", toolCall.ID, truncation.OriginalBytes, truncation.TruncatedBytes)
+				truncatedCalls = append(truncatedCalls, toolCall.ID)
+				originalBytes = append(originalBytes, truncation.OriginalBytes)
+				truncatedBytes = append(truncatedBytes, truncation.TruncatedBytes)
+				if err == nil {
+					result = fmt.Sprintf(truncatedDataNote, a.config.MaxArgumentBytes) + result
+				}
+			}
+		}
+
+		// Let the router know what failed and whether retrying makes sense
+		if err != nil {
+			log.Printf("WARNING: Tool call '%s' failed: %s\n", toolCall.ID, err)
+			result = tools.FormatToolError(err)
+			errorClasses = append(errorClasses, tools.ErrorClass(err))
+			// Denials aren't fatal, the router tells the user what they may not query
+			var denied *tools.ErrAccessDenied
+			if !tools.IsRetryable(err) && !errors.As(err, &denied) && fatalErr == nil {
+				fatalErr = fmt.Errorf("tool '%s' failed: %w", functionName, err)
+			}
+		}
+
+		// Tool output is untrusted, sanitize it before it reaches the router
+		result, neutralized := sanitizeToolOutput(result, a.injectionPatterns)
+		if neutralized != 0 {
+			log.Printf("WARNING: Neutralized %d instruction-like phrases in '%s' output\n", neutralized, functionName)
+			neutralizedTotal += neutralized
+			sanitizedCalls = append(sanitizedCalls, toolCall.ID)
+		}
+
+		response := openai.ToolMessage(toolCall.ID, result)
+		messages = append(messages, response)
+		checkpoint(messages)
+
+		// Update output attribute
+		outputAttr = append(outputAttr, response.Content.String())
+	}
+
+	tracing.SetSpanInput(span, inputAttr)
+	tracing.SetSpanOutput(span, outputAttr)
+	tracing.SetSpanModel(span, a.config.Model)
+	tracing.SetSpanAttrFromMap(span, map[string]any{
+		"agent.sanitization.neutralized_count":    neutralizedTotal,
+		"agent.sanitization.tool_call_ids":        sanitizedCalls,
+		"agent.blocked_tool_call_ids":             blockedCalls,
+		"agent.tool_error_classes":                errorClasses,
+		"agent.arguments.truncated_tool_call_ids": truncatedCalls,
+		"agent.arguments.original_bytes":          originalBytes,
+		"agent.arguments.truncated_bytes":         truncatedBytes,
+	})
+
+	if fatalErr != nil {
+		tracing.SetSpanErrorCode(span)
+		return messages, fatalErr
+	}
+
+	// Mark the execution as a success
+	tracing.SetSpanSuccessCode(span)
+	return messages, nil
+}
+
+// Execute an allowed tool call and return its result for the model.
+// The call is cancelled once it runs over the tool timeout
+func (a *Agent) dispatchToolCall(
+	parentCtx context.Context,
+	toolbox *tools.Toolbox,
+	functionName string,
+	functionArgs toolFunctionArgs,
+) (string, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, a.config.ToolTimeouts.For(functionName))
+	defer cancel()
+
+	switch functionName {
+	case tools.LookUpFuncName:
+		return toolbox.LookUpSalesData(ctx, functionArgs.Prompt)
+	case tools.AnalyzeFuncName:
+		return toolbox.AnalyzeSalesData(ctx, functionArgs.Prompt, functionArgs.Data, functionArgs.DataRef)
+	case tools.VisualizeFuncName:
+		return toolbox.GenerateVisualization(ctx, functionArgs.Data, functionArgs.DataRef, functionArgs.VisualizationGoal, functionArgs.ReportName, functionArgs.Theme)
+	case tools.SearchPastAnalysesFuncName:
+		return toolbox.SearchPastAnalyses(ctx, functionArgs.Query)
+	case tools.ComparePeriodsFuncName:
+		return toolbox.ComparePeriods(ctx, functionArgs.Metric, functionArgs.GroupBy, functionArgs.Period, functionArgs.BaselinePeriod)
+	}
+
+	return "", &tools.ErrInvalidArguments{Tool: functionName, Reason: "unknown tool"}
+}
+
+// Interim content of a router response calling tools. False for responses without content or tool calls
+func interimContent(response openai.ChatCompletionMessage, iteration int) (InterimContent, bool) {
+	content := strings.TrimSpace(response.Content)
+	if content == "" || len(response.ToolCalls) == 0 {
+		return InterimContent{}, false
+	}
+
+	interim := InterimContent{Iteration: iteration, Content: content, ToolCalls: []string{}}
+	for _, toolCall := range response.ToolCalls {
+		interim.ToolCalls = append(interim.ToolCalls, toolCall.Function.Name)
+	}
+
+	return interim, true
+}
+
+// Correctly format messages for agent handling. Expects a type of AgentInput which can be
+// a string or an array of ChatcompletionMessageParamUnion. Conversations without a system message get the
+// dataset one first. Those with their own keep it, along with the untrusted tool output instruction, or with
+// mergeSystemPrompt get the dataset prompt merged into their first one, wherever it is
+func formatAgentMessages[T AgentInput](messages T, mergeSystemPrompt bool) []openai.ChatCompletionMessageParamUnion {
+	var openaiMessages []openai.ChatCompletionMessageParamUnion
+
+	// Convert the message to expected format if necessary
+	switch value := any(messages).(type) {
+	case string:
+		log.Println("Converting string message")
+		openaiMessages = []openai.ChatCompletionMessageParamUnion{openai.UserMessage(value)}
+	case []openai.ChatCompletionMessageParamUnion:
+		openaiMessages = value
+	default:
+		log.Panic("messages are not on expected types")
+	}
+
+	// System messages may be any variant of the union, see isSystemMessage
+	systemIndex := slices.IndexFunc(openaiMessages, isSystemMessage)
+	if systemIndex < 0 {
+		log.Println("Adding system message")
+		return append([]openai.ChatCompletionMessageParamUnion{openai.SystemMessage(systemPrompt)}, openaiMessages...)
+	}
+
+	callerPrompt := messageText(openaiMessages[systemIndex])
+	if strings.Contains(callerPrompt, systemPrompt) {
+		return openaiMessages
+	}
+	if !mergeSystemPrompt {
+		// The caller's prompt replaces the dataset one, but tool results are still untrusted data
+		return withSystemMessage(openaiMessages, strings.TrimSpace(untrustedToolOutputPrompt))
+	}
+
+	// The caller's slice is left as it was
+	log.Printf("Merging system prompt into the system message at position %d\n", systemIndex)
+	openaiMessages = slices.Clone(openaiMessages)
+	openaiMessages[systemIndex] = openai.SystemMessage(systemPrompt + "\n\n" + callerPrompt)
+	return openaiMessages
+}
+
+// Convert an array of tool configs to openai expected tool param. Fails on functions the agent doesn't implement
+func convertToolConfigToParams(toolConfigs []toolConfig) ([]openai.ChatCompletionToolParam, error) {
+	openaiToolParam := []openai.ChatCompletionToolParam{}
+	for _, config := range toolConfigs {
+		log.Printf("Converting tool config for function '%s' to param\n", config.Function.Name)
+
+		// Each config has its own properties, map them using the function name
+		var propertiesMap map[string]any
+		switch config.Function.Name {
+		case tools.LookUpFuncName:
+			propertiesMap = map[string]any{
+				"prompt": map[string]string{
+					"type": config.Function.Parameters.Properties.Prompt.Type,
+				},
+			}
+		case tools.AnalyzeFuncName:
+			propertiesMap = map[string]any{
+				"prompt": map[string]string{
+					"type": config.Function.Parameters.Properties.Prompt.Type,
+				},
+				"data": map[string]string{
+					"type": config.Function.Parameters.Properties.Data.Type,
+				},
+			}
+		case tools.VisualizeFuncName:
+			propertiesMap = map[string]any{
+				"data": map[string]string{
+					"type": config.Function.Parameters.Properties.Data.Type,
+				},
+				"visualizationGoal": map[string]string{
+					"type": config.Function.Parameters.Properties.VisualizationGoal.Type,
+				},
+			}
+		case tools.SearchPastAnalysesFuncName:
+			propertiesMap = map[string]any{
+				"query": map[string]string{
+					"type": config.Function.Parameters.Properties.Query.Type,
+				},
+			}
+		case tools.ComparePeriodsFuncName:
+			propertiesMap = map[string]any{
+				"metric": map[string]string{
+					"type": config.Function.Parameters.Properties.Metric.Type,
+				},
+				"groupBy": map[string]string{
+					"type": config.Function.Parameters.Properties.GroupBy.Type,
+				},
+				"period": map[string]string{
+					"type": config.Function.Parameters.Properties.Period.Type,
+				},
+				"baselinePeriod": map[string]string{
+					"type": config.Function.Parameters.Properties.BaselinePeriod.Type,
+				},
+			}
+		default:
+			return nil, fmt.Errorf("tools json defines unknown function '%s'", config.Function.Name)
+		}
+
+		// Tools json files predating stored results don't define dataRef
+		dataRef := config.Function.Parameters.Properties.DataRef
+		if config.Function.Name != tools.LookUpFuncName && dataRef.Type != "" {
+			propertiesMap["dataRef"] = map[string]string{"type": dataRef.Type}
+		}
+
+		// Nor do the ones predating reports define reportName
+		reportName := config.Function.Parameters.Properties.ReportName
+		if config.Function.Name == tools.VisualizeFuncName && reportName.Type != "" {
+			propertiesMap["reportName"] = map[string]string{"type": reportName.Type}
+		}
+
+		// Nor the ones predating chart themes define theme
+		theme := config.Function.Parameters.Properties.Theme
+		if config.Function.Name == tools.VisualizeFuncName && theme.Type != "" {
+			propertiesMap["theme"] = map[string]string{"type": theme.Type}
+		}
+
+		// Add each config as a param
+		openaiToolParam = append(openaiToolParam, openai.ChatCompletionToolParam{
+			Type: openai.F(openai.ChatCompletionToolType(config.Type)),
+			Function: openai.F(openai.FunctionDefinitionParam{
+				Name:        openai.String(config.Function.Name),
+				Description: openai.String(config.Function.Description),
+				Parameters: openai.F(openai.FunctionParameters{
+					"type":       config.Function.Parameters.Type,
+					"properties": propertiesMap,
+					"required":   config.Function.Parameters.Required,
+				}),
+			}),
+		})
+	}
+
+	return openaiToolParam, nil
+}
+
+// Write the numbers of a final answer consistently, amounts of monetary columns with a currency symbol
+func (a *Agent) formatNumbers(toolbox *tools.Toolbox, answer string) string {
+	if a.config.RawNumbers {
+		return answer
+	}
+
+	locale := a.config.NumberLocale
+	if locale == "" {
+		locale = toolbox.Language()
+	}
+
+	return render.FormatNumbers(answer, render.NumberPolicy{
+		Locale:            locale,
+		MaxDecimals:       a.config.NumberDecimals,
+		MonetaryColumns:   tools.MonetaryColumns(),
+		IdentifierColumns: tools.IdentifierColumns(),
+	})
+}
+
+// Ask for follow up questions and append them to the answer.
+// Failures are only logged, the answer is returned unchanged
+func appendFollowUps(agentCtx context.Context, toolbox *tools.Toolbox, question string, result RunResult) RunResult {
+	followUps, err := toolbox.SuggestFollowUps(agentCtx, question, result.Answer)
+	if err != nil {
+		log.Printf("WARNING: Failed to suggest follow ups: %s\n", err)
+		return result
+	}
+
+	if len(followUps) == 0 {
+		return result
+	}
+
+	result.FollowUps = followUps
+	header := language.Message(toolbox.Language(), language.FollowUpsHeader)
+	result.Answer = fmt.Sprintf("%s\n\n%s\n- %s", result.Answer, header, strings.Join(followUps, "\n- "))
+	return result
+}
+
+// Append the note telling the answer was made on a sample of the dataset, in the language of the run
+func appendSampleFooter(answer string, sample string, code string) string {
+	return fmt.Sprintf("%s\n\n%s", answer, fmt.Sprintf(language.Message(code, language.SampledAnswer), sample))
+}
+
+/*
+-------------------
+Main Agent function
+-------------------
+*/
+
+// Config with its default paths filled, and its relative paths resolved against the workspace root
+func (c Config) withPaths() Config {
+	c.DataPath = config.ResolvePath(cmp.Or(c.DataPath, tools.DefaultDataPath))
+	c.ToolsJsonPath = config.ResolvePath(cmp.Or(c.ToolsJsonPath, tools.DefaultToolsJsonPath))
+	c.OutputDir = config.ResolvePath(cmp.Or(c.OutputDir, "runs"))
+	c.DatabasePath = config.ResolvePath(cmp.Or(c.DatabasePath, filepath.Join(c.OutputDir, "data.db")))
+	c.AuditPath = config.ResolvePath(cmp.Or(c.AuditPath, filepath.Join(c.OutputDir, "audit.jsonl")))
+	c.ReportsDir = config.ResolvePath(cmp.Or(c.ReportsDir, filepath.Join(c.OutputDir, "reports")))
+	c.ThemesDir = config.ResolvePath(cmp.Or(c.ThemesDir, tools.DefaultThemesDir))
+	c.FineTunePath = config.ResolvePath(c.FineTunePath)
+	c.AnalysesIndexPath = config.ResolvePath(c.AnalysesIndexPath)
+	return c
+}
+
+// Normalize a model and check it's on the completion model registry, like New does for the configured ones
+func ValidateModel(model string, allowUnknown bool) (string, error) {
+	model, err := completion.ValidateModel(model, allowUnknown)
+	return model, unknownModelError(err)
+}
+
+// Point unknown model errors at the flag allowing them
+func unknownModelError(err error) error {
+	var unknown *completion.ErrUnknownModel
+	if errors.As(err, &unknown) {
+		return fmt.Errorf("%w. Pass -allow-unknown-model to use it anyway", err)
+	}
+	return err
+}
+
+// Create an agent from its configuration. Loads the tools config, opens the database
+// and validates the dataset, so misconfiguration fails before any LLM call.
+// Failures are exitcode.ClassConfig errors unless they have a class, like dataset ones.
+// Close must be called once the agent is no longer needed
+func New(config Config) (*Agent, error) {
+	agent, err := newAgent(config)
+	return agent, exitcode.Default(exitcode.ClassConfig, err)
+}
+
+// Create an agent, see New
+func newAgent(config Config) (*Agent, error) {
+	if config.Model == "" {
+		config.Model = tools.DefaultModel
+	}
+	config = config.withPaths()
+	config.ToolTimeouts = config.ToolTimeouts.WithDefaults()
+	if config.MaxIterations <= 0 {
+		config.MaxIterations = DefaultMaxIterations
+	}
+	if config.DegradeFloor <= 0 {
+		config.DegradeFloor = DefaultDegradeFloor
+	}
+
+	// A typo in a model name fails here instead of with a 404 on the first completion
+	var err error
+	config.Model, err = ValidateModel(config.Model, config.AllowUnknownModels)
+	if err != nil {
+		return nil, err
+	}
+	config.ToolModels, err = config.ToolModels.Validate(config.AllowUnknownModels)
+	if err != nil {
+		return nil, unknownModelError(err)
+	}
+	if config.MaxArgumentBytes <= 0 {
+		config.MaxArgumentBytes = completion.ContextBytes(config.Model, argumentContextPercent)
+	}
+
+	if err := tools.ValidateDataPath(config.DataPath); err != nil {
+		return nil, err
+	}
+	if err := tools.ValidateToolsPath(config.ToolsJsonPath); err != nil {
+		return nil, err
+	}
+
+	toolsAllowlistEmpty := len(config.Tools) == 0
+	config.Tools, err = resolveToolAllowlist(config.Tools)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Language, err = language.Normalize(config.Language)
+	if err != nil {
+		return nil, err
+	}
+	config.Verbosity, err = prompts.NormalizeVerbosity(config.Verbosity)
+	if err != nil {
+		return nil, err
+	}
+	config.DateOrder, err = tools.ParseDateOrder(string(config.DateOrder))
+	if err != nil {
+		return nil, err
+	}
+	config.Ungrounded, err = normalizeUngroundedPolicy(config.Ungrounded)
+	if err != nil {
+		return nil, err
+	}
+	config.DBSizeAction, err = normalizeDBSizeAction(config.DBSizeAction)
+	if err != nil {
+		return nil, err
+	}
+	injectionPatterns, err := compileInjectionPatterns(config.InjectionPatterns)
+	if err != nil {
+		return nil, err
+	}
+	if config.MaxDBSizeMB < 0 {
+		return nil, fmt.Errorf("invalid database size cap of %d MB", config.MaxDBSizeMB)
+	}
+	chartTheme, err := tools.LoadChartTheme(config.ThemesDir, config.ChartTheme)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Sample.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.ScanGuard.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := MergeTags(nil, config.Tags); err != nil {
+		return nil, err
+	}
+	if config.Production && !config.ReadOnly {
+		return nil, fmt.Errorf("production deployments must open the database read-only, pass -read-only")
+	}
+	if !config.Sample.IsZero() && tools.IsLiveDatabase(config.DataPath) {
+		return nil, fmt.Errorf("live databases are queried in place, they can't be sampled")
+	}
+
+	if config.Publisher == nil {
+		config.Publisher, err = tools.NewPublisherFromEnv()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Sampled figures published next to production ones would be mistaken for them
+	if config.Publisher != nil && !config.Sample.IsZero() && !config.SampledExport {
+		return nil, fmt.Errorf("refusing to publish the artifacts of runs on a %s sample of the dataset, allow sampled exports (-allow-sampled-export) to publish them anyway", config.Sample)
+	}
+
+	// Past analyses are only searched with an index to search
+	pastAnalyses, allowed, err := newAnalysisIndex(config, toolsAllowlistEmpty)
+	if err != nil {
+		return nil, err
+	}
+	config.Tools = allowed
+
+	toolSet, err := loadToolSet(config.ToolsJsonPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// A missing API key fails here instead of on the first completion
+	completer := config.Completer
+	if completer == nil {
+		// Agents with their own transport settings get their own connection pool
+		client, err := completion.GetOpenaiClient()
+		if !config.HTTP.IsZero() {
+			client, err = completion.NewOpenaiClient(config.HTTP)
+		}
+		if err != nil {
+			return nil, err
+		}
+		completer = client.Chat.Completions
+
+		// Claude models go to the Anthropic backend. Its API key is only required when a configured model needs it,
+		// when set it also serves models picked later, like with WithModel
+		usesClaude := slices.ContainsFunc(append(config.ToolModels.Models(), config.Model), anthropic.IsModel)
+		if usesClaude || os.Getenv(anthropic.APIKeyEnv) != "" {
+			anthropicCompleter, err := anthropic.NewCompleter()
+			if err != nil {
+				return nil, err
+			}
+			completer = tools.NewModelRouter(completer, map[string]tools.ChatCompleter{anthropic.ModelPrefix: anthropicCompleter})
+		}
+	}
+
+	// Completions wait for the organization budgets instead of failing on them. The environment ones are
+	// shared by every agent of the process
+	limiter := completion.NewRateLimiter(config.RateLimits)
+	if limiter == nil {
+		limiter, err = completion.SharedRateLimiter()
+		if err != nil {
+			return nil, err
+		}
+	}
+	completer = tools.NewRateLimitedCompleter(completer, limiter)
+
+	// Every completion of a run carries its end user, for abuse monitoring
+	completer = tools.NewEndUserCompleter(completer)
+
+	// Runs artifacts, the audit log and the database live under the output directory
+	if err := os.MkdirAll(config.OutputDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// Bootstrap the database so invalid tables or views fail before the run
+	store, refreshed, err := tools.OpenStore(config.StorageBackend, config.DatabasePath, config.DataPath, config.Sample, config.ForceRefresh, config.ReadOnly)
+	if err != nil {
+		return nil, exitcode.Default(exitcode.ClassData, err)
+	}
+	if store.Backend() != tools.StorageDuckDB {
+		log.Printf("WARNING: Using the %s storage backend, lookups and reports can't run SQL\n", store.Backend())
+	}
+
+	if !config.SkipDatasetCheck {
+		if err := tools.ValidateDataset(store, config.DataPath); err != nil {
+			store.Close()
+			return nil, exitcode.Default(exitcode.ClassData, err)
+		}
+	}
+
+	// Live databases bring their own tables, list them for the model instead of the views.
+	// Only DuckDB opens them, so the store has SQL
+	liveTables := []string{}
+	if tools.IsLiveDatabase(config.DataPath) {
+		db, _ := store.SQL()
+		liveTables, err = tools.DiscoverTables(context.Background(), db)
+		if err != nil {
+			store.Close()
+			return nil, exitcode.Default(exitcode.ClassData, err)
+		}
+		log.Printf("Discovered %d tables on the live database\n", len(liveTables))
+	}
+
+	agent := &Agent{
+		config:     config,
+		completer:  completer,
+		store:      store,
+		tracer:     config.Tracer,
+		audit:      tools.NewAuditLog(config.AuditPath, !config.DisableAudit),
+		toolSet:    toolSet,
+		liveTables: liveTables,
+		warmup:     &warmupState{},
+		dbMutex:    &sync.RWMutex{},
+		limiter:    limiter,
+		chartTheme: chartTheme,
+
+		pastAnalyses:      pastAnalyses,
+		injectionPatterns: injectionPatterns,
+		data:              &dataState{},
+		live:              &liveRuns{runs: map[string]*liveRun{}},
+	}
+	agent.data.pendingRefresh.Store(refreshed)
+
+	// Only columns the dataset has can be filtered on
+	agent.sensitiveColumns, err = tools.ResolveSensitiveColumns(context.Background(), store, config.SensitiveColumns)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	agent.entityGuardBypass = entityGuardAllowed(config.Principal)
+
+	// Live databases bring their own tables, only the configured terms apply to them
+	glossaryTerms := config.Glossary
+	if !tools.IsLiveDatabase(config.DataPath) {
+		glossaryTerms = append(tools.DatasetGlossary(), config.Glossary...)
+	}
+	agent.glossary, err = tools.NewGlossary(context.Background(), store, glossaryTerms)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	// Same for units, where a wrong unit only warns since the data still loads
+	columnUnits := config.ColumnUnits
+	if !tools.IsLiveDatabase(config.DataPath) {
+		columnUnits = append(tools.DatasetUnits(), config.ColumnUnits...)
+	}
+	agent.units, err = tools.NewColumnUnits(context.Background(), store, columnUnits)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	if config.Deterministic {
+		if config.Seed == 0 {
+			agent.config.Seed = tools.DefaultSeed
+		}
+		agent.completer = tools.NewDeterministicCompleter(agent.completer, agent.config.Seed)
+	} else {
+		// Only seeds completions used are recorded, re-runs of other transcripts pick their own
+		agent.config.Seed = 0
+	}
+	if agent.tracer == nil {
+		agent.tracer = tracing.NewNoopTracer()
+	}
+
+	// Cached profiles make this instant after the first run on a data file
+	if config.ProfilePrompt {
+		profile, err := agent.Profile(context.Background(), false)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		agent.data.profile = profilePrompt + profile.Markdown()
+	}
+
+	// Opened last, so a failing New leaves no writer running
+	if config.FineTunePath != "" {
+		agent.fineTune, err = tools.NewFineTuneCapture(config.FineTunePath, config.FineTuneSample)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+
+	return agent, nil
+}
+
+// Derive an agent using another chat model. It shares the database bootstrap,
+// client, tracer and audit log of a. Closing it is a no-op, close a instead
+func (a *Agent) WithModel(model string) *Agent {
+	derived := a.derive()
+	derived.config.Model = model
+
+	return derived
+}
+
+// Derive an agent with other tool timeouts, for runs known to need more or less time.
+// Zero values keep the timeouts of a. Closing it is a no-op, close a instead
+func (a *Agent) WithToolTimeouts(timeouts tools.ToolTimeouts) *Agent {
+	derived := a.derive()
+	if timeouts.Lookup > 0 {
+		derived.config.ToolTimeouts.Lookup = timeouts.Lookup
+	}
+	if timeouts.Analyze > 0 {
+		derived.config.ToolTimeouts.Analyze = timeouts.Analyze
+	}
+	if timeouts.Visualize > 0 {
+		derived.config.ToolTimeouts.Visualize = timeouts.Visualize
+	}
+
+	return derived
+}
+
+// Derive an agent answering at another verbosity level, for requests from another audience.
+// Closing it is a no-op, close a instead
+func (a *Agent) WithVerbosity(level string) (*Agent, error) {
+	level, err := prompts.NormalizeVerbosity(level)
+	if err != nil {
+		return nil, err
+	}
+
+	derived := a.derive()
+	derived.config.Verbosity = level
+	return derived, nil
+}
+
+// Derive an agent appending the provenance of its answers, like for a server request asking how it was answered.
+// Closing it is a no-op, close a instead
+func (a *Agent) WithExplain() *Agent {
+	derived := a.derive()
+	derived.config.ExplainAnswer = true
+
+	return derived
+}
+
+// Derive an agent running for another principal and role, like the one of a server request resolved with
+// Config.Access.RoleForRequest. Its completions are attributed to the principal as their end user.
+// Closing it is a no-op, close a instead
+func (a *Agent) WithPrincipal(principal string, role string) *Agent {
+	derived := a.derive()
+	derived.config.Principal = principal
+	derived.config.Role = role
+	derived.config.User = principal
+	derived.entityGuardBypass = entityGuardAllowed(principal)
+
+	return derived
+}
+
+// Directory holding the run directories of the agent
+func (a *Agent) OutputDir() string {
+	return a.config.OutputDir
+}
+
+// Roles of the API keys and roles allowed per dataset, nil without an access config
+func (a *Agent) Access() *tools.AccessPolicy {
+	return a.config.Access
+}
+
+// Copy of the agent sharing its database, client, tracer, audit log and tools
+func (a *Agent) derive() *Agent {
+	return &Agent{
+		config:     a.config,
+		completer:  a.completer,
+		store:      a.store,
+		tracer:     a.tracer,
+		audit:      a.audit,
+		toolSet:    a.toolSet,
+		liveTables: a.liveTables,
+		sharedDB:   true,
+		warmup:     a.warmup,
+		dbMutex:    a.dbMutex,
+		limiter:    a.limiter,
+		fineTune:   a.fineTune,
+		chartTheme: a.chartTheme,
+
+		pastAnalyses:      a.pastAnalyses,
+		sensitiveColumns:  a.sensitiveColumns,
+		glossary:          a.glossary,
+		units:             a.units,
+		entityGuardBypass: a.entityGuardBypass,
+		injectionPatterns: a.injectionPatterns,
+		data:              a.data,
+		live:              a.live,
+	}
+}
+
+// Judge which candidate answers the question best, using the agent's chat model
+func (a *Agent) Judge(ctx context.Context, question string, candidates []tools.JudgeCandidate) (tools.Judgement, error) {
+	completer := tools.NewRequestRecorder(a.completer)
+	toolbox := tools.NewToolbox(tools.Config{Model: a.config.Model}, completer, a.store, a.tracer, a.audit)
+	return toolbox.JudgeAnswers(ctx, question, candidates)
+}
+
+// Release the agent's dataset store. The tracer is left to its owner
+func (a *Agent) Close() error {
+	if a.sharedDB {
+		return nil
+	}
+
+	// Pending fine tuning records are written before the process exits
+	a.fineTune.Close()
+
+	log.Println("Closing database")
+	return a.store.Close()
+}
+
+// Reload the dataset and its views, for when the underlying parquet file changes.
+// Waits for the runs going on, like a reload of a changed data file
+func (a *Agent) RefreshData() error {
+	a.dbMutex.Lock()
+	defer a.dbMutex.Unlock()
+
+	if err := a.store.Refresh(a.config.DataPath); err != nil {
+		return err
+	}
+
+	a.data.pendingRefresh.Store(true)
+	return nil
+}
+
+// Run the agent on a user prompt. Cancelling ctx stops the run
+func (a *Agent) Run(ctx context.Context, prompt string) (RunResult, error) {
+	return a.run(ctx, formatAgentMessages(prompt, a.config.MergeSystemPrompt), runOptions{})
+}
+
+// Run the agent on an ongoing conversation. A system message is added if none is present
+func (a *Agent) RunMessages(ctx context.Context, messages []openai.ChatCompletionMessageParamUnion) (RunResult, error) {
+	return a.run(ctx, formatAgentMessages(messages, a.config.MergeSystemPrompt), runOptions{})
+}
+
+// Optional behavior of a run
+type runOptions struct {
+	plan   *tools.Plan  // Plan the run was hinted with
+	resume *resumeState // Transcript the run continues
+}
+
+// Start the main span surrounding the agent run, then run the router loop
+func (a *Agent) run(
+	ctx context.Context,
+	openaiMessages []openai.ChatCompletionMessageParamUnion,
+	options runOptions,
+) (RunResult, error) {
+	// Resumed runs get a new span tree, linked to the trace of the original run
+	spanOptions := []trace.SpanStartOption{}
+	if options.resume != nil && options.resume.transcript.TraceID != "" {
+		link, err := tracing.LinkTrace(options.resume.transcript.TraceID)
+		if err != nil {
+			log.Printf("WARNING: Not linking resumed run: %s\n", err)
+		} else {
+			spanOptions = append(spanOptions, link)
+		}
+	}
+
+	// Long lived agents pick up data file changes before the run reads the table
+	dataChange := tools.DataChange{}
+	if a.config.WatchData {
+		dataChange = a.reloadDataIfChanged(ctx)
+	}
+
+	// The database isn't vacuumed under the run
+	a.dbMutex.RLock()
+	defer a.dbMutex.RUnlock()
+
+	// Every child span context derives from ctx, so cancelling it stops the whole run
+	agentCtx, span := a.tracer.StartOpenInferenceSpan(ctx, "AgentRun", tracing.AgentKind, spanOptions...)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	// Steps add their durations to the run timings through the context
+	runStart := time.Now()
+	timings := tools.NewTimings()
+	agentCtx = tools.WithTimings(agentCtx, timings)
+
+	// Completions and audit entries of the run are attributed to its end user
+	agentCtx = completion.WithEndUser(agentCtx, a.config.User)
+	if user := completion.EndUserFrom(agentCtx); user != "" {
+		tracing.SetSpanAttr(span, "user.id", user)
+	}
+
+	if a.config.Role != "" {
+		tracing.SetSpanAttr(span, "agent.role", a.config.Role)
+	}
+	if !a.config.Sample.IsZero() {
+		tracing.SetSpanAttr(span, "agent.sample", a.config.Sample.String())
+	}
+	tags := a.runTags()
+	tracing.SetSpanAttrFromMap(span, tagAttributes(tags))
+	if dataChange.Reloaded {
+		openaiMessages = a.withDataChangeNotice(span, openaiMessages, dataChange)
+	}
+
+	prompt := lastUserQuestion(openaiMessages)
+	tracing.SetSpanInput(span, prompt)
+	tracing.SetSpanAttr(span, "agent.tools", a.config.Tools)
+	tracing.SetSpanModel(span, a.config.Model)
+
+	// Answer in the language of the user unless one was configured
+	runLanguage := a.config.Language
+	if runLanguage == "" {
+		runLanguage = language.Detect(prompt)
+	}
+	tracing.SetSpanAttr(span, "agent.language", runLanguage)
+	openaiMessages = withLanguageInstruction(openaiMessages, runLanguage)
+	tracing.SetSpanAttr(span, "agent.verbosity", a.config.Verbosity)
+
+	// Runs close to their deadline answer without the optional steps
+	degraded := a.degradedSteps(ctx)
+	if len(degraded) != 0 {
+		log.Printf("WARNING: Run deadline is less than %s away, skipping %s\n", a.config.DegradeFloor, strings.Join(degraded, ", "))
+		tracing.SetSpanAttr(span, "agent.degraded", degraded)
+	}
+	if slices.Contains(degraded, DegradedCharts) {
+		openaiMessages = withSystemMessage(openaiMessages, degradedChartsPrompt)
+	}
+	openaiMessages = withSystemMessage(openaiMessages, prompts.RenderVerbosityInstruction(a.config.Verbosity))
+	openaiMessages = withSystemMessage(openaiMessages, a.datasetDescriptionPrompt())
+	openaiMessages = withSystemMessage(openaiMessages, a.data.profile)
+	if len(a.glossary.Terms()) != 0 {
+		openaiMessages = withSystemMessage(openaiMessages, prompts.RenderGlossary(prompts.GlossaryRequest{Terms: a.glossary.Description()}))
+	}
+
+	// Long lived agents pick up tools json edits without a restart
+	if a.config.ReloadTools {
+		a.reloadToolsIfChanged()
+		tracing.SetSpanAttr(span, "agent.tools_reloads", a.ToolsReloads())
+	}
+
+	// Each run gets its own tools, token usage count, request records and working directory for the run artifacts
+	requests := tools.NewRequestRecorder(a.completer)
+	completer := tools.NewUsageCounter(requests)
+	toolbox := tools.NewToolbox(
+		tools.Config{
+			Model:        a.config.Model,
+			ToolModels:   a.config.ToolModels,
+			DataPath:     a.config.DataPath,
+			OutputDir:    a.config.OutputDir,
+			DatabasePath: a.config.DatabasePath,
+			ReportsDir:   a.config.ReportsDir,
+			ThemesDir:    a.config.ThemesDir,
+			ChartTheme:   a.chartTheme,
+			// Only the first run after a reload reports it
+			DataRefreshed:    a.data.pendingRefresh.Swap(false),
+			ExplainQueries:   a.config.ExplainQueries,
+			ScanGuard:        a.config.ScanGuard,
+			GroundedAnalysis: a.config.GroundedAnalysis,
+			ToolTimeouts:     a.config.ToolTimeouts,
+			LiveTables:       a.liveTables,
+			Glossary:         a.glossary,
+			Units:            a.units,
+			Language:         runLanguage,
+			Verbosity:        a.config.Verbosity,
+			DateOrder:        a.config.DateOrder,
+			Today:            a.config.Today,
+			Publisher:        a.config.Publisher,
+			Principal:        a.config.Principal,
+			Role:             a.config.Role,
+			Access:           a.config.Access,
+			Tags:             tags,
+			FineTune:         a.fineTune,
+			ConfirmSQL:       a.config.ConfirmSQL,
+			PastAnalyses:     a.pastAnalyses,
+		},
+		completer,
+		a.store,
+		a.tracer,
+		a.audit,
+	)
+
+	runID, err := a.startRun(agentCtx, toolbox, openaiMessages, options.resume)
+	if err != nil {
+		tracing.SetSpanErrorCode(span)
+		return RunResult{}, err
+	}
+	// Stored results are only referenced by the run that made them
+	defer toolbox.ReleaseResults()
+	// Temporary tables outlive a cancelled run context, they're dropped regardless
+	defer toolbox.DropTempTables(context.WithoutCancel(agentCtx))
+
+	initialResult := RunResult{
+		RunID:         runID,
+		RunDir:        toolbox.RunDir,
+		TraceID:       tracing.TraceID(agentCtx),
+		Model:         a.config.Model,
+		Language:      runLanguage,
+		Verbosity:     a.config.Verbosity,
+		Sample:        a.config.Sample.String(),
+		Tags:          tags,
+		Degraded:      degraded,
+		Prompt:        prompt,
+		Tools:         a.config.Tools,
+		Deterministic: a.config.Deterministic,
+		Seed:          a.config.Seed,
+		ResumedFrom:   resumedTraceID(options.resume),
+		Queries:       resumedQueries(options.resume, nil),
+		Interim:       resumedInterim(options.resume),
+	}
+
+	// Interrupts write the transcript of the run from its latest checkpoint, see AbortLiveRuns
+	a.live.start(initialResult, openaiMessages)
+	defer a.live.end(runID)
+
+	// Questions about individuals are refused before any completion is made
+	refused, err := a.guardEntities(agentCtx, toolbox, openaiMessages, initialResult)
+	if err != nil {
+		tracing.SetSpanErrorCode(span)
+		return initialResult, err
+	}
+	if refused != nil {
+		tracing.SetSpanOutput(span, refused.Answer)
+		tracing.SetSpanAttr(span, "agent.run_id", refused.RunID)
+		tracing.SetSpanRefusedCode(span)
+		return *refused, nil
+	}
+
+	result, err := a.routerLoop(agentCtx, completer, toolbox, openaiMessages, initialResult)
+
+	result.Usage = completer.Usage()
+	result.Completions = requests.Calls()
+	result.Retrieval = toolbox.Retrieval()
+	result.Queries = resumedQueries(options.resume, toolbox.Queries())
+	timings.Since(tools.TimingTotal, runStart)
+	result.Timings = timings.Summary()
+
+	// Built from the run records, so it can't be misremembered like a model written explanation
+	if a.config.ExplainAnswer && result.Completed {
+		provenance := NewProvenance(result)
+		result.Provenance = &provenance
+		result.Answer = fmt.Sprintf("%s\n\n%s", result.Answer, provenance.Markdown())
+	}
+
+	// Figures of a sample don't hold for the whole data, say so last
+	if result.Sample != "" && result.Completed {
+		result.Answer = appendSampleFooter(result.Answer, result.Sample, toolbox.Language())
+	}
+
+	// Set span output and status code
+	tracing.SetSpanOutput(span, result.Answer)
+	tracing.SetSpanAttr(span, "agent.run_id", result.RunID)
+	tracing.SetSpanAttr(span, "agent.artifacts", result.Artifacts)
+	if result.Ungrounded {
+		tracing.SetSpanAttrFromMap(span, map[string]any{
+			"agent.ungrounded":        true,
+			"agent.ungrounded_action": result.UngroundedAction,
+		})
+	}
+	tracing.SetSpanAttrFromMap(span, map[string]any{
+		"agent.iterations":       result.Iterations,
+		"retrieval.row_count":    result.Retrieval.RowsReturned,
+		"retrieval.bytes":        result.Retrieval.Bytes,
+		"retrieval.rows_scanned": result.Retrieval.RowsScanned,
+	})
+
+	if options.plan != nil {
+		divergence := planDivergence(*options.plan, result.Messages)
+		result.PlanDivergence = &divergence
+		tracing.SetSpanAttrFromMap(span, map[string]any{
+			"agent.plan.planned_tools": divergence.Planned,
+			"agent.plan.actual_tools":  divergence.Actual,
+			"agent.plan.divergence":    divergence.Distance,
+		})
+	}
+
+	if err != nil {
+		tracing.SetSpanErrorCode(span)
+		return result, err
+	}
+
+	// Later runs can search what this one answered
+	a.indexAnalysis(agentCtx, result)
+
+	tracing.SetSpanSuccessCode(span)
+	return result, nil
+}
+
+// Make router calls until the model answers without tool calls
+func (a *Agent) routerLoop(
+	agentCtx context.Context,
+	completer tools.ChatCompleter,
+	toolbox *tools.Toolbox,
+	openaiMessages []openai.ChatCompletionMessageParamUnion,
+	result RunResult,
+) (RunResult, error) {
+	toolParams := a.withDatasetDescription(a.toolParams())
+	if slices.Contains(result.Degraded, DegradedCharts) {
+		toolParams = slices.DeleteFunc(slices.Clone(toolParams), isVisualizeParam)
+	}
+
+	// Keep the transcript current, so a run dying midway can be resumed
+	checkpoint := func(messages []openai.ChatCompletionMessageParamUnion) {
+		checkpointResult := result
+		checkpointResult.Artifacts = toolbox.Artifacts()
+		checkpointResult.Queries = append(slices.Clone(result.Queries), toolbox.Queries()...)
+		checkpointResult.Messages = messages
+		if err := a.live.checkpoint(checkpointResult); err != nil {
+			log.Printf("WARNING: Failed to checkpoint transcript: %s\n", err)
+		}
+	}
+
+	for {
+		// A router that never stops calling tools would loop for as long as the budget lasts
+		if result.Iterations >= a.config.MaxIterations {
+			log.Printf("WARNING: Aborting run after %d router calls without a final answer\n", result.Iterations)
+			result.Artifacts = toolbox.Artifacts()
+			result.ArtifactURLs = toolbox.ArtifactURLs()
+			result.Messages = openaiMessages
+			return result, &ErrMaxIterations{Limit: a.config.MaxIterations}
+		}
+		result.Iterations++
+
+		log.Println("Making router call for OpenAI and starting new span")
+
+		// Manually start span as child of the Agent span
+		ctx, span := a.tracer.StartOpenInferenceSpan(agentCtx, "RouterCall", tracing.ChainKind)
+		defer tracing.EndOpenInferenceSpan(span)
+
+		// A dropped or repeated tool result would fail the request with an opaque tool_call_id error
+		var repair PairingRepair
+		openaiMessages, repair = repairToolPairing(openaiMessages)
+		if !repair.Empty() {
+			log.Printf("WARNING: Repaired tool results before the router call: %s\n", repair)
+			tracing.SetSpanAttrFromMap(span, repair.attributes())
+			checkpoint(openaiMessages)
+		}
+
+		// Big data prompts are cut to a preview, the llm span keeps the hash of the whole conversation
+		inputMessage, _ := llmdebug.Truncate(openai.F(openaiMessages[0]).String(), tracing.PreviewChars())
+		tracing.SetSpanInput(span, inputMessage)
+
+		// Start OpenAI manual tracing
+		llmCtx, llmSpan := a.tracer.StartOpenAISpan(ctx, a.config.Model)
+		defer llmSpan.End()
+
+		// Add input attributes to llm span
+		tracing.SetSpanInputMessages(llmSpan, openaiMessages)
+
+		routerStart := time.Now()
+		response, err := completer.New(
+			llmCtx,
+			openai.ChatCompletionNewParams{
+				Model:     openai.F(a.config.Model),
+				Messages:  openai.F(openaiMessages),
+				Tools:     openai.F(toolParams),
+				MaxTokens: openai.Int(1000),
+			},
+		)
+		tools.TimingsFrom(ctx).Since(tools.TimingRouter, routerStart)
+
+		if err != nil {
+			tracing.SetSpanErrorCode(llmSpan)
+			tracing.SetSpanErrorCode(span)
+			result.Artifacts = toolbox.Artifacts()
+			result.ArtifactURLs = toolbox.ArtifactURLs()
+			result.Messages = openaiMessages
+			return result, err
+		}
+
+		// Refusals and filtered outputs end the run, there is nothing to route
+		responseMessage, err := completion.Message(response)
+		if err != nil {
+			tracing.SetSpanErrorCode(llmSpan)
+			tracing.SetSpanErrorCode(span)
+			result.Artifacts = toolbox.Artifacts()
+			result.ArtifactURLs = toolbox.ArtifactURLs()
+			result.Messages = openaiMessages
+			return result, err
+		}
+
+		// Add the response to a tool call message, needed for next steps
+		openaiMessages = append(openaiMessages, responseMessage)
+		toolCalls := responseMessage.ToolCalls
+
+		// Content sent along tool calls explains the next step, keep it apart from the answer
+		if interim, ok := interimContent(responseMessage, result.Iterations); ok {
+			log.Printf("Router note before calling %s: %s\n", strings.Join(interim.ToolCalls, ", "), interim.Content)
+			tracing.SetSpanAttr(span, "agent.interim_content", interim.Content)
+			result.Interim = append(result.Interim, interim)
+		}
+		checkpoint(openaiMessages)
+
+		rawJsonToolCalls := []string{}
+		for _, toolCall := range toolCalls {
+			rawJsonToolCalls = append(rawJsonToolCalls, toolCall.JSON.RawJSON())
+		}
+
+		// Add output attributes to llm span
+		tracing.SetSpanAttrFromMap(llmSpan, map[string]any{
+			"llm.token_count.prompt":     response.Usage.PromptTokens,
+			"llm.token_count.completion": response.Usage.CompletionTokens,
+			"llm.token_count.total":      response.Usage.TotalTokens,
+			"llm.tools":                  rawJsonToolCalls,
+		})
+		tracing.SetSpanOutputMessages(llmSpan, []openai.ChatCompletionMessage{responseMessage})
+
+		// Set span as successful
+		tracing.SetSpanSuccessCode(span)
+		tracing.SetSpanSuccessCode(llmSpan)
+
+		if len(toolCalls) != 0 {
+			log.Println("Processing tool calls ...")
+			result.ToolCalls += len(toolCalls)
+			tracing.SetSpanOutput(span, rawJsonToolCalls)
+			openaiMessages, err = a.handleToolCalls(ctx, toolbox, toolCalls, openaiMessages, checkpoint)
+			if err != nil {
+				// Non retryable tool failures end the run instead of looping on them
+				tracing.SetSpanErrorCode(span)
+				result.Artifacts = toolbox.Artifacts()
+				result.ArtifactURLs = toolbox.ArtifactURLs()
+				result.Messages = openaiMessages
+				return result, err
+			}
+		} else if strings.TrimSpace(responseMessage.Content) == "" {
+			// Nothing to answer with nor route, calling again would likely repeat it
+			tracing.SetSpanErrorCode(span)
+			result.Artifacts = toolbox.Artifacts()
+			result.ArtifactURLs = toolbox.ArtifactURLs()
+			result.Messages = openaiMessages
+			return result, &completion.ErrEmptyMessage{}
+		} else {
+			// Figures stated without looking up data are likely made up
+			ungrounded := a.config.Ungrounded != UngroundedOff && isUngrounded(responseMessage.Content, openaiMessages)
+			if ungrounded && a.config.Ungrounded == UngroundedRetry && result.UngroundedAction == "" &&
+				slices.Contains(a.config.Tools, tools.LookUpFuncName) && !slices.Contains(result.Degraded, DegradedUngroundedRetry) {
+				log.Println("WARNING: Answer has figures but no data was looked up, asking the router to verify them")
+				tracing.SetSpanAttr(span, "agent.ungrounded", true)
+				result.Ungrounded = true
+				result.UngroundedAction = UngroundedRetried
+				openaiMessages = append(openaiMessages, openai.SystemMessage(ungroundedRetryPrompt))
+				checkpoint(openaiMessages)
+				continue
+			}
+
+			log.Println("No tool calls, returning final answer")
+			tracing.SetSpanOutput(span, responseMessage.Content)
+			result.Answer = a.formatNumbers(toolbox, responseMessage.Content)
+			result.Completed = true
+			if ungrounded {
+				log.Println("WARNING: Answer has figures but no data was looked up, warning about it")
+				result.Ungrounded = true
+				result.Answer = appendUngroundedWarning(result.Answer, toolbox.Language())
+				if result.UngroundedAction == UngroundedRetried {
+					result.UngroundedAction = UngroundedRetriedWarned
+				} else {
+					result.UngroundedAction = UngroundedWarned
+				}
+			}
+			if a.config.SuggestFollowUps && !slices.Contains(result.Degraded, DegradedFollowUps) {
+				result = appendFollowUps(agentCtx, toolbox, lastUserQuestion(openaiMessages), result)
+			}
+			result.Artifacts = toolbox.Artifacts()
+			result.ArtifactURLs = toolbox.ArtifactURLs()
+			result.Messages = openaiMessages
+			return result, nil
+		}
+	}
+}
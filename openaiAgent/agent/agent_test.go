@@ -0,0 +1,431 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/history"
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/prompts"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// Absolute path of a file under the module root. Relative config paths resolve against the workspace instead
+func modulePath(t *testing.T, elem ...string) string {
+	t.Helper()
+	path, err := filepath.Abs(filepath.Join(append([]string{".."}, elem...)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Path of a parquet fixture of the sales dataset, see tools/dataset_test.go
+func datasetFixture(t *testing.T, name string) string {
+	return modulePath(t, "testdata", "datasets", name)
+}
+
+// Create an agent on a dataset fixture replying with completer, with its artifacts in a temp dir.
+// Fields set on config are kept
+func newTestAgent(t *testing.T, config Config, completer tools.ChatCompleter) *Agent {
+	t.Helper()
+	if config.DataPath == "" {
+		config.DataPath = datasetFixture(t, "sales.parquet")
+	}
+	config.ToolsJsonPath = modulePath(t, tools.DefaultToolsJsonPath)
+	config.OutputDir = t.TempDir()
+	config.DisableAudit = true
+	config.Completer = completer
+
+	agent, err := New(config)
+	if err != nil {
+		t.Fatalf("failed to create agent: %s", err)
+	}
+	t.Cleanup(func() { agent.Close() })
+
+	return agent
+}
+
+/*
+---------------
+Fixture replays
+---------------
+*/
+
+// How the replay of a recorded fixture must end
+type fixtureCase struct {
+	configure  func(t *testing.T, config *Config) // Settings the fixture requests expect, like the flags its description names
+	answer     string                             // Start of the final answer, numbers may be formatted after it
+	errClass   string                             // Class of the error the run fails with, like completion.ErrorClassEmptyMessage
+	dispatches []string                           // Functions the router called, in order
+	iterations int                                // Router calls of the run
+	interim    int                                // Router calls explaining their tool calls
+}
+
+// Every fixture of testdata/fixtures, replayed by TestReplayFixtures
+var fixtureCases = map[string]fixtureCase{
+	"access_denied": {
+		configure: func(t *testing.T, config *Config) {
+			access, err := tools.LoadAccessPolicy(modulePath(t, "testdata", "access.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.Access, config.Role = access, "intern"
+		},
+		answer:     "You don't have access to the sales dataset",
+		dispatches: []string{tools.LookUpFuncName},
+		iterations: 2,
+	},
+	"analysis_fallback": {
+		answer:     "The analysis model was unavailable.",
+		dispatches: []string{tools.AnalyzeFuncName},
+		iterations: 2,
+	},
+	"analysis_refusal": {
+		errClass:   "model_refusal",
+		dispatches: []string{tools.AnalyzeFuncName},
+		iterations: 1,
+	},
+	"chart_inline_data": {
+		answer:     "Here is the chart code for the given units.",
+		dispatches: []string{tools.VisualizeFuncName},
+		iterations: 2,
+	},
+	"chart_latest_result": {
+		answer:     "Here is the chart code for units sold by store.",
+		dispatches: []string{tools.LookUpFuncName, tools.VisualizeFuncName},
+		iterations: 3,
+	},
+	"chart_result_ref": {
+		configure:  onRecordedDataset,
+		answer:     "Here is the chart code for units sold by store.",
+		dispatches: []string{tools.LookUpFuncName, tools.VisualizeFuncName},
+		iterations: 3,
+	},
+	"chart_schema_correction": {
+		configure:  onRecordedDataset,
+		answer:     "Here is the chart code for units sold by store.",
+		dispatches: []string{tools.LookUpFuncName, tools.VisualizeFuncName},
+		iterations: 3,
+	},
+	"chart_without_data": {
+		answer:     "There is no data to chart yet",
+		dispatches: []string{tools.VisualizeFuncName},
+		iterations: 2,
+	},
+	"compare_periods": {
+		answer:     "Sales changed between November and December",
+		dispatches: []string{tools.ComparePeriodsFuncName},
+		iterations: 2,
+	},
+	"empty_final_turn": {
+		errClass:   "empty_message",
+		dispatches: []string{tools.LookUpFuncName},
+		iterations: 2,
+		interim:    1,
+	},
+	"empty_response": {
+		errClass:   "empty_message",
+		iterations: 1,
+	},
+	"formatted_numbers": {
+		answer:     "The Total_Sale_Value reached",
+		dispatches: []string{tools.LookUpFuncName},
+		iterations: 2,
+	},
+	"glossary_rewrite": {
+		answer:     "Revenue per store was reported by the lookup.",
+		dispatches: []string{tools.LookUpFuncName},
+		iterations: 2,
+	},
+	"max_iterations": {
+		errClass:   "max_iterations",
+		dispatches: slices.Repeat([]string{"DropSalesTable"}, DefaultMaxIterations),
+		iterations: DefaultMaxIterations,
+	},
+	"mixed_content_tool_calls": {
+		answer:     "The lookup shows the total units sold.",
+		dispatches: []string{tools.LookUpFuncName},
+		iterations: 2,
+		interim:    1,
+	},
+	"multiple_tool_calls": {
+		answer:     "Promotions account for a share of the sales.",
+		dispatches: []string{tools.LookUpFuncName, tools.LookUpFuncName},
+		iterations: 2,
+	},
+	"past_analyses": {
+		configure: func(t *testing.T, config *Config) {
+			config.AnalysesIndexPath = seedPastAnalyses(t)
+			config.Embedder = &mock.Embedder{}
+		},
+		answer:     "A past analysis found that promotions lifted units sold",
+		dispatches: []string{tools.SearchPastAnalysesFuncName},
+		iterations: 2,
+	},
+	"single_lookup": {
+		answer:     "Total sales were reported by the lookup.",
+		dispatches: []string{tools.LookUpFuncName},
+		iterations: 2,
+	},
+	"temp_table_join": {
+		configure: func(t *testing.T, config *Config) {
+			config.StorageBackend = tools.StorageDuckDB
+		},
+		answer:     "Promoted sales make up the largest share",
+		dispatches: []string{tools.LookUpFuncName, tools.LookUpFuncName},
+		iterations: 3,
+	},
+	"tool_error_mid_run": {
+		answer:     "There is no region column, these are the top stores instead.",
+		dispatches: []string{tools.LookUpFuncName, tools.LookUpFuncName},
+		iterations: 3,
+	},
+	"ungrounded_retry": {
+		configure:  func(t *testing.T, config *Config) { config.Ungrounded = UngroundedRetry },
+		answer:     "Total sales were reported by the lookup, the earlier figure was an estimate and is withdrawn.",
+		dispatches: []string{tools.LookUpFuncName},
+		iterations: 3,
+	},
+	"ungrounded_warn": {
+		answer:     "Store 1320 sold",
+		iterations: 1,
+	},
+	"unit_scaling": {
+		configure: func(t *testing.T, config *Config) {
+			units, err := tools.ParseColumnUnits("Price_Cents=cents*0.01")
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.ColumnUnits = units
+		},
+		answer:     "Store 1320 has the highest price",
+		dispatches: []string{tools.AnalyzeFuncName},
+		iterations: 2,
+	},
+	"verbosity_brief": {
+		configure:  func(t *testing.T, config *Config) { config.Verbosity = prompts.VerbosityBrief },
+		answer:     "Store 1320 sells the most",
+		iterations: 1,
+	},
+	"verbosity_detailed": {
+		configure:  func(t *testing.T, config *Config) { config.Verbosity = prompts.VerbosityDetailed },
+		answer:     "Summary\nStore 1320 sells the most",
+		dispatches: []string{tools.AnalyzeFuncName},
+		iterations: 2,
+	},
+}
+
+// Replay on the dataset the fixture was recorded on, its dataRef is the hash of a result of that data
+func onRecordedDataset(t *testing.T, config *Config) {
+	config.DataPath = modulePath(t, tools.DefaultDataPath)
+	config.StorageBackend = tools.StorageDuckDB
+}
+
+// Write an index of past analyses for the past_analyses fixture to search, embedded by the mock embedder
+func seedPastAnalyses(t *testing.T) string {
+	t.Helper()
+	indexPath := filepath.Join(t.TempDir(), "analyses.jsonl")
+	index := tools.NewAnalysisIndex(indexPath, &mock.Embedder{}, "")
+	analyses := []tools.PastAnalysis{
+		{RunID: "fixture-promotions", Question: "What did promotions do to units sold?", Answer: "Promotions lifted units sold at every store, 18% on average."},
+		{RunID: "fixture-stores", Question: "Which store sold the most?", Answer: "Store 1320 had the highest total sales value."},
+	}
+	for i, analysis := range analyses {
+		analysis.Date = time.Date(2026, time.September, 1+i, 0, 0, 0, 0, time.UTC)
+		if err := index.Add(context.Background(), analysis); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return indexPath
+}
+
+// Temporary tables are numbered per process, tmp_r1_ on the fixtures
+var tempNamespacePattern = regexp.MustCompile(`tmp_r\d+_`)
+
+// Mock completer naming the temporary tables of its responses with the namespace the run was given,
+// so fixtures recorded as the first run of their process replay after other runs too
+type tempNamespaceCompleter struct {
+	*mock.Completer
+}
+
+func (c tempNamespaceCompleter) New(
+	ctx context.Context,
+	body openai.ChatCompletionNewParams,
+	opts ...option.RequestOption,
+) (*openai.ChatCompletion, error) {
+	response, err := c.Completer.New(ctx, body, opts...)
+	if err != nil || response == nil {
+		return response, err
+	}
+
+	messages, _ := json.Marshal(body.Messages.Value)
+	if namespace := tempNamespacePattern.Find(messages); namespace != nil {
+		for i := range response.Choices {
+			content := response.Choices[i].Message.Content
+			response.Choices[i].Message.Content = strings.ReplaceAll(content, "tmp_r1_", string(namespace))
+		}
+	}
+	return response, nil
+}
+
+func TestReplayFixtures(t *testing.T) {
+	paths, err := filepath.Glob(modulePath(t, "testdata", "fixtures", "*.json"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no fixtures found: %v", err)
+	}
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		t.Run(name, func(t *testing.T) {
+			c, ok := fixtureCases[name]
+			if !ok {
+				t.Fatalf("fixture '%s' has no case on fixtureCases", name)
+			}
+
+			completer, err := mock.LoadFixture(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := Config{}
+			if c.configure != nil {
+				c.configure(t, &config)
+			}
+			agent := newTestAgent(t, config, tempNamespaceCompleter{completer})
+
+			result, err := agent.Run(context.Background(), "Fixture question")
+			assertRunEnd(t, result, err, c)
+
+			if len(completer.Requests) != len(completer.Responses) {
+				t.Errorf("run made %d of the %d requests of the fixture", len(completer.Requests), len(completer.Responses))
+			}
+			if result.Iterations != c.iterations {
+				t.Errorf("expected %d router calls, got %d", c.iterations, result.Iterations)
+			}
+			if len(result.Interim) != c.interim {
+				t.Errorf("expected %d interim contents, got %+v", c.interim, result.Interim)
+			}
+			if dispatches := toolDispatches(result.Messages); !slices.Equal(dispatches, c.dispatches) {
+				t.Errorf("expected tool calls %v, got %v", c.dispatches, dispatches)
+			}
+			assertToolPairing(t, result.Messages)
+			assertSystemPrompt(t, completer.Requests[0])
+		})
+	}
+}
+
+// Check the answer or error a run ended with
+func assertRunEnd(t *testing.T, result RunResult, err error, c fixtureCase) {
+	t.Helper()
+	if c.errClass == "" {
+		if err != nil {
+			t.Fatalf("run failed: %s", err)
+		}
+		if !strings.HasPrefix(result.Answer, c.answer) {
+			t.Fatalf("expected an answer starting with %q, got %q", c.answer, result.Answer)
+		}
+		return
+	}
+
+	if err == nil {
+		t.Fatalf("expected the run to fail with %s, answered %q", c.errClass, result.Answer)
+	}
+	if class := errorClass(err); class != c.errClass {
+		t.Fatalf("expected a %s error, got %s: %s", c.errClass, class, err)
+	}
+	if result.Answer != "" {
+		t.Fatalf("expected no answer on a failed run, got %q", result.Answer)
+	}
+}
+
+// Class of a run error: the class of a typed completion or tool error, or max_iterations
+func errorClass(err error) string {
+	var maxIterations *ErrMaxIterations
+	if errors.As(err, &maxIterations) {
+		return "max_iterations"
+	}
+
+	var classified interface{ Class() string }
+	if errors.As(err, &classified) {
+		return classified.Class()
+	}
+	return fmt.Sprintf("%T", err)
+}
+
+// Tool calls of the assistant messages of a conversation, by ID, in order
+func assistantToolCalls(message openai.ChatCompletionMessageParamUnion) (ids []string, names []string) {
+	switch m := message.(type) {
+	case openai.ChatCompletionMessage:
+		for _, toolCall := range m.ToolCalls {
+			ids, names = append(ids, toolCall.ID), append(names, toolCall.Function.Name)
+		}
+	case openai.ChatCompletionAssistantMessageParam:
+		for _, toolCall := range m.ToolCalls.Value {
+			ids, names = append(ids, toolCall.ID.Value), append(names, toolCall.Function.Value.Name.Value)
+		}
+	}
+	return ids, names
+}
+
+// Functions called along a conversation, in order
+func toolDispatches(messages []openai.ChatCompletionMessageParamUnion) []string {
+	dispatches := []string{}
+	for _, message := range messages {
+		_, names := assistantToolCalls(message)
+		dispatches = append(dispatches, names...)
+	}
+	return dispatches
+}
+
+// Every tool call is answered by one tool message of its ID, in the order of the calls,
+// before the conversation goes on
+func assertToolPairing(t *testing.T, messages []openai.ChatCompletionMessageParamUnion) {
+	t.Helper()
+	pending := []string{}
+	for i, message := range messages {
+		if toolMessage, ok := message.(openai.ChatCompletionToolMessageParam); ok {
+			if len(pending) == 0 || toolMessage.ToolCallID.Value != pending[0] {
+				t.Fatalf("message %d answers tool call '%s', expected one of %v", i, toolMessage.ToolCallID.Value, pending)
+			}
+			pending = pending[1:]
+			continue
+		}
+
+		// System messages injected between tool results, like corrections, don't break the pairing
+		if isSystemMessage(message) {
+			continue
+		}
+		if len(pending) != 0 {
+			t.Fatalf("message %d follows unanswered tool calls %v", i, pending)
+		}
+		pending, _ = assistantToolCalls(message)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("tool calls %v are never answered", pending)
+	}
+}
+
+// The router gets the dataset system prompt first, then the question
+func assertSystemPrompt(t *testing.T, request openai.ChatCompletionNewParams) {
+	t.Helper()
+	messages := request.Messages.Value
+	if len(messages) < 2 || messageText(messages[0]) != systemPrompt {
+		t.Fatalf("expected the router request to start with the dataset system prompt, got %v", messages)
+	}
+	if question := lastUserQuestion(messages); question != "Fixture question" {
+		t.Fatalf("expected the question on the router request, got %q", question)
+	}
+	if history.MessageText(messages[len(messages)-1]) != "Fixture question" {
+		t.Fatalf("expected the question last on the first router request")
+	}
+}
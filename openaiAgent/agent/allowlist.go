@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/openai/openai-go"
+)
+
+/*
+--------------
+Tool allowlist
+--------------
+*/
+
+// Short tool names accepted on allowlists, mapped to their function names
+var toolAliases = map[string]string{
+	"lookup":    tools.LookUpFuncName,
+	"analyze":   tools.AnalyzeFuncName,
+	"visualize": tools.VisualizeFuncName,
+	"search":    tools.SearchPastAnalysesFuncName,
+	"compare":   tools.ComparePeriodsFuncName,
+}
+
+// Tools allowed when the allowlist is empty. SearchPastAnalyses is added by New when an index is configured
+var defaultTools = []string{tools.LookUpFuncName, tools.AnalyzeFuncName, tools.VisualizeFuncName, tools.ComparePeriodsFuncName}
+
+// Function names of every tool the agent can run
+var knownTools = append(slices.Clone(defaultTools), tools.SearchPastAnalysesFuncName)
+
+// Parse a comma separated allowlist like "lookup,analyze". Function names are accepted too.
+// Returns the function names of the allowed tools
+func ParseToolAllowlist(allowlist string) ([]string, error) {
+	return resolveToolAllowlist(strings.Split(allowlist, ","))
+}
+
+// Resolve tool names or aliases to function names. An empty allowlist allows every tool
+func resolveToolAllowlist(names []string) ([]string, error) {
+	allowed := []string{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		functionName, ok := toolAliases[strings.ToLower(name)]
+		if !ok && !slices.Contains(knownTools, name) {
+			return nil, fmt.Errorf("unknown tool '%s' on allowlist", name)
+		}
+		if !ok {
+			functionName = name
+		}
+
+		if !slices.Contains(allowed, functionName) {
+			allowed = append(allowed, functionName)
+		}
+	}
+
+	if len(allowed) == 0 {
+		return slices.Clone(defaultTools), nil
+	}
+
+	return allowed, nil
+}
+
+// Derive an agent allowed only some of the tools of a, like the ones a server request names.
+// Tools a doesn't allow are refused, an empty allowlist keeps the ones of a. Closing it is a no-op, close a instead
+func (a *Agent) WithTools(names []string) (*Agent, error) {
+	derived := a.derive()
+	if len(names) == 0 {
+		return derived, nil
+	}
+
+	allowed, err := resolveToolAllowlist(names)
+	if err != nil {
+		return nil, err
+	}
+	for _, functionName := range allowed {
+		if !slices.Contains(a.config.Tools, functionName) {
+			return nil, fmt.Errorf("tool '%s' isn't enabled on this agent", functionName)
+		}
+	}
+
+	derived.config.Tools = allowed
+	return derived, nil
+}
+
+// Tool params offered to the router: the loaded ones, narrowed to the allowlist of a derived agent
+func (a *Agent) toolParams() []openai.ChatCompletionToolParam {
+	return filterToolParams(a.toolSet.Params(), a.config.Tools)
+}
+
+// Keep only the tool params of allowed tools
+func filterToolParams(params []openai.ChatCompletionToolParam, allowed []string) []openai.ChatCompletionToolParam {
+	filtered := []openai.ChatCompletionToolParam{}
+	for _, param := range params {
+		if slices.Contains(allowed, param.Function.Value.Name.Value) {
+			filtered = append(filtered, param)
+		}
+	}
+
+	return filtered
+}
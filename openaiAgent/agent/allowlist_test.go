@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/history"
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/openai/openai-go"
+)
+
+func TestParseToolAllowlist(t *testing.T) {
+	cases := []struct {
+		allowlist string
+		expected  []string
+		wantErr   bool
+	}{
+		{allowlist: "", expected: defaultTools},
+		{allowlist: " , ", expected: defaultTools},
+		{allowlist: "lookup,analyze", expected: []string{tools.LookUpFuncName, tools.AnalyzeFuncName}},
+		{allowlist: "LOOKUP, lookup, " + tools.LookUpFuncName, expected: []string{tools.LookUpFuncName}},
+		{allowlist: tools.VisualizeFuncName, expected: []string{tools.VisualizeFuncName}},
+		{allowlist: "lookup,execute", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.allowlist, func(t *testing.T) {
+			allowed, err := ParseToolAllowlist(c.allowlist)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected '%s' to fail, got %v", c.allowlist, allowed)
+				}
+				return
+			}
+			if err != nil || !slices.Equal(allowed, c.expected) {
+				t.Fatalf("expected %v, got %v: %v", c.expected, allowed, err)
+			}
+		})
+	}
+}
+
+// Function names of the tools offered on a completion request
+func offeredTools(request openai.ChatCompletionNewParams) []string {
+	names := []string{}
+	for _, param := range request.Tools.Value {
+		names = append(names, param.Function.Value.Name.Value)
+	}
+	return names
+}
+
+func TestAllowlistFiltersToolParams(t *testing.T) {
+	completer := mock.NewCompleter(mock.TextResponse("Hi."), mock.TextResponse("Hi again."))
+	parent := newTestAgent(t, Config{Tools: []string{"lookup", "visualize"}}, completer)
+
+	if _, err := parent.Run(context.Background(), "Hi"); err != nil {
+		t.Fatal(err)
+	}
+	if offered := offeredTools(completer.Requests[0]); !slices.Equal(offered, []string{tools.LookUpFuncName, tools.VisualizeFuncName}) {
+		t.Errorf("expected lookup and visualize offered, got %v", offered)
+	}
+
+	derived, err := parent.WithTools([]string{"visualize"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := derived.Run(context.Background(), "Hi"); err != nil {
+		t.Fatal(err)
+	}
+	if offered := offeredTools(completer.Requests[1]); !slices.Equal(offered, []string{tools.VisualizeFuncName}) {
+		t.Errorf("expected only visualize offered on the derived agent, got %v", offered)
+	}
+
+	// Derived agents narrow the tools, they can't enable more
+	if _, err := parent.WithTools([]string{"analyze"}); err == nil {
+		t.Error("expected a tool the parent doesn't allow to be refused")
+	}
+	if len(parent.toolParams()) != 2 {
+		t.Errorf("expected the parent to keep its tools, got %d", len(parent.toolParams()))
+	}
+}
+
+// A call to a tool the run doesn't offer is answered with an error tool message, never executed
+func TestBlockedToolDispatch(t *testing.T) {
+	completer := mock.NewCompleter(
+		mock.ToolCallResponse("call_1", tools.VisualizeFuncName, map[string]string{"prompt": "Chart the sales"}),
+		mock.TextResponse("I can't chart here."),
+	)
+	runAgent := newTestAgent(t, Config{Tools: []string{"lookup"}}, completer)
+
+	result, err := runAgent.Run(context.Background(), "Chart the sales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(completer.Requests) != 2 {
+		t.Fatalf("expected only the 2 router calls, got %d completions", len(completer.Requests))
+	}
+	if len(result.Artifacts) != 0 {
+		t.Errorf("expected no artifacts from a blocked call, got %v", result.Artifacts)
+	}
+
+	toolResult := ""
+	for _, message := range result.Messages {
+		if toolMessage, ok := message.(openai.ChatCompletionToolMessageParam); ok && toolMessage.ToolCallID.Value == "call_1" {
+			toolResult = history.MessageText(message)
+		}
+	}
+	if !strings.Contains(toolResult, "tool '"+tools.VisualizeFuncName+"' is not enabled") {
+		t.Errorf("expected a disabled tool error, got: %s", toolResult)
+	}
+}
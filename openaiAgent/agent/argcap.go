@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+/*
+-------------------
+Tool argument sizes
+-------------------
+*/
+
+// Share of the router model context window each decoded tool call argument can take, unless
+// Config.MaxArgumentBytes says otherwise. 50KB on 128k token models
+const argumentContextPercent = 10
+
+// Marker ending truncated data, with its original size
+const truncatedDataMarker = "\n[truncated from %d bytes]"
+
+// Note before the result of a tool call whose data was truncated, so the router passes a reference next time
+const truncatedDataNote = "NOTE: the data argument was over %d bytes and was truncated, results may be incomplete." +
+	" Pass the dataRef of a previous result instead of pasting its rows.\n\n"
+
+// Sizes of the data argument of a tool call cut to fit the cap
+type ArgumentTruncation struct {
+	OriginalBytes  int
+	TruncatedBytes int // Size after the cut, the marker included
+}
+
+// Cut the data argument of a call over max bytes, ending it with a marker so the tools know rows are missing.
+// The cut falls on a rune boundary and leaves room for the marker. Prompts and visualization goals can't be
+// cut without changing what is asked, so oversized ones fail with a retryable invalid arguments error
+func capToolArguments(functionName string, args *toolFunctionArgs, max int) (*ArgumentTruncation, error) {
+	for _, field := range []struct {
+		name  string
+		value string
+	}{{"prompt", args.Prompt}, {"visualizationGoal", args.VisualizationGoal}} {
+		if len(field.value) > max {
+			return nil, &tools.ErrInvalidArguments{
+				Tool:   functionName,
+				Reason: fmt.Sprintf("%s is %d bytes, over the %d bytes limit. Ask for less at once", field.name, len(field.value), max),
+			}
+		}
+	}
+
+	if len(args.Data) <= max {
+		return nil, nil
+	}
+
+	originalBytes := len(args.Data)
+	marker := fmt.Sprintf(truncatedDataMarker, originalBytes)
+	args.Data = truncateUTF8(args.Data, max-len(marker)) + marker
+	return &ArgumentTruncation{OriginalBytes: originalBytes, TruncatedBytes: len(args.Data)}, nil
+}
+
+// Longest prefix of text up to max bytes that doesn't split a rune
+func truncateUTF8(text string, max int) string {
+	if max <= 0 {
+		return ""
+	}
+	if len(text) <= max {
+		return text
+	}
+
+	cut := max
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut]
+}
@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+// Arguments at and over the cap, with multi byte runes at the cut point, and oversized prompts and goals
+func TestCapToolArguments(t *testing.T) {
+	const max = 100
+	cases := []struct {
+		name      string
+		args      toolFunctionArgs
+		max       int
+		truncated bool // Whether the data must be cut
+		invalid   bool // Whether the arguments must be rejected
+	}{
+		{name: "data at the cap", args: toolFunctionArgs{Data: strings.Repeat("a", max)}, max: max},
+		{name: "data a byte over", args: toolFunctionArgs{Data: strings.Repeat("a", max+1)}, max: max, truncated: true},
+		{name: "two byte rune at the cut", args: toolFunctionArgs{Data: strings.Repeat("é", max)}, max: max, truncated: true},
+		{name: "four byte rune at the cut", args: toolFunctionArgs{Data: "ab" + strings.Repeat("📈", max)}, max: max, truncated: true},
+		{name: "prompt at the cap", args: toolFunctionArgs{Prompt: strings.Repeat("a", max)}, max: max},
+		{name: "prompt a byte over", args: toolFunctionArgs{Prompt: strings.Repeat("a", max+1)}, max: max, invalid: true},
+		{name: "multi byte goal over", args: toolFunctionArgs{VisualizationGoal: strings.Repeat("ñ", max/2+1)}, max: max, invalid: true},
+		{name: "cap under the marker", args: toolFunctionArgs{Data: strings.Repeat("a", 20)}, max: 10, truncated: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			args := c.args
+			truncation, err := capToolArguments(tools.AnalyzeFuncName, &args, c.max)
+			if c.invalid {
+				if err == nil {
+					t.Fatalf("accepted arguments over %d bytes", c.max)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !c.truncated {
+				if truncation != nil || args != c.args {
+					t.Fatalf("changed arguments within %d bytes", c.max)
+				}
+				return
+			}
+
+			marker := fmt.Sprintf(truncatedDataMarker, len(c.args.Data))
+			switch {
+			case truncation == nil:
+				t.Fatalf("kept %d bytes of data, expected a cut at %d", len(args.Data), c.max)
+			case truncation.OriginalBytes != len(c.args.Data) || truncation.TruncatedBytes != len(args.Data):
+				t.Fatalf("reported a cut from %d to %d bytes, expected %d to %d", truncation.OriginalBytes, truncation.TruncatedBytes, len(c.args.Data), len(args.Data))
+			case !utf8.ValidString(args.Data):
+				t.Fatal("cut data isn't valid UTF-8")
+			case !strings.HasSuffix(args.Data, marker):
+				t.Fatal("cut data has no truncation marker")
+			case len(args.Data) > c.max && len(args.Data) != len(marker):
+				t.Fatalf("cut data is %d bytes, over the %d bytes cap", len(args.Data), c.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/history"
+	"github.com/openai/openai-go"
+)
+
+/*
+-----------------------------
+Chat history interoperability
+-----------------------------
+*/
+
+// Run the agent on a prompt, continuing a conversation saved by openaiChat at historyPath, a JSONL history
+// for .jsonl files. The agent system prompt goes first, so the chat's own system messages don't replace it
+func (a *Agent) RunContinued(ctx context.Context, historyPath string, prompt string) (RunResult, error) {
+	store, err := history.OpenStore(historyPath, "")
+	if err != nil {
+		return RunResult{}, err
+	}
+	chatHistory, err := store.Load()
+	if err != nil {
+		return RunResult{}, fmt.Errorf("failed to load chat history: %w", err)
+	}
+
+	log.Printf("Continuing conversation from: %s\n", chatHistory.TimeStamp)
+	messages := []openai.ChatCompletionMessageParamUnion{openai.SystemMessage(systemPrompt)}
+	messages = append(messages, chatHistory.MessageParams()...)
+	messages = append(messages, openai.UserMessage(prompt))
+
+	return a.RunMessages(ctx, messages)
+}
+
+// Export the conversation of a run in the openaiChat history format, so the chat can open it.
+// Tool calls and results are collapsed into assistant summaries. Exports to .jsonl files are JSONL histories
+func ExportHistory(result RunResult, historyPath string) error {
+	store, err := history.OpenStore(historyPath, "")
+	if err != nil {
+		return err
+	}
+	if err := store.Save(history.New(history.FromMessageParams(result.Messages))); err != nil {
+		return fmt.Errorf("failed to export chat history: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+--------------------
+Dataset change watch
+--------------------
+*/
+
+// System notice of runs continuing a conversation after the dataset changed, so earlier numbers aren't cited as current
+const dataChangedNotice = "The underlying dataset was updated at %s; previously retrieved results may be stale. " +
+	"Look the data up again instead of reusing numbers or result refs from earlier in the conversation."
+
+// Dataset state of an agent and the agents derived from it. Server requests run on derived agents, so the
+// reload one of them makes is seen by the base agent and the runs after it instead of being redone on each
+type dataState struct {
+	profile        string       // Markdown profile of the dataset added to the router prompt, with Config.ProfilePrompt. Guarded by dbMutex
+	pendingRefresh atomic.Bool  // Dataset was reloaded and no run has reported it yet
+	reloads        atomic.Int64 // Reloads of the dataset after its data file changed, see Config.WatchData
+}
+
+// Reload the dataset when its data file changed since it was loaded, see Config.WatchData. Waits for the runs
+// going on, as the table is replaced under them. The profile prompt is computed again for the new data.
+// A failed check or reload keeps the loaded table
+func (a *Agent) reloadDataIfChanged(ctx context.Context) tools.DataChange {
+	a.dbMutex.Lock()
+	defer a.dbMutex.Unlock()
+
+	change, err := a.store.RefreshIfChanged(a.config.DataPath)
+	if err != nil {
+		log.Printf("WARNING: Failed to reload the changed dataset, keeping the loaded table: %s\n", err)
+		return tools.DataChange{}
+	}
+	if !change.Reloaded {
+		return change
+	}
+
+	a.data.reloads.Add(1)
+	a.data.pendingRefresh.Store(true)
+	log.Printf("Dataset changed at %s, reloaded it before the run\n", change.UpdatedAt.Format(time.RFC3339))
+
+	if a.config.ProfilePrompt {
+		profile, err := a.Profile(ctx, false)
+		if err != nil {
+			log.Printf("WARNING: Failed to profile the changed dataset, dropping its profile prompt: %s\n", err)
+			a.data.profile = ""
+		} else {
+			a.data.profile = profilePrompt + profile.Markdown()
+		}
+	}
+
+	return change
+}
+
+// Record a dataset change on the run span, and tell the model the results of the earlier turns of a
+// continued conversation may be stale. Their result refs don't resolve anyway, each run stores its own
+func (a *Agent) withDataChangeNotice(
+	span trace.Span,
+	messages []openai.ChatCompletionMessageParamUnion,
+	change tools.DataChange,
+) []openai.ChatCompletionMessageParamUnion {
+	tracing.SetSpanAttr(span, "dataset.changed", true)
+	tracing.SetSpanAttr(span, "dataset.updated_at", change.UpdatedAt.Format(time.RFC3339))
+
+	continued := false
+	for _, message := range messages {
+		continued = continued || messageRole(message) == string(openai.ChatCompletionMessageParamRoleAssistant)
+	}
+	tracing.SetSpanAttr(span, "dataset.stale_notice", continued)
+	if !continued {
+		return messages
+	}
+
+	return withSystemMessage(messages, fmt.Sprintf(dataChangedNotice, change.UpdatedAt.Format(time.RFC3339)))
+}
+
+// Amount of times the dataset was reloaded after its data file changed, see Config.WatchData
+func (a *Agent) DataReloads() int {
+	return int(a.data.reloads.Load())
+}
@@ -0,0 +1,202 @@
+package agent
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+)
+
+// Rows kept by the rewrite of the data file between the two runs of a case
+const dataWatchRewriteRows = 1
+
+// A refresh reopens the locked database, so it waits for the runs holding it
+func TestRefreshDataWaitsForRuns(t *testing.T) {
+	agent := newTestAgent(t, Config{}, mock.NewCompleter())
+
+	agent.dbMutex.RLock()
+	refreshed := make(chan error, 1)
+	go func() { refreshed <- agent.RefreshData() }()
+
+	select {
+	case err := <-refreshed:
+		agent.dbMutex.RUnlock()
+		t.Fatalf("expected the refresh to wait for the run, it ended with %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	agent.dbMutex.RUnlock()
+	if err := <-refreshed; err != nil {
+		t.Fatal(err)
+	}
+	if rows, err := agent.store.RowCount(context.Background()); err != nil || rows != 3 {
+		t.Fatalf("expected the refreshed table to hold the 3 rows of the dataset, got %d: %v", rows, err)
+	}
+}
+
+// Two runs of an agent on a copy of the data file, changed between them by change when set: the second run
+// reloads the dataset, tells a continued conversation earlier results may be stale and gets the new profile
+func TestWatchData(t *testing.T) {
+	cases := []struct {
+		name      string
+		watchData bool
+		change    func(t *testing.T, dataPath string)
+		continued bool // The second run continues the conversation of the first one
+		reloaded  bool // The dataset is reloaded before the second run
+		notice    bool // The second run is told earlier results may be stale
+		derived   bool // Each run is made by an agent derived from the watching one, like server requests
+	}{
+		{name: "unchanged file", watchData: true, continued: true},
+		{name: "touched file", watchData: true, change: touchDataFile, continued: true},
+		{name: "rewritten file on a continued conversation", watchData: true, change: rewriteDataFile, continued: true, reloaded: true, notice: true},
+		{name: "rewritten file on a new question", watchData: true, change: rewriteDataFile, reloaded: true},
+		{name: "rewritten file unwatched", change: rewriteDataFile, continued: true},
+		{name: "rewritten file on derived agents", watchData: true, change: rewriteDataFile, continued: true, reloaded: true, notice: true, derived: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dataPath := copyDataFile(t, datasetFixture(t, "sales.parquet"))
+			completer := mock.NewCompleter(mock.TextResponse("Store 1320 sold the most."), mock.TextResponse("Store 1320 still sold the most."))
+			tracer, recorder := tracing.NewRecordingTracer()
+			baseAgent := newTestAgent(t, Config{DataPath: dataPath, ProfilePrompt: true, WatchData: c.watchData, Tracer: tracer}, completer)
+			runAgent := func() *Agent { return baseAgent }
+			if c.derived {
+				runAgent = func() *Agent { return baseAgent.WithModel(baseAgent.config.Model) }
+			}
+			originalRows := dataFileRows(t, dataPath)
+
+			first := []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Which store sold the most?")}
+			firstResult, err := runAgent().RunMessages(context.Background(), first)
+			if err != nil {
+				t.Fatalf("first run failed: %s", err)
+			}
+
+			if c.change != nil {
+				c.change(t, dataPath)
+			}
+
+			second := []openai.ChatCompletionMessageParamUnion{openai.UserMessage("And now?")}
+			if c.continued {
+				second = append(firstResult.Messages, second...)
+			}
+			if _, err := runAgent().RunMessages(context.Background(), second); err != nil {
+				t.Fatalf("second run failed: %s", err)
+			}
+
+			if reloads, want := baseAgent.DataReloads(), map[bool]int{true: 1}[c.reloaded]; reloads != want {
+				t.Errorf("expected %d dataset reloads, got %d", want, reloads)
+			}
+			if len(completer.Requests) != 2 {
+				t.Fatalf("expected a request per run, got %d", len(completer.Requests))
+			}
+			system := strings.Join(requestSystemTexts(completer.Requests[1]), "\n")
+			if strings.Contains(system, "The underlying dataset was updated at") != c.notice {
+				t.Errorf("expected the stale results notice on the second run to be %t", c.notice)
+			}
+
+			// The profile prompt describes the table the second run reads
+			rows := originalRows
+			if c.reloaded {
+				rows = dataFileRows(t, dataPath)
+			}
+			if !strings.Contains(system, fmt.Sprintf("\n%d rows,", rows)) {
+				t.Errorf("the profile prompt of the second run doesn't describe the %d rows of the dataset", rows)
+			}
+
+			runSpans := []bool{}
+			for _, span := range recorder.Ended() {
+				if span.Name() != "AgentRun" {
+					continue
+				}
+				changed := false
+				for _, attr := range span.Attributes() {
+					changed = changed || attr.Key == "dataset.changed" && attr.Value.AsBool()
+				}
+				runSpans = append(runSpans, changed)
+			}
+			if len(runSpans) != 2 || runSpans[0] || runSpans[1] != c.reloaded {
+				t.Errorf("expected dataset.changed on the second AgentRun span only when reloaded, got %v", runSpans)
+			}
+		})
+	}
+}
+
+// Texts of the system messages of a request
+func requestSystemTexts(request openai.ChatCompletionNewParams) []string {
+	texts := []string{}
+	for _, message := range request.Messages.Value {
+		if systemMessage, ok := message.(openai.ChatCompletionSystemMessageParam); ok {
+			for _, part := range systemMessage.Content.Value {
+				texts = append(texts, part.Text.Value)
+			}
+		}
+	}
+	return texts
+}
+
+// Copy a data file into a temp dir, returning the path of the copy
+func copyDataFile(t *testing.T, from string) string {
+	t.Helper()
+	data, err := os.ReadFile(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := filepath.Join(t.TempDir(), "data.parquet")
+	if err := os.WriteFile(to, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return to
+}
+
+// Move the modification time of the data file without changing its content
+func touchDataFile(t *testing.T, dataPath string) {
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(dataPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Replace the data file with its first dataWatchRewriteRows rows
+func rewriteDataFile(t *testing.T, dataPath string) {
+	t.Helper()
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rewritten := dataPath + ".rewritten"
+	_, err = db.Exec(fmt.Sprintf(
+		"COPY (SELECT * FROM read_parquet('%s') LIMIT %d) TO '%s' (FORMAT parquet)", dataPath, dataWatchRewriteRows, rewritten,
+	))
+	if err != nil {
+		t.Fatalf("failed to rewrite the data file: %s", err)
+	}
+	if err := os.Rename(rewritten, dataPath); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func dataFileRows(t *testing.T, dataPath string) int64 {
+	t.Helper()
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows := int64(0)
+	if err := db.QueryRow(fmt.Sprintf("SELECT count(*) FROM read_parquet('%s')", dataPath)).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
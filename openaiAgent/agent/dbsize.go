@@ -0,0 +1,169 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+/*
+---------------
+Database upkeep
+---------------
+*/
+
+// What the warm up does when the database file is over Config.MaxDBSizeMB
+const (
+	DBSizeWarn   = "warn"   // Log a warning and report it on the readiness
+	DBSizeVacuum = "vacuum" // Vacuum the database, warning if it's still over
+)
+
+// A vacuum was asked while a run of the agent was using the database
+var ErrRunInProgress = errors.New("a run is using the database, vacuum once it's done")
+
+const bytesPerMB = 1024 * 1024
+
+// Size of the agent database on warm up
+type DatabaseStats struct {
+	SizeBytes int64  `json:"sizeBytes"`
+	MaxBytes  int64  `json:"maxBytes,omitempty"` // Config.MaxDBSizeMB in bytes, 0 without a cap
+	Vacuumed  bool   `json:"vacuumed,omitempty"` // Vacuumed on warm up for being over the cap
+	Warning   string `json:"warning,omitempty"`  // Why the database is still over the cap
+}
+
+// Check a database size action. Empty is DBSizeWarn
+func normalizeDBSizeAction(action string) (string, error) {
+	switch action {
+	case "":
+		return DBSizeWarn, nil
+	case DBSizeWarn, DBSizeVacuum:
+		return action, nil
+	}
+
+	return "", fmt.Errorf("unknown database size action '%s', expected %s or %s", action, DBSizeWarn, DBSizeVacuum)
+}
+
+// Whether the agent has a DuckDB database file of its own, not a live database nor the memory backend
+func (a *Agent) hasDatabaseFile() bool {
+	return a.store.Backend() == tools.StorageDuckDB && !tools.IsLiveDatabase(a.config.DataPath)
+}
+
+// Drop the orphaned run tables and stale dataset tables of the database, then checkpoint it.
+// Fails with ErrRunInProgress instead of waiting when a run of this agent, or of one derived from it, is going on.
+// Other processes can't have the database open, DuckDB locks the file
+func (a *Agent) Vacuum(ctx context.Context) (tools.VacuumReport, error) {
+	if !a.hasDatabaseFile() {
+		return tools.VacuumReport{}, fmt.Errorf("the dataset is on %s, not on a DuckDB database file of the agent", tools.RedactDSN(a.config.DataPath))
+	}
+	if a.config.ReadOnly {
+		return tools.VacuumReport{}, &tools.ErrReadOnly{Operation: "vacuuming the database"}
+	}
+
+	if !a.dbMutex.TryLock() {
+		return tools.VacuumReport{}, ErrRunInProgress
+	}
+	defer a.dbMutex.Unlock()
+
+	db, err := a.store.SQL()
+	if err != nil {
+		return tools.VacuumReport{}, err
+	}
+
+	return tools.VacuumDatabase(ctx, db, a.config.DatabasePath)
+}
+
+// Bytes of the database file with its write ahead log, 0 without a database file
+func (a *Agent) DatabaseSize() (int64, error) {
+	if !a.hasDatabaseFile() {
+		return 0, nil
+	}
+	return tools.DatabaseSize(a.config.DatabasePath)
+}
+
+// Tables a vacuum of the database would drop, without dropping them
+func (a *Agent) VacuumTargets(ctx context.Context) (tools.VacuumTargets, error) {
+	if !a.hasDatabaseFile() {
+		return tools.VacuumTargets{}, fmt.Errorf("the dataset is on %s, not on a DuckDB database file of the agent", tools.RedactDSN(a.config.DataPath))
+	}
+
+	db, err := a.store.SQL()
+	if err != nil {
+		return tools.VacuumTargets{}, err
+	}
+
+	return tools.FindVacuumTargets(ctx, db)
+}
+
+// Size the database on warm up. Over Config.MaxDBSizeMB it's vacuumed with DBSizeVacuum, and a warning is
+// reported if it's still over, or right away with DBSizeWarn. Nil without a database file
+func (a *Agent) warmupDatabaseSize(ctx context.Context) *DatabaseStats {
+	if !a.hasDatabaseFile() {
+		return nil
+	}
+
+	size, err := tools.DatabaseSize(a.config.DatabasePath)
+	if err != nil {
+		log.Printf("WARNING: %s\n", err)
+		return nil
+	}
+
+	stats := &DatabaseStats{SizeBytes: size, MaxBytes: int64(a.config.MaxDBSizeMB) * bytesPerMB}
+	if stats.MaxBytes == 0 || stats.SizeBytes <= stats.MaxBytes {
+		return stats
+	}
+
+	vacuumErr := error(nil)
+	if a.config.DBSizeAction == DBSizeVacuum {
+		log.Printf("Database %s is %s, over the %d MB cap, vacuuming it\n", a.config.DatabasePath, FormatMB(size), a.config.MaxDBSizeMB)
+		report, err := a.Vacuum(ctx)
+		if err == nil {
+			stats.SizeBytes, stats.Vacuumed = report.SizeAfter, true
+		}
+		vacuumErr = err
+	}
+
+	if stats.SizeBytes > stats.MaxBytes {
+		stats.Warning = fmt.Sprintf("database %s is %s, over the %d MB cap", a.config.DatabasePath, FormatMB(stats.SizeBytes), a.config.MaxDBSizeMB)
+		switch {
+		case stats.Vacuumed:
+			stats.Warning += " even after a vacuum, raise -max-db-size-mb or load a sample of the dataset"
+		case vacuumErr != nil:
+			stats.Warning += fmt.Sprintf(", the vacuum failed: %s", vacuumErr)
+		default:
+			stats.Warning += ". Run 'agent db vacuum', or raise -max-db-size-mb"
+		}
+		log.Printf("WARNING: %s\n", stats.Warning)
+	}
+
+	return stats
+}
+
+// Size of the database file of a config against its cap, without opening it. Skipped without a cap,
+// and for live databases and the memory backend
+func CheckDatabaseSize(ctx context.Context, config Config) error {
+	paths := config.withPaths()
+	if paths.StorageBackend == tools.StorageMemory || tools.IsLiveDatabase(paths.DataPath) {
+		return fmt.Errorf("%w: no database file", ErrCheckSkipped)
+	}
+
+	size, err := tools.DatabaseSize(paths.DatabasePath)
+	if err != nil {
+		return err
+	}
+	if config.MaxDBSizeMB <= 0 {
+		return fmt.Errorf("%w: no -max-db-size-mb, %s is %s", ErrCheckSkipped, paths.DatabasePath, FormatMB(size))
+	}
+	if size > int64(config.MaxDBSizeMB)*bytesPerMB {
+		return fmt.Errorf("%s is %s, over the %d MB cap", paths.DatabasePath, FormatMB(size), config.MaxDBSizeMB)
+	}
+
+	return nil
+}
+
+// Bytes as megabytes with one decimal, like "12.5 MB"
+func FormatMB(bytes int64) string {
+	return fmt.Sprintf("%.1f MB", float64(bytes)/bytesPerMB)
+}
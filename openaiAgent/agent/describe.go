@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"context"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/prompts"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/openai/openai-go"
+)
+
+/*
+-------------------
+Dataset description
+-------------------
+*/
+
+// Describe the dataset in a few sentences from its profile, for Config.DatasetDescription. noLLM templates the
+// description from the profile instead of having the model write it
+func (a *Agent) DescribeDataset(ctx context.Context, noLLM bool) (string, error) {
+	completer := tools.NewRequestRecorder(a.completer)
+	toolbox := tools.NewToolbox(
+		tools.Config{Model: a.config.Model, DataPath: a.config.DataPath, Language: a.config.Language},
+		completer,
+		a.store,
+		a.tracer,
+		a.audit,
+	)
+	return toolbox.DescribeDataset(ctx, !noLLM)
+}
+
+// System message introducing the dataset with Config.DatasetDescription, empty without one
+func (a *Agent) datasetDescriptionPrompt() string {
+	if a.config.DatasetDescription == "" {
+		return ""
+	}
+	return prompts.RenderDatasetDescription(prompts.DatasetDescriptionRequest{Description: a.config.DatasetDescription})
+}
+
+// Tool params with Config.DatasetDescription appended to the description of the lookup tool, so the router
+// knows what it can look up. The params given aren't modified
+func (a *Agent) withDatasetDescription(params []openai.ChatCompletionToolParam) []openai.ChatCompletionToolParam {
+	if a.config.DatasetDescription == "" {
+		return params
+	}
+
+	described := make([]openai.ChatCompletionToolParam, len(params))
+	for i, param := range params {
+		described[i] = param
+		if param.Function.Value.Name.Value != tools.LookUpFuncName {
+			continue
+		}
+		function := param.Function.Value
+		function.Description = openai.F(strings.TrimSuffix(function.Description.Value, ".") + ". Dataset: " + a.config.DatasetDescription)
+		described[i].Function = openai.F(function)
+	}
+
+	return described
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+)
+
+// Deterministic runs request every completion, router and tools alike, with the seed and zero temperature,
+// record the seed on each LLM span and store it in the transcript for re-runs
+func TestDeterministicRuns(t *testing.T) {
+	cases := []struct {
+		name          string
+		deterministic bool
+		seed          int64
+		wantSeed      int64 // Seed every request and the transcript carry, 0 when runs aren't deterministic
+	}{
+		{name: "not deterministic", seed: 7},
+		{name: "default seed", deterministic: true, wantSeed: tools.DefaultSeed},
+		{name: "given seed", deterministic: true, seed: 7, wantSeed: 7},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			completer := mock.NewCompleter(
+				mock.ToolCallResponse("call_1", tools.LookUpFuncName, map[string]string{"prompt": "Total sales value"}),
+				mock.TextResponse("SELECT round(sum(Total_Sale_Value), 2) AS total_sales FROM sales"),
+				mock.TextResponse("Total sales were 66.75."),
+			)
+			tracer, recorder := tracing.NewRecordingTracer()
+			a := newTestAgent(t, Config{Tracer: tracer, Deterministic: c.deterministic, Seed: c.seed}, completer)
+
+			result, err := a.Run(context.Background(), "What were the total sales?")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(completer.Requests) != 3 {
+				t.Fatalf("run made %d requests, expected the router, SQL generation and answer ones", len(completer.Requests))
+			}
+
+			for i, request := range completer.Requests {
+				if request.Seed.Present != c.deterministic || request.Seed.Value != c.wantSeed {
+					t.Errorf("request %d has seed %d (set %t), expected %d", i+1, request.Seed.Value, request.Seed.Present, c.wantSeed)
+				}
+				if c.deterministic && (!request.Temperature.Present || request.Temperature.Value != 0) {
+					t.Errorf("request %d has temperature %v, expected 0", i+1, request.Temperature.Value)
+				}
+			}
+
+			seededSpans := 0
+			for _, span := range recorder.Ended() {
+				for _, attr := range span.Attributes() {
+					if attr.Key != "llm.seed" {
+						continue
+					}
+					seededSpans++
+					if attr.Value.AsInt64() != c.wantSeed {
+						t.Errorf("span %s records seed %d, expected %d", span.Name(), attr.Value.AsInt64(), c.wantSeed)
+					}
+				}
+			}
+			if wantSpans := map[bool]int{true: len(completer.Requests)}[c.deterministic]; seededSpans != wantSpans {
+				t.Errorf("%d spans record a seed, expected %d", seededSpans, wantSpans)
+			}
+
+			transcript, err := LoadTranscript(filepath.Join(result.RunDir, transcriptArtifactName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if transcript.Deterministic != c.deterministic || transcript.Seed != c.wantSeed {
+				t.Errorf("transcript records deterministic %t with seed %d, expected %t with %d",
+					transcript.Deterministic, transcript.Seed, c.deterministic, c.wantSeed)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+)
+
+/*
+------------------
+Environment checks
+------------------
+*/
+
+// Max time each check may take
+const checkTimeout = 30 * time.Second
+
+// Checks return it, wrapped with the reason, when they don't apply
+var ErrCheckSkipped = errors.New("skipped")
+
+// Check of a prerequisite of the agent. Each check works on the config alone, without an agent,
+// so any of them can run on its own, like for a readiness probe
+type Check struct {
+	Name     string
+	Required bool   // Failing required checks leave the agent unusable
+	Hint     string // What to do when the check fails
+	Run      func(ctx context.Context, config Config) error
+}
+
+// Outcome of a check
+type CheckResult struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+	ComponentStatus
+	Hint       string `json:"hint,omitempty"` // Only on failures
+	DurationMs int64  `json:"durationMs"`
+}
+
+// Checks of the environment, in the order they should run. Offline skips the checks calling paid APIs
+func EnvironmentChecks(offline bool) []Check {
+	openaiCheck := CheckOpenAI
+	if offline {
+		openaiCheck = func(context.Context, Config) error {
+			return fmt.Errorf("%w: offline", ErrCheckSkipped)
+		}
+	}
+
+	return []Check{
+		{
+			Name:     "data",
+			Required: true,
+			Hint:     "Point -data-path at a readable parquet file with the sales columns, a .duckdb file or an md: DSN",
+			Run:      CheckData,
+		},
+		{
+			Name:     "read-only",
+			Required: true,
+			Hint:     "Pass -read-only, and run the agent once without it to build the database it opens",
+			Run:      CheckReadOnly,
+		},
+		{
+			Name: "db-size",
+			Hint: "Run 'agent db vacuum' to drop orphaned run tables and stale datasets, or raise -max-db-size-mb",
+			Run:  CheckDatabaseSize,
+		},
+		{
+			Name:     "tools",
+			Required: true,
+			Hint:     fmt.Sprintf("Fix the tools json so it defines %s, or narrow -tools", strings.Join(requiredToolNames, ", ")),
+			Run:      CheckToolsJson,
+		},
+		{
+			Name:     "api-key",
+			Required: true,
+			Hint:     completion.MissingAPIKeyHint,
+			Run:      CheckAPIKey,
+		},
+		{
+			Name:     "openai",
+			Required: true,
+			Hint:     fmt.Sprintf("Set %s to a key with access to the configured model, or pass --offline to skip this check", completion.APIKeyEnv),
+			Run:      openaiCheck,
+		},
+		{
+			Name:     "tracing",
+			Required: true,
+			Hint:     fmt.Sprintf("Set %s and %s, and start the Phoenix collector", tracing.PhoenixEndpointEnv, tracing.PhoenixHeadersEnv),
+			Run:      CheckTracing,
+		},
+	}
+}
+
+// Run checks in order. Every check runs, whatever the outcome of the previous ones
+func RunChecks(ctx context.Context, config Config, checks []Check) []CheckResult {
+	results := []CheckResult{}
+	for _, check := range checks {
+		log.Printf("Checking %s\n", check.Name)
+		checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+		start := time.Now()
+		err := check.Run(checkCtx, config)
+		cancel()
+
+		result := CheckResult{Name: check.Name, Required: check.Required, DurationMs: time.Since(start).Milliseconds()}
+		switch {
+		case errors.Is(err, ErrCheckSkipped):
+			result.ComponentStatus = ComponentStatus{Skipped: true, Error: err.Error()}
+		case err != nil:
+			result.ComponentStatus = componentStatus(err)
+			result.Hint = check.Hint
+		default:
+			result.ComponentStatus = componentStatus(nil)
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// Whether every required check passed or was skipped
+func ChecksPassed(results []CheckResult) bool {
+	return !slices.ContainsFunc(results, func(result CheckResult) bool {
+		return result.Required && !result.OK && !result.Skipped
+	})
+}
+
+// Check the data path is readable and its dataset loads with the expected columns, on the configured storage backend.
+// Parquet data is loaded into an in memory database, so the agent database isn't touched
+func CheckData(ctx context.Context, config Config) error {
+	dataPath := config.withPaths().DataPath
+	if err := tools.ValidateDataPath(dataPath); err != nil {
+		return err
+	}
+
+	store, _, err := tools.OpenStore(config.StorageBackend, "", dataPath, config.Sample, true, false)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return tools.ValidateDataset(store, dataPath)
+}
+
+// Check production deployments are read-only, and that a read-only database opens without writes.
+// The database isn't bootstrapped, a run must have built it before
+func CheckReadOnly(ctx context.Context, config Config) error {
+	if !config.ReadOnly {
+		if config.Production {
+			return fmt.Errorf("production deployments must open the database read-only")
+		}
+		return fmt.Errorf("%w: read-only mode is off", ErrCheckSkipped)
+	}
+
+	paths := config.withPaths()
+	db, err := tools.OpenReadOnlyDatabase(paths.DatabasePath, paths.DataPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	readOnly, err := tools.IsReadOnly(ctx, db)
+	if err != nil {
+		return err
+	}
+	if !readOnly {
+		return fmt.Errorf("database %s was opened read-only but its connection can write", tools.RedactDSN(paths.DatabasePath))
+	}
+
+	return nil
+}
+
+// Check the tools json loads, defines every tool on the allowlist and no tool the agent can't run
+func CheckToolsJson(ctx context.Context, config Config) error {
+	toolsJsonPath := config.withPaths().ToolsJsonPath
+
+	allowed, err := resolveToolAllowlist(config.Tools)
+	if err != nil {
+		return err
+	}
+
+	toolConfigs, err := loadToolsJson(toolsJsonPath)
+	if err != nil {
+		return err
+	}
+
+	defined := []string{}
+	unknown := []string{}
+	for _, toolConfig := range toolConfigs {
+		defined = append(defined, toolConfig.Function.Name)
+		if !slices.Contains(knownTools, toolConfig.Function.Name) {
+			unknown = append(unknown, toolConfig.Function.Name)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("tools json %s defines tools the agent can't run: %s", toolsJsonPath, strings.Join(unknown, ", "))
+	}
+
+	for _, name := range allowed {
+		if !slices.Contains(defined, name) {
+			return fmt.Errorf("tool '%s' is allowed but not defined on %s", name, toolsJsonPath)
+		}
+	}
+
+	return nil
+}
+
+// Check an API key is set, without calling the API. Mock mode completers need none
+func CheckAPIKey(ctx context.Context, config Config) error {
+	if config.Completer != nil {
+		return fmt.Errorf("%w: mock mode", ErrCheckSkipped)
+	}
+	// The hint of the check tells how to set it
+	if err := completion.RequireAPIKey(); err != nil {
+		return completion.ErrMissingAPIKey
+	}
+	return nil
+}
+
+// Check the API key works with a 1 token completion on the configured model
+func CheckOpenAI(ctx context.Context, config Config) error {
+	model := config.Model
+	if model == "" {
+		model = tools.DefaultModel
+	}
+
+	completer := config.Completer
+	if completer == nil {
+		client, err := completion.GetOpenaiClient()
+		if err != nil {
+			return err
+		}
+		completer = client.Chat.Completions
+	}
+
+	_, err := tools.NewRequestRecorder(completer).New(ctx, openai.ChatCompletionNewParams{
+		Model:     openai.F(model),
+		Messages:  openai.F([]openai.ChatCompletionMessageParamUnion{openai.UserMessage("ping")}),
+		MaxTokens: openai.Int(1),
+	})
+	if err != nil {
+		return fmt.Errorf("ping completion on '%s' failed: %w", model, err)
+	}
+
+	return nil
+}
+
+// Check the tracing variables are defined and the Phoenix collector answers
+func CheckTracing(ctx context.Context, config Config) error {
+	return tracing.PingPhoenix(ctx)
+}
@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/language"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+----------------------
+Sensitive entity guard
+----------------------
+*/
+
+// Environment variable holding the comma separated principals allowed to ask about individuals
+const EntityGuardAllowlistEnv = "ENTITY_GUARD_ALLOWLIST"
+
+// Whether a principal is on the entity guard allowlist. Empty principals never are
+func entityGuardAllowed(principal string) bool {
+	if principal == "" {
+		return false
+	}
+
+	allowed := strings.Split(os.Getenv(EntityGuardAllowlistEnv), ",")
+	return slices.ContainsFunc(allowed, func(entry string) bool {
+		return strings.TrimSpace(entry) == principal
+	})
+}
+
+// Run the entity guard on the question of a run. Returns the refused result when the question
+// targets an individual, nil when the run can go on. Allowlisted principals skip the guard
+func (a *Agent) guardEntities(
+	agentCtx context.Context,
+	toolbox *tools.Toolbox,
+	messages []openai.ChatCompletionMessageParamUnion,
+	result RunResult,
+) (*RunResult, error) {
+	if len(a.sensitiveColumns) == 0 {
+		return nil, nil
+	}
+
+	span := trace.SpanFromContext(agentCtx)
+	if a.entityGuardBypass {
+		log.Printf("Principal '%s' is allowlisted, skipping the entity guard\n", a.config.Principal)
+		tracing.SetSpanAttr(span, "guardrail.bypassed_by", a.config.Principal)
+		return nil, nil
+	}
+
+	match, err := toolbox.GuardSensitiveEntities(agentCtx, result.Prompt, a.sensitiveColumns)
+	if err != nil || match == nil {
+		return nil, err
+	}
+
+	result.Answer = fmt.Sprintf(language.Message(result.Language, language.EntityRefused), match.Column)
+	result.Refusal = match
+	result.Completed = true
+	result.Messages = append(messages, openai.AssistantMessage(result.Answer))
+	return &result, nil
+}
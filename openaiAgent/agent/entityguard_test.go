@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/language"
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+)
+
+// Question about one store, Store_Number is the sensitive column of these tests
+const individualQuestion = "How much did store 110 sell?"
+
+func TestEntityGuardAllowed(t *testing.T) {
+	t.Setenv(EntityGuardAllowlistEnv, "ana@example.com, bob ,")
+
+	cases := map[string]bool{
+		"ana@example.com": true,
+		"bob":             true,
+		"":                false,
+		"eve":             false,
+		"ana":             false,
+	}
+	for principal, want := range cases {
+		if got := entityGuardAllowed(principal); got != want {
+			t.Errorf("expected '%s' allowed to be %t", principal, want)
+		}
+	}
+}
+
+func TestEntityGuardRefuses(t *testing.T) {
+	cases := []struct {
+		language string
+		prompt   string
+	}{
+		{language: "", prompt: individualQuestion},
+		{language: "es", prompt: "¿Cuánto vendió la tienda? store 110"},
+	}
+
+	for _, c := range cases {
+		t.Run("language "+c.language, func(t *testing.T) {
+			completer := mock.NewCompleter()
+			agent := newTestAgent(t, Config{SensitiveColumns: []string{"store_number"}, Language: c.language}, completer)
+
+			result, err := agent.Run(context.Background(), c.prompt)
+			if err != nil {
+				t.Fatalf("run failed: %s", err)
+			}
+
+			want := fmt.Sprintf(language.Message(c.language, language.EntityRefused), "Store_Number")
+			if result.Answer != want {
+				t.Fatalf("expected refusal %q, got %q", want, result.Answer)
+			}
+			if result.Refusal == nil || result.Refusal.Column != "Store_Number" || result.Refusal.Value != "110" {
+				t.Fatalf("expected a refusal on Store_Number 110, got %+v", result.Refusal)
+			}
+			if !result.Completed {
+				t.Fatal("expected the refused run to be completed")
+			}
+			if len(completer.Requests) != 0 {
+				t.Fatalf("expected no completions for a refused question, got %d", len(completer.Requests))
+			}
+		})
+	}
+}
+
+// Allowlisted principals get their questions about individuals answered, on the agent or a derived one
+func TestEntityGuardAllowlistOverride(t *testing.T) {
+	t.Setenv(EntityGuardAllowlistEnv, "analyst")
+
+	cases := []struct {
+		name      string
+		principal string // Principal of the agent
+		derived   string // Principal of the agent derived with WithPrincipal, if any
+		refused   bool
+	}{
+		{name: "allowlisted principal", principal: "analyst"},
+		{name: "other principal", principal: "viewer", refused: true},
+		{name: "derived allowlisted principal", principal: "viewer", derived: "analyst"},
+		{name: "derived other principal", principal: "analyst", derived: "viewer", refused: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			completer := mock.NewCompleter(mock.TextResponse("Store 110 sold 19.5."))
+			agent := newTestAgent(t, Config{SensitiveColumns: []string{"Store_Number"}, Principal: c.principal}, completer)
+			if c.derived != "" {
+				agent = agent.WithPrincipal(c.derived, "")
+			}
+
+			result, err := agent.Run(context.Background(), individualQuestion)
+			if err != nil {
+				t.Fatalf("run failed: %s", err)
+			}
+
+			if refused := result.Refusal != nil; refused != c.refused {
+				t.Fatalf("expected refused to be %t, got answer %q", c.refused, result.Answer)
+			}
+			if !c.refused && (!strings.HasPrefix(result.Answer, "Store 110 sold 19.5.") || len(completer.Requests) == 0) {
+				t.Fatalf("expected the question answered by the LLM, got %q", result.Answer)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package agent_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+// Embed the agent in another program. The mock completer stands in for OpenAI, replying with a lookup
+// tool call, the SQL the lookup generates and the final answer
+func Example() {
+	outputDir, err := os.MkdirTemp("", "agent-example-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	dataPath, _ := filepath.Abs("../testdata/datasets/sales.parquet")
+	toolsJsonPath, _ := filepath.Abs(filepath.Join("..", tools.DefaultToolsJsonPath))
+	salesAgent, err := agent.New(agent.Config{
+		DataPath:      dataPath,
+		ToolsJsonPath: toolsJsonPath,
+		OutputDir:     outputDir,
+		Completer: mock.NewCompleter(
+			mock.ToolCallResponse("call_1", tools.LookUpFuncName, map[string]string{"prompt": "Total sales value"}),
+			mock.TextResponse("SELECT round(sum(Total_Sale_Value), 2) AS total_sales FROM sales"),
+			mock.TextResponse("Total sales were 66.75."),
+		),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer salesAgent.Close()
+
+	result, err := salesAgent.Run(context.Background(), "What were the total sales?")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(result.Answer)
+	fmt.Println(result.ToolCalls, "tool call,", result.Iterations, "router calls")
+	fmt.Println(result.Artifacts)
+	// Output:
+	// Total sales were 66.75.
+	// 1 tool call, 2 router calls
+	// [lookup-1.csv]
+}
@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/openai/openai-go"
+)
+
+/*
+-------------
+Load shedding
+-------------
+*/
+
+// Latencies of the most recent requests the p95 is computed over
+const latencyWindow = 100
+
+// Load shedding settings of a server running the agent
+type LoadShedding struct {
+	MaxInFlight    int           // Requests served at once, the ones over it are rejected with 429. Zero never rejects
+	RequestTimeout time.Duration // Deadline set on each request context, so runs near it degrade. Zero sets none
+}
+
+// HTTP middleware bounding the requests served at once and tracking their recent latencies.
+// Rejected requests get a Retry-After of the recent p95 latency, so clients come back once a slot likely freed
+type LoadShedder struct {
+	settings LoadShedding
+	inFlight atomic.Int64
+	shed     atomic.Int64
+
+	mutex     sync.Mutex
+	latencies []time.Duration // Ring of the latencies of the last latencyWindow served requests
+	next      int
+}
+
+// Create a load shedder with the given settings
+func NewLoadShedder(settings LoadShedding) *LoadShedder {
+	return &LoadShedder{settings: settings, latencies: []time.Duration{}}
+}
+
+// Wrap a handler, rejecting requests over the in flight cap with 429 and Retry-After
+func (s *LoadShedder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight := s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+
+		if s.settings.MaxInFlight > 0 && inFlight > int64(s.settings.MaxInFlight) {
+			s.shed.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(s.RetryAfterSeconds()))
+			http.Error(w, fmt.Sprintf("server is at its limit of %d requests in flight, retry later", s.settings.MaxInFlight), http.StatusTooManyRequests)
+			return
+		}
+
+		if s.settings.RequestTimeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), s.settings.RequestTimeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		s.record(time.Since(start))
+	})
+}
+
+// Add the latency of a served request to the window
+func (s *LoadShedder) record(latency time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.latencies) < latencyWindow {
+		s.latencies = append(s.latencies, latency)
+		return
+	}
+	s.latencies[s.next] = latency
+	s.next = (s.next + 1) % latencyWindow
+}
+
+// 95th percentile latency of the recent served requests, zero before any
+func (s *LoadShedder) P95() time.Duration {
+	s.mutex.Lock()
+	latencies := slices.Clone(s.latencies)
+	s.mutex.Unlock()
+
+	if len(latencies) == 0 {
+		return 0
+	}
+
+	slices.Sort(latencies)
+	return latencies[int(math.Ceil(0.95*float64(len(latencies))))-1]
+}
+
+// Seconds rejected clients are told to wait: the recent p95 latency rounded up, at least 1
+func (s *LoadShedder) RetryAfterSeconds() int {
+	return max(1, int(math.Ceil(s.P95().Seconds())))
+}
+
+// Requests being served or rejected right now
+func (s *LoadShedder) InFlight() int {
+	return int(s.inFlight.Load())
+}
+
+// Requests rejected so far
+func (s *LoadShedder) Shed() int {
+	return int(s.shed.Load())
+}
+
+/*
+-------------
+Degraded runs
+-------------
+*/
+
+// Time left before the run deadline under which optional steps are skipped by default
+const DefaultDegradeFloor = 10 * time.Second
+
+// Optional steps a run skips when its deadline is near
+const (
+	DegradedFollowUps       = "follow_ups"       // Follow up suggestions
+	DegradedUngroundedRetry = "ungrounded_retry" // Router call verifying figures stated without data, the answer is warned about instead
+	DegradedCharts          = "charts"           // Chart generation, the visualization tool isn't offered
+)
+
+const degradedChartsPrompt = "Charts are unavailable for this question because it must be answered quickly." +
+	" Don't offer or attempt to generate a visualization."
+
+// Optional steps the run of ctx skips, because its deadline leaves less than the degrade floor.
+// Only steps the agent would make are listed, nil when the run isn't degraded
+func (a *Agent) degradedSteps(ctx context.Context) []string {
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) >= a.config.DegradeFloor {
+		return nil
+	}
+
+	steps := []string{}
+	if a.config.SuggestFollowUps {
+		steps = append(steps, DegradedFollowUps)
+	}
+	if a.config.Ungrounded == UngroundedRetry {
+		steps = append(steps, DegradedUngroundedRetry)
+	}
+	if slices.ContainsFunc(a.toolParams(), isVisualizeParam) {
+		steps = append(steps, DegradedCharts)
+	}
+	if len(steps) == 0 {
+		return nil
+	}
+
+	return steps
+}
+
+// Whether a tool param is the visualization tool
+func isVisualizeParam(param openai.ChatCompletionToolParam) bool {
+	return param.Function.Value.Name.Value == tools.VisualizeFuncName
+}
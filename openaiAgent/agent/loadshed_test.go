@@ -0,0 +1,185 @@
+package agent
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/openai/openai-go"
+)
+
+// Body of the test server answers
+type loadShedAnswer struct {
+	Answer   string   `json:"answer"`
+	Degraded []string `json:"degraded"`
+}
+
+// Serve runs of agent on a test server behind a load shedder. Each request body is a question, answered as a loadShedAnswer
+func serveLoadShedded(t *testing.T, agent *Agent, shedding LoadShedding) (*httptest.Server, *LoadShedder) {
+	t.Helper()
+	shedder := NewLoadShedder(shedding)
+	server := httptest.NewServer(shedder.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		question, _ := io.ReadAll(r.Body)
+		result, err := agent.Run(r.Context(), string(question))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(loadShedAnswer{Answer: result.Answer, Degraded: result.Degraded})
+	})))
+	t.Cleanup(server.Close)
+
+	return server, shedder
+}
+
+// Ask a question to the test server, returning the response with its body read. Nil when the request failed,
+// which fails the test
+func ask(t *testing.T, server *httptest.Server, question string) (*http.Response, []byte) {
+	response, err := server.Client().Post(server.URL, "text/plain", strings.NewReader(question))
+	if err != nil {
+		t.Error(err)
+		return nil, nil
+	}
+	defer response.Body.Close()
+
+	body, _ := io.ReadAll(response.Body)
+	return response, body
+}
+
+// Fill the in flight cap with slow runs, every request over it is rejected with 429 and a Retry-After
+// while the admitted runs still answer
+func TestLoadShedding(t *testing.T) {
+	const maxInFlight, overflow = 2, 3
+	completer := mock.NewCompleter(mock.TextResponse("Sales were steady."), mock.TextResponse("Sales were steady."))
+	completer.Delay = 500 * time.Millisecond
+	server, shedder := serveLoadShedded(t, newTestAgent(t, Config{}, completer), LoadShedding{MaxInFlight: maxInFlight})
+
+	wait := sync.WaitGroup{}
+	for range maxInFlight {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			if response, body := ask(t, server, "How were sales?"); response != nil && response.StatusCode != http.StatusOK {
+				t.Errorf("admitted request got status %d: %s", response.StatusCode, body)
+			}
+		}()
+	}
+
+	// The admitted runs wait on the mock completer, so the cap stays full for its delay
+	deadline := time.Now().Add(completer.Delay)
+	for shedder.InFlight() < maxInFlight && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if shedder.InFlight() < maxInFlight {
+		t.Fatalf("only %d requests in flight, expected %d", shedder.InFlight(), maxInFlight)
+	}
+
+	for range overflow {
+		response, _ := ask(t, server, "How were sales?")
+		if response == nil {
+			t.FailNow()
+		}
+		if response.StatusCode != http.StatusTooManyRequests || response.Header.Get("Retry-After") != "1" {
+			t.Fatalf("expected 429 with a Retry-After of 1s, got %d with '%s'", response.StatusCode, response.Header.Get("Retry-After"))
+		}
+	}
+	wait.Wait()
+
+	if shedder.Shed() != overflow {
+		t.Errorf("shed %d requests, expected %d", shedder.Shed(), overflow)
+	}
+	if len(completer.Requests) != maxInFlight {
+		t.Errorf("made %d completions, expected only the %d admitted runs", len(completer.Requests), maxInFlight)
+	}
+	if p95 := shedder.P95(); p95 < completer.Delay {
+		t.Errorf("expected a p95 latency of at least the completion delay, got %s", p95)
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	shedder := NewLoadShedder(LoadShedding{})
+	if seconds := shedder.RetryAfterSeconds(); seconds != 1 {
+		t.Fatalf("expected 1s before any request, got %d", seconds)
+	}
+
+	for i := range 100 {
+		shedder.record(time.Duration(i+1) * 100 * time.Millisecond)
+	}
+	if p95, seconds := shedder.P95(), shedder.RetryAfterSeconds(); p95 != 9500*time.Millisecond || seconds != 10 {
+		t.Fatalf("expected a p95 of 9.5s rounded up to 10s, got %s and %ds", p95, seconds)
+	}
+}
+
+// Whether a completion request offered the visualization tool
+func offersCharts(request openai.ChatCompletionNewParams) bool {
+	return slices.ContainsFunc(request.Tools.Value, func(tool openai.ChatCompletionToolParam) bool {
+		return tool.Function.Value.Name.Value == tools.VisualizeFuncName
+	})
+}
+
+// Runs with a request timeout under the degrade floor skip follow ups and charts and say so, the others run every step
+func TestDegradedRuns(t *testing.T) {
+	cases := []struct {
+		name      string
+		timeout   time.Duration
+		responses []*openai.ChatCompletion
+		degraded  []string
+	}{
+		{
+			name:      "near deadline",
+			timeout:   DefaultDegradeFloor / 2,
+			responses: []*openai.ChatCompletion{mock.TextResponse("Sales were steady.")},
+			degraded:  []string{DegradedFollowUps, DegradedCharts},
+		},
+		{
+			name:    "far deadline",
+			timeout: 2 * DefaultDegradeFloor,
+			responses: []*openai.ChatCompletion{
+				mock.TextResponse("Sales were steady."),
+				mock.TextResponse(`{"followUps": ["Which store sold the most?", "How did promotions do?"]}`),
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			completer := mock.NewCompleter(c.responses...)
+			agent := newTestAgent(t, Config{SuggestFollowUps: true, DegradeFloor: DefaultDegradeFloor}, completer)
+			server, _ := serveLoadShedded(t, agent, LoadShedding{RequestTimeout: c.timeout})
+
+			response, body := ask(t, server, "How were sales?")
+			if response == nil {
+				t.FailNow()
+			}
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d: %s", response.StatusCode, body)
+			}
+			answer := loadShedAnswer{}
+			if err := json.Unmarshal(body, &answer); err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(answer.Degraded, c.degraded) {
+				t.Fatalf("degraded %v, expected %v", answer.Degraded, c.degraded)
+			}
+			if len(completer.Requests) != len(c.responses) {
+				t.Fatalf("made %d completions, expected %d", len(completer.Requests), len(c.responses))
+			}
+			if degraded := len(c.degraded) != 0; offersCharts(completer.Requests[0]) == degraded {
+				t.Fatalf("expected the visualization tool offered to be %t", !degraded)
+			}
+			// Follow ups only land on the answer when they were made
+			if followUps := strings.Contains(answer.Answer, "Which store sold the most?"); followUps != (len(c.degraded) == 0) {
+				t.Fatalf("expected follow ups on the answer only without degradation: %s", answer.Answer)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/history"
+	"github.com/EzequielGhR/goProjects/openaiAgent/language"
+	"github.com/openai/openai-go"
+)
+
+/*
+-------------------------
+Conversation message aids
+-------------------------
+*/
+
+// Get the text of the latest user message of a conversation
+func lastUserQuestion(messages []openai.ChatCompletionMessageParamUnion) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messageRole(messages[i]) == string(openai.ChatCompletionMessageParamRoleUser) {
+			return messageText(messages[i])
+		}
+	}
+
+	return ""
+}
+
+// Add a system message asking to answer in a language, after the leading system messages.
+// Conversations already holding the instruction are returned as is
+func withLanguageInstruction(
+	messages []openai.ChatCompletionMessageParamUnion,
+	code string,
+) []openai.ChatCompletionMessageParamUnion {
+	return withSystemMessage(messages, language.Instruction(code))
+}
+
+// Add a system message after the leading system messages. Empty texts add nothing,
+// and conversations already holding the message are returned as is
+func withSystemMessage(
+	messages []openai.ChatCompletionMessageParamUnion,
+	text string,
+) []openai.ChatCompletionMessageParamUnion {
+	if text == "" {
+		return messages
+	}
+
+	position := 0
+	for i, message := range messages {
+		if !isSystemMessage(message) {
+			break
+		}
+		if messageText(message) == text {
+			return messages
+		}
+		position = i + 1
+	}
+
+	withMessage := append([]openai.ChatCompletionMessageParamUnion{}, messages[:position]...)
+	withMessage = append(withMessage, openai.SystemMessage(text))
+	return append(withMessage, messages[position:]...)
+}
+
+// Role of a message of any variant of the union. Typed system and developer params may be built without
+// their role field, the other variants, like the generic ChatCompletionMessageParam, are told by the role they encode
+func messageRole(message openai.ChatCompletionMessageParamUnion) string {
+	switch message.(type) {
+	case openai.ChatCompletionSystemMessageParam, *openai.ChatCompletionSystemMessageParam:
+		return string(openai.ChatCompletionMessageParamRoleSystem)
+	case openai.ChatCompletionDeveloperMessageParam, *openai.ChatCompletionDeveloperMessageParam:
+		return string(openai.ChatCompletionMessageParamRoleDeveloper)
+	}
+
+	encoded := struct {
+		Role string `json:"role"`
+	}{}
+	jsonBytes, err := json.Marshal(message)
+	if err != nil || json.Unmarshal(jsonBytes, &encoded) != nil {
+		return ""
+	}
+	return encoded.Role
+}
+
+// Whether a message instructs the model like a system message. Developer messages replace them on newer models
+func isSystemMessage(message openai.ChatCompletionMessageParamUnion) bool {
+	role := messageRole(message)
+	return role == string(openai.ChatCompletionMessageParamRoleSystem) || role == string(openai.ChatCompletionMessageParamRoleDeveloper)
+}
+
+// Text of a message of any variant, from its content string or text parts. Parts that aren't text, like images, are skipped
+func messageText(message openai.ChatCompletionMessageParamUnion) string {
+	switch message.(type) {
+	case openai.ChatCompletionSystemMessageParam, openai.ChatCompletionUserMessageParam:
+		return history.MessageText(message)
+	}
+
+	encoded := struct {
+		Content json.RawMessage `json:"content"`
+	}{}
+	jsonBytes, err := json.Marshal(message)
+	if err != nil || json.Unmarshal(jsonBytes, &encoded) != nil {
+		return ""
+	}
+
+	text := ""
+	if json.Unmarshal(encoded.Content, &text) == nil {
+		return text
+	}
+	parts := []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}{}
+	json.Unmarshal(encoded.Content, &parts)
+	texts := []string{}
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
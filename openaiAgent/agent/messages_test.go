@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go"
+)
+
+// System prompt of the callers of the system message cases
+const callerSystemPrompt = "You are a pricing analyst. Answer in bullet points."
+
+// Conversations with the caller's system message built every way the SDK allows, at the start and further in.
+// Formatted messages are compared as roles, see systemMessageShape
+func TestFormatAgentMessagesSystemMessages(t *testing.T) {
+	question := openai.UserMessage("How were sales?")
+	answer := openai.AssistantMessage("Sales were steady.")
+	systemRole := openai.F(openai.ChatCompletionSystemMessageParamRoleSystem)
+	parts := openai.F([]openai.ChatCompletionContentPartTextParam{openai.TextPart(callerSystemPrompt)})
+
+	builders := map[string]openai.ChatCompletionMessageParamUnion{
+		"SystemMessage":         openai.SystemMessage(callerSystemPrompt),
+		"system param":          openai.ChatCompletionSystemMessageParam{Role: systemRole, Content: parts},
+		"system param no role":  openai.ChatCompletionSystemMessageParam{Content: parts},
+		"system param pointer":  &openai.ChatCompletionSystemMessageParam{Role: systemRole, Content: parts},
+		"developer param":       openai.ChatCompletionDeveloperMessageParam{Role: openai.F(openai.ChatCompletionDeveloperMessageParamRoleDeveloper), Content: parts},
+		"generic param":         openai.ChatCompletionMessageParam{Role: openai.F(openai.ChatCompletionMessageParamRoleSystem), Content: openai.F[any](callerSystemPrompt)},
+		"generic param pointer": &openai.ChatCompletionMessageParam{Role: openai.F(openai.ChatCompletionMessageParamRoleSystem), Content: openai.F[any](parts.Value)},
+	}
+
+	type systemMessageCase struct {
+		name     string
+		messages []openai.ChatCompletionMessageParamUnion
+		merge    bool // Format with Config.MergeSystemPrompt
+		want     []string
+	}
+	cases := []systemMessageCase{
+		{name: "no system message", messages: []openai.ChatCompletionMessageParamUnion{question}, want: []string{"dataset", "user"}},
+		{name: "no system message merged", messages: []openai.ChatCompletionMessageParamUnion{question, answer, question}, merge: true, want: []string{"dataset", "user", "assistant", "user"}},
+		{name: "dataset prompt merged again", messages: []openai.ChatCompletionMessageParamUnion{openai.SystemMessage(systemPrompt + "\n\n" + callerSystemPrompt), question}, merge: true, want: []string{"merged", "user"}},
+	}
+	for _, name := range slices.Sorted(maps.Keys(builders)) {
+		system := builders[name]
+		cases = append(cases,
+			systemMessageCase{name: name, messages: []openai.ChatCompletionMessageParamUnion{system, question}, want: []string{"caller", "untrusted", "user"}},
+			systemMessageCase{name: name + " later", messages: []openai.ChatCompletionMessageParamUnion{question, answer, system, question}, want: []string{"untrusted", "user", "assistant", "caller", "user"}},
+			systemMessageCase{name: name + " merged", messages: []openai.ChatCompletionMessageParamUnion{system, question}, merge: true, want: []string{"merged", "user"}},
+			systemMessageCase{name: name + " later merged", messages: []openai.ChatCompletionMessageParamUnion{question, answer, system, question}, merge: true, want: []string{"user", "assistant", "merged", "user"}},
+		)
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			before := systemMessageShape(c.messages)
+			formatted := formatAgentMessages(c.messages, c.merge)
+			if shape := systemMessageShape(formatted); !slices.Equal(shape, c.want) {
+				t.Fatalf("formatted to %s, expected %s", strings.Join(shape, " "), strings.Join(c.want, " "))
+			}
+			if after := systemMessageShape(c.messages); !slices.Equal(before, after) {
+				t.Fatalf("formatting changed the caller's messages to %s", strings.Join(after, " "))
+			}
+
+			// Formatting again must change nothing
+			if again := systemMessageShape(formatAgentMessages(formatted, c.merge)); !slices.Equal(again, c.want) {
+				t.Fatalf("formatting again gave %s", strings.Join(again, " "))
+			}
+		})
+	}
+}
+
+// Roles of formatted messages, where the system ones are "dataset" for the dataset prompt alone, "caller" for
+// callerSystemPrompt alone, "merged" for both and "untrusted" for the untrusted tool output instruction
+func systemMessageShape(messages []openai.ChatCompletionMessageParamUnion) []string {
+	shape := []string{}
+	for _, message := range messages {
+		if !isSystemMessage(message) {
+			shape = append(shape, messageRole(message))
+			continue
+		}
+
+		text := messageText(message)
+		switch {
+		case text == systemPrompt:
+			shape = append(shape, "dataset")
+		case text == callerSystemPrompt:
+			shape = append(shape, "caller")
+		case text == systemPrompt+"\n\n"+callerSystemPrompt:
+			shape = append(shape, "merged")
+		case text == strings.TrimSpace(untrustedToolOutputPrompt):
+			shape = append(shape, "untrusted")
+		default:
+			shape = append(shape, "system")
+		}
+	}
+
+	return shape
+}
+
+// The latest user message is found and read whatever variant of the union it was built as
+func TestLastUserQuestion(t *testing.T) {
+	question := "Which store sold the most?"
+	userRole := openai.F(openai.ChatCompletionUserMessageParamRoleUser)
+	parts := openai.F([]openai.ChatCompletionContentPartUnionParam{openai.TextPart(question), openai.ImagePart("https://example.com/sales.png")})
+	answer := openai.AssistantMessage("Store 1320 sold the most.")
+	cases := []struct {
+		name     string
+		messages []openai.ChatCompletionMessageParamUnion
+		want     string
+	}{
+		{name: "UserMessage", messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage(question), answer}, want: question},
+		{name: "user param with an image", messages: []openai.ChatCompletionMessageParamUnion{openai.ChatCompletionUserMessageParam{Role: userRole, Content: parts}}, want: question},
+		{name: "user param pointer", messages: []openai.ChatCompletionMessageParamUnion{&openai.ChatCompletionUserMessageParam{Role: userRole, Content: parts}, answer}, want: question},
+		{
+			name:     "generic param",
+			messages: []openai.ChatCompletionMessageParamUnion{openai.ChatCompletionMessageParam{Role: openai.F(openai.ChatCompletionMessageParamRoleUser), Content: openai.F[any](question)}},
+			want:     question,
+		},
+		{
+			name:     "generic param pointer",
+			messages: []openai.ChatCompletionMessageParamUnion{&openai.ChatCompletionMessageParam{Role: openai.F(openai.ChatCompletionMessageParamRoleUser), Content: openai.F[any](parts.Value)}, answer},
+			want:     question,
+		},
+		{
+			name:     "latest question",
+			messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("How were sales?"), answer, openai.UserMessage(question), answer},
+			want:     question,
+		},
+		{name: "no question", messages: []openai.ChatCompletionMessageParamUnion{openai.SystemMessage(callerSystemPrompt), answer}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := lastUserQuestion(c.messages); got != c.want {
+				t.Errorf("last question '%s', expected '%s'", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/openai/openai-go"
+)
+
+/*
+-----------------
+Tool call pairing
+-----------------
+*/
+
+// Repairs made so every tool call of a conversation has exactly one result right after its assistant message.
+// OpenAI rejects conversations breaking it with an opaque 400 about tool_call_id
+type PairingRepair struct {
+	Missing    []string `json:"missing,omitempty"`    // Tool call IDs without a result, answered with a synthesized tool error
+	Duplicates []string `json:"duplicates,omitempty"` // Tool call IDs with several results, only the first one is kept
+	Orphans    []string `json:"orphans,omitempty"`    // Tool call IDs of results answering no call of their turn, dropped
+	Moved      []string `json:"moved,omitempty"`      // Tool call IDs of results found after other messages, moved after their calls
+}
+
+// Whether nothing was repaired
+func (r PairingRepair) Empty() bool {
+	return len(r.Missing) == 0 && len(r.Duplicates) == 0 && len(r.Orphans) == 0 && len(r.Moved) == 0
+}
+
+func (r PairingRepair) String() string {
+	parts := []string{}
+	for _, repair := range []struct {
+		name string
+		ids  []string
+	}{{"missing", r.Missing}, {"duplicated", r.Duplicates}, {"orphan", r.Orphans}, {"moved", r.Moved}} {
+		if len(repair.ids) != 0 {
+			parts = append(parts, fmt.Sprintf("%s %s", repair.name, strings.Join(repair.ids, ", ")))
+		}
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// Span attributes of a repair
+func (r PairingRepair) attributes() map[string]any {
+	return map[string]any{
+		"agent.pairing.missing_tool_call_ids":   r.Missing,
+		"agent.pairing.duplicate_tool_call_ids": r.Duplicates,
+		"agent.pairing.orphan_tool_call_ids":    r.Orphans,
+		"agent.pairing.moved_tool_call_ids":     r.Moved,
+	}
+}
+
+// Tool call IDs of an assistant message, false if the message isn't from the assistant
+func assistantToolCallIDs(message openai.ChatCompletionMessageParamUnion) ([]string, bool) {
+	ids := []string{}
+	switch assistantMessage := message.(type) {
+	case openai.ChatCompletionMessage:
+		for _, toolCall := range assistantMessage.ToolCalls {
+			ids = append(ids, toolCall.ID)
+		}
+	case openai.ChatCompletionAssistantMessageParam:
+		for _, toolCall := range assistantMessage.ToolCalls.Value {
+			ids = append(ids, toolCall.ID.Value)
+		}
+	default:
+		return nil, false
+	}
+
+	return ids, true
+}
+
+// Check every tool call has exactly one result right after its assistant message, and every result answers a call.
+// A turn lasts until the next assistant message: its results found after other messages are moved after the calls,
+// repeated results and results of no call of the turn are dropped, and calls without results get a synthesized
+// retryable tool error. Conversations that hold the invariant are returned unchanged
+func repairToolPairing(messages []openai.ChatCompletionMessageParamUnion) ([]openai.ChatCompletionMessageParamUnion, PairingRepair) {
+	repair := PairingRepair{}
+	repaired := make([]openai.ChatCompletionMessageParamUnion, 0, len(messages))
+	for i := 0; i < len(messages); i++ {
+		// Results of a turn are taken along with its calls, any met here answers no call
+		if toolMessage, ok := messages[i].(openai.ChatCompletionToolMessageParam); ok {
+			repair.Orphans = append(repair.Orphans, toolMessage.ToolCallID.Value)
+			continue
+		}
+
+		repaired = append(repaired, messages[i])
+		callIDs, _ := assistantToolCallIDs(messages[i])
+		if len(callIDs) == 0 {
+			continue
+		}
+
+		end := i + 1
+		for end < len(messages) {
+			if _, isAssistant := assistantToolCallIDs(messages[end]); isAssistant {
+				break
+			}
+			end++
+		}
+
+		results, others := []openai.ChatCompletionMessageParamUnion{}, []openai.ChatCompletionMessageParamUnion{}
+		answered := map[string]bool{}
+		for _, message := range messages[i+1 : end] {
+			toolMessage, ok := message.(openai.ChatCompletionToolMessageParam)
+			if !ok {
+				others = append(others, message)
+				continue
+			}
+
+			id := toolMessage.ToolCallID.Value
+			switch {
+			case !slices.Contains(callIDs, id):
+				repair.Orphans = append(repair.Orphans, id)
+			case answered[id]:
+				repair.Duplicates = append(repair.Duplicates, id)
+			default:
+				answered[id] = true
+				results = append(results, message)
+				if len(others) != 0 {
+					repair.Moved = append(repair.Moved, id)
+				}
+			}
+		}
+
+		for _, id := range callIDs {
+			if !answered[id] {
+				repair.Missing = append(repair.Missing, id)
+				results = append(results, openai.ToolMessage(id, tools.FormatToolError(&tools.ErrMissingToolResult{ToolCallID: id})))
+			}
+		}
+
+		repaired = append(repaired, results...)
+		repaired = append(repaired, others...)
+		i = end - 1
+	}
+
+	if repair.Empty() {
+		return messages, repair
+	}
+	return repaired, repair
+}
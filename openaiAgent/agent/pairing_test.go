@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/openai/openai-go"
+)
+
+// Assistant message calling tools with the given IDs
+func pairingToolCalls(ids ...string) openai.ChatCompletionMessage {
+	toolCalls := []openai.ChatCompletionMessageToolCall{}
+	for _, id := range ids {
+		toolCalls = append(toolCalls, openai.ChatCompletionMessageToolCall{
+			ID:       id,
+			Type:     openai.ChatCompletionMessageToolCallTypeFunction,
+			Function: openai.ChatCompletionMessageToolCallFunction{Name: tools.LookUpFuncName, Arguments: "{}"},
+		})
+	}
+
+	return openai.ChatCompletionMessage{Role: openai.ChatCompletionMessageRoleAssistant, ToolCalls: toolCalls}
+}
+
+// Missing, duplicated, out of order and orphan tool results are repaired, a valid conversation is left alone
+func TestRepairToolPairing(t *testing.T) {
+	question := openai.UserMessage("How were sales?")
+	answer := openai.ChatCompletionMessage{Role: openai.ChatCompletionMessageRoleAssistant, Content: "Sales were steady."}
+	result := func(id string) openai.ChatCompletionMessageParamUnion { return openai.ToolMessage(id, "[]") }
+
+	cases := []struct {
+		name     string
+		messages []openai.ChatCompletionMessageParamUnion
+		repair   PairingRepair
+		expected []string // Messages after the repair, as role or tool:<id>
+	}{
+		{
+			name:     "valid",
+			messages: []openai.ChatCompletionMessageParamUnion{question, pairingToolCalls("a", "b"), result("b"), result("a"), answer},
+			expected: []string{"user", "assistant", "tool:b", "tool:a", "assistant"},
+		},
+		{
+			name:     "missing",
+			messages: []openai.ChatCompletionMessageParamUnion{question, pairingToolCalls("a", "b"), result("a")},
+			repair:   PairingRepair{Missing: []string{"b"}},
+			expected: []string{"user", "assistant", "tool:a", "tool:b"},
+		},
+		{
+			name:     "duplicated",
+			messages: []openai.ChatCompletionMessageParamUnion{question, pairingToolCalls("a"), result("a"), result("a")},
+			repair:   PairingRepair{Duplicates: []string{"a"}},
+			expected: []string{"user", "assistant", "tool:a"},
+		},
+		{
+			name: "out of order",
+			messages: []openai.ChatCompletionMessageParamUnion{
+				question, pairingToolCalls("a", "b"), result("a"), openai.SystemMessage("Verify the figures."), result("b"),
+			},
+			repair:   PairingRepair{Moved: []string{"b"}},
+			expected: []string{"user", "assistant", "tool:a", "tool:b", "system"},
+		},
+		{
+			name: "orphans",
+			messages: []openai.ChatCompletionMessageParamUnion{
+				result("x"), question, pairingToolCalls("a"), result("a"), result("y"), pairingToolCalls("b"), result("a"), result("b"),
+			},
+			repair:   PairingRepair{Orphans: []string{"x", "y", "a"}},
+			expected: []string{"user", "assistant", "tool:a", "assistant", "tool:b"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			repaired, repair := repairToolPairing(c.messages)
+			if repair.String() != c.repair.String() {
+				t.Fatalf("repaired '%s', expected '%s'", repair, c.repair)
+			}
+			if shape := pairingShape(repaired); !slices.Equal(shape, c.expected) {
+				t.Fatalf("repaired to %s, expected %s", strings.Join(shape, " "), strings.Join(c.expected, " "))
+			}
+
+			// Repairing again must change nothing
+			if _, again := repairToolPairing(repaired); !again.Empty() {
+				t.Fatalf("repairing the repaired messages again repaired '%s'", again)
+			}
+		})
+	}
+}
+
+// Roles of messages as the expected messages of the cases list them
+func pairingShape(messages []openai.ChatCompletionMessageParamUnion) []string {
+	shape := []string{}
+	for _, message := range messages {
+		if _, isAssistant := assistantToolCallIDs(message); isAssistant {
+			shape = append(shape, "assistant")
+			continue
+		}
+
+		switch typed := message.(type) {
+		case openai.ChatCompletionToolMessageParam:
+			shape = append(shape, "tool:"+typed.ToolCallID.Value)
+		case openai.ChatCompletionSystemMessageParam:
+			shape = append(shape, "system")
+		case openai.ChatCompletionUserMessageParam:
+			shape = append(shape, "user")
+		default:
+			shape = append(shape, "unknown")
+		}
+	}
+
+	return shape
+}
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+)
+
+/*
+-------------
+Past analyses
+-------------
+*/
+
+// Open the past analyses index of a config, nil when Config.AnalysesIndexPath is empty. Without an Embedder
+// the shared OpenAI client embeds, so its API key is needed. Tools allowlists naming SearchPastAnalyses need
+// an index, and empty ones get the tool with it
+func newAnalysisIndex(config Config, toolsAllowlistEmpty bool) (*tools.AnalysisIndex, []string, error) {
+	allowed := config.Tools
+	if config.AnalysesIndexPath == "" {
+		if slices.Contains(allowed, tools.SearchPastAnalysesFuncName) {
+			return nil, nil, fmt.Errorf("tool '%s' needs a past analyses index, pass -analyses-index", tools.SearchPastAnalysesFuncName)
+		}
+		return nil, allowed, nil
+	}
+
+	embedder := config.Embedder
+	if embedder == nil {
+		client, err := completion.GetOpenaiClient()
+		if !config.HTTP.IsZero() {
+			client, err = completion.NewOpenaiClient(config.HTTP)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		embedder = client.Embeddings
+	}
+
+	if toolsAllowlistEmpty {
+		allowed = append(allowed, tools.SearchPastAnalysesFuncName)
+	}
+
+	return tools.NewAnalysisIndex(config.AnalysesIndexPath, embedder, config.EmbeddingModel), allowed, nil
+}
+
+// Embed the question and answer of a completed run into the past analyses index.
+// Failures are only logged, the run already answered
+func (a *Agent) indexAnalysis(agentCtx context.Context, result RunResult) {
+	if a.pastAnalyses == nil || !result.Completed || result.Prompt == "" {
+		return
+	}
+
+	ctx, span := a.tracer.StartOpenInferenceSpan(agentCtx, "IndexAnalysis", tracing.EmbeddingKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	tracing.SetSpanInput(span, result.Prompt)
+	tracing.SetSpanAttr(span, "embedding.model_name", a.pastAnalyses.Model())
+
+	analysis := tools.PastAnalysis{RunID: result.RunID, Date: time.Now().UTC(), Question: result.Prompt, Answer: result.Answer}
+	if err := a.pastAnalyses.Add(ctx, analysis); err != nil {
+		log.Printf("WARNING: Failed to index the analysis of run '%s': %s\n", result.RunID, err)
+		tracing.SetSpanErrorCode(span)
+		return
+	}
+
+	tracing.SetSpanOutput(span, result.RunID)
+	tracing.SetSpanSuccessCode(span)
+}
+
+// Past analyses most similar to a query, like SearchPastAnalyses finds them. Fails without an index,
+// and with tools.ErrCorruptIndex when it can't be read back
+func (a *Agent) SearchPastAnalyses(ctx context.Context, query string, k int) ([]tools.PastAnalysisMatch, error) {
+	if a.pastAnalyses == nil {
+		return nil, fmt.Errorf("no past analyses index is configured, pass -analyses-index")
+	}
+
+	return a.pastAnalyses.Search(completion.WithEndUser(ctx, a.config.User), query, k)
+}
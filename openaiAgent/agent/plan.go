@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/openai/openai-go"
+)
+
+/*
+----------------
+Dry run planning
+----------------
+*/
+
+// Planned and actual tool sequences of a run made with a plan
+type PlanDivergence struct {
+	Planned  []string `json:"planned"`
+	Actual   []string `json:"actual"`
+	Distance int      `json:"distance"` // Tool calls to add, remove or replace to turn the plan into the actual sequence
+}
+
+// Whether the run called other tools than planned
+func (d PlanDivergence) Diverged() bool {
+	return d.Distance != 0
+}
+
+// Plan the tool calls to answer a question without executing any tool.
+// The plan can be reviewed before spending tokens on RunWithPlan
+func (a *Agent) Plan(ctx context.Context, question string) (tools.Plan, error) {
+	toolDescriptions := []string{}
+	for _, param := range a.toolParams() {
+		function := param.Function.Value
+		toolDescriptions = append(toolDescriptions, fmt.Sprintf("- %s: %s", function.Name.Value, function.Description.Value))
+	}
+
+	completer := tools.NewRequestRecorder(a.completer)
+	toolbox := tools.NewToolbox(tools.Config{Model: a.config.Model}, completer, a.store, a.tracer, a.audit)
+	return toolbox.PlanRun(ctx, question, toolDescriptions)
+}
+
+// Run the agent on a prompt with a plan from Plan as a system hint.
+// The divergence between the plan and the tools actually called is set on the result
+func (a *Agent) RunWithPlan(ctx context.Context, prompt string, plan tools.Plan) (RunResult, error) {
+	messages := formatAgentMessages(prompt, a.config.MergeSystemPrompt)
+	hint := openai.SystemMessage("A plan was made for this question, follow it unless the tool results call for a change:\n" + plan.String())
+
+	// The hint goes right before the user prompt
+	messages = append(messages[:len(messages)-1:len(messages)-1], hint, messages[len(messages)-1])
+	return a.run(ctx, messages, runOptions{plan: &plan})
+}
+
+// Compare the planned tool sequence with the tools called on the run messages
+func planDivergence(plan tools.Plan, messages []openai.ChatCompletionMessageParamUnion) PlanDivergence {
+	actual := calledTools(messages)
+	planned := plan.Tools()
+	return PlanDivergence{Planned: planned, Actual: actual, Distance: editDistance(planned, actual)}
+}
+
+// Levenshtein distance between two sequences of names
+func editDistance(a []string, b []string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			substitution := previous[j-1]
+			if a[i-1] != b[j-1] {
+				substitution++
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, substitution)
+		}
+		previous = current
+	}
+
+	return previous[len(b)]
+}
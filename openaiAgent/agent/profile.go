@@ -0,0 +1,30 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+/*
+-----------------
+Dataset profiling
+-----------------
+*/
+
+// Introduces the dataset profile on the router prompt
+const profilePrompt = "Overview of the dataset, use it to pick columns and values:\n"
+
+// Profile the dataset: null rates, distinct counts, numeric distributions, top categorical values and date coverage.
+// The profile is cached in the database until the data file changes. narrative adds an LLM summary paragraph
+func (a *Agent) Profile(ctx context.Context, narrative bool) (tools.DatasetProfile, error) {
+	completer := tools.NewRequestRecorder(a.completer)
+	toolbox := tools.NewToolbox(
+		tools.Config{Model: a.config.Model, DataPath: a.config.DataPath, Language: a.config.Language},
+		completer,
+		a.store,
+		a.tracer,
+		a.audit,
+	)
+	return toolbox.ProfileDataset(ctx, narrative)
+}
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+/*
+-----------------
+Answer provenance
+-----------------
+*/
+
+// SQL statement executed for an answer
+type ProvenanceQuery struct {
+	Tool       string `json:"tool"`
+	SQL        string `json:"sql"`
+	RowCount   int    `json:"rowCount"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Where an answer came from, assembled from the run records instead of asking the model
+type Provenance struct {
+	Steps        []string          `json:"steps"`   // Tools called by the router, in order
+	Queries      []ProvenanceQuery `json:"queries"` // SQL executed by the tools, in order
+	RowsReturned int               `json:"rowsReturned"`
+	Models       []string          `json:"models"` // Models of the completions made, in order of first use
+	Completions  int               `json:"completions"`
+}
+
+// Assemble the provenance of a run from its messages, queries and completions
+func NewProvenance(result RunResult) Provenance {
+	provenance := Provenance{
+		Steps:        calledTools(result.Messages),
+		Queries:      []ProvenanceQuery{},
+		RowsReturned: result.Retrieval.RowsReturned,
+		Models:       []string{},
+		Completions:  len(result.Completions),
+	}
+
+	for _, query := range result.Queries {
+		provenance.Queries = append(provenance.Queries, ProvenanceQuery{
+			Tool:       query.Tool,
+			SQL:        query.SQL,
+			RowCount:   query.RowCount,
+			DurationMs: query.DurationMs,
+			Error:      query.Error,
+		})
+	}
+
+	for _, call := range result.Completions {
+		if call.Model != "" && !slices.Contains(provenance.Models, call.Model) {
+			provenance.Models = append(provenance.Models, call.Model)
+		}
+	}
+
+	return provenance
+}
+
+// Render the provenance as a Markdown appendix for the answer
+func (p Provenance) Markdown() string {
+	builder := strings.Builder{}
+	builder.WriteString("---\n### How this answer was produced\n\n")
+
+	steps := "no tools"
+	if len(p.Steps) > 0 {
+		steps = strings.Join(p.Steps, " → ")
+	}
+	fmt.Fprintf(&builder, "**Steps:** %s\n\n", steps)
+	fmt.Fprintf(&builder, "**Models:** %s (%d completions)\n\n", strings.Join(p.Models, ", "), p.Completions)
+
+	if len(p.Queries) == 0 {
+		builder.WriteString("**Queries:** none\n")
+		return builder.String()
+	}
+
+	fmt.Fprintf(&builder, "**Queries** (%d rows returned to the model):\n", p.RowsReturned)
+	for i, query := range p.Queries {
+		outcome := fmt.Sprintf("%d rows in %d ms", query.RowCount, query.DurationMs)
+		if query.Error != "" {
+			outcome = "failed: " + query.Error
+		}
+		fmt.Fprintf(&builder, "\n%d. %s, %s\n\n```sql\n%s\n```\n", i+1, query.Tool, outcome, strings.TrimSpace(query.SQL))
+	}
+
+	return builder.String()
+}
+
+// Tools called by the router responses of a run, in order. Earlier conversation turns are params and left out
+func calledTools(messages []openai.ChatCompletionMessageParamUnion) []string {
+	called := []string{}
+	for _, message := range messages {
+		if response, ok := message.(openai.ChatCompletionMessage); ok {
+			for _, toolCall := range response.ToolCalls {
+				called = append(called, toolCall.Function.Name)
+			}
+		}
+	}
+
+	return called
+}
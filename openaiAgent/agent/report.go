@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+)
+
+/*
+-------------
+Named reports
+-------------
+*/
+
+// Rendering of a saved report, made on a run of its own
+type ReportRun struct {
+	tools.ReportResult
+	RunID   string // Identifier of the run, also the name of its artifacts directory
+	RunDir  string // Directory holding the report data and chart code
+	TraceID string // Trace ID of the ReportRun span, empty if the tracer records nothing
+}
+
+// Reports saved by the visualization tool of the agent runs
+func (a *Agent) Reports() *tools.ReportStore {
+	return tools.NewReportStore(a.config.ReportsDir)
+}
+
+// Render a saved report on the current data: its query runs again and its chart code is written
+// with the stored config, without any completion
+func (a *Agent) RunReport(ctx context.Context, name string) (ReportRun, error) {
+	a.dbMutex.RLock()
+	defer a.dbMutex.RUnlock()
+
+	reportCtx, span := a.tracer.StartOpenInferenceSpan(ctx, "ReportRun", tracing.ChainKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	tracing.SetSpanInput(span, name)
+
+	report, err := a.Reports().Load(name)
+	if err != nil {
+		tracing.SetSpanErrorCode(span)
+		return ReportRun{}, err
+	}
+
+	toolbox := tools.NewToolbox(
+		tools.Config{
+			Model:      a.config.Model,
+			DataPath:   a.config.DataPath,
+			OutputDir:  a.config.OutputDir,
+			ReportsDir: a.config.ReportsDir,
+			ThemesDir:  a.config.ThemesDir,
+			ChartTheme: a.chartTheme,
+			Publisher:  a.config.Publisher,
+		},
+		a.completer,
+		a.store,
+		a.tracer,
+		a.audit,
+	)
+	runID, err := toolbox.StartRun(tracing.ShortTraceID(reportCtx))
+	if err != nil {
+		tracing.SetSpanErrorCode(span)
+		return ReportRun{}, err
+	}
+
+	reportResult, err := toolbox.RunReport(reportCtx, report)
+	reportRun := ReportRun{ReportResult: reportResult, RunID: runID, RunDir: toolbox.RunDir, TraceID: tracing.TraceID(reportCtx)}
+	tracing.SetSpanAttr(span, "agent.run_id", runID)
+	if err != nil {
+		tracing.SetSpanErrorCode(span)
+		return reportRun, err
+	}
+
+	tracing.SetSpanOutput(span, reportResult.ArtifactPath)
+	tracing.SetSpanSuccessCode(span)
+	return reportRun, nil
+}
+
+// Publish a file written outside of the runs, like the dated outputs of scheduled reports.
+// Fails when no publisher is configured
+func (a *Agent) PublishFile(ctx context.Context, localPath string) (string, error) {
+	if a.config.Publisher == nil {
+		return "", fmt.Errorf("no artifact publisher configured, set %s", tools.ArtifactPublishURLEnv)
+	}
+
+	return tools.PublishTraced(ctx, a.tracer, a.config.Publisher, localPath)
+}
@@ -0,0 +1,261 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/history"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+------------------------------
+Resuming runs from transcripts
+------------------------------
+*/
+
+// Run continued by Resume
+type resumeState struct {
+	transcript Transcript
+	runDir     string // Directory holding the transcript and the run artifacts
+}
+
+// Fields of a transcript message needed to rebuild it
+type transcriptMessage struct {
+	Role       string          `json:"role"`
+	Content    json.RawMessage `json:"content"`
+	ToolCallID string          `json:"tool_call_id"`
+}
+
+// Resume an interrupted run from its transcript, continuing its router loop in the same run directory.
+// Tool calls without results are dropped so the router makes them again, and every artifact and
+// result ref of the conversation must still resolve. The new AgentRun span is linked to the original trace.
+// Completed runs aren't run again, their stored answer is returned
+func (a *Agent) Resume(ctx context.Context, transcriptPath string) (RunResult, error) {
+	transcript, err := LoadTranscript(transcriptPath)
+	if err != nil {
+		return RunResult{}, err
+	}
+
+	messages, err := decodeTranscriptMessages(transcript.Messages)
+	if err != nil {
+		return RunResult{}, fmt.Errorf("failed to rebuild transcript '%s': %w", transcriptPath, err)
+	}
+
+	runDir := filepath.Dir(transcriptPath)
+	if answer, ok := finalAnswer(messages); transcript.Completed || ok {
+		log.Printf("Run '%s' already completed, nothing to resume\n", transcript.RunID)
+		if transcript.Answer == "" {
+			transcript.Answer = answer
+		}
+		return RunResult{
+			Answer:    transcript.Answer,
+			RunID:     transcript.RunID,
+			RunDir:    runDir,
+			Artifacts: transcript.Artifacts,
+			Prompt:    transcript.Prompt,
+			Tools:     transcript.Tools,
+			TraceID:   transcript.TraceID,
+			Model:     transcript.Model,
+			Completed: true,
+			Messages:  messages,
+		}, nil
+	}
+
+	messages = dropUnansweredToolCalls(messages)
+	if lastUserQuestion(messages) == "" {
+		return RunResult{}, fmt.Errorf("transcript '%s' has no user prompt to resume", transcriptPath)
+	}
+
+	// Keep answering with the model the run started with
+	resumed := a
+	if transcript.Model != "" && transcript.Model != a.config.Model {
+		resumed = a.WithModel(transcript.Model)
+	}
+
+	log.Printf("Resuming run '%s' from %d messages\n", transcript.RunID, len(messages))
+	return resumed.run(ctx, messages, runOptions{resume: &resumeState{transcript: transcript, runDir: runDir}})
+}
+
+// Rebuild the conversation messages of a transcript
+func decodeTranscriptMessages(rawMessages []json.RawMessage) ([]openai.ChatCompletionMessageParamUnion, error) {
+	messages := []openai.ChatCompletionMessageParamUnion{}
+	for i, rawMessage := range rawMessages {
+		message := transcriptMessage{}
+		if err := json.Unmarshal(rawMessage, &message); err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+
+		// Assistant messages are kept whole, they may hold tool calls
+		if message.Role == "assistant" {
+			assistantMessage := openai.ChatCompletionMessage{}
+			if err := json.Unmarshal(rawMessage, &assistantMessage); err != nil {
+				return nil, fmt.Errorf("message %d: %w", i, err)
+			}
+			messages = append(messages, assistantMessage)
+			continue
+		}
+
+		text, err := contentText(message.Content)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+
+		switch message.Role {
+		case "system":
+			messages = append(messages, openai.SystemMessage(text))
+		case "user":
+			messages = append(messages, openai.UserMessage(text))
+		case "tool":
+			messages = append(messages, openai.ToolMessage(message.ToolCallID, text))
+		default:
+			return nil, fmt.Errorf("message %d: unexpected role '%s'", i, message.Role)
+		}
+	}
+
+	return messages, nil
+}
+
+// Text of a message content, either a plain string or text parts
+func contentText(rawContent json.RawMessage) (string, error) {
+	if len(rawContent) == 0 || string(rawContent) == "null" {
+		return "", nil
+	}
+
+	text := ""
+	if err := json.Unmarshal(rawContent, &text); err == nil {
+		return text, nil
+	}
+
+	parts := []struct {
+		Text string `json:"text"`
+	}{}
+	if err := json.Unmarshal(rawContent, &parts); err != nil {
+		return "", fmt.Errorf("unexpected content: %w", err)
+	}
+
+	texts := []string{}
+	for _, part := range parts {
+		texts = append(texts, part.Text)
+	}
+
+	return strings.Join(texts, ""), nil
+}
+
+// Answer of a conversation ending on an assistant message without tool calls
+func finalAnswer(messages []openai.ChatCompletionMessageParamUnion) (string, bool) {
+	if len(messages) == 0 {
+		return "", false
+	}
+
+	assistantMessage, ok := messages[len(messages)-1].(openai.ChatCompletionMessage)
+	if !ok || len(assistantMessage.ToolCalls) != 0 || assistantMessage.Content == "" {
+		return "", false
+	}
+
+	return assistantMessage.Content, true
+}
+
+// Drop the latest assistant tool calls when any of them has no result, so the router makes them again
+func dropUnansweredToolCalls(messages []openai.ChatCompletionMessageParamUnion) []openai.ChatCompletionMessageParamUnion {
+	for i := len(messages) - 1; i >= 0; i-- {
+		assistantMessage, ok := messages[i].(openai.ChatCompletionMessage)
+		if !ok {
+			continue
+		}
+
+		answered := map[string]bool{}
+		for _, message := range messages[i+1:] {
+			if toolMessage, ok := message.(openai.ChatCompletionToolMessageParam); ok {
+				answered[toolMessage.ToolCallID.Value] = true
+			}
+		}
+
+		for _, toolCall := range assistantMessage.ToolCalls {
+			if !answered[toolCall.ID] {
+				log.Printf("WARNING: Tool call '%s' has no result, dropping the last %d messages\n", toolCall.ID, len(messages)-i)
+				return messages[:i]
+			}
+		}
+
+		return messages
+	}
+
+	return messages
+}
+
+// Check every result ref shown on the tool results of a resumed conversation is stored again
+func validateResumedResults(toolbox *tools.Toolbox, messages []openai.ChatCompletionMessageParamUnion) error {
+	for _, message := range messages {
+		if _, ok := message.(openai.ChatCompletionToolMessageParam); !ok {
+			continue
+		}
+
+		for _, ref := range tools.ResultRefs(history.MessageText(message)) {
+			if !toolbox.HasResult(ref) {
+				return fmt.Errorf("result_ref '%s' of the transcript has no lookup artifact to restore it from", ref)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Start the run directory of a new run, or continue the one of a resumed run
+func (a *Agent) startRun(
+	agentCtx context.Context,
+	toolbox *tools.Toolbox,
+	messages []openai.ChatCompletionMessageParamUnion,
+	resume *resumeState,
+) (string, error) {
+	if resume == nil {
+		return toolbox.StartRun(tracing.ShortTraceID(agentCtx))
+	}
+
+	transcript := resume.transcript
+	if err := toolbox.ResumeRun(transcript.RunID, resume.runDir, transcript.Artifacts); err != nil {
+		return "", err
+	}
+	toolbox.RestoreArtifactURLs(transcript.ArtifactURLs)
+	if err := validateResumedResults(toolbox, messages); err != nil {
+		return "", err
+	}
+
+	tracing.SetSpanAttr(trace.SpanFromContext(agentCtx), "agent.resumed_from", transcript.TraceID)
+	return transcript.RunID, nil
+}
+
+// Trace ID of the run a resumed run continues, empty for new runs
+func resumedTraceID(resume *resumeState) string {
+	if resume == nil {
+		return ""
+	}
+
+	return resume.transcript.TraceID
+}
+
+// SQL executed by a run, after the one the run it resumes executed
+func resumedQueries(resume *resumeState, queries []tools.AuditEntry) []tools.AuditEntry {
+	resumed := []tools.AuditEntry{}
+	if resume != nil {
+		resumed = append(resumed, resume.transcript.Queries...)
+	}
+
+	return append(resumed, queries...)
+}
+
+// Interim content of the run a resumed run continues, empty for new runs
+func resumedInterim(resume *resumeState) []InterimContent {
+	if resume == nil {
+		return nil
+	}
+
+	return resume.transcript.Interim
+}
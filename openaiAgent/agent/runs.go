@@ -0,0 +1,259 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+/*
+-----------
+Run history
+-----------
+*/
+
+// Status of a run on the output directory
+const (
+	RunCompleted  = "completed"
+	RunAborted    = "aborted"    // Interrupted before finishing
+	RunRefused    = "refused"    // Refused by the entity guard
+	RunIncomplete = "incomplete" // Crashed or still going: its transcript is missing, partially written or unfinished
+)
+
+// Run found on the output directory, summarized from its transcript
+type RunSummary struct {
+	RunID      string      `json:"runId"`
+	Dir        string      `json:"dir"`
+	StartedAt  time.Time   `json:"startedAt"`
+	Status     string      `json:"status"`
+	Question   string      `json:"question"`
+	Answer     string      `json:"answer"`
+	Model      string      `json:"model,omitempty"`
+	Usage      tools.Usage `json:"usage"`
+	DurationMs int64       `json:"durationMs"`      // Until the transcript was last written
+	Error      string      `json:"error,omitempty"` // Why the transcript couldn't be fully read
+}
+
+// Runs listed by ListRuns. Zero values match every run
+type RunFilter struct {
+	Since  time.Time // Runs started at or after it
+	Until  time.Time // Runs started before it
+	Search string    // Text the question holds, case insensitively
+}
+
+// Summarize the runs on outputDir matching filter, newest first. Only reads the run directories,
+// runs whose transcript can't be read are listed as incomplete instead of failing the listing
+func ListRuns(outputDir string, filter RunFilter) ([]RunSummary, error) {
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []RunSummary{}, nil
+		}
+		return nil, fmt.Errorf("failed to list runs: %w", err)
+	}
+
+	runs := []RunSummary{}
+	for _, entry := range entries {
+		startedAt, ok := tools.RunStartTime(entry.Name())
+		if !entry.IsDir() || !ok {
+			continue
+		}
+		if (!filter.Since.IsZero() && startedAt.Before(filter.Since)) || (!filter.Until.IsZero() && !startedAt.Before(filter.Until)) {
+			continue
+		}
+
+		summary, reader, err := openRunSummary(filepath.Join(outputDir, entry.Name()), startedAt)
+		if err == nil {
+			reader.Close()
+		}
+		if filter.Search != "" && !strings.Contains(strings.ToLower(summary.Question), strings.ToLower(filter.Search)) {
+			continue
+		}
+		runs = append(runs, summary)
+	}
+
+	// Run IDs start with a timestamp, so lexical order is chronological order
+	slices.SortFunc(runs, func(a RunSummary, b RunSummary) int { return strings.Compare(b.RunID, a.RunID) })
+	return runs, nil
+}
+
+// Summary and transcript of a run on outputDir, by its ID or any unique part of it, like its trace ID
+func LoadRun(outputDir string, runID string) (RunSummary, Transcript, error) {
+	match, err := findRun(outputDir, runID)
+	if err != nil {
+		return RunSummary{}, Transcript{}, err
+	}
+
+	summary, transcript := summarizeRun(match.Dir, match.StartedAt)
+	return summary, transcript, nil
+}
+
+// Run on outputDir matching runID, see LoadRun
+func findRun(outputDir string, runID string) (RunSummary, error) {
+	runs, err := ListRuns(outputDir, RunFilter{})
+	if err != nil {
+		return RunSummary{}, err
+	}
+
+	matches := []RunSummary{}
+	for _, run := range runs {
+		if run.RunID == runID {
+			return run, nil
+		}
+		if strings.Contains(run.RunID, runID) {
+			matches = append(matches, run)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return RunSummary{}, fmt.Errorf("no run '%s' on %s", runID, outputDir)
+	case 1:
+		return matches[0], nil
+	}
+
+	ids := []string{}
+	for _, match := range matches {
+		ids = append(ids, match.RunID)
+	}
+	return RunSummary{}, fmt.Errorf("'%s' matches %d runs: %s", runID, len(matches), strings.Join(ids, ", "))
+}
+
+// Summarize the run at runDir from its transcript, salvaging what a partially written one holds
+func summarizeRun(runDir string, startedAt time.Time) (RunSummary, Transcript) {
+	summary := RunSummary{RunID: filepath.Base(runDir), Dir: runDir, StartedAt: startedAt, Status: RunIncomplete}
+
+	transcriptBytes, err := os.ReadFile(filepath.Join(runDir, transcriptArtifactName))
+	if err != nil {
+		summary.Error = err.Error()
+		return summary, Transcript{RunID: summary.RunID}
+	}
+
+	transcript := Transcript{}
+	if err := json.Unmarshal(transcriptBytes, &transcript); err != nil {
+		summary.Error = fmt.Sprintf("partially written transcript: %s", err)
+		transcript = salvageTranscript(transcriptBytes)
+	} else {
+		summary.Status = runStatus(transcript)
+	}
+
+	fillRunSummary(&summary, transcript)
+	return summary, transcript
+}
+
+// Copy the question, answer, model, usage and duration of a transcript to its run summary
+func fillRunSummary(summary *RunSummary, transcript Transcript) {
+	summary.Question = transcript.Prompt
+	summary.Answer = transcript.Answer
+	summary.Model = transcript.Model
+	summary.Usage = transcript.Usage
+	if writtenAt, err := time.ParseInLocation("2006-01-02T15:04:05", transcript.TimeStamp, time.Local); err == nil {
+		summary.DurationMs = writtenAt.Sub(summary.StartedAt).Milliseconds()
+	}
+}
+
+func runStatus(transcript Transcript) string {
+	switch {
+	case transcript.Aborted:
+		return RunAborted
+	case transcript.Refusal != nil:
+		return RunRefused
+	case transcript.Completed:
+		return RunCompleted
+	}
+
+	return RunIncomplete
+}
+
+// Fields of a transcript read before the point it was cut at. The prompt comes early on the file,
+// so even runs that crashed mid write keep their question
+func salvageTranscript(transcriptBytes []byte) Transcript {
+	decoder := json.NewDecoder(bytes.NewReader(transcriptBytes))
+	fields := map[string]json.RawMessage{}
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return Transcript{}
+	}
+
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		value := json.RawMessage{}
+		if err := decoder.Decode(&value); err != nil {
+			break
+		}
+		fields[fmt.Sprint(key)] = value
+	}
+
+	transcript := Transcript{}
+	if fieldsBytes, err := json.Marshal(fields); err == nil {
+		json.Unmarshal(fieldsBytes, &transcript)
+	}
+
+	return transcript
+}
+
+// Tool call of a transcript message
+type RunToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// Transcript message flattened for display
+type RunMessage struct {
+	Role       string        `json:"role"`
+	Content    string        `json:"content"`
+	ToolCallID string        `json:"toolCallId,omitempty"` // Only on tool results
+	ToolCalls  []RunToolCall `json:"toolCalls,omitempty"`  // Only on assistant messages
+}
+
+// Flatten the messages of a transcript for display. Messages that can't be read are kept with their raw JSON
+func RunMessages(transcript Transcript) []RunMessage {
+	messages := []RunMessage{}
+	for _, rawMessage := range transcript.Messages {
+		messages = append(messages, flattenRunMessage(rawMessage))
+	}
+
+	return messages
+}
+
+// Flatten a transcript message for display, see RunMessages
+func flattenRunMessage(rawMessage json.RawMessage) RunMessage {
+	message := struct {
+		transcriptMessage
+		ToolCalls []struct {
+			ID       string `json:"id"`
+			Function struct {
+				Name      string `json:"name"`
+				Arguments string `json:"arguments"`
+			} `json:"function"`
+		} `json:"tool_calls"`
+	}{}
+	if err := json.Unmarshal(rawMessage, &message); err != nil {
+		return RunMessage{Role: "unknown", Content: string(rawMessage)}
+	}
+
+	content, err := contentText(message.Content)
+	if err != nil {
+		content = string(message.Content)
+	}
+
+	runMessage := RunMessage{Role: message.Role, Content: content, ToolCallID: message.ToolCallID}
+	for _, toolCall := range message.ToolCalls {
+		runMessage.ToolCalls = append(runMessage.ToolCalls, RunToolCall{
+			ID:        toolCall.ID,
+			Name:      toolCall.Function.Name,
+			Arguments: toolCall.Function.Arguments,
+		})
+	}
+	return runMessage
+}
@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+)
+
+// Queries generated by the scan guard lookups, reading the whole table or one store of it
+const (
+	fullScanQuery     = "SELECT Store_Number, SUM(Total_Sale_Value) AS sales FROM sales GROUP BY Store_Number"
+	filteredScanQuery = "SELECT SUM(Total_Sale_Value) AS sales FROM sales WHERE Store_Number = 1320"
+)
+
+// Lookups of runs whose generated queries are scripted, on the recorded dataset of about 700k rows,
+// checked for the query that ran and the scan guard outcome
+func TestScanGuardLookups(t *testing.T) {
+	cases := []struct {
+		name        string
+		action      string
+		queries     []string // Queries the model generates, a second one only when it's asked again
+		ran         string   // Query the lookup runs
+		warned      bool     // The tool result warns about the scans
+		regenerated bool
+	}{
+		{name: "filtered query", action: tools.ScanGuardRegenerate, queries: []string{filteredScanQuery}, ran: filteredScanQuery},
+		{name: "full scan warned", action: tools.ScanGuardWarn, queries: []string{fullScanQuery}, ran: fullScanQuery, warned: true},
+		{
+			name:        "full scan regenerated with filters",
+			action:      tools.ScanGuardRegenerate,
+			queries:     []string{fullScanQuery, filteredScanQuery},
+			ran:         filteredScanQuery,
+			regenerated: true,
+		},
+		{
+			name:        "full scan regenerated unfiltered",
+			action:      tools.ScanGuardRegenerate,
+			queries:     []string{fullScanQuery, fullScanQuery},
+			ran:         fullScanQuery,
+			warned:      true,
+			regenerated: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			responses := []*openai.ChatCompletion{
+				mock.ToolCallResponse("call_lookup", tools.LookUpFuncName, map[string]string{"prompt": "Total sales of store 1320"}),
+			}
+			for _, query := range c.queries {
+				responses = append(responses, mock.TextResponse(query))
+			}
+			responses = append(responses, mock.TextResponse("Store 1320 sold 1000."))
+
+			completer := mock.NewCompleter(responses...)
+			tracer, recorder := tracing.NewRecordingTracer()
+			config := Config{ScanGuard: tools.ScanGuard{MaxRows: 100000, Action: c.action}, Tracer: tracer}
+			onRecordedDataset(t, &config)
+			agent := newTestAgent(t, config, completer)
+
+			result, err := agent.RunMessages(context.Background(), []openai.ChatCompletionMessageParamUnion{
+				openai.UserMessage("What did store 1320 sell?"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(completer.Requests) != len(responses) {
+				t.Fatalf("expected %d completions, got %d", len(responses), len(completer.Requests))
+			}
+
+			ran, plans := "", 0
+			for _, query := range result.Queries {
+				switch query.Source {
+				case tools.AuditSourceGenerated:
+					ran = query.SQL
+				case tools.AuditSourcePlan:
+					plans++
+				}
+			}
+			if ran != c.ran {
+				t.Fatalf("ran '%s', expected '%s'", ran, c.ran)
+			}
+			if plans != len(c.queries) {
+				t.Fatalf("explained %d queries, expected %d", plans, len(c.queries))
+			}
+
+			toolResult := ""
+			for _, message := range result.Messages {
+				if toolMessage, ok := message.(openai.ChatCompletionToolMessageParam); ok {
+					for _, part := range toolMessage.Content.Value {
+						toolResult += part.Text.Value
+					}
+				}
+			}
+			if warned := strings.Contains(toolResult, "# Warning: the query scans about"); warned != c.warned {
+				t.Fatalf("tool result warning is %t, expected %t: %s", warned, c.warned, toolResult)
+			}
+
+			for _, span := range recorder.Ended() {
+				if span.Name() != "LookUpTool" {
+					continue
+				}
+				for _, attr := range span.Attributes() {
+					if attr.Key == "tool.sql.scan_regenerated" && attr.Value.AsBool() != c.regenerated {
+						t.Fatalf("expected tool.sql.scan_regenerated to be %t", c.regenerated)
+					}
+				}
+			}
+			if c.regenerated {
+				regeneration, err := json.Marshal(completer.Requests[2])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(string(regeneration), "Add WHERE filters") {
+					t.Fatal("the regenerated query wasn't asked for filters")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+)
+
+// Rewrite the span snapshots once every change is on the allowlist, with go test ./agent -run TestSpanSnapshots -update
+var updateSpans = flag.Bool("update", false, "rewrite the span snapshots and clear their allowlist")
+
+// Header kept on the allowlist once its changes are applied
+const spanAllowlistHeader = `# Intentional span attribute changes, one per line as printed by TestSpanSnapshots, like
+# "+ RouterCall (CHAIN): agent.new_key". -update only rewrites the snapshots when every change is listed
+# here, then clears the list, so renaming or dropping an attribute Phoenix renders is a reviewed change.
+`
+
+// Fixtures covering every tool span and the router, LLM and tool call spans around them.
+// They replay with the settings of their fixtureCases
+var spanFixtures = []string{"single_lookup", "chart_result_ref", "compare_periods", "verbosity_detailed", "past_analyses"}
+
+// Replay the span fixtures with a recording tracer and compare the name, kind and attribute keys of their spans
+// against the snapshots. Fails on unlisted changes or missing Phoenix keys
+func TestSpanSnapshots(t *testing.T) {
+	snapshotDir := modulePath(t, "testdata", "spans")
+	allowlistPath := filepath.Join(snapshotDir, "allowlist.txt")
+	allowed, err := tracing.LoadSnapshotAllowlist(allowlistPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots := map[string][]tracing.SpanSnapshot{}
+	for _, name := range spanFixtures {
+		t.Run(name, func(t *testing.T) {
+			current := recordFixtureSpans(t, name)
+			snapshots[name] = current
+
+			// New fixtures have no snapshot to compare with, -update writes their first one
+			golden, err := tracing.LoadSnapshot(filepath.Join(snapshotDir, name+".json"))
+			if errors.Is(err, os.ErrNotExist) {
+				if !*updateSpans {
+					t.Errorf("fixture has no span snapshot, run with -update to write it")
+				}
+				golden = current
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, missing := range tracing.MissingPhoenixKeys(current) {
+				t.Errorf("span is missing a key Phoenix renders: %s", missing)
+			}
+			for _, change := range tracing.DiffSnapshots(golden, current) {
+				if slices.Contains(allowed, change) {
+					t.Logf("allowed change: %s", change)
+					continue
+				}
+				t.Errorf("unlisted change, list it on %s and run with -update if intended: %s", allowlistPath, change)
+			}
+		})
+	}
+
+	if !*updateSpans || t.Failed() {
+		return
+	}
+	for name, snapshot := range snapshots {
+		if err := tracing.SaveSnapshot(filepath.Join(snapshotDir, name+".json"), snapshot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(allowlistPath, []byte(spanAllowlistHeader), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("wrote %d span snapshots and cleared %d allowlisted changes", len(snapshots), len(allowed))
+}
+
+// Replay a fixture with a recording tracer and snapshot the spans of the run
+func recordFixtureSpans(t *testing.T, name string) []tracing.SpanSnapshot {
+	t.Helper()
+	completer, err := mock.LoadFixture(modulePath(t, "testdata", "fixtures", name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer, recorder := tracing.NewRecordingTracer()
+	config := Config{Tracer: tracer}
+	if configure := fixtureCases[name].configure; configure != nil {
+		configure(t, &config)
+	}
+	agent := newTestAgent(t, config, tempNamespaceCompleter{completer})
+
+	if _, err := agent.Run(context.Background(), "Spans snapshot question"); err != nil {
+		t.Fatal(err)
+	}
+	if len(completer.Requests) != len(completer.Responses) {
+		t.Fatalf("run made %d of the %d requests of the fixture", len(completer.Requests), len(completer.Responses))
+	}
+
+	spans := recorder.Ended()
+	if len(spans) == 0 {
+		t.Fatal("run recorded no spans")
+	}
+	return tracing.SnapshotSpans(spans)
+}
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+/*
+-------------
+Metadata tags
+-------------
+*/
+
+// Prefix of the tag attributes on the AgentRun span, so Phoenix can filter runs by them
+const tagAttributePrefix = "metadata."
+
+// Keys Phoenix already gets from the run, a tag can't shadow them
+var reservedTagKeys = []string{"trace_id", "model"}
+
+var tagKeyRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]{0,63}$`)
+
+// Parse a key=value tag, like 'experiment=router-v2'
+func ParseTag(pair string) (string, string, error) {
+	key, value, found := strings.Cut(pair, "=")
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	if !found || key == "" {
+		return "", "", fmt.Errorf("invalid tag '%s', expected key=value", pair)
+	}
+
+	return key, value, validateTagKey(key)
+}
+
+// Check a tag key is usable as a span attribute and isn't reserved
+func validateTagKey(key string) error {
+	if slices.Contains(reservedTagKeys, strings.ToLower(key)) {
+		return fmt.Errorf("tag key '%s' is reserved, pick another than %s", key, strings.Join(reservedTagKeys, " or "))
+	}
+	if !tagKeyRegex.MatchString(key) {
+		return fmt.Errorf("invalid tag key '%s', use up to 64 letters, digits, '_', '-' or '.' starting with a letter", key)
+	}
+
+	return nil
+}
+
+// Tags of a request merged over default ones, like the tags of a server request body over the server configured
+// defaults. Request tags win on shared keys, and every key must be valid
+func MergeTags(defaults map[string]string, request map[string]string) (map[string]string, error) {
+	merged := maps.Clone(defaults)
+	if merged == nil {
+		merged = map[string]string{}
+	}
+
+	for key, value := range request {
+		if err := validateTagKey(key); err != nil {
+			return nil, err
+		}
+		merged[key] = value
+	}
+
+	return merged, nil
+}
+
+// Redaction hook for tag values, returning the value to record for a tag
+type TagRedactor func(key string, value string) string
+
+// Default redaction of tag values: email addresses are hashed like end users and credentials in DSNs are hidden
+func RedactTagValue(key string, value string) string {
+	return tools.RedactDSN(completion.EndUserID(value))
+}
+
+// Tags of the agent runs with their values redacted, nil when there are none
+func (a *Agent) runTags() map[string]string {
+	if len(a.config.Tags) == 0 {
+		return nil
+	}
+
+	redact := a.config.RedactTag
+	if redact == nil {
+		redact = RedactTagValue
+	}
+
+	tags := map[string]string{}
+	for key, value := range a.config.Tags {
+		tags[key] = redact(key, value)
+	}
+
+	return tags
+}
+
+// Span attributes of run tags, prefixed for Phoenix metadata filters
+func tagAttributes(tags map[string]string) map[string]any {
+	attributes := map[string]any{}
+	for key, value := range tags {
+		attributes[tagAttributePrefix+key] = value
+	}
+
+	return attributes
+}
+
+// Derive an agent tagging its runs with tags merged over the configured ones, like for a server request
+// carrying its own tags. Closing it is a no-op, close a instead
+func (a *Agent) WithTags(tags map[string]string) (*Agent, error) {
+	merged, err := MergeTags(a.config.Tags, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	derived := a.derive()
+	derived.config.Tags = merged
+	return derived, nil
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/history"
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+)
+
+// Query scanning far more rows than any lookup timeout allows
+const slowQuery = "SELECT count(*) AS pairs FROM range(1000000000) a, range(1000000000) b WHERE a.range + b.range = 7"
+
+// Tools running over the timeout of the run are cancelled and marked as timed out on their span. The run goes
+// on: a cancelled lookup reports partial results, a cancelled analysis falls back to a summary of the data
+func TestToolTimeouts(t *testing.T) {
+	cases := []struct {
+		name      string
+		timeouts  tools.ToolTimeouts // Timeouts of the run, set with WithToolTimeouts
+		delay     time.Duration      // Delay of every completion
+		tool      string             // Tool the router calls
+		args      map[string]string  // Arguments of the tool call
+		toolReply string             // Completion the tool gets, empty when it makes none
+		span      string             // Span of the tool that times out
+		want      string             // Start of the tool result the router gets
+	}{
+		{
+			name:      "partial lookup",
+			timeouts:  tools.ToolTimeouts{Lookup: 300 * time.Millisecond},
+			tool:      tools.LookUpFuncName,
+			args:      map[string]string{"prompt": "Pairs of numbers adding up to 7"},
+			toolReply: slowQuery,
+			span:      "LookUpTool",
+			want:      "# partial results, query cancelled after 300ms",
+		},
+		{
+			name:      "analysis fallback",
+			timeouts:  tools.ToolTimeouts{Analyze: 100 * time.Millisecond},
+			delay:     200 * time.Millisecond,
+			tool:      tools.AnalyzeFuncName,
+			args:      map[string]string{"prompt": "Which store sold the most?", "data": "Store_Number, Total_Sale_Value\n1, 30.5\n2, 12.25"},
+			toolReply: "Store 1 sold the most.",
+			span:      "AnalyzeTool",
+			want:      "Automated summary (LLM unavailable)",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			completer := mock.NewCompleter(
+				mock.ToolCallResponse("call_1", c.tool, c.args),
+				mock.TextResponse(c.toolReply),
+				mock.TextResponse("The tool ran out of time, this is what it found."),
+			)
+			completer.Delay = c.delay
+			tracer, recorder := tracing.NewRecordingTracer()
+			a := newTestAgent(t, Config{StorageBackend: tools.StorageDuckDB, Tracer: tracer}, completer).WithToolTimeouts(c.timeouts)
+
+			start := time.Now()
+			result, err := a.Run(context.Background(), "Run a slow tool")
+			if err != nil || !result.Completed {
+				t.Fatalf("run didn't complete after the tool timeout: %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 10*time.Second {
+				t.Errorf("run took %s, the tool wasn't cancelled", elapsed)
+			}
+
+			toolMessage := ""
+			for _, message := range result.Messages {
+				if messageRole(message) == "tool" {
+					toolMessage = history.MessageText(message)
+				}
+			}
+			if !strings.HasPrefix(toolMessage, "<tool_output>\n"+c.want) {
+				t.Errorf("tool message '%s' doesn't start with '%s'", toolMessage, c.want)
+			}
+
+			timedOut := false
+			for _, span := range recorder.Ended() {
+				if span.Name() != c.span {
+					continue
+				}
+				for _, attr := range span.Attributes() {
+					timedOut = timedOut || attr.Key == "tool.timeout" && attr.Value.AsBool()
+				}
+			}
+			if !timedOut {
+				t.Errorf("span %s isn't marked as timed out", c.span)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/history"
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/openai/openai-go"
+)
+
+// A failed tool call is answered with its class. Retryable failures and access denials leave the router to
+// answer, other failures abort the run with the tool error
+func TestToolErrorsAbortRun(t *testing.T) {
+	cases := []struct {
+		name      string
+		configure func(t *testing.T, config *Config)
+		sql       string // Query the lookup generates
+		class     string // Class of the tool error sent to the router
+		abort     bool   // Whether the run fails on the tool error
+	}{
+		{
+			name:  "retryable",
+			sql:   "SELECT Region, sum(Total_Sale_Value) AS sales FROM sales GROUP BY Region",
+			class: tools.ErrorClassSQLExecution,
+		},
+		{
+			name:      "not retryable",
+			configure: func(t *testing.T, config *Config) { config.StorageBackend = tools.StorageMemory },
+			sql:       "SELECT sum(Total_Sale_Value) AS sales FROM sales",
+			class:     tools.ErrorClassSQLUnavailable,
+			abort:     true,
+		},
+		{
+			name: "access denied",
+			configure: func(t *testing.T, config *Config) {
+				access, err := tools.LoadAccessPolicy(modulePath(t, "testdata", "access.json"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				config.Access, config.Role = access, "intern"
+			},
+			sql:   "SELECT sum(Total_Sale_Value) AS sales FROM sales",
+			class: tools.ErrorClassAccessDenied,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := Config{StorageBackend: tools.StorageDuckDB}
+			if c.configure != nil {
+				c.configure(t, &config)
+			}
+			completer := mock.NewCompleter(
+				mock.ToolCallResponse("call_1", tools.LookUpFuncName, map[string]string{"prompt": "Total sales"}),
+				mock.TextResponse(c.sql),
+				mock.TextResponse("The lookup failed, I can't answer that."),
+			)
+			a := newTestAgent(t, config, completer)
+
+			result, err := a.Run(context.Background(), "What were the total sales?")
+			if c.abort {
+				if err == nil {
+					t.Fatal("run didn't fail on a non retryable tool error")
+				}
+				if class := tools.ErrorClass(err); class != c.class {
+					t.Fatalf("run failed with class %s, expected %s: %s", class, c.class, err)
+				}
+			} else if err != nil || !result.Completed {
+				t.Fatalf("run didn't complete after a %s tool error: %v", c.class, err)
+			}
+
+			toolMessage := ""
+			for _, message := range result.Messages {
+				if _, ok := message.(openai.ChatCompletionToolMessageParam); ok {
+					toolMessage = history.MessageText(message)
+				}
+			}
+			if !strings.Contains(toolMessage, `"class":"`+c.class+`"`) {
+				t.Errorf("tool message '%s' doesn't report the %s class", toolMessage, c.class)
+			}
+
+			// Aborted runs make no router call after the tool error
+			routerCalls := 2
+			if c.abort {
+				routerCalls = 1
+			}
+			if result.Iterations != routerCalls {
+				t.Errorf("made %d router calls, expected %d", result.Iterations, routerCalls)
+			}
+		})
+	}
+}
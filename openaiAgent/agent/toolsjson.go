@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/openai/openai-go"
+)
+
+/*
+------------------
+Tools json loading
+------------------
+*/
+
+// Max size of the tools json file. Anything bigger is not a tools config
+const maxToolsJsonBytes = 1 << 20
+
+// Function names every tools json must define exactly once
+var requiredToolNames = []string{tools.LookUpFuncName, tools.AnalyzeFuncName, tools.VisualizeFuncName}
+
+// Load tools config from a json file.
+// Decode errors point to the line and column of the issue, and every required tool must be defined once
+func loadToolsJson(toolsJsonPath string) ([]toolConfig, error) {
+	log.Println("Loading tools json ...")
+	jsonFile, err := os.Open(toolsJsonPath)
+	if err != nil {
+		return nil, err
+	}
+	defer jsonFile.Close()
+
+	// Read one byte over the limit to tell a full file from a cut one
+	data, err := io.ReadAll(io.LimitReader(jsonFile, maxToolsJsonBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tools json: %w", err)
+	}
+	if len(data) > maxToolsJsonBytes {
+		return nil, fmt.Errorf("tools json %s is over the %d bytes limit", toolsJsonPath, maxToolsJsonBytes)
+	}
+
+	config := []toolConfig{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to decode tools json %s: %w", toolsJsonPath, describeJsonError(data, err))
+	}
+
+	if err := validateToolConfigs(config); err != nil {
+		return nil, fmt.Errorf("invalid tools json %s: %w", toolsJsonPath, err)
+	}
+
+	return config, nil
+}
+
+// Add the line and column of syntax and type errors, which only carry a byte offset
+func describeJsonError(data []byte, err error) error {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return err
+	}
+
+	before := data[:min(int(offset), len(data))]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Errorf("line %d, column %d (offset %d): %w", line, column, offset, err)
+}
+
+// Check every required tool is defined exactly once
+func validateToolConfigs(configs []toolConfig) error {
+	counts := map[string]int{}
+	for _, config := range configs {
+		counts[config.Function.Name]++
+	}
+
+	for name, count := range counts {
+		if count > 1 {
+			return fmt.Errorf("tool '%s' is defined %d times", name, count)
+		}
+	}
+
+	for _, name := range requiredToolNames {
+		if counts[name] == 0 {
+			return fmt.Errorf("missing required tool '%s'", name)
+		}
+	}
+
+	return nil
+}
+
+/*
+---------------------
+Tools json hot reload
+---------------------
+*/
+
+// Tool params loaded from the tools json, shared by an agent and the agents derived from it.
+// Every configured tool is kept, each agent narrows them to its own allowlist
+type toolSet struct {
+	mu      sync.RWMutex
+	params  []openai.ChatCompletionToolParam
+	modTime time.Time // Modification time of the loaded file
+	size    int64     // Size of the loaded file
+	reloads int       // Amount of reloads since the agent was created
+}
+
+// Tool params of every configured tool
+func (s *toolSet) Params() []openai.ChatCompletionToolParam {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.params
+}
+
+// Amount of times the tools json was reloaded
+func (s *toolSet) Reloads() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.reloads
+}
+
+// Record the file the params were loaded from
+func (s *toolSet) setLoaded(params []openai.ChatCompletionToolParam, info os.FileInfo) {
+	s.params = params
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+}
+
+// Load the params of every tool of the tools json
+func loadToolSet(toolsJsonPath string) (*toolSet, error) {
+	info, err := os.Stat(toolsJsonPath)
+	if err != nil {
+		return nil, fmt.Errorf("no json file found at %s", toolsJsonPath)
+	}
+
+	toolConfigs, err := loadToolsJson(toolsJsonPath)
+	if err != nil {
+		return nil, err
+	}
+	params, err := convertToolConfigToParams(toolConfigs)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &toolSet{}
+	set.setLoaded(params, info)
+	return set, nil
+}
+
+// Re-stat the tools json and rebuild the tool params when it changed.
+// A file that fails to load keeps the previous params. Returns whether the params were reloaded
+func (a *Agent) reloadToolsIfChanged() bool {
+	info, err := os.Stat(a.config.ToolsJsonPath)
+	if err != nil {
+		log.Printf("WARNING: Failed to stat tools json, keeping loaded tools: %s\n", err)
+		return false
+	}
+
+	a.toolSet.mu.Lock()
+	defer a.toolSet.mu.Unlock()
+
+	if info.ModTime().Equal(a.toolSet.modTime) && info.Size() == a.toolSet.size {
+		return false
+	}
+
+	toolConfigs, err := loadToolsJson(a.config.ToolsJsonPath)
+	var params []openai.ChatCompletionToolParam
+	if err == nil {
+		params, err = convertToolConfigToParams(toolConfigs)
+	}
+	if err != nil {
+		// Don't retry the same broken file on every run
+		a.toolSet.modTime, a.toolSet.size = info.ModTime(), info.Size()
+		log.Printf("WARNING: Failed to reload tools json, keeping loaded tools: %s\n", err)
+		return false
+	}
+
+	a.toolSet.setLoaded(params, info)
+	a.toolSet.reloads++
+	log.Printf("Reloaded tools json %s (reload %d)\n", a.config.ToolsJsonPath, a.toolSet.reloads)
+	return true
+}
+
+// Amount of times the tools json was reloaded, see Config.ReloadTools
+func (a *Agent) ToolsReloads() int {
+	return a.toolSet.Reloads()
+}
@@ -0,0 +1,214 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/openai/openai-go"
+)
+
+/*
+--------------
+Run transcript
+--------------
+*/
+
+// Transcript of an agent run, saved as an artifact of the run.
+// It's rewritten after each router call and tool result, so interrupted runs can be resumed
+type Transcript struct {
+	TimeStamp     string                 `json:"timeStamp"`
+	RunID         string                 `json:"runId"`
+	Aborted       bool                   `json:"aborted"`
+	Completed     bool                   `json:"completed"`
+	TraceID       string                 `json:"traceId,omitempty"`
+	ResumedFrom   string                 `json:"resumedFrom,omitempty"`
+	Model         string                 `json:"model,omitempty"`
+	Verbosity     string                 `json:"verbosity,omitempty"`
+	Sample        string                 `json:"sample,omitempty"`
+	Tags          map[string]string      `json:"tags,omitempty"`
+	Degraded      []string               `json:"degraded,omitempty"`
+	Prompt        string                 `json:"prompt"`
+	Answer        string                 `json:"answer"`
+	Interim       []InterimContent       `json:"interim,omitempty"`
+	Tools         []string               `json:"tools"`
+	Artifacts     []string               `json:"artifacts"`
+	ArtifactURLs  map[string]string      `json:"artifactUrls,omitempty"`
+	Usage         tools.Usage            `json:"usage"`
+	Completions   []tools.CompletionCall `json:"completions"`
+	Retrieval     tools.RetrievalStats   `json:"retrieval"`
+	Queries       []tools.AuditEntry     `json:"queries"`
+	Provenance    *Provenance            `json:"provenance,omitempty"`
+	Refusal       *tools.EntityMatch     `json:"refusal,omitempty"`
+	Deterministic bool                   `json:"deterministic"`
+	Seed          int64                  `json:"seed,omitempty"`
+	Plan          *PlanDivergence        `json:"plan,omitempty"`
+	Messages      []json.RawMessage      `json:"messages"`
+}
+
+const transcriptArtifactName = "transcript.json"
+
+// Write the conversation of a run as a transcript artifact on its run directory.
+// `aborted` marks runs that were interrupted before finishing.
+// Returns the artifact path relative to the run directory
+func WriteTranscript(result RunResult, aborted bool) (string, error) {
+	if result.RunDir == "" {
+		return "", fmt.Errorf("run has no directory, can't write transcript")
+	}
+
+	transcript := Transcript{
+		TimeStamp:     time.Now().Format("2006-01-02T15:04:05"),
+		RunID:         result.RunID,
+		Aborted:       aborted,
+		Completed:     result.Completed && !aborted,
+		TraceID:       result.TraceID,
+		ResumedFrom:   result.ResumedFrom,
+		Model:         result.Model,
+		Verbosity:     result.Verbosity,
+		Sample:        result.Sample,
+		Tags:          result.Tags,
+		Degraded:      result.Degraded,
+		Prompt:        result.Prompt,
+		Answer:        result.Answer,
+		Interim:       result.Interim,
+		Tools:         result.Tools,
+		Artifacts:     result.Artifacts,
+		ArtifactURLs:  result.ArtifactURLs,
+		Usage:         result.Usage,
+		Completions:   result.Completions,
+		Retrieval:     result.Retrieval,
+		Queries:       result.Queries,
+		Provenance:    result.Provenance,
+		Refusal:       result.Refusal,
+		Deterministic: result.Deterministic,
+		Seed:          result.Seed,
+		Plan:          result.PlanDivergence,
+		Messages:      []json.RawMessage{},
+	}
+
+	for _, message := range result.Messages {
+		rawMessage, err := json.Marshal(message)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal transcript message: %w", err)
+		}
+		transcript.Messages = append(transcript.Messages, rawMessage)
+	}
+
+	jsonBytes, err := json.MarshalIndent(transcript, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(filepath.Join(result.RunDir, transcriptArtifactName), jsonBytes, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write transcript: %w", err)
+	}
+
+	// Runs show reads the messages from their offsets, and scans transcripts whose index is missing or stale
+	if err := writeTranscriptIndex(result.RunDir, jsonBytes); err != nil {
+		log.Printf("WARNING: Failed to index transcript: %s\n", err)
+	}
+
+	return transcriptArtifactName, nil
+}
+
+// Run going on, with the result of its latest checkpoint
+type liveRun struct {
+	result  RunResult
+	aborted bool // The run was interrupted, its checkpoints are written as aborted
+}
+
+// Runs going on, keyed by their id. Transcripts of a run are written under the mutex,
+// so a checkpoint never overwrites the aborted transcript of an interrupt
+type liveRuns struct {
+	runs  map[string]*liveRun
+	mutex sync.Mutex
+}
+
+// Track a run from its initial result until end
+func (l *liveRuns) start(result RunResult, messages []openai.ChatCompletionMessageParamUnion) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	result.Messages = messages
+	l.runs[result.RunID] = &liveRun{result: result}
+}
+
+func (l *liveRuns) end(runID string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.runs, runID)
+}
+
+// Keep the latest result of a run and write its transcript, as aborted once the run was interrupted
+func (l *liveRuns) checkpoint(result RunResult) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	aborted := false
+	if run, ok := l.runs[result.RunID]; ok {
+		run.result = result
+		aborted = run.aborted
+	}
+	_, err := WriteTranscript(result, aborted)
+	return err
+}
+
+// Write the transcript of every run going on as aborted, from its latest checkpoint, and keep their later
+// checkpoints aborted. For interrupts: the transcripts are on disk before waiting for the runs to stop,
+// even if they never do. Returns the paths of the written transcripts
+func (a *Agent) AbortLiveRuns() []string {
+	a.live.mutex.Lock()
+	defer a.live.mutex.Unlock()
+
+	paths := []string{}
+	for _, run := range a.live.runs {
+		run.aborted = true
+		name, err := WriteTranscript(run.result, true)
+		if err != nil {
+			log.Printf("WARNING: Failed to write the transcript of aborted run '%s': %s\n", run.result.RunID, err)
+			continue
+		}
+		paths = append(paths, filepath.Join(run.result.RunDir, name))
+	}
+
+	return paths
+}
+
+// Load a transcript written by WriteTranscript
+func LoadTranscript(transcriptPath string) (Transcript, error) {
+	transcript := Transcript{}
+	jsonFile, err := os.Open(transcriptPath)
+	if err != nil {
+		return transcript, err
+	}
+	defer jsonFile.Close()
+
+	if err := json.NewDecoder(jsonFile).Decode(&transcript); err != nil {
+		return transcript, fmt.Errorf("failed to decode transcript: %w", err)
+	}
+
+	return transcript, nil
+}
+
+// Publish the transcript of a run with the agent publisher, after it was last written.
+// Returns its URL, empty if it's kept local
+func (a *Agent) PublishTranscript(ctx context.Context, result RunResult) string {
+	if a.config.Publisher == nil || result.RunDir == "" {
+		return ""
+	}
+
+	publishedURL, err := tools.PublishTraced(ctx, a.tracer, a.config.Publisher, filepath.Join(result.RunDir, transcriptArtifactName))
+	if err != nil {
+		log.Printf("WARNING: Keeping transcript local: %s\n", err)
+		return ""
+	}
+
+	return publishedURL
+}
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+// Aborting the runs going on writes their transcript as aborted right away, from the latest checkpoint,
+// and the checkpoints the run still makes before it stops stay aborted
+func TestAbortLiveRuns(t *testing.T) {
+	completer := mock.NewCompleter(
+		mock.ToolCallResponse("call_1", tools.LookUpFuncName, map[string]string{"prompt": "Total sales value"}),
+		mock.TextResponse("SELECT round(sum(Total_Sale_Value), 2) AS total_sales FROM sales"),
+		mock.TextResponse("Total sales were 66.75."),
+	)
+	completer.Delay = 200 * time.Millisecond
+	a := newTestAgent(t, Config{}, completer)
+
+	if paths := a.AbortLiveRuns(); len(paths) != 0 {
+		t.Fatalf("aborted %v without runs going on", paths)
+	}
+
+	// The run isn't cancelled, like one that doesn't stop in time after an interrupt
+	done := make(chan RunResult, 1)
+	go func() {
+		result, err := a.Run(context.Background(), "What were the total sales?")
+		if err != nil {
+			t.Error(err)
+		}
+		done <- result
+	}()
+
+	paths := []string{}
+	for deadline := time.Now().Add(5 * time.Second); len(paths) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the run never went live")
+		}
+		time.Sleep(10 * time.Millisecond)
+		paths = a.AbortLiveRuns()
+	}
+	if len(paths) != 1 {
+		t.Fatalf("aborted %d runs, expected 1", len(paths))
+	}
+
+	transcript, err := LoadTranscript(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !transcript.Aborted || transcript.Completed || transcript.Prompt != "What were the total sales?" {
+		t.Fatalf("wrote transcript aborted %t, completed %t, prompt '%s', expected an aborted run of the prompt",
+			transcript.Aborted, transcript.Completed, transcript.Prompt)
+	}
+	if len(transcript.Messages) == 0 {
+		t.Fatal("the aborted transcript has no messages to resume from")
+	}
+
+	result := <-done
+	checkpointed, err := LoadTranscript(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkpointed.Aborted {
+		t.Error("a checkpoint after the abort wrote the transcript as not aborted")
+	}
+	if len(checkpointed.Messages) <= len(transcript.Messages) {
+		t.Errorf("checkpoint kept %d messages, expected the tool result after the %d aborted ones", len(checkpointed.Messages), len(transcript.Messages))
+	}
+	if checkpointed.RunID != result.RunID {
+		t.Errorf("transcript of run '%s', expected '%s'", checkpointed.RunID, result.RunID)
+	}
+
+	if paths := a.AbortLiveRuns(); len(paths) != 0 {
+		t.Errorf("aborted %v after the run returned", paths)
+	}
+}
@@ -0,0 +1,248 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+/*
+----------------
+Transcript index
+----------------
+*/
+
+// Index of the messages of transcript.json, written next to it
+const transcriptIndexName = "transcript.index.json"
+
+// Byte range of a transcript message, with what finds it without reading it
+type MessageOffset struct {
+	Offset     int64  `json:"offset"`
+	Length     int64  `json:"length"`
+	ToolCallID string `json:"toolCallId,omitempty"` // Only on tool results
+}
+
+// Offsets of the messages of a transcript. Size is the one of the transcript indexed, so a transcript
+// rewritten without its index, like by an older build or a crash between both writes, doesn't match it
+type TranscriptIndex struct {
+	Size     int64           `json:"size"`
+	Messages []MessageOffset `json:"messages"`
+}
+
+// Write the index of the transcript written to runDir as transcriptBytes
+func writeTranscriptIndex(runDir string, transcriptBytes []byte) error {
+	_, offsets, err := scanTranscript(bytes.NewReader(transcriptBytes), false)
+	if err != nil {
+		return err
+	}
+
+	jsonBytes, err := json.Marshal(TranscriptIndex{Size: int64(len(transcriptBytes)), Messages: offsets})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(runDir, transcriptIndexName), jsonBytes, 0o644)
+}
+
+// Read a transcript one field at a time. With skipMessages it stops at the messages, which are written
+// last, otherwise their offsets are returned and only one message is held at a time.
+// On a partially written transcript, the fields and messages read before the cut are returned with the error
+func scanTranscript(reader io.Reader, skipMessages bool) (Transcript, []MessageOffset, error) {
+	decoder := json.NewDecoder(reader)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return Transcript{}, nil, errors.New("transcript isn't a JSON object")
+	}
+
+	fields := map[string]json.RawMessage{}
+	offsets := []MessageOffset{}
+	var scanErr error
+	for scanErr == nil && decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			scanErr = err
+			break
+		}
+		if key == "messages" && skipMessages {
+			break
+		}
+		if key == "messages" {
+			offsets, scanErr = scanMessages(decoder)
+			continue
+		}
+
+		value := json.RawMessage{}
+		scanErr = decoder.Decode(&value)
+		fields[fmt.Sprint(key)] = value
+	}
+	if scanErr == nil && !skipMessages {
+		if _, err := decoder.Token(); err != nil {
+			scanErr = err
+		}
+	}
+
+	transcript := Transcript{}
+	if fieldsBytes, err := json.Marshal(fields); err == nil {
+		json.Unmarshal(fieldsBytes, &transcript)
+	}
+
+	return transcript, offsets, scanErr
+}
+
+// Offsets of the messages of the array the decoder is at
+func scanMessages(decoder *json.Decoder) ([]MessageOffset, error) {
+	offsets := []MessageOffset{}
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return offsets, errors.New("transcript messages aren't an array")
+	}
+
+	for decoder.More() {
+		rawMessage := json.RawMessage{}
+		if err := decoder.Decode(&rawMessage); err != nil {
+			return offsets, err
+		}
+		message := struct {
+			ToolCallID string `json:"tool_call_id"`
+		}{}
+		json.Unmarshal(rawMessage, &message)
+
+		end := decoder.InputOffset()
+		offsets = append(offsets, MessageOffset{
+			Offset:     end - int64(len(rawMessage)),
+			Length:     int64(len(rawMessage)),
+			ToolCallID: message.ToolCallID,
+		})
+	}
+
+	_, err := decoder.Token()
+	return offsets, err
+}
+
+// Transcript read lazily: its fields are loaded on open, and its messages one at a time from their offsets
+type TranscriptReader struct {
+	Transcript Transcript      // Fields of the transcript, without its messages
+	Messages   []MessageOffset // Offsets of the messages, from the index or from scanning the transcript
+	Indexed    bool            // The offsets were read from the index
+	Err        error           // Why the transcript was only read up to a point, nil when it's whole
+	runDir     string
+	file       *os.File
+}
+
+// Open the transcript of the run at runDir. The messages are located with the transcript index when it
+// matches the transcript, or by scanning it once otherwise, like for transcripts written before indexes
+func OpenTranscript(runDir string) (*TranscriptReader, error) {
+	file, err := os.Open(filepath.Join(runDir, transcriptArtifactName))
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	reader := &TranscriptReader{runDir: runDir, file: file}
+	index := TranscriptIndex{}
+	if indexBytes, err := os.ReadFile(filepath.Join(runDir, transcriptIndexName)); err == nil && json.Unmarshal(indexBytes, &index) == nil {
+		reader.Indexed = index.Size == info.Size()
+	}
+
+	var offsets []MessageOffset
+	reader.Transcript, offsets, reader.Err = scanTranscript(io.NewSectionReader(file, 0, info.Size()), reader.Indexed)
+	reader.Messages = offsets
+	if reader.Indexed {
+		reader.Messages = index.Messages
+	}
+
+	return reader, nil
+}
+
+// Read and flatten message i
+func (r *TranscriptReader) Message(i int) (RunMessage, error) {
+	if i < 0 || i >= len(r.Messages) {
+		return RunMessage{}, fmt.Errorf("transcript has no message %d", i)
+	}
+
+	rawMessage := make([]byte, r.Messages[i].Length)
+	if _, err := r.file.ReadAt(rawMessage, r.Messages[i].Offset); err != nil {
+		return RunMessage{}, fmt.Errorf("failed to read transcript message %d: %w", i, err)
+	}
+	return flattenRunMessage(rawMessage), nil
+}
+
+// Read the result of a tool call
+func (r *TranscriptReader) ToolResult(toolCallID string) (RunMessage, error) {
+	for i, offset := range r.Messages {
+		if offset.ToolCallID == toolCallID {
+			return r.Message(i)
+		}
+	}
+
+	return RunMessage{}, fmt.Errorf("transcript has no result of tool call '%s'", toolCallID)
+}
+
+// Path of the lookup artifact holding the full result a tool result previews, found by its result_ref.
+// False if the tool result has no ref or its artifact is gone
+func (r *TranscriptReader) ResultArtifact(toolResult RunMessage) (string, bool) {
+	refs := tools.ResultRefs(toolResult.Content)
+	if len(refs) == 0 {
+		return "", false
+	}
+
+	for _, artifact := range r.Transcript.Artifacts {
+		if !strings.HasPrefix(artifact, "lookup-") || !strings.HasSuffix(artifact, ".csv") {
+			continue
+		}
+		artifactPath := filepath.Join(r.runDir, artifact)
+		content, err := os.ReadFile(artifactPath)
+		if err == nil && tools.ResultRefMatches(refs[0], content) {
+			return artifactPath, true
+		}
+	}
+
+	return "", false
+}
+
+func (r *TranscriptReader) Close() error {
+	return r.file.Close()
+}
+
+// Summary of a run on outputDir, by its ID or any unique part of it, with a lazy reader of its transcript.
+// Unlike LoadRun, the messages aren't loaded. Close the reader once done
+func OpenRun(outputDir string, runID string) (RunSummary, *TranscriptReader, error) {
+	match, err := findRun(outputDir, runID)
+	if err != nil {
+		return RunSummary{}, nil, err
+	}
+
+	summary, reader, err := openRunSummary(match.Dir, match.StartedAt)
+	if err != nil {
+		return RunSummary{}, nil, err
+	}
+	return summary, reader, nil
+}
+
+// Summarize the run at runDir from its transcript read lazily, see OpenTranscript. A transcript that can't
+// be opened fails, with the summary of an incomplete run holding the error
+func openRunSummary(runDir string, startedAt time.Time) (RunSummary, *TranscriptReader, error) {
+	summary := RunSummary{RunID: filepath.Base(runDir), Dir: runDir, StartedAt: startedAt, Status: RunIncomplete}
+	reader, err := OpenTranscript(runDir)
+	if err != nil {
+		summary.Error = err.Error()
+		return summary, nil, err
+	}
+
+	if reader.Err != nil {
+		summary.Error = fmt.Sprintf("partially written transcript: %s", reader.Err)
+	} else {
+		summary.Status = runStatus(reader.Transcript)
+	}
+	fillRunSummary(&summary, reader.Transcript)
+	return summary, reader, nil
+}
@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/openai/openai-go"
+)
+
+// Tool call of the test transcripts, with a large lookup result
+const transcriptToolCall = "call_lookup"
+
+// Rows of the lookup result of the test transcripts, a few MB
+const transcriptLookupRows = 100000
+
+// Transcripts read back after prepare changed them or their index: every message reads back like the whole
+// transcript decodes it, and the large tool result is found whole with its lookup artifact
+func TestOpenTranscript(t *testing.T) {
+	cases := []struct {
+		name     string
+		prepare  func(runDir string) error
+		indexed  bool
+		partial  bool
+		messages int
+	}{
+		{name: "indexed transcript", indexed: true, messages: 5},
+		{name: "transcript without index", prepare: removeTranscriptIndex, messages: 5},
+		{name: "stale index", prepare: rewriteWithoutIndex, messages: 6},
+		{name: "corrupt index", prepare: corruptTranscriptIndex, messages: 5},
+		{name: "transcript cut in the lookup result", prepare: cutTranscript, partial: true, messages: 3},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			runDir := t.TempDir()
+			lookup := transcriptLookup()
+			if err := os.WriteFile(filepath.Join(runDir, "lookup-1.csv"), []byte(lookup), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := WriteTranscript(transcriptRun(runDir, lookup), false); err != nil {
+				t.Fatal(err)
+			}
+			whole, err := LoadTranscript(filepath.Join(runDir, transcriptArtifactName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.prepare != nil {
+				if err := c.prepare(runDir); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !c.partial {
+				whole, err = LoadTranscript(filepath.Join(runDir, transcriptArtifactName))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			reader, err := OpenTranscript(runDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+
+			switch {
+			case reader.Indexed != c.indexed:
+				t.Fatalf("expected the index to be used to be %t", c.indexed)
+			case (reader.Err != nil) != c.partial:
+				t.Fatalf("expected a partial transcript to be %t, got error %v", c.partial, reader.Err)
+			case len(reader.Messages) != c.messages:
+				t.Fatalf("expected %d messages, found %d", c.messages, len(reader.Messages))
+			case reader.Transcript.Prompt != "Which store sold the most?":
+				t.Fatalf("transcript fields weren't read, prompt is '%s'", reader.Transcript.Prompt)
+			case len(reader.Transcript.Messages) != 0:
+				t.Fatal("the reader loaded the messages")
+			}
+
+			for i := range reader.Messages {
+				message, err := reader.Message(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if expected := flattenRunMessage(whole.Messages[i]); fmt.Sprint(message) != fmt.Sprint(expected) {
+					t.Fatalf("message %d reads as %s, expected %s", i, cutTranscriptText(fmt.Sprint(message)), cutTranscriptText(fmt.Sprint(expected)))
+				}
+			}
+
+			toolResult, err := reader.ToolResult(transcriptToolCall)
+			if c.partial {
+				if err == nil {
+					t.Fatal("found the tool result cut from the transcript")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(toolResult.Content, lookup+"</tool_output>") {
+				t.Fatal("the tool result isn't whole")
+			}
+			if artifact, ok := reader.ResultArtifact(toolResult); !ok || filepath.Base(artifact) != "lookup-1.csv" {
+				t.Fatalf("expected the lookup artifact of the tool result, got '%s'", artifact)
+			}
+			if _, err := reader.ToolResult("call_missing"); err == nil {
+				t.Fatal("found the result of an unknown tool call")
+			}
+		})
+	}
+}
+
+// Lookup result of the test transcripts
+func transcriptLookup() string {
+	builder := strings.Builder{}
+	builder.WriteString("Store_Number,Total_Sale_Value\n")
+	for i := range transcriptLookupRows {
+		fmt.Fprintf(&builder, "%d,%d.%02d\n", 1000+i, i*37, i%100)
+	}
+	return builder.String()
+}
+
+// Run of the test transcripts: a lookup with a large result, then the answer
+func transcriptRun(runDir string, lookup string) RunResult {
+	sum := sha256.Sum256([]byte(lookup))
+	ref := "res_" + hex.EncodeToString(sum[:])[:6]
+
+	return RunResult{
+		RunID:     filepath.Base(runDir),
+		RunDir:    runDir,
+		Prompt:    "Which store sold the most?",
+		Answer:    "Store 100999 sold the most.",
+		Artifacts: []string{"lookup-1.csv"},
+		Messages: []openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(systemPrompt),
+			openai.UserMessage("Which store sold the most?"),
+			openai.ChatCompletionMessage{
+				Role: openai.ChatCompletionMessageRoleAssistant,
+				ToolCalls: []openai.ChatCompletionMessageToolCall{{
+					ID:       transcriptToolCall,
+					Type:     openai.ChatCompletionMessageToolCallTypeFunction,
+					Function: openai.ChatCompletionMessageToolCallFunction{Name: tools.LookUpFuncName, Arguments: `{"prompt":"sales per store"}`},
+				}},
+			},
+			openai.ToolMessage(transcriptToolCall, fmt.Sprintf("<tool_output>\nresult_ref: %q (%d rows)\n%s</tool_output>", ref, transcriptLookupRows, lookup)),
+			openai.AssistantMessage("Store 100999 sold the most."),
+		},
+	}
+}
+
+func removeTranscriptIndex(runDir string) error {
+	return os.Remove(filepath.Join(runDir, transcriptIndexName))
+}
+
+// Rewrite the transcript with one more message, keeping the index of the previous one
+func rewriteWithoutIndex(runDir string) error {
+	index, err := os.ReadFile(filepath.Join(runDir, transcriptIndexName))
+	if err != nil {
+		return err
+	}
+	lookup, err := os.ReadFile(filepath.Join(runDir, "lookup-1.csv"))
+	if err != nil {
+		return err
+	}
+
+	result := transcriptRun(runDir, string(lookup))
+	result.Messages = append(result.Messages, openai.UserMessage("Thanks"))
+	if _, err := WriteTranscript(result, false); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(runDir, transcriptIndexName), index, 0o644)
+}
+
+func corruptTranscriptIndex(runDir string) error {
+	return os.WriteFile(filepath.Join(runDir, transcriptIndexName), []byte(`{"size": `), 0o644)
+}
+
+// Cut the transcript halfway, through the lookup result that makes most of it, like a crash mid write, and drop its index
+func cutTranscript(runDir string) error {
+	transcriptPath := filepath.Join(runDir, transcriptArtifactName)
+	transcriptBytes, err := os.ReadFile(transcriptPath)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(transcriptPath, transcriptBytes[:len(transcriptBytes)/2], 0o644); err != nil {
+		return err
+	}
+	return removeTranscriptIndex(runDir)
+}
+
+func cutTranscriptText(text string) string {
+	if len(text) > 200 {
+		return text[:200] + "..."
+	}
+	return text
+}
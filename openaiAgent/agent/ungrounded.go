@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/language"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/openai/openai-go"
+)
+
+/*
+------------------
+Ungrounded answers
+------------------
+*/
+
+// What a run does with an answer holding figures when it looked up no data
+const (
+	UngroundedWarn  = "warn"  // Append a warning to the answer
+	UngroundedRetry = "retry" // Ask the router once to verify the figures with a lookup, then warn if it still doesn't
+	UngroundedOff   = "off"   // Leave the answer as is
+)
+
+// What was done about an ungrounded answer, set on RunResult.UngroundedAction
+const (
+	UngroundedWarned        = "warned"
+	UngroundedRetried       = "retried"
+	UngroundedRetriedWarned = "retried_warned" // The corrective router call still answered without data
+)
+
+// Instruction added to the conversation on a corrective router call
+const ungroundedRetryPrompt = "Your answer has figures, but no data was looked up on this run." +
+	" Verify every figure with " + tools.LookUpFuncName + " before answering again, and don't state figures the data doesn't support."
+
+// Numbers with several digits, decimals or a percent sign. Single digits are left out,
+// they're usually counts of the answer itself like "the top 3 stores"
+var numericClaimRegex = regexp.MustCompile(`\d[\d,]*\.\d+%?|\d+(?:,\d{3})+|\d{2,}%?|\d%`)
+
+// Check an ungrounded policy. Empty is UngroundedWarn
+func normalizeUngroundedPolicy(policy string) (string, error) {
+	switch policy {
+	case "":
+		return UngroundedWarn, nil
+	case UngroundedWarn, UngroundedRetry, UngroundedOff:
+		return policy, nil
+	}
+
+	return "", fmt.Errorf("unknown ungrounded answers policy '%s', expected %s, %s or %s", policy, UngroundedWarn, UngroundedRetry, UngroundedOff)
+}
+
+// Whether an answer states numbers besides years, like amounts, counts or percentages
+func hasNumericClaims(answer string) bool {
+	for _, token := range numericClaimRegex.FindAllString(answer, -1) {
+		if year, err := strconv.Atoi(token); err == nil && year >= 1900 && year <= 2100 {
+			continue
+		}
+		return true
+	}
+
+	return false
+}
+
+// Whether an answer states numbers while the run never looked up the dataset, on a lookup or a period comparison
+func isUngrounded(answer string, messages []openai.ChatCompletionMessageParamUnion) bool {
+	called := calledTools(messages)
+	return hasNumericClaims(answer) && !slices.Contains(called, tools.LookUpFuncName) && !slices.Contains(called, tools.ComparePeriodsFuncName)
+}
+
+// Append the ungrounded answer warning, in the language of the run
+func appendUngroundedWarning(answer string, code string) string {
+	return fmt.Sprintf("%s\n\n%s", answer, language.Message(code, language.UngroundedAnswer))
+}
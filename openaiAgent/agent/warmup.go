@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+)
+
+/*
+---------------------
+Warm up and readiness
+---------------------
+*/
+
+// Components checked on warm up
+const (
+	ComponentDatabase = "db"
+	ComponentLLM      = "llm"
+	ComponentTracer   = "tracer"
+)
+
+// Result of warming up one component
+type ComponentStatus struct {
+	OK      bool   `json:"ok"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Result of a warm up. Ready is false until Warmup finishes
+type Readiness struct {
+	Ready      bool                         `json:"ready"`
+	ReadOnly   bool                         `json:"readOnly"`           // The database connection can't write, as detected on warm up
+	Database   *DatabaseStats               `json:"database,omitempty"` // Size of the database file, only for agents with one
+	Components map[string]ComponentStatus   `json:"components"`
+	RateLimit  *completion.RateLimiterStats `json:"rateLimit,omitempty"` // Completion budgets saturation and waits, only with rate limits
+}
+
+// Warm up state shared by every caller of an agent
+type warmupState struct {
+	readiness Readiness
+	mutex     sync.Mutex
+}
+
+// Pay the cold start costs before the first run: check the database and the dataset table,
+// start a span so the tracer exporter is set up, and if pingLLM is set,
+// make a 1 token completion so the first run doesn't pay for the connection to the API.
+// Failing components are marked on the result but don't stop the others
+func (a *Agent) Warmup(ctx context.Context, pingLLM bool) Readiness {
+	log.Println("Warming up agent")
+	readOnly, databaseErr := a.warmupDatabase(ctx)
+	databaseStats := (*DatabaseStats)(nil)
+	if databaseErr == nil {
+		databaseStats = a.warmupDatabaseSize(ctx)
+	}
+	components := map[string]ComponentStatus{
+		ComponentDatabase: componentStatus(databaseErr),
+		ComponentTracer:   componentStatus(a.warmupTracer(ctx, databaseStats)),
+		ComponentLLM:      {Skipped: true},
+	}
+
+	if pingLLM {
+		components[ComponentLLM] = componentStatus(a.warmupLLM(ctx))
+	}
+
+	for name, status := range components {
+		if !status.OK && !status.Skipped {
+			log.Printf("WARNING: Warm up of '%s' failed: %s\n", name, status.Error)
+		}
+	}
+
+	a.warmup.mutex.Lock()
+	defer a.warmup.mutex.Unlock()
+	a.warmup.readiness = Readiness{Ready: true, ReadOnly: readOnly, Database: databaseStats, Components: components}
+	return a.withRateLimit(a.warmup.readiness)
+}
+
+// Readiness of the agent. Not ready until Warmup has finished once
+func (a *Agent) Readiness() Readiness {
+	a.warmup.mutex.Lock()
+	defer a.warmup.mutex.Unlock()
+	return a.withRateLimit(a.warmup.readiness)
+}
+
+// Add the current rate limiter stats to a readiness, if completions are rate limited
+func (a *Agent) withRateLimit(readiness Readiness) Readiness {
+	if a.limiter != nil {
+		stats := a.limiter.Stats()
+		readiness.RateLimit = &stats
+	}
+
+	return readiness
+}
+
+// Whether every warmed up component is healthy
+func (r Readiness) Healthy() bool {
+	for _, status := range r.Components {
+		if !status.OK && !status.Skipped {
+			return false
+		}
+	}
+
+	return r.Ready
+}
+
+func componentStatus(err error) ComponentStatus {
+	if err != nil {
+		return ComponentStatus{Error: err.Error()}
+	}
+
+	return ComponentStatus{OK: true}
+}
+
+// Check the database connection and that the dataset table can be read. Returns whether the connection
+// is read-only, failing when a production deployment can write
+func (a *Agent) warmupDatabase(ctx context.Context) (bool, error) {
+	if err := a.store.Ping(ctx); err != nil {
+		if tools.IsLiveDatabase(a.config.DataPath) {
+			return false, fmt.Errorf("live database %s is unreachable: %s", tools.RedactDSN(a.config.DataPath), tools.RedactDSN(err.Error()))
+		}
+		return false, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	if len(a.toolParams()) == 0 {
+		return false, fmt.Errorf("no tools loaded from %s", a.config.ToolsJsonPath)
+	}
+
+	if err := tools.PingDataset(ctx, a.store); err != nil {
+		return false, err
+	}
+
+	// The connection is asked, a DSN can ignore the access mode it was opened with
+	readOnly, err := a.store.ReadOnly(ctx)
+	if err != nil {
+		return false, err
+	}
+	if a.config.Production && !readOnly {
+		log.Println("ERROR: Production deployment with a database connection that can write")
+		return false, fmt.Errorf("production deployment with a database connection that can write, open it read-only")
+	}
+
+	return readOnly, nil
+}
+
+// Start and end a span so the tracer provider sets up its exporter. The span carries the database size,
+// so its growth can be followed across deployments
+func (a *Agent) warmupTracer(ctx context.Context, databaseStats *DatabaseStats) error {
+	_, span := a.tracer.StartOpenInferenceSpan(ctx, "Warmup", tracing.ChainKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	if databaseStats != nil {
+		tracing.SetSpanAttrFromMap(span, map[string]any{
+			"db.size_bytes": databaseStats.SizeBytes,
+			"db.vacuumed":   databaseStats.Vacuumed,
+		})
+	}
+	tracing.SetSpanSuccessCode(span)
+	return nil
+}
+
+// Make a 1 token completion to open the connection to the chat API.
+// Failures carry the OpenAI request ID when the API answered
+func (a *Agent) warmupLLM(ctx context.Context) error {
+	_, err := tools.NewRequestRecorder(a.completer).New(ctx, openai.ChatCompletionNewParams{
+		Model:     openai.F(a.config.Model),
+		Messages:  openai.F([]openai.ChatCompletionMessageParamUnion{openai.UserMessage("ping")}),
+		MaxTokens: openai.Int(1),
+	})
+
+	if err != nil {
+		return fmt.Errorf("ping completion failed: %w", err)
+	}
+
+	return nil
+}
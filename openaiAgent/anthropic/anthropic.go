@@ -0,0 +1,206 @@
+// Package anthropic completes chat requests with the Anthropic Messages API, so single steps can run on Claude models.
+// OpenAI requests are translated to Anthropic messages, tool definitions and forced tool calls for structured output,
+// and responses back to the OpenAI completions and tool calls the agent loop expects.
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/llmdebug"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+-------------
+Configuration
+-------------
+*/
+
+// Environment variables of the Anthropic backend
+const (
+	APIKeyEnv  = "ANTHROPIC_API_KEY"
+	BaseURLEnv = "ANTHROPIC_BASE_URL" // API or gateway URL, defaults to DefaultBaseURL
+)
+
+const DefaultBaseURL = "https://api.anthropic.com"
+
+// Models served by this backend start with it
+const ModelPrefix = "claude"
+
+// Messages API version sent on every request
+const apiVersion = "2023-06-01"
+
+// Response header identifying a request
+const requestIDHeader = "request-id"
+
+// Max tokens of a completion when the request sets none, Anthropic requires one
+const defaultMaxTokens = 4096
+
+// Retries of rate limited and overloaded requests, like the OpenAI client does
+const maxRetries = 2
+
+// Name of the forced tool answering structured output requests without a schema name
+const defaultStructuredOutputTool = "structured_output"
+
+// No API key is configured, every request would fail
+var ErrMissingAPIKey = errors.New(APIKeyEnv + " is not set")
+
+// Check if a model is served by this backend
+func IsModel(model string) bool {
+	return strings.HasPrefix(model, ModelPrefix)
+}
+
+/*
+--------------
+Chat completer
+--------------
+*/
+
+// Chat completer backed by the Anthropic Messages API. Satisfies tools.ChatCompleter.
+// OpenAI request options don't apply to it and are ignored
+type Completer struct {
+	apiKey     string
+	baseURL    string
+	httpClient *http.Client
+}
+
+// Create a completer configured from the environment.
+// Returns ErrMissingAPIKey when no API key is set instead of failing on the first request
+func NewCompleter() (*Completer, error) {
+	apiKey := os.Getenv(APIKeyEnv)
+	if apiKey == "" {
+		return nil, ErrMissingAPIKey
+	}
+
+	baseURL := os.Getenv(BaseURLEnv)
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+
+	settings, err := completion.HTTPSettingsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("Creating new Anthropic client")
+	return &Completer{apiKey: apiKey, baseURL: strings.TrimSuffix(baseURL, "/"), httpClient: completion.NewHTTPClient(settings)}, nil
+}
+
+// API error answered by Anthropic
+type Error struct {
+	StatusCode int
+	Type       string // Error type, like "rate_limit_error"
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("anthropic request failed with status %d: %s: %s", e.StatusCode, e.Type, e.Message)
+}
+func (e *Error) ExitClass() exitcode.Class { return exitcode.ClassUpstream }
+
+// Rate limits, overloads and server errors may succeed later
+func (e *Error) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+func (c *Completer) New(
+	ctx context.Context,
+	body openai.ChatCompletionNewParams,
+	opts ...option.RequestOption,
+) (*openai.ChatCompletion, error) {
+	request, structuredTool, err := translateRequest(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to translate request for %s: %w", body.Model.Value, err)
+	}
+
+	// The LLM span was started for an OpenAI model
+	tracing.SetSpanAttrFromMap(trace.SpanFromContext(ctx), map[string]any{
+		"llm.provider": "anthropic",
+		"llm.system":   "anthropic",
+	})
+
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		responseBody, requestID, err := c.send(ctx, requestBody)
+		if requestID != "" {
+			tracing.SetSpanAttr(trace.SpanFromContext(ctx), "llm.request_id", requestID)
+		}
+		if err == nil {
+			return translateResponse(responseBody, structuredTool)
+		}
+
+		var apiErr *Error
+		if attempt == maxRetries || !errors.As(err, &apiErr) || !apiErr.retryable() {
+			return nil, completion.WithRequestID(err, requestID)
+		}
+
+		backoff := time.Duration(attempt+1) * time.Second
+		log.Printf("WARNING: %s, retrying in %s\n", err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Send a Messages API request. Returns the response body and request ID, or an Error for non 2xx answers
+func (c *Completer) send(ctx context.Context, requestBody []byte) ([]byte, string, error) {
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/v1/messages", bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, "", err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("X-Api-Key", c.apiKey)
+	httpRequest.Header.Set("Anthropic-Version", apiVersion)
+
+	// The debug mode writes these requests too
+	do := c.httpClient.Do
+	if llmdebug.Enabled() {
+		do = func(request *http.Request) (*http.Response, error) {
+			return llmdebug.Middleware(llmdebug.Dir())(request, c.httpClient.Do)
+		}
+	}
+
+	httpResponse, err := do(httpRequest)
+	if err != nil {
+		return nil, "", err
+	}
+	defer httpResponse.Body.Close()
+
+	requestID := httpResponse.Header.Get(requestIDHeader)
+	responseBody, err := io.ReadAll(httpResponse.Body)
+	if err != nil {
+		return nil, requestID, fmt.Errorf("failed to read anthropic response: %w", err)
+	}
+
+	if httpResponse.StatusCode/100 != 2 {
+		apiErr := &Error{StatusCode: httpResponse.StatusCode, Type: "unknown_error", Message: string(responseBody)}
+		errorBody := errorResponse{}
+		if json.Unmarshal(responseBody, &errorBody) == nil && errorBody.Error.Type != "" {
+			apiErr.Type, apiErr.Message = errorBody.Error.Type, errorBody.Error.Message
+		}
+		return nil, requestID, apiErr
+	}
+
+	return responseBody, requestID, nil
+}
@@ -0,0 +1,402 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+/*
+----------------
+Wire definitions
+----------------
+*/
+
+// Fields of an OpenAI chat completion request this backend translates
+type openaiRequest struct {
+	Model               string          `json:"model"`
+	Messages            []openaiMessage `json:"messages"`
+	Tools               []openaiTool    `json:"tools"`
+	ToolChoice          json.RawMessage `json:"tool_choice"`
+	ResponseFormat      *openaiFormat   `json:"response_format"`
+	Temperature         *float64        `json:"temperature"`
+	MaxTokens           int64           `json:"max_tokens"`
+	MaxCompletionTokens int64           `json:"max_completion_tokens"`
+}
+
+type openaiMessage struct {
+	Role       string           `json:"role"`
+	Content    json.RawMessage  `json:"content"`
+	ToolCalls  []openaiToolCall `json:"tool_calls"`
+	ToolCallID string           `json:"tool_call_id"`
+}
+
+type openaiToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openaiTool struct {
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		Parameters  json.RawMessage `json:"parameters"`
+	} `json:"function"`
+}
+
+type openaiFormat struct {
+	Type       string `json:"type"`
+	JSONSchema *struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		Schema      json.RawMessage `json:"schema"`
+	} `json:"json_schema"`
+}
+
+// Messages API request
+type request struct {
+	Model       string      `json:"model"`
+	System      string      `json:"system,omitempty"`
+	Messages    []message   `json:"messages"`
+	Tools       []tool      `json:"tools,omitempty"`
+	ToolChoice  *toolChoice `json:"tool_choice,omitempty"`
+	MaxTokens   int64       `json:"max_tokens"`
+	Temperature *float64    `json:"temperature,omitempty"`
+}
+
+type message struct {
+	Role    string  `json:"role"`
+	Content []block `json:"content"`
+}
+
+// Content block: text, tool_use or tool_result
+type block struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type toolChoice struct {
+	Type string `json:"type"` // auto, any, none or tool
+	Name string `json:"name,omitempty"`
+}
+
+// Messages API response
+type response struct {
+	ID         string  `json:"id"`
+	Model      string  `json:"model"`
+	Content    []block `json:"content"`
+	StopReason string  `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int64 `json:"input_tokens"`
+		OutputTokens int64 `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+type errorResponse struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// OpenAI finish reasons of the Anthropic stop reasons
+var finishReasons = map[string]openai.ChatCompletionChoicesFinishReason{
+	"end_turn":      openai.ChatCompletionChoicesFinishReasonStop,
+	"stop_sequence": openai.ChatCompletionChoicesFinishReasonStop,
+	"max_tokens":    openai.ChatCompletionChoicesFinishReasonLength,
+	"tool_use":      openai.ChatCompletionChoicesFinishReasonToolCalls,
+	"refusal":       openai.ChatCompletionChoicesFinishReasonContentFilter,
+}
+
+/*
+-------------------
+Request translation
+-------------------
+*/
+
+// Translate an OpenAI request to a Messages API request. Structured output requests are answered with
+// a forced call to a tool taking the schema as input, whose name is returned to read the answer back
+func translateRequest(body openai.ChatCompletionNewParams) (request, string, error) {
+	rawBody, err := json.Marshal(body)
+	if err != nil {
+		return request{}, "", err
+	}
+
+	return translateRawRequest(rawBody)
+}
+
+// Translate the JSON of an OpenAI request, see translateRequest
+func translateRawRequest(rawBody []byte) (request, string, error) {
+	source := openaiRequest{}
+	if err := json.Unmarshal(rawBody, &source); err != nil {
+		return request{}, "", err
+	}
+
+	translated := request{Model: source.Model, Temperature: source.Temperature, MaxTokens: defaultMaxTokens}
+	if source.MaxCompletionTokens > 0 {
+		translated.MaxTokens = source.MaxCompletionTokens
+	} else if source.MaxTokens > 0 {
+		translated.MaxTokens = source.MaxTokens
+	}
+
+	systemPrompts := []string{}
+	for i, sourceMessage := range source.Messages {
+		text, err := contentText(sourceMessage.Content)
+		if err != nil {
+			return request{}, "", fmt.Errorf("message %d: %w", i, err)
+		}
+
+		switch sourceMessage.Role {
+		case "system", "developer":
+			systemPrompts = append(systemPrompts, text)
+		case "user":
+			translated.Messages = appendBlocks(translated.Messages, "user", textBlocks(text)...)
+		case "assistant":
+			blocks := textBlocks(text)
+			for _, toolCall := range sourceMessage.ToolCalls {
+				blocks = append(blocks, toolUseBlock(toolCall))
+			}
+			translated.Messages = appendBlocks(translated.Messages, "assistant", blocks...)
+		case "tool":
+			// Tool results are user content answering the tool_use block of the same ID
+			result := block{Type: "tool_result", ToolUseID: sourceMessage.ToolCallID, Content: text}
+			translated.Messages = appendBlocks(translated.Messages, "user", result)
+		default:
+			return request{}, "", fmt.Errorf("message %d: unexpected role '%s'", i, sourceMessage.Role)
+		}
+	}
+	translated.System = strings.Join(systemPrompts, "\n\n")
+
+	for _, sourceTool := range source.Tools {
+		translated.Tools = append(translated.Tools, tool{
+			Name:        sourceTool.Function.Name,
+			Description: sourceTool.Function.Description,
+			InputSchema: objectSchema(sourceTool.Function.Parameters),
+		})
+	}
+
+	choice, err := translateToolChoice(source.ToolChoice)
+	if err != nil {
+		return request{}, "", err
+	}
+	translated.ToolChoice = choice
+
+	structuredTool := ""
+	if format := source.ResponseFormat; format != nil {
+		switch {
+		case format.Type == "json_schema" && format.JSONSchema != nil:
+			structuredTool = format.JSONSchema.Name
+			if structuredTool == "" {
+				structuredTool = defaultStructuredOutputTool
+			}
+			translated.Tools = append(translated.Tools, tool{
+				Name:        structuredTool,
+				Description: strings.TrimSpace("Reply by calling this tool with the answer. " + format.JSONSchema.Description),
+				InputSchema: objectSchema(format.JSONSchema.Schema),
+			})
+			translated.ToolChoice = &toolChoice{Type: "tool", Name: structuredTool}
+		case format.Type == "json_object":
+			translated.System = strings.TrimSpace(translated.System + "\n\nReply with a single JSON object and nothing else.")
+		}
+	}
+
+	return translated, structuredTool, nil
+}
+
+// Append blocks to the conversation. Consecutive messages of a role are merged, the API expects roles to alternate
+func appendBlocks(messages []message, role string, blocks ...block) []message {
+	if len(blocks) == 0 {
+		return messages
+	}
+
+	if last := len(messages) - 1; last >= 0 && messages[last].Role == role {
+		messages[last].Content = append(messages[last].Content, blocks...)
+		return messages
+	}
+
+	return append(messages, message{Role: role, Content: blocks})
+}
+
+// Text block of a message, none for empty text which the API rejects
+func textBlocks(text string) []block {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+
+	return []block{{Type: "text", Text: text}}
+}
+
+// tool_use block of an OpenAI tool call. Arguments that aren't a JSON object are sent as an empty input
+func toolUseBlock(toolCall openaiToolCall) block {
+	input := json.RawMessage(toolCall.Function.Arguments)
+	if !json.Valid(input) || !strings.HasPrefix(strings.TrimSpace(toolCall.Function.Arguments), "{") {
+		input = json.RawMessage("{}")
+	}
+
+	return block{Type: "tool_use", ID: toolCall.ID, Name: toolCall.Function.Name, Input: input}
+}
+
+// Input schema of a tool. Tools without parameters take an empty object
+func objectSchema(schema json.RawMessage) json.RawMessage {
+	if len(schema) == 0 || string(schema) == "null" {
+		return json.RawMessage(`{"type":"object","properties":{}}`)
+	}
+
+	return schema
+}
+
+// Translate an OpenAI tool choice: "auto", "none", "required" or a named function
+func translateToolChoice(rawChoice json.RawMessage) (*toolChoice, error) {
+	if len(rawChoice) == 0 || string(rawChoice) == "null" {
+		return nil, nil
+	}
+
+	mode := ""
+	if err := json.Unmarshal(rawChoice, &mode); err == nil {
+		switch mode {
+		case "auto":
+			return &toolChoice{Type: "auto"}, nil
+		case "none":
+			return &toolChoice{Type: "none"}, nil
+		case "required":
+			return &toolChoice{Type: "any"}, nil
+		}
+		return nil, fmt.Errorf("unexpected tool choice '%s'", mode)
+	}
+
+	named := struct {
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}{}
+	if err := json.Unmarshal(rawChoice, &named); err != nil || named.Function.Name == "" {
+		return nil, fmt.Errorf("unexpected tool choice %s", rawChoice)
+	}
+
+	return &toolChoice{Type: "tool", Name: named.Function.Name}, nil
+}
+
+// Text of a message content, either a plain string or text parts
+func contentText(rawContent json.RawMessage) (string, error) {
+	if len(rawContent) == 0 || string(rawContent) == "null" {
+		return "", nil
+	}
+
+	text := ""
+	if err := json.Unmarshal(rawContent, &text); err == nil {
+		return text, nil
+	}
+
+	parts := []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}{}
+	if err := json.Unmarshal(rawContent, &parts); err != nil {
+		return "", fmt.Errorf("unexpected content: %w", err)
+	}
+
+	texts := []string{}
+	for _, part := range parts {
+		if part.Type != "" && part.Type != "text" {
+			return "", fmt.Errorf("unsupported content part '%s'", part.Type)
+		}
+		texts = append(texts, part.Text)
+	}
+
+	return strings.Join(texts, ""), nil
+}
+
+/*
+--------------------
+Response translation
+--------------------
+*/
+
+// Translate a Messages API response to an OpenAI chat completion. tool_use blocks become tool calls,
+// except the one of structuredTool, whose input is the message content like structured output is
+func translateResponse(responseBody []byte, structuredTool string) (*openai.ChatCompletion, error) {
+	source := response{}
+	if err := json.Unmarshal(responseBody, &source); err != nil {
+		return nil, fmt.Errorf("failed to decode anthropic response: %w", err)
+	}
+
+	texts := []string{}
+	toolCalls := []openaiToolCall{}
+	for _, contentBlock := range source.Content {
+		switch contentBlock.Type {
+		case "text":
+			texts = append(texts, contentBlock.Text)
+		case "tool_use":
+			if structuredTool != "" && contentBlock.Name == structuredTool {
+				texts = append(texts, string(contentBlock.Input))
+				continue
+			}
+			toolCall := openaiToolCall{ID: contentBlock.ID, Type: "function"}
+			toolCall.Function.Name = contentBlock.Name
+			toolCall.Function.Arguments = string(contentBlock.Input)
+			toolCalls = append(toolCalls, toolCall)
+		}
+	}
+
+	finishReason, ok := finishReasons[source.StopReason]
+	if !ok {
+		finishReason = openai.ChatCompletionChoicesFinishReasonStop
+	}
+	// The forced structured output call is the answer, not a tool call
+	if finishReason == openai.ChatCompletionChoicesFinishReasonToolCalls && len(toolCalls) == 0 {
+		finishReason = openai.ChatCompletionChoicesFinishReasonStop
+	}
+
+	responseMessage := map[string]any{"role": "assistant", "content": strings.Join(texts, "")}
+	if len(toolCalls) > 0 {
+		responseMessage["tool_calls"] = toolCalls
+	}
+	if source.StopReason == "refusal" {
+		responseMessage["refusal"] = strings.Join(texts, "")
+	}
+
+	// Built from JSON so the completion keeps its raw JSON like the OpenAI client's
+	rawCompletion, err := json.Marshal(map[string]any{
+		"id":      source.ID,
+		"object":  "chat.completion",
+		"model":   source.Model,
+		"created": 0,
+		"choices": []map[string]any{{
+			"index":         0,
+			"finish_reason": finishReason,
+			"message":       responseMessage,
+		}},
+		"usage": map[string]int64{
+			"prompt_tokens":     source.Usage.InputTokens,
+			"completion_tokens": source.Usage.OutputTokens,
+			"total_tokens":      source.Usage.InputTokens + source.Usage.OutputTokens,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	chatCompletion := &openai.ChatCompletion{}
+	if err := json.Unmarshal(rawCompletion, chatCompletion); err != nil {
+		return nil, fmt.Errorf("failed to build completion from anthropic response: %w", err)
+	}
+
+	return chatCompletion, nil
+}
@@ -0,0 +1,206 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/openai/openai-go"
+)
+
+// Recorded conversations: the OpenAI request the agent sends, the Messages API request it should become,
+// an answer recorded from Anthropic and the OpenAI completion parts it should become
+const fixturesDir = "../testdata/anthropic"
+
+type fixture struct {
+	Description       string          `json:"description"`
+	OpenaiRequest     json.RawMessage `json:"openaiRequest"`
+	AnthropicRequest  json.RawMessage `json:"anthropicRequest"`
+	StructuredTool    string          `json:"structuredTool"`
+	AnthropicResponse json.RawMessage `json:"anthropicResponse"`
+	OpenaiResponse    struct {
+		ID           string `json:"id"`
+		Model        string `json:"model"`
+		FinishReason string `json:"finishReason"`
+		Content      string `json:"content"`
+		ToolCalls    []struct {
+			ID        string `json:"id"`
+			Name      string `json:"name"`
+			Arguments string `json:"arguments"`
+		} `json:"toolCalls"`
+		Usage struct {
+			PromptTokens     int64 `json:"promptTokens"`
+			CompletionTokens int64 `json:"completionTokens"`
+			TotalTokens      int64 `json:"totalTokens"`
+		} `json:"usage"`
+	} `json:"openaiResponse"`
+}
+
+func loadFixtures(t *testing.T) map[string]fixture {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(fixturesDir, "*.json"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no fixtures in %s: %v", fixturesDir, err)
+	}
+
+	fixtures := map[string]fixture{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded := fixture{}
+		if err := json.Unmarshal(data, &loaded); err != nil {
+			t.Fatalf("failed to decode %s: %s", path, err)
+		}
+		fixtures[filepath.Base(path)] = loaded
+	}
+	return fixtures
+}
+
+// Compare JSON documents ignoring key order and formatting
+func assertSameJSON(t *testing.T, got []byte, want []byte) {
+	t.Helper()
+	var gotValue, wantValue any
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("invalid JSON %s: %s", got, err)
+	}
+	if err := json.Unmarshal(want, &wantValue); err != nil {
+		t.Fatalf("invalid expected JSON %s: %s", want, err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Fatalf("JSON mismatch\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestTranslateRequestFixtures(t *testing.T) {
+	for name, recorded := range loadFixtures(t) {
+		t.Run(name, func(t *testing.T) {
+			translated, structuredTool, err := translateRawRequest(recorded.OpenaiRequest)
+			if err != nil {
+				t.Fatalf("%s: %s", recorded.Description, err)
+			}
+			if structuredTool != recorded.StructuredTool {
+				t.Fatalf("expected structured tool '%s', got '%s'", recorded.StructuredTool, structuredTool)
+			}
+
+			rawTranslated, err := json.Marshal(translated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertSameJSON(t, rawTranslated, recorded.AnthropicRequest)
+		})
+	}
+}
+
+func TestTranslateResponseFixtures(t *testing.T) {
+	for name, recorded := range loadFixtures(t) {
+		t.Run(name, func(t *testing.T) {
+			chatCompletion, err := translateResponse(recorded.AnthropicResponse, recorded.StructuredTool)
+			if err != nil {
+				t.Fatalf("%s: %s", recorded.Description, err)
+			}
+			assertCompletion(t, chatCompletion, recorded)
+		})
+	}
+}
+
+func assertCompletion(t *testing.T, chatCompletion *openai.ChatCompletion, recorded fixture) {
+	t.Helper()
+	want := recorded.OpenaiResponse
+	if chatCompletion.ID != want.ID || chatCompletion.Model != want.Model {
+		t.Fatalf("expected completion %s of %s, got %s of %s", want.ID, want.Model, chatCompletion.ID, chatCompletion.Model)
+	}
+	if len(chatCompletion.Choices) != 1 {
+		t.Fatalf("expected 1 choice, got %d", len(chatCompletion.Choices))
+	}
+
+	choice := chatCompletion.Choices[0]
+	if string(choice.FinishReason) != want.FinishReason {
+		t.Fatalf("expected finish reason %s, got %s", want.FinishReason, choice.FinishReason)
+	}
+	if choice.Message.Content != want.Content {
+		t.Fatalf("expected content %q, got %q", want.Content, choice.Message.Content)
+	}
+	if choice.Message.Role != openai.ChatCompletionMessageRoleAssistant {
+		t.Fatalf("expected an assistant message, got %s", choice.Message.Role)
+	}
+
+	if len(choice.Message.ToolCalls) != len(want.ToolCalls) {
+		t.Fatalf("expected %d tool calls, got %d", len(want.ToolCalls), len(choice.Message.ToolCalls))
+	}
+	for i, toolCall := range choice.Message.ToolCalls {
+		wantCall := want.ToolCalls[i]
+		if toolCall.ID != wantCall.ID || toolCall.Function.Name != wantCall.Name {
+			t.Fatalf("tool call %d: expected %s %s, got %s %s", i, wantCall.ID, wantCall.Name, toolCall.ID, toolCall.Function.Name)
+		}
+		if toolCall.Type != openai.ChatCompletionMessageToolCallTypeFunction {
+			t.Fatalf("tool call %d: expected a function call, got %s", i, toolCall.Type)
+		}
+		assertSameJSON(t, []byte(toolCall.Function.Arguments), []byte(wantCall.Arguments))
+	}
+
+	usage := chatCompletion.Usage
+	if usage.PromptTokens != want.Usage.PromptTokens ||
+		usage.CompletionTokens != want.Usage.CompletionTokens ||
+		usage.TotalTokens != want.Usage.TotalTokens {
+		t.Fatalf("expected usage %+v, got %d/%d/%d", want.Usage, usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens)
+	}
+}
+
+// The completer sends the translated request with the API headers and answers with the translated response
+func TestCompleterReplaysToolUse(t *testing.T) {
+	recorded := loadFixtures(t)["tool_use.json"]
+
+	var gotHeader http.Header
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			http.NotFound(w, r)
+			return
+		}
+		gotHeader = r.Header.Clone()
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Header().Set(requestIDHeader, "req_01")
+		w.Write(recorded.AnthropicResponse)
+	}))
+	defer server.Close()
+
+	completer := &Completer{apiKey: "test-key", baseURL: server.URL, httpClient: server.Client()}
+	body := openai.ChatCompletionNewParams{
+		Model: openai.F("claude-3-5-sonnet-latest"),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage("You are a helpful assistant."),
+			openai.UserMessage("What were the total sales?"),
+		}),
+		Tools: openai.F([]openai.ChatCompletionToolParam{{
+			Type: openai.F(openai.ChatCompletionToolTypeFunction),
+			Function: openai.F(openai.FunctionDefinitionParam{
+				Name:        openai.F("LookUpSalesData"),
+				Description: openai.F("Look up data from the sales dataset"),
+				Parameters: openai.F(openai.FunctionParameters{
+					"type":       "object",
+					"properties": map[string]any{"prompt": map[string]any{"type": "string"}},
+					"required":   []string{"prompt"},
+				}),
+			}),
+		}}),
+		ToolChoice: openai.F[openai.ChatCompletionToolChoiceOptionUnionParam](openai.ChatCompletionToolChoiceOptionAutoAuto),
+	}
+
+	chatCompletion, err := completer.New(t.Context(), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotHeader.Get("X-Api-Key") != "test-key" || gotHeader.Get("Anthropic-Version") != apiVersion {
+		t.Fatalf("expected API key and version headers, got %v", gotHeader)
+	}
+	assertSameJSON(t, gotBody, recorded.AnthropicRequest)
+	assertCompletion(t, chatCompletion, recorded)
+}
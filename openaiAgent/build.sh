@@ -6,18 +6,9 @@ EXECNAME=$pwd
 
 mkdir -p $DIRNAME/bin/v1
 
-cd $DIRNAME/src/agent
+cd $DIRNAME
 go mod tidy
 
-cd $DIRNAME/src/tools
-go mod tidy
-
-cd $DIRNAME/src/trace
-go mod tidy
-
-cd $DIRNAME/src/main
-go mod tidy
-
-go build -o ../../bin/v1/main.o
+go build -o bin/v1/main.o ./cmd/agent
 
-cd $EXECNAME
\ No newline at end of file
+cd $EXECNAME
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/openai/openai-go"
+)
+
+// Costs shown for usages, computed by hand
+func TestEstimateCost(t *testing.T) {
+	cases := []struct {
+		name  string
+		model string
+		usage tools.Usage
+		want  string // Shown with costDecimals, "" for unknown models
+	}{
+		{name: "million tokens", model: openai.ChatModelGPT4oMini, usage: tools.Usage{PromptTokens: 1_000_000, CompletionTokens: 1_000_000}, want: "0.75000"},
+		{name: "tie rounds to even down", model: openai.ChatModelGPT4o, usage: tools.Usage{PromptTokens: 10}, want: "0.00002"},
+		{name: "tie rounds to even up", model: openai.ChatModelGPT4o, usage: tools.Usage{PromptTokens: 14}, want: "0.00004"},
+		{name: "mixed tie", model: openai.ChatModelGPT4o, usage: tools.Usage{PromptTokens: 1234, CompletionTokens: 567}, want: "0.00876"},
+		{name: "int32 boundary", model: openai.ChatModelO3Mini, usage: tools.Usage{PromptTokens: 1 << 31, CompletionTokens: 1<<31 - 1}, want: "11811.16006"},
+		{name: "float64 exact integers", model: openai.ChatModelGPT4oMini, usage: tools.Usage{PromptTokens: 1<<53 + 1}, want: "1351079888.21115"},
+		{name: "int64 limit", model: openai.ChatModelGPT4oMini, usage: tools.Usage{PromptTokens: math.MaxInt64}, want: "1383505805528.21637"},
+		{name: "unknown model", model: "local-model", usage: tools.Usage{PromptTokens: 100}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			shown := ""
+			if cost := estimateCost(c.model, c.usage); cost != nil {
+				shown = roundHalfEven(cost, costDecimals)
+			}
+			if shown != c.want {
+				t.Fatalf("expected the cost '%s', got '%s'", c.want, shown)
+			}
+		})
+	}
+}
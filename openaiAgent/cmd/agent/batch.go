@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"os"
+	"os/signal"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/prompts"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+/*
+----------
+Batch mode
+----------
+*/
+
+// Input line of a batch file. Only the question is required
+type batchQuestion struct {
+	ID              string   `json:"id"`
+	Question        string   `json:"question"`
+	ExpectedColumns []string `json:"expected_columns,omitempty"`
+	Verbosity       string   `json:"verbosity,omitempty"` // Answer depth of this question, overriding -verbosity
+
+	Tags map[string]string `json:"tags,omitempty"` // Metadata tags of this question, merged over the -tag ones
+}
+
+// Output line of a batch file. Error is empty for successful runs
+type batchResult struct {
+	ID         string                      `json:"id"`
+	Question   string                      `json:"question"`
+	Answer     string                      `json:"answer"`
+	Usage      tools.Usage                 `json:"usage"`
+	Retrieval  tools.RetrievalStats        `json:"retrieval"`
+	Timings    map[string]tools.StepTiming `json:"timings,omitempty"`
+	Provenance *agent.Provenance           `json:"provenance,omitempty"` // Only with -explain
+	Tags       map[string]string           `json:"tags,omitempty"`
+	RunID      string                      `json:"run_id"`
+	TraceID    string                      `json:"trace_id"`
+	Duration   float64                     `json:"duration"` // Seconds
+	Error      string                      `json:"error,omitempty"`
+}
+
+// Appends batch results to the output file, one JSON line each, in the order of their questions
+// whatever order they finish in
+type batchWriter struct {
+	file    *os.File
+	mutex   sync.Mutex
+	next    int                  // Index of the next question to write
+	pending map[int]*batchResult // Finished questions waiting for earlier ones. Nil results aren't written
+}
+
+func newBatchWriter(file *os.File) *batchWriter {
+	return &batchWriter{file: file, pending: map[int]*batchResult{}}
+}
+
+func (w *batchWriter) write(result batchResult) error {
+	jsonBytes, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.file.Write(append(jsonBytes, '\n'))
+	return err
+}
+
+// Record the result of the question at index, writing it along the later ones already finished once every
+// earlier question finished. A nil result, like the one of an interrupted question, isn't written
+func (w *batchWriter) finish(index int, result *batchResult) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.pending[index] = result
+	for {
+		result, ok := w.pending[w.next]
+		if !ok {
+			return nil
+		}
+		delete(w.pending, w.next)
+		w.next++
+		if result == nil {
+			continue
+		}
+		if err := w.write(*result); err != nil {
+			return err
+		}
+	}
+}
+
+// Write the finished results still waiting for earlier questions that never ran, like after an interrupt
+func (w *batchWriter) flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, index := range slices.Sorted(maps.Keys(w.pending)) {
+		if result := w.pending[index]; result != nil {
+			if err := w.write(*result); err != nil {
+				return err
+			}
+		}
+		delete(w.pending, index)
+	}
+	return nil
+}
+
+// Read the questions of a batch input file. Questions without id get their line number as id
+func readBatchQuestions(inputPath string) ([]batchQuestion, error) {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	questions := []batchQuestion{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		question := batchQuestion{}
+		if err := json.Unmarshal([]byte(line), &question); err != nil {
+			return nil, fmt.Errorf("invalid batch question on line %d: %w", lineNumber, err)
+		}
+		if question.Question == "" {
+			return nil, fmt.Errorf("missing question on line %d", lineNumber)
+		}
+		if question.ID == "" {
+			question.ID = fmt.Sprintf("line-%d", lineNumber)
+		}
+		if question.Verbosity != "" {
+			if question.Verbosity, err = prompts.NormalizeVerbosity(question.Verbosity); err != nil {
+				return nil, fmt.Errorf("invalid batch question on line %d: %w", lineNumber, err)
+			}
+		}
+		if _, err := agent.MergeTags(nil, question.Tags); err != nil {
+			return nil, fmt.Errorf("invalid batch question on line %d: %w", lineNumber, err)
+		}
+
+		questions = append(questions, question)
+	}
+
+	return questions, scanner.Err()
+}
+
+// Read the ids present on a batch results file, mapped to whether their last result failed, so a resumed batch
+// skips them and --rerun-failures finds the failed ones. A missing file has no ids
+func readAnsweredIDs(outputPath string) (map[string]bool, error) {
+	answered := map[string]bool{}
+	file, err := os.Open(outputPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return answered, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		result := batchResult{}
+		// A line cut by an interrupted write is skipped, its question runs again
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			continue
+		}
+		// Rerun questions append a new line, the last one wins
+		answered[result.ID] = result.Error != ""
+	}
+
+	return answered, scanner.Err()
+}
+
+// Prompt sent to the agent for a batch question, including the expected columns hint
+func (q batchQuestion) prompt() string {
+	if len(q.ExpectedColumns) == 0 {
+		return q.Question
+	}
+
+	return fmt.Sprintf("%s\nThe answer is expected to cover these columns: %s", q.Question, strings.Join(q.ExpectedColumns, ", "))
+}
+
+// Run the agent on a batch question, cancelled after timeout unless it's zero. Panics on the run are
+// recovered as errors so a single failure doesn't abort the batch
+func runBatchQuestion(ctx context.Context, runAgent *agent.Agent, question batchQuestion, timeout time.Duration) (result batchResult) {
+	result = batchResult{ID: question.ID, Question: question.Question}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result.Error = fmt.Sprintf("run panicked: %v", recovered)
+		}
+		result.Duration = time.Since(start).Seconds()
+	}()
+
+	if question.Verbosity != "" {
+		// Already validated when the batch was read
+		runAgent, _ = runAgent.WithVerbosity(question.Verbosity)
+	}
+	if len(question.Tags) != 0 {
+		// Already validated when the batch was read
+		runAgent, _ = runAgent.WithTags(question.Tags)
+	}
+
+	runResult, err := runAgent.Run(ctx, question.prompt())
+	result.Answer = runResult.Answer
+	result.Usage = runResult.Usage
+	result.Retrieval = runResult.Retrieval
+	result.Timings = runResult.Timings
+	result.Provenance = runResult.Provenance
+	result.Tags = runResult.Tags
+	result.RunID = runResult.RunID
+	result.TraceID = runResult.TraceID
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	if runResult.RunID != "" {
+		if _, err := agent.WriteTranscript(runResult, ctx.Err() != nil); err != nil {
+			log.Printf("WARNING: Failed to write transcript: %s\n", err)
+		}
+	}
+
+	return result
+}
+
+// Tally of the finished batch questions, for the progress lines and the final summary
+type batchSummary struct {
+	mutex     sync.Mutex
+	passed    int
+	failed    int
+	durations []float64
+	tokens    int64
+	tags      map[string][2]int // Passed and failed questions per "key=value" tag
+}
+
+// Count a written result, returning the passed and failed questions so far
+func (s *batchSummary) add(result batchResult) (int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	index := 0
+	if result.Error == "" {
+		s.passed++
+	} else {
+		s.failed++
+		index = 1
+	}
+	s.durations = append(s.durations, result.Duration)
+	s.tokens += result.Usage.TotalTokens
+	for key, value := range result.Tags {
+		counts := s.tags[key+"="+value]
+		counts[index]++
+		s.tags[key+"="+value] = counts
+	}
+
+	return s.passed, s.failed
+}
+
+// Lines summarizing the finished questions: their duration distribution, tokens and the pass rate of each tag
+func (s *batchSummary) lines() []string {
+	if len(s.durations) == 0 {
+		return nil
+	}
+
+	durations := slices.Sorted(slices.Values(s.durations))
+	percentile := func(p float64) float64 { return durations[int(math.Ceil(p*float64(len(durations))))-1] }
+	lines := []string{fmt.Sprintf(
+		"Batch durations: p50 %.1fs, p90 %.1fs, max %.1fs. Tokens: %d, %d per question",
+		percentile(0.5), percentile(0.9), durations[len(durations)-1], s.tokens, s.tokens/int64(len(durations)),
+	)}
+
+	rates := []string{}
+	for _, tag := range slices.Sorted(maps.Keys(s.tags)) {
+		counts := s.tags[tag]
+		rates = append(rates, fmt.Sprintf("%s %d/%d", tag, counts[0], counts[0]+counts[1]))
+	}
+	if len(rates) != 0 {
+		lines = append(lines, "Batch pass rate by tag: "+strings.Join(rates, ", "))
+	}
+	return lines
+}
+
+// Question of a batch and its position among the questions run
+type indexedQuestion struct {
+	index    int
+	question batchQuestion
+}
+
+// Handle the batch subcommand: answer every question of an input file with bounded concurrency,
+// appending results to the output file in input order. Ids already on the output are skipped
+func runBatchCommand(config agent.Config, args []string) {
+	batchFlags := flag.NewFlagSet("batch", flag.ExitOnError)
+	inputPath := batchFlags.String("input", "", "JSON lines file with one {\"id\", \"question\", \"expected_columns\"} object per line")
+	outputPath := batchFlags.String("output", "", "JSON lines file the results are appended to")
+	concurrency := batchFlags.Int("concurrency", 2, "Amount of questions answered at the same time")
+	timeout := batchFlags.Duration("timeout", 0, "Max duration of each question, recorded as a failure when it's over. Zero has none")
+	failFast := batchFlags.Bool("fail-fast", false, "Stop the batch on the first failed question, cancelling the running ones")
+	only := batchFlags.String("only", "", "Glob the ids of the questions run must match, like 'sales-*'")
+	rerunFailures := batchFlags.String("rerun-failures", "", "Results file of a previous batch. Only the questions that failed on it run, even if already on the output")
+	batchFlags.Parse(args)
+
+	if *inputPath == "" || *outputPath == "" {
+		failf(exitcode.ClassUsage, "batch mode needs both --input and --output")
+	}
+	if *concurrency <= 0 {
+		failf(exitcode.ClassUsage, "invalid batch concurrency: %d", *concurrency)
+	}
+	if *timeout < 0 {
+		failf(exitcode.ClassUsage, "invalid batch timeout: %s", *timeout)
+	}
+	if _, err := path.Match(*only, ""); err != nil {
+		failf(exitcode.ClassUsage, "invalid --only glob '%s': %s", *only, err)
+	}
+
+	questions, err := readBatchQuestions(projectPath(*inputPath))
+	if err != nil {
+		fail(exitcode.New(exitcode.ClassData, fmt.Errorf("failed to read batch input: %w", err)))
+	}
+
+	answered, err := readAnsweredIDs(projectPath(*outputPath))
+	if err != nil {
+		fail(exitcode.New(exitcode.ClassData, fmt.Errorf("failed to read batch output: %w", err)))
+	}
+
+	var previous map[string]bool
+	if *rerunFailures != "" {
+		if previous, err = readAnsweredIDs(projectPath(*rerunFailures)); err != nil {
+			fail(exitcode.New(exitcode.ClassData, fmt.Errorf("failed to read previous batch results: %w", err)))
+		}
+	}
+
+	pending := []batchQuestion{}
+	for _, question := range questions {
+		if matched, _ := path.Match(*only, question.ID); *only != "" && !matched {
+			continue
+		}
+		failedBefore, answeredBefore := answered[question.ID]
+		if previous != nil {
+			// Failed on the previous results, and not passed since on the output
+			if previous[question.ID] && (!answeredBefore || failedBefore) {
+				pending = append(pending, question)
+			}
+			continue
+		}
+		if !answeredBefore {
+			pending = append(pending, question)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Batch: %d questions, %d pending\n", len(questions), len(pending))
+
+	outputFile, err := os.OpenFile(projectPath(*outputPath), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		fail(exitcode.New(exitcode.ClassData, fmt.Errorf("failed to open batch output: %w", err)))
+	}
+	defer outputFile.Close()
+	writer := newBatchWriter(outputFile)
+
+	tracer, err := newPhoenixTracer()
+	if err != nil {
+		fail(err)
+	}
+	config.Tracer = tracer
+
+	// Questions share the agent, and with it its rate limiter
+	runAgent, err := agent.New(config)
+	if err != nil {
+		fail(err)
+	}
+
+	// An interrupt, or a failure with --fail-fast, stops handing out questions and cancels the running ones.
+	// Cancelled questions aren't written, so resuming the batch runs them again
+	batchContext, cancelBatch := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancelBatch()
+	runContext, stopBatch := context.WithCancel(batchContext)
+	defer stopBatch()
+
+	queue := make(chan indexedQuestion)
+	waitGroup := sync.WaitGroup{}
+	summary := &batchSummary{tags: map[string][2]int{}}
+	var firstFailure atomic.Pointer[batchResult]
+	for range min(*concurrency, max(len(pending), 1)) {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for next := range queue {
+				question := next.question
+				result := runBatchQuestion(runContext, runAgent, question, *timeout)
+				if runContext.Err() != nil {
+					writer.finish(next.index, nil)
+					fmt.Fprintf(os.Stderr, "Batch: '%s' cancelled, it will run again on resume\n", question.ID)
+					continue
+				}
+
+				if err := writer.finish(next.index, &result); err != nil {
+					log.Printf("WARNING: Failed to write batch result for '%s': %s\n", question.ID, err)
+				}
+
+				passed, failed := summary.add(result)
+				status := "ok"
+				if result.Error != "" {
+					status = "error: " + result.Error
+					if *failFast && firstFailure.CompareAndSwap(nil, &result) {
+						stopBatch()
+					}
+				}
+				fmt.Fprintf(
+					os.Stderr, "Batch: [%d/%d] %d passed, %d failed. '%s' %s in %.1fs\n",
+					passed+failed, len(pending), passed, failed, question.ID, status, result.Duration,
+				)
+			}
+		}()
+	}
+
+dispatch:
+	for index, question := range pending {
+		select {
+		case queue <- indexedQuestion{index: index, question: question}:
+		case <-runContext.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	waitGroup.Wait()
+
+	if err := writer.flush(); err != nil {
+		log.Printf("WARNING: Failed to write batch results: %s\n", err)
+	}
+	shutdown(runAgent, tracer, agent.RunResult{}, false)
+
+	for _, line := range summary.lines() {
+		fmt.Fprintln(os.Stderr, line)
+	}
+	if batchContext.Err() != nil {
+		failf(exitcode.ClassInterrupted, "batch interrupted after %d of %d questions. Run it again to resume", summary.passed+summary.failed, len(pending))
+	}
+	if failure := firstFailure.Load(); failure != nil {
+		failf(exitcode.ClassData, "batch stopped on the first failed question '%s': %s", failure.ID, failure.Error)
+	}
+
+	if err := tools.PruneRuns(config.OutputDir, *keepRuns); err != nil {
+		log.Printf("WARNING: %s\n", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Batch finished: %d processed, %d failed. Results at %s\n", summary.passed+summary.failed, summary.failed, *outputPath)
+}
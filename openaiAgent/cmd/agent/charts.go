@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+/*
+-----------
+Render mode
+-----------
+*/
+
+// Handle the render subcommand: render chart spec files again from their spec, without any completion
+func runRenderCommand(args []string) {
+	if len(args) == 0 {
+		failf(exitcode.ClassUsage, "expected chart spec files to render")
+	}
+
+	for _, specPath := range args {
+		chartRender, err := tools.RenderFromSpec(specPath)
+		if err != nil {
+			fail(err)
+		}
+		fmt.Printf("%s: %s chart of %d rows, run %s, rendered to %s\n",
+			specPath, chartRender.Spec.Config.ChartType, len(chartRender.Spec.Rows), chartRender.Spec.RunID, chartRender.CodePath)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/openai/openai-go"
+)
+
+/*
+---------------
+Comparison mode
+---------------
+*/
+
+// Decimals of costs printed on tables. Reports keep the exact cost
+const costDecimals = 5
+
+// Result of the prompt on one model
+type compareRun struct {
+	Model     string       `json:"model"`
+	Answer    string       `json:"answer"`
+	Usage     tools.Usage  `json:"usage"`
+	CostUSD   *json.Number `json:"cost_usd"` // Exact, nil for models without a known price
+	Latency   float64      `json:"latency"`  // Seconds
+	ToolCalls int          `json:"tool_calls"`
+	RunID     string       `json:"run_id"`
+	TraceID   string       `json:"trace_id"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// Comparison report, printed side by side and optionally written as JSON
+type compareReport struct {
+	Question  string           `json:"question"`
+	Runs      []compareRun     `json:"runs"`
+	Judgement *tools.Judgement `json:"judgement,omitempty"`
+}
+
+// Estimate the cost of a token usage on a model, exactly, with the prices of the model registry.
+// Returns nil for models without a known price
+func estimateCost(model string, usage tools.Usage) *big.Rat {
+	info, ok := completion.LookupModel(model)
+	if !ok || info.InputPrice == "" || info.OutputPrice == "" {
+		return nil
+	}
+
+	input, _ := new(big.Rat).SetString(info.InputPrice)
+	output, _ := new(big.Rat).SetString(info.OutputPrice)
+	cost := new(big.Rat).Mul(new(big.Rat).SetInt64(usage.PromptTokens), input)
+	cost.Add(cost, new(big.Rat).Mul(new(big.Rat).SetInt64(usage.CompletionTokens), output))
+	return cost.Quo(cost, big.NewRat(1_000_000, 1))
+}
+
+// Cost of a usage for reports, as an exact JSON number. Nil for unknown models
+func costNumber(model string, usage tools.Usage) *json.Number {
+	cost := estimateCost(model, usage)
+	if cost == nil {
+		return nil
+	}
+
+	// Prices have a few decimals, so the exact cost has a finite decimal expansion
+	exact := strings.TrimRight(strings.TrimRight(roundHalfEven(cost, 18), "0"), ".")
+	number := json.Number(exact)
+	return &number
+}
+
+// Text of an amount rounded to decimals, with ties going to the even digit, like 0.000025 to 0.00002.
+// Costs are only rounded when they are shown, so summing rounded costs never drifts
+func roundHalfEven(amount *big.Rat, decimals int) string {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	scaled := new(big.Rat).Mul(amount, new(big.Rat).SetInt(scale))
+
+	numerator, denominator := new(big.Int).Abs(scaled.Num()), scaled.Denom()
+	quotient, remainder := new(big.Int).QuoRem(numerator, denominator, new(big.Int))
+	switch remainder.Lsh(remainder, 1).Cmp(denominator) {
+	case 1:
+		quotient.Add(quotient, big.NewInt(1))
+	case 0:
+		if quotient.Bit(0) == 1 {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+
+	rounded := new(big.Rat).SetFrac(quotient, scale)
+	if scaled.Sign() < 0 {
+		rounded.Neg(rounded)
+	}
+	return rounded.FloatString(decimals)
+}
+
+// Handle the compare subcommand: run the same prompt once per model and report the differences.
+// Every run shares the database bootstrap but gets its own span tree, tools and artifacts
+func runCompareCommand(config agent.Config, args []string) {
+	compareFlags := flag.NewFlagSet("compare", flag.ExitOnError)
+	models := compareFlags.String("models", "", "Comma separated chat models to compare, like 'gpt-4o-mini,gpt-4o'")
+	judge := compareFlags.Bool("judge", false, "Ask an evaluator model which answer is better")
+	judgeModel := compareFlags.String("judge-model", openai.ChatModelGPT4o, "Chat model of the evaluator")
+	jsonPath := compareFlags.String("json", "", "Also write the report as JSON to this file")
+	compareFlags.Parse(args)
+
+	modelNames := []string{}
+	for model := range strings.SplitSeq(*models, ",") {
+		if model = strings.TrimSpace(model); model == "" {
+			continue
+		}
+		model, err := agent.ValidateModel(model, config.AllowUnknownModels)
+		if err != nil {
+			failf(exitcode.ClassUsage, "--models: %w", err)
+		}
+		modelNames = append(modelNames, model)
+	}
+
+	if len(modelNames) < 2 || compareFlags.NArg() != 1 {
+		failf(exitcode.ClassUsage, "compare mode needs at least two --models and one prompt")
+	}
+	if *judge {
+		validated, err := agent.ValidateModel(*judgeModel, config.AllowUnknownModels)
+		if err != nil {
+			failf(exitcode.ClassUsage, "--judge-model: %w", err)
+		}
+		*judgeModel = validated
+	}
+
+	tracer, err := newPhoenixTracer()
+	if err != nil {
+		fail(err)
+	}
+	config.Tracer = tracer
+
+	baseAgent, err := agent.New(config)
+	if err != nil {
+		fail(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	report := compareReport{Question: compareFlags.Arg(0), Runs: []compareRun{}}
+	for _, model := range modelNames {
+		log.Printf("Running prompt on model '%s'\n", model)
+		start := time.Now()
+		result, err := baseAgent.WithModel(model).Run(ctx, report.Question)
+
+		run := compareRun{
+			Model:     model,
+			Answer:    result.Answer,
+			Usage:     result.Usage,
+			CostUSD:   costNumber(model, result.Usage),
+			Latency:   time.Since(start).Seconds(),
+			ToolCalls: result.ToolCalls,
+			RunID:     result.RunID,
+			TraceID:   result.TraceID,
+		}
+		if err != nil {
+			run.Error = err.Error()
+		}
+
+		if result.RunID != "" {
+			if _, err := agent.WriteTranscript(result, false); err != nil {
+				log.Printf("WARNING: Failed to write transcript: %s\n", err)
+			}
+		}
+
+		report.Runs = append(report.Runs, run)
+	}
+
+	if *judge {
+		candidates := []tools.JudgeCandidate{}
+		for _, run := range report.Runs {
+			if run.Error == "" {
+				candidates = append(candidates, tools.JudgeCandidate{Label: run.Model, Answer: run.Answer})
+			}
+		}
+
+		judgement, err := baseAgent.WithModel(*judgeModel).Judge(ctx, report.Question, candidates)
+		if err != nil {
+			log.Printf("WARNING: Failed to judge answers: %s\n", err)
+		} else {
+			report.Judgement = &judgement
+		}
+	}
+
+	shutdown(baseAgent, tracer, agent.RunResult{}, false)
+
+	printCompareReport(report)
+	if *jsonPath != "" {
+		jsonBytes, err := json.MarshalIndent(report, "", "  ")
+		if err == nil {
+			err = os.WriteFile(projectPath(*jsonPath), jsonBytes, 0o644)
+		}
+		if err != nil {
+			fail(exitcode.Default(exitcode.ClassData, fmt.Errorf("failed to write comparison report: %w", err)))
+		}
+	}
+}
+
+// Print the comparison metrics as a table, followed by each answer
+func printCompareReport(report compareReport) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "MODEL\tPROMPT TOKENS\tCOMPLETION TOKENS\tCOST (USD)\tLATENCY\tTOOL CALLS\tSTATUS")
+	for _, run := range report.Runs {
+		cost := "unknown"
+		if estimated := estimateCost(run.Model, run.Usage); estimated != nil {
+			cost = roundHalfEven(estimated, costDecimals)
+		}
+
+		status := "ok"
+		if run.Error != "" {
+			status = "error: " + run.Error
+		}
+
+		fmt.Fprintf(
+			writer, "%s\t%d\t%d\t%s\t%.1fs\t%d\t%s\n",
+			run.Model, run.Usage.PromptTokens, run.Usage.CompletionTokens, cost, run.Latency, run.ToolCalls, status,
+		)
+	}
+	writer.Flush()
+
+	for _, run := range report.Runs {
+		fmt.Printf("\n=== %s ===\n%s\n", run.Model, run.Answer)
+	}
+
+	if report.Judgement != nil {
+		fmt.Printf("\nBetter answer: %s\n%s\n", report.Judgement.Winner, report.Judgement.Reasoning)
+	}
+}
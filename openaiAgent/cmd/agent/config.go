@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/config"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+/*
+------------
+Config files
+------------
+*/
+
+var configPath = flag.String("config", "", "JSON config file with the flag settings of each profile, relative to the workspace")
+var profileName = flag.String("profile", "", "Config file profile to use, like 'dev' or 'prod'. Defaults to APP_PROFILE")
+var printConfig = flag.Bool("print-config", false, "Print the settings in use and where each one comes from, then exit")
+
+// Flags choosing the config, they can't be set from it
+var configFlags = []string{"config", "profile", "print-config", "workspace"}
+
+// Config loaded on startup, nil when no config file is used
+var loadedConfig *config.Config
+
+// Load the config file and set the flags it holds that weren't given on the command line.
+// Returns how the profile was selected, for printing
+func applyConfigFile() (string, error) {
+	profile, profileSource := *profileName, "-profile"
+	if profile == "" {
+		profile, profileSource = os.Getenv(config.ProfileEnv), config.ProfileEnv
+	}
+
+	if *configPath == "" {
+		if profile != "" {
+			return profileSource, fmt.Errorf("profile '%s' selected with %s but no -config file given", profile, profileSource)
+		}
+		return "", nil
+	}
+
+	loaded, err := config.Load(projectPath(*configPath), profile)
+	if err != nil {
+		return profileSource, err
+	}
+
+	for _, setting := range loaded.Settings {
+		if slices.Contains(configFlags, setting.Name) {
+			return profileSource, fmt.Errorf("'%s' can't be set from config '%s'", setting.Name, loaded.Path)
+		}
+	}
+
+	if err := loaded.Apply(flag.CommandLine); err != nil {
+		return profileSource, err
+	}
+
+	if profile != "" {
+		log.Printf("Using profile '%s' of config '%s'\n", profile, loaded.Path)
+	}
+	loadedConfig = loaded
+	return profileSource, nil
+}
+
+// Print every flag value with where it comes from: a config setting, the command line or its default.
+// Environment variables from the config are listed without their values, which may be secrets
+func runPrintConfig(profileSource string) {
+	if loadedConfig == nil {
+		fmt.Println("Config: none")
+	} else if loadedConfig.Profile == "" {
+		fmt.Printf("Config: %s, base settings only\n", loadedConfig.Path)
+	} else {
+		fmt.Printf("Config: %s, profile '%s' selected by %s\n", loadedConfig.Path, loadedConfig.Profile, profileSource)
+	}
+
+	settings, env := []config.Setting{}, []config.Setting{}
+	if loadedConfig != nil {
+		settings, env = loadedConfig.Settings, loadedConfig.Env
+	}
+
+	onCommandLine := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+
+	fmt.Println("Settings:")
+	flag.VisitAll(func(f *flag.Flag) {
+		source := "default"
+		setting, ok := config.Find(settings, f.Name)
+		switch {
+		case ok && setting.Overridden:
+			source = fmt.Sprintf("command line, overriding %s", setting.Source)
+		case ok:
+			source = setting.Source
+		case onCommandLine[f.Name]:
+			source = "command line"
+		}
+		fmt.Printf("  %s = %q (%s)\n", f.Name, f.Value.String(), source)
+	})
+
+	if len(env) == 0 {
+		return
+	}
+
+	fmt.Println("Environment:")
+	for _, setting := range env {
+		source := setting.Source
+		if setting.Overridden {
+			source = fmt.Sprintf("already defined, overriding %s", setting.Source)
+		}
+		fmt.Printf("  %s (%s)\n", setting.Name, source)
+	}
+}
+
+// Create the Phoenix tracer of the agent. Runs on a profile are tagged with it as their deployment environment
+func newPhoenixTracer() (*tracing.Tracer, error) {
+	resourceAttributes := []attribute.KeyValue{}
+	if loadedConfig != nil && loadedConfig.Profile != "" {
+		resourceAttributes = append(resourceAttributes, tracing.DeploymentEnvironment(loadedConfig.Profile))
+	}
+
+	tracer, err := tracing.NewPhoenixTracer(*tracingProject, resourceAttributes...)
+	return tracer, exitcode.Default(exitcode.ClassConfig, err)
+}
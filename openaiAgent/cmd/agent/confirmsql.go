@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+/*
+------------------
+SQL confirm prompt
+------------------
+*/
+
+var confirmSQL = flag.Bool("confirm-sql", false, "Show each generated query with its estimated rows and wait for y/n/edit before it runs. Needs a terminal")
+
+// Lookup timeout of confirmed runs unless -tool-timeouts sets one, the confirmation counts against it
+const confirmLookupTimeout = 10 * time.Minute
+
+// Editor of queries when $EDITOR is unset
+const defaultEditor = "vi"
+
+// Terminal prompt confirming generated queries. It talks on stderr, so answers printed on stdout stay clean
+type terminalSQLConfirmer struct {
+	input *bufio.Reader
+}
+
+// Create the terminal prompt. Fails when stdin or stderr isn't a terminal, since waiting on a pipe would hang
+func newTerminalSQLConfirmer() (*terminalSQLConfirmer, error) {
+	for _, file := range []*os.File{os.Stdin, os.Stderr} {
+		info, err := file.Stat()
+		if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+			return nil, fmt.Errorf("-confirm-sql needs an interactive terminal, %s isn't one", file.Name())
+		}
+	}
+
+	return &terminalSQLConfirmer{input: bufio.NewReader(os.Stdin)}, nil
+}
+
+func (c *terminalSQLConfirmer) ConfirmSQL(ctx context.Context, review tools.SQLReview) (tools.SQLConfirmation, error) {
+	fmt.Fprintf(os.Stderr, "\nQuery to run:\n%s\n", review.Query)
+	if review.Problem != "" {
+		fmt.Fprintf(os.Stderr, "The edited query can't run: %s\n", review.Problem)
+	} else if review.EstimatedRows >= 0 {
+		fmt.Fprintf(os.Stderr, "Estimated rows: %d\n", review.EstimatedRows)
+	}
+
+	prompt := "Run it? [y/n/edit] "
+	if review.Problem != "" {
+		prompt = "Reject or edit it? [n/edit] "
+	}
+
+	for {
+		fmt.Fprint(os.Stderr, prompt)
+		answer, err := c.readLine(ctx)
+		if err != nil {
+			return tools.SQLConfirmation{}, err
+		}
+
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			if review.Problem != "" {
+				continue
+			}
+			return tools.SQLConfirmation{Decision: tools.SQLApproved}, nil
+		case "n", "no":
+			return tools.SQLConfirmation{Decision: tools.SQLRejected}, nil
+		case "e", "edit":
+			edited, err := editQuery(review.Query)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to edit the query: %s\n", err)
+				continue
+			}
+			return tools.SQLConfirmation{Decision: tools.SQLEdited, Query: edited}, nil
+		}
+	}
+}
+
+// Read an answer line, giving up when the context is done, like when the lookup times out
+func (c *terminalSQLConfirmer) readLine(ctx context.Context) (string, error) {
+	lines := make(chan string, 1)
+	errs := make(chan error, 1)
+	go func() {
+		line, err := c.input.ReadString('\n')
+		if err != nil {
+			errs <- err
+			return
+		}
+		lines <- strings.TrimSpace(line)
+	}()
+
+	select {
+	case line := <-lines:
+		return line, nil
+	case err := <-errs:
+		return "", fmt.Errorf("failed to read the confirmation: %w", err)
+	case <-ctx.Done():
+		fmt.Fprintln(os.Stderr)
+		return "", errors.New("no confirmation before the lookup timed out")
+	}
+}
+
+// Open the query in $EDITOR and return the saved version
+func editQuery(query string) (string, error) {
+	file, err := os.CreateTemp("", "query-*.sql")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.WriteString(query + "\n"); err != nil {
+		file.Close()
+		return "", err
+	}
+	file.Close()
+
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{defaultEditor}
+	}
+	command := exec.Command(editor[0], append(editor[1:], file.Name())...)
+	command.Stdin, command.Stdout, command.Stderr = os.Stdin, os.Stderr, os.Stderr
+	if err := command.Run(); err != nil {
+		return "", err
+	}
+
+	edited, err := os.ReadFile(file.Name())
+	return strings.TrimSpace(string(edited)), err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+/*
+-------
+DB mode
+-------
+*/
+
+// Handle the db subcommand: print the database size and what a vacuum would drop, or vacuum it.
+// Exits with an error code on failures
+func runDBCommand(config agent.Config, args []string) {
+	if len(args) > 0 && args[0] != "vacuum" {
+		failf(exitcode.ClassUsage, "unknown db subcommand '%s'. Expected 'vacuum' or none", args[0])
+	}
+
+	tracer, err := newPhoenixTracer()
+	if err != nil {
+		fail(err)
+	}
+	config.Tracer = tracer
+
+	runAgent, err := agent.New(config)
+	if err != nil {
+		fail(err)
+	}
+	defer shutdown(runAgent, tracer, agent.RunResult{}, false)
+
+	if len(args) == 0 {
+		size, err := runAgent.DatabaseSize()
+		if err != nil {
+			fail(err)
+		}
+		targets, err := runAgent.VacuumTargets(context.Background())
+		if err != nil {
+			fail(err)
+		}
+		fmt.Printf("Database size: %s\n", agent.FormatMB(size))
+		printVacuumTargets("A vacuum would drop", targets)
+		return
+	}
+
+	report, err := runAgent.Vacuum(context.Background())
+	if err != nil {
+		fail(exitcode.Default(exitcode.ClassData, fmt.Errorf("failed to vacuum the database: %w", err)))
+	}
+	printVacuumTargets("Dropped", report.VacuumTargets)
+	fmt.Printf("Database size: %s, was %s\n", agent.FormatMB(report.SizeAfter), agent.FormatMB(report.SizeBefore))
+}
+
+func printVacuumTargets(header string, targets tools.VacuumTargets) {
+	if targets.Empty() {
+		fmt.Println("No orphaned run tables or stale datasets")
+		return
+	}
+	if len(targets.OrphanTables) > 0 {
+		fmt.Printf("%s %d orphaned run tables: %s\n", header, len(targets.OrphanTables), strings.Join(targets.OrphanTables, ", "))
+	}
+	if len(targets.StaleDatasets) > 0 {
+		fmt.Printf("%s %d stale datasets: %s\n", header, len(targets.StaleDatasets), strings.Join(targets.StaleDatasets, ", "))
+	}
+}
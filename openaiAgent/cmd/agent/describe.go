@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/config"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+/*
+---------------------
+Describe dataset mode
+---------------------
+*/
+
+// Setting the description is stored under, the flag setting Config.DatasetDescription
+const datasetDescriptionSetting = "dataset-description"
+
+// Handle the describe-dataset subcommand: describe a dataset from its profile and print the description, or with
+// --write store it as the dataset-description setting of the -config file, in the selected profile when there's one.
+// --no-llm templates the description from the profile instead of having the model write it
+func runDescribeDatasetCommand(runConfig agent.Config, args []string) {
+	describeFlags := flag.NewFlagSet("describe-dataset", flag.ExitOnError)
+	write := describeFlags.Bool("write", false, "Store the description in the -config file, after confirming it")
+	noLLM := describeFlags.Bool("no-llm", false, "Template the description from the profile instead of having the model write it")
+	yes := describeFlags.Bool("yes", false, "Write the description without asking for confirmation")
+	describeFlags.Parse(args[1:])
+
+	if args[0] != tools.DatasetName {
+		failf(exitcode.ClassUsage, "unknown dataset '%s', expected '%s'", args[0], tools.DatasetName)
+	}
+	if *write && loadedConfig == nil {
+		failf(exitcode.ClassUsage, "--write needs a -config file to store the description in")
+	}
+
+	tracer, err := newPhoenixTracer()
+	if err != nil {
+		fail(err)
+	}
+	runConfig.Tracer = tracer
+
+	runAgent, err := agent.New(runConfig)
+	if err != nil {
+		fail(err)
+	}
+
+	description, err := runAgent.DescribeDataset(context.Background(), *noLLM)
+	shutdown(runAgent, tracer, agent.RunResult{}, false)
+	if err != nil {
+		fail(exitcode.Default(exitcode.ClassData, fmt.Errorf("failed to describe the dataset: %w", err)))
+	}
+
+	fmt.Println(description)
+	if !*write {
+		return
+	}
+
+	section := "base settings"
+	if loadedConfig.Profile != "" {
+		section = fmt.Sprintf("profile '%s'", loadedConfig.Profile)
+	}
+	if !*yes && !confirm(fmt.Sprintf("Write it to the %s of %s?", section, loadedConfig.Path)) {
+		return
+	}
+	if err := config.SetSetting(loadedConfig.Path, loadedConfig.Profile, datasetDescriptionSetting, description); err != nil {
+		fail(err)
+	}
+	log.Printf("Wrote the dataset description to the %s of %s\n", section, loadedConfig.Path)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+)
+
+/*
+-----------
+Doctor mode
+-----------
+*/
+
+// Handle the doctor subcommand: check every prerequisite of the agent in order and print a pass or fail report
+// with hints for the failures. Exits with an error code if any required check failed
+func runDoctorCommand(config agent.Config, args []string, configErr error) {
+	doctorFlags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	offline := doctorFlags.Bool("offline", false, "Skip the OpenAI key check, which makes a 1 token completion")
+	jsonOutput := doctorFlags.Bool("json", false, "Print the report as JSON")
+	doctorFlags.Parse(args)
+
+	// The config file is loaded before any other setting, its check comes first
+	configCheck := agent.Check{
+		Name:     "config",
+		Required: true,
+		Hint:     "Fix the -config file or pick one of its profiles, -print-config shows the settings in use",
+		Run: func(context.Context, agent.Config) error {
+			if configErr == nil && loadedConfig == nil {
+				return fmt.Errorf("%w: no -config file", agent.ErrCheckSkipped)
+			}
+			return configErr
+		},
+	}
+
+	checks := append([]agent.Check{configCheck}, agent.EnvironmentChecks(*offline)...)
+	results := agent.RunChecks(context.Background(), config, checks)
+	passed := agent.ChecksPassed(results)
+
+	if *jsonOutput {
+		jsonBytes, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			fail(err)
+		}
+		fmt.Println(string(jsonBytes))
+	} else {
+		printDoctorReport(results, passed)
+	}
+
+	if !passed {
+		os.Exit(1)
+	}
+}
+
+func printDoctorReport(results []agent.CheckResult, passed bool) {
+	for _, result := range results {
+		status := "PASS"
+		switch {
+		case result.Skipped:
+			status = "SKIP"
+		case !result.OK && !result.Required:
+			status = "WARN"
+		case !result.OK:
+			status = "FAIL"
+		}
+
+		fmt.Printf("%s  %-8s %dms\n", status, result.Name, result.DurationMs)
+		if result.Error != "" {
+			fmt.Printf("      %s\n", result.Error)
+		}
+		if result.Hint != "" {
+			fmt.Printf("      hint: %s\n", result.Hint)
+		}
+	}
+
+	if passed {
+		fmt.Println("Environment ready")
+	} else {
+		fmt.Println("Environment not ready, fix the failed checks above")
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+)
+
+/*
+-----------
+Exit errors
+-----------
+*/
+
+var errorFormat = flag.String("error-format", exitcode.FormatText, "Format of the error ending the run: text, or json for a {\"code\", \"class\", \"message\"} line on stderr")
+
+// Print the error ending the process on the -error-format and exit with the code of its class
+func fail(err error) {
+	report := exitcode.NewReport(err)
+	if *errorFormat == exitcode.FormatJSON {
+		fmt.Fprintln(os.Stderr, report.JSON())
+	} else {
+		log.Printf("ERROR: %s\n", err)
+	}
+	os.Exit(report.Code)
+}
+
+// Fail with an error of a class, formatted like fmt.Errorf
+func failf(class exitcode.Class, format string, args ...any) {
+	fail(exitcode.Errorf(class, format, args...))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/anthropic"
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/config"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/history"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+)
+
+// Errors of every package and the class they must exit with
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want exitcode.Class
+	}{
+		{name: "unclassified", err: errors.New("something broke"), want: exitcode.ClassError},
+		{name: "usage", err: exitcode.Errorf(exitcode.ClassUsage, "planning mode needs one prompt"), want: exitcode.ClassUsage},
+		{name: "missing env", err: &config.ErrMissingEnv{Path: "config.json", Missing: []string{"API_KEY"}}, want: exitcode.ClassConfig},
+		{name: "unknown model", err: fmt.Errorf("model: %w", &completion.ErrUnknownModel{Model: "gpt-4o-mni"}), want: exitcode.ClassConfig},
+		{name: "setup failure", err: exitcode.Default(exitcode.ClassConfig, errors.New("no json file found")), want: exitcode.ClassConfig},
+		{name: "dataset failure on setup", err: exitcode.Default(exitcode.ClassConfig, exitcode.Default(exitcode.ClassData, errors.New("bad parquet"))), want: exitcode.ClassData},
+		{name: "api error", err: fmt.Errorf("router call: %w", &openai.Error{StatusCode: 500}), want: exitcode.ClassUpstream},
+		{name: "api quota", err: &completion.ErrRequest{RequestID: "req_1", Err: &openai.Error{StatusCode: 429, Code: "insufficient_quota"}}, want: exitcode.ClassBudget},
+		{name: "network", err: &url.Error{Op: "Post", URL: "https://api.openai.com/v1/chat/completions", Err: errors.New("connection refused")}, want: exitcode.ClassUpstream},
+		{name: "refusal", err: &completion.ErrModelRefusal{Message: "I can't help with that."}, want: exitcode.ClassUpstream},
+		{name: "anthropic", err: &anthropic.Error{StatusCode: 529, Type: "overloaded_error"}, want: exitcode.ClassUpstream},
+		{name: "tool completion", err: &tools.ErrLLMUnavailable{Err: errors.New("connection reset")}, want: exitcode.ClassUpstream},
+		{name: "sql execution", err: &tools.ErrSQLExecution{Query: "SELECT 1", DBErr: errors.New("syntax error")}, want: exitcode.ClassData},
+		{name: "tool timeout", err: &tools.ErrToolTimeout{Tool: tools.LookUpFuncName}, want: exitcode.ClassData},
+		{name: "corrupt history", err: &history.ErrCorruptHistory{Path: "history.jsonl", Line: 3}, want: exitcode.ClassData},
+		{name: "missing api key", err: &completion.ErrAPIKeyRequired{}, want: exitcode.ClassConfig},
+		{name: "max iterations", err: &agent.ErrMaxIterations{Limit: 10}, want: exitcode.ClassBudget},
+		{name: "run deadline", err: fmt.Errorf("run: %w", context.DeadlineExceeded), want: exitcode.ClassBudget},
+		{name: "interrupt", err: context.Canceled, want: exitcode.ClassInterrupted},
+		{name: "interrupted tool", err: &tools.ErrSQLExecution{Query: "SELECT 1", DBErr: context.Canceled}, want: exitcode.ClassInterrupted},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if class := exitcode.Classify(c.err); class != c.want {
+				t.Fatalf("classified as %s, expected %s", class, c.want)
+			}
+		})
+	}
+}
+
+// Failing runs of the agent binary, in mock mode unless they check the API key, exit with the code of their
+// class and print it as a JSON error. {dir} in the arguments is replaced by a directory holding exitCaseFiles
+func TestExitCodes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the agent binary")
+	}
+
+	cases := []struct {
+		name      string
+		args      []string
+		env       []string // Set on top of the environment of the test, like "OPENAI_API_KEY="
+		interrupt bool     // Send an interrupt once the first router call starts
+		want      exitcode.Class
+	}{
+		{name: "missing prompt", args: []string{"what", "sold"}, want: exitcode.ClassUsage},
+		{name: "unknown output format", args: []string{"-output", "yaml", "q"}, want: exitcode.ClassUsage},
+		{name: "missing config", args: []string{"-config", "{dir}/missing.json", "q"}, want: exitcode.ClassConfig},
+		{name: "unknown model", args: []string{"-fixture", "testdata/fixtures/single_lookup.json", "-model", "gpt-4o-mni", "q"}, want: exitcode.ClassConfig},
+		{name: "api failure", args: []string{"-fixture", "{dir}/api_failure.json", "q"}, want: exitcode.ClassUpstream},
+		{name: "corrupt dataset", args: []string{"-fixture", "testdata/fixtures/single_lookup.json", "-data-path", "{dir}/corrupt.parquet", "q"}, want: exitcode.ClassData},
+		{name: "max iterations", args: []string{"-fixture", "testdata/fixtures/max_iterations.json", "q"}, want: exitcode.ClassBudget},
+		{name: "interrupted", args: []string{"-fixture", "{dir}/slow.json", "q"}, interrupt: true, want: exitcode.ClassInterrupted},
+		{name: "missing api key", args: []string{"q"}, env: []string{completion.APIKeyEnv + "="}, want: exitcode.ClassConfig},
+		{name: "blank api key", args: []string{"-healthcheck"}, env: []string{completion.APIKeyEnv + "=  "}, want: exitcode.ClassConfig},
+		// Past the key check, the corrupt dataset fails before any request
+		{name: "api key set", args: []string{"-data-path", "{dir}/corrupt.parquet", "q"}, env: []string{completion.APIKeyEnv + "=sk-check"}, want: exitcode.ClassData},
+		{name: "mock mode without api key", args: []string{"-fixture", "testdata/fixtures/max_iterations.json", "q"}, env: []string{completion.APIKeyEnv + "="}, want: exitcode.ClassBudget},
+	}
+
+	// The tracer only needs the variables defined, nothing listens on the endpoint
+	t.Setenv(tracing.PhoenixEndpointEnv, "http://127.0.0.1:1")
+	t.Setenv(tracing.PhoenixHeadersEnv, "api_key=test")
+
+	executable := buildAgent(t)
+	root, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	casesDir := t.TempDir()
+	for name, content := range exitCaseFiles {
+		if err := os.WriteFile(filepath.Join(casesDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			args := []string{"-workspace", root, "-error-format", exitcode.FormatJSON, "-output-dir", t.TempDir()}
+			for _, arg := range c.args {
+				args = append(args, strings.ReplaceAll(arg, "{dir}", casesDir))
+			}
+			command := exec.Command(executable, args...)
+			command.Dir = root
+			command.Env = append(os.Environ(), c.env...)
+			stderr, err := command.StderrPipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := command.Start(); err != nil {
+				t.Fatal(err)
+			}
+
+			lastLine := ""
+			interrupted := false
+			scanner := bufio.NewScanner(stderr)
+			for scanner.Scan() {
+				lastLine = scanner.Text()
+				if c.interrupt && !interrupted && strings.Contains(lastLine, "Making router call") {
+					command.Process.Signal(os.Interrupt)
+					interrupted = true
+				}
+			}
+			command.Wait()
+
+			want := c.want.Code()
+			if code := command.ProcessState.ExitCode(); code != want {
+				t.Fatalf("exited with %d, expected %d. Last error line: %s", code, want, lastLine)
+			}
+
+			report := exitcode.Report{}
+			if err := json.Unmarshal([]byte(lastLine), &report); err != nil {
+				t.Fatalf("last error line isn't a JSON error: %s", lastLine)
+			}
+			if report.Code != want || report.Class != c.want || report.Message == "" {
+				t.Fatalf("printed %s, expected code %d and class %s", lastLine, want, c.want)
+			}
+		})
+	}
+}
+
+// Files the exit code runs use
+var exitCaseFiles = map[string]string{
+	"api_failure.json": `{"name": "api_failure", "responses": [null]}`,
+	"slow.json":        `{"name": "slow", "delayMs": 60000, "responses": [null]}`,
+	"corrupt.parquet":  "not a parquet file",
+}
+
+// Build the agent binary into a temporary directory
+func buildAgent(t *testing.T) string {
+	t.Helper()
+
+	executable := filepath.Join(t.TempDir(), "agent")
+	build := exec.Command("go", "build", "-o", executable, ".")
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build the agent: %s\n%s", err, output)
+	}
+
+	return executable
+}
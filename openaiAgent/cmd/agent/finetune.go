@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+/*
+----------------
+Fine tuning mode
+----------------
+*/
+
+// Handle the export-finetune subcommand: export the succeeded captured records of a step to a fine tuning
+// dataset, deduplicated and filtered by capture date
+func runExportFineTuneCommand(config agent.Config, args []string) {
+	exportFlags := flag.NewFlagSet("export-finetune", flag.ExitOnError)
+	step := exportFlags.String("step", tools.FineTuneStepSQLGen, "Captured step to export")
+	since := exportFlags.String("since", "", "Only export records captured on or after this date, like 2024-01-01")
+	inputPath := exportFlags.String("input", path.Join(config.OutputDir, tools.DefaultFineTuneFile), "Capture file written with -capture-finetune")
+	outputPath := exportFlags.String("o", "", "JSON lines fine tuning dataset to write")
+	exportFlags.Parse(args)
+
+	if *outputPath == "" {
+		failf(exitcode.ClassUsage, "fine tuning export needs -o")
+	}
+
+	sinceTime := time.Time{}
+	if *since != "" {
+		var err error
+		sinceTime, err = time.ParseInLocation(time.DateOnly, *since, time.Local)
+		if err != nil {
+			failf(exitcode.ClassUsage, "invalid --since date '%s', expected YYYY-MM-DD", *since)
+		}
+	}
+
+	export, err := tools.ExportFineTune(projectPath(*inputPath), *step, sinceTime, projectPath(*outputPath))
+	if err != nil {
+		fail(err)
+	}
+
+	fmt.Printf(
+		"Exported %d of %d records to %s, leaving out %d of other steps, %d older, %d failed and %d duplicates\n",
+		export.Exported, export.Read, *outputPath, export.OtherStep, export.TooOld, export.Failed, export.Duplicates,
+	)
+}
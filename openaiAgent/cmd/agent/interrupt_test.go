@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+)
+
+// An interrupted run of the agent binary writes its transcript as aborted before waiting for the run, then
+// cleans up once the run stops: the transcript is written again, the database closed and the tracer flushed
+func TestInterruptCleanup(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the agent binary")
+	}
+
+	// The tracer only needs the variables defined, nothing listens on the endpoint
+	t.Setenv(tracing.PhoenixEndpointEnv, "http://127.0.0.1:1")
+	t.Setenv(tracing.PhoenixHeadersEnv, "api_key=test")
+
+	executable := buildAgent(t)
+	root, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixturePath := filepath.Join(t.TempDir(), "slow.json")
+	if err := os.WriteFile(fixturePath, []byte(exitCaseFiles["slow.json"]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	outputDir := t.TempDir()
+	command := exec.Command(executable, "-workspace", root, "-output-dir", outputDir, "-fixture", fixturePath, "What were the total sales?")
+	command.Dir = root
+	stderr, err := command.StderrPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := command.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := []string{}
+	interrupted := false
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if !interrupted && strings.Contains(scanner.Text(), "Making router call") {
+			command.Process.Signal(os.Interrupt)
+			interrupted = true
+		}
+	}
+	command.Wait()
+
+	want := exitcode.ClassInterrupted.Code()
+	if code := command.ProcessState.ExitCode(); code != want {
+		t.Fatalf("exited with %d, expected %d. Output:\n%s", code, want, strings.Join(lines, "\n"))
+	}
+
+	// Cleanup hooks, in the order they must run
+	hooks := []string{"Aborted run transcript at ", "Closing database", "Shutting down tracer provider"}
+	transcriptPath := ""
+	next := 0
+	for _, line := range lines {
+		if next == len(hooks) {
+			break
+		}
+		if _, after, found := strings.Cut(line, hooks[next]); found {
+			if next == 0 {
+				transcriptPath = after
+			}
+			next++
+		}
+	}
+	if next != len(hooks) {
+		t.Fatalf("cleanup hook '%s' didn't run. Output:\n%s", hooks[next], strings.Join(lines, "\n"))
+	}
+
+	if !strings.HasPrefix(transcriptPath, outputDir) {
+		t.Fatalf("transcript written to %s, outside the output directory %s", transcriptPath, outputDir)
+	}
+	transcript, err := agent.LoadTranscript(transcriptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !transcript.Aborted || transcript.Completed || transcript.Prompt != "What were the total sales?" {
+		t.Errorf("wrote transcript aborted %t, completed %t, prompt '%s', expected an aborted run of the prompt",
+			transcript.Aborted, transcript.Completed, transcript.Prompt)
+	}
+}
@@ -0,0 +1,762 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/config"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/render"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+)
+
+// Max time to wait for the agent to return after an interrupt
+const shutdownTimeout = 10 * time.Second
+
+// Outcome of the agent run, sent back from the run goroutine
+type runOutcome struct {
+	result agent.RunResult
+	err    error
+}
+
+// Command line flags
+var skipDatasetCheck = flag.Bool("skip-dataset-check", false, "Skip dataset validation, for intentionally empty datasets")
+var workspaceRoot = flag.String("workspace", "", "Workspace root relative paths resolve against. Defaults to AGENT_WORKSPACE, then the nearest directory up to the git root holding data/tools.json, then the working directory")
+var outputDir = flag.String("output-dir", "runs", "Directory for per run artifacts, relative to the workspace")
+var dataPath = flag.String("data-path", tools.DefaultDataPath, "Parquet data path relative to the workspace, absolute, or a s3://, gs:// or https:// URL. A .duckdb file or md: DSN is queried in place")
+var auditSetting = flag.String("audit", "on", "SQL audit log setting, 'on' or 'off'")
+var captureFineTune = flag.Bool("capture-finetune", false, "Capture SQL generations to finetune.jsonl on the output directory, for fine tuning datasets")
+var fineTuneSampleRate = flag.Float64("finetune-sample-rate", 1, "Share of the SQL generations captured with -capture-finetune, in (0, 1]")
+var auditFile = flag.String("audit-file", "audit.jsonl", "SQL audit log file, relative to the output directory")
+var reportsDir = flag.String("reports-dir", "reports", "Directory of the saved reports, relative to the output directory")
+var themesDir = flag.String("themes-dir", tools.DefaultThemesDir, "Directory of the chart theme json files, relative to the workspace")
+var chartTheme = flag.String("chart-theme", tools.DefaultChartThemeName, "Chart theme, the name of a file of -themes-dir without .json. The default one is colorblind safe")
+var followUps = flag.Bool("follow-ups", false, "Append suggested follow up questions to the answer")
+var rawNumbers = flag.Bool("raw-numbers", false, "Leave the numbers of the answer as the model wrote them instead of formatting them")
+var numberLocale = flag.String("number-locale", "", "Separators of formatted numbers: en, es, pt or fr. Empty uses the answer language")
+var numberDecimals = flag.Int("number-decimals", render.DefaultNumberDecimals, "Max decimals of formatted numbers. Amounts always get 2")
+var deterministic = flag.Bool("deterministic", false, "Request every completion with a fixed seed and zero temperature")
+var seed = flag.Int64("seed", tools.DefaultSeed, "Seed for deterministic runs")
+var storageBackend = flag.String("storage-backend", "", "Dataset storage, duckdb or memory. Empty reads STORAGE_BACKEND, then falls back to memory if DuckDB can't start")
+var readOnly = flag.Bool("read-only", false, "Open the database read-only once the dataset is bootstrapped. Temporary tables and dataset reloads are disabled")
+var production = flag.Bool("production", false, "Flag the deployment as production, which refuses to start unless the database is read-only")
+var maxDBSizeMB = flag.Int("max-db-size-mb", 0, "Size cap of the database file in MB, checked on warm up and by the doctor. 0 has no cap")
+var dbSizeAction = flag.String("db-size-action", agent.DBSizeWarn, "What the warm up does when the database is over -max-db-size-mb: warn, or vacuum it")
+var forceRefresh = flag.Bool("force-refresh", false, "Reload the dataset table even if the data file is unchanged")
+var sampleFraction = flag.Float64("sample", 0, "Load only this fraction of the parquet rows, like 0.05, to cut costs while developing. Answers are marked as sampled")
+var sampleRows = flag.Int("sample-rows", 0, "Load only this amount of parquet rows, like 10000, instead of a fraction")
+var allowSampledExport = flag.Bool("allow-sampled-export", false, "Publish the artifacts of sampled runs to ARTIFACT_PUBLISH_URL. Sampled runs refuse to otherwise")
+var toolsAllowlist = flag.String("tools", "", "Comma separated tools the agent may use, like 'lookup,analyze'. Empty allows all")
+var explainQueries = flag.Bool("explain-queries", false, "Profile lookup queries to report the rows they scan. Doubles their cost")
+var scanGuardRows = flag.Int64("scan-guard-rows", 0, "Rows a lookup query may scan without filters, estimated with EXPLAIN before it runs. 0 turns the check off")
+var scanGuardAction = flag.String("scan-guard", tools.ScanGuardWarn, "What lookups do over -scan-guard-rows: warn on the tool result, or regenerate the query once asking for filters")
+var reloadTools = flag.Bool("reload-tools", false, "Reload the tools json before a run when it changed, for long batches")
+var watchData = flag.Bool("watch-data", false, "Reload the dataset before a run when its data file changed, telling continued conversations their earlier results may be stale")
+var toolTimeouts = flag.String("tool-timeouts", "", "Comma separated tool timeouts, like 'lookup=10s,visualize=1m'. Defaults to lookup=30s,analyze=1m,visualize=45s")
+var toolModels = flag.String("tool-models", "", "Comma separated tool models, like 'analyze=claude-3-5-sonnet-latest'. Claude models need ANTHROPIC_API_KEY")
+var requestsPerMinute = flag.Int("rpm", 0, "Max OpenAI requests per minute, calls over it wait. 0 uses OPENAI_RPM, unset is unlimited")
+var tokensPerMinute = flag.Int("tpm", 0, "Max OpenAI tokens per minute, calls over it wait. 0 uses OPENAI_TPM, unset is unlimited")
+var maxIdleConns = flag.Int("max-idle-conns", 0, "OpenAI connections kept open between requests. 0 uses OPENAI_MAX_IDLE_CONNS_PER_HOST, defaults to 16")
+var responseTimeout = flag.Duration("response-timeout", 0, "Max wait for an OpenAI completion response. 0 uses OPENAI_RESPONSE_TIMEOUT, defaults to 5m")
+var answerLanguage = flag.String("lang", "", "Answer language code (en, es, pt, fr). Empty detects it from the prompt")
+var verbosity = flag.String("verbosity", "", "Answer depth: brief (two sentences), normal or detailed (methodology and caveats). Defaults to normal")
+var dateFormat = flag.String("date-format", "", "Day and month order of numeric dates like 01/02/2021 on lookups: dmy or mdy. Empty asks about ambiguous ones")
+var today = flag.String("today", "", "Day relative dates like 'last week' resolve against, as YYYY-MM-DD. Empty is the current day")
+var groundedAnalysis = flag.Bool("grounded-analysis", false, "Have analyses cite the data rows supporting them")
+var continueFrom = flag.String("continue-from", "", "openaiChat history json to continue the conversation from")
+var exportHistory = flag.String("export-history", "", "Export the run conversation to this openaiChat history json")
+var explainAnswer = flag.Bool("explain", false, "Append the tools, SQL queries and models behind the answer")
+var mergeSystemPrompt = flag.Bool("merge-system-prompt", false, "Merge the dataset system prompt into the system message of a continued conversation instead of leaving it out")
+var ungrounded = flag.String("ungrounded", agent.UngroundedWarn, "What to do with answers stating figures when no data was looked up: warn, retry (one corrective router call) or off")
+var profilePrompt = flag.Bool("profile-prompt", false, "Describe the dataset to the model with its cached profile")
+var datasetDescription = flag.String("dataset-description", "", "Few sentences on what the dataset is, for the model. Written to the config by describe-dataset --write")
+var glossary = flag.String("glossary", "", "Comma separated business terms and the columns they name, like 'sales=Total_Sale_Value', besides the dataset ones")
+var columnUnits = flag.String("column-units", "", "Comma separated columns and their unit (cents, dollars, percent or count) with an optional display scale, like 'Price_Cents=cents*0.01', besides the dataset ones")
+var sensitiveColumns = flag.String("sensitive-columns", "", "Comma separated columns identifying individuals. Questions filtering on them are refused")
+var principal = flag.String("principal", os.Getenv("USER"), "User running the agent. Users on ENTITY_GUARD_ALLOWLIST may ask about individuals")
+var role = flag.String("role", "", "Role of the principal, checked against the roles allowed on each dataset. Empty has full access")
+var accessConfig = flag.String("access-config", "", "Access config json with the roles of the API keys and the roles allowed per dataset")
+var endUser = flag.String("user", os.Getenv(completion.EndUserEnv), "End user the completions are attributed to, for abuse monitoring. Emails are sent hashed")
+var injectionPatterns = listFlag("injection-pattern", "Case-insensitive regex of instruction-like phrases neutralized in tool results, besides the default ones. Repeat it for several patterns")
+var runTags = tagFlag("tag", "Metadata tag of the runs as key=value, like 'experiment=router-v2'. Repeat it for several tags. trace_id and model are reserved")
+var healthcheck = flag.Bool("healthcheck", false, "Warm up the agent, print the status of each component as JSON and exit")
+var maxIterations = flag.Int("max-iterations", agent.DefaultMaxIterations, "Max router calls of a run before it's aborted")
+var maxArgumentBytes = flag.Int("max-argument-bytes", 0, "Max bytes of each tool call argument, longer data is truncated and longer prompts rejected. 0 uses a tenth of the model context window")
+var fixture = flag.String("fixture", "", "Replay the recorded completions of a fixture file instead of calling OpenAI")
+var model = flag.String("model", tools.DefaultModel, "Chat model of the runs")
+var allowUnknownModel = flag.Bool("allow-unknown-model", false, "Use models missing from the model registry, like fine tuned ones, assuming a 128k tokens context window")
+var tracingProject = flag.String("tracing-project", tracing.DefaultProjectName, "Phoenix project the run traces are exported to")
+var outputFormat = flag.String("output", "", "Print the answer to stdout as plain, markdown, json (the whole run result) or table. Empty logs it")
+var showTimings = flag.Bool("timings", false, "Print the time spent on router calls, each tool, DuckDB and rate limit waits after the answer")
+var analysesIndex = flag.String("analyses-index", "", "JSONL index of past questions and answers, relative to the workspace. Completed runs are added to it and the SearchPastAnalyses tool searches it. Empty turns both off")
+var embeddingModel = flag.String("embedding-model", tools.DefaultEmbeddingModel, "Embeddings model of the past analyses index")
+var keepRuns = flag.Int("keep-runs", 0, "Amount of most recent runs to keep on the output directory. 0 keeps all")
+
+// Repeatable key=value flag, keeping the value of the last pair of each key
+type tagFlags struct {
+	Tags map[string]string
+}
+
+func (f *tagFlags) String() string {
+	pairs := []string{}
+	for key, value := range f.Tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f *tagFlags) Set(pair string) error {
+	key, value, err := agent.ParseTag(pair)
+	if err != nil {
+		return err
+	}
+
+	if f.Tags == nil {
+		f.Tags = map[string]string{}
+	}
+	f.Tags[key] = value
+	return nil
+}
+
+// Define a repeatable tag flag
+func tagFlag(name string, usage string) *tagFlags {
+	tags := &tagFlags{}
+	flag.Var(tags, name, usage)
+	return tags
+}
+
+// Repeatable flag keeping every value given, for values that may hold commas like regexes
+type listFlags []string
+
+func (f *listFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// Define a repeatable list flag
+func listFlag(name string, usage string) *listFlags {
+	values := &listFlags{}
+	flag.Var(values, name, usage)
+	return values
+}
+
+// Resolve a path relative to the workspace root. Remote and absolute paths are used as they are
+func projectPath(p string) string {
+	return config.ResolvePath(p)
+}
+
+func main() {
+	flag.Parse()
+
+	// A missing API key names every way to set one, and the mock mode running without it
+	completion.MissingAPIKeyHint = fmt.Sprintf(
+		"export %s, set it under \"env\" in the -config file, or pass -fixture to replay recorded completions in mock mode, which needs no key",
+		completion.APIKeyEnv,
+	)
+
+	if err := exitcode.ValidateFormat(*errorFormat); err != nil {
+		*errorFormat = exitcode.FormatText
+		fail(err)
+	}
+
+	// Relative paths resolve against the workspace, wherever the binary is and whatever directory it runs from
+	workspace, err := config.SetupWorkspace(*workspaceRoot, tools.DefaultToolsJsonPath)
+	if err != nil {
+		fail(exitcode.New(exitcode.ClassConfig, err))
+	}
+	log.Printf("Using workspace %s, found from %s\n", workspace.Root, workspace.Source)
+
+	// Config file settings fill the flags not given on the command line.
+	// The doctor reports a broken config along with its other checks
+	isDoctorCommand := flag.NArg() >= 1 && flag.Arg(0) == "doctor"
+	profileSource, configErr := applyConfigFile()
+	if configErr != nil && !isDoctorCommand {
+		fail(exitcode.Default(exitcode.ClassConfig, configErr))
+	}
+	if *printConfig {
+		runPrintConfig(profileSource)
+		return
+	}
+
+	isAuditCommand := flag.NArg() >= 2 && flag.Arg(0) == "audit"
+	isReplayCommand := flag.NArg() >= 2 && flag.Arg(0) == "replay"
+	isBatchCommand := flag.NArg() >= 2 && flag.Arg(0) == "batch"
+	isCompareCommand := flag.NArg() >= 2 && flag.Arg(0) == "compare"
+	isPlanCommand := flag.NArg() >= 2 && flag.Arg(0) == "plan"
+	isResumeCommand := flag.NArg() == 2 && flag.Arg(0) == "resume"
+	isProfileCommand := flag.NArg() >= 1 && flag.Arg(0) == "profile"
+	isDescribeDatasetCommand := flag.NArg() >= 2 && flag.Arg(0) == "describe-dataset"
+	isReportsCommand := flag.NArg() >= 1 && flag.Arg(0) == "reports"
+	isScheduleCommand := flag.NArg() >= 1 && flag.Arg(0) == "schedule"
+	isRunsCommand := flag.NArg() >= 1 && flag.Arg(0) == "runs"
+	isRenderCommand := flag.NArg() >= 1 && flag.Arg(0) == "render"
+	isModelsCommand := flag.NArg() >= 1 && flag.Arg(0) == "models"
+	isWorkspaceCommand := flag.NArg() >= 1 && flag.Arg(0) == "workspace"
+	isQuickstartCommand := flag.NArg() >= 1 && flag.Arg(0) == "quickstart"
+	isDBCommand := flag.NArg() >= 1 && flag.Arg(0) == "db"
+	isRecallCommand := flag.NArg() >= 2 && flag.Arg(0) == "recall"
+	isExportFineTuneCommand := flag.NArg() >= 2 && flag.Arg(0) == "export-finetune"
+	isServeCommand := flag.NArg() >= 1 && flag.Arg(0) == "serve"
+	if flag.NArg() != 1 && !*healthcheck && !isAuditCommand && !isReplayCommand && !isBatchCommand && !isCompareCommand && !isPlanCommand &&
+		!isResumeCommand && !isProfileCommand && !isDescribeDatasetCommand && !isReportsCommand && !isScheduleCommand && !isRunsCommand &&
+		!isRenderCommand && !isModelsCommand && !isWorkspaceCommand && !isQuickstartCommand && !isDBCommand && !isRecallCommand && !isExportFineTuneCommand && !isServeCommand && !isDoctorCommand {
+		failf(
+			exitcode.ClassUsage,
+			"Usage: %[1]s [flags] [prompt]\n       %[1]s [flags] audit tail [amount]\n       %[1]s [flags] replay [transcript] [--re-run]\n"+
+				"       %[1]s [flags] batch --input [questions.jsonl] --output [results.jsonl] [--concurrency n]\n"+
+				"       %[1]s [flags] compare --models [model,model] [--judge] [--json report.json] [prompt]\n"+
+				"       %[1]s [flags] plan [--yes] [--plan-only] [prompt]\n       %[1]s [flags] resume [transcript]\n"+
+				"       %[1]s [flags] profile [--narrative] [--output profile.md]\n       %[1]s [flags] db [vacuum]\n"+
+				"       %[1]s [flags] describe-dataset sales [--write] [--no-llm] [--yes]\n"+
+				"       %[1]s [flags] -analyses-index analyses.jsonl recall query\n"+
+				"       %[1]s [flags] reports [list | run name | delete name]\n"+
+				"       %[1]s [flags] -config config.json schedule [--run-due [--dry-run]]\n"+
+				"       %[1]s [flags] runs [list [--since date] [--until date] [--search text] [--json] | show id [--json | --expand call-id | --extract call-id -o file.csv] | prune --older-than age]\n"+
+				"       %[1]s [flags] render chart.spec.json...\n"+
+				"       %[1]s [flags] models\n       %[1]s [flags] workspace\n"+
+				"       %[1]s [flags] export-finetune --step sqlgen [--since date] [--input finetune.jsonl] -o out.jsonl\n"+
+				"       %[1]s [flags] quickstart [--dir quickstart] [--rows n] [--seed n] [--question text] [--mock]\n"+
+				"       %[1]s [flags] serve [--addr :8080] [--max-in-flight n] [--request-timeout 30s]\n"+
+				"       %[1]s [flags] -print-config\n"+
+				"       %[1]s [flags] doctor [--offline] [--json]\n",
+			os.Args[0],
+		)
+	}
+
+	// Fail on a mistyped format before spending a run on it
+	answerFormat := render.Format("")
+	if *outputFormat != "" {
+		format, err := render.ParseFormat(*outputFormat)
+		if err != nil {
+			fail(exitcode.New(exitcode.ClassUsage, err))
+		}
+		answerFormat = format
+	}
+
+	config := agent.Config{
+		Model:              *model,
+		AllowUnknownModels: *allowUnknownModel,
+		DataPath:           projectPath(*dataPath),
+		ToolsJsonPath:      projectPath(tools.DefaultToolsJsonPath),
+		OutputDir:          projectPath(*outputDir),
+		DisableAudit:       *auditSetting == "off",
+		SkipDatasetCheck:   *skipDatasetCheck,
+		ForceRefresh:       *forceRefresh,
+		ReadOnly:           *readOnly,
+		MaxDBSizeMB:        *maxDBSizeMB,
+		DBSizeAction:       *dbSizeAction,
+		Production:         *production,
+		Sample:             tools.Sample{Fraction: *sampleFraction, Rows: *sampleRows},
+		SampledExport:      *allowSampledExport,
+		StorageBackend:     *storageBackend,
+		ExplainQueries:     *explainQueries,
+		ScanGuard:          tools.ScanGuard{MaxRows: *scanGuardRows, Action: *scanGuardAction},
+		GroundedAnalysis:   *groundedAnalysis,
+		ReloadTools:        *reloadTools,
+		WatchData:          *watchData,
+		Language:           *answerLanguage,
+		Verbosity:          *verbosity,
+		SuggestFollowUps:   *followUps,
+		RawNumbers:         *rawNumbers,
+		NumberLocale:       *numberLocale,
+		NumberDecimals:     *numberDecimals,
+		ExplainAnswer:      *explainAnswer,
+		Ungrounded:         *ungrounded,
+		MergeSystemPrompt:  *mergeSystemPrompt,
+		ProfilePrompt:      *profilePrompt,
+		DatasetDescription: *datasetDescription,
+		SensitiveColumns:   strings.Split(*sensitiveColumns, ","),
+		Principal:          *principal,
+		Role:               *role,
+		User:               *endUser,
+		RateLimits:         completion.RateLimits{RequestsPerMinute: *requestsPerMinute, TokensPerMinute: *tokensPerMinute},
+		HTTP:               completion.HTTPSettings{MaxIdleConnsPerHost: *maxIdleConns, ResponseHeaderTimeout: *responseTimeout},
+		MaxIterations:      *maxIterations,
+		MaxArgumentBytes:   *maxArgumentBytes,
+		Deterministic:      *deterministic,
+		Seed:               *seed,
+	}
+	config.Tags = runTags.Tags
+	if len(*injectionPatterns) != 0 {
+		config.InjectionPatterns = append(slices.Clone(agent.DefaultInjectionPatterns), *injectionPatterns...)
+	}
+	config.AuditPath = path.Join(config.OutputDir, *auditFile)
+	if *captureFineTune {
+		config.FineTunePath = path.Join(config.OutputDir, tools.DefaultFineTuneFile)
+		config.FineTuneSample = *fineTuneSampleRate
+	}
+	config.ReportsDir = path.Join(config.OutputDir, *reportsDir)
+	config.ThemesDir = *themesDir
+	config.ChartTheme = *chartTheme
+	if *analysesIndex != "" {
+		config.AnalysesIndexPath = projectPath(*analysesIndex)
+		config.EmbeddingModel = *embeddingModel
+	}
+
+	allowedTools, err := agent.ParseToolAllowlist(*toolsAllowlist)
+	if err != nil {
+		fail(exitcode.New(exitcode.ClassUsage, err))
+	}
+	// Left empty, New also allows the tools the config turns on, like SearchPastAnalyses with -analyses-index
+	if strings.TrimSpace(*toolsAllowlist) != "" {
+		config.Tools = allowedTools
+	}
+
+	config.ToolTimeouts, err = tools.ParseToolTimeouts(*toolTimeouts)
+	if err != nil {
+		fail(exitcode.New(exitcode.ClassUsage, err))
+	}
+
+	// Queries are confirmed on the terminal, so runs answering several questions unattended can't use it
+	if *confirmSQL {
+		if isBatchCommand || isCompareCommand || isScheduleCommand {
+			failf(exitcode.ClassUsage, "-confirm-sql only works on interactive runs")
+		}
+		confirmer, err := newTerminalSQLConfirmer()
+		if err != nil {
+			fail(exitcode.New(exitcode.ClassConfig, err))
+		}
+		config.ConfirmSQL = confirmer
+		if config.ToolTimeouts.Lookup == 0 {
+			config.ToolTimeouts.Lookup = confirmLookupTimeout
+		}
+	}
+
+	config.ToolModels, err = tools.ParseToolModels(*toolModels)
+	if err != nil {
+		fail(exitcode.New(exitcode.ClassUsage, err))
+	}
+	config.DateOrder, err = tools.ParseDateOrder(*dateFormat)
+	if err != nil {
+		fail(exitcode.New(exitcode.ClassUsage, err))
+	}
+	if *today != "" {
+		config.Today, err = time.Parse(time.DateOnly, *today)
+		if err != nil {
+			failf(exitcode.ClassUsage, "invalid -today '%s', expected YYYY-MM-DD", *today)
+		}
+	}
+	config.Glossary, err = tools.ParseGlossary(*glossary)
+	if err != nil {
+		fail(exitcode.New(exitcode.ClassUsage, err))
+	}
+	config.ColumnUnits, err = tools.ParseColumnUnits(*columnUnits)
+	if err != nil {
+		fail(exitcode.New(exitcode.ClassUsage, err))
+	}
+	if *accessConfig != "" {
+		config.Access, err = tools.LoadAccessPolicy(projectPath(*accessConfig))
+		if err != nil {
+			fail(exitcode.New(exitcode.ClassConfig, err))
+		}
+	}
+
+	// Fixtures stand in for OpenAI, to reproduce runs without an API key
+	if *fixture != "" {
+		config.Completer, err = mock.LoadFixture(projectPath(*fixture))
+		if err != nil {
+			fail(exitcode.New(exitcode.ClassConfig, err))
+		}
+		config.Embedder = &mock.Embedder{}
+	}
+
+	if isDoctorCommand {
+		runDoctorCommand(config, flag.Args()[1:], configErr)
+		return
+	}
+
+	if isRenderCommand {
+		runRenderCommand(flag.Args()[1:])
+		return
+	}
+
+	if isExportFineTuneCommand {
+		runExportFineTuneCommand(config, flag.Args()[1:])
+		return
+	}
+
+	if isWorkspaceCommand {
+		runWorkspaceCommand(flag.Args()[1:])
+		return
+	}
+
+	if isQuickstartCommand {
+		runQuickstartCommand(flag.Args()[1:])
+		return
+	}
+
+	if isModelsCommand {
+		runModelsCommand(flag.Args()[1:])
+		return
+	}
+
+	if isRunsCommand {
+		runRunsCommand(config, flag.Args()[1:])
+		return
+	}
+
+	if isAuditCommand {
+		runAuditCommand(config.AuditPath, flag.Args()[1:])
+		return
+	}
+
+	if isBatchCommand {
+		runBatchCommand(config, flag.Args()[1:])
+		return
+	}
+
+	if isCompareCommand {
+		runCompareCommand(config, flag.Args()[1:])
+		return
+	}
+
+	if isPlanCommand {
+		runPlanCommand(config, flag.Args()[1:])
+		return
+	}
+
+	if isProfileCommand {
+		runProfileCommand(config, flag.Args()[1:])
+		return
+	}
+
+	if isDescribeDatasetCommand {
+		runDescribeDatasetCommand(config, flag.Args()[1:])
+		return
+	}
+
+	if isDBCommand {
+		runDBCommand(config, flag.Args()[1:])
+		return
+	}
+
+	if isRecallCommand {
+		runRecallCommand(config, flag.Args()[1:])
+		return
+	}
+
+	if isReportsCommand {
+		runReportsCommand(config, flag.Args()[1:])
+		return
+	}
+
+	if isScheduleCommand {
+		runScheduleCommand(config, flag.Args()[1:])
+		return
+	}
+
+	if isServeCommand {
+		runServeCommand(config, flag.Args()[1:])
+		return
+	}
+
+	prompt := flag.Arg(0)
+	if isReplayCommand {
+		transcript, reRun := loadReplay(flag.Args()[1:])
+		if !reRun {
+			fmt.Printf(
+				"Run '%s' (%s) deterministic=%t seed=%d aborted=%t\nPrompt: %s\nAnswer: %s\n",
+				transcript.RunID, transcript.TimeStamp, transcript.Deterministic, transcript.Seed,
+				transcript.Aborted, transcript.Prompt, transcript.Answer,
+			)
+			return
+		}
+
+		// Re-execute the prompt with the seed the run used
+		log.Printf("Re-running '%s' with seed %d\n", transcript.RunID, transcript.Seed)
+		prompt = transcript.Prompt
+		config.Deterministic = true
+		config.Seed = transcript.Seed
+		if len(transcript.Tools) != 0 {
+			config.Tools = transcript.Tools
+		}
+	}
+
+	// Completed runs have nothing to resume, skip the agent setup
+	if isResumeCommand {
+		transcript, err := agent.LoadTranscript(projectPath(flag.Arg(1)))
+		if err != nil {
+			fail(exitcode.Default(exitcode.ClassData, fmt.Errorf("failed to load transcript: %w", err)))
+		}
+		if transcript.Completed {
+			fmt.Printf("Run '%s' already completed\nAnswer: %s\n", transcript.RunID, transcript.Answer)
+			return
+		}
+	}
+
+	tracer, err := newPhoenixTracer()
+	if err != nil {
+		fail(err)
+	}
+	config.Tracer = tracer
+
+	runAgent, err := agent.New(config)
+	if err != nil {
+		fail(err)
+	}
+
+	if *healthcheck {
+		runHealthcheck(runAgent, tracer)
+		return
+	}
+
+	// Run the agent on its own goroutine so interrupts can cancel it
+	runContext, cancelRun := context.WithCancel(context.Background())
+	defer cancelRun()
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan runOutcome, 1)
+	go func() {
+		if isResumeCommand {
+			result, err := runAgent.Resume(runContext, flag.Arg(1))
+			done <- runOutcome{result: result, err: err}
+			return
+		}
+
+		if *continueFrom != "" {
+			result, err := runAgent.RunContinued(runContext, *continueFrom, prompt)
+			done <- runOutcome{result: result, err: err}
+			return
+		}
+
+		result, err := runAgent.Run(runContext, prompt)
+		done <- runOutcome{result: result, err: err}
+	}()
+
+	aborted := false
+	outcome := runOutcome{}
+	select {
+	case outcome = <-done:
+	case <-signals:
+		log.Println("Interrupt received, cancelling run. Interrupt again to force exit")
+		aborted = true
+		cancelRun()
+
+		// The partial transcript is written before waiting, a run that doesn't stop still leaves it behind
+		for _, transcriptPath := range runAgent.AbortLiveRuns() {
+			log.Printf("Aborted run transcript at %s\n", transcriptPath)
+		}
+
+		// A second interrupt skips any cleanup
+		go func() {
+			<-signals
+			failf(exitcode.ClassInterrupted, "second interrupt received, forcing exit")
+		}()
+
+		select {
+		case outcome = <-done:
+		case <-time.After(shutdownTimeout):
+			// The run still uses the database, closing it under the run could corrupt it. Exiting releases it
+			log.Printf("WARNING: Run didn't stop after %s, exiting without closing the database\n", shutdownTimeout)
+			shutdownTracer(tracer)
+			failf(exitcode.ClassInterrupted, "run aborted: %v", context.Canceled)
+		}
+	}
+
+	shutdown(runAgent, tracer, outcome.result, aborted)
+
+	if aborted {
+		failf(exitcode.ClassInterrupted, "run aborted: %v", outcome.err)
+	}
+
+	if outcome.err != nil {
+		fail(outcome.err)
+	}
+
+	// Keep only the most recent runs if requested
+	if err := tools.PruneRuns(config.OutputDir, *keepRuns); err != nil {
+		log.Printf("WARNING: %s\n", err)
+	}
+
+	if *exportHistory != "" {
+		if err := agent.ExportHistory(outcome.result, *exportHistory); err != nil {
+			log.Printf("WARNING: %s\n", err)
+		}
+	}
+
+	log.Printf("Run '%s' artifacts at %s: %v\n", outcome.result.RunID, outcome.result.RunDir, outcome.result.Artifacts)
+	for _, name := range outcome.result.Artifacts {
+		if artifactURL, ok := outcome.result.ArtifactURLs[name]; ok {
+			log.Printf("Run '%s' artifact %s published at %s\n", outcome.result.RunID, name, artifactURL)
+		}
+	}
+	printAnswer(outcome.result, answerFormat)
+
+	// JSON output already holds the timings
+	if *showTimings && answerFormat != render.JSON {
+		printTimings(outcome.result.Timings)
+	}
+}
+
+// Print the time spent per step of a run: the whole run first, then router calls, rate limit waits, DuckDB and each tool
+func printTimings(timings map[string]tools.StepTiming) {
+	steps := []string{tools.TimingTotal, tools.TimingRouter, tools.TimingRateLimitWait, tools.TimingDuckDB}
+	for _, step := range slices.Sorted(maps.Keys(timings)) {
+		if !slices.Contains(steps, step) {
+			steps = append(steps, step)
+		}
+	}
+
+	total := timings[tools.TimingTotal].TotalMs
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(writer, "STEP\tCOUNT\tTOTAL (ms)\tMAX (ms)\tSHARE\t")
+	for _, step := range steps {
+		timing := timings[step]
+		share := "-"
+		if total > 0 {
+			share = fmt.Sprintf("%.1f%%", float64(timing.TotalMs)/float64(total)*100)
+		}
+		fmt.Fprintf(writer, "%s\t%d\t%d\t%d\t%s\t\n", step, timing.Count, timing.TotalMs, timing.MaxMs, share)
+	}
+	writer.Flush()
+}
+
+// Print the answer of a run on an output format. Without one the answer is logged
+func printAnswer(result agent.RunResult, format render.Format) {
+	switch format {
+	case "":
+		log.Println(result.Answer)
+	case render.JSON:
+		jsonBytes, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			fail(fmt.Errorf("failed to marshal run result: %w", err))
+		}
+		fmt.Println(string(jsonBytes))
+	default:
+		fmt.Println(render.Answer(result.Answer, format))
+	}
+}
+
+// Release every resource held by the run: write the transcript,
+// close the agent database and flush the tracer provider
+func shutdown(runAgent *agent.Agent, tracer *tracing.Tracer, result agent.RunResult, aborted bool) {
+	if result.RunID != "" {
+		if _, err := agent.WriteTranscript(result, aborted); err != nil {
+			log.Printf("WARNING: Failed to write transcript: %s\n", err)
+		} else if transcriptURL := runAgent.PublishTranscript(context.Background(), result); transcriptURL != "" {
+			log.Printf("Run '%s' transcript published at %s\n", result.RunID, transcriptURL)
+		}
+	}
+
+	if err := runAgent.Close(); err != nil {
+		log.Printf("WARNING: Failed to close database: %s\n", err)
+	}
+
+	shutdownTracer(tracer)
+}
+
+// Flush the batch span processor before exiting
+func shutdownTracer(tracer *tracing.Tracer) {
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		log.Printf("ERROR: Failed to shutdown tracer provider: %s\n", err)
+	}
+}
+
+// Handle the audit subcommand. Only `tail [amount]` is supported
+func runAuditCommand(auditPath string, args []string) {
+	if args[0] != "tail" {
+		failf(exitcode.ClassUsage, "unknown audit subcommand '%s'. Expected 'tail'", args[0])
+	}
+
+	amount := 20
+	if len(args) > 1 {
+		parsed, err := strconv.Atoi(args[1])
+		if err != nil || parsed <= 0 {
+			failf(exitcode.ClassUsage, "invalid amount of audit entries: '%s'", args[1])
+		}
+		amount = parsed
+	}
+
+	entries, err := tools.AuditTail(auditPath, amount)
+	if err != nil {
+		fail(exitcode.New(exitcode.ClassData, fmt.Errorf("failed to read audit log: %w", err)))
+	}
+
+	for _, entry := range entries {
+		status := "ok"
+		if entry.Error != "" {
+			status = "error: " + entry.Error
+		}
+
+		fmt.Printf(
+			"%s run=%s tool=%s source=%s rows=%d duration=%dms %s\n    %s\n",
+			entry.TimeStamp, entry.RunID, entry.Tool, entry.Source,
+			entry.RowCount, entry.DurationMs, status,
+			strings.Join(strings.Fields(entry.SQL), " "),
+		)
+	}
+}
+
+// Parse the replay subcommand arguments: a transcript path and an optional --re-run flag
+func loadReplay(args []string) (agent.Transcript, bool) {
+	transcriptPath := ""
+	reRun := false
+	for _, arg := range args {
+		if arg == "--re-run" || arg == "-re-run" {
+			reRun = true
+		} else {
+			transcriptPath = arg
+		}
+	}
+
+	if transcriptPath == "" {
+		failf(exitcode.ClassUsage, "missing transcript path for replay")
+	}
+
+	transcript, err := agent.LoadTranscript(projectPath(transcriptPath))
+	if err != nil {
+		fail(exitcode.Default(exitcode.ClassData, fmt.Errorf("failed to load transcript: %w", err)))
+	}
+
+	if reRun && transcript.Prompt == "" {
+		failf(exitcode.ClassData, "transcript '%s' has no prompt to re-run", transcriptPath)
+	}
+
+	if reRun && !transcript.Deterministic {
+		log.Printf("WARNING: Run '%s' wasn't deterministic, re-running with seed %d anyway\n", transcript.RunID, *seed)
+		transcript.Seed = *seed
+	}
+
+	return transcript, reRun
+}
+
+// Warm up every component, including a ping completion, and print their status.
+// Exits with an error code if any component failed
+func runHealthcheck(runAgent *agent.Agent, tracer *tracing.Tracer) {
+	readiness := runAgent.Warmup(context.Background(), true)
+	shutdown(runAgent, tracer, agent.RunResult{}, false)
+
+	jsonBytes, err := json.MarshalIndent(readiness, "", "  ")
+	if err != nil {
+		fail(err)
+	}
+	fmt.Println(string(jsonBytes))
+
+	if !readiness.Healthy() {
+		os.Exit(1)
+	}
+}
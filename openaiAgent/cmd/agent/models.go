@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+)
+
+/*
+-----------
+Models mode
+-----------
+*/
+
+// Handle the models subcommand: list the model registry
+func runModelsCommand(args []string) {
+	if len(args) != 0 {
+		failf(exitcode.ClassUsage, "unknown models subcommand '%s'. Expected none", args[0])
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "MODEL\tCONTEXT\tMAX OUTPUT\tINPUT $/M\tOUTPUT $/M\tTOOLS\tSTRUCTURED\tVISION")
+	for _, id := range completion.KnownModels() {
+		info, _ := completion.LookupModel(id)
+		fmt.Fprintf(writer, "%s\t%d\t%d\t%s\t%s\t%t\t%t\t%t\n",
+			info.ID, info.ContextWindow, info.MaxOutputTokens, info.InputPrice, info.OutputPrice, info.Tools, info.StructuredOutputs, info.Vision)
+	}
+	writer.Flush()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+)
+
+/*
+-------------
+Planning mode
+-------------
+*/
+
+// Handle the plan subcommand: print the tool calls the agent intends to make, then run it with the plan
+// once confirmed. --yes skips the confirmation, for scripting
+func runPlanCommand(config agent.Config, args []string) {
+	planFlags := flag.NewFlagSet("plan", flag.ExitOnError)
+	yes := planFlags.Bool("yes", false, "Run the plan without asking for confirmation")
+	planOnly := planFlags.Bool("plan-only", false, "Print the plan and exit without running it")
+	planFlags.Parse(args)
+
+	if planFlags.NArg() != 1 {
+		failf(exitcode.ClassUsage, "planning mode needs one prompt")
+	}
+	prompt := planFlags.Arg(0)
+
+	tracer, err := newPhoenixTracer()
+	if err != nil {
+		fail(err)
+	}
+	config.Tracer = tracer
+
+	runAgent, err := agent.New(config)
+	if err != nil {
+		fail(err)
+	}
+
+	ctx := context.Background()
+	plan, err := runAgent.Plan(ctx, prompt)
+	if err != nil {
+		shutdown(runAgent, tracer, agent.RunResult{}, false)
+		fail(fmt.Errorf("failed to plan the run: %w", err))
+	}
+
+	fmt.Printf("Plan for: %s\n%s\n", prompt, plan)
+	if *planOnly || (!*yes && !confirm("Run this plan?")) {
+		shutdown(runAgent, tracer, agent.RunResult{}, false)
+		return
+	}
+
+	result, err := runAgent.RunWithPlan(ctx, prompt, plan)
+	shutdown(runAgent, tracer, result, false)
+	if err != nil {
+		fail(err)
+	}
+
+	if result.PlanDivergence != nil && result.PlanDivergence.Diverged() {
+		fmt.Printf(
+			"\nThe run diverged from the plan by %d tool calls. Planned: %v, called: %v\n",
+			result.PlanDivergence.Distance, result.PlanDivergence.Planned, result.PlanDivergence.Actual,
+		)
+	}
+	fmt.Printf("\n%s\n", result.Answer)
+}
+
+// Ask a yes or no question on the terminal. Anything but y or yes is a no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+)
+
+/*
+------------
+Profile mode
+------------
+*/
+
+// Handle the profile subcommand: print a Markdown overview of the dataset, or write it to --output.
+// --narrative appends an LLM summary paragraph
+func runProfileCommand(config agent.Config, args []string) {
+	profileFlags := flag.NewFlagSet("profile", flag.ExitOnError)
+	narrative := profileFlags.Bool("narrative", false, "Append a one paragraph summary written by the model")
+	output := profileFlags.String("output", "", "Markdown file to write the profile to instead of printing it")
+	profileFlags.Parse(args)
+
+	tracer, err := newPhoenixTracer()
+	if err != nil {
+		fail(err)
+	}
+	config.Tracer = tracer
+
+	runAgent, err := agent.New(config)
+	if err != nil {
+		fail(err)
+	}
+
+	profile, err := runAgent.Profile(context.Background(), *narrative)
+	shutdown(runAgent, tracer, agent.RunResult{}, false)
+	if err != nil {
+		fail(exitcode.Default(exitcode.ClassData, fmt.Errorf("failed to profile the dataset: %w", err)))
+	}
+
+	if *output == "" {
+		fmt.Print(profile.Markdown())
+		return
+	}
+
+	if err := os.WriteFile(projectPath(*output), []byte(profile.Markdown()), 0o644); err != nil {
+		fail(exitcode.Default(exitcode.ClassData, fmt.Errorf("failed to write profile: %w", err)))
+	}
+	log.Printf("Wrote dataset profile to %s\n", *output)
+}
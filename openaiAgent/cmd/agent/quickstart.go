@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+)
+
+/*
+---------------
+Quickstart mode
+---------------
+*/
+
+// Directory the quickstart writes its files to unless --dir names another, relative to the workspace
+const defaultQuickstartDir = "quickstart"
+
+// Question the quickstart asks, and the query its mock mode answers it with
+const (
+	quickstartQuestion = "Which store had the highest total sales?"
+	quickstartQuery    = "SELECT Store_Number, sum(Total_Sale_Value::DECIMAL(18, 2)) AS Total_Sales FROM sales " +
+		"GROUP BY Store_Number ORDER BY Total_Sales DESC LIMIT 1"
+)
+
+// Files written by the quickstart, relative to the workspace unless its directory is absolute
+type quickstartFiles struct {
+	Dir       string
+	DataPath  string
+	Config    string
+	Fixture   string
+	OutputDir string
+}
+
+func newQuickstartFiles(dir string) quickstartFiles {
+	return quickstartFiles{
+		Dir:       dir,
+		DataPath:  filepath.Join(dir, "sales.parquet"),
+		Config:    filepath.Join(dir, "config.json"),
+		Fixture:   filepath.Join(dir, "fixture.json"),
+		OutputDir: filepath.Join(dir, "runs"),
+	}
+}
+
+// Figures of the example dataset the mock answer states, and the tests pin
+type exampleSummary struct {
+	Rows          int64
+	TotalSales    string
+	TopStore      int
+	TopStoreSales string
+}
+
+// Outcome of each quickstart step
+type quickstartOutcome struct {
+	Files   quickstartFiles
+	Summary exampleSummary
+	Checks  []agent.CheckResult
+	Mocked  bool
+	Result  agent.RunResult
+}
+
+// Handle the quickstart subcommand: generate the example dataset, write a config and a mock fixture for it,
+// run the doctor checks and ask a sample question end to end. Completions are mocked without an API key
+func runQuickstartCommand(args []string) {
+	quickstartFlags := flag.NewFlagSet("quickstart", flag.ExitOnError)
+	dir := quickstartFlags.String("dir", defaultQuickstartDir, "Directory of the generated files, relative to the workspace")
+	rows := quickstartFlags.Int("rows", tools.DefaultExampleRows, "Rows of the example dataset")
+	seed := quickstartFlags.Int64("seed", tools.DefaultExampleSeed, "Seed of the example dataset, the same seed generates the same rows")
+	question := quickstartFlags.String("question", quickstartQuestion, "Question asked once the environment is checked")
+	forceMock := quickstartFlags.Bool("mock", false, "Replay the mock completions even if an API key is set")
+	quickstartFlags.Parse(args)
+
+	outcome, err := quickstart(*dir, tools.ExampleDataset{Rows: *rows, Seed: *seed}, *question, *forceMock)
+	if outcome.Checks != nil {
+		printDoctorReport(outcome.Checks, agent.ChecksPassed(outcome.Checks))
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	mode := "OpenAI"
+	if outcome.Mocked {
+		mode = "mock completions, no API key needed"
+	}
+	fmt.Printf("\nExample dataset: %s (%d rows, total sales %s)\n", outcome.Files.DataPath, outcome.Summary.Rows, outcome.Summary.TotalSales)
+	fmt.Printf("Question (%s): %s\nAnswer: %s\n\n", mode, *question, outcome.Result.Answer)
+	fmt.Printf("Ask your own questions with:\n  %s -config %s \"What were the sales of each product class?\"\n", os.Args[0], outcome.Files.Config)
+	fmt.Printf("Replay the sample question without an API key with:\n  %s -config %s -fixture %s \"%s\"\n",
+		os.Args[0], outcome.Files.Config, outcome.Files.Fixture, quickstartQuestion)
+}
+
+// Run every quickstart step on dir, stopping at the first failure. The outcome holds the steps done until then
+func quickstart(dir string, dataset tools.ExampleDataset, question string, forceMock bool) (quickstartOutcome, error) {
+	outcome := quickstartOutcome{Files: newQuickstartFiles(dir)}
+	files := outcome.Files
+
+	log.Printf("Generating %d example sales with seed %d\n", dataset.Rows, dataset.Seed)
+	if err := dataset.Write(projectPath(files.DataPath)); err != nil {
+		return outcome, exitcode.New(exitcode.ClassData, err)
+	}
+	summary, err := summarizeExampleDataset(projectPath(files.DataPath))
+	if err != nil {
+		return outcome, exitcode.New(exitcode.ClassData, err)
+	}
+	outcome.Summary = summary
+
+	if err := writeQuickstartConfig(files); err != nil {
+		return outcome, err
+	}
+	if err := writeQuickstartFixture(files, summary); err != nil {
+		return outcome, err
+	}
+
+	runConfig := agent.Config{
+		DataPath:           projectPath(files.DataPath),
+		ToolsJsonPath:      projectPath(tools.DefaultToolsJsonPath),
+		OutputDir:          projectPath(files.OutputDir),
+		Glossary:           tools.ExampleGlossary,
+		DatasetDescription: tools.ExampleDescription,
+	}
+	outcome.Mocked = forceMock || completion.RequireAPIKey() != nil
+	if outcome.Mocked {
+		runConfig.Completer, err = mock.LoadFixture(projectPath(files.Fixture))
+		if err != nil {
+			return outcome, exitcode.New(exitcode.ClassConfig, err)
+		}
+	}
+
+	// The sample question runs without Phoenix too, its spans are dropped then
+	checks := agent.EnvironmentChecks(outcome.Mocked)
+	for i := range checks {
+		checks[i].Required = checks[i].Required && checks[i].Name != "tracing"
+	}
+	outcome.Checks = agent.RunChecks(context.Background(), runConfig, checks)
+	if !agent.ChecksPassed(outcome.Checks) {
+		return outcome, exitcode.Errorf(exitcode.ClassConfig, "the environment isn't ready for the sample question, fix the failed checks above")
+	}
+
+	runConfig.Tracer = tracing.NewNoopTracer()
+	for _, result := range outcome.Checks {
+		if result.Name == "tracing" && result.OK {
+			if runConfig.Tracer, err = newPhoenixTracer(); err != nil {
+				return outcome, err
+			}
+			defer runConfig.Tracer.Shutdown(context.Background())
+		}
+	}
+
+	runAgent, err := agent.New(runConfig)
+	if err != nil {
+		return outcome, exitcode.Default(exitcode.ClassConfig, err)
+	}
+	defer runAgent.Close()
+
+	outcome.Result, err = runAgent.Run(context.Background(), question)
+	if err != nil {
+		return outcome, err
+	}
+	if _, err := agent.WriteTranscript(outcome.Result, false); err != nil {
+		log.Printf("WARNING: %s\n", err)
+	}
+
+	return outcome, nil
+}
+
+// Query the figures of the example dataset, with the quickstart query for the top store
+func summarizeExampleDataset(dataPath string) (exampleSummary, error) {
+	summary := exampleSummary{}
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		return summary, err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(fmt.Sprintf("CREATE VIEW sales AS SELECT * FROM read_parquet('%s')", dataPath)); err != nil {
+		return summary, fmt.Errorf("failed to read example dataset: %w", err)
+	}
+	err = db.QueryRow("SELECT count(*), sum(Total_Sale_Value::DECIMAL(18, 2))::VARCHAR FROM sales").Scan(&summary.Rows, &summary.TotalSales)
+	if err != nil {
+		return summary, fmt.Errorf("failed to summarize example dataset: %w", err)
+	}
+	err = db.QueryRow(fmt.Sprintf("SELECT Store_Number, Total_Sales::VARCHAR FROM (%s)", quickstartQuery)).Scan(&summary.TopStore, &summary.TopStoreSales)
+	if err != nil {
+		return summary, fmt.Errorf("failed to find the top store of the example dataset: %w", err)
+	}
+
+	return summary, nil
+}
+
+// Write the config file pointing the agent at the example dataset, with its glossary and description
+func writeQuickstartConfig(files quickstartFiles) error {
+	glossary := []string{}
+	for _, term := range tools.ExampleGlossary {
+		glossary = append(glossary, term.Term+"="+term.Column)
+	}
+
+	settings := map[string]any{
+		"data-path":           files.DataPath,
+		"output-dir":          files.OutputDir,
+		"glossary":            strings.Join(glossary, ","),
+		"dataset-description": tools.ExampleDescription,
+	}
+	jsonBytes, err := json.MarshalIndent(map[string]any{"settings": settings}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(projectPath(files.Config), append(jsonBytes, '\n'), 0o644)
+}
+
+// Write the completions of the sample question, a lookup with the quickstart query and an answer
+// stating the figures it returns
+func writeQuickstartFixture(files quickstartFiles, summary exampleSummary) error {
+	answer := fmt.Sprintf("Store %d had the highest total sales, %s dollars.", summary.TopStore, summary.TopStoreSales)
+	responses := []json.RawMessage{}
+	for _, response := range []*openai.ChatCompletion{
+		mock.ToolCallResponse("call_1", tools.LookUpFuncName, map[string]string{"prompt": "Total sales of each store, highest first"}),
+		mock.TextResponse(quickstartQuery),
+		mock.TextResponse(answer),
+	} {
+		responses = append(responses, json.RawMessage(response.JSON.RawJSON()))
+	}
+
+	fixture := mock.Fixture{
+		Name:        "quickstart",
+		Description: fmt.Sprintf("Replay with -config %s. Asks '%s' on the example dataset, ends with the answer and 2 router calls", files.Config, quickstartQuestion),
+		Responses:   responses,
+	}
+	jsonBytes, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(projectPath(files.Fixture), append(jsonBytes, '\n'), 0o644)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/config"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+// Figures of the default example dataset, documentation can quote them
+var defaultExampleSummary = exampleSummary{Rows: 5000, TotalSales: "422783.32", TopStore: 660, TopStoreSales: "55632.22"}
+
+// The quickstart runs end to end in mock mode on a temporary directory: the example dataset is deterministic,
+// the config loads, the doctor passes and the sample question answers with the top store
+func TestQuickstart(t *testing.T) {
+	// Like main, so the tools json resolves against the agent module
+	if _, err := config.SetupWorkspace("", tools.DefaultToolsJsonPath); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	outcome, err := quickstart(dir, tools.ExampleDataset{Rows: tools.DefaultExampleRows, Seed: tools.DefaultExampleSeed}, quickstartQuestion, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("deterministic dataset", func(t *testing.T) {
+		if outcome.Summary != defaultExampleSummary {
+			t.Fatalf("generated %+v, expected %+v", outcome.Summary, defaultExampleSummary)
+		}
+
+		cases := []struct {
+			name string
+			seed int64
+			same bool // Whether the dataset has the figures of the default one
+		}{
+			{name: "same seed", seed: tools.DefaultExampleSeed, same: true},
+			{name: "other seed", seed: tools.DefaultExampleSeed + 1},
+		}
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				dataPath := filepath.Join(t.TempDir(), fmt.Sprintf("seed-%d.parquet", c.seed))
+				if err := (tools.ExampleDataset{Rows: tools.DefaultExampleRows, Seed: c.seed}).Write(dataPath); err != nil {
+					t.Fatal(err)
+				}
+				again, err := summarizeExampleDataset(dataPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if (again == outcome.Summary) != c.same {
+					t.Errorf("generated %+v, expected the same figures to be %t", again, c.same)
+				}
+			})
+		}
+
+		if err := (tools.ExampleDataset{}).Write(filepath.Join(t.TempDir(), "empty.parquet")); err == nil {
+			t.Error("expected an empty example dataset to fail")
+		}
+	})
+
+	t.Run("config", func(t *testing.T) {
+		loaded, err := config.Load(outcome.Files.Config, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, want := range map[string]string{"data-path": outcome.Files.DataPath, "output-dir": outcome.Files.OutputDir} {
+			setting, ok := config.Find(loaded.Settings, name)
+			if !ok || setting.Value != want {
+				t.Errorf("config sets %s to '%s', expected '%s'", name, setting.Value, want)
+			}
+		}
+		glossary, ok := config.Find(loaded.Settings, "glossary")
+		if !ok {
+			t.Fatal("config has no glossary")
+		}
+		terms, err := tools.ParseGlossary(glossary.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(terms) != len(tools.ExampleGlossary) {
+			t.Errorf("config glossary has %d terms, expected %d", len(terms), len(tools.ExampleGlossary))
+		}
+	})
+
+	// The data and tools checks pass, and the key checks are skipped in mock mode
+	t.Run("doctor", func(t *testing.T) {
+		want := map[string]string{"data": "ok", "tools": "ok", "api-key": "skipped", "openai": "skipped"}
+		for _, result := range outcome.Checks {
+			status := "failed"
+			switch {
+			case result.Skipped:
+				status = "skipped"
+			case result.OK:
+				status = "ok"
+			}
+			if expected, ok := want[result.Name]; ok && status != expected {
+				t.Errorf("check '%s' %s, expected %s: %s", result.Name, status, expected, result.Error)
+			}
+			delete(want, result.Name)
+		}
+		if len(want) != 0 {
+			t.Errorf("checks missing from the report: %v", want)
+		}
+	})
+
+	t.Run("sample question", func(t *testing.T) {
+		if !outcome.Mocked || !outcome.Result.Completed {
+			t.Fatalf("expected a completed mock run, got mocked %t and completed %t", outcome.Mocked, outcome.Result.Completed)
+		}
+
+		lookups := 0
+		for _, query := range outcome.Result.Queries {
+			if query.Source == tools.AuditSourceGenerated && query.RowCount == 1 && query.Error == "" {
+				lookups++
+			}
+		}
+		if lookups != 1 {
+			t.Errorf("expected the quickstart query to return the top store once, got %d lookups", lookups)
+		}
+		if !strings.Contains(outcome.Result.Answer, strconv.Itoa(outcome.Summary.TopStore)) {
+			t.Errorf("answer '%s' doesn't name store %d", outcome.Result.Answer, outcome.Summary.TopStore)
+		}
+		if _, err := os.Stat(filepath.Join(outcome.Result.RunDir, "transcript.json")); err != nil {
+			t.Error("the run has no transcript")
+		}
+	})
+}
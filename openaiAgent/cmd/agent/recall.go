@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+)
+
+/*
+-----------
+Recall mode
+-----------
+*/
+
+// Past analyses printed by the recall subcommand
+const recallTopK = 5
+
+// Handle the recall subcommand: print the past analyses most similar to a query. Exits with an error code on failures
+func runRecallCommand(config agent.Config, args []string) {
+	if len(args) != 1 {
+		failf(exitcode.ClassUsage, "expected a query to search the past analyses for")
+	}
+
+	runAgent, err := agent.New(config)
+	if err != nil {
+		fail(err)
+	}
+	defer runAgent.Close()
+
+	matches, err := runAgent.SearchPastAnalyses(context.Background(), args[0], recallTopK)
+	if err != nil {
+		fail(err)
+	}
+	if len(matches) == 0 {
+		fmt.Println("No past analyses found")
+		return
+	}
+	for _, match := range matches {
+		fmt.Printf("%.3f  %s  %s\n  Q: %s\n  A: %s\n", match.Score, match.Date.Format(time.DateOnly), match.RunID, match.Question, match.Answer)
+	}
+}
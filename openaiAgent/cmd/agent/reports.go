@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+/*
+------------
+Reports mode
+------------
+*/
+
+// Handle the reports subcommand: list the saved reports, delete one, or run one on the current data
+func runReportsCommand(config agent.Config, args []string) {
+	store := tools.NewReportStore(config.ReportsDir)
+	if len(args) == 0 || args[0] == "list" {
+		reports, err := store.List()
+		if err != nil {
+			fail(exitcode.Default(exitcode.ClassData, fmt.Errorf("failed to list reports: %w", err)))
+		}
+		if len(reports) == 0 {
+			fmt.Printf("No reports saved in %s\n", config.ReportsDir)
+		}
+
+		for _, report := range reports {
+			fmt.Printf(
+				"%s  %s chart of %s by %s, saved %s from run %s\n    %s\n",
+				report.Name, report.Config.ChartType, report.Config.YAxis, report.Config.XAxis,
+				report.CreatedAt, report.RunID, strings.Join(strings.Fields(report.SQL), " "),
+			)
+		}
+		return
+	}
+
+	if len(args) != 2 {
+		failf(exitcode.ClassUsage, "usage: reports [list] | reports run [name] | reports delete [name]")
+	}
+
+	switch args[0] {
+	case "delete":
+		if err := store.Delete(args[1]); err != nil {
+			fail(err)
+		}
+		fmt.Printf("Deleted report '%s'\n", args[1])
+	case "run":
+		runReport(config, args[1])
+	default:
+		failf(exitcode.ClassUsage, "unknown reports subcommand '%s'. Expected 'list', 'run' or 'delete'", args[0])
+	}
+}
+
+// Render a saved report on the current data and print where its chart code was written
+func runReport(config agent.Config, name string) {
+	tracer, err := newPhoenixTracer()
+	if err != nil {
+		fail(err)
+	}
+	config.Tracer = tracer
+
+	runAgent, err := agent.New(config)
+	if err != nil {
+		fail(err)
+	}
+
+	reportRun, err := runAgent.RunReport(context.Background(), name)
+	shutdown(runAgent, tracer, agent.RunResult{}, false)
+	if err != nil {
+		fail(exitcode.Default(exitcode.ClassData, fmt.Errorf("failed to run report '%s': %w", name, err)))
+	}
+
+	fmt.Printf(
+		"Report '%s': %d rows\nData: %s\nChart code: %s\nRun it with python to save the chart next to it\n",
+		name, len(reportRun.Rows),
+		filepath.Join(reportRun.RunDir, reportRun.DataPath), filepath.Join(reportRun.RunDir, reportRun.ArtifactPath),
+	)
+	if reportRun.DataURL != "" {
+		fmt.Printf("Published data: %s\n", reportRun.DataURL)
+	}
+	if reportRun.ArtifactURL != "" {
+		fmt.Printf("Published chart code: %s\n", reportRun.ArtifactURL)
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/llmdebug"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+/*
+---------
+Runs mode
+---------
+*/
+
+// Characters of the question and answer previews on the runs list
+const runPreviewChars = 48
+
+// Characters of a message shown by runs show, longer ones are cut
+const runMessageChars = 2000
+
+// Handle the runs subcommand: list the runs on the output directory, show one, or prune the old ones.
+// Only reads the output directory, without network or agent setup
+func runRunsCommand(config agent.Config, args []string) {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	switch args[0] {
+	case "list":
+		listRuns(config.OutputDir, args[1:])
+	case "show":
+		showRun(config.OutputDir, args[1:])
+	case "prune":
+		pruneRuns(config.OutputDir, args[1:])
+	default:
+		failf(exitcode.ClassUsage, "unknown runs subcommand '%s'. Expected 'list', 'show' or 'prune'", args[0])
+	}
+}
+
+// Print the runs matching the filter flags as a table, newest first
+func listRuns(outputDir string, args []string) {
+	listFlags := flag.NewFlagSet("runs list", flag.ExitOnError)
+	since := listFlags.String("since", "", "Only runs started since a date (2006-01-02), time (RFC 3339) or age ago (7d, 12h)")
+	until := listFlags.String("until", "", "Only runs started before a date (2006-01-02), time (RFC 3339) or age ago (7d, 12h)")
+	search := listFlags.String("search", "", "Only runs whose question holds this text, case insensitively")
+	limit := listFlags.Int("limit", 0, "Max runs listed. 0 lists all")
+	jsonOutput := listFlags.Bool("json", false, "Print the runs as JSON")
+	listFlags.Parse(args)
+
+	filter := agent.RunFilter{Search: *search}
+	var err error
+	if filter.Since, err = parseRunTime(*since); err != nil {
+		failf(exitcode.ClassUsage, "invalid -since: %w", err)
+	}
+	if filter.Until, err = parseRunTime(*until); err != nil {
+		failf(exitcode.ClassUsage, "invalid -until: %w", err)
+	}
+
+	runs, err := agent.ListRuns(outputDir, filter)
+	if err != nil {
+		fail(err)
+	}
+	if *limit > 0 && len(runs) > *limit {
+		runs = runs[:*limit]
+	}
+
+	if *jsonOutput {
+		printJSON(runs)
+		return
+	}
+	if len(runs) == 0 {
+		fmt.Printf("No runs found in %s\n", outputDir)
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "RUN ID\tSTARTED\tSTATUS\tDURATION\tCOST (USD)\tQUESTION\tANSWER")
+	for _, run := range runs {
+		fmt.Fprintf(
+			writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			run.RunID, run.StartedAt.Format(time.DateTime), run.Status, runDuration(run),
+			runCost(run), runPreview(run.Question), runPreview(run.Answer),
+		)
+	}
+	writer.Flush()
+}
+
+// Print the transcript of a run: its conversation with every tool call, then its SQL queries. Messages are
+// read one at a time and long ones cut to a preview, --expand prints a tool result whole and --extract writes
+// it to a file, the full lookup artifact for lookup results
+func showRun(outputDir string, args []string) {
+	showFlags := flag.NewFlagSet("runs show", flag.ExitOnError)
+	jsonOutput := showFlags.Bool("json", false, "Print the run summary and its whole transcript as JSON")
+	expand := showFlags.String("expand", "", "Print the whole result of a tool call, by its ID")
+	extract := showFlags.String("extract", "", "Write the whole result of a tool call, by its ID, to the -o file")
+	outputPath := showFlags.String("o", "", "File --extract writes to, like result.csv")
+	showFlags.Parse(args)
+
+	// Flags are also read after the run ID
+	if showFlags.NArg() == 0 {
+		failf(exitcode.ClassUsage, "usage: runs show [run id] [--json | --expand tool-call-id | --extract tool-call-id -o file.csv]")
+	}
+	runID := showFlags.Arg(0)
+	showFlags.Parse(showFlags.Args()[1:])
+	if *extract != "" && *outputPath == "" {
+		failf(exitcode.ClassUsage, "--extract needs the -o file to write the result to")
+	}
+
+	if *jsonOutput {
+		summary, transcript, err := agent.LoadRun(outputDir, runID)
+		if err != nil {
+			fail(err)
+		}
+		printJSON(map[string]any{"run": summary, "transcript": transcript})
+		return
+	}
+
+	summary, reader, err := agent.OpenRun(outputDir, runID)
+	if err != nil {
+		fail(err)
+	}
+	defer reader.Close()
+
+	switch {
+	case *expand != "":
+		toolResult, err := reader.ToolResult(*expand)
+		if err != nil {
+			fail(exitcode.New(exitcode.ClassUsage, err))
+		}
+		fmt.Println(toolResult.Content)
+	case *extract != "":
+		extractToolResult(reader, *extract, *outputPath)
+	default:
+		printRun(summary, reader)
+	}
+}
+
+// Print the summary and conversation of a run, with tool results over runMessageChars cut to a preview
+func printRun(summary agent.RunSummary, reader *agent.TranscriptReader) {
+	transcript := reader.Transcript
+	fmt.Printf("Run '%s' %s\n", summary.RunID, summary.Status)
+	if summary.Error != "" {
+		fmt.Printf("Transcript: %s\n", summary.Error)
+	}
+	fmt.Printf("Started: %s  Duration: %s  Model: %s  Trace: %s\n", summary.StartedAt.Format(time.DateTime), runDuration(summary), summary.Model, transcript.TraceID)
+	fmt.Printf(
+		"Tokens: %d prompt, %d completion, %d completions  Cost (USD): %s\n",
+		summary.Usage.PromptTokens, summary.Usage.CompletionTokens, summary.Usage.Completions, runCost(summary),
+	)
+	fmt.Printf("Question: %s\n", summary.Question)
+
+	fmt.Println("\nConversation:")
+	for i := range reader.Messages {
+		message, err := reader.Message(i)
+		if err != nil {
+			fail(err)
+		}
+		role := message.Role
+		if message.ToolCallID != "" {
+			role += " " + message.ToolCallID
+		}
+		if message.Content != "" {
+			fmt.Printf("[%s] %s\n", role, indent(messagePreview(summary.RunID, message)))
+		}
+		for _, toolCall := range message.ToolCalls {
+			fmt.Printf("[%s] calls %s %s (%s)\n", role, toolCall.Name, toolCall.Arguments, toolCall.ID)
+		}
+	}
+
+	if len(transcript.Queries) != 0 {
+		fmt.Println("\nSQL queries:")
+	}
+	for i, query := range transcript.Queries {
+		status := "ok"
+		if query.Error != "" {
+			status = "error: " + query.Error
+		}
+		fmt.Printf(
+			"%d. %s source=%s rows=%d duration=%dms %s\n    %s\n",
+			i+1, query.Tool, query.Source, query.RowCount, query.DurationMs, status,
+			strings.Join(strings.Fields(query.SQL), " "),
+		)
+	}
+
+	if len(transcript.Artifacts) != 0 {
+		fmt.Printf("\nArtifacts: %s\n", strings.Join(transcript.Artifacts, ", "))
+	}
+	fmt.Printf("\nAnswer:\n%s\n", summary.Answer)
+}
+
+// Message content cut to runMessageChars, with its full size and, on tool results, how to see it whole
+func messagePreview(runID string, message agent.RunMessage) string {
+	preview := cutText(message.Content, runMessageChars)
+	if preview == message.Content {
+		return preview
+	}
+
+	size := fmt.Sprintf("%d bytes", len(message.Content))
+	if len(message.Content) >= 1<<20 {
+		size = agent.FormatMB(int64(len(message.Content)))
+	}
+	if message.ToolCallID == "" {
+		return fmt.Sprintf("%s\n(%s in full)", preview, size)
+	}
+	return fmt.Sprintf("%s\n(%s in full, see runs show %s --expand %s)", preview, size, runID, message.ToolCallID)
+}
+
+// Write the result of a tool call to outputPath: the lookup artifact its result_ref points to, with every row,
+// or the tool result itself
+func extractToolResult(reader *agent.TranscriptReader, toolCallID string, outputPath string) {
+	toolResult, err := reader.ToolResult(toolCallID)
+	if err != nil {
+		fail(exitcode.New(exitcode.ClassUsage, err))
+	}
+
+	content := []byte(toolResult.Content)
+	source := "tool result"
+	if artifactPath, ok := reader.ResultArtifact(toolResult); ok {
+		if content, err = os.ReadFile(artifactPath); err != nil {
+			fail(exitcode.New(exitcode.ClassData, err))
+		}
+		source = filepath.Base(artifactPath)
+	}
+
+	if err := os.WriteFile(outputPath, content, 0o644); err != nil {
+		fail(exitcode.New(exitcode.ClassData, fmt.Errorf("failed to write %s: %w", outputPath, err)))
+	}
+	fmt.Printf("Wrote the %s of '%s' to %s (%d bytes)\n", source, toolCallID, outputPath, len(content))
+}
+
+// Remove the runs started longer ago than the -older-than age
+func pruneRuns(outputDir string, args []string) {
+	pruneFlags := flag.NewFlagSet("runs prune", flag.ExitOnError)
+	olderThan := pruneFlags.String("older-than", "", "Age of the runs removed, like 30d, 2w or 36h")
+	pruneFlags.Parse(args)
+
+	if *olderThan == "" {
+		failf(exitcode.ClassUsage, "usage: runs prune --older-than [age]")
+	}
+	age, err := parseAge(*olderThan)
+	if err != nil {
+		failf(exitcode.ClassUsage, "invalid -older-than: %w", err)
+	}
+
+	pruned, err := tools.PruneRunsBefore(outputDir, time.Now().Add(-age))
+	if err != nil {
+		fail(err)
+	}
+	fmt.Printf("Pruned %d runs older than %s from %s\n", len(pruned), *olderThan, outputDir)
+}
+
+// Parse an age as a Go duration, also accepting days (30d) and weeks (2w)
+func parseAge(text string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if amount, found := strings.CutSuffix(text, suffix); found {
+			parsed, err := strconv.Atoi(amount)
+			if err != nil || parsed < 0 {
+				return 0, fmt.Errorf("'%s' isn't an age like 30d", text)
+			}
+			return time.Duration(parsed) * unit, nil
+		}
+	}
+
+	age, err := time.ParseDuration(text)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("'%s' isn't an age like 30d or 12h", text)
+	}
+
+	return age, nil
+}
+
+// Parse a date, an RFC 3339 time or an age ago into a time. Empty is the zero time
+func parseRunTime(text string) (time.Time, error) {
+	if text == "" {
+		return time.Time{}, nil
+	}
+	if date, err := time.ParseInLocation(time.DateOnly, text, time.Local); err == nil {
+		return date, nil
+	}
+	if moment, err := time.Parse(time.RFC3339, text); err == nil {
+		return moment, nil
+	}
+
+	age, err := parseAge(text)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("'%s' isn't a date, an RFC 3339 time or an age", text)
+	}
+
+	return time.Now().Add(-age), nil
+}
+
+// Duration of a run, to the second transcripts are timestamped with
+func runDuration(run agent.RunSummary) string {
+	if run.Error != "" && run.DurationMs <= 0 {
+		return "-"
+	}
+	if run.DurationMs < 1000 {
+		return "<1s"
+	}
+
+	return (time.Duration(run.DurationMs) * time.Millisecond).Round(time.Second).String()
+}
+
+func runCost(run agent.RunSummary) string {
+	cost := estimateCost(run.Model, run.Usage)
+	if cost == nil {
+		return "-"
+	}
+
+	return roundHalfEven(cost, costDecimals)
+}
+
+// Question or answer on a single line, cut to the preview length
+func runPreview(text string) string {
+	preview := cutText(strings.Join(strings.Fields(text), " "), runPreviewChars)
+	if preview == "" {
+		return "-"
+	}
+
+	return preview
+}
+
+// Cut text to limit characters, marking it as cut
+func cutText(text string, limit int) string {
+	if cut, truncated := llmdebug.Truncate(text, limit); truncated {
+		return cut + "..."
+	}
+
+	return text
+}
+
+// Indent the lines after the first one of a multiline text, so it reads under its role
+func indent(text string) string {
+	return strings.ReplaceAll(text, "\n", "\n    ")
+}
+
+func printJSON(value any) {
+	jsonBytes, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		fail(err)
+	}
+	fmt.Println(string(jsonBytes))
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/config"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+)
+
+/*
+-------------
+Schedule mode
+-------------
+*/
+
+// Directory under the output directory holding the schedule lock, state, manifests and default outputs
+const scheduleDirName = "schedule"
+
+// Files of the schedule directory
+const (
+	scheduleLockFile  = "schedule.lock"
+	scheduleStateFile = "state.json"
+	scheduleManifests = "manifests"
+)
+
+// Outcome of a scheduled report on a schedule run
+type scheduleEntry struct {
+	Name         string    `json:"name"`
+	Slot         time.Time `json:"slot"` // Time the report was due from
+	Status       string    `json:"status"`
+	Output       string    `json:"output,omitempty"`
+	PublishedURL string    `json:"published_url,omitempty"`
+	Recipients   []string  `json:"recipients,omitempty"`
+	RunID        string    `json:"run_id,omitempty"`
+	Duration     float64   `json:"duration"` // Seconds
+	Error        string    `json:"error,omitempty"`
+}
+
+// Statuses of scheduled reports on a manifest
+const (
+	scheduleStatusSucceeded = "succeeded"
+	scheduleStatusFailed    = "failed"
+)
+
+// Manifest written by each --run-due invocation
+type scheduleManifest struct {
+	Config     string          `json:"config"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	Succeeded  int             `json:"succeeded"`
+	Failed     int             `json:"failed"`
+	Reports    []scheduleEntry `json:"reports"`
+}
+
+// Handle the schedule subcommand: list the scheduled reports of the config with whether they are due,
+// or run the due ones with --run-due, from cron or a systemd timer. Exits with an error code when a report failed
+func runScheduleCommand(agentConfig agent.Config, args []string) {
+	scheduleFlags := flag.NewFlagSet("schedule", flag.ExitOnError)
+	runDue := scheduleFlags.Bool("run-due", false, "Run the reports due since their last success")
+	dryRun := scheduleFlags.Bool("dry-run", false, "List what --run-due would run without running it")
+	scheduleFlags.Parse(args)
+
+	if loadedConfig == nil || len(loadedConfig.Schedule) == 0 {
+		failf(exitcode.ClassConfig, "no scheduled reports, list them on the \"schedule\" section of a -config file")
+	}
+
+	scheduleDir := filepath.Join(agentConfig.OutputDir, scheduleDirName)
+	lastSuccess, err := readScheduleState(scheduleDir)
+	if err != nil {
+		fail(exitcode.Default(exitcode.ClassData, fmt.Errorf("failed to read schedule state: %w", err)))
+	}
+
+	now := time.Now()
+	due := []config.ScheduledReport{}
+	for _, report := range loadedConfig.Schedule {
+		last, ran := lastSuccess[report.Name]
+		status := "never ran"
+		if ran {
+			status = "last succeeded " + last.Local().Format(time.DateTime)
+		}
+
+		isDue := report.Due(last, now)
+		if isDue {
+			due = append(due, report)
+			status += ", due since " + report.LastSlot(now).Format(time.DateTime)
+		}
+		if !*runDue || *dryRun {
+			fmt.Printf("%s  every %s at %s, %s to %s\n    %s\n", report.Name, report.Every, report.At, report.Source(), scheduleOutputPath(scheduleDir, report, now), status)
+		}
+	}
+
+	if !*runDue {
+		return
+	}
+	if *dryRun {
+		fmt.Printf("%d of %d scheduled reports would run\n", len(due), len(loadedConfig.Schedule))
+		return
+	}
+
+	if err := os.MkdirAll(scheduleDir, 0o755); err != nil {
+		fail(err)
+	}
+	unlock, err := lockSchedule(scheduleDir)
+	if err != nil {
+		fail(err)
+	}
+	defer unlock()
+
+	if len(due) == 0 {
+		fmt.Println("No scheduled reports due")
+		return
+	}
+
+	manifest := runDueReports(agentConfig, scheduleDir, due, lastSuccess, now)
+	manifestPath, err := writeScheduleManifest(scheduleDir, manifest)
+	if err != nil {
+		log.Printf("WARNING: Failed to write schedule manifest: %s\n", err)
+	}
+
+	fmt.Printf("Schedule run finished: %d succeeded, %d failed. Manifest at %s\n", manifest.Succeeded, manifest.Failed, manifestPath)
+	if manifest.Failed != 0 {
+		unlock()
+		os.Exit(1)
+	}
+}
+
+// Run the due reports one after another on a single agent. A failed report doesn't stop the others,
+// it stays due and runs again on the next invocation
+func runDueReports(
+	agentConfig agent.Config,
+	scheduleDir string,
+	due []config.ScheduledReport,
+	lastSuccess map[string]time.Time,
+	now time.Time,
+) scheduleManifest {
+	manifest := scheduleManifest{Config: loadedConfig.Path, StartedAt: now}
+
+	tracer, err := newPhoenixTracer()
+	if err != nil {
+		fail(err)
+	}
+	agentConfig.Tracer = tracer
+
+	runAgent, err := agent.New(agentConfig)
+	if err != nil {
+		fail(err)
+	}
+
+	// An interrupt cancels the running report and fails the ones left, they run on the next invocation
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	for _, report := range due {
+		entry := runScheduledReport(ctx, runAgent, scheduleDir, report, now)
+		if entry.Status == scheduleStatusSucceeded {
+			manifest.Succeeded++
+			lastSuccess[report.Name] = now
+		} else {
+			manifest.Failed++
+		}
+		manifest.Reports = append(manifest.Reports, entry)
+		fmt.Fprintf(os.Stderr, "Schedule: '%s' %s in %.1fs\n", report.Name, entry.Status, entry.Duration)
+
+		// Saved after each report, so a crash doesn't run the finished ones again
+		if err := writeScheduleState(scheduleDir, lastSuccess); err != nil {
+			log.Printf("WARNING: Failed to write schedule state: %s\n", err)
+		}
+	}
+
+	shutdown(runAgent, tracer, agent.RunResult{}, false)
+	manifest.FinishedAt = time.Now()
+	return manifest
+}
+
+// Run a scheduled report and write its dated output. Panics are recovered as failures
+func runScheduledReport(
+	ctx context.Context,
+	runAgent *agent.Agent,
+	scheduleDir string,
+	report config.ScheduledReport,
+	now time.Time,
+) (entry scheduleEntry) {
+	entry = scheduleEntry{Name: report.Name, Slot: report.LastSlot(now), Status: scheduleStatusFailed, Recipients: report.Recipients}
+	start := time.Now()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			entry.Status = scheduleStatusFailed
+			entry.Error = fmt.Sprintf("report panicked: %v", recovered)
+		}
+		entry.Duration = time.Since(start).Seconds()
+	}()
+
+	if ctx.Err() != nil {
+		entry.Error = "schedule run interrupted"
+		return entry
+	}
+
+	var output any
+	markdown := ""
+	if report.Report != "" {
+		reportRun, err := runAgent.RunReport(ctx, report.Report)
+		entry.RunID = reportRun.RunID
+		if err != nil {
+			entry.Error = err.Error()
+			return entry
+		}
+
+		output = reportRun
+		markdown = fmt.Sprintf(
+			"%d rows\n\n- Data: %s\n- Chart code: %s\n",
+			len(reportRun.Rows), filepath.Join(reportRun.RunDir, reportRun.DataPath), filepath.Join(reportRun.RunDir, reportRun.ArtifactPath),
+		)
+	} else {
+		// Questions go through the batch machinery, transcripts and panic recovery included
+		result := runBatchQuestion(ctx, runAgent, batchQuestion{ID: report.Name, Question: report.Question}, 0)
+		entry.RunID = result.RunID
+		if result.Error != "" {
+			entry.Error = result.Error
+			return entry
+		}
+
+		output = result
+		markdown = result.Answer + "\n"
+	}
+
+	outputPath := scheduleOutputPath(scheduleDir, report, now)
+	content := []byte(fmt.Sprintf("# %s\n\n%s, run %s on %s\n\n%s", report.Name, report.Source(), entry.RunID, now.Format(time.DateTime), markdown))
+	if report.Format == config.ScheduleFormatJSON {
+		var err error
+		if content, err = json.MarshalIndent(output, "", "  "); err != nil {
+			entry.Error = err.Error()
+			return entry
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0o755); err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+	if err := os.WriteFile(outputPath, content, 0o644); err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+	entry.Output = outputPath
+
+	if report.Publish {
+		publishedURL, err := runAgent.PublishFile(ctx, outputPath)
+		if err != nil {
+			entry.Error = fmt.Sprintf("failed to publish output: %s", err)
+			return entry
+		}
+		entry.PublishedURL = publishedURL
+	}
+	if len(report.Recipients) != 0 {
+		log.Printf("Report '%s' is meant for %s, delivery isn't supported yet\n", report.Name, strings.Join(report.Recipients, ", "))
+	}
+
+	entry.Status = scheduleStatusSucceeded
+	return entry
+}
+
+// Dated output file of a report, like <destination>/weekly-sales/weekly-sales-2024-01-08.md
+func scheduleOutputPath(scheduleDir string, report config.ScheduledReport, now time.Time) string {
+	dir := filepath.Join(scheduleDir, report.Name)
+	if report.Destination != "" {
+		dir = filepath.Join(projectPath(report.Destination), report.Name)
+	}
+
+	extension := ".md"
+	if report.Format == config.ScheduleFormatJSON {
+		extension = ".json"
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s-%s%s", report.Name, now.Format(time.DateOnly), extension))
+}
+
+// Take the schedule lock so overlapping invocations don't run the same reports. Locks left by a process
+// that is gone are taken over. Returns the func releasing the lock, safe to call twice
+func lockSchedule(scheduleDir string) (func(), error) {
+	lockPath := filepath.Join(scheduleDir, scheduleLockFile)
+	for attempt := 0; ; attempt++ {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			fmt.Fprintf(file, "%d\n", os.Getpid())
+			file.Close()
+
+			released := false
+			return func() {
+				if !released {
+					released = true
+					os.Remove(lockPath)
+				}
+			}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to take schedule lock: %w", err)
+		}
+
+		pidBytes, _ := os.ReadFile(lockPath)
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(pidBytes)))
+		if attempt != 0 || processAlive(pid) {
+			return nil, fmt.Errorf("another schedule run holds %s (pid %d)", lockPath, pid)
+		}
+
+		log.Printf("WARNING: Taking over the schedule lock of pid %d, which is gone\n", pid)
+		if err := os.Remove(lockPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale schedule lock: %w", err)
+		}
+	}
+}
+
+// Whether a process with the pid is running. Unknown pids are assumed running, so a lock is never stolen by mistake
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return true
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+// Last success of each scheduled report. A missing state has none
+func readScheduleState(scheduleDir string) (map[string]time.Time, error) {
+	lastSuccess := map[string]time.Time{}
+	jsonBytes, err := os.ReadFile(filepath.Join(scheduleDir, scheduleStateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return lastSuccess, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return lastSuccess, json.Unmarshal(jsonBytes, &lastSuccess)
+}
+
+// Replace the schedule state, through a temporary file so a crash never leaves it half written
+func writeScheduleState(scheduleDir string, lastSuccess map[string]time.Time) error {
+	jsonBytes, err := json.MarshalIndent(lastSuccess, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	statePath := filepath.Join(scheduleDir, scheduleStateFile)
+	if err := os.WriteFile(statePath+".tmp", jsonBytes, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(statePath+".tmp", statePath)
+}
+
+// Write the manifest of a schedule run, named after its start, returning its path
+func writeScheduleManifest(scheduleDir string, manifest scheduleManifest) (string, error) {
+	jsonBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	manifestPath := filepath.Join(scheduleDir, scheduleManifests, manifest.StartedAt.Format("20060102T150405")+".json")
+	if err := os.MkdirAll(filepath.Dir(manifestPath), 0o755); err != nil {
+		return "", err
+	}
+	return manifestPath, os.WriteFile(manifestPath, jsonBytes, 0o644)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/server"
+)
+
+/*
+----------
+Serve mode
+----------
+*/
+
+// Time in flight requests get to finish once the server is told to stop
+const serveShutdownTimeout = 30 * time.Second
+
+// Handle the serve subcommand: answer runs over HTTP until interrupted, see the server package
+func runServeCommand(config agent.Config, args []string) {
+	serveFlags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := serveFlags.String("addr", ":8080", "Address the server listens on")
+	maxInFlight := serveFlags.Int("max-in-flight", 0, "Runs served at once, the requests over it are rejected with 429. 0 never rejects")
+	requestTimeout := serveFlags.Duration("request-timeout", 0, "Deadline of each run, runs near it skip their optional steps. 0 sets none")
+	serveFlags.Parse(args)
+	if serveFlags.NArg() != 0 {
+		failf(exitcode.ClassUsage, "unexpected serve arguments: %v", serveFlags.Args())
+	}
+
+	// Every request would fail without a key, the server refuses to start unless it replays a fixture
+	if *fixture == "" {
+		if err := completion.RequireAPIKey(); err != nil {
+			fail(err)
+		}
+	}
+
+	tracer, err := newPhoenixTracer()
+	if err != nil {
+		fail(err)
+	}
+	config.Tracer = tracer
+
+	runAgent, err := agent.New(config)
+	if err != nil {
+		fail(err)
+	}
+	defer shutdown(runAgent, tracer, agent.RunResult{}, false)
+
+	// The server answers /readyz with 503 until the warm up finishes
+	go runAgent.Warmup(context.Background(), true)
+
+	httpServer := &http.Server{Addr: *addr, Handler: server.New(runAgent, server.Options{
+		KeepRuns:     *keepRuns,
+		LoadShedding: agent.LoadShedding{MaxInFlight: *maxInFlight, RequestTimeout: *requestTimeout},
+	})}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Println("Interrupt received, stopping the server")
+		ctx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Printf("WARNING: Server didn't stop cleanly: %s\n", err)
+		}
+	}()
+
+	log.Printf("Serving runs on %s\n", *addr)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fail(exitcode.New(exitcode.ClassConfig, err))
+	}
+}
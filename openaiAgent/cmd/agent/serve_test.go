@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+)
+
+// The server starts with an API key or in mock mode, and refuses to start without a key otherwise
+func TestServeRequiresAPIKey(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the agent binary")
+	}
+
+	cases := []struct {
+		name    string
+		args    []string
+		key     string
+		serving bool // Starts serving, otherwise exits with the configuration code
+	}{
+		{name: "api key set", key: "sk-check", serving: true},
+		{name: "missing api key", key: ""},
+		{name: "blank api key", key: "  "},
+		{name: "mock mode without api key", args: []string{"-fixture", "testdata/fixtures/single_lookup.json"}, key: "", serving: true},
+	}
+
+	t.Setenv(tracing.PhoenixEndpointEnv, "http://127.0.0.1:1")
+	t.Setenv(tracing.PhoenixHeadersEnv, "api_key=test")
+
+	executable := buildAgent(t)
+	root, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Setenv(completion.APIKeyEnv, c.key)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			args := append([]string{"-workspace", root, "-error-format", exitcode.FormatJSON, "-output-dir", t.TempDir()}, c.args...)
+			command := exec.CommandContext(ctx, executable, append(args, "serve", "--addr", freeAddr(t))...)
+			command.Dir = root
+			stderr, err := command.StderrPipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := command.Start(); err != nil {
+				t.Fatal(err)
+			}
+
+			lastLine := ""
+			serving := false
+			scanner := bufio.NewScanner(stderr)
+			for scanner.Scan() {
+				lastLine = scanner.Text()
+				if !serving && strings.Contains(lastLine, "Serving runs on") {
+					command.Process.Signal(os.Interrupt)
+					serving = true
+				}
+			}
+			command.Wait()
+
+			if serving != c.serving {
+				t.Fatalf("serving is %t, expected %t. Last error line: %s", serving, c.serving, lastLine)
+			}
+			if c.serving {
+				if code := command.ProcessState.ExitCode(); code != 0 {
+					t.Fatalf("exited with %d once interrupted. Last error line: %s", code, lastLine)
+				}
+				return
+			}
+
+			report := exitcode.Report{}
+			if err := json.Unmarshal([]byte(lastLine), &report); err != nil {
+				t.Fatalf("last error line isn't a JSON error: %s", lastLine)
+			}
+			if code := command.ProcessState.ExitCode(); code != exitcode.ClassConfig.Code() || report.Class != exitcode.ClassConfig {
+				t.Fatalf("exited with %d and printed %s, expected the configuration code", code, lastLine)
+			}
+			if !strings.Contains(report.Message, completion.APIKeyEnv) {
+				t.Fatalf("expected the error to name %s, got %s", completion.APIKeyEnv, report.Message)
+			}
+		})
+	}
+}
+
+// Local address nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/config"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+)
+
+/*
+--------------
+Workspace mode
+--------------
+*/
+
+// Handle the workspace subcommand: print the workspace in use and where its root came from
+func runWorkspaceCommand(args []string) {
+	if len(args) != 0 {
+		failf(exitcode.ClassUsage, "unknown workspace subcommand '%s'. Expected none", args[0])
+	}
+
+	workspace := config.CurrentWorkspace()
+	fmt.Printf("Workspace %s, found from %s\n", workspace.Root, workspace.Source)
+}
@@ -0,0 +1,103 @@
+package completion
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/llmdebug"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+/*
+--------------------
+Shared OpenAI client
+--------------------
+*/
+
+// Environment variable holding the OpenAI API key, read by the client
+const APIKeyEnv = "OPENAI_API_KEY"
+
+// Environment variables scoping requests to an organization and project, sent as headers.
+// The SDK also reads OPENAI_PROJECT_ID, used when OPENAI_PROJECT isn't set
+const (
+	OrgIDEnv   = "OPENAI_ORG_ID"
+	ProjectEnv = "OPENAI_PROJECT"
+)
+
+// No API key is configured, every request would fail
+var ErrMissingAPIKey = errors.New(APIKeyEnv + " is not set")
+
+// What to do about a missing API key, told along with the error. Binaries replace it with their own
+// ways to set the key or to run without one
+var MissingAPIKeyHint = fmt.Sprintf("export %s before starting", APIKeyEnv)
+
+// The API key is unset or blank. Wraps ErrMissingAPIKey, it's a configuration failure
+type ErrAPIKeyRequired struct {
+	Hint string
+}
+
+func (e *ErrAPIKeyRequired) Error() string {
+	if e.Hint == "" {
+		return ErrMissingAPIKey.Error()
+	}
+	return fmt.Sprintf("%s: %s", ErrMissingAPIKey, e.Hint)
+}
+func (e *ErrAPIKeyRequired) Unwrap() error             { return ErrMissingAPIKey }
+func (e *ErrAPIKeyRequired) ExitClass() exitcode.Class { return exitcode.ClassConfig }
+
+// Check an API key is set, without calling the API. Returns ErrAPIKeyRequired with MissingAPIKeyHint otherwise
+func RequireAPIKey() error {
+	if strings.TrimSpace(os.Getenv(APIKeyEnv)) == "" {
+		return &ErrAPIKeyRequired{Hint: MissingAPIKeyHint}
+	}
+	return nil
+}
+
+// Created on the first GetOpenaiClient call with the transport settings of the environment, safe for concurrent callers
+var sharedClient = sync.OnceValues(func() (*openai.Client, error) {
+	settings, err := HTTPSettingsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewOpenaiClient(settings)
+})
+
+// Create an OpenAI client configured from the environment, with its connections tuned by settings.
+// Prefer GetOpenaiClient, so every caller of the process shares its connection pool
+func NewOpenaiClient(settings HTTPSettings) (*openai.Client, error) {
+	if err := RequireAPIKey(); err != nil {
+		return nil, err
+	}
+
+	log.Println("Creating new OpenAI client")
+	options := append(scopeOptions(), option.WithHTTPClient(NewHTTPClient(settings)))
+	return openai.NewClient(append(options, llmdebug.Options()...)...), nil
+}
+
+// Organization and project headers set on the environment, for project scoped keys
+func scopeOptions() []option.RequestOption {
+	options := []option.RequestOption{}
+	if organization := os.Getenv(OrgIDEnv); organization != "" {
+		log.Printf("Scoping OpenAI requests to organization '%s'\n", organization)
+		options = append(options, option.WithOrganization(organization))
+	}
+	if project := os.Getenv(ProjectEnv); project != "" {
+		log.Printf("Scoping OpenAI requests to project '%s'\n", project)
+		options = append(options, option.WithProject(project))
+	}
+
+	return options
+}
+
+// Get the OpenAI client configured from the environment, shared by every caller.
+// Returns ErrAPIKeyRequired when no API key is set instead of failing on the first request
+func GetOpenaiClient() (*openai.Client, error) {
+	return sharedClient()
+}
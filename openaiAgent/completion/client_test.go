@@ -0,0 +1,38 @@
+package completion
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/openai/openai-go"
+)
+
+// Callers racing for the shared client all get the same one, created once. Run with -race
+func TestGetOpenaiClientConcurrently(t *testing.T) {
+	t.Setenv(APIKeyEnv, "sk-test")
+
+	const callers = 64
+	clients := make([]*openai.Client, callers)
+	errs := make([]error, callers)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			clients[i], errs[i] = GetOpenaiClient()
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for i := range callers {
+		if errs[i] != nil {
+			t.Fatalf("caller %d failed: %s", i, errs[i])
+		}
+		if clients[i] == nil || clients[i] != clients[0] {
+			t.Fatalf("caller %d got another client", i)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Package completion validates chat completion responses, so empty choices, refusals and
+// filtered content turn into typed errors instead of empty strings or index panics.
+// It also holds the OpenAI client shared by the agent and chat binaries.
+package completion
+
+import (
+	"fmt"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/openai/openai-go"
+)
+
+/*
+--------------------------
+Completion response errors
+--------------------------
+*/
+
+// Error classes of invalid responses. Matches the tools error class naming
+const (
+	ErrorClassNoChoices     = "no_choices"
+	ErrorClassModelRefusal  = "model_refusal"
+	ErrorClassContentFilter = "content_filter"
+	ErrorClassEmptyMessage  = "empty_message"
+)
+
+// The response had no choices
+type ErrNoChoices struct{}
+
+func (e *ErrNoChoices) Error() string             { return "chat completion returned no choices" }
+func (e *ErrNoChoices) Class() string             { return ErrorClassNoChoices }
+func (e *ErrNoChoices) IsRetryable() bool         { return false }
+func (e *ErrNoChoices) ExitClass() exitcode.Class { return exitcode.ClassUpstream }
+
+// The model refused to answer. Message holds the refusal text
+type ErrModelRefusal struct {
+	Message string
+}
+
+func (e *ErrModelRefusal) Error() string             { return fmt.Sprintf("model refused: %s", e.Message) }
+func (e *ErrModelRefusal) Class() string             { return ErrorClassModelRefusal }
+func (e *ErrModelRefusal) IsRetryable() bool         { return false }
+func (e *ErrModelRefusal) ExitClass() exitcode.Class { return exitcode.ClassUpstream }
+
+// The response was stopped by the content filter
+type ErrContentFilter struct{}
+
+func (e *ErrContentFilter) Error() string             { return "chat completion was stopped by the content filter" }
+func (e *ErrContentFilter) Class() string             { return ErrorClassContentFilter }
+func (e *ErrContentFilter) IsRetryable() bool         { return false }
+func (e *ErrContentFilter) ExitClass() exitcode.Class { return exitcode.ClassUpstream }
+
+// The message had neither content nor tool calls, so it neither answers nor routes
+type ErrEmptyMessage struct{}
+
+func (e *ErrEmptyMessage) Error() string             { return "chat completion had no content nor tool calls" }
+func (e *ErrEmptyMessage) Class() string             { return ErrorClassEmptyMessage }
+func (e *ErrEmptyMessage) IsRetryable() bool         { return false }
+func (e *ErrEmptyMessage) ExitClass() exitcode.Class { return exitcode.ClassUpstream }
+
+// Check a chat completion response and return its first choice message.
+// Returns ErrNoChoices, ErrModelRefusal or ErrContentFilter for responses without a usable message
+func Message(response *openai.ChatCompletion) (openai.ChatCompletionMessage, error) {
+	if response == nil || len(response.Choices) == 0 {
+		return openai.ChatCompletionMessage{}, &ErrNoChoices{}
+	}
+
+	choice := response.Choices[0]
+	if choice.Message.Refusal != "" {
+		return choice.Message, &ErrModelRefusal{Message: choice.Message.Refusal}
+	}
+
+	if choice.FinishReason == openai.ChatCompletionChoicesFinishReasonContentFilter {
+		return choice.Message, &ErrContentFilter{}
+	}
+
+	return choice.Message, nil
+}
@@ -0,0 +1,95 @@
+package completion
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/openai/openai-go"
+)
+
+// Response with a single choice
+func choiceResponse(message openai.ChatCompletionMessage, finishReason openai.ChatCompletionChoicesFinishReason) *openai.ChatCompletion {
+	return &openai.ChatCompletion{Choices: []openai.ChatCompletionChoice{{Message: message, FinishReason: finishReason}}}
+}
+
+// Responses without a usable message fail with a typed, non retryable error, the others return their first choice
+func TestMessage(t *testing.T) {
+	toolCall := openai.ChatCompletionMessageToolCall{ID: "call_1", Function: openai.ChatCompletionMessageToolCallFunction{Name: "LookUpSalesData"}}
+	cases := []struct {
+		name     string
+		response *openai.ChatCompletion
+		content  string // Content of the returned message
+		errClass string // Class of the error, empty when the message is usable
+	}{
+		{name: "nil response", errClass: ErrorClassNoChoices},
+		{name: "no choices", response: &openai.ChatCompletion{}, errClass: ErrorClassNoChoices},
+		{
+			name:     "refusal",
+			response: choiceResponse(openai.ChatCompletionMessage{Refusal: "I can't help with that."}, openai.ChatCompletionChoicesFinishReasonStop),
+			errClass: ErrorClassModelRefusal,
+		},
+		{
+			name:     "content filter",
+			response: choiceResponse(openai.ChatCompletionMessage{Content: "Partial ans"}, openai.ChatCompletionChoicesFinishReasonContentFilter),
+			content:  "Partial ans",
+			errClass: ErrorClassContentFilter,
+		},
+		{
+			name:     "answer",
+			response: choiceResponse(openai.ChatCompletionMessage{Content: "Total sales were 66.75."}, openai.ChatCompletionChoicesFinishReasonStop),
+			content:  "Total sales were 66.75.",
+		},
+		{
+			name: "tool calls",
+			response: choiceResponse(
+				openai.ChatCompletionMessage{ToolCalls: []openai.ChatCompletionMessageToolCall{toolCall}},
+				openai.ChatCompletionChoicesFinishReasonToolCalls,
+			),
+		},
+		{
+			name: "first choice",
+			response: &openai.ChatCompletion{Choices: []openai.ChatCompletionChoice{
+				{Message: openai.ChatCompletionMessage{Content: "First"}},
+				{Message: openai.ChatCompletionMessage{Refusal: "Second"}},
+			}},
+			content: "First",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			message, err := Message(c.response)
+			if message.Content != c.content {
+				t.Errorf("message content '%s', expected '%s'", message.Content, c.content)
+			}
+			if c.errClass == "" {
+				if err != nil {
+					t.Fatalf("expected a usable message, got: %s", err)
+				}
+				return
+			}
+
+			classified, ok := err.(interface {
+				Class() string
+				IsRetryable() bool
+			})
+			if !ok || classified.Class() != c.errClass {
+				t.Fatalf("expected a %s error, got %v", c.errClass, err)
+			}
+			if classified.IsRetryable() {
+				t.Errorf("%s errors must not be retried", c.errClass)
+			}
+			if class := exitcode.Classify(err); class != exitcode.ClassUpstream {
+				t.Errorf("%s errors exit as %s, expected %s", c.errClass, class, exitcode.ClassUpstream)
+			}
+		})
+	}
+
+	// The refusal text is surfaced on the error
+	_, err := Message(choiceResponse(openai.ChatCompletionMessage{Refusal: "I can't help with that."}, ""))
+	var refusal *ErrModelRefusal
+	if !errors.As(err, &refusal) || refusal.Message != "I can't help with that." {
+		t.Errorf("expected the refusal text on the error, got %v", err)
+	}
+}
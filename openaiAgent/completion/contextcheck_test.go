@@ -0,0 +1,114 @@
+package completion
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Roots of the Go code making completions, the agent module and the chat binary
+var contextCheckDirs = []string{"..", "../../openaiChat"}
+
+// Directories without Go code of the binaries
+var skippedDirs = map[string]bool{"bin": true, "testdata": true, "vendor": true, "runs": true}
+
+// Completions made with context.TODO() can't be cancelled, have no deadline and lose the trace of their caller,
+// so every call path must take a context instead
+func TestNoContextTODO(t *testing.T) {
+	fileSet := token.NewFileSet()
+	for _, dir := range contextCheckDirs {
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				if path != dir && (skippedDirs[entry.Name()] || strings.HasPrefix(entry.Name(), ".")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !strings.HasSuffix(path, ".go") {
+				return nil
+			}
+
+			file, err := parser.ParseFile(fileSet, path, nil, parser.SkipObjectResolution)
+			if err != nil {
+				return err
+			}
+			for _, position := range todoCalls(fileSet, file) {
+				t.Errorf("%s: context.TODO() call, take a context from the caller instead", position)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTodoCalls(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+		calls  int
+	}{
+		{name: "call", source: "import \"context\"\nvar ctx = context.TODO()", calls: 1},
+		{name: "renamed import", source: "import stdctx \"context\"\nvar ctx = stdctx.TODO()", calls: 1},
+		{name: "background", source: "import \"context\"\nvar ctx = context.Background()"},
+		{name: "other package", source: "import \"context\"\nvar ctx = plan.TODO()\nvar _ = context.Canceled"},
+		{name: "no import", source: "var ctx = context.TODO()"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fileSet := token.NewFileSet()
+			file, err := parser.ParseFile(fileSet, "source.go", "package source\n"+c.source, parser.SkipObjectResolution)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if calls := todoCalls(fileSet, file); len(calls) != c.calls {
+				t.Fatalf("expected %d context.TODO() calls, got %v", c.calls, calls)
+			}
+		})
+	}
+}
+
+// Positions of the context.TODO() calls of a file, under whatever name it imports context as
+func todoCalls(fileSet *token.FileSet, file *ast.File) []token.Position {
+	contextName := ""
+	for _, spec := range file.Imports {
+		if importPath, _ := strconv.Unquote(spec.Path.Value); importPath != "context" {
+			continue
+		}
+		contextName = "context"
+		if spec.Name != nil {
+			contextName = spec.Name.Name
+		}
+	}
+	if contextName == "" || contextName == "_" {
+		return nil
+	}
+
+	positions := []token.Position{}
+	ast.Inspect(file, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		selector, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || selector.Sel.Name != "TODO" {
+			return true
+		}
+		if pkg, ok := selector.X.(*ast.Ident); ok && pkg.Name == contextName {
+			positions = append(positions, fileSet.Position(call.Pos()))
+		}
+		return true
+	})
+
+	return positions
+}
@@ -0,0 +1,53 @@
+package completion
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+/*
+--------------------
+End user attribution
+--------------------
+*/
+
+// Environment variable holding the end user completions are attributed to, when none is given otherwise
+const EndUserEnv = "OPENAI_END_USER"
+
+// Identifiers that look like email addresses
+var emailRegex = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// Hex characters kept of the hash of a redacted identifier
+const endUserHashLength = 16
+
+// Identifier of an end user as sent on the user field of completions and recorded on spans and audit logs.
+// Emails are replaced by a stable hash, so the same user is still told apart without exposing the address
+func EndUserID(user string) string {
+	user = strings.TrimSpace(user)
+	if !emailRegex.MatchString(user) {
+		return user
+	}
+
+	sum := sha256.Sum256([]byte(strings.ToLower(user)))
+	return "user-" + hex.EncodeToString(sum[:])[:endUserHashLength]
+}
+
+type endUserKey struct{}
+
+// Attribute the completions made with ctx to an end user. Empty users leave ctx as it is
+func WithEndUser(ctx context.Context, user string) context.Context {
+	if user = EndUserID(user); user == "" {
+		return ctx
+	}
+
+	return context.WithValue(ctx, endUserKey{}, user)
+}
+
+// End user the completions made with ctx are attributed to, already redacted. Empty when there is none
+func EndUserFrom(ctx context.Context) string {
+	user, _ := ctx.Value(endUserKey{}).(string)
+	return user
+}
@@ -0,0 +1,149 @@
+package completion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+/*
+--------------------
+JSON response repair
+--------------------
+*/
+
+// Stage of the repair pipeline that produced a JSON response, or failed to
+const (
+	JSONStageValid     = "valid"     // The response was valid JSON as it came
+	JSONStageExtracted = "extracted" // The largest balanced JSON block of the response was valid
+	JSONStageRepaired  = "repaired"  // A repair request returned valid JSON
+	JSONStageFailed    = "failed"    // Every stage failed, the raw response is kept
+)
+
+// Instruction of the repair request sent with a broken JSON response
+const JSONRepairPrompt = "The following text should be JSON but doesn't parse. Return only the corrected JSON, without commentary or code fences."
+
+// Ask the model to correct a broken JSON response, returning its new response
+type JSONRepairFunc func(ctx context.Context, broken string) (string, error)
+
+// Outcome of decoding a JSON mode response
+type JSONOutcome struct {
+	Text    string // The valid JSON, or the raw response when Stage is JSONStageFailed
+	Stage   string // Stage that produced Text, one of the JSONStage constants
+	Repairs int    // Repair requests made
+	Err     error  // Why the response couldn't be decoded, set when Stage is JSONStageFailed
+}
+
+// Decode a JSON mode response: take it as is when it's valid, else the largest balanced block it holds,
+// else ask repair to correct it up to maxRepairs times. A nil repair or 0 maxRepairs skips the repair requests
+func DecodeJSONResponse(ctx context.Context, response string, repair JSONRepairFunc, maxRepairs int) JSONOutcome {
+	parseErr := checkJSON(response)
+	if parseErr == nil {
+		return JSONOutcome{Text: response, Stage: JSONStageValid}
+	}
+	if extracted, ok := ExtractJSON(response); ok {
+		return JSONOutcome{Text: extracted, Stage: JSONStageExtracted}
+	}
+
+	outcome := JSONOutcome{Text: response, Stage: JSONStageFailed, Err: parseErr}
+	broken := response
+	for outcome.Repairs < maxRepairs && repair != nil {
+		outcome.Repairs++
+		repaired, err := repair(ctx, broken)
+		if err != nil {
+			outcome.Err = fmt.Errorf("repair request failed: %w", err)
+			return outcome
+		}
+		if checkJSON(repaired) != nil {
+			if extracted, ok := ExtractJSON(repaired); ok {
+				repaired = extracted
+			}
+		}
+		if checkJSON(repaired) == nil {
+			outcome.Text, outcome.Stage, outcome.Err = repaired, JSONStageRepaired, nil
+			return outcome
+		}
+		broken = repaired
+	}
+
+	return outcome
+}
+
+// Parse error of a text that should be a single JSON value, nil when it is one
+func checkJSON(text string) error {
+	var value any
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	return nil
+}
+
+// Largest balanced {...} or [...] block of a text that is valid JSON, like the object of a response
+// wrapped in commentary or code fences. Brackets inside JSON strings don't count towards the balance
+func ExtractJSON(text string) (string, bool) {
+	blocks := balancedBlocks(text)
+	slices.SortStableFunc(blocks, func(a string, b string) int { return len(b) - len(a) })
+	for _, block := range blocks {
+		if checkJSON(block) == nil {
+			return block, true
+		}
+	}
+
+	return "", false
+}
+
+// Every balanced bracket block of a text, starting at each opening bracket
+func balancedBlocks(text string) []string {
+	blocks := []string{}
+	for start := range len(text) {
+		if text[start] != '{' && text[start] != '[' {
+			continue
+		}
+		if end := blockEnd(text, start); end > start {
+			blocks = append(blocks, text[start:end+1])
+		}
+	}
+
+	return blocks
+}
+
+// Index of the bracket closing the one at start, -1 when it's never closed or closed by the wrong kind
+func blockEnd(text string, start int) int {
+	closers := []byte{}
+	inString, escaped := false, false
+	for i := start; i < len(text); i++ {
+		char := text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case char == '\\':
+				escaped = true
+			case char == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch char {
+		case '"':
+			inString = true
+		case '{':
+			closers = append(closers, '}')
+		case '[':
+			closers = append(closers, ']')
+		case '}', ']':
+			if closers[len(closers)-1] != char {
+				return -1
+			}
+			closers = closers[:len(closers)-1]
+			if len(closers) == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
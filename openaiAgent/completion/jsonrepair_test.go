@@ -0,0 +1,128 @@
+package completion
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExtractJSON(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		want string // Empty when nothing should be extracted
+	}{
+		{name: "code fence", text: "```json\n{\"a\": 1}\n```", want: `{"a": 1}`},
+		{name: "commentary", text: `Here you go: {"a": [1, 2]} hope it helps`, want: `{"a": [1, 2]}`},
+		{name: "array", text: "Results: [1, 2, 3].", want: "[1, 2, 3]"},
+		{name: "largest block", text: `{"a": 1} and {"b": {"c": 2}}`, want: `{"b": {"c": 2}}`},
+		{name: "brackets in strings", text: `Sure {"a": "}{ ]["} done`, want: `{"a": "}{ ]["}`},
+		{name: "escaped quotes", text: `x {"a": "say \"}\""} y`, want: `{"a": "say \"}\""}`},
+		{name: "invalid largest block", text: `{"a": 1, oops} {"b": 2}`, want: `{"b": 2}`},
+		{name: "mismatched brackets", text: `{"a": [1, 2}`},
+		{name: "unclosed", text: `{"a": 1`},
+		{name: "no JSON", text: "No data was found."},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, ok := ExtractJSON(c.text)
+			if ok != (c.want != "") || got != c.want {
+				t.Fatalf("expected '%s', got '%s' (%t)", c.want, got, ok)
+			}
+		})
+	}
+}
+
+// Repair function answering with the responses in order, recording the texts it was asked to repair
+func scriptedRepair(responses ...string) (JSONRepairFunc, *[]string) {
+	broken := []string{}
+	return func(ctx context.Context, text string) (string, error) {
+		broken = append(broken, text)
+		if len(broken) > len(responses) {
+			return "", errors.New("no more responses")
+		}
+		return responses[len(broken)-1], nil
+	}, &broken
+}
+
+func TestDecodeJSONResponse(t *testing.T) {
+	cases := []struct {
+		name       string
+		response   string
+		repairs    []string // Responses of the repair requests
+		maxRepairs int
+		want       JSONOutcome
+	}{
+		{name: "valid", response: `{"a": 1}`, maxRepairs: 2, want: JSONOutcome{Text: `{"a": 1}`, Stage: JSONStageValid}},
+		{name: "extracted", response: "```json\n[1, 2]\n```", maxRepairs: 2, want: JSONOutcome{Text: "[1, 2]", Stage: JSONStageExtracted}},
+		{
+			name:       "repaired",
+			response:   `{"a": 1,}`,
+			repairs:    []string{`{"a": 1}`},
+			maxRepairs: 2,
+			want:       JSONOutcome{Text: `{"a": 1}`, Stage: JSONStageRepaired, Repairs: 1},
+		},
+		{
+			name:       "repaired after extracting",
+			response:   `{'a': 1}`,
+			repairs:    []string{"Fixed it:\n```json\n{\"a\": 1}\n```"},
+			maxRepairs: 1,
+			want:       JSONOutcome{Text: `{"a": 1}`, Stage: JSONStageRepaired, Repairs: 1},
+		},
+		{
+			name:       "second repair",
+			response:   `{a: 1}`,
+			repairs:    []string{`{"a": 1`, `{"a": 1}`},
+			maxRepairs: 2,
+			want:       JSONOutcome{Text: `{"a": 1}`, Stage: JSONStageRepaired, Repairs: 2},
+		},
+		{
+			name:       "repairs exhausted",
+			response:   `{a: 1}`,
+			repairs:    []string{`{a: 1`, `{"a" 1}`},
+			maxRepairs: 2,
+			want:       JSONOutcome{Text: `{a: 1}`, Stage: JSONStageFailed, Repairs: 2},
+		},
+		{name: "repairs disabled", response: `{a: 1}`, want: JSONOutcome{Text: `{a: 1}`, Stage: JSONStageFailed}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			repair, broken := scriptedRepair(c.repairs...)
+			outcome := DecodeJSONResponse(context.Background(), c.response, repair, c.maxRepairs)
+
+			if outcome.Text != c.want.Text || outcome.Stage != c.want.Stage || outcome.Repairs != c.want.Repairs {
+				t.Fatalf("expected %+v, got %+v", c.want, outcome)
+			}
+			if (outcome.Err != nil) != (c.want.Stage == JSONStageFailed) {
+				t.Fatalf("expected an error only when failed, got %v", outcome.Err)
+			}
+			if len(*broken) != c.want.Repairs {
+				t.Fatalf("expected %d repair requests, got %d", c.want.Repairs, len(*broken))
+			}
+			// Each repair request corrects the previous attempt, the first one the response
+			if len(*broken) != 0 && (*broken)[0] != c.response {
+				t.Fatalf("expected the first repair to get the response, got '%s'", (*broken)[0])
+			}
+			if len(*broken) == 2 && (*broken)[1] != c.repairs[0] {
+				t.Fatalf("expected the second repair to get the first repaired text, got '%s'", (*broken)[1])
+			}
+		})
+	}
+}
+
+func TestDecodeJSONResponseRepairError(t *testing.T) {
+	repair := func(ctx context.Context, broken string) (string, error) {
+		return "", errors.New("rate limited")
+	}
+
+	outcome := DecodeJSONResponse(context.Background(), `{a: 1}`, repair, 3)
+	if outcome.Stage != JSONStageFailed || outcome.Repairs != 1 || outcome.Text != `{a: 1}` {
+		t.Fatalf("expected to fail after the first repair request, got %+v", outcome)
+	}
+	if outcome.Err == nil || !strings.Contains(outcome.Err.Error(), "rate limited") {
+		t.Fatalf("expected the repair error, got %v", outcome.Err)
+	}
+}
@@ -0,0 +1,175 @@
+package completion
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+)
+
+/*
+--------------
+Model registry
+--------------
+*/
+
+// Context window assumed for models missing from the registry, like fine tuned ones
+const DefaultContextWindow = 128_000
+
+// Max edit distance of a did you mean suggestion to the unknown model name
+const maxSuggestionDistance = 3
+
+// Suffixes of dated snapshots and aliases of a registry model, like -2024-08-06, -0613, -20241022 or -latest
+var (
+	snapshotSuffixRegex = regexp.MustCompile(`^-(latest|\d{4}(-\d{2}-\d{2})?|\d{8})$`)
+	snapshotEndRegex    = regexp.MustCompile(`-(latest|\d{4}(-\d{2}-\d{2})?|\d{8})$`)
+)
+
+// Known chat model, with its limits, USD prices per million tokens and capabilities
+type ModelInfo struct {
+	ID                string
+	ContextWindow     int    // Tokens of prompt and completion together
+	MaxOutputTokens   int    // Tokens of a single completion
+	InputPrice        string // Decimal text so costs are exact
+	OutputPrice       string
+	Tools             bool // Supports tool calls
+	StructuredOutputs bool // Supports json_schema response formats
+	Vision            bool // Accepts image inputs
+}
+
+// Models the agent and the chat know, snapshots and -latest aliases of them included
+var registry = []ModelInfo{
+	{ID: "gpt-4o-mini", ContextWindow: 128_000, MaxOutputTokens: 16_384, InputPrice: "0.15", OutputPrice: "0.60", Tools: true, StructuredOutputs: true, Vision: true},
+	{ID: "gpt-4o", ContextWindow: 128_000, MaxOutputTokens: 16_384, InputPrice: "2.50", OutputPrice: "10.00", Tools: true, StructuredOutputs: true, Vision: true},
+	{ID: "chatgpt-4o-latest", ContextWindow: 128_000, MaxOutputTokens: 16_384, InputPrice: "5.00", OutputPrice: "15.00", Vision: true},
+	{ID: "gpt-4-turbo", ContextWindow: 128_000, MaxOutputTokens: 4_096, InputPrice: "10.00", OutputPrice: "30.00", Tools: true, Vision: true},
+	{ID: "gpt-4", ContextWindow: 8_192, MaxOutputTokens: 8_192, InputPrice: "30.00", OutputPrice: "60.00", Tools: true},
+	{ID: "gpt-3.5-turbo", ContextWindow: 16_385, MaxOutputTokens: 4_096, InputPrice: "0.50", OutputPrice: "1.50", Tools: true},
+	{ID: "o1", ContextWindow: 200_000, MaxOutputTokens: 100_000, InputPrice: "15.00", OutputPrice: "60.00", Tools: true, StructuredOutputs: true, Vision: true},
+	{ID: "o1-mini", ContextWindow: 128_000, MaxOutputTokens: 65_536, InputPrice: "1.10", OutputPrice: "4.40"},
+	{ID: "o3-mini", ContextWindow: 200_000, MaxOutputTokens: 100_000, InputPrice: "1.10", OutputPrice: "4.40", Tools: true, StructuredOutputs: true},
+	{ID: "claude-3-7-sonnet", ContextWindow: 200_000, MaxOutputTokens: 64_000, InputPrice: "3.00", OutputPrice: "15.00", Tools: true, Vision: true},
+	{ID: "claude-3-5-sonnet", ContextWindow: 200_000, MaxOutputTokens: 8_192, InputPrice: "3.00", OutputPrice: "15.00", Tools: true, Vision: true},
+	{ID: "claude-3-5-haiku", ContextWindow: 200_000, MaxOutputTokens: 8_192, InputPrice: "0.80", OutputPrice: "4.00", Tools: true},
+	{ID: "claude-3-opus", ContextWindow: 200_000, MaxOutputTokens: 4_096, InputPrice: "15.00", OutputPrice: "75.00", Tools: true, Vision: true},
+}
+
+// A configured model isn't on the registry, likely a typo that would only fail on the first request
+type ErrUnknownModel struct {
+	Model      string
+	Suggestion string // Closest registry model, empty when none is close
+}
+
+func (e *ErrUnknownModel) Error() string {
+	message := fmt.Sprintf("unknown model '%s'", e.Model)
+	if e.Suggestion != "" {
+		message += fmt.Sprintf(" (did you mean '%s'?)", e.Suggestion)
+	}
+	return message
+}
+func (e *ErrUnknownModel) ExitClass() exitcode.Class { return exitcode.ClassConfig }
+
+// Model name as the API expects it: trimmed and lowercase
+func NormalizeModel(model string) string {
+	return strings.ToLower(strings.TrimSpace(model))
+}
+
+// Registry entry of a model, matching its snapshots and aliases too, like gpt-4o-2024-08-06 or
+// claude-3-5-sonnet-latest. The longest matching entry wins, so gpt-4o-mini snapshots aren't taken for gpt-4o
+func LookupModel(model string) (ModelInfo, bool) {
+	model = NormalizeModel(model)
+
+	found, matched := ModelInfo{}, false
+	for _, info := range registry {
+		suffix, isPrefix := strings.CutPrefix(model, info.ID)
+		if !isPrefix || (suffix != "" && !snapshotSuffixRegex.MatchString(suffix)) {
+			continue
+		}
+		if !matched || len(info.ID) > len(found.ID) {
+			found, matched = info, true
+		}
+	}
+
+	return found, matched
+}
+
+// Normalize a configured model and check it's on the registry. Unknown models fail with ErrUnknownModel
+// unless allowUnknown is set
+func ValidateModel(model string, allowUnknown bool) (string, error) {
+	model = NormalizeModel(model)
+	if model == "" {
+		return "", fmt.Errorf("empty model name")
+	}
+
+	if _, ok := LookupModel(model); ok || allowUnknown {
+		return model, nil
+	}
+
+	return "", &ErrUnknownModel{Model: model, Suggestion: suggestModel(model)}
+}
+
+// Registry model IDs, in registry order
+func KnownModels() []string {
+	ids := []string{}
+	for _, info := range registry {
+		ids = append(ids, info.ID)
+	}
+	return ids
+}
+
+// Context window of a model in tokens, DefaultContextWindow for unknown ones
+func ContextWindow(model string) int {
+	if info, ok := LookupModel(model); ok {
+		return info.ContextWindow
+	}
+	return DefaultContextWindow
+}
+
+// Bytes of text filling percent of the context window of a model, with the characters per token estimate.
+// Sizes the data handed to a model after its window instead of a fixed amount
+func ContextBytes(model string, percent int) int {
+	return ContextWindow(model) * charsPerToken * percent / 100
+}
+
+// Closest registry model to an unknown one, ignoring dashes and dots so "gpt4o-mini" finds "gpt-4o-mini",
+// and keeping a snapshot or alias suffix. Empty when none is within maxSuggestionDistance edits
+func suggestModel(model string) string {
+	strip := strings.NewReplacer("-", "", ".", "", "_", "", " ", "")
+	suffix := snapshotEndRegex.FindString(model)
+	base := strings.TrimSuffix(model, suffix)
+
+	suggestion, best := "", maxSuggestionDistance+1
+	for _, id := range KnownModels() {
+		distance := min(editDistance(base, id), editDistance(strip.Replace(base), strip.Replace(id)))
+		if distance < best {
+			suggestion, best = id+suffix, distance
+		}
+	}
+
+	return suggestion
+}
+
+// Levenshtein distance between two strings, by bytes
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	current := make([]int, len(b)+1)
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = slices.Min([]int{previous[j] + 1, current[j-1] + 1, previous[j-1] + cost})
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
@@ -0,0 +1,73 @@
+package completion
+
+import (
+	"errors"
+	"testing"
+)
+
+// Configured model names validate to their normalized name and context window, or fail with a did you mean
+// suggestion for typos
+func TestValidateModel(t *testing.T) {
+	cases := []struct {
+		name         string
+		model        string
+		allowUnknown bool
+		want         string // Normalized name, empty when validating must fail
+		window       int    // Context window the budgets are sized from
+		suggestion   string // Did you mean suggestion of a failure
+	}{
+		{name: "known model", model: "gpt-4o-mini", want: "gpt-4o-mini", window: 128_000},
+		{name: "untrimmed uppercase", model: " GPT-4o ", want: "gpt-4o", window: 128_000},
+		{name: "dated snapshot", model: "gpt-4o-mini-2024-07-18", want: "gpt-4o-mini-2024-07-18", window: 128_000},
+		{name: "short snapshot", model: "gpt-4-0613", want: "gpt-4-0613", window: 8_192},
+		{name: "claude alias", model: "claude-3-5-sonnet-latest", want: "claude-3-5-sonnet-latest", window: 200_000},
+		{name: "claude snapshot", model: "claude-3-5-haiku-20241022", want: "claude-3-5-haiku-20241022", window: 200_000},
+		{name: "missing dash", model: "gpt4o-mini", suggestion: "gpt-4o-mini"},
+		{name: "typo", model: "gpt-4o-mnii", suggestion: "gpt-4o-mini"},
+		{name: "misspelled alias", model: "claude-3-5-sonet-latest", suggestion: "claude-3-5-sonnet-latest"},
+		{name: "unknown variant", model: "gpt-4o-audio-preview"},
+		{name: "nothing close", model: "llama-3-70b"},
+		{name: "allowed unknown", model: "ft:gpt-4o-mini:acme::abc123", allowUnknown: true, want: "ft:gpt-4o-mini:acme::abc123", window: DefaultContextWindow},
+		{name: "empty", model: " ", allowUnknown: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			model, err := ValidateModel(c.model, c.allowUnknown)
+			if c.want == "" {
+				if err == nil {
+					t.Fatalf("accepted as '%s', expected an error", model)
+				}
+				var unknown *ErrUnknownModel
+				if errors.As(err, &unknown) && unknown.Suggestion != c.suggestion {
+					t.Errorf("suggested '%s', expected '%s'", unknown.Suggestion, c.suggestion)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if model != c.want {
+				t.Errorf("normalized to '%s', expected '%s'", model, c.want)
+			}
+			if window := ContextWindow(model); window != c.window {
+				t.Errorf("context window of %d tokens, expected %d", window, c.window)
+			}
+		})
+	}
+}
+
+// Every known model looks up to its own entry, with the limits the budgets are sized from
+func TestKnownModels(t *testing.T) {
+	for _, id := range KnownModels() {
+		info, ok := LookupModel(id)
+		if !ok || info.ID != id {
+			t.Errorf("model '%s' looks up to %+v", id, info)
+			continue
+		}
+		if info.ContextWindow <= 0 || info.MaxOutputTokens <= 0 {
+			t.Errorf("model '%s' has no context window or max output tokens", id)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package completion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+/*
+-------------------------
+Client side rate limiting
+-------------------------
+*/
+
+// Environment variables holding the organization budgets shared by every completion of the process
+const (
+	RequestsPerMinuteEnv = "OPENAI_RPM"
+	TokensPerMinuteEnv   = "OPENAI_TPM"
+)
+
+// Approximate characters per token of a request, to estimate its tokens before sending it
+const charsPerToken = 4
+
+// Budgets of a rate limiter. Zero values are unlimited
+type RateLimits struct {
+	RequestsPerMinute int
+	TokensPerMinute   int
+}
+
+// Whether any budget is set
+func (l RateLimits) Enabled() bool {
+	return l.RequestsPerMinute > 0 || l.TokensPerMinute > 0
+}
+
+// Read the budgets from OPENAI_RPM and OPENAI_TPM. Unset variables are unlimited
+func RateLimitsFromEnv() (RateLimits, error) {
+	limits := RateLimits{}
+	for env, limit := range map[string]*int{RequestsPerMinuteEnv: &limits.RequestsPerMinute, TokensPerMinuteEnv: &limits.TokensPerMinute} {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return RateLimits{}, fmt.Errorf("invalid %s '%s', expected a positive integer", env, value)
+		}
+		*limit = parsed
+	}
+
+	return limits, nil
+}
+
+// Time source of a rate limiter, replaceable to control waits
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Budget refilling continuously up to its per minute capacity. Available goes negative
+// while calls wait for reservations they already took, which keeps waiting calls in order
+type bucket struct {
+	capacity  float64
+	available float64
+}
+
+// Refill the budget for the time elapsed
+func (b *bucket) refill(elapsed time.Duration) {
+	if b.capacity == 0 {
+		return
+	}
+	b.available = min(b.capacity, b.available+b.capacity*elapsed.Minutes())
+}
+
+// Time until a reservation of amount taken now is covered, zero if it already is
+func (b *bucket) reserve(amount float64) time.Duration {
+	if b.capacity == 0 {
+		return 0
+	}
+
+	b.available -= amount
+	if b.available >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.available / b.capacity * float64(time.Minute))
+}
+
+// Fraction of the budget in use, over 1 while calls wait
+func (b *bucket) saturation() float64 {
+	if b.capacity == 0 {
+		return 0
+	}
+
+	return 1 - b.available/b.capacity
+}
+
+// Snapshot of a rate limiter, for health checks and spans
+type RateLimiterStats struct {
+	RequestsPerMinute int     `json:"requestsPerMinute"`
+	TokensPerMinute   int     `json:"tokensPerMinute"`
+	Saturation        float64 `json:"saturation"` // Fraction of the most used budget in use, over 1 while calls wait
+	Waiting           int     `json:"waiting"`    // Calls waiting for budget right now
+	Waits             int     `json:"waits"`      // Calls that had to wait so far
+	WaitedMs          int64   `json:"waitedMs"`   // Total time calls waited so far
+}
+
+// Weighted token bucket limiting requests and tokens per minute. Completion calls acquire one request
+// and their estimated tokens before sending, waiting when the budget is spent instead of failing,
+// and reconcile the estimate with their actual usage afterwards. Safe for concurrent callers
+type RateLimiter struct {
+	limits   RateLimits
+	requests bucket
+	tokens   bucket
+	updated  time.Time
+	clock    clock
+
+	waiting int
+	waits   int
+	waited  time.Duration
+	mutex   sync.Mutex
+}
+
+// Create a rate limiter with full budgets. Returns nil when limits are unlimited, nil limiters never wait
+func NewRateLimiter(limits RateLimits) *RateLimiter {
+	return newRateLimiter(limits, systemClock{})
+}
+
+func newRateLimiter(limits RateLimits, clock clock) *RateLimiter {
+	if !limits.Enabled() {
+		return nil
+	}
+
+	log.Printf("Limiting completions to %d requests and %d tokens per minute (0 is unlimited)\n", limits.RequestsPerMinute, limits.TokensPerMinute)
+	return &RateLimiter{
+		limits:   limits,
+		requests: bucket{capacity: float64(limits.RequestsPerMinute), available: float64(limits.RequestsPerMinute)},
+		tokens:   bucket{capacity: float64(limits.TokensPerMinute), available: float64(limits.TokensPerMinute)},
+		updated:  clock.Now(),
+		clock:    clock,
+	}
+}
+
+// Created on the first SharedRateLimiter call from the environment budgets
+var sharedRateLimiter = sync.OnceValues(func() (*RateLimiter, error) {
+	limits, err := RateLimitsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRateLimiter(limits), nil
+})
+
+// Get the rate limiter configured with OPENAI_RPM and OPENAI_TPM, shared by every caller of the process.
+// Nil when neither is set
+func SharedRateLimiter() (*RateLimiter, error) {
+	return sharedRateLimiter()
+}
+
+// Refill the budgets for the time elapsed since the last update. Must hold the mutex
+func (r *RateLimiter) refill() {
+	now := r.clock.Now()
+	elapsed := now.Sub(r.updated)
+	r.updated = now
+	r.requests.refill(elapsed)
+	r.tokens.refill(elapsed)
+}
+
+// Acquire one request and tokens of budget, waiting until they're available or ctx is done.
+// Requests over the whole token budget only wait for a full budget. Returns the time waited
+func (r *RateLimiter) Acquire(ctx context.Context, tokens int) (time.Duration, error) {
+	if r == nil {
+		return 0, nil
+	}
+
+	amount := float64(tokens)
+	if r.tokens.capacity > 0 {
+		amount = min(amount, r.tokens.capacity)
+	}
+
+	r.mutex.Lock()
+	r.refill()
+	wait := max(r.requests.reserve(1), r.tokens.reserve(amount))
+	if wait == 0 {
+		r.mutex.Unlock()
+		return 0, nil
+	}
+	r.waiting++
+	r.mutex.Unlock()
+
+	select {
+	case <-r.clock.After(wait):
+	case <-ctx.Done():
+		// Give the reservation back to the calls still waiting
+		r.mutex.Lock()
+		r.refill()
+		r.requests.available = min(r.requests.capacity, r.requests.available+1)
+		r.tokens.available = min(r.tokens.capacity, r.tokens.available+amount)
+		r.waiting--
+		r.mutex.Unlock()
+		return 0, ctx.Err()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.waiting--
+	r.waits++
+	r.waited += wait
+	return wait, nil
+}
+
+// Correct the tokens taken by Acquire with the tokens the completion actually used
+func (r *RateLimiter) Reconcile(estimated int, actual int) {
+	if r == nil || r.tokens.capacity == 0 {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.refill()
+	r.tokens.available = min(r.tokens.capacity, r.tokens.available+float64(estimated-actual))
+}
+
+// Snapshot of the budgets and waits so far. Zero for nil limiters
+func (r *RateLimiter) Stats() RateLimiterStats {
+	if r == nil {
+		return RateLimiterStats{}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.refill()
+	return RateLimiterStats{
+		RequestsPerMinute: r.limits.RequestsPerMinute,
+		TokensPerMinute:   r.limits.TokensPerMinute,
+		Saturation:        max(r.requests.saturation(), r.tokens.saturation()),
+		Waiting:           r.waiting,
+		Waits:             r.waits,
+		WaitedMs:          r.waited.Milliseconds(),
+	}
+}
+
+// Estimate the tokens of a completion: its request size in tokens plus its completion token cap, if any
+func EstimateTokens(body openai.ChatCompletionNewParams) int {
+	messages, err := json.Marshal(body.Messages.Value)
+	if err != nil {
+		return 0
+	}
+
+	estimate := len(messages) / charsPerToken
+	if body.MaxCompletionTokens.Present {
+		estimate += int(body.MaxCompletionTokens.Value)
+	} else if body.MaxTokens.Present {
+		estimate += int(body.MaxTokens.Value)
+	}
+
+	return estimate
+}
@@ -0,0 +1,222 @@
+package completion
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Clock that only moves when advanced. Timers are announced on started so tests know a call is waiting
+type fakeClock struct {
+	now     time.Time
+	timers  []fakeTimer
+	started chan time.Duration
+	mutex   sync.Mutex
+}
+
+type fakeTimer struct {
+	deadline time.Time
+	fired    chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), started: make(chan time.Duration, 16)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	timer := fakeTimer{deadline: c.now.Add(d), fired: make(chan time.Time, 1)}
+	c.timers = append(c.timers, timer)
+	c.mutex.Unlock()
+
+	c.started <- d
+	return timer.fired
+}
+
+// Move the time forward and fire the timers it reaches
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.deadline.After(c.now) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.fired <- c.now
+	}
+	c.timers = pending
+}
+
+type acquired struct {
+	wait time.Duration
+	err  error
+}
+
+// Acquire in the background, returns the time it waited once it's done
+func acquireAsync(ctx context.Context, limiter *RateLimiter, tokens int) <-chan acquired {
+	done := make(chan acquired, 1)
+	go func() {
+		wait, err := limiter.Acquire(ctx, tokens)
+		done <- acquired{wait, err}
+	}()
+	return done
+}
+
+func mustAcquireNow(t *testing.T, limiter *RateLimiter, tokens int) {
+	t.Helper()
+	wait, err := limiter.Acquire(context.Background(), tokens)
+	if err != nil || wait != 0 {
+		t.Fatalf("expected %d tokens to be acquired without waiting, waited %s: %v", tokens, wait, err)
+	}
+}
+
+func TestRateLimiterWaitsForRequestBudget(t *testing.T) {
+	clock := newFakeClock()
+	limiter := newRateLimiter(RateLimits{RequestsPerMinute: 2}, clock)
+
+	mustAcquireNow(t, limiter, 0)
+	mustAcquireNow(t, limiter, 0)
+
+	done := acquireAsync(context.Background(), limiter, 0)
+	if wait := <-clock.started; wait != 30*time.Second {
+		t.Fatalf("expected the third request to wait 30s for budget, got %s", wait)
+	}
+	if stats := limiter.Stats(); stats.Waiting != 1 || stats.Saturation != 1.5 {
+		t.Fatalf("expected 1 waiting call at 1.5 saturation, got %+v", stats)
+	}
+
+	clock.Advance(30 * time.Second)
+	result := <-done
+	if result.err != nil || result.wait != 30*time.Second {
+		t.Fatalf("expected to acquire after 30s, got %s: %v", result.wait, result.err)
+	}
+
+	stats := limiter.Stats()
+	if stats.Waiting != 0 || stats.Waits != 1 || stats.WaitedMs != 30000 {
+		t.Fatalf("expected 1 finished wait of 30s, got %+v", stats)
+	}
+}
+
+func TestRateLimiterWaitsForTokenBudget(t *testing.T) {
+	clock := newFakeClock()
+	limiter := newRateLimiter(RateLimits{TokensPerMinute: 1000}, clock)
+
+	mustAcquireNow(t, limiter, 800)
+
+	done := acquireAsync(context.Background(), limiter, 400)
+	if wait := <-clock.started; wait != 12*time.Second {
+		t.Fatalf("expected 200 missing tokens to wait 12s, got %s", wait)
+	}
+	clock.Advance(12 * time.Second)
+	if result := <-done; result.err != nil || result.wait != 12*time.Second {
+		t.Fatalf("expected to acquire after 12s, got %s: %v", result.wait, result.err)
+	}
+
+	// The budget refills over time, a minute later it's full again
+	clock.Advance(time.Minute)
+	mustAcquireNow(t, limiter, 1000)
+}
+
+// A request larger than the whole budget only waits for a full one instead of forever
+func TestRateLimiterCapsOversizedRequests(t *testing.T) {
+	clock := newFakeClock()
+	limiter := newRateLimiter(RateLimits{TokensPerMinute: 1000}, clock)
+
+	mustAcquireNow(t, limiter, 5000)
+
+	done := acquireAsync(context.Background(), limiter, 5000)
+	if wait := <-clock.started; wait != time.Minute {
+		t.Fatalf("expected an oversized request to wait for a full budget, got %s", wait)
+	}
+	clock.Advance(time.Minute)
+	if result := <-done; result.err != nil {
+		t.Fatal(result.err)
+	}
+}
+
+// A cancelled wait gives its reservation back, later calls don't wait for it
+func TestRateLimiterRefundsCancelledWaits(t *testing.T) {
+	clock := newFakeClock()
+	limiter := newRateLimiter(RateLimits{RequestsPerMinute: 1, TokensPerMinute: 1000}, clock)
+
+	mustAcquireNow(t, limiter, 1000)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := acquireAsync(ctx, limiter, 500)
+	if wait := <-clock.started; wait != time.Minute {
+		t.Fatalf("expected to wait a minute for the next request, got %s", wait)
+	}
+	cancel()
+	if result := <-done; !errors.Is(result.err, context.Canceled) || result.wait != 0 {
+		t.Fatalf("expected a cancelled acquire, got %s: %v", result.wait, result.err)
+	}
+
+	stats := limiter.Stats()
+	if stats.Waiting != 0 || stats.Waits != 0 || stats.Saturation != 1 {
+		t.Fatalf("expected the reservation refunded to a spent budget, got %+v", stats)
+	}
+
+	// Without the refund the next call would wait two minutes
+	done = acquireAsync(context.Background(), limiter, 500)
+	if wait := <-clock.started; wait != time.Minute {
+		t.Fatalf("expected the next call to wait only for its own request, got %s", wait)
+	}
+	clock.Advance(time.Minute)
+	if result := <-done; result.err != nil || result.wait != time.Minute {
+		t.Fatalf("expected to acquire after a minute, got %s: %v", result.wait, result.err)
+	}
+}
+
+func TestRateLimiterReconcile(t *testing.T) {
+	clock := newFakeClock()
+	limiter := newRateLimiter(RateLimits{TokensPerMinute: 1000}, clock)
+
+	// Overestimated calls give back what they didn't use
+	mustAcquireNow(t, limiter, 900)
+	limiter.Reconcile(900, 100)
+	mustAcquireNow(t, limiter, 900)
+
+	// Underestimated ones take the rest from the budget
+	limiter.Reconcile(0, 100)
+	if stats := limiter.Stats(); stats.Saturation != 1.1 {
+		t.Fatalf("expected the budget overspent by 100 tokens, got %+v", stats)
+	}
+}
+
+func TestNilRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(RateLimits{}, newFakeClock())
+	if limiter != nil {
+		t.Fatal("expected no limiter without budgets")
+	}
+
+	mustAcquireNow(t, limiter, 1_000_000)
+	limiter.Reconcile(0, 1_000_000)
+	if stats := limiter.Stats(); stats != (RateLimiterStats{}) {
+		t.Fatalf("expected empty stats, got %+v", stats)
+	}
+}
+
+func TestRateLimitsFromEnv(t *testing.T) {
+	t.Setenv(RequestsPerMinuteEnv, "60")
+	t.Setenv(TokensPerMinuteEnv, "")
+	limits, err := RateLimitsFromEnv()
+	if err != nil || limits != (RateLimits{RequestsPerMinute: 60}) {
+		t.Fatalf("expected 60 requests per minute, got %+v: %v", limits, err)
+	}
+
+	t.Setenv(TokensPerMinuteEnv, "-1")
+	if _, err := RateLimitsFromEnv(); err == nil {
+		t.Fatalf("expected a negative %s to be rejected", TokensPerMinuteEnv)
+	}
+}
@@ -0,0 +1,65 @@
+package completion
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/openai/openai-go"
+)
+
+/*
+------------------
+OpenAI request IDs
+------------------
+*/
+
+// Response header identifying a request, what OpenAI support asks for
+const RequestIDHeader = "x-request-id"
+
+// A chat completion request failed. RequestID identifies it for OpenAI support
+type ErrRequest struct {
+	RequestID string
+	Err       error
+}
+
+func (e *ErrRequest) Error() string             { return fmt.Sprintf("%s (request id %s)", e.Err, e.RequestID) }
+func (e *ErrRequest) Unwrap() error             { return e.Err }
+func (e *ErrRequest) ExitClass() exitcode.Class { return exitcode.ClassUpstream }
+
+// Get the request ID of a response. Falls back to the response of an API error,
+// empty if neither carries one, like for connection errors
+func RequestID(response *http.Response, err error) string {
+	if response != nil {
+		if requestID := response.Header.Get(RequestIDHeader); requestID != "" {
+			return requestID
+		}
+	}
+
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) && apiErr.Response != nil {
+		return apiErr.Response.Header.Get(RequestIDHeader)
+	}
+
+	return ""
+}
+
+// Add the request ID to a failed request error. Errors without a request ID are returned as is
+func WithRequestID(err error, requestID string) error {
+	if err == nil || requestID == "" {
+		return err
+	}
+
+	return &ErrRequest{RequestID: requestID, Err: err}
+}
+
+// Request ID carried by an error, empty if it has none
+func RequestIDOf(err error) string {
+	var requestErr *ErrRequest
+	if errors.As(err, &requestErr) {
+		return requestErr.RequestID
+	}
+
+	return ""
+}
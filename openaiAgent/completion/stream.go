@@ -0,0 +1,85 @@
+package completion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/openai/openai-go"
+)
+
+/*
+------------------
+Streamed responses
+------------------
+*/
+
+// Appended to the partial answer of an interrupted stream, so the conversation shows it was cut
+const InterruptedMarker = "[interrupted]"
+
+// Chunks of a streamed chat completion. Satisfied by the stream of Chat.Completions.NewStreaming
+type ChunkStream interface {
+	Next() bool
+	Current() openai.ChatCompletionChunk
+	Err() error
+}
+
+// Answer read from a stream, and the token usage of the request when the stream sent it
+type StreamResult struct {
+	Text        string
+	Usage       openai.CompletionUsage
+	Interrupted bool // The request context was cancelled before the answer finished, Text is the partial answer
+}
+
+// Read a streamed completion to its end, calling onDelta with each piece of answer text as it arrives.
+// Cancelling ctx, the context of the stream request, interrupts the answer: the text received so far is
+// returned with InterruptedMarker appended and no error. An answer that finished before the cancellation
+// is returned whole. Complete answers are checked like Message checks them
+func ReadStream(ctx context.Context, stream ChunkStream, onDelta func(string)) (StreamResult, error) {
+	accumulator := openai.ChatCompletionAccumulator{}
+	for stream.Next() {
+		chunk := stream.Current()
+		if !accumulator.AddChunk(chunk) {
+			return StreamResult{}, fmt.Errorf("stream chunk '%s' doesn't belong to completion '%s'", chunk.ID, accumulator.ID)
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Index == 0 && choice.Delta.Content != "" {
+				onDelta(choice.Delta.Content)
+			}
+		}
+	}
+
+	finished := len(accumulator.Choices) != 0 && accumulator.Choices[0].FinishReason != ""
+	err := stream.Err()
+	if ctx.Err() != nil {
+		if !finished {
+			partial := ""
+			if len(accumulator.Choices) != 0 {
+				partial = accumulator.Choices[0].Message.Content
+			}
+			return StreamResult{Text: markInterrupted(partial), Usage: accumulator.Usage, Interrupted: true}, nil
+		}
+		// Only the usage chunk was cut
+		if errors.Is(err, ctx.Err()) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return StreamResult{Usage: accumulator.Usage}, err
+	}
+
+	message, err := Message(&accumulator.ChatCompletion)
+	return StreamResult{Text: message.Content, Usage: accumulator.Usage}, err
+}
+
+// Partial answer followed by InterruptedMarker
+func markInterrupted(partial string) string {
+	partial = strings.TrimRightFunc(partial, unicode.IsSpace)
+	if partial == "" {
+		return InterruptedMarker
+	}
+
+	return partial + " " + InterruptedMarker
+}
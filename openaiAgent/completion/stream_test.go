@@ -0,0 +1,128 @@
+package completion
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go"
+)
+
+// Usage sent on the last chunk of a finished stream
+var streamUsage = openai.CompletionUsage{PromptTokens: 12, CompletionTokens: 6, TotalTokens: 18}
+
+// Fake stream, cancelling the request after reading cancelAt chunks like Escape or Ctrl+C do
+type fakeStream struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	chunks   []openai.ChatCompletionChunk
+	read     int
+	cancelAt int
+	err      error
+}
+
+func (s *fakeStream) Next() bool {
+	if s.read == s.cancelAt {
+		s.cancel()
+	}
+	if s.ctx.Err() != nil || s.read == len(s.chunks) {
+		return false
+	}
+
+	s.read++
+	return true
+}
+
+func (s *fakeStream) Current() openai.ChatCompletionChunk {
+	return s.chunks[s.read-1]
+}
+
+func (s *fakeStream) Err() error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	return s.err
+}
+
+// Chunks streaming deltas and a refusal after them, followed by the usage chunk when the stream finishes
+func streamChunks(deltas []string, refusal string, finish openai.ChatCompletionChunkChoicesFinishReason) []openai.ChatCompletionChunk {
+	chunk := func(delta openai.ChatCompletionChunkChoicesDelta) openai.ChatCompletionChunk {
+		return openai.ChatCompletionChunk{ID: "chatcmpl-stream-test", Choices: []openai.ChatCompletionChunkChoice{{Index: 0, Delta: delta}}}
+	}
+
+	chunks := []openai.ChatCompletionChunk{}
+	for _, delta := range deltas {
+		chunks = append(chunks, chunk(openai.ChatCompletionChunkChoicesDelta{Content: delta}))
+	}
+	if refusal != "" {
+		chunks = append(chunks, chunk(openai.ChatCompletionChunkChoicesDelta{Refusal: refusal}))
+	}
+	if finish == "" {
+		return chunks
+	}
+
+	chunks[len(chunks)-1].Choices[0].FinishReason = finish
+	return append(chunks, openai.ChatCompletionChunk{ID: "chatcmpl-stream-test", Choices: []openai.ChatCompletionChunkChoice{}, Usage: streamUsage})
+}
+
+// Complete, interrupted, failed, refused and filtered answers
+func TestReadStream(t *testing.T) {
+	answer := []string{"Store 1320 ", "sold the most, ", "48,210 units."}
+
+	cases := []struct {
+		name        string
+		deltas      []string
+		refusal     string                                        // Streamed after the deltas
+		finish      openai.ChatCompletionChunkChoicesFinishReason // Finish reason of the last delta, empty for a stream cut short
+		cancelAt    int                                           // Chunks read before cancelling the request. -1 never cancels
+		err         error                                         // Error the stream fails with after its chunks
+		want        string                                        // Answer text, with the marker when interrupted
+		interrupted bool
+		wantUsage   int64  // Total tokens
+		wantError   string // Text of the expected error, empty for none
+	}{
+		{name: "complete", deltas: answer, finish: "stop", cancelAt: -1, want: "Store 1320 sold the most, 48,210 units.", wantUsage: 18},
+		{name: "interrupted", deltas: answer, finish: "stop", cancelAt: 2, want: "Store 1320 sold the most, [interrupted]", interrupted: true},
+		{name: "interrupted before any text", deltas: answer, finish: "stop", cancelAt: 0, want: InterruptedMarker, interrupted: true},
+		{name: "interrupted after the answer", deltas: answer, finish: "stop", cancelAt: 3, want: "Store 1320 sold the most, 48,210 units."},
+		{name: "connection lost", deltas: answer[:2], cancelAt: -1, err: errors.New("connection reset by peer"), wantError: "connection reset by peer"},
+		{name: "refused", refusal: "I can't help with that.", finish: "stop", cancelAt: -1, wantUsage: 18, wantError: "model refused: I can't help with that."},
+		{name: "filtered", deltas: answer[:1], finish: "content_filter", cancelAt: -1, wantUsage: 18, wantError: (&ErrContentFilter{}).Error()},
+		{name: "empty", cancelAt: -1, wantError: (&ErrNoChoices{}).Error()},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			printed := ""
+			stream := &fakeStream{ctx: ctx, cancel: cancel, chunks: streamChunks(c.deltas, c.refusal, c.finish), cancelAt: c.cancelAt, err: c.err}
+			result, err := ReadStream(ctx, stream, func(delta string) { printed += delta })
+
+			if c.wantError == "" && err != nil {
+				t.Fatalf("failed with %s", err)
+			}
+			if c.wantError != "" && (err == nil || err.Error() != c.wantError) {
+				t.Fatalf("failed with %v, expected %s", err, c.wantError)
+			}
+			if result.Usage.TotalTokens != c.wantUsage {
+				t.Fatalf("used %d tokens, expected %d", result.Usage.TotalTokens, c.wantUsage)
+			}
+			if err != nil {
+				return
+			}
+
+			if result.Text != c.want {
+				t.Fatalf("answered %q, expected %q", result.Text, c.want)
+			}
+			if result.Interrupted != c.interrupted {
+				t.Fatalf("interrupted is %t, expected %t", result.Interrupted, c.interrupted)
+			}
+			if strings.TrimSpace(printed) != strings.TrimSpace(strings.TrimSuffix(result.Text, InterruptedMarker)) {
+				t.Fatalf("printed %q while streaming, but answered %q", printed, result.Text)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package completion
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+--------------
+HTTP transport
+--------------
+*/
+
+// Environment variables overriding the transport settings of the shared clients
+const (
+	MaxIdleConnsPerHostEnv = "OPENAI_MAX_IDLE_CONNS_PER_HOST"
+	ResponseTimeoutEnv     = "OPENAI_RESPONSE_TIMEOUT"
+)
+
+// Transport settings of the completion clients. Zero values use DefaultHTTPSettings
+type HTTPSettings struct {
+	MaxIdleConnsPerHost   int           // Connections kept open to the API between requests. Parallel tool calls burst over the net/http default of 2
+	IdleConnTimeout       time.Duration // How long an idle connection is kept open
+	DialTimeout           time.Duration // Max duration of opening a connection
+	TLSHandshakeTimeout   time.Duration // Max duration of the TLS handshake
+	ResponseHeaderTimeout time.Duration // Max wait for the response headers after sending a request. Completions aren't streamed, so it covers the whole generation
+}
+
+var DefaultHTTPSettings = HTTPSettings{
+	MaxIdleConnsPerHost:   16,
+	IdleConnTimeout:       90 * time.Second,
+	DialTimeout:           10 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: 5 * time.Minute,
+}
+
+// Settings with their zero values replaced by the defaults
+func (s HTTPSettings) WithDefaults() HTTPSettings {
+	if s.MaxIdleConnsPerHost <= 0 {
+		s.MaxIdleConnsPerHost = DefaultHTTPSettings.MaxIdleConnsPerHost
+	}
+	if s.IdleConnTimeout <= 0 {
+		s.IdleConnTimeout = DefaultHTTPSettings.IdleConnTimeout
+	}
+	if s.DialTimeout <= 0 {
+		s.DialTimeout = DefaultHTTPSettings.DialTimeout
+	}
+	if s.TLSHandshakeTimeout <= 0 {
+		s.TLSHandshakeTimeout = DefaultHTTPSettings.TLSHandshakeTimeout
+	}
+	if s.ResponseHeaderTimeout <= 0 {
+		s.ResponseHeaderTimeout = DefaultHTTPSettings.ResponseHeaderTimeout
+	}
+
+	return s
+}
+
+// Whether any setting is set
+func (s HTTPSettings) IsZero() bool {
+	return s == HTTPSettings{}
+}
+
+// Read the settings from OPENAI_MAX_IDLE_CONNS_PER_HOST and OPENAI_RESPONSE_TIMEOUT. Unset variables use the defaults
+func HTTPSettingsFromEnv() (HTTPSettings, error) {
+	settings := HTTPSettings{}
+	if value := os.Getenv(MaxIdleConnsPerHostEnv); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return HTTPSettings{}, fmt.Errorf("invalid %s '%s', expected a positive integer", MaxIdleConnsPerHostEnv, value)
+		}
+		settings.MaxIdleConnsPerHost = parsed
+	}
+	if value := os.Getenv(ResponseTimeoutEnv); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return HTTPSettings{}, fmt.Errorf("invalid %s '%s', expected a duration like 2m", ResponseTimeoutEnv, value)
+		}
+		settings.ResponseHeaderTimeout = parsed
+	}
+
+	return settings.WithDefaults(), nil
+}
+
+// HTTP client with its transport tuned by settings. It has no overall timeout,
+// requests are bounded by their context and the transport timeouts instead
+func NewHTTPClient(settings HTTPSettings) *http.Client {
+	settings = settings.WithDefaults()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: settings.DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.MaxIdleConnsPerHost = settings.MaxIdleConnsPerHost
+	transport.MaxIdleConns = max(transport.MaxIdleConns, settings.MaxIdleConnsPerHost)
+	transport.IdleConnTimeout = settings.IdleConnTimeout
+	transport.TLSHandshakeTimeout = settings.TLSHandshakeTimeout
+	transport.ResponseHeaderTimeout = settings.ResponseHeaderTimeout
+
+	return &http.Client{Transport: transport}
+}
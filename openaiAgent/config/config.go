@@ -0,0 +1,233 @@
+// Package config loads the agent settings from a JSON config file. Named profiles like dev, staging or prod
+// override its base settings, and ${NAME} references to environment variables are expanded on load.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+)
+
+/*
+------------
+Config files
+------------
+*/
+
+// Environment variable selecting the profile when none is given on the command line
+const ProfileEnv = "APP_PROFILE"
+
+// ${NAME} references to environment variables on setting values
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Settings and environment of a config file part, either its base or a profile
+type Section struct {
+	Settings map[string]any    `json:"settings"` // Command line flag values by flag name, like "model" or "rpm"
+	Env      map[string]string `json:"env"`      // Environment variables like endpoints, set when not already defined
+}
+
+// Layout of a config file: base settings, the profiles overriding them and the reports run on a schedule
+type File struct {
+	Section
+	Profiles map[string]Section `json:"profiles"`
+	Schedule []ScheduledReport  `json:"schedule"`
+}
+
+// Value a config file gives to a flag or an environment variable
+type Setting struct {
+	Name       string
+	Value      string
+	Source     string // "base" or "profile <name>"
+	Overridden bool   // Set by Apply when a command line flag or a defined environment variable took precedence
+}
+
+// Settings of a config file with a profile applied
+type Config struct {
+	Path     string
+	Profile  string    // Empty when only the base settings are used
+	Settings []Setting // Sorted by name, profile values replace base ones
+	Env      []Setting // Sorted by name, profile values replace base ones
+	Schedule []ScheduledReport
+}
+
+// A config file references environment variables that aren't defined
+type ErrMissingEnv struct {
+	Path    string
+	Profile string
+	Missing []string
+}
+
+func (e *ErrMissingEnv) Error() string {
+	profile := "base settings"
+	if e.Profile != "" {
+		profile = fmt.Sprintf("profile '%s'", e.Profile)
+	}
+
+	return fmt.Sprintf(
+		"config '%s' with %s references undefined environment variables: %s",
+		e.Path, profile, strings.Join(e.Missing, ", "),
+	)
+}
+func (e *ErrMissingEnv) ExitClass() exitcode.Class { return exitcode.ClassConfig }
+
+// Load a config file and apply one of its profiles over the base settings. An empty profile uses the base alone.
+// Only the base and the chosen profile must have their environment references defined
+func Load(path string, profile string) (*Config, error) {
+	jsonBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	// Reject misspelled keys instead of silently ignoring them
+	file := File{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to decode config '%s': %w", path, err)
+	}
+
+	if err := validateSchedule(path, file.Schedule); err != nil {
+		return nil, err
+	}
+
+	sections := map[string]Section{"base": file.Section}
+	order := []string{"base"}
+	if profile != "" {
+		profileSection, ok := file.Profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf(
+				"config '%s' has no profile '%s'. Available profiles: %s",
+				path, profile, strings.Join(slices.Sorted(maps.Keys(file.Profiles)), ", "),
+			)
+		}
+
+		source := "profile " + profile
+		sections[source] = profileSection
+		order = append(order, source)
+	}
+
+	settings := map[string]Setting{}
+	env := map[string]Setting{}
+	missing := []string{}
+	for _, source := range order {
+		for name, rawValue := range sections[source].Settings {
+			value, err := settingValue(rawValue)
+			if err != nil {
+				return nil, fmt.Errorf("config '%s' setting '%s' of the %s: %w", path, name, source, err)
+			}
+			settings[name] = Setting{Name: name, Value: expandEnv(value, &missing), Source: source}
+		}
+
+		for name, value := range sections[source].Env {
+			env[name] = Setting{Name: name, Value: expandEnv(value, &missing), Source: source}
+		}
+	}
+
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return nil, &ErrMissingEnv{Path: path, Profile: profile, Missing: slices.Compact(missing)}
+	}
+
+	return &Config{
+		Path:     path,
+		Profile:  profile,
+		Settings: sortedSettings(settings),
+		Env:      sortedSettings(env),
+		Schedule: file.Schedule,
+	}, nil
+}
+
+// Flag value of a JSON setting. Only strings, numbers and booleans map to flags
+func settingValue(rawValue any) (string, error) {
+	switch value := rawValue.(type) {
+	case string:
+		return value, nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	default:
+		return "", fmt.Errorf("expected a string, number or boolean, got %v", rawValue)
+	}
+}
+
+// Replace the ${NAME} references of a value, adding the undefined ones to missing
+func expandEnv(value string, missing *[]string) string {
+	return envReference.ReplaceAllStringFunc(value, func(reference string) string {
+		name := envReference.FindStringSubmatch(reference)[1]
+		envValue, ok := os.LookupEnv(name)
+		if !ok {
+			*missing = append(*missing, name)
+		}
+		return envValue
+	})
+}
+
+func sortedSettings(settings map[string]Setting) []Setting {
+	sorted := []Setting{}
+	for _, name := range slices.Sorted(maps.Keys(settings)) {
+		sorted = append(sorted, settings[name])
+	}
+
+	return sorted
+}
+
+// Set the flags and environment variables of the config. Flags given on the command line and environment
+// variables already defined keep their values, their settings are marked as overridden
+func (c *Config) Apply(flags *flag.FlagSet) error {
+	onCommandLine := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+
+	for i, setting := range c.Settings {
+		if flags.Lookup(setting.Name) == nil {
+			return fmt.Errorf("config '%s' has unknown setting '%s' on the %s", c.Path, setting.Name, setting.Source)
+		}
+
+		if onCommandLine[setting.Name] {
+			log.Printf("Flag '-%s' overrides the %s value of the config\n", setting.Name, setting.Source)
+			c.Settings[i].Overridden = true
+			continue
+		}
+
+		if err := flags.Set(setting.Name, setting.Value); err != nil {
+			return fmt.Errorf("config '%s' setting '%s' of the %s: %w", c.Path, setting.Name, setting.Source, err)
+		}
+	}
+
+	for i, setting := range c.Env {
+		if _, ok := os.LookupEnv(setting.Name); ok {
+			c.Env[i].Overridden = true
+			continue
+		}
+
+		if err := os.Setenv(setting.Name, setting.Value); err != nil {
+			return fmt.Errorf("failed to set '%s' from config: %w", setting.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Setting of a flag or environment variable, if the config has one
+func Find(settings []Setting, name string) (Setting, bool) {
+	index := slices.IndexFunc(settings, func(setting Setting) bool {
+		return setting.Name == name
+	})
+	if index < 0 {
+		return Setting{}, false
+	}
+
+	return settings[index], true
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+------------
+Config edits
+------------
+*/
+
+// Indentation of config files that don't show theirs
+const defaultIndent = "  "
+
+// Member of a JSON object found in a config file, by byte offsets
+type jsonMember struct {
+	found      bool
+	valueStart int // Offset of the value of the key, when found
+	valueEnd   int
+	lastEnd    int // End of the value of the last member, -1 for an empty object
+	objectEnd  int // Offset of the closing brace
+}
+
+// Set a setting of a config file, in the base settings or those of a profile, creating its section when missing.
+// The file is edited in place: the order, formatting and other values of the file are kept as they were
+func SetSetting(path string, profile string, name string, value any) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	edited, err := setMember(content, sectionPath(profile, name), value)
+	if err != nil {
+		return fmt.Errorf("failed to edit config '%s': %w", path, err)
+	}
+	if !json.Valid(edited) {
+		return fmt.Errorf("failed to edit config '%s': the edit isn't valid JSON", path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, edited, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+// Keys leading to a setting of the base settings or of a profile
+func sectionPath(profile string, name string) []string {
+	if profile == "" {
+		return []string{"settings", name}
+	}
+	return []string{"profiles", profile, "settings", name}
+}
+
+// Content with the member at keys set to value. Missing objects on the way are created
+func setMember(content []byte, keys []string, value any) ([]byte, error) {
+	objectStart := bytes.IndexByte(content, '{')
+	if objectStart == -1 || strings.TrimSpace(string(content[:objectStart])) != "" {
+		return nil, fmt.Errorf("the file isn't a JSON object")
+	}
+
+	indent := inferIndent(content)
+	for i, key := range keys {
+		member, err := findMember(content, objectStart, key)
+		if err != nil {
+			return nil, err
+		}
+
+		last := i == len(keys)-1
+		if member.found && !last {
+			if content[member.valueStart] != '{' {
+				return nil, fmt.Errorf("'%s' isn't an object", strings.Join(keys[:i+1], "."))
+			}
+			objectStart = member.valueStart
+			continue
+		}
+
+		// Value of the missing key: the setting itself, or the objects leading to it
+		memberValue := value
+		for j := len(keys) - 1; j > i; j-- {
+			memberValue = map[string]any{keys[j]: memberValue}
+		}
+
+		closingIndent := lineIndent(content, member.objectEnd)
+		encoded, err := marshalValue(memberValue, closingIndent+indent, indent)
+		if err != nil {
+			return nil, err
+		}
+		if member.found {
+			return splice(content, member.valueStart, member.valueEnd, encoded), nil
+		}
+
+		line := fmt.Sprintf("\n%s%s%q: %s", closingIndent, indent, key, encoded)
+		if member.lastEnd == -1 {
+			return splice(content, objectStart+1, member.objectEnd, line+"\n"+closingIndent), nil
+		}
+		return splice(content, member.lastEnd, member.lastEnd, ","+line), nil
+	}
+
+	return content, nil
+}
+
+// Find a key of the object starting at objectStart
+func findMember(content []byte, objectStart int, key string) (jsonMember, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content[objectStart:]))
+	if _, err := decoder.Token(); err != nil {
+		return jsonMember{}, err
+	}
+
+	member := jsonMember{lastEnd: -1}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return jsonMember{}, err
+		}
+		raw := json.RawMessage{}
+		if err := decoder.Decode(&raw); err != nil {
+			return jsonMember{}, err
+		}
+
+		end := objectStart + int(decoder.InputOffset())
+		member.lastEnd = end
+		if token == key {
+			member.found, member.valueStart, member.valueEnd = true, end-len(raw), end
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return jsonMember{}, err
+	}
+
+	member.objectEnd = objectStart + int(decoder.InputOffset()) - 1
+	return member, nil
+}
+
+// Value as JSON, its nested lines indented from prefix
+func marshalValue(value any, prefix string, indent string) (string, error) {
+	encoded := bytes.Buffer{}
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent(prefix, indent)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(encoded.String(), "\n"), nil
+}
+
+// Indentation of the first indented line, defaultIndent when there's none
+func inferIndent(content []byte) string {
+	for _, line := range strings.Split(string(content), "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && len(trimmed) < len(line) {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+
+	return defaultIndent
+}
+
+// Leading whitespace of the line holding offset
+func lineIndent(content []byte, offset int) string {
+	lineStart := bytes.LastIndexByte(content[:offset], '\n') + 1
+	line := string(content[lineStart:offset])
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// Content with the bytes from start to end replaced
+func splice(content []byte, start int, end int, replacement string) []byte {
+	edited := append([]byte{}, content[:start]...)
+	edited = append(edited, replacement...)
+	return append(edited, content[end:]...)
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Settings replaced, added and created on the base and on profiles, keeping the rest of the file as it was
+func TestSetMember(t *testing.T) {
+	base := "{\n  \"settings\": {\n    \"model\": \"gpt-4o-mini\",\n    \"rpm\": 100\n  },\n" +
+		"  \"profiles\": {\n    \"prod\": {\n      \"env\": {\"A\": \"b\"}\n    }\n  }\n}\n"
+
+	cases := []struct {
+		name    string
+		content string
+		profile string
+		setting string
+		value   any
+		want    string
+	}{
+		{
+			name: "replace", content: base, setting: "model", value: "gpt-4o",
+			want: strings.Replace(base, `"gpt-4o-mini"`, `"gpt-4o"`, 1),
+		},
+		{
+			name: "add", content: base, setting: "dataset-description", value: "Daily sales <per store>.",
+			want: strings.Replace(base, "\"rpm\": 100\n", "\"rpm\": 100,\n    \"dataset-description\": \"Daily sales <per store>.\"\n", 1),
+		},
+		{
+			name: "new profile section", content: base, profile: "prod", setting: "rpm", value: 500,
+			want: strings.Replace(base, "{\"A\": \"b\"}\n", "{\"A\": \"b\"},\n      \"settings\": {\n        \"rpm\": 500\n      }\n", 1),
+		},
+		{
+			name: "new profile", content: base, profile: "dev", setting: "rpm", value: 10,
+			want: strings.Replace(base, "    }\n  }\n}", "    },\n    \"dev\": {\n      \"settings\": {\n        \"rpm\": 10\n      }\n    }\n  }\n}", 1),
+		},
+		{
+			name: "empty file", content: "{}", setting: "model", value: "gpt-4o",
+			want: "{\n  \"settings\": {\n    \"model\": \"gpt-4o\"\n  }\n}",
+		},
+		{
+			name: "tab indented", content: "{\n\t\"settings\": {}\n}\n", setting: "rpm", value: 5,
+			want: "{\n\t\"settings\": {\n\t\t\"rpm\": 5\n\t}\n}\n",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			edited, err := setMember([]byte(c.content), sectionPath(c.profile, c.setting), c.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(edited) != c.want {
+				t.Fatalf("edited to %q, expected %q", edited, c.want)
+			}
+
+			file := File{}
+			if err := json.Unmarshal(edited, &file); err != nil {
+				t.Fatalf("edit doesn't decode: %s", err)
+			}
+			section := file.Section
+			if c.profile != "" {
+				section = file.Profiles[c.profile]
+			}
+			if fmt.Sprint(section.Settings[c.setting]) != fmt.Sprint(c.value) {
+				t.Fatalf("setting is %v after the edit, expected %v", section.Settings[c.setting], c.value)
+			}
+		})
+	}
+}
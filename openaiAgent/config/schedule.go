@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+-----------------
+Scheduled reports
+-----------------
+*/
+
+// Output formats of scheduled reports
+const (
+	ScheduleFormatMarkdown = "markdown"
+	ScheduleFormatJSON     = "json"
+)
+
+// Cadence of reports run every day. Other reports run weekly, on the weekday they name
+const ScheduleEveryDay = "day"
+
+// Report answered on a schedule, listed on the "schedule" section of a config file. Either a question
+// for the agent or the name of a saved report, run again on the current data
+type ScheduledReport struct {
+	Name        string   `json:"name"`                  // Unique, names the dated outputs
+	Question    string   `json:"question,omitempty"`    // Question asked to the agent
+	Report      string   `json:"report,omitempty"`      // Saved report rendered instead of a question
+	Every       string   `json:"every"`                 // "day" or a weekday, like "monday"
+	At          string   `json:"at,omitempty"`          // Local time of day it's due from, like "07:30". Defaults to midnight
+	Format      string   `json:"format,omitempty"`      // ScheduleFormatMarkdown or ScheduleFormatJSON. Defaults to markdown
+	Destination string   `json:"destination,omitempty"` // Directory of the dated outputs, relative to the workspace. Defaults to the schedule directory
+	Publish     bool     `json:"publish,omitempty"`     // Also publish the outputs with the artifact publisher
+	Recipients  []string `json:"recipients,omitempty"`  // Who the outputs are meant for. Recorded on the manifests, nothing is sent yet
+}
+
+// Check the reports of a schedule, filling their defaults
+func validateSchedule(path string, schedule []ScheduledReport) error {
+	names := map[string]bool{}
+	for i := range schedule {
+		report := &schedule[i]
+		if report.Name == "" || strings.ContainsAny(report.Name, `/\`) {
+			return fmt.Errorf("config '%s' scheduled report %d has an invalid name '%s'", path, i+1, report.Name)
+		}
+		if names[report.Name] {
+			return fmt.Errorf("config '%s' schedules report '%s' twice", path, report.Name)
+		}
+		names[report.Name] = true
+
+		if (report.Question == "") == (report.Report == "") {
+			return fmt.Errorf("config '%s' scheduled report '%s' needs either a question or a saved report", path, report.Name)
+		}
+
+		report.Every = strings.ToLower(report.Every)
+		if _, ok := scheduleWeekday(report.Every); !ok && report.Every != ScheduleEveryDay {
+			return fmt.Errorf("config '%s' scheduled report '%s' runs every '%s', expected 'day' or a weekday", path, report.Name, report.Every)
+		}
+
+		if report.At == "" {
+			report.At = "00:00"
+		}
+		if _, err := time.Parse("15:04", report.At); err != nil {
+			return fmt.Errorf("config '%s' scheduled report '%s' is at '%s', expected a time like 07:30", path, report.Name, report.At)
+		}
+
+		if report.Format == "" {
+			report.Format = ScheduleFormatMarkdown
+		}
+		if !slices.Contains([]string{ScheduleFormatMarkdown, ScheduleFormatJSON}, report.Format) {
+			return fmt.Errorf("config '%s' scheduled report '%s' has unknown format '%s'", path, report.Name, report.Format)
+		}
+	}
+
+	return nil
+}
+
+// Weekday named by a cadence, like "monday"
+func scheduleWeekday(every string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.ToLower(day.String()) == every {
+			return day, true
+		}
+	}
+
+	return 0, false
+}
+
+// Latest time up to now the report was due, in the location of now
+func (r ScheduledReport) LastSlot(now time.Time) time.Time {
+	at, _ := time.Parse("15:04", r.At)
+	slot := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	if slot.After(now) {
+		slot = slot.AddDate(0, 0, -1)
+	}
+
+	if weekday, ok := scheduleWeekday(r.Every); ok {
+		slot = slot.AddDate(0, 0, -((int(slot.Weekday()) - int(weekday) + 7) % 7))
+	}
+	return slot
+}
+
+// Whether the report is due at now, given when it last succeeded. Reports that never ran are due
+func (r ScheduledReport) Due(lastSuccess time.Time, now time.Time) bool {
+	return lastSuccess.Before(r.LastSlot(now))
+}
+
+// Source of the report, for listing
+func (r ScheduledReport) Source() string {
+	if r.Report != "" {
+		return fmt.Sprintf("saved report '%s'", r.Report)
+	}
+	return fmt.Sprintf("question %q", r.Question)
+}
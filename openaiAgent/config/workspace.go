@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+/*
+---------
+Workspace
+---------
+*/
+
+// Environment variable setting the workspace root when no -workspace flag is given
+const WorkspaceEnv = "AGENT_WORKSPACE"
+
+// Where the workspace root came from, in precedence order
+const (
+	WorkspaceFromFlag = "flag"
+	WorkspaceFromEnv  = "env"
+	WorkspaceFromGit  = "git"
+	WorkspaceFromCwd  = "cwd"
+)
+
+// Paths with a scheme, like s3://bucket/data.parquet or md:analytics, aren't files of the workspace.
+// Single letter schemes are Windows drives, not URLs
+var schemePrefix = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]+:`)
+
+// Root relative paths of files read and written are resolved against
+type Workspace struct {
+	Root   string // Absolute directory
+	Source string // One of the WorkspaceFrom* constants
+}
+
+var (
+	workspaceMutex sync.Mutex
+	workspace      *Workspace
+)
+
+// Find the workspace root from dir. An explicit root wins, then the env one, relative ones taken from dir.
+// Otherwise it's the nearest directory from dir up to its git root holding marker, or the git root itself
+// when none does or marker is empty. Outside a git repository it's dir. The executable location plays no part,
+// so `go run`, installed binaries and nested working directories all find the same root
+func FindWorkspace(explicit string, env string, dir string, marker string) (Workspace, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return Workspace{}, err
+	}
+
+	for _, candidate := range []Workspace{{explicit, WorkspaceFromFlag}, {env, WorkspaceFromEnv}} {
+		if candidate.Root == "" {
+			continue
+		}
+
+		root := candidate.Root
+		if !filepath.IsAbs(root) {
+			root = filepath.Join(dir, root)
+		}
+		info, err := os.Stat(root)
+		if err != nil || !info.IsDir() {
+			return Workspace{}, fmt.Errorf("workspace '%s' set by %s isn't a directory", candidate.Root, candidate.Source)
+		}
+		return Workspace{Root: filepath.Clean(root), Source: candidate.Source}, nil
+	}
+
+	markerDir := ""
+	for current := dir; ; current = filepath.Dir(current) {
+		if marker != "" && markerDir == "" && exists(filepath.Join(current, marker)) {
+			markerDir = current
+		}
+		if exists(filepath.Join(current, ".git")) {
+			if markerDir == "" {
+				markerDir = current
+			}
+			return Workspace{Root: markerDir, Source: WorkspaceFromGit}, nil
+		}
+		if filepath.Dir(current) == current {
+			break
+		}
+	}
+
+	return Workspace{Root: dir, Source: WorkspaceFromCwd}, nil
+}
+
+// Whether a file or directory exists
+func exists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+// Find the workspace of the process from the -workspace flag value, WorkspaceEnv and the working directory,
+// and resolve paths against it from now on. See FindWorkspace
+func SetupWorkspace(explicit string, marker string) (Workspace, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return Workspace{}, fmt.Errorf("failed to get the working directory: %w", err)
+	}
+
+	found, err := FindWorkspace(explicit, os.Getenv(WorkspaceEnv), cwd, marker)
+	if err != nil {
+		return Workspace{}, err
+	}
+
+	workspaceMutex.Lock()
+	defer workspaceMutex.Unlock()
+	workspace = &found
+	return found, nil
+}
+
+// Workspace of the process. Without SetupWorkspace it's found on first use, with no flag nor marker
+func CurrentWorkspace() Workspace {
+	workspaceMutex.Lock()
+	defer workspaceMutex.Unlock()
+
+	if workspace == nil {
+		cwd, _ := os.Getwd()
+		found, err := FindWorkspace("", os.Getenv(WorkspaceEnv), cwd, "")
+		if err != nil {
+			found = Workspace{Root: cwd, Source: WorkspaceFromCwd}
+		}
+		workspace = &found
+	}
+
+	return *workspace
+}
+
+// Resolve a path against the workspace root. Empty paths, absolute paths and paths with a scheme,
+// like s3:// URLs or md: DSNs, are returned as they are
+func ResolvePath(p string) string {
+	return CurrentWorkspace().Resolve(p)
+}
+
+// Resolve a path against the root, see ResolvePath
+func (w Workspace) Resolve(p string) string {
+	if p == "" || filepath.IsAbs(p) || schemePrefix.MatchString(p) {
+		return p
+	}
+
+	return filepath.Join(w.Root, p)
+}
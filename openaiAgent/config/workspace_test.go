@@ -0,0 +1,89 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Marker file of the agent module on the test layout, like tools.DefaultToolsJsonPath
+const workspaceMarker = "data/tools.json"
+
+// Directories of the temporary repository layout, the marker file is added to the agent module
+var workspaceLayout = []string{"repo/.git", "repo/openaiAgent/bin/v1", "repo/openaiAgent/cmd/agent", "repo/openaiChat/src", "outside"}
+
+// Roots found from the invocations that used to break, on a temporary repository layout. The executable
+// location plays no part, so only the working directory, flag and env of each invocation are given
+func TestFindWorkspace(t *testing.T) {
+	base := t.TempDir()
+	for _, dir := range workspaceLayout {
+		if err := os.MkdirAll(filepath.Join(base, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	markerPath := filepath.Join(base, "repo/openaiAgent", workspaceMarker)
+	if err := os.MkdirAll(filepath.Dir(markerPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(markerPath, []byte("[]"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		dir      string // Working directory
+		explicit string // -workspace flag value
+		env      string // AGENT_WORKSPACE value
+		root     string // Expected root, empty when finding it must fail
+		source   string
+	}{
+		{name: "run.sh binary", dir: "repo/openaiAgent/bin/v1", root: "repo/openaiAgent", source: WorkspaceFromGit},
+		{name: "go run", dir: "repo/openaiAgent", root: "repo/openaiAgent", source: WorkspaceFromGit},
+		{name: "nested directory", dir: "repo/openaiAgent/cmd/agent", root: "repo/openaiAgent", source: WorkspaceFromGit},
+		{name: "installed binary", dir: "repo/openaiAgent/cmd", root: "repo/openaiAgent", source: WorkspaceFromGit},
+		{name: "installed binary outside the marker", dir: "repo/openaiChat/src", root: "repo", source: WorkspaceFromGit},
+		{name: "outside a repository", dir: "outside", root: "outside", source: WorkspaceFromCwd},
+		{name: "relative flag", dir: "outside", explicit: "../repo/openaiAgent", root: "repo/openaiAgent", source: WorkspaceFromFlag},
+		{name: "env", dir: "repo/openaiChat", env: "repo/openaiAgent", root: "repo/openaiAgent", source: WorkspaceFromEnv},
+		{name: "flag over env", dir: "outside", explicit: "../repo/openaiChat", env: "repo/openaiAgent", root: "repo/openaiChat", source: WorkspaceFromFlag},
+		{name: "missing flag directory", dir: "outside", explicit: "missing"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			env := c.env
+			if env != "" {
+				env = filepath.Join(base, env)
+			}
+
+			workspace, err := FindWorkspace(c.explicit, env, filepath.Join(base, c.dir), workspaceMarker)
+			if c.root == "" {
+				if err == nil {
+					t.Fatalf("found root %s, expected an error", workspace.Root)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			root := filepath.Join(base, c.root)
+			if workspace.Root != root || workspace.Source != c.source {
+				t.Fatalf("found %s from %s, expected %s from %s", workspace.Root, workspace.Source, root, c.source)
+			}
+
+			// Relative paths resolve against the root, absolute ones and URLs stay as they are
+			for path, want := range map[string]string{
+				"data/sales.parquet":   filepath.Join(root, "data/sales.parquet"),
+				"/var/data.parquet":    "/var/data.parquet",
+				"s3://bucket/data.csv": "s3://bucket/data.csv",
+				"md:analytics":         "md:analytics",
+				"runs":                 filepath.Join(root, "runs"),
+			} {
+				if resolved := workspace.Resolve(path); resolved != want {
+					t.Errorf("resolved '%s' to %s, expected %s", path, resolved, want)
+				}
+			}
+		})
+	}
+}
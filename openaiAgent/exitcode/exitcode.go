@@ -0,0 +1,153 @@
+// Package exitcode maps the errors ending the agent and chat binaries to the exit codes and error classes scripts
+// wrapping them rely on, and formats them for stderr.
+package exitcode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/openai/openai-go"
+)
+
+/*
+-------------
+Error classes
+-------------
+*/
+
+// Kind of failure ending a binary. Each one exits with its own code
+type Class string
+
+const (
+	ClassError       Class = "error"         // Anything not classified, like a failed check
+	ClassUsage       Class = "usage"         // Bad command line: missing arguments, unknown subcommands or invalid flag values
+	ClassConfig      Class = "configuration" // Config files, environment, models or settings that can't be used
+	ClassUpstream    Class = "upstream_api"  // The OpenAI or Anthropic API failed or answered unusably
+	ClassData        Class = "data"          // The dataset, a query, a tool or a stored file failed
+	ClassBudget      Class = "budget"        // A run went over its router calls, deadline or API quota
+	ClassInterrupted Class = "interrupted"   // Ctrl+C or SIGTERM
+)
+
+// Exit code of each class. Success exits with 0
+var codes = map[Class]int{
+	ClassError:       1,
+	ClassUsage:       2,
+	ClassConfig:      3,
+	ClassUpstream:    4,
+	ClassData:        5,
+	ClassBudget:      6,
+	ClassInterrupted: 130,
+}
+
+// Exit code of the class, 1 for unknown ones
+func (c Class) Code() int {
+	if code, ok := codes[c]; ok {
+		return code
+	}
+	return codes[ClassError]
+}
+
+// Implemented by errors that know their class, like the tool errors or completion.ErrModelRefusal
+type classified interface {
+	ExitClass() Class
+}
+
+// Error given a class where it's detected, like a missing argument
+type Error struct {
+	Class Class
+	Err   error
+}
+
+func (e *Error) Error() string    { return e.Err.Error() }
+func (e *Error) Unwrap() error    { return e.Err }
+func (e *Error) ExitClass() Class { return e.Class }
+
+// Give an error a class, over the one it had. Nil stays nil
+func New(class Class, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Class: class, Err: err}
+}
+
+// Error of a class formatted like fmt.Errorf
+func Errorf(class Class, format string, args ...any) error {
+	return &Error{Class: class, Err: fmt.Errorf(format, args...)}
+}
+
+// Give an error a class unless it already has one. Nil stays nil
+func Default(class Class, err error) error {
+	if err == nil || Classify(err) != ClassError {
+		return err
+	}
+	return New(class, err)
+}
+
+// Class of an error. Interrupts win over everything, so a tool cancelled by Ctrl+C is an interruption.
+// Then API quota errors, the class of the outermost classified error, run deadlines, and API and network
+// errors. Anything else is ClassError
+func Classify(err error) Class {
+	var known classified
+	var apiErr *openai.Error
+	var urlErr *url.Error
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ClassInterrupted
+	case errors.As(err, &apiErr) && apiErr.Code == "insufficient_quota":
+		return ClassBudget
+	case errors.As(err, &known):
+		return known.ExitClass()
+	case errors.Is(err, context.DeadlineExceeded):
+		return ClassBudget
+	case errors.As(err, &apiErr), errors.As(err, &urlErr), errors.As(err, &opErr), errors.As(err, &dnsErr):
+		return ClassUpstream
+	}
+
+	return ClassError
+}
+
+/*
+-------------
+Error reports
+-------------
+*/
+
+// Formats errors are printed on
+const (
+	FormatText = "text" // The message, as the binaries always printed it
+	FormatJSON = "json" // One Report line
+)
+
+// Error printed on stderr with FormatJSON
+type Report struct {
+	Code    int    `json:"code"`
+	Class   Class  `json:"class"`
+	Message string `json:"message"`
+}
+
+// Report of an error, with the code and class it exits with
+func NewReport(err error) Report {
+	class := Classify(err)
+	return Report{Code: class.Code(), Class: class, Message: err.Error()}
+}
+
+// Report as one JSON line
+func (r Report) JSON() string {
+	// Strings and ints always marshal
+	jsonBytes, _ := json.Marshal(r)
+	return string(jsonBytes)
+}
+
+// Check an error format flag value
+func ValidateFormat(format string) error {
+	if format != FormatText && format != FormatJSON {
+		return Errorf(ClassUsage, "unknown error format '%s', expected '%s' or '%s'", format, FormatText, FormatJSON)
+	}
+	return nil
+}
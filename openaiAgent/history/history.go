@@ -0,0 +1,265 @@
+// Package history holds the conversation history format shared by openaiChat and the agent,
+// so a conversation started on one can be continued on the other.
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+/*
+-------------------
+History file format
+-------------------
+*/
+
+// Roles understood by the chat
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"     // Result of a tool call, answering ToolCallID
+	RoleFunction  = "function" // Result of a deprecated function call, answering the function Name
+)
+
+// Versions of the history file format
+const (
+	Version1       = 1 // Role and content only. Version 1 files have no version field
+	Version2       = 2 // Adds tool calls, tool results and function messages
+	CurrentVersion = Version2
+)
+
+// Max length of a tool result kept in its collapsed summary
+const maxToolSummaryLength = 500
+
+// Simple message structure. Besides role and content, fields are only set on the messages of their role
+type ChatMessage struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCallID string     `json:"toolCallId,omitempty"` // Tool call a tool message answers
+	Name       string     `json:"name,omitempty"`       // Function a function message answers
+	ToolCalls  []ToolCall `json:"toolCalls,omitempty"`  // Tools called by an assistant message
+	Variants   []string   `json:"variants,omitempty"`   // Answers an assistant message replaced when regenerated, oldest first
+}
+
+// Tool call made by an assistant message
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`      // Function called
+	Arguments string `json:"arguments"` // JSON arguments, as the model wrote them
+}
+
+// Simple conversation structure for saving history to json.
+// Version is 0 for version 1 files, saved back without it
+type ConversationHistory struct {
+	Version   int            `json:"version,omitempty"`
+	TimeStamp string         `json:"timeStamp"`
+	Messages  []*ChatMessage `json:"messages"`
+}
+
+// Create a history of messages timestamped now, on the current version
+func New(messages []*ChatMessage) ConversationHistory {
+	return ConversationHistory{
+		Version:   CurrentVersion,
+		TimeStamp: time.Now().Format("2006-01-02T15:04:05"),
+		Messages:  messages,
+	}
+}
+
+// Load a conversation history from a json file
+func Load(historyPath string) (ConversationHistory, error) {
+	history := ConversationHistory{}
+	jsonFile, err := os.Open(historyPath)
+	if err != nil {
+		return history, err
+	}
+	defer jsonFile.Close()
+
+	if err := json.NewDecoder(jsonFile).Decode(&history); err != nil {
+		return history, fmt.Errorf("failed to decode history: %w", err)
+	}
+
+	return history, history.migrate()
+}
+
+// Bring a loaded history to the current version
+func (h *ConversationHistory) migrate() error {
+	switch h.Version {
+	case 0:
+		// Version 1 messages are valid version 2 messages, they're kept untouched
+		// so saving the history again writes the same file
+		return nil
+	case Version2:
+		return nil
+	}
+
+	return fmt.Errorf("unsupported history version %d, expected at most %d", h.Version, CurrentVersion)
+}
+
+// Save the conversation history to a json file
+func (h ConversationHistory) Save(historyPath string) error {
+	jsonBytes, err := h.marshal()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(historyPath, jsonBytes, 0o644)
+}
+
+// JSON of the history file
+func (h ConversationHistory) marshal() ([]byte, error) {
+	return json.MarshalIndent(h, "", "  ")
+}
+
+/*
+---------------------------
+Conversion to OpenAI format
+---------------------------
+*/
+
+// Convert a history message to an openai message based on its role
+func MessageParam(message *ChatMessage) (openai.ChatCompletionMessageParamUnion, error) {
+	switch message.Role {
+	case RoleSystem:
+		return openai.SystemMessage(message.Content), nil
+	case RoleAssistant:
+		if len(message.ToolCalls) != 0 {
+			return assistantToolCallsParam(message), nil
+		}
+		return openai.AssistantMessage(message.Content), nil
+	case RoleUser:
+		return openai.UserMessage(message.Content), nil
+	case RoleTool:
+		if message.ToolCallID == "" {
+			return nil, fmt.Errorf("tool message without a tool call ID")
+		}
+		return openai.ToolMessage(message.ToolCallID, message.Content), nil
+	case RoleFunction:
+		if message.Name == "" {
+			return nil, fmt.Errorf("function message without a function name")
+		}
+		return openai.FunctionMessage(message.Name, message.Content), nil
+	}
+
+	return nil, fmt.Errorf("invalid message role: %s", message.Role)
+}
+
+// Assistant message calling tools. Its content is optional, like on the responses calling tools
+func assistantToolCallsParam(message *ChatMessage) openai.ChatCompletionAssistantMessageParam {
+	toolCalls := []openai.ChatCompletionMessageToolCallParam{}
+	for _, toolCall := range message.ToolCalls {
+		toolCalls = append(toolCalls, openai.ChatCompletionMessageToolCallParam{
+			ID:   openai.F(toolCall.ID),
+			Type: openai.F(openai.ChatCompletionMessageToolCallTypeFunction),
+			Function: openai.F(openai.ChatCompletionMessageToolCallFunctionParam{
+				Name:      openai.F(toolCall.Name),
+				Arguments: openai.F(toolCall.Arguments),
+			}),
+		})
+	}
+
+	param := openai.ChatCompletionAssistantMessageParam{
+		Role:      openai.F(openai.ChatCompletionAssistantMessageParamRoleAssistant),
+		ToolCalls: openai.F(toolCalls),
+	}
+	if message.Content != "" {
+		param.Content = openai.F([]openai.ChatCompletionAssistantMessageParamContentUnion{openai.TextPart(message.Content)})
+	}
+
+	return param
+}
+
+// Convert every history message to openai messages. Messages with unknown roles are skipped
+func (h ConversationHistory) MessageParams() []openai.ChatCompletionMessageParamUnion {
+	messages := []openai.ChatCompletionMessageParamUnion{}
+	for _, message := range h.Messages {
+		if param, err := MessageParam(message); err == nil {
+			messages = append(messages, param)
+		}
+	}
+
+	return messages
+}
+
+// Convert openai messages to history messages. Tool calls and tool results, which the chat
+// has no tools to answer, are collapsed into assistant messages summarizing them
+func FromMessageParams(messages []openai.ChatCompletionMessageParamUnion) []*ChatMessage {
+	historyMessages := []*ChatMessage{}
+	toolNames := map[string]string{}
+	for _, message := range messages {
+		switch m := message.(type) {
+		case openai.ChatCompletionSystemMessageParam:
+			historyMessages = append(historyMessages, &ChatMessage{Role: RoleSystem, Content: MessageText(m)})
+		case openai.ChatCompletionUserMessageParam:
+			historyMessages = append(historyMessages, &ChatMessage{Role: RoleUser, Content: MessageText(m)})
+		case openai.ChatCompletionAssistantMessageParam:
+			historyMessages = append(historyMessages, &ChatMessage{Role: RoleAssistant, Content: MessageText(m)})
+		case openai.ChatCompletionMessage:
+			summaries := []string{}
+			for _, toolCall := range m.ToolCalls {
+				toolNames[toolCall.ID] = toolCall.Function.Name
+				summaries = append(summaries, fmt.Sprintf("[Called tool %s with %s]", toolCall.Function.Name, toolCall.Function.Arguments))
+			}
+			if m.Content != "" {
+				summaries = append([]string{m.Content}, summaries...)
+			}
+			historyMessages = append(historyMessages, &ChatMessage{Role: RoleAssistant, Content: strings.Join(summaries, "\n")})
+		case openai.ChatCompletionToolMessageParam:
+			result := MessageText(m)
+			if len(result) > maxToolSummaryLength {
+				result = result[:maxToolSummaryLength] + "..."
+			}
+			summary := fmt.Sprintf("[Tool %s returned]\n%s", toolNames[m.ToolCallID.Value], result)
+			historyMessages = append(historyMessages, &ChatMessage{Role: RoleAssistant, Content: summary})
+		}
+	}
+
+	return historyMessages
+}
+
+// Join the text of content parts, ignoring non text parts
+func joinTextParts(parts []openai.ChatCompletionContentPartTextParam) string {
+	texts := []string{}
+	for _, part := range parts {
+		texts = append(texts, part.Text.Value)
+	}
+
+	return strings.Join(texts, "\n")
+}
+
+// Extract the text content of a conversation message
+func MessageText(message openai.ChatCompletionMessageParamUnion) string {
+	switch m := message.(type) {
+	case openai.ChatCompletionSystemMessageParam:
+		return joinTextParts(m.Content.Value)
+	case openai.ChatCompletionToolMessageParam:
+		return joinTextParts(m.Content.Value)
+	case openai.ChatCompletionUserMessageParam:
+		parts := []openai.ChatCompletionContentPartTextParam{}
+		for _, part := range m.Content.Value {
+			if text, ok := part.(openai.ChatCompletionContentPartTextParam); ok {
+				parts = append(parts, text)
+			}
+		}
+		return joinTextParts(parts)
+	case openai.ChatCompletionAssistantMessageParam:
+		parts := []openai.ChatCompletionContentPartTextParam{}
+		for _, part := range m.Content.Value {
+			if text, ok := part.(openai.ChatCompletionContentPartTextParam); ok {
+				parts = append(parts, text)
+			}
+		}
+		return joinTextParts(parts)
+	case openai.ChatCompletionFunctionMessageParam:
+		return m.Content.Value
+	case openai.ChatCompletionMessage:
+		return m.Content
+	}
+
+	return ""
+}
@@ -0,0 +1,72 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Directory of the sample history files, one per version
+const samplesDir = "../testdata/history"
+
+// Sample history files, in name order
+func historySamples(t *testing.T) []string {
+	t.Helper()
+	samples, err := filepath.Glob(filepath.Join(samplesDir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) == 0 {
+		t.Fatalf("expected history samples on %s", samplesDir)
+	}
+	return samples
+}
+
+// Each sample survives being loaded and saved: saving it gives back the same JSON, and so does loading and saving
+// that again. Every message also converts to an openai message
+func TestSamplesRoundTrip(t *testing.T) {
+	for _, samplePath := range historySamples(t) {
+		t.Run(filepath.Base(samplePath), func(t *testing.T) {
+			original, err := os.ReadFile(samplePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			loaded, err := Load(samplePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, message := range loaded.Messages {
+				if _, err := MessageParam(message); err != nil {
+					t.Errorf("message %d: %s", i, err)
+				}
+			}
+
+			saved, err := loaded.marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(saved, original) {
+				t.Fatal("saving the loaded history changes its JSON")
+			}
+
+			reloaded := ConversationHistory{}
+			if err := json.Unmarshal(saved, &reloaded); err != nil {
+				t.Fatalf("failed to decode saved history: %s", err)
+			}
+			if err := reloaded.migrate(); err != nil {
+				t.Fatal(err)
+			}
+
+			savedAgain, err := reloaded.marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(savedAgain, saved) {
+				t.Error("saving the reloaded history changes its JSON")
+			}
+		})
+	}
+}
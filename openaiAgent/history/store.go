@@ -0,0 +1,355 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+)
+
+/*
+---------------
+History storage
+---------------
+*/
+
+// Storage formats of a history file
+const (
+	FormatJSON  = "json"  // One JSON document, rewritten on every change
+	FormatJSONL = "jsonl" // Append only event log, one JSON line per change
+)
+
+// Types of the lines of a JSONL history
+const (
+	eventSession = "session" // Header line, first of the file
+	eventMessage = "message" // Message added to the conversation
+	eventUndo    = "undo"    // Removal of the last message, like the answers <regenerate> replaces
+)
+
+// Storage of a conversation history. Every change is written when it's made, so a crash loses nothing.
+// Satisfied by JSONStore and JSONLStore
+type Store interface {
+	Path() string
+	Load() (ConversationHistory, error)
+	Save(history ConversationHistory) error // Replace the stored history
+	Append(message *ChatMessage) error      // Add a message at the end
+	Undo() error                            // Remove the last message
+}
+
+// A JSONL history has a line that can't be replayed
+type ErrCorruptHistory struct {
+	Path   string
+	Line   int
+	Reason string
+}
+
+func (e *ErrCorruptHistory) Error() string {
+	return fmt.Sprintf("history %s is corrupt at line %d: %s", e.Path, e.Line, e.Reason)
+}
+func (e *ErrCorruptHistory) ExitClass() exitcode.Class { return exitcode.ClassData }
+
+// Open the store of a history file. Empty format picks FormatJSONL for .jsonl files and FormatJSON otherwise
+func OpenStore(historyPath string, format string) (Store, error) {
+	if format == "" && strings.EqualFold(filepath.Ext(historyPath), ".jsonl") {
+		format = FormatJSONL
+	}
+
+	switch format {
+	case "", FormatJSON:
+		return &JSONStore{path: historyPath}, nil
+	case FormatJSONL:
+		return &JSONLStore{path: historyPath}, nil
+	}
+
+	return nil, fmt.Errorf("unknown history format '%s', expected '%s' or '%s'", format, FormatJSON, FormatJSONL)
+}
+
+// Copy the history of one store to another, like from a JSON file to a JSONL one.
+// Messages undone on a JSONL history aren't copied
+func Convert(from Store, to Store) error {
+	history, err := from.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", from.Path(), err)
+	}
+	if err := to.Save(history); err != nil {
+		return fmt.Errorf("failed to save %s: %w", to.Path(), err)
+	}
+
+	return nil
+}
+
+/*
+----------
+JSON store
+----------
+*/
+
+// History file rewritten as a whole on every change, the format of Load and ConversationHistory.Save
+type JSONStore struct {
+	path    string
+	history *ConversationHistory // Last history loaded or saved, nil until then
+}
+
+func (s *JSONStore) Path() string {
+	return s.path
+}
+
+func (s *JSONStore) Load() (ConversationHistory, error) {
+	history, err := Load(s.path)
+	if err != nil {
+		return history, err
+	}
+
+	s.history = &history
+	return history, nil
+}
+
+func (s *JSONStore) Save(history ConversationHistory) error {
+	if err := history.Save(s.path); err != nil {
+		return err
+	}
+
+	s.history = &history
+	return nil
+}
+
+// Add a message to the loaded history and rewrite the file. A missing file starts a new history
+func (s *JSONStore) Append(message *ChatMessage) error {
+	if s.history == nil {
+		if _, err := s.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if s.history == nil {
+		history := New(nil)
+		s.history = &history
+	}
+
+	history := *s.history
+	history.Messages = append(history.Messages, message)
+	return s.Save(history)
+}
+
+func (s *JSONStore) Undo() error {
+	if s.history == nil {
+		if _, err := s.Load(); err != nil {
+			return err
+		}
+	}
+	if len(s.history.Messages) == 0 {
+		return fmt.Errorf("history %s has no message to undo", s.path)
+	}
+
+	history := *s.history
+	history.Messages = history.Messages[:len(history.Messages)-1]
+	return s.Save(history)
+}
+
+/*
+-----------
+JSONL store
+-----------
+*/
+
+// Append only history, a session header line followed by one line per message added or undone.
+// Loading replays the lines, and Compact drops the undone ones
+type JSONLStore struct {
+	path string
+}
+
+// Line of a JSONL history. Message lines carry the message fields, the session line the history version
+type historyEvent struct {
+	Type      string `json:"type"`
+	TimeStamp string `json:"timeStamp"`
+	Version   int    `json:"version,omitempty"`
+	*ChatMessage
+}
+
+func (s *JSONLStore) Path() string {
+	return s.path
+}
+
+// Session header and messages left after replaying the lines of the file, with the time they were added
+func (s *JSONLStore) replay() (historyEvent, []historyEvent, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return historyEvent{}, nil, err
+	}
+	defer file.Close()
+
+	header := historyEvent{}
+	messages := []historyEvent{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		event := historyEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return header, nil, &ErrCorruptHistory{Path: s.path, Line: line, Reason: err.Error()}
+		}
+		if (line == 1) != (event.Type == eventSession) {
+			return header, nil, &ErrCorruptHistory{Path: s.path, Line: line, Reason: "the session line must be the first one"}
+		}
+
+		switch event.Type {
+		case eventSession:
+			header = event
+		case eventMessage:
+			if event.ChatMessage == nil {
+				return header, nil, &ErrCorruptHistory{Path: s.path, Line: line, Reason: "message line without a message"}
+			}
+			messages = append(messages, event)
+		case eventUndo:
+			if len(messages) == 0 {
+				return header, nil, &ErrCorruptHistory{Path: s.path, Line: line, Reason: "undo without a message"}
+			}
+			messages = messages[:len(messages)-1]
+		default:
+			return header, nil, &ErrCorruptHistory{Path: s.path, Line: line, Reason: fmt.Sprintf("unknown line type '%s'", event.Type)}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return header, nil, err
+	}
+	if header.Type == "" {
+		return header, nil, &ErrCorruptHistory{Path: s.path, Line: 1, Reason: "missing session line"}
+	}
+
+	return header, messages, nil
+}
+
+func (s *JSONLStore) Load() (ConversationHistory, error) {
+	header, events, err := s.replay()
+	if err != nil {
+		return ConversationHistory{}, err
+	}
+
+	history := ConversationHistory{Version: header.Version, TimeStamp: header.TimeStamp, Messages: []*ChatMessage{}}
+	for _, event := range events {
+		history.Messages = append(history.Messages, event.ChatMessage)
+	}
+
+	return history, history.migrate()
+}
+
+// Rewrite the file with the history messages, timestamped now
+func (s *JSONLStore) Save(history ConversationHistory) error {
+	events := []historyEvent{}
+	for _, message := range history.Messages {
+		events = append(events, newHistoryEvent(eventMessage, message))
+	}
+
+	return s.rewrite(historyEvent{Type: eventSession, TimeStamp: history.TimeStamp, Version: history.Version}, events)
+}
+
+// Write the header and message lines to a temporary file replacing the history, so a failed
+// rewrite leaves the previous file untouched
+func (s *JSONLStore) rewrite(header historyEvent, events []historyEvent) error {
+	lines := []byte{}
+	for _, event := range append([]historyEvent{header}, events...) {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	tempPath := s.path + ".tmp"
+	if err := os.WriteFile(tempPath, lines, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, s.path)
+}
+
+// Append a message line. A missing file is started with a session line
+func (s *JSONLStore) Append(message *ChatMessage) error {
+	return s.appendEvent(newHistoryEvent(eventMessage, message))
+}
+
+// Append an undo line. Fails when the history has no message to undo
+func (s *JSONLStore) Undo() error {
+	_, messages, err := s.replay()
+	if err != nil {
+		return err
+	}
+	if len(messages) == 0 {
+		return fmt.Errorf("history %s has no message to undo", s.path)
+	}
+
+	return s.appendEvent(newHistoryEvent(eventUndo, nil))
+}
+
+// Write one line at the end of the file
+func (s *JSONLStore) appendEvent(event historyEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(s.path); errors.Is(err, os.ErrNotExist) {
+		header := New(nil)
+		if err := s.rewrite(historyEvent{Type: eventSession, TimeStamp: header.TimeStamp, Version: header.Version}, nil); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// One write per line, so a crash can only cut the last one
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// Rewrite the file without undone messages and undo lines, keeping the time of the messages left.
+// Returns the lines dropped
+func (s *JSONLStore) Compact() (int, error) {
+	header, messages, err := s.replay()
+	if err != nil {
+		return 0, err
+	}
+	lines, err := countLines(s.path)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.rewrite(header, messages); err != nil {
+		return 0, err
+	}
+	return lines - 1 - len(messages), nil
+}
+
+// Event timestamped now
+func newHistoryEvent(eventType string, message *ChatMessage) historyEvent {
+	return historyEvent{Type: eventType, TimeStamp: time.Now().Format("2006-01-02T15:04:05"), ChatMessage: message}
+}
+
+// Non blank lines of a file
+func countLines(path string) (int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	lines := 0
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines++
+		}
+	}
+
+	return lines, nil
+}
@@ -0,0 +1,118 @@
+package history
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Both stores keep the messages of each sample through the changes the chat makes: saving the first message,
+// appending the others one by one, and undoing an extra answer. The history is loaded back with a store
+// reopened on its file, so nothing is kept in memory only
+func TestStoresKeepSamples(t *testing.T) {
+	for _, samplePath := range historySamples(t) {
+		sample, err := Load(samplePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sample.Messages) == 0 {
+			t.Fatalf("sample history %s has no messages", samplePath)
+		}
+
+		for _, format := range []string{FormatJSON, FormatJSONL} {
+			t.Run(filepath.Base(samplePath)+" "+format, func(t *testing.T) {
+				store, err := OpenStore(filepath.Join(t.TempDir(), "history."+format), format)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := store.Undo(); err == nil {
+					t.Fatal("undoing a missing history didn't fail")
+				}
+
+				if err := store.Save(New(sample.Messages[:1])); err != nil {
+					t.Fatal(err)
+				}
+				for _, message := range sample.Messages[1:] {
+					if err := store.Append(message); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := store.Append(&ChatMessage{Role: RoleAssistant, Content: "An answer to regenerate"}); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.Undo(); err != nil {
+					t.Fatal(err)
+				}
+
+				reopened, err := OpenStore(store.Path(), format)
+				if err != nil {
+					t.Fatal(err)
+				}
+				loaded, err := reopened.Load()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if loaded.Version != CurrentVersion {
+					t.Errorf("loaded history has version %d, expected %d", loaded.Version, CurrentVersion)
+				}
+				if !reflect.DeepEqual(loaded.Messages, sample.Messages) {
+					t.Fatalf("loaded %d messages that aren't the %d saved", len(loaded.Messages), len(sample.Messages))
+				}
+
+				// Compacting a JSONL history drops the undone answer and its undo line only
+				jsonlStore, ok := reopened.(*JSONLStore)
+				if !ok {
+					return
+				}
+				dropped, err := jsonlStore.Compact()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if dropped != 2 {
+					t.Errorf("compaction dropped %d lines, expected 2", dropped)
+				}
+				compacted, err := jsonlStore.Load()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(compacted, loaded) {
+					t.Error("compaction changed the loaded history")
+				}
+			})
+		}
+	}
+}
+
+// Converting each sample to a JSONL history and back gives the same JSON
+func TestConvertSamples(t *testing.T) {
+	for _, samplePath := range historySamples(t) {
+		t.Run(filepath.Base(samplePath), func(t *testing.T) {
+			original, err := os.ReadFile(samplePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			name := strings.TrimSuffix(filepath.Base(samplePath), filepath.Ext(samplePath))
+			casesDir := t.TempDir()
+			jsonlStore := &JSONLStore{path: filepath.Join(casesDir, name+".jsonl")}
+			if err := Convert(&JSONStore{path: samplePath}, jsonlStore); err != nil {
+				t.Fatal(err)
+			}
+			convertedPath := filepath.Join(casesDir, name+".converted.json")
+			if err := Convert(jsonlStore, &JSONStore{path: convertedPath}); err != nil {
+				t.Fatal(err)
+			}
+
+			converted, err := os.ReadFile(convertedPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(converted, original) {
+				t.Error("converting to JSONL and back changes its JSON")
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+// Package language detects the language of user prompts and holds the user facing
+// canned strings of the agent and tools, keyed by language with an English fallback.
+package language
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+/*
+-------------------
+Supported languages
+-------------------
+*/
+
+// ISO 639-1 codes of the supported languages
+const (
+	English    = "en"
+	Spanish    = "es"
+	Portuguese = "pt"
+	French     = "fr"
+)
+
+// Language names, used on model instructions
+var names = map[string]string{
+	English:    "English",
+	Spanish:    "Spanish",
+	Portuguese: "Portuguese",
+	French:     "French",
+}
+
+// Frequent short words of each language, for detection
+var stopwords = map[string][]string{
+	English: {
+		"the", "what", "which", "how", "is", "are", "was", "of", "and", "in", "by", "for", "with", "most", "did", "does", "per",
+	},
+	Spanish: {
+		"el", "la", "los", "las", "qué", "que", "cuál", "cuáles", "cuánto", "cuántos", "cómo", "es", "son", "de", "del", "y",
+		"en", "por", "para", "con", "más", "una", "un", "fue", "tienda", "ventas",
+	},
+	Portuguese: {
+		"o", "os", "as", "qual", "quais", "quanto", "quantos", "como", "é", "são", "de", "do", "da", "dos", "e", "em", "por",
+		"para", "com", "mais", "uma", "um", "foi", "loja", "vendas",
+	},
+	French: {
+		"le", "la", "les", "quel", "quelle", "quels", "combien", "comment", "est", "sont", "de", "du", "des", "et", "en",
+		"par", "pour", "avec", "plus", "une", "un", "magasin", "ventes",
+	},
+}
+
+// Check a language code is supported. An empty code is returned as is, meaning detection
+func Normalize(code string) (string, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if code == "" {
+		return "", nil
+	}
+
+	if _, ok := names[code]; !ok {
+		return "", fmt.Errorf("unsupported language '%s'", code)
+	}
+
+	return code, nil
+}
+
+// Name of a language, English for unsupported codes
+func Name(code string) string {
+	if name, ok := names[code]; ok {
+		return name
+	}
+
+	return names[English]
+}
+
+// Detect the language of a prompt by counting the stopwords of each language.
+// Falls back to English when no other language clearly wins
+func Detect(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+
+	scores := map[string]int{}
+	for _, word := range words {
+		for code, list := range stopwords {
+			for _, stopword := range list {
+				if word == stopword {
+					scores[code]++
+					break
+				}
+			}
+		}
+	}
+
+	// Inverted punctuation only shows up in Spanish
+	if strings.ContainsAny(text, "¿¡") {
+		scores[Spanish] += 2
+	}
+
+	detected, best := English, scores[English]
+	for _, code := range []string{Spanish, Portuguese, French} {
+		if scores[code] > best {
+			detected, best = code, scores[code]
+		}
+	}
+
+	return detected
+}
+
+// Model instruction to answer in a language. Empty for English, which prompts are written in
+func Instruction(code string) string {
+	if code == "" || code == English {
+		return ""
+	}
+
+	return fmt.Sprintf("Answer in %s, the language of the user.", Name(code))
+}
+
+/*
+---------------
+Message catalog
+---------------
+*/
+
+// Keys of the canned strings
+const (
+	FollowUpsHeader = "follow_ups_header"
+	NoAnalysis      = "no_analysis"
+	NoChart         = "no_chart"
+	ToolDisabled    = "tool_disabled"
+	EntityRefused   = "entity_refused"
+
+	UngroundedAnswer = "ungrounded_answer"
+	SampledAnswer    = "sampled_answer"
+)
+
+// Canned strings per language. Every key must be defined for English
+var catalog = map[string]map[string]string{
+	English: {
+		FollowUpsHeader: "You could also ask:",
+		NoAnalysis:      "No analysis could be generated",
+		NoChart:         "No chart code could be generated",
+		ToolDisabled:    "Error: tool '%s' is not enabled for this run",
+		EntityRefused:   "I can't answer questions about specific individuals. Your question filters on '%s', which identifies people in the dataset. Try asking about totals or groups instead.",
+
+		UngroundedAnswer: "Warning: no data was looked up to answer this, its figures weren't checked against the dataset.",
+		SampledAnswer:    "Note: answered on a sample of the dataset (%s), its figures don't cover the whole data.",
+	},
+	Spanish: {
+		FollowUpsHeader: "También podrías preguntar:",
+		NoAnalysis:      "No se pudo generar un análisis",
+		NoChart:         "No se pudo generar el código del gráfico",
+		ToolDisabled:    "Error: la herramienta '%s' no está habilitada en esta ejecución",
+		EntityRefused:   "No puedo responder preguntas sobre personas específicas. Tu pregunta filtra por '%s', que identifica personas en el conjunto de datos. Prueba a preguntar por totales o grupos.",
+
+		UngroundedAnswer: "Advertencia: no se consultaron datos para responder esto, sus cifras no se verificaron con el conjunto de datos.",
+		SampledAnswer:    "Nota: respondido sobre una muestra del conjunto de datos (%s), sus cifras no cubren todos los datos.",
+	},
+	Portuguese: {
+		FollowUpsHeader: "Você também poderia perguntar:",
+		NoAnalysis:      "Não foi possível gerar uma análise",
+		NoChart:         "Não foi possível gerar o código do gráfico",
+		ToolDisabled:    "Erro: a ferramenta '%s' não está habilitada nesta execução",
+		EntityRefused:   "Não posso responder perguntas sobre pessoas específicas. Sua pergunta filtra por '%s', que identifica pessoas no conjunto de dados. Tente perguntar sobre totais ou grupos.",
+
+		UngroundedAnswer: "Aviso: nenhum dado foi consultado para responder isto, seus números não foram verificados no conjunto de dados.",
+		SampledAnswer:    "Nota: respondido sobre uma amostra do conjunto de dados (%s), seus números não cobrem todos os dados.",
+	},
+	French: {
+		FollowUpsHeader: "Vous pourriez aussi demander :",
+		NoAnalysis:      "Aucune analyse n'a pu être générée",
+		NoChart:         "Aucun code de graphique n'a pu être généré",
+		ToolDisabled:    "Erreur : l'outil '%s' n'est pas activé pour cette exécution",
+		EntityRefused:   "Je ne peux pas répondre aux questions sur des personnes précises. Votre question filtre sur '%s', qui identifie des personnes dans le jeu de données. Essayez plutôt de demander des totaux ou des groupes.",
+
+		UngroundedAnswer: "Attention : aucune donnée n'a été consultée pour répondre, ses chiffres n'ont pas été vérifiés dans le jeu de données.",
+		SampledAnswer:    "Remarque : réponse sur un échantillon du jeu de données (%s), ses chiffres ne couvrent pas toutes les données.",
+	},
+}
+
+// Canned string of a language, falling back to English for missing languages or keys
+func Message(code string, key string) string {
+	if message, ok := catalog[code][key]; ok {
+		return message
+	}
+
+	return catalog[English][key]
+}
@@ -0,0 +1,292 @@
+// Package llmdebug writes the raw JSON of every OpenAI request and response to a debug directory,
+// for debugging prompt issues. It's enabled with DEBUG_LLM_IO=1 and does nothing otherwise.
+package llmdebug
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/config"
+	"github.com/openai/openai-go/option"
+)
+
+/*
+-------------
+Configuration
+-------------
+*/
+
+// Environment variables of the debug mode
+const (
+	EnabledEnv = "DEBUG_LLM_IO"     // Set to 1 to write the request and response files
+	DirEnv     = "DEBUG_LLM_IO_DIR" // Directory of the files, relative to the workspace. Defaults to DefaultDir
+)
+
+const DefaultDir = "llm_debug"
+
+// Max size of each request or response body kept on a file, bigger ones are cut
+const maxBodyBytes = 512 * 1024
+
+// Max amount of files kept on the directory, the oldest ones are removed first
+const maxFiles = 200
+
+// Headers holding credentials, their values are never written
+var secretHeaders = []string{"Authorization", "Api-Key", "X-Api-Key", "Openai-Organization", "Openai-Project"}
+
+// API keys showing up on bodies, like echoed on error messages
+var apiKeyRegex = regexp.MustCompile(`sk-[A-Za-z0-9_\-]{8,}`)
+
+// Whether DEBUG_LLM_IO enables the debug mode
+func Enabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv(EnabledEnv))
+	return enabled
+}
+
+// Directory the debug files are written to
+func Dir() string {
+	return config.ResolvePath(cmp.Or(os.Getenv(DirEnv), DefaultDir))
+}
+
+// Client options writing the debug files. Empty unless the debug mode is enabled
+func Options() []option.RequestOption {
+	if !Enabled() {
+		return nil
+	}
+
+	dir := Dir()
+	log.Printf("WARNING: %s is set, writing OpenAI requests and responses to %s\n", EnabledEnv, dir)
+	return []option.RequestOption{option.WithMiddleware(Middleware(dir))}
+}
+
+/*
+-----------
+Step labels
+-----------
+*/
+
+// Context key of the step labels of a request
+type stepKey struct{}
+
+// Step and LLM span of a request, used to name its debug file
+type stepLabels struct {
+	step   string
+	spanID string
+}
+
+// Label the requests made with ctx with the step making them and its LLM span ID
+func WithStep(ctx context.Context, step string, spanID string) context.Context {
+	return context.WithValue(ctx, stepKey{}, stepLabels{step: step, spanID: spanID})
+}
+
+// Step labels of a request, with placeholders for unlabeled ones like the chat binary's
+func labelsOf(ctx context.Context) stepLabels {
+	labels, _ := ctx.Value(stepKey{}).(stepLabels)
+	if labels.step == "" {
+		labels.step = "unlabeled"
+	}
+	if labels.spanID == "" {
+		labels.spanID = "nospan"
+	}
+
+	return labels
+}
+
+/*
+-------------------
+Request file writer
+-------------------
+*/
+
+// HTTP side of a debug file
+type exchange struct {
+	Method    string            `json:"method,omitempty"`
+	URL       string            `json:"url,omitempty"`
+	Status    int               `json:"status,omitempty"`
+	Headers   map[string]string `json:"headers"`
+	Body      any               `json:"body"`
+	Truncated bool              `json:"truncated,omitempty"`
+}
+
+// Content of a debug file: one request and its response
+type debugFile struct {
+	TimeStamp  string    `json:"timeStamp"`
+	Step       string    `json:"step"`
+	SpanID     string    `json:"spanId"`
+	DurationMs int64     `json:"durationMs"`
+	Request    exchange  `json:"request"`
+	Response   *exchange `json:"response,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Sequence number of the files written by the process, so names never collide
+var fileSequence atomic.Int64
+
+// Serializes the retention pass
+var pruneMutex sync.Mutex
+
+// Middleware writing each request and response pair as a JSON file into dir.
+// Failures to write are logged, the request always goes through
+func Middleware(dir string) option.Middleware {
+	return func(request *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+		requestBody, err := readBody(&request.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		start := time.Now()
+		response, err := next(request)
+
+		labels := labelsOf(request.Context())
+		file := debugFile{
+			TimeStamp:  start.Format(time.RFC3339Nano),
+			Step:       labels.step,
+			SpanID:     labels.spanID,
+			DurationMs: time.Since(start).Milliseconds(),
+			Request:    newExchange(request.Header, requestBody),
+		}
+		file.Request.Method = request.Method
+		file.Request.URL = request.URL.String()
+
+		if err != nil {
+			file.Error = scrub(err.Error())
+		}
+		if response != nil {
+			responseBody, readErr := readBody(&response.Body)
+			if readErr != nil {
+				return response, readErr
+			}
+			responseExchange := newExchange(response.Header, responseBody)
+			responseExchange.Status = response.StatusCode
+			file.Response = &responseExchange
+		}
+
+		if writeErr := writeFile(dir, file); writeErr != nil {
+			log.Printf("WARNING: Failed to write LLM debug file: %s\n", writeErr)
+		}
+
+		return response, err
+	}
+}
+
+// Read a body and put back an unread copy of it, so the client can still use it
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+
+	content, err := io.ReadAll(*body)
+	(*body).Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body for LLM debug file: %w", err)
+	}
+
+	*body = io.NopCloser(bytes.NewReader(content))
+	return content, nil
+}
+
+// Scrubbed headers and body of a request or response. JSON bodies are kept as JSON
+func newExchange(header http.Header, body []byte) exchange {
+	result := exchange{Headers: map[string]string{}}
+	for name, values := range header {
+		value := strings.Join(values, ", ")
+		if slices.Contains(secretHeaders, http.CanonicalHeaderKey(name)) {
+			value = "REDACTED"
+		}
+		result.Headers[name] = scrub(value)
+	}
+
+	if truncated, ok := TruncateBytes(string(body), maxBodyBytes); ok {
+		result.Body = scrub(truncated)
+		result.Truncated = true
+		return result
+	}
+
+	scrubbed := scrub(string(body))
+	if json.Valid([]byte(scrubbed)) {
+		result.Body = json.RawMessage(scrubbed)
+	} else {
+		result.Body = scrubbed
+	}
+
+	return result
+}
+
+// Hide API keys, including the configured ones
+func scrub(text string) string {
+	for _, keyEnv := range []string{"OPENAI_API_KEY", "ANTHROPIC_API_KEY"} {
+		if key := os.Getenv(keyEnv); key != "" {
+			text = strings.ReplaceAll(text, key, "REDACTED")
+		}
+	}
+
+	return apiKeyRegex.ReplaceAllString(text, "sk-REDACTED")
+}
+
+// Write a debug file named after its time, step and span, then remove the oldest files over maxFiles
+func writeFile(dir string, file debugFile) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Names start with the time, so lexical order is chronological order
+	name := fmt.Sprintf(
+		"%s-%06d-%s-%s.json",
+		time.Now().Format("20060102T150405.000000"),
+		fileSequence.Add(1),
+		file.Step,
+		file.SpanID,
+	)
+	if err := os.WriteFile(filepath.Join(dir, name), content, 0o600); err != nil {
+		return err
+	}
+
+	return pruneFiles(dir)
+}
+
+// Remove the oldest debug files until at most maxFiles are left
+func pruneFiles(dir string) error {
+	pruneMutex.Lock()
+	defer pruneMutex.Unlock()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	files := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			files = append(files, entry.Name())
+		}
+	}
+	slices.Sort(files)
+
+	for len(files) > maxFiles {
+		if err := os.Remove(filepath.Join(dir, files[0])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		files = files[1:]
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package llmdebug
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"unicode/utf8"
+)
+
+/*
+-------------------
+Truncation and hash
+-------------------
+*/
+
+// Cut text to at most limit characters, never splitting a multi-byte character.
+// Returns whether the text was cut. Limits under 1 keep the whole text
+func Truncate(text string, limit int) (string, bool) {
+	if limit < 1 || len(text) <= limit {
+		return text, false
+	}
+
+	count := 0
+	for index := range text {
+		if count == limit {
+			return text[:index], true
+		}
+		count++
+	}
+
+	return text, false
+}
+
+// Cut text to at most limit bytes, backing off to the start of a character split by the limit.
+// Returns whether the text was cut. Limits under 1 keep the whole text
+func TruncateBytes(text string, limit int) (string, bool) {
+	if limit < 1 || len(text) <= limit {
+		return text, false
+	}
+
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+
+	return text[:cut], true
+}
+
+// Hex SHA-256 of content, to correlate identical content without keeping it
+func ContentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
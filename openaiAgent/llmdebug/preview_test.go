@@ -0,0 +1,62 @@
+package llmdebug
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+// Previews cut by characters or bytes never split a multi-byte character
+func TestTruncate(t *testing.T) {
+	cases := []struct {
+		name      string
+		text      string
+		limit     int
+		wantChars string // Cut to limit characters
+		wantBytes string // Cut to limit bytes
+	}{
+		{name: "ascii", text: "Total sales", limit: 5, wantChars: "Total", wantBytes: "Total"},
+		{name: "shorter than the limit", text: "sales", limit: 10, wantChars: "sales", wantBytes: "sales"},
+		{name: "exact length", text: "sales", limit: 5, wantChars: "sales", wantBytes: "sales"},
+		{name: "no limit", text: "sales", limit: 0, wantChars: "sales", wantBytes: "sales"},
+		{name: "two byte character", text: "cañada", limit: 3, wantChars: "cañ", wantBytes: "ca"},
+		{name: "two byte character boundary", text: "cañada", limit: 4, wantChars: "caña", wantBytes: "cañ"},
+		{name: "three byte characters", text: "売上合計", limit: 2, wantChars: "売上", wantBytes: ""},
+		{name: "three byte character boundary", text: "売上合計", limit: 7, wantChars: "売上合計", wantBytes: "売上"},
+		{name: "four byte character", text: "📈 up", limit: 3, wantChars: "📈 u", wantBytes: ""},
+		{name: "four byte character boundary", text: "📈 up", limit: 4, wantChars: "📈 up", wantBytes: "📈"},
+		{name: "combining mark", text: "cafe\u0301s", limit: 4, wantChars: "cafe", wantBytes: "cafe"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			chars, cut := Truncate(c.text, c.limit)
+			if chars != c.wantChars || cut != (chars != c.text) {
+				t.Errorf("cut to %d characters: %q (cut %t), expected %q", c.limit, chars, cut, c.wantChars)
+			}
+
+			bytes, cut := TruncateBytes(c.text, c.limit)
+			if bytes != c.wantBytes || cut != (bytes != c.text) {
+				t.Errorf("cut to %d bytes: %q (cut %t), expected %q", c.limit, bytes, cut, c.wantBytes)
+			}
+			if !utf8.ValidString(chars) || !utf8.ValidString(bytes) {
+				t.Error("truncation split a character")
+			}
+		})
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	cases := []struct {
+		content string
+		want    string
+	}{
+		{content: "", want: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{content: "abc", want: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	}
+
+	for _, c := range cases {
+		if hash := ContentHash(c.content); hash != c.want {
+			t.Errorf("hash of %q is %s, expected %s", c.content, hash, c.want)
+		}
+	}
+}
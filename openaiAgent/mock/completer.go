@@ -0,0 +1,186 @@
+// Package mock provides a scripted chat completer, to run the agent without calling OpenAI.
+package mock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/history"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// Request a fixture scripts as failed, standing for an API failure
+type ErrScriptedFailure struct {
+	Request int // Number of the request in the run, from 1
+}
+
+func (e *ErrScriptedFailure) Error() string {
+	return fmt.Sprintf("mock completer failed request %d as scripted", e.Request)
+}
+func (e *ErrScriptedFailure) ExitClass() exitcode.Class { return exitcode.ClassUpstream }
+
+// Chat completer that replies with scripted responses in order, recording every request.
+// A nil response fails its request, like an unreachable API. Satisfies tools.ChatCompleter
+type Completer struct {
+	Responses []*openai.ChatCompletion
+	Requests  []openai.ChatCompletionNewParams
+	Delay     time.Duration // Wait before each reply, to simulate a slow API. Cancelling ctx stops the wait
+
+	// Texts the system messages of the first request must hold. The request fails on a missing one
+	ExpectSystem []string
+	// Texts given requests must or mustn't hold in any of their messages
+	ExpectRequests []RequestExpectation
+
+	mutex sync.Mutex
+}
+
+// Create a completer replying with the given responses in order
+func NewCompleter(responses ...*openai.ChatCompletion) *Completer {
+	return &Completer{Responses: responses}
+}
+
+// Reply with the next scripted response. Fails once responses run out
+func (c *Completer) New(
+	ctx context.Context,
+	body openai.ChatCompletionNewParams,
+	opts ...option.RequestOption,
+) (*openai.ChatCompletion, error) {
+	if c.Delay > 0 {
+		select {
+		case <-time.After(c.Delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(c.Requests) == 0 {
+		if err := checkSystem(body, c.ExpectSystem); err != nil {
+			return nil, err
+		}
+	}
+
+	c.Requests = append(c.Requests, body)
+	if err := checkRequest(body, len(c.Requests), c.ExpectRequests); err != nil {
+		return nil, err
+	}
+	if len(c.Requests) > len(c.Responses) {
+		return nil, fmt.Errorf("mock completer has no response for request %d", len(c.Requests))
+	}
+
+	response := c.Responses[len(c.Requests)-1]
+	if response == nil {
+		return nil, &ErrScriptedFailure{Request: len(c.Requests)}
+	}
+
+	return response, nil
+}
+
+// Check the system messages of a request hold every expected text
+func checkSystem(body openai.ChatCompletionNewParams, expected []string) error {
+	systemTexts := []string{}
+	for _, message := range body.Messages.Value {
+		if systemMessage, ok := message.(openai.ChatCompletionSystemMessageParam); ok {
+			systemTexts = append(systemTexts, history.MessageText(systemMessage))
+		}
+	}
+
+	system := strings.Join(systemTexts, "\n")
+	for _, text := range expected {
+		if !strings.Contains(system, text) {
+			return fmt.Errorf("mock completer expected the system prompt to hold %q", text)
+		}
+	}
+
+	return nil
+}
+
+// Check the messages of the nth request against the expectations made for it
+func checkRequest(body openai.ChatCompletionNewParams, number int, expectations []RequestExpectation) error {
+	texts := []string{}
+	for _, message := range body.Messages.Value {
+		texts = append(texts, history.MessageText(message))
+	}
+	messages := strings.Join(texts, "\n")
+
+	for _, expectation := range expectations {
+		if expectation.Request != number {
+			continue
+		}
+		for _, text := range expectation.Contains {
+			if !strings.Contains(messages, text) {
+				return fmt.Errorf("mock completer expected request %d to hold %q", number, text)
+			}
+		}
+		for _, text := range expectation.Excludes {
+			if strings.Contains(messages, text) {
+				return fmt.Errorf("mock completer expected request %d not to hold %q", number, text)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Build a completion from its raw JSON, so the response keeps its raw JSON like a real one
+func parseCompletion(rawJson string) *openai.ChatCompletion {
+	completion := &openai.ChatCompletion{}
+	if err := json.Unmarshal([]byte(rawJson), completion); err != nil {
+		panic(fmt.Sprintf("invalid mock completion: %s", err))
+	}
+
+	return completion
+}
+
+// Completion answering with plain text content
+func TextResponse(content string) *openai.ChatCompletion {
+	contentJson, _ := json.Marshal(content)
+	return parseCompletion(fmt.Sprintf(`{
+		"id": "mock-completion",
+		"object": "chat.completion",
+		"model": "mock",
+		"choices": [{
+			"index": 0,
+			"finish_reason": "stop",
+			"message": {"role": "assistant", "content": %s}
+		}],
+		"usage": {"prompt_tokens": 0, "completion_tokens": 0, "total_tokens": 0}
+	}`, contentJson))
+}
+
+// Completion calling a single tool with the given arguments
+func ToolCallResponse(callID string, functionName string, arguments map[string]string) *openai.ChatCompletion {
+	argumentsJson, _ := json.Marshal(arguments)
+	argumentsString, _ := json.Marshal(string(argumentsJson))
+	return parseCompletion(fmt.Sprintf(`{
+		"id": "mock-completion",
+		"object": "chat.completion",
+		"model": "mock",
+		"choices": [{
+			"index": 0,
+			"finish_reason": "tool_calls",
+			"message": {
+				"role": "assistant",
+				"content": "",
+				"tool_calls": [{
+					"id": %q,
+					"type": "function",
+					"function": {"name": %q, "arguments": %s}
+				}]
+			}
+		}],
+		"usage": {"prompt_tokens": 0, "completion_tokens": 0, "total_tokens": 0}
+	}`, callID, functionName, argumentsString))
+}
@@ -0,0 +1,75 @@
+package mock
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/shared"
+)
+
+// Dimensions of the vectors a mock Embedder returns
+const embeddingDimensions = 64
+
+// Embedder hashing the words of a text into a bag of words vector, so texts sharing words are similar.
+// Stands in for the OpenAI embeddings API offline. Satisfies tools.Embedder
+type Embedder struct {
+	Requests []openai.EmbeddingNewParams
+
+	mutex sync.Mutex
+}
+
+// Embed the single string input of the request
+func (e *Embedder) New(
+	ctx context.Context,
+	body openai.EmbeddingNewParams,
+	opts ...option.RequestOption,
+) (*openai.CreateEmbeddingResponse, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	e.Requests = append(e.Requests, body)
+
+	text, ok := body.Input.Value.(shared.UnionString)
+	if !ok {
+		return nil, fmt.Errorf("mock embedder only embeds a single string")
+	}
+
+	return &openai.CreateEmbeddingResponse{
+		Data:  []openai.Embedding{{Embedding: BagOfWords(string(text)), Index: 0}},
+		Model: string(body.Model.Value),
+	}, nil
+}
+
+// Normalized vector counting the hashed lowercase words of a text
+func BagOfWords(text string) []float64 {
+	vector := make([]float64, embeddingDimensions)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	for _, word := range words {
+		hash := fnv.New32a()
+		hash.Write([]byte(word))
+		vector[hash.Sum32()%embeddingDimensions]++
+	}
+
+	norm := 0.0
+	for _, value := range vector {
+		norm += value * value
+	}
+	if norm == 0 {
+		return vector
+	}
+	for i := range vector {
+		vector[i] /= math.Sqrt(norm)
+	}
+
+	return vector
+}
@@ -0,0 +1,64 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// Recorded completions of a run, replayed in order by a Completer
+type Fixture struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"` // What the run exercises and how it should end
+	Responses   []json.RawMessage `json:"responses"`   // Raw chat completions, as returned by the API. null fails its request
+	DelayMs     int               `json:"delayMs"`     // Wait before each reply, to interrupt runs midway
+
+	// Texts the system prompt of the run must hold, like the instructions of the flags it's replayed with
+	ExpectSystem []string `json:"expectSystem,omitempty"`
+	// Texts given requests of the run must or mustn't hold, like the prompt a tool sent
+	ExpectRequests []RequestExpectation `json:"expectRequests,omitempty"`
+}
+
+// Texts the messages of a request must or mustn't hold
+type RequestExpectation struct {
+	Request  int      `json:"request"`            // Number of the request in the run, from 1
+	Contains []string `json:"contains,omitempty"` // Texts some message must hold
+	Excludes []string `json:"excludes,omitempty"` // Texts no message may hold
+}
+
+// Create a completer replaying the responses of a fixture file
+func LoadFixture(fixturePath string) (*Completer, error) {
+	jsonBytes, err := os.ReadFile(fixturePath)
+	if err != nil {
+		return nil, err
+	}
+
+	fixture := Fixture{}
+	if err := json.Unmarshal(jsonBytes, &fixture); err != nil {
+		return nil, fmt.Errorf("failed to decode fixture %s: %w", fixturePath, err)
+	}
+
+	responses := []*openai.ChatCompletion{}
+	for i, rawResponse := range fixture.Responses {
+		// A null response stands for a completion the API failed
+		if string(rawResponse) == "null" {
+			responses = append(responses, nil)
+			continue
+		}
+
+		response := &openai.ChatCompletion{}
+		if err := json.Unmarshal(rawResponse, response); err != nil {
+			return nil, fmt.Errorf("invalid response %d of fixture %s: %w", i+1, fixturePath, err)
+		}
+		responses = append(responses, response)
+	}
+
+	completer := NewCompleter(responses...)
+	completer.ExpectSystem = fixture.ExpectSystem
+	completer.ExpectRequests = fixture.ExpectRequests
+	completer.Delay = time.Duration(fixture.DelayMs) * time.Millisecond
+	return completer, nil
+}
@@ -0,0 +1,354 @@
+// Package prompts holds the prompt templates of the tools and renders them from typed requests.
+// Every template is checked to consume all of its arguments, and `go test ./prompts` compares
+// sample renders against golden files, so wording changes show up as reviewable diffs.
+package prompts
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/*
+---------
+Templates
+---------
+*/
+
+const sqlGenerationTemplate = `
+Generate an SQL query based on a prompt. Do not reply with anything besides the SQL query.
+The prompt is:
+%s
+
+The available columns are: %s
+Business terms and the columns they name: %s
+Units of the columns, keep amounts in the same unit when combining columns: %s
+The table name is: %s
+Prefer selecting from these precomputed views or tables when they answer the prompt: %s
+Temporary tables created by earlier lookups of this run: %s
+To keep an intermediate result for a later lookup, reply instead with a single
+CREATE TEMPORARY TABLE <name> AS SELECT ... statement, where <name> starts with %s
+The query runs on DuckDB, follow its dialect:
+%s
+`
+const dataAnalysisTemplate = `
+Analyze the following data: %s
+Units of the columns, answer with the shown units: %s
+Column statistics of the data, already in the shown units:
+%s
+Your job is to answer the following question: %s
+%s`
+const groundedAnalysisTemplate = `
+Analyze the following data: %s
+Units of the columns, answer with the shown units: %s
+Column statistics of the data, already in the shown units:
+%s
+Your job is to answer the following question: %s
+Cite the rows supporting each claim of your answer by their %s values.
+Only cite rows that are in the data.
+%s`
+const chartConfigTemplate = `
+Generate a chart configuration based on this data summary:
+%s
+The goal is to show: %s
+The chart type must be one of: bar, line, scatter, histogram, boxplot
+Use a histogram for how the values of a single numeric column spread, with that column as xAxis and binCount
+the number of bins, or 0 for the default. Use a boxplot to compare the spread of a numeric column between groups,
+with that column as xAxis and bucketBy the column of the groups, or empty for a single box.
+Otherwise binCount is 0 and bucketBy is empty.
+`
+const chartCodeTemplate = `
+Write python code to create a chart based on the following configuration.
+Only return the code, no other text.
+config: %+v
+`
+const chartDistributionTemplate = `
+Plot these values as given, they were computed from the data and must not be computed again (%s):
+%s
+`
+const followUpTemplate = `
+Suggest 2 or 3 short follow up questions the user could ask next.
+They must be answerable with the datasets below, and grounded in the data already retrieved.
+Question: %s
+Answer: %s
+Datasets touched (name: columns):
+%s
+`
+const judgeTemplate = `
+You are judging answers to a question about a sales dataset.
+Pick the answer that is most correct, complete and clearly grounded in data. Reply with its label.
+Question: %s
+
+Answers:
+%s
+`
+const profileNarrativeTemplate = `
+Write a single paragraph overview of the dataset described by this profile, for analysts about to ask questions
+about it. Mention what the data covers, its main dimensions and measures, and any data quality issue worth knowing.
+Do not use lists or headings.
+%s
+`
+const describeDatasetTemplate = `
+Describe the dataset of this profile in 3 to 5 sentences, to tell an assistant answering questions about it what
+the data is. Say what each row appears to record, the main dimensions and measures with their ranges, and the
+period covered. Do not use lists or headings, and do not state anything the profile doesn't show.
+Columns each row appears to be one combination of: %s
+%s
+`
+const datasetDescriptionTemplate = `About the dataset: %s`
+const glossaryTemplate = `Business terms of the dataset and the columns they name.
+When the user says a term, they mean its column:%s`
+const planTemplate = `
+Plan how to answer a question about a sales dataset with the tools below, without calling them.
+List the tool calls you would make in order, with a sketch of their arguments and why each one is needed.
+Return no steps if the question can be answered without tools.
+Question: %s
+
+Tools:
+%s
+`
+
+// Answer verbosity instructions, added to the router system prompt and to analyses. Normal answers add none
+const briefAnswerInstruction = "Keep the final answer brief, for executives: two sentences at most, with the key figures." +
+	" Leave out methodology, queries and caveats."
+const detailedAnswerInstruction = "Give a detailed final answer, for analysts, in three sections: Summary, with the answer;" +
+	" Methodology, with the tools, queries and calculations behind it; and Caveats, with the limits of the data and any assumption made."
+const briefAnalysisInstruction = "Only reply with a summary of two sentences at most, without methodology or caveats."
+const detailedAnalysisInstruction = "Reply with a summary, then a Methodology section explaining how the data was processed," +
+	" then a Caveats section listing the limits of the data and of the analysis."
+
+/*
+---------
+Verbosity
+---------
+*/
+
+// Depth of the answers, for the audience reading them
+const (
+	VerbosityBrief    = "brief"    // Summary only, for executives
+	VerbosityNormal   = "normal"   // Default depth, without instructions
+	VerbosityDetailed = "detailed" // Methodology and caveats sections, for analysts
+)
+
+// Check a verbosity level, case insensitively. Empty is normal
+func NormalizeVerbosity(level string) (string, error) {
+	switch normalized := strings.ToLower(strings.TrimSpace(level)); normalized {
+	case "":
+		return VerbosityNormal, nil
+	case VerbosityBrief, VerbosityNormal, VerbosityDetailed:
+		return normalized, nil
+	}
+
+	return "", fmt.Errorf("unknown verbosity '%s', expected %s, %s or %s", level, VerbosityBrief, VerbosityNormal, VerbosityDetailed)
+}
+
+// System prompt instruction of a verbosity level, empty for normal
+func RenderVerbosityInstruction(level string) string {
+	switch level {
+	case VerbosityBrief:
+		return briefAnswerInstruction
+	case VerbosityDetailed:
+		return detailedAnswerInstruction
+	}
+
+	return ""
+}
+
+// Analysis prompt lines of a verbosity level, empty for normal
+func analysisVerbosity(level string) string {
+	switch level {
+	case VerbosityBrief:
+		return briefAnalysisInstruction + "\n"
+	case VerbosityDetailed:
+		return detailedAnalysisInstruction + "\n"
+	}
+
+	return ""
+}
+
+/*
+--------
+Requests
+--------
+*/
+
+// Arguments of the SQL generation prompt. Descriptions are rendered by the tools, "none" when empty
+type SQLGenRequest struct {
+	Prompt        string   // What the lookup must retrieve
+	Columns       []string // Columns of the dataset table
+	Glossary      string   // Business terms and the columns they name
+	Units         string   // Units of the columns and how they're shown
+	Table         string   // Name of the dataset table
+	Views         string   // Precomputed views or live tables to prefer
+	TempTables    string   // Temporary tables created earlier on the run
+	TempNamespace string   // Prefix the temporary tables of the run must be named with
+	Dialect       string   // DuckDB dialect rules
+}
+
+// Arguments of the analysis prompts
+type AnalysisRequest struct {
+	Data        string // Data to analyze, as CSV
+	Statistics  string // Column statistics of the data, in the shown units
+	Units       string // Units of the columns and how they're shown
+	Question    string
+	IndexColumn string // Column of the row indexes to cite, only for grounded analyses
+	Verbosity   string // Answer verbosity level, empty is normal
+}
+
+// Arguments of the chart config prompt
+type ChartConfigRequest struct {
+	Summary string // Column statistics and sample rows of the data
+	Goal    string // What the chart must show
+}
+
+// Arguments of the chart code prompt
+type ChartCodeRequest struct {
+	Config       any    // Chart config and its data, rendered with their field names
+	Distribution string // Bins or boxes of histograms and box plots, one per line after a title line
+}
+
+// Arguments of the follow up questions prompt
+type FollowUpRequest struct {
+	Question string
+	Answer   string
+	Datasets []string // Datasets touched by the run, one "- name: columns" line each
+}
+
+// Arguments of the answers judge prompt
+type JudgeRequest struct {
+	Question string
+	Answers  []string // Candidate answers, each starting with its [label]
+}
+
+// Arguments of the profile narrative prompt
+type ProfileNarrativeRequest struct {
+	Profile string // Dataset profile as markdown
+}
+
+// Arguments of the dataset description request
+type DescribeDatasetRequest struct {
+	Grain   string // Columns identifying a row, as far as the profile tells
+	Profile string // Dataset profile as markdown
+}
+
+// Arguments of the dataset description system prompt
+type DatasetDescriptionRequest struct {
+	Description string
+}
+
+// Arguments of the glossary system prompt
+type GlossaryRequest struct {
+	Terms string // One "- term: column (description)" line each, after a line break
+}
+
+// Arguments of the run plan prompt
+type PlanRequest struct {
+	Question string
+	Tools    []string // Descriptions of the available tools, one line each
+}
+
+/*
+---------
+Rendering
+---------
+*/
+
+func RenderSQLGeneration(request SQLGenRequest) string {
+	return render("sql_generation", sqlGenerationTemplate,
+		request.Prompt, strings.Join(request.Columns, ", "), request.Glossary, request.Units, request.Table,
+		request.Views, request.TempTables, request.TempNamespace, request.Dialect,
+	)
+}
+
+// Render the analysis prompt, the grounded one when the request has an index column
+func RenderAnalysis(request AnalysisRequest) string {
+	if request.IndexColumn != "" {
+		return render(
+			"grounded_analysis", groundedAnalysisTemplate,
+			request.Data, request.Units, request.Statistics, request.Question, request.IndexColumn, analysisVerbosity(request.Verbosity),
+		)
+	}
+
+	return render(
+		"data_analysis", dataAnalysisTemplate,
+		request.Data, request.Units, request.Statistics, request.Question, analysisVerbosity(request.Verbosity),
+	)
+}
+
+func RenderChartConfig(request ChartConfigRequest) string {
+	return render("chart_config", chartConfigTemplate, request.Summary, request.Goal)
+}
+
+// Render the chart code prompt, telling the model the distribution to plot when the chart has one
+func RenderChartCode(request ChartCodeRequest) string {
+	prompt := render("chart_code", chartCodeTemplate, request.Config)
+	if request.Distribution == "" {
+		return prompt
+	}
+
+	title, lines, _ := strings.Cut(request.Distribution, "\n")
+	return prompt + render("chart_distribution", chartDistributionTemplate, title, lines)
+}
+
+func RenderFollowUp(request FollowUpRequest) string {
+	return render("follow_up", followUpTemplate, request.Question, request.Answer, strings.Join(request.Datasets, "\n"))
+}
+
+func RenderJudge(request JudgeRequest) string {
+	return render("judge", judgeTemplate, request.Question, strings.Join(request.Answers, "\n\n"))
+}
+
+func RenderProfileNarrative(request ProfileNarrativeRequest) string {
+	return render("profile_narrative", profileNarrativeTemplate, request.Profile)
+}
+
+func RenderDescribeDataset(request DescribeDatasetRequest) string {
+	return render("describe_dataset", describeDatasetTemplate, request.Grain, request.Profile)
+}
+
+func RenderDatasetDescription(request DatasetDescriptionRequest) string {
+	return render("dataset_description", datasetDescriptionTemplate, request.Description)
+}
+
+func RenderGlossary(request GlossaryRequest) string {
+	return render("glossary", glossaryTemplate, request.Terms)
+}
+
+func RenderPlan(request PlanRequest) string {
+	return render("plan", planTemplate, request.Question, strings.Join(request.Tools, "\n"))
+}
+
+// Formatting verbs of a template, besides escaped percent signs
+var verbRegex = regexp.MustCompile(`%[-+# 0]*[0-9]*(?:\.[0-9]+)?[a-zA-Z%]`)
+
+// Placeholders of other template languages, which Sprintf leaves as they are
+var foreignPlaceholderRegex = regexp.MustCompile(`\{\{?\s*\.?[A-Za-z_][A-Za-z0-9_]*\s*\}?\}`)
+
+// Check a template consumes exactly its arguments and has no placeholder Sprintf can't fill
+func Validate(template string, argCount int) error {
+	verbs := 0
+	for _, verb := range verbRegex.FindAllString(template, -1) {
+		if verb != "%%" {
+			verbs++
+		}
+	}
+	if verbs != argCount {
+		return fmt.Errorf("template has %d formatting verbs for %d arguments", verbs, argCount)
+	}
+
+	if placeholder := foreignPlaceholderRegex.FindString(template); placeholder != "" {
+		return fmt.Errorf("template has a %s placeholder Sprintf doesn't fill", placeholder)
+	}
+
+	return nil
+}
+
+// Fill a template with its arguments. Templates are constants, so a broken one is a programming
+// error caught by `go test ./prompts` before it ships, and panics here
+func render(name string, template string, args ...any) string {
+	if err := Validate(template, len(args)); err != nil {
+		panic(fmt.Sprintf("prompt '%s': %s", name, err))
+	}
+
+	return fmt.Sprintf(template, args...)
+}
@@ -0,0 +1,153 @@
+package prompts
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Rewrite the golden files from the renders after an intended wording change, with go test ./prompts -update
+var update = flag.Bool("update", false, "rewrite the golden prompt files")
+
+// Directory of the golden renders
+const goldenDir = "../testdata/prompts"
+
+// Sample render of a prompt, compared against its golden file
+type sample struct {
+	Name     string // Golden file name, without extension
+	Rendered string
+}
+
+// Render every prompt with fixed sample inputs, in a stable order
+func samples() []sample {
+	return []sample{
+		{Name: "sql_generation", Rendered: RenderSQLGeneration(SQLGenRequest{
+			Prompt:        "Total sales value per store in 2023",
+			Columns:       []string{"Store_Number", "SKU_Coded", "Sold_Date", "Total_Sale_Value"},
+			Glossary:      "\n- revenue: Total_Sale_Value (sales value in currency)",
+			Units:         "\n- Total_Sale_Value: dollars\n- Price_Cents: cents, shown in dollars (multiply by 0.01)",
+			Table:         "sales",
+			Views:         "\n- sales_by_store: Store_Number, Total_Sale_Value",
+			TempTables:    "none",
+			TempNamespace: "tmp_r1_",
+			Dialect:       "- Identifiers: quote identifiers with double quotes",
+		})},
+		{Name: "data_analysis", Rendered: RenderAnalysis(AnalysisRequest{
+			Data:       "Store_Number, Total_Sale_Value\n1320, 1234.5\n330, 12.5",
+			Statistics: "Total_Sale_Value: numeric, min 12.5, max 1234.5",
+			Units:      "\n- Total_Sale_Value: dollars",
+			Question:   "Which store sells the most?",
+		})},
+		{Name: "grounded_analysis", Rendered: RenderAnalysis(AnalysisRequest{
+			Data:        "row_index, Store_Number, Total_Sale_Value\n0, 1320, 1234.5\n1, 330, 12.5",
+			Statistics:  "Total_Sale_Value: numeric, min 12.5, max 1234.5",
+			Units:       "\n- Total_Sale_Value: dollars",
+			Question:    "Which store sells the most?",
+			IndexColumn: "row_index",
+		})},
+		{Name: "brief_analysis", Rendered: RenderAnalysis(AnalysisRequest{
+			Data:       "Store_Number, Total_Sale_Value\n1320, 1234.5\n330, 12.5",
+			Statistics: "Total_Sale_Value: numeric, min 12.5, max 1234.5",
+			Units:      "\n- Total_Sale_Value: dollars",
+			Question:   "Which store sells the most?",
+			Verbosity:  VerbosityBrief,
+		})},
+		{Name: "detailed_analysis", Rendered: RenderAnalysis(AnalysisRequest{
+			Data:       "Store_Number, Total_Sale_Value\n1320, 1234.5\n330, 12.5",
+			Statistics: "Total_Sale_Value: numeric, min 12.5, max 1234.5",
+			Units:      "\n- Total_Sale_Value: dollars",
+			Question:   "Which store sells the most?",
+			Verbosity:  VerbosityDetailed,
+		})},
+		{Name: "brief_answer", Rendered: RenderVerbosityInstruction(VerbosityBrief)},
+		{Name: "detailed_answer", Rendered: RenderVerbosityInstruction(VerbosityDetailed)},
+		{Name: "chart_config", Rendered: RenderChartConfig(ChartConfigRequest{
+			Summary: "Store_Number: categorical, 2 values\nTotal_Sale_Value: numeric, min 12.5, max 1234.5",
+			Goal:    "Sales value per store",
+		})},
+		{Name: "chart_code", Rendered: RenderChartCode(ChartCodeRequest{
+			Config: struct{ ChartType, XAxis, YAxis, Title string }{"bar", "Store_Number", "Total_Sale_Value", "Sales per store"},
+		})},
+		{Name: "histogram_chart_code", Rendered: RenderChartCode(ChartCodeRequest{
+			Config:       struct{ ChartType, XAxis, YAxis, Title string }{"histogram", "Total_Sale_Value", "count", "Sale values"},
+			Distribution: "histogram of Total_Sale_Value in 2 bins, 0 values skipped\n12.5 to 623.5: 1\n623.5 to 1234.5: 1",
+		})},
+		{Name: "follow_up", Rendered: RenderFollowUp(FollowUpRequest{
+			Question: "Which store sells the most?",
+			Answer:   "Store 1320 sells the most.",
+			Datasets: []string{"- sales: Store_Number, Total_Sale_Value"},
+		})},
+		{Name: "judge", Rendered: RenderJudge(JudgeRequest{
+			Question: "Which store sells the most?",
+			Answers:  []string{"[A]\nStore 1320.", "[B]\nStore 330."},
+		})},
+		{Name: "profile_narrative", Rendered: RenderProfileNarrative(ProfileNarrativeRequest{
+			Profile: "| Column | Type |\n|---|---|\n| Store_Number | BIGINT |",
+		})},
+		{Name: "describe_dataset", Rendered: RenderDescribeDataset(DescribeDatasetRequest{
+			Grain:   "Store_Number, Sold_Date",
+			Profile: "| Column | Type |\n|---|---|\n| Store_Number | BIGINT |",
+		})},
+		{Name: "dataset_description", Rendered: RenderDatasetDescription(DatasetDescriptionRequest{
+			Description: "Daily sales of each product at each store, from 2021-11-01 to 2024-03-31.",
+		})},
+		{Name: "glossary", Rendered: RenderGlossary(GlossaryRequest{
+			Terms: "\n- revenue: Total_Sale_Value (sales value in currency)\n- sale date: Sold_Date",
+		})},
+		{Name: "plan", Rendered: RenderPlan(PlanRequest{
+			Question: "Which store sells the most?",
+			Tools:    []string{"- LookUpSalesData: Look up data from the sales dataset"},
+		})},
+	}
+}
+
+func TestGoldenPrompts(t *testing.T) {
+	for _, sample := range samples() {
+		t.Run(sample.Name, func(t *testing.T) {
+			goldenPath := filepath.Join(goldenDir, sample.Name+".txt")
+			if *update {
+				if err := os.WriteFile(goldenPath, []byte(sample.Rendered), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			golden, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatalf("missing golden file, run go test ./prompts -update: %s", err)
+			}
+			if lines := diffLines(string(golden), sample.Rendered); len(lines) != 0 {
+				t.Errorf("prompt differs from its golden file, run go test ./prompts -update if intended:\n    %s", strings.Join(lines, "\n    "))
+			}
+		})
+	}
+}
+
+// Lines differing between two texts, compared line by line
+func diffLines(golden string, rendered string) []string {
+	goldenLines := strings.Split(golden, "\n")
+	renderedLines := strings.Split(rendered, "\n")
+	if slices.Equal(goldenLines, renderedLines) {
+		return nil
+	}
+
+	lines := []string{}
+	for i := range max(len(goldenLines), len(renderedLines)) {
+		goldenLine, renderedLine := "", ""
+		if i < len(goldenLines) {
+			goldenLine = goldenLines[i]
+		}
+		if i < len(renderedLines) {
+			renderedLine = renderedLines[i]
+		}
+		if goldenLine != renderedLine {
+			lines = append(lines, fmt.Sprintf("%d: -%s", i+1, goldenLine), fmt.Sprintf("%d: +%s", i+1, renderedLine))
+		}
+	}
+
+	return lines
+}
@@ -0,0 +1,421 @@
+package render
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/language"
+)
+
+/*
+--------------
+Answer numbers
+--------------
+*/
+
+// Max decimals of formatted numbers when the policy sets none. Amounts always get 2
+const DefaultNumberDecimals = 2
+
+// How the numbers of an answer are written
+type NumberPolicy struct {
+	Locale            string   // Language code picking the separators, like "fr". Unknown or empty ones use English
+	MaxDecimals       int      // Decimals kept on numbers, trailing zeros dropped. Defaults to DefaultNumberDecimals
+	Currency          string   // Symbol prefixed to amounts of monetary columns, defaults to "$"
+	MonetaryColumns   []string // Columns holding amounts. Numbers right after their names, or in their table columns, are amounts
+	IdentifierColumns []string // Columns holding codes, like SKUs. Numbers right after their names, or in their table columns, are kept as is
+}
+
+// Thousands and decimal separators of a locale
+type numberSeparators struct {
+	thousands string
+	decimal   string
+}
+
+var localeSeparators = map[string]numberSeparators{
+	language.English:    {thousands: ",", decimal: "."},
+	language.Spanish:    {thousands: ".", decimal: ","},
+	language.Portuguese: {thousands: ".", decimal: ","},
+	language.French:     {thousands: " ", decimal: ","},
+}
+
+// Numbers as the model writes them: an optional currency symbol and sign, digits with or without
+// English thousands separators, decimals and a percent sign
+var numberRegex = regexp.MustCompile(`([$€£]\s?)?(-)?(\d{1,3}(?:,\d{3})+|\d+)(\.\d+)?(\s?%)?`)
+
+// Words naming codes rather than quantities when right before a number, like "SKU 6172800"
+var identifierWords = []string{
+	"sku", "skus", "store", "stores", "id", "ids", "code", "codes", "class", "classes", "number", "no",
+	"item", "items", "product", "products", "zip", "page", "step", "version", "week", "quarter", "q", "year",
+}
+
+// Text between numbers of the same list, like "330, 1320 and 4840"
+var listSeparatorRegex = regexp.MustCompile(`^(?:\s*,\s*|\s*/\s*|\s+(?:and|or|y|o|e|et|ou)\s+)$`)
+
+// Inline spans whose numbers are never touched: code, link targets and URLs
+var protectedInlineRegex = regexp.MustCompile("(`+)[^`]*?(`+)|\\]\\([^)]*\\)|https?://\\S+")
+
+// End of the previous clause, monetary names only apply to numbers of their clause
+var clauseEndRegex = regexp.MustCompile(`[!?;]\s|\.\s`)
+
+// Max words between a monetary column name and its amount, like "Total_Sale_Value reached 1234.5"
+const maxAmountDistance = 3
+
+// Rewrite the bare numbers of an answer with the separators and decimals of the policy. Code blocks,
+// inline code, links, CSV tables and numbers that are part of identifiers, dates, times or versions are left as
+// they are, as are years, short integers and abbreviated amounts like "1.2M"
+func FormatNumbers(answer string, policy NumberPolicy) string {
+	formatter := newNumberFormatter(policy)
+	lines := strings.Split(answer, "\n")
+
+	for i := 0; i < len(lines); {
+		if end, ok := fencedBlockEnd(lines, i); ok {
+			i = end
+			continue
+		}
+
+		if end, table, ok := markdownTable(lines, i); ok {
+			kinds := formatter.columnKinds(table.rows[0])
+			for row := i + 2; row < end; row++ {
+				lines[row] = formatter.tableRow(lines[row], kinds)
+			}
+			i = end
+			continue
+		}
+
+		// Thousands separators would break the fields of CSV tables
+		if i == 0 || strings.TrimSpace(lines[i-1]) == "" {
+			if end, _, ok := csvTable(lines, i); ok {
+				i = end
+				continue
+			}
+		}
+
+		lines[i] = formatter.text(lines[i], numberPlain)
+		i++
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// What the numbers of a piece of text are
+type numberKind int
+
+const (
+	numberPlain      numberKind = iota // Quantities, amounts only when they carry a currency symbol
+	numberMonetary                     // Amounts
+	numberIdentifier                   // Codes, never touched
+)
+
+type numberFormatter struct {
+	policy      NumberPolicy
+	separators  numberSeparators
+	monetary    []*regexp.Regexp
+	identifiers []*regexp.Regexp
+}
+
+func newNumberFormatter(policy NumberPolicy) *numberFormatter {
+	if policy.MaxDecimals <= 0 {
+		policy.MaxDecimals = DefaultNumberDecimals
+	}
+	if policy.Currency == "" {
+		policy.Currency = "$"
+	}
+
+	separators, ok := localeSeparators[strings.ToLower(policy.Locale)]
+	if !ok {
+		separators = localeSeparators[language.English]
+	}
+
+	formatter := &numberFormatter{policy: policy, separators: separators}
+	for _, column := range policy.MonetaryColumns {
+		formatter.monetary = append(formatter.monetary, columnNameRegex(column))
+	}
+	for _, column := range policy.IdentifierColumns {
+		formatter.identifiers = append(formatter.identifiers, columnNameRegex(column))
+	}
+
+	return formatter
+}
+
+// Pattern of a column name as written on prose: "Total_Sale_Value" also matches "total sales value"
+func columnNameRegex(column string) *regexp.Regexp {
+	words := strings.FieldsFunc(strings.ToLower(column), func(r rune) bool {
+		return r == '_' || r == '-' || r == ' '
+	})
+	for i, word := range words {
+		words[i] = regexp.QuoteMeta(strings.TrimSuffix(word, "s")) + "s?"
+	}
+
+	return regexp.MustCompile(`(?i)\b` + strings.Join(words, `[\s_-]*`) + `\b`)
+}
+
+// Kind of the numbers of each table column, from its header
+func (f *numberFormatter) columnKinds(header []string) []numberKind {
+	kinds := []numberKind{}
+	for _, name := range header {
+		kind := numberPlain
+		switch {
+		case matchesWhole(f.identifiers, name):
+			kind = numberIdentifier
+		case matchesWhole(f.monetary, name):
+			kind = numberMonetary
+		}
+		kinds = append(kinds, kind)
+	}
+
+	return kinds
+}
+
+// Whether a table header is one of the column names, ignoring Markdown emphasis around it
+func matchesWhole(patterns []*regexp.Regexp, header string) bool {
+	header = strings.Trim(header, "*_` ")
+	return slices.ContainsFunc(patterns, func(pattern *regexp.Regexp) bool {
+		location := pattern.FindStringIndex(header)
+		return location != nil && location[0] == 0 && location[1] == len(header)
+	})
+}
+
+// Format the cells of a table row, each with the kind of its column. Pipes and spacing are kept
+func (f *numberFormatter) tableRow(line string, kinds []numberKind) string {
+	cells := splitUnescaped(line, '|')
+	column := 0
+	if strings.TrimSpace(cells[0]) == "" {
+		column = -1
+	}
+
+	for i := range cells {
+		if column >= 0 && column < len(kinds) {
+			cells[i] = f.text(cells[i], kinds[column])
+		}
+		column++
+	}
+
+	return strings.Join(cells, "|")
+}
+
+// Split a line on a separator, skipping escaped ones
+func splitUnescaped(line string, separator byte) []string {
+	parts := []string{}
+	start := 0
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if line[i] == separator {
+			parts = append(parts, line[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, line[start:])
+}
+
+// Format the numbers of a piece of text outside its protected spans
+func (f *numberFormatter) text(text string, kind numberKind) string {
+	result := strings.Builder{}
+	start := 0
+	for _, span := range protectedInlineRegex.FindAllStringIndex(text, -1) {
+		result.WriteString(f.numbers(text, start, span[0], kind))
+		result.WriteString(text[span[0]:span[1]])
+		start = span[1]
+	}
+	result.WriteString(f.numbers(text, start, len(text), kind))
+
+	return result.String()
+}
+
+// Format the numbers of text[start:end]. The whole text is used to check their surroundings
+func (f *numberFormatter) numbers(text string, start int, end int, kind numberKind) string {
+	segment := text[start:end]
+	result := strings.Builder{}
+	previous := 0
+	previousIdentifier := false
+
+	for _, match := range numberRegex.FindAllStringSubmatchIndex(segment, -1) {
+		matchStart, matchEnd := start+match[0], start+match[1]
+		result.WriteString(segment[previous:match[0]])
+		original := segment[match[0]:match[1]]
+
+		identifier := kind == numberIdentifier || f.followsIdentifier(text[:matchStart]) ||
+			(previousIdentifier && listSeparatorRegex.MatchString(segment[previous:match[0]]))
+		previous = match[1]
+		previousIdentifier = identifier && isolatedNumber(text, matchStart, matchEnd)
+
+		if identifier || !isolatedNumber(text, matchStart, matchEnd) {
+			result.WriteString(original)
+			continue
+		}
+
+		parts := numberParts{
+			currency: group(segment, match, 1),
+			sign:     group(segment, match, 2),
+			integer:  group(segment, match, 3),
+			decimals: group(segment, match, 4),
+			percent:  group(segment, match, 5),
+		}
+		monetary := parts.currency != "" ||
+			(parts.percent == "" && (kind == numberMonetary || f.followsMonetary(text[:matchStart])))
+		result.WriteString(f.format(parts, monetary, original))
+	}
+	result.WriteString(segment[previous:])
+
+	return result.String()
+}
+
+func group(text string, match []int, index int) string {
+	if match[2*index] < 0 {
+		return ""
+	}
+
+	return text[match[2*index]:match[2*index+1]]
+}
+
+// Whether a number stands on its own rather than being part of a word, date, time, version, range or
+// abbreviation, judging by the characters around it
+func isolatedNumber(text string, start int, end int) bool {
+	if start > 0 {
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		if isWordRune(before) || strings.ContainsRune(".,/:#@\\-^+~", before) {
+			return false
+		}
+	}
+
+	if end < len(text) {
+		after, size := utf8.DecodeRuneInString(text[end:])
+		if isWordRune(after) || after == '°' {
+			return false
+		}
+		if strings.ContainsRune(".,:/-", after) && end+size < len(text) {
+			next, _ := utf8.DecodeRuneInString(text[end+size:])
+			if unicode.IsDigit(next) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// Whether the text before a number ends naming an identifier, like "SKU " or "Store_Number: "
+func (f *numberFormatter) followsIdentifier(before string) bool {
+	before = strings.TrimRight(before, " \t:=#*")
+	words := strings.FieldsFunc(before, func(r rune) bool {
+		return !isWordRune(r)
+	})
+	if len(words) == 0 {
+		return false
+	}
+
+	word := strings.ToLower(words[len(words)-1])
+	if slices.Contains(identifierWords, word) {
+		return true
+	}
+
+	return slices.ContainsFunc(f.identifiers, func(pattern *regexp.Regexp) bool {
+		location := pattern.FindStringIndex(before)
+		return location != nil && location[1] == len(before)
+	})
+}
+
+// Whether a monetary column is named shortly before a number, on the same clause
+func (f *numberFormatter) followsMonetary(before string) bool {
+	if clauseEnds := clauseEndRegex.FindAllStringIndex(before, -1); len(clauseEnds) > 0 {
+		before = before[clauseEnds[len(clauseEnds)-1][1]:]
+	}
+
+	for _, pattern := range f.monetary {
+		locations := pattern.FindAllStringIndex(before, -1)
+		if len(locations) == 0 {
+			continue
+		}
+
+		between := before[locations[len(locations)-1][1]:]
+		if len(strings.Fields(between)) <= maxAmountDistance && !strings.ContainsFunc(between, unicode.IsDigit) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Pieces of a number matched on an answer
+type numberParts struct {
+	currency string
+	sign     string
+	integer  string // With or without English thousands separators
+	decimals string // With the leading point
+	percent  string
+}
+
+// Write a number with the policy separators. Amounts get the currency and exactly 2 decimals, other numbers up to
+// the max decimals. Numbers too long to be quantities, leading zero codes and short integers are kept as written
+func (f *numberFormatter) format(parts numberParts, monetary bool, original string) string {
+	digits := strings.ReplaceAll(parts.integer, ",", "")
+	if len(digits) > 15 || (len(digits) > 1 && digits[0] == '0') {
+		return original
+	}
+	// Years and other short integers read the same on every format
+	if !monetary && parts.decimals == "" && len(digits) < 5 {
+		return original
+	}
+
+	value, err := strconv.ParseFloat(digits+parts.decimals, 64)
+	if err != nil {
+		return original
+	}
+
+	decimals := f.policy.MaxDecimals
+	if monetary {
+		decimals = 2
+	}
+	formatted := strconv.FormatFloat(value, 'f', decimals, 64)
+	integer, fraction, _ := strings.Cut(formatted, ".")
+	if !monetary {
+		fraction = strings.TrimRight(fraction, "0")
+	}
+
+	// Values too small for the decimals would read as zero
+	if value != 0 && strings.Trim(integer+fraction, "0") == "" {
+		return original
+	}
+
+	number := groupThousands(integer, f.separators.thousands)
+	if fraction != "" {
+		number += f.separators.decimal + fraction
+	}
+
+	currency := strings.TrimSpace(parts.currency)
+	if monetary && currency == "" {
+		currency = f.policy.Currency
+	}
+
+	return fmt.Sprintf("%s%s%s%s", parts.sign, currency, number, parts.percent)
+}
+
+// Insert a separator every 3 digits of an integer, from the right
+func groupThousands(integer string, separator string) string {
+	if len(integer) <= 3 {
+		return integer
+	}
+
+	groups := []string{}
+	head := len(integer) % 3
+	if head > 0 {
+		groups = append(groups, integer[:head])
+	}
+	for i := head; i < len(integer); i += 3 {
+		groups = append(groups, integer[i:i+3])
+	}
+
+	return strings.Join(groups, separator)
+}
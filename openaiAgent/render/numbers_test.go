@@ -0,0 +1,83 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/language"
+)
+
+// Policy of the sales dataset: sale values are amounts, SKUs and store numbers are codes
+var salesPolicy = NumberPolicy{
+	MonetaryColumns:   []string{"Total_Sale_Value"},
+	IdentifierColumns: []string{"SKU_Coded", "Store_Number"},
+}
+
+func TestFormatNumbers(t *testing.T) {
+	cases := []struct {
+		name   string
+		answer string
+		policy NumberPolicy
+		want   string
+	}{
+		// Quantities
+		{name: "thousands", answer: "Sold 1036043 units", want: "Sold 1,036,043 units"},
+		{name: "rounded decimals", answer: "A ratio of 1.23456", want: "A ratio of 1.23"},
+		{name: "negative decimals", answer: "Elasticity is -0.12345", want: "Elasticity is -0.12"},
+		{name: "rounding carries to thousands", answer: "The cost 99999.999 was high", want: "The cost 100,000 was high"},
+		{name: "trailing zeros dropped", answer: "Average 12345.10 units", want: "Average 12,345.1 units"},
+		{name: "already grouped", answer: "Sold 1,036,043.456 units", want: "Sold 1,036,043.46 units"},
+		{name: "short integers", answer: "Sold 1234 units in 42 stores", want: "Sold 1234 units in 42 stores"},
+		{name: "tiny values", answer: "A share of 0.0001", want: "A share of 0.0001"},
+		{name: "leading zeros", answer: "Batch 00123456 shipped", want: "Batch 00123456 shipped"},
+
+		// Percentages
+		{name: "percentage", answer: "Promotions were 0.6255964% of sales", want: "Promotions were 0.63% of sales"},
+		{name: "spaced percentage", answer: "Up 12.3456 %", want: "Up 12.35 %"},
+		{name: "percentage never an amount", answer: "Total_Sale_Value grew 4.5678%", policy: salesPolicy, want: "Total_Sale_Value grew 4.57%"},
+
+		// Years and dates
+		{name: "years", answer: "Between 2021 and 2024 sales grew", want: "Between 2021 and 2024 sales grew"},
+		{name: "year word", answer: "Fiscal year 20245 closed", want: "Fiscal year 20245 closed"},
+		{name: "dates", answer: "From 2021-11-01 to 01/02/2021", want: "From 2021-11-01 to 01/02/2021"},
+		{name: "times and versions", answer: "At 12:30:45 on v1.2.3456", want: "At 12:30:45 on v1.2.3456"},
+
+		// Codes
+		{name: "SKU", answer: "SKU 6172800 sold the most", want: "SKU 6172800 sold the most"},
+		{name: "SKU list", answer: "SKUs 6172800, 7654321 and 1234567 lead", want: "SKUs 6172800, 7654321 and 1234567 lead"},
+		{name: "store code", answer: "Store #13205 leads", want: "Store #13205 leads"},
+		{name: "identifier column", answer: "SKU_Coded: 6172800 sold 12345 units", policy: salesPolicy, want: "SKU_Coded: 6172800 sold 12,345 units"},
+		{name: "abbreviated", answer: "About 1.2345M units", want: "About 1.2345M units"},
+
+		// Amounts
+		{name: "currency symbol", answer: "Revenue was $1234.5", want: "Revenue was $1,234.50"},
+		{name: "monetary column", answer: "Total_Sale_Value reached 13272425.079999", policy: salesPolicy, want: "Total_Sale_Value reached $13,272,425.08"},
+		{name: "monetary column clause", answer: "Total_Sale_Value is high. We sold 12345.5 units", policy: salesPolicy, want: "Total_Sale_Value is high. We sold 12,345.5 units"},
+		{name: "currency of the policy", answer: "Total_Sale_Value of 1234.5", policy: NumberPolicy{MonetaryColumns: []string{"Total_Sale_Value"}, Currency: "€"}, want: "Total_Sale_Value of €1,234.50"},
+
+		// Locales and decimals
+		{name: "spanish", answer: "Vendió 1234567.891 unidades", policy: NumberPolicy{Locale: language.Spanish}, want: "Vendió 1.234.567,89 unidades"},
+		{name: "french", answer: "Vendu 1234567.891 unités", policy: NumberPolicy{Locale: language.French}, want: "Vendu 1\u202f234\u202f567,89 unités"},
+		{name: "unknown locale", answer: "Sold 12345.5", policy: NumberPolicy{Locale: "xx"}, want: "Sold 12,345.5"},
+		{name: "max decimals", answer: "A ratio of 1.234567", policy: NumberPolicy{MaxDecimals: 4}, want: "A ratio of 1.2346"},
+
+		// Protected text
+		{name: "inline code", answer: "Run `LIMIT 12345.678` on 12345.678", want: "Run `LIMIT 12345.678` on 12,345.68"},
+		{name: "links", answer: "See [12345.678](https://example.com/12345.678)", want: "See [12,345.68](https://example.com/12345.678)"},
+		{name: "code block", answer: "```\nx = 12345.678\n```\n12345.678", want: "```\nx = 12345.678\n```\n12,345.68"},
+		{name: "csv table", answer: "Store_Number,units\n1320,123456.789", want: "Store_Number,units\n1320,123456.789"},
+		{
+			name:   "markdown table",
+			answer: "| Store_Number | Total_Sale_Value | units |\n|---|---|---|\n| 13205 | 1234.5 | 123456.789 |",
+			policy: salesPolicy,
+			want:   "| Store_Number | Total_Sale_Value | units |\n|---|---|---|\n| 13205 | $1,234.50 | 123,456.79 |",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := FormatNumbers(c.answer, c.policy); got != c.want {
+				t.Errorf("FormatNumbers(%q)\n got: %q\nwant: %q", c.answer, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,449 @@
+// Package render formats agent answers for the terminal: Markdown as it is, plain text without its
+// formatting, or with the Markdown and CSV tables of the answer drawn as aligned columns.
+package render
+
+import (
+	"encoding/csv"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/width"
+)
+
+/*
+--------------
+Answer formats
+--------------
+*/
+
+// Output format of an answer
+type Format string
+
+const (
+	Plain    Format = "plain"    // Without Markdown formatting, tables as space aligned columns
+	Markdown Format = "markdown" // The answer as the model wrote it
+	JSON     Format = "json"     // The whole run result, rendered by the caller
+	Table    Format = "table"    // The answer as written, with its tables drawn as bordered columns
+)
+
+var Formats = []Format{Plain, Markdown, JSON, Table}
+
+// Parse an output format name, case insensitive
+func ParseFormat(name string) (Format, error) {
+	format := Format(strings.ToLower(strings.TrimSpace(name)))
+	if !slices.Contains(Formats, format) {
+		return "", fmt.Errorf("unknown output format '%s', expected plain, markdown, json or table", name)
+	}
+
+	return format, nil
+}
+
+// Render an answer on a text format. JSON answers are left as they are, they need the whole run result
+func Answer(answer string, format Format) string {
+	switch format {
+	case Plain:
+		return renderBlocks(parseBlocks(answer), true)
+	case Table:
+		return renderBlocks(parseBlocks(answer), false)
+	default:
+		return answer
+	}
+}
+
+/*
+------
+Blocks
+------
+*/
+
+// Part of an answer: text lines, a fenced code block or a table
+type block struct {
+	lines  []string   // Original lines, kept for text and code
+	code   bool       // Fenced code block, fence lines included
+	rows   [][]string // Table cells, header first
+	aligns []align    // Column alignment of tables
+}
+
+type align int
+
+const (
+	alignLeft align = iota
+	alignRight
+	alignCenter
+)
+
+// Opening or closing line of a fenced code block, up to 3 spaces indented
+var fenceRegex = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+
+// Delimiter row of a Markdown table, like "|---|:--:|"
+var delimiterRegex = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+
+// Max words on a CSV field, longer ones are prose with commas rather than data
+const maxCSVFieldWords = 4
+
+// Split an answer on text, code and table blocks. Tables inside code blocks are kept as code
+func parseBlocks(answer string) []block {
+	lines := strings.Split(answer, "\n")
+	blocks := []block{}
+	text := []string{}
+	flushText := func() {
+		if len(text) > 0 {
+			blocks = append(blocks, block{lines: text})
+			text = []string{}
+		}
+	}
+
+	for i := 0; i < len(lines); {
+		if end, ok := fencedBlockEnd(lines, i); ok {
+			flushText()
+			blocks = append(blocks, block{lines: lines[i:end], code: true})
+			i = end
+			continue
+		}
+
+		if end, table, ok := markdownTable(lines, i); ok {
+			flushText()
+			blocks = append(blocks, table)
+			i = end
+			continue
+		}
+
+		// CSV tables must be a whole paragraph
+		if len(text) == 0 || strings.TrimSpace(text[len(text)-1]) == "" {
+			if end, table, ok := csvTable(lines, i); ok {
+				flushText()
+				blocks = append(blocks, table)
+				i = end
+				continue
+			}
+		}
+
+		text = append(text, lines[i])
+		i++
+	}
+	flushText()
+
+	return blocks
+}
+
+// End of the fenced code block opened at a line. The fence is closed by a line of the same character at least
+// as long as the opening one, so shorter fences inside it are content. Unclosed fences run to the end
+func fencedBlockEnd(lines []string, start int) (int, bool) {
+	match := fenceRegex.FindStringSubmatch(lines[start])
+	if match == nil {
+		return 0, false
+	}
+
+	fence := match[1]
+	for i := start + 1; i < len(lines); i++ {
+		closing := strings.TrimSpace(lines[i])
+		if len(closing) >= len(fence) && strings.Trim(closing, fence[:1]) == "" {
+			return i + 1, true
+		}
+	}
+
+	return len(lines), true
+}
+
+// Markdown table starting at a line: a header row, a delimiter row and the rows containing pipes after them
+func markdownTable(lines []string, start int) (int, block, bool) {
+	if start+1 >= len(lines) || !strings.Contains(lines[start], "|") || !delimiterRegex.MatchString(lines[start+1]) {
+		return 0, block{}, false
+	}
+
+	header := tableCells(lines[start])
+	delimiters := tableCells(lines[start+1])
+	if len(header) != len(delimiters) {
+		return 0, block{}, false
+	}
+
+	table := block{rows: [][]string{header}}
+	for _, delimiter := range delimiters {
+		switch {
+		case strings.HasPrefix(delimiter, ":") && strings.HasSuffix(delimiter, ":"):
+			table.aligns = append(table.aligns, alignCenter)
+		case strings.HasSuffix(delimiter, ":"):
+			table.aligns = append(table.aligns, alignRight)
+		default:
+			table.aligns = append(table.aligns, alignLeft)
+		}
+	}
+
+	end := start + 2
+	for ; end < len(lines) && strings.Contains(lines[end], "|") && strings.TrimSpace(lines[end]) != ""; end++ {
+		row := tableCells(lines[end])
+
+		// Rows may have fewer or more cells than the header, like on GitHub
+		row = append(row, make([]string, max(0, len(header)-len(row)))...)
+		table.rows = append(table.rows, row[:len(header)])
+	}
+
+	return end, table, true
+}
+
+// Cells of a Markdown table row. Escaped pipes are part of the cell
+func tableCells(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+
+	cells := []string{}
+	cell := strings.Builder{}
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// CSV table filling the paragraph starting at a line: at least a header and a row, all with the same amount
+// of fields, none of them long enough to be prose. Numeric columns are right aligned
+func csvTable(lines []string, start int) (int, block, bool) {
+	end := start
+	for end < len(lines) && strings.TrimSpace(lines[end]) != "" && !fenceRegex.MatchString(lines[end]) {
+		end++
+	}
+	if end-start < 2 {
+		return 0, block{}, false
+	}
+
+	reader := csv.NewReader(strings.NewReader(strings.Join(lines[start:end], "\n")))
+	reader.TrimLeadingSpace = true
+	reader.LazyQuotes = true
+	rows, err := reader.ReadAll()
+	if err != nil || len(rows) != end-start || len(rows[0]) < 2 {
+		return 0, block{}, false
+	}
+
+	for _, row := range rows {
+		for i, field := range row {
+			row[i] = strings.TrimSpace(field)
+			if len(strings.Fields(field)) > maxCSVFieldWords {
+				return 0, block{}, false
+			}
+		}
+	}
+
+	table := block{rows: rows}
+	for column := range rows[0] {
+		numeric := true
+		for _, row := range rows[1:] {
+			if _, err := strconv.ParseFloat(row[column], 64); err != nil && row[column] != "" {
+				numeric = false
+			}
+		}
+
+		table.aligns = append(table.aligns, alignLeft)
+		if numeric {
+			table.aligns[column] = alignRight
+		}
+	}
+
+	return end, table, true
+}
+
+/*
+---------
+Rendering
+---------
+*/
+
+func renderBlocks(blocks []block, plain bool) string {
+	lines := []string{}
+	for _, block := range blocks {
+		switch {
+		case block.code && plain:
+			// Keep the code, drop its fences
+			lines = append(lines, block.lines[1:]...)
+			if len(block.lines) > 1 && fenceRegex.MatchString(block.lines[len(block.lines)-1]) {
+				lines = lines[:len(lines)-1]
+			}
+		case block.code:
+			lines = append(lines, block.lines...)
+		case block.rows != nil:
+			lines = append(lines, renderTable(block, plain)...)
+		case plain:
+			for _, line := range block.lines {
+				if line, ok := plainLine(line); ok {
+					lines = append(lines, line)
+				}
+			}
+		default:
+			lines = append(lines, block.lines...)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Draw a table with aligned columns: bordered, or separated by spaces with plain text cells
+func renderTable(table block, plain bool) []string {
+	rows := [][]string{}
+	for _, row := range table.rows {
+		if plain {
+			row = slices.Clone(row)
+			for i, cell := range row {
+				row[i] = plainInline(cell)
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], displayWidth(cell))
+		}
+	}
+
+	formatRow := func(row []string) string {
+		cells := []string{}
+		for i, cell := range row {
+			cells = append(cells, pad(cell, widths[i], table.aligns[i]))
+		}
+
+		if plain {
+			return strings.TrimRightFunc(strings.Join(cells, "  "), unicode.IsSpace)
+		}
+		return "| " + strings.Join(cells, " | ") + " |"
+	}
+
+	separators := []string{}
+	for _, columnWidth := range widths {
+		separators = append(separators, strings.Repeat("-", columnWidth))
+	}
+
+	if plain {
+		lines := []string{formatRow(rows[0]), strings.Join(separators, "  ")}
+		for _, row := range rows[1:] {
+			lines = append(lines, formatRow(row))
+		}
+		return lines
+	}
+
+	border := "+-" + strings.Join(separators, "-+-") + "-+"
+	lines := []string{border, formatRow(rows[0]), border}
+	for _, row := range rows[1:] {
+		lines = append(lines, formatRow(row))
+	}
+	return append(lines, border)
+}
+
+// Columns a text takes on a terminal: wide east asian characters take two, combining marks none
+func displayWidth(text string) int {
+	columns := 0
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r) || r == '‍':
+			continue
+		case width.LookupRune(r).Kind() == width.EastAsianWide || width.LookupRune(r).Kind() == width.EastAsianFullwidth:
+			columns += 2
+		default:
+			columns++
+		}
+	}
+
+	return columns
+}
+
+// Pad a cell to a column width with its alignment
+func pad(cell string, columnWidth int, alignment align) string {
+	padding := columnWidth - displayWidth(cell)
+	switch alignment {
+	case alignRight:
+		return strings.Repeat(" ", padding) + cell
+	case alignCenter:
+		return strings.Repeat(" ", padding/2) + cell + strings.Repeat(" ", padding-padding/2)
+	default:
+		return cell + strings.Repeat(" ", padding)
+	}
+}
+
+/*
+----------------
+Plain formatting
+----------------
+*/
+
+var headingRegex = regexp.MustCompile(`^ {0,3}#{1,6}\s+`)
+var closingHeadingRegex = regexp.MustCompile(`\s+#+\s*$`)
+var quoteRegex = regexp.MustCompile(`^ {0,3}>\s?`)
+var ruleRegex = regexp.MustCompile(`^ {0,3}([-*_])(\s*([-*_]))+\s*$`)
+var starBulletRegex = regexp.MustCompile(`^(\s*)[*+]\s+`)
+var imageRegex = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+var linkRegex = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)(?:\s+"[^"]*")?\)`)
+var inlineCodeRegex = regexp.MustCompile("(`+)(.+?)(`+)")
+var strongRegex = regexp.MustCompile(`(\*\*|__)(\S(?:.*?\S)?)(\*\*|__)`)
+var strikeRegex = regexp.MustCompile(`~~(\S(?:.*?\S)?)~~`)
+var emphasisStarRegex = regexp.MustCompile(`\*(\S(?:[^*]*?\S)?)\*`)
+
+// Underscores inside words, like on column names, aren't emphasis
+var emphasisUnderscoreRegex = regexp.MustCompile(`(^|[^\p{L}\p{N}_])_(\S(?:[^_]*?\S)?)_($|[^\p{L}\p{N}_])`)
+var escapeRegex = regexp.MustCompile("\\\\([\\\\`*_{}\\[\\]()#+\\-.!|~>])")
+
+// Text of a Markdown line without its formatting. Rule lines are dropped
+func plainLine(line string) (string, bool) {
+	if ruleRegex.MatchString(line) {
+		return "", false
+	}
+
+	if headingRegex.MatchString(line) {
+		line = closingHeadingRegex.ReplaceAllString(headingRegex.ReplaceAllString(line, ""), "")
+	}
+	line = quoteRegex.ReplaceAllString(line, "")
+	line = starBulletRegex.ReplaceAllString(line, "$1- ")
+
+	return plainInline(line), true
+}
+
+// Text without inline formatting: emphasis, links, images, code spans and escapes. Code spans are kept as written
+func plainInline(text string) string {
+	// Set code spans apart so their content isn't unformatted, and escaped characters so they aren't formatting
+	spans := []string{}
+	text = inlineCodeRegex.ReplaceAllStringFunc(text, func(span string) string {
+		match := inlineCodeRegex.FindStringSubmatch(span)
+		if match[1] != match[3] {
+			return span
+		}
+		spans = append(spans, strings.TrimSpace(match[2]))
+		return fmt.Sprintf("\x00%d\x00", len(spans)-1)
+	})
+	text = escapeRegex.ReplaceAllStringFunc(text, func(escape string) string {
+		spans = append(spans, escape[1:])
+		return fmt.Sprintf("\x00%d\x00", len(spans)-1)
+	})
+
+	text = imageRegex.ReplaceAllString(text, "$1")
+	text = linkRegex.ReplaceAllStringFunc(text, func(link string) string {
+		match := linkRegex.FindStringSubmatch(link)
+		if match[1] == match[2] {
+			return match[2]
+		}
+		return fmt.Sprintf("%s (%s)", match[1], match[2])
+	})
+	text = strongRegex.ReplaceAllString(text, "$2")
+	text = strikeRegex.ReplaceAllString(text, "$1")
+	text = emphasisStarRegex.ReplaceAllString(text, "$1")
+	text = emphasisUnderscoreRegex.ReplaceAllString(text, "$1$2$3")
+
+	for i, span := range spans {
+		text = strings.Replace(text, fmt.Sprintf("\x00%d\x00", i), span, 1)
+	}
+
+	return text
+}
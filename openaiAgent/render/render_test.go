@@ -0,0 +1,169 @@
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFormat(t *testing.T) {
+	cases := []struct {
+		name string
+		want Format // Empty when parsing must fail
+	}{
+		{name: "plain", want: Plain},
+		{name: " Markdown ", want: Markdown},
+		{name: "JSON", want: JSON},
+		{name: "table", want: Table},
+		{name: "yaml"},
+		{name: ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			format, err := ParseFormat(c.name)
+			if c.want == "" {
+				if err == nil {
+					t.Fatalf("parsed as %s, expected an error", format)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != c.want {
+				t.Errorf("parsed as %s, expected %s", format, c.want)
+			}
+		})
+	}
+}
+
+// Answers rendered on each text format. Lines are joined with \n, so the wanted output reads as it prints
+func TestAnswer(t *testing.T) {
+	cases := []struct {
+		name   string
+		answer []string
+		format Format
+		want   []string
+	}{
+		{
+			name:   "markdown as written",
+			answer: []string{"# Sales", "", "| Store | Sales |", "|---|--:|", "| 1320 | 55 |"},
+			format: Markdown,
+			want:   []string{"# Sales", "", "| Store | Sales |", "|---|--:|", "| 1320 | 55 |"},
+		},
+		{
+			name:   "json left to the caller",
+			answer: []string{"**Total** sales were 42."},
+			format: JSON,
+			want:   []string{"**Total** sales were 42."},
+		},
+		{
+			name:   "plain formatting",
+			answer: []string{"## Top stores ##", "> **Store 1320** sold the _most_, see [the chart](chart-1.png)", "* `Total_Sale_Value` is in dollars", "---", "Done \\*really\\*"},
+			format: Plain,
+			want:   []string{"Top stores", "Store 1320 sold the most, see the chart (chart-1.png)", "- Total_Sale_Value is in dollars", "Done *really*"},
+		},
+		{
+			name:   "underscores inside words",
+			answer: []string{"Grouped by Store_Number and Product_Class_Code"},
+			format: Plain,
+			want:   []string{"Grouped by Store_Number and Product_Class_Code"},
+		},
+		{
+			name:   "plain table",
+			answer: []string{"| Store | **Sales** |", "|:--|--:|", "| 1320 | 55.5 |", "| 7 | 1200 |"},
+			format: Plain,
+			want:   []string{"Store  Sales", "-----  -----", "1320    55.5", "7       1200"},
+		},
+		{
+			name:   "bordered table",
+			answer: []string{"Sales by store:", "| Store | Sales |", "|---|:---:|", "| 1320 | 55 |", "| 7 | 1200 |", "", "Store 7 leads."},
+			format: Table,
+			want: []string{
+				"Sales by store:",
+				"+-------+-------+",
+				"| Store | Sales |",
+				"+-------+-------+",
+				"| 1320  |  55   |",
+				"| 7     | 1200  |",
+				"+-------+-------+",
+				"",
+				"Store 7 leads.",
+			},
+		},
+		{
+			name:   "wide unicode cells",
+			answer: []string{"| 店舗 | Café |", "|---|---|", "| 東京 | e\u0301 |", "| a | 🇦🇷 |"},
+			format: Table,
+			want: []string{
+				"+------+------+",
+				"| 店舗 | Café |",
+				"+------+------+",
+				"| 東京 | e\u0301    |",
+				"| a    | 🇦🇷   |",
+				"+------+------+",
+			},
+		},
+		{
+			name:   "ragged rows and escaped pipes",
+			answer: []string{"| Class | Note |", "|---|---|", "| A \\| B |", "| C | ok | extra |"},
+			format: Table,
+			want: []string{
+				"+-------+------+",
+				"| Class | Note |",
+				"+-------+------+",
+				"| A | B |      |",
+				"| C     | ok   |",
+				"+-------+------+",
+			},
+		},
+		{
+			name:   "csv table",
+			answer: []string{"Totals:", "", "store,units,region", "1320,55,North East", "7,1200,South"},
+			format: Table,
+			want: []string{
+				"Totals:",
+				"",
+				"+-------+-------+------------+",
+				"| store | units | region     |",
+				"+-------+-------+------------+",
+				"|  1320 |    55 | North East |",
+				"|     7 |  1200 | South      |",
+				"+-------+-------+------------+",
+			},
+		},
+		{
+			name:   "csv prose left as text",
+			answer: []string{"Sales rose, units fell", "Promotions helped the stores that ran them for more than a week, mostly"},
+			format: Table,
+			want:   []string{"Sales rose, units fell", "Promotions helped the stores that ran them for more than a week, mostly"},
+		},
+		{
+			name:   "table in a code block kept as code",
+			answer: []string{"```", "| a | b |", "|---|---|", "| 1 | 2 |", "```"},
+			format: Table,
+			want:   []string{"```", "| a | b |", "|---|---|", "| 1 | 2 |", "```"},
+		},
+		{
+			name:   "nested code fences",
+			answer: []string{"````markdown", "```sql", "SELECT *", "```", "| a | b |", "|---|---|", "````", "**after**"},
+			format: Plain,
+			want:   []string{"```sql", "SELECT *", "```", "| a | b |", "|---|---|", "after"},
+		},
+		{
+			name:   "unclosed code fence",
+			answer: []string{"Query:", "~~~", "SELECT **1**"},
+			format: Plain,
+			want:   []string{"Query:", "SELECT **1**"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rendered := Answer(strings.Join(c.answer, "\n"), c.format)
+			if want := strings.Join(c.want, "\n"); rendered != want {
+				t.Errorf("rendered\n%s\nexpected\n%s", rendered, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+// Package server answers agent runs over HTTP.
+//
+// Create a Server with New over an agent and serve it with net/http. Each run gets its own artifacts
+// directory, served back under /v1/runs/<run_id>/artifacts/.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/render"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+)
+
+/*
+-----------
+HTTP server
+-----------
+*/
+
+// Max size of a run request body
+const maxRequestBytes = 1 << 20
+
+// Server settings. Zero values fall back to the default noted on each field
+type Options struct {
+	KeepRuns     int                // Amount of most recent run directories kept after each run. Zero keeps all
+	LoadShedding agent.LoadShedding // Runs served at once and the deadline of each. Zero values never shed nor degrade runs
+}
+
+// Body of a run request
+type RunRequest struct {
+	Prompt    string            `json:"prompt"`
+	Tools     []string          `json:"tools,omitempty"`     // Tools allowed on the run, like ["lookup", "analyze"]. Empty allows the ones of the server
+	Format    string            `json:"format,omitempty"`    // Format of the answer: plain, markdown, json or table. Empty is markdown
+	Verbosity string            `json:"verbosity,omitempty"` // Answer depth: brief, normal or detailed. Empty keeps the one of the server
+	Tags      map[string]string `json:"tags,omitempty"`      // Metadata tags of the run, merged over the ones of the server
+	Explain   bool              `json:"explain,omitempty"`   // Append how the answer was produced and reply with its provenance
+}
+
+// Body of a completed run
+type RunResponse struct {
+	RunID        string            `json:"runId"`
+	Answer       string            `json:"answer"`
+	Artifacts    []string          `json:"artifacts"`              // Paths of the run artifacts on this server
+	ArtifactURLs map[string]string `json:"artifactUrls,omitempty"` // URLs of the published artifacts, keyed by their name
+	Degraded     []string          `json:"degraded,omitempty"`     // Optional steps skipped as the request deadline was near, see agent.Degraded*
+	Provenance   *agent.Provenance `json:"provenance,omitempty"`   // Tools, queries and models behind the answer, with explain or the server -explain
+	Result       *agent.RunResult  `json:"result,omitempty"`       // Whole run result, only on the json format
+}
+
+// HTTP handler running the agent for each request
+type Server struct {
+	agent   *agent.Agent
+	options Options
+	mux     *http.ServeMux
+}
+
+// Create a server running a. Warming a up and closing it are left to the caller, /readyz replies 503 until it's warm.
+// Run requests go through a load shedder with the LoadShedding of options
+func New(a *agent.Agent, options Options) *Server {
+	s := &Server{agent: a, options: options, mux: http.NewServeMux()}
+	s.mux.Handle("POST /v1/runs", agent.NewLoadShedder(options.LoadShedding).Middleware(http.HandlerFunc(s.handleRun)))
+	s.mux.HandleFunc("GET /v1/runs/{runID}/artifacts/{name...}", s.handleArtifact)
+	s.mux.HandleFunc("GET /readyz", s.handleReadyz)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Path an artifact of a run is served on
+func ArtifactPath(runID string, name string) string {
+	return fmt.Sprintf("/v1/runs/%s/artifacts/%s", runID, name)
+}
+
+// Answer a prompt, replying with a RunResponse. The run transcript is written next to its artifacts
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	request := RunRequest{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&request); err != nil {
+		writeError(w, exitcode.Errorf(exitcode.ClassUsage, "invalid run request: %w", err))
+		return
+	}
+	if request.Prompt == "" {
+		writeError(w, exitcode.Errorf(exitcode.ClassUsage, "run request has no prompt"))
+		return
+	}
+	format := render.Markdown
+	if request.Format != "" {
+		parsed, err := render.ParseFormat(request.Format)
+		if err != nil {
+			writeError(w, exitcode.New(exitcode.ClassUsage, err))
+			return
+		}
+		format = parsed
+	}
+
+	// With an access config, requests run as the role of their API key, which is their principal too
+	runAgent := s.agent
+	if access := s.agent.Access(); access != nil {
+		role, err := access.RoleForRequest(r)
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, exitcode.NewReport(exitcode.New(exitcode.ClassUsage, err)))
+			return
+		}
+		runAgent = runAgent.WithPrincipal(role, role)
+	}
+
+	// Requests can only narrow the tools of the server, never enable more
+	runAgent, err := runAgent.WithTools(request.Tools)
+	if err != nil {
+		writeError(w, exitcode.New(exitcode.ClassUsage, err))
+		return
+	}
+
+	// Requests may answer another audience than the server default
+	if request.Verbosity != "" {
+		runAgent, err = runAgent.WithVerbosity(request.Verbosity)
+		if err != nil {
+			writeError(w, exitcode.New(exitcode.ClassUsage, err))
+			return
+		}
+	}
+
+	// Request tags win over the server defaults on shared keys
+	if len(request.Tags) != 0 {
+		runAgent, err = runAgent.WithTags(request.Tags)
+		if err != nil {
+			writeError(w, exitcode.New(exitcode.ClassUsage, err))
+			return
+		}
+	}
+
+	if request.Explain {
+		runAgent = runAgent.WithExplain()
+	}
+
+	result, err := runAgent.Run(r.Context(), request.Prompt)
+	if result.RunID != "" {
+		if _, err := agent.WriteTranscript(result, err != nil); err != nil {
+			log.Printf("WARNING: Failed to write transcript: %s\n", err)
+		}
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := tools.PruneRuns(s.agent.OutputDir(), s.options.KeepRuns); err != nil {
+		log.Printf("WARNING: %s\n", err)
+	}
+
+	response := RunResponse{
+		RunID:        result.RunID,
+		Answer:       render.Answer(result.Answer, format),
+		Artifacts:    []string{},
+		ArtifactURLs: result.ArtifactURLs,
+		Degraded:     result.Degraded,
+		Provenance:   result.Provenance,
+	}
+	if format == render.JSON {
+		response.Result = &result
+	}
+	for _, name := range result.Artifacts {
+		response.Artifacts = append(response.Artifacts, ArtifactPath(result.RunID, name))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Serve a file of a run directory. Only run directories are reachable, and nothing outside them
+func (s *Server) handleArtifact(w http.ResponseWriter, r *http.Request) {
+	runID, name := r.PathValue("runID"), r.PathValue("name")
+	if _, ok := tools.RunStartTime(runID); !ok || !fs.ValidPath(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	runDir, err := os.OpenRoot(filepath.Join(s.agent.OutputDir(), runID))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer runDir.Close()
+
+	http.ServeFileFS(w, r, runDir.FS(), name)
+}
+
+// Reply with the readiness of the agent: 503 until its warm up finished, then 200 with the status of each component.
+// Failed components are reported without failing the probe, the server keeps serving what still works
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	readiness := s.agent.Readiness()
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, readiness)
+}
+
+// HTTP status of a failure, from its class
+func statusOf(err error) int {
+	switch exitcode.Classify(err) {
+	case exitcode.ClassUsage:
+		return http.StatusBadRequest
+	case exitcode.ClassUpstream:
+		return http.StatusBadGateway
+	case exitcode.ClassData:
+		return http.StatusUnprocessableEntity
+	case exitcode.ClassBudget:
+		return http.StatusGatewayTimeout
+	case exitcode.ClassInterrupted:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Reply with the error report of a failure
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, statusOf(err), exitcode.NewReport(err))
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("WARNING: Failed to write response: %s\n", err)
+	}
+}
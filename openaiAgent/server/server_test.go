@@ -0,0 +1,639 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/agent"
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/prompts"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tools"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"go.opentelemetry.io/otel/attribute"
+	traceSdk "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Absolute path of a file under the module root. Relative config paths resolve against the workspace instead
+func modulePath(t *testing.T, elem ...string) string {
+	t.Helper()
+	path, err := filepath.Abs(filepath.Join(append([]string{".."}, elem...)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Create an agent on the sales fixture replying with the given completions, with its artifacts in a temp dir.
+// configure, if any, sets other fields of its config
+func newTestAgent(t *testing.T, configure func(*agent.Config), responses ...*openai.ChatCompletion) (*agent.Agent, *mock.Completer) {
+	t.Helper()
+	completer := mock.NewCompleter(responses...)
+	config := agent.Config{
+		DataPath:      modulePath(t, "testdata", "datasets", "sales.parquet"),
+		ToolsJsonPath: modulePath(t, tools.DefaultToolsJsonPath),
+		OutputDir:     t.TempDir(),
+		DisableAudit:  true,
+		Completer:     completer,
+	}
+	if configure != nil {
+		configure(&config)
+	}
+
+	runAgent, err := agent.New(config)
+	if err != nil {
+		t.Fatalf("failed to create agent: %s", err)
+	}
+	t.Cleanup(func() { runAgent.Close() })
+
+	return runAgent, completer
+}
+
+// Responses of a run looking up the total sales once, then answering
+func lookupResponses() []*openai.ChatCompletion {
+	return []*openai.ChatCompletion{
+		mock.ToolCallResponse("call_1", tools.LookUpFuncName, map[string]string{"prompt": "Total sales value"}),
+		mock.TextResponse("SELECT round(sum(Total_Sale_Value), 2) AS total_sales FROM sales"),
+		mock.TextResponse("Total sales were **66.75**."),
+	}
+}
+
+// Post a run request, decoding the reply into response when it's a success
+func postRun(t *testing.T, serverURL string, body string, response any, header http.Header) *http.Response {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodPost, serverURL+"/v1/runs", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+
+	reply, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("run request failed: %s", err)
+	}
+	defer reply.Body.Close()
+
+	if reply.StatusCode == http.StatusOK && response != nil {
+		if err := json.NewDecoder(reply.Body).Decode(response); err != nil {
+			t.Fatalf("invalid run response: %s", err)
+		}
+	}
+	return reply
+}
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	reply, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	defer reply.Body.Close()
+
+	body, _ := io.ReadAll(reply.Body)
+	return reply.StatusCode, string(body)
+}
+
+func TestRunServesArtifacts(t *testing.T) {
+	runAgent, _ := newTestAgent(t, nil, lookupResponses()...)
+	testServer := httptest.NewServer(New(runAgent, Options{}))
+	defer testServer.Close()
+
+	response := RunResponse{}
+	reply := postRun(t, testServer.URL, `{"prompt": "What were the total sales?"}`, &response, nil)
+	if reply.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", reply.StatusCode)
+	}
+	if response.Answer != "Total sales were **66.75**." {
+		t.Errorf("unexpected answer: %s", response.Answer)
+	}
+	if len(response.Artifacts) == 0 {
+		t.Fatal("expected the lookup CSV among the artifacts")
+	}
+
+	for _, artifact := range response.Artifacts {
+		if !strings.HasPrefix(artifact, "/v1/runs/"+response.RunID+"/artifacts/") {
+			t.Errorf("artifact %s isn't served under its run", artifact)
+		}
+		status, body := get(t, testServer.URL+artifact)
+		if status != http.StatusOK || body == "" {
+			t.Errorf("expected artifact %s served, got %d", artifact, status)
+		}
+	}
+
+	status, body := get(t, testServer.URL+ArtifactPath(response.RunID, "lookup-1.csv"))
+	if status != http.StatusOK || !strings.Contains(body, "total_sales") {
+		t.Errorf("expected the lookup CSV, got %d: %s", status, body)
+	}
+}
+
+func TestArtifactsStayInsideRunDirectories(t *testing.T) {
+	runAgent, _ := newTestAgent(t, nil, lookupResponses()...)
+	testServer := httptest.NewServer(New(runAgent, Options{}))
+	defer testServer.Close()
+
+	response := RunResponse{}
+	postRun(t, testServer.URL, `{"prompt": "What were the total sales?"}`, &response, nil)
+
+	for _, path := range []string{
+		ArtifactPath(response.RunID, "missing.csv"),
+		ArtifactPath("20240101T000000-unknown", "lookup-1.csv"),
+		ArtifactPath("reports", "lookup-1.csv"),
+		"/v1/runs/" + response.RunID + "/artifacts/..%2F..%2Fdata.db",
+		"/v1/runs/" + response.RunID + "/artifacts/%2Fetc%2Fpasswd",
+	} {
+		if status, _ := get(t, testServer.URL+path); status != http.StatusNotFound {
+			t.Errorf("expected 404 for %s, got %d", path, status)
+		}
+	}
+}
+
+func TestRunRequestErrors(t *testing.T) {
+	runAgent, _ := newTestAgent(t, nil)
+	testServer := httptest.NewServer(New(runAgent, Options{}))
+	defer testServer.Close()
+
+	cases := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "invalid json", body: `{"prompt": `, status: http.StatusBadRequest},
+		{name: "no prompt", body: `{}`, status: http.StatusBadRequest},
+		{name: "failed completion", body: `{"prompt": "What were the total sales?"}`, status: http.StatusInternalServerError},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			reply := postRun(t, testServer.URL, c.body, nil, nil)
+			if reply.StatusCode != c.status {
+				t.Fatalf("expected %d, got %d", c.status, reply.StatusCode)
+			}
+		})
+	}
+}
+
+// Tool names offered on a completion request
+func offeredTools(request openai.ChatCompletionNewParams) []string {
+	names := []string{}
+	for _, param := range request.Tools.Value {
+		names = append(names, param.Function.Value.Name.Value)
+	}
+	return names
+}
+
+func TestRunToolAllowlist(t *testing.T) {
+	runAgent, completer := newTestAgent(t, nil, mock.TextResponse("Nothing to look up."))
+	testServer := httptest.NewServer(New(runAgent, Options{}))
+	defer testServer.Close()
+
+	reply := postRun(t, testServer.URL, `{"prompt": "Hi", "tools": ["lookup"]}`, nil, nil)
+	if reply.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", reply.StatusCode)
+	}
+	if offered := offeredTools(completer.Requests[0]); len(offered) != 1 || offered[0] != tools.LookUpFuncName {
+		t.Errorf("expected only the lookup tool offered, got %v", offered)
+	}
+
+	for _, body := range []string{`{"prompt": "Hi", "tools": ["delete"]}`, `{"prompt": "Hi", "tools": ["search"]}`} {
+		if reply := postRun(t, testServer.URL, body, nil, nil); reply.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected 400 for %s, got %d", body, reply.StatusCode)
+		}
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	cases := []struct {
+		name      string
+		responses []*openai.ChatCompletion
+		llmOK     bool
+	}{
+		{name: "healthy", responses: []*openai.ChatCompletion{mock.TextResponse("pong")}, llmOK: true},
+		// The ping fails on a completer without responses, the server stays up and reports it
+		{name: "llm down"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			runAgent, _ := newTestAgent(t, nil, c.responses...)
+			testServer := httptest.NewServer(New(runAgent, Options{}))
+			defer testServer.Close()
+
+			if status, _ := get(t, testServer.URL+"/readyz"); status != http.StatusServiceUnavailable {
+				t.Fatalf("expected 503 before the warm up, got %d", status)
+			}
+
+			runAgent.Warmup(t.Context(), true)
+			status, body := get(t, testServer.URL+"/readyz")
+			if status != http.StatusOK {
+				t.Fatalf("expected 200 after the warm up, got %d", status)
+			}
+
+			readiness := agent.Readiness{}
+			if err := json.Unmarshal([]byte(body), &readiness); err != nil {
+				t.Fatal(err)
+			}
+			if !readiness.Ready || !readiness.Components[agent.ComponentDatabase].OK || !readiness.Components[agent.ComponentTracer].OK {
+				t.Errorf("expected the database and tracer ready, got %s", body)
+			}
+			if llm := readiness.Components[agent.ComponentLLM]; llm.OK != c.llmOK || (!c.llmOK && llm.Error == "") {
+				t.Errorf("expected llm ok=%t with its error, got %s", c.llmOK, body)
+			}
+		})
+	}
+}
+
+func TestRunFormats(t *testing.T) {
+	markdownAnswer := "Total sales were **66.75**."
+	cases := []struct {
+		format string
+		answer string
+	}{
+		{format: "", answer: markdownAnswer},
+		{format: "markdown", answer: markdownAnswer},
+		{format: "plain", answer: "Total sales were 66.75."},
+		{format: "table", answer: markdownAnswer},
+		{format: "JSON", answer: markdownAnswer},
+	}
+
+	for _, c := range cases {
+		t.Run("format "+c.format, func(t *testing.T) {
+			runAgent, _ := newTestAgent(t, nil, lookupResponses()...)
+			testServer := httptest.NewServer(New(runAgent, Options{}))
+			defer testServer.Close()
+
+			// The result holds messages of interface types, decoded generically
+			response := map[string]any{}
+			body := fmt.Sprintf(`{"prompt": "What were the total sales?", "format": %q}`, c.format)
+			if reply := postRun(t, testServer.URL, body, &response, nil); reply.StatusCode != http.StatusOK {
+				t.Fatalf("expected 200, got %d", reply.StatusCode)
+			}
+			if response["answer"] != c.answer {
+				t.Errorf("expected answer %q, got %q", c.answer, response["answer"])
+			}
+
+			result, hasResult := response["result"].(map[string]any)
+			if hasResult != strings.EqualFold(c.format, "json") {
+				t.Fatalf("expected the run result only on the json format, got %v", response["result"])
+			}
+			if hasResult && (result["RunID"] != response["runId"] || result["Iterations"] != float64(2)) {
+				t.Errorf("expected the whole run result, got %v", result)
+			}
+		})
+	}
+
+	runAgent, _ := newTestAgent(t, nil)
+	testServer := httptest.NewServer(New(runAgent, Options{}))
+	defer testServer.Close()
+	if reply := postRun(t, testServer.URL, `{"prompt": "Hi", "format": "html"}`, nil, nil); reply.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown format, got %d", reply.StatusCode)
+	}
+}
+
+// With an access config, the API key of a request picks the role its run is checked with
+func TestRunAPIKeyRoles(t *testing.T) {
+	denied := []*openai.ChatCompletion{
+		mock.ToolCallResponse("call_1", tools.LookUpFuncName, map[string]string{"prompt": "Total sales value"}),
+		mock.TextResponse("You don't have access to the sales data."),
+	}
+	cases := []struct {
+		name      string
+		apiKey    string
+		responses []*openai.ChatCompletion
+		status    int
+		answer    string
+		user      string // End user every completion is attributed to
+	}{
+		{name: "allowed role", apiKey: "example-analyst-key", responses: lookupResponses(), status: http.StatusOK, answer: "Total sales were **66.75**.", user: "analyst"},
+		{name: "denied role", apiKey: "example-intern-key", responses: denied, status: http.StatusOK, answer: "You don't have access to the sales data.", user: "intern"},
+		{name: "unknown key", apiKey: "stolen-key", status: http.StatusUnauthorized},
+		{name: "missing key", status: http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			runAgent, completer := newTestAgent(t, func(config *agent.Config) {
+				access, err := tools.LoadAccessPolicy(modulePath(t, "testdata", "access.json"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				config.Access = access
+			}, c.responses...)
+			testServer := httptest.NewServer(New(runAgent, Options{}))
+			defer testServer.Close()
+
+			header := http.Header{}
+			if c.apiKey != "" {
+				header.Set(tools.APIKeyHeader, c.apiKey)
+			}
+			response := RunResponse{}
+			reply := postRun(t, testServer.URL, `{"prompt": "What were the total sales?"}`, &response, header)
+			if reply.StatusCode != c.status {
+				t.Fatalf("expected %d, got %d", c.status, reply.StatusCode)
+			}
+			if response.Answer != c.answer {
+				t.Errorf("expected answer %q, got %q", c.answer, response.Answer)
+			}
+			if len(completer.Requests) != len(c.responses) {
+				t.Errorf("expected %d completions, got %d", len(c.responses), len(completer.Requests))
+			}
+			for i, request := range completer.Requests {
+				if request.User.Value != c.user {
+					t.Errorf("expected completion %d attributed to %q, got %q", i, c.user, request.User.Value)
+				}
+			}
+		})
+	}
+}
+
+// Runs near the request deadline note the steps they skipped, runs over the in flight cap are rejected
+func TestRunLoadShedding(t *testing.T) {
+	runAgent, _ := newTestAgent(t, func(config *agent.Config) { config.SuggestFollowUps = true }, mock.TextResponse("Sales were steady."))
+	shedding := agent.LoadShedding{RequestTimeout: agent.DefaultDegradeFloor / 2}
+	testServer := httptest.NewServer(New(runAgent, Options{LoadShedding: shedding}))
+	defer testServer.Close()
+
+	response := RunResponse{}
+	if reply := postRun(t, testServer.URL, `{"prompt": "How were sales?"}`, &response, nil); reply.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", reply.StatusCode)
+	}
+	if want := []string{agent.DegradedFollowUps, agent.DegradedCharts}; !slices.Equal(response.Degraded, want) {
+		t.Fatalf("expected degraded %v, got %v", want, response.Degraded)
+	}
+
+	gated := gatedCompleter{Completer: mock.NewCompleter(mock.TextResponse("Sales were steady.")), started: make(chan struct{}), release: make(chan struct{})}
+	slowAgent, _ := newTestAgent(t, func(config *agent.Config) { config.Completer = gated })
+	slowServer := httptest.NewServer(New(slowAgent, Options{LoadShedding: agent.LoadShedding{MaxInFlight: 1}}))
+	defer slowServer.Close()
+
+	admitted := make(chan int)
+	go func() {
+		admitted <- postRun(t, slowServer.URL, `{"prompt": "How were sales?"}`, nil, nil).StatusCode
+	}()
+	<-gated.started
+	reply := postRun(t, slowServer.URL, `{"prompt": "How were sales?"}`, nil, nil)
+	if reply.StatusCode != http.StatusTooManyRequests || reply.Header.Get("Retry-After") == "" {
+		t.Errorf("expected 429 with a Retry-After over the in flight cap, got %d", reply.StatusCode)
+	}
+	close(gated.release)
+	if status := <-admitted; status != http.StatusOK {
+		t.Errorf("expected the admitted run to answer, got %d", status)
+	}
+}
+
+// Mock completer holding its replies until released, announcing each request on started
+type gatedCompleter struct {
+	*mock.Completer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c gatedCompleter) New(ctx context.Context, body openai.ChatCompletionNewParams, opts ...option.RequestOption) (*openai.ChatCompletion, error) {
+	c.started <- struct{}{}
+	<-c.release
+	return c.Completer.New(ctx, body, opts...)
+}
+
+// Requests run on agents derived from the server one, a data file change reloaded by a request is seen by the next
+func TestRunPicksUpDataChanges(t *testing.T) {
+	data, err := os.ReadFile(modulePath(t, "testdata", "datasets", "sales.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataPath := filepath.Join(t.TempDir(), "sales.parquet")
+	if err := os.WriteFile(dataPath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tracer, recorder := tracing.NewRecordingTracer()
+	runAgent, completer := newTestAgent(t, func(config *agent.Config) {
+		config.DataPath = dataPath
+		config.ProfilePrompt = true
+		config.WatchData = true
+		config.Tracer = tracer
+	}, mock.TextResponse("Sales were steady."), mock.TextResponse("Sales were steady."))
+	testServer := httptest.NewServer(New(runAgent, Options{}))
+	defer testServer.Close()
+
+	if reply := postRun(t, testServer.URL, `{"prompt": "How were sales?"}`, nil, nil); reply.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", reply.StatusCode)
+	}
+
+	// Keep a single row of the 3 of the fixture
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rewritten := dataPath + ".rewritten"
+	if _, err := db.Exec(fmt.Sprintf("COPY (SELECT * FROM read_parquet('%s') LIMIT 1) TO '%s' (FORMAT parquet)", dataPath, rewritten)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(rewritten, dataPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply := postRun(t, testServer.URL, `{"prompt": "How were sales?"}`, nil, nil); reply.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", reply.StatusCode)
+	}
+
+	if reloads := runAgent.DataReloads(); reloads != 1 {
+		t.Errorf("expected the server agent to count 1 reload, got %d", reloads)
+	}
+	profiles := []bool{}
+	for _, request := range completer.Requests {
+		profiles = append(profiles, strings.Contains(systemTexts(request), "\n1 rows,"))
+	}
+	if !slices.Equal(profiles, []bool{false, true}) {
+		t.Errorf("expected only the second prompt to profile the rewritten dataset, got %v", profiles)
+	}
+	changed := []bool{}
+	for _, span := range recorder.Ended() {
+		if span.Name() == "AgentRun" {
+			changed = append(changed, spanAttr(span, "dataset.changed").AsBool())
+		}
+	}
+	if !slices.Equal(changed, []bool{false, true}) {
+		t.Errorf("expected dataset.changed on the second AgentRun span only, got %v", changed)
+	}
+}
+
+// Texts of the system messages of a request, joined by new lines
+func systemTexts(request openai.ChatCompletionNewParams) string {
+	texts := []string{}
+	for _, message := range request.Messages.Value {
+		if systemMessage, ok := message.(openai.ChatCompletionSystemMessageParam); ok {
+			for _, part := range systemMessage.Content.Value {
+				texts = append(texts, part.Text.Value)
+			}
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// Value of an attribute of a recorded span, empty when it's missing
+func spanAttr(span traceSdk.ReadOnlySpan, key string) attribute.Value {
+	for _, attr := range span.Attributes() {
+		if string(attr.Key) == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// The verbosity of a request overrides the one of the server on its run only
+func TestRunVerbosity(t *testing.T) {
+	cases := []struct {
+		name      string
+		verbosity string
+		status    int
+		want      string // Verbosity the run answered at
+	}{
+		{name: "server default", status: http.StatusOK, want: prompts.VerbosityBrief},
+		{name: "detailed", verbosity: "detailed", status: http.StatusOK, want: prompts.VerbosityDetailed},
+		{name: "normalized", verbosity: " Normal ", status: http.StatusOK, want: prompts.VerbosityNormal},
+		{name: "unknown", verbosity: "chatty", status: http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			runAgent, completer := newTestAgent(t, func(config *agent.Config) { config.Verbosity = prompts.VerbosityBrief }, mock.TextResponse("Sales were steady."))
+			testServer := httptest.NewServer(New(runAgent, Options{}))
+			defer testServer.Close()
+
+			response := map[string]any{}
+			body := fmt.Sprintf(`{"prompt": "How were sales?", "format": "json", "verbosity": %q}`, c.verbosity)
+			if reply := postRun(t, testServer.URL, body, &response, nil); reply.StatusCode != c.status {
+				t.Fatalf("expected %d, got %d", c.status, reply.StatusCode)
+			}
+			if c.status != http.StatusOK {
+				if len(completer.Requests) != 0 {
+					t.Errorf("expected no completion for a refused request, got %d", len(completer.Requests))
+				}
+				return
+			}
+
+			if result := response["result"].(map[string]any); result["Verbosity"] != c.want {
+				t.Errorf("expected the run answered at %s, got %v", c.want, result["Verbosity"])
+			}
+			system := systemTexts(completer.Requests[0])
+			for _, level := range []string{prompts.VerbosityBrief, prompts.VerbosityDetailed} {
+				if instruction := prompts.RenderVerbosityInstruction(level); strings.Contains(system, instruction) != (level == c.want) {
+					t.Errorf("expected the %s instruction on the prompt only when answering at it", level)
+				}
+			}
+		})
+	}
+}
+
+// Tags of a request are merged over the server ones and set on the run span
+func TestRunTags(t *testing.T) {
+	cases := []struct {
+		name   string
+		tags   string
+		status int
+		want   map[string]string // metadata attributes of the run span
+	}{
+		{name: "server tags", tags: `{}`, status: http.StatusOK, want: map[string]string{"metadata.experiment": "router-v2", "metadata.prompt": "v3"}},
+		{
+			name: "merged tags", tags: `{"prompt": "v4", "customer": "acme"}`, status: http.StatusOK,
+			want: map[string]string{"metadata.experiment": "router-v2", "metadata.prompt": "v4", "metadata.customer": "acme"},
+		},
+		{name: "reserved key", tags: `{"model": "gpt-5"}`, status: http.StatusBadRequest},
+		{name: "invalid key", tags: `{"bad key": "x"}`, status: http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tracer, recorder := tracing.NewRecordingTracer()
+			runAgent, _ := newTestAgent(t, func(config *agent.Config) {
+				config.Tags = map[string]string{"experiment": "router-v2", "prompt": "v3"}
+				config.Tracer = tracer
+			}, mock.TextResponse("Sales were steady."))
+			testServer := httptest.NewServer(New(runAgent, Options{}))
+			defer testServer.Close()
+
+			body := fmt.Sprintf(`{"prompt": "How were sales?", "tags": %s}`, c.tags)
+			if reply := postRun(t, testServer.URL, body, nil, nil); reply.StatusCode != c.status {
+				t.Fatalf("expected %d, got %d", c.status, reply.StatusCode)
+			}
+			if c.status != http.StatusOK {
+				return
+			}
+
+			for _, span := range recorder.Ended() {
+				if span.Name() != "AgentRun" {
+					continue
+				}
+				got := map[string]string{}
+				for _, attr := range span.Attributes() {
+					if strings.HasPrefix(string(attr.Key), "metadata.") {
+						got[string(attr.Key)] = attr.Value.AsString()
+					}
+				}
+				if !maps.Equal(got, c.want) {
+					t.Errorf("expected the span tags %v, got %v", c.want, got)
+				}
+				return
+			}
+			t.Fatal("expected an AgentRun span")
+		})
+	}
+}
+
+// Explained runs reply with the provenance of their answer, and append it to the answer like -explain
+func TestRunExplain(t *testing.T) {
+	cases := []struct {
+		name          string
+		explain       bool
+		serverExplain bool
+	}{
+		{name: "not explained"},
+		{name: "explained request", explain: true},
+		{name: "explaining server", serverExplain: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			runAgent, _ := newTestAgent(t, func(config *agent.Config) { config.ExplainAnswer = c.serverExplain }, lookupResponses()...)
+			testServer := httptest.NewServer(New(runAgent, Options{}))
+			defer testServer.Close()
+
+			response := RunResponse{}
+			body := fmt.Sprintf(`{"prompt": "What were the total sales?", "explain": %t}`, c.explain)
+			if reply := postRun(t, testServer.URL, body, &response, nil); reply.StatusCode != http.StatusOK {
+				t.Fatalf("expected 200, got %d", reply.StatusCode)
+			}
+
+			explained := c.explain || c.serverExplain
+			if (response.Provenance != nil) != explained {
+				t.Fatalf("expected a provenance only on explained runs, got %v", response.Provenance)
+			}
+			if strings.Contains(response.Answer, "How this answer was produced") != explained {
+				t.Errorf("expected the provenance section on the answer only on explained runs, got: %s", response.Answer)
+			}
+			if !explained {
+				return
+			}
+			if !slices.Equal(response.Provenance.Steps, []string{tools.LookUpFuncName}) {
+				t.Errorf("expected the lookup step, got %v", response.Provenance.Steps)
+			}
+			if len(response.Provenance.Queries) != 1 || !strings.Contains(response.Provenance.Queries[0].SQL, "sum(Total_Sale_Value)") {
+				t.Errorf("expected the lookup query, got %v", response.Provenance.Queries)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+// Package terminal watches the keys pressed on an interactive terminal, so the chat can interrupt an answer
+// while it streams without waiting for a line of input.
+package terminal
+
+import "errors"
+
+/*
+------------
+Key watching
+------------
+*/
+
+// Escape key, interrupting a streamed answer
+const KeyEscape = 0x1b
+
+// Keys can't be watched on this platform
+var ErrUnsupported = errors.New("watching keys isn't supported on this platform")
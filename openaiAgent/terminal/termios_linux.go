@@ -0,0 +1,9 @@
+package terminal
+
+import "syscall"
+
+// Requests getting and setting the terminal settings
+const (
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+)
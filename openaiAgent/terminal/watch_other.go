@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package terminal
+
+import "os"
+
+// Keys can't be watched here, so WatchKeys fails with ErrUnsupported
+func WatchKeys(file *os.File, keys []byte, onKey func()) (func(), error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,81 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"syscall"
+	"unsafe"
+)
+
+// Watch the keys pressed on the terminal of file, calling onKey each time one of keys is read. The terminal is
+// put in non canonical mode without echo until the returned stop is called, which waits for the watcher to
+// exit and restores the terminal, so no input typed after stop is read. Other keys typed meanwhile are dropped.
+// Signals are left on, so Ctrl+C still raises SIGINT. Fails when file isn't a terminal
+func WatchKeys(file *os.File, keys []byte, onKey func()) (func(), error) {
+	fd := file.Fd()
+	original, err := getTermios(fd)
+	if err != nil {
+		return nil, fmt.Errorf("%s isn't a terminal: %w", file.Name(), err)
+	}
+
+	// Reads return after a tenth of a second without input, so the watcher sees stop
+	watching := *original
+	watching.Lflag &^= syscall.ICANON | syscall.ECHO
+	watching.Cc[syscall.VMIN] = 0
+	watching.Cc[syscall.VTIME] = 1
+	if err := setTermios(fd, &watching); err != nil {
+		return nil, fmt.Errorf("failed to set up %s: %w", file.Name(), err)
+	}
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		buffer := make([]byte, 16)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			read, err := syscall.Read(int(fd), buffer)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if slices.ContainsFunc(buffer[:read], func(key byte) bool { return slices.Contains(keys, key) }) {
+				onKey()
+			}
+		}
+	}()
+
+	stop := func() {
+		close(done)
+		<-exited
+		setTermios(fd, original)
+	}
+	return stop, nil
+}
+
+// Settings of a terminal
+func getTermios(fd uintptr) (*syscall.Termios, error) {
+	termios := &syscall.Termios{}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlGetTermios, uintptr(unsafe.Pointer(termios))); errno != 0 {
+		return nil, errno
+	}
+	return termios, nil
+}
+
+// Apply settings to a terminal
+func setTermios(fd uintptr, termios *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlSetTermios, uintptr(unsafe.Pointer(termios))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+import html
+import pathlib
+
+import pandas as pd
+import matplotlib.pyplot as plt
+from cycler import cycler
+
+plt.rcParams.update({
+    "axes.prop_cycle": cycler(color=["#0072B2", "#E69F00", "#009E73", "#D55E00", "#CC79A7", "#56B4E9", "#F0E442", "#000000"]),
+    "font.family": "DejaVu Sans",
+    "font.size": 10,
+    "figure.figsize": (10, 6),
+    "figure.dpi": 100,
+    "savefig.dpi": 100,
+    "figure.facecolor": "#ffffff",
+    "axes.facecolor": "#ffffff",
+    "savefig.facecolor": "#ffffff",
+})
+
+data = pd.read_csv(pathlib.Path(__file__).with_name("bar_units_by_store.render.csv"), skipinitialspace=True)
+
+fig, ax = plt.subplots()
+ax.bar(data["Store_Number"].astype(str), data["units"])
+ax.set_title("Units sold by store")
+ax.set_xlabel("Store_Number")
+ax.set_ylabel("units")
+plt.tight_layout()
+image = pathlib.Path(__file__).with_suffix(".png")
+plt.savefig(image)
+
+page = [
+    "<!DOCTYPE html>",
+    f'<html><head><meta charset="utf-8"><title>{html.escape("Units sold by store")}</title></head><body>',
+    f'<figure><img src="{image.name}" alt="{html.escape("Units sold by store: bar chart of units by Store_Number")}"></figure>',
+]
+page.append("</body></html>")
+image.with_suffix(".html").write_text("\n".join(page) + "\n")
@@ -0,0 +1,41 @@
+import html
+import pathlib
+
+import pandas as pd
+import matplotlib.pyplot as plt
+from cycler import cycler
+
+plt.rcParams.update({
+    "axes.prop_cycle": cycler(color=["#0072B2", "#E69F00", "#009E73", "#D55E00", "#CC79A7", "#56B4E9", "#F0E442", "#000000"]),
+    "font.family": "DejaVu Sans",
+    "font.size": 10,
+    "figure.figsize": (10, 6),
+    "figure.dpi": 100,
+    "savefig.dpi": 100,
+    "figure.facecolor": "#ffffff",
+    "axes.facecolor": "#ffffff",
+    "savefig.facecolor": "#ffffff",
+})
+
+data = pd.read_csv(pathlib.Path(__file__).with_name("boxplot_sale_values_by_store.render.csv"), skipinitialspace=True)
+
+fig, ax = plt.subplots()
+ax.bxp([
+    {"label": "1320", "whislo": 29.5, "q1": 29.5, "med": 33, "q3": 40.75, "whishi": 40.75, "fliers": [12.5, 412]},
+    {"label": "330", "whislo": 88.25, "q1": 93.3125, "med": 98.75, "q3": 106.875, "whishi": 120, "fliers": []},
+], showfliers=True)
+ax.set_title("Spread of sale values by store")
+ax.set_xlabel("Store_Number")
+ax.set_ylabel("Total_Sale_Value")
+plt.tight_layout()
+image = pathlib.Path(__file__).with_suffix(".png")
+plt.savefig(image)
+
+page = [
+    "<!DOCTYPE html>",
+    f'<html><head><meta charset="utf-8"><title>{html.escape("Spread of sale values by store")}</title></head><body>',
+    f'<figure><img src="{image.name}" alt="{html.escape("Spread of sale values by store: box plot of Total_Sale_Value by Store_Number")}"></figure>',
+]
+page.append("<table><tr><th>Store_Number</th><th>count</th><th>low whisker</th><th>Q1</th><th>median</th><th>Q3</th><th>high whisker</th><th>outliers</th></tr><tr><td>1320</td><td>5</td><td>29.5</td><td>29.5</td><td>33</td><td>40.75</td><td>40.75</td><td>12.5 412</td></tr><tr><td>330</td><td>4</td><td>88.25</td><td>93.3125</td><td>98.75</td><td>106.875</td><td>120</td><td></td></tr></table>")
+page.append("</body></html>")
+image.with_suffix(".html").write_text("\n".join(page) + "\n")
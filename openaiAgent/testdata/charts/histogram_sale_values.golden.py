@@ -0,0 +1,38 @@
+import html
+import pathlib
+
+import pandas as pd
+import matplotlib.pyplot as plt
+from cycler import cycler
+
+plt.rcParams.update({
+    "axes.prop_cycle": cycler(color=["#0072B2", "#E69F00", "#009E73", "#D55E00", "#CC79A7", "#56B4E9", "#F0E442", "#000000"]),
+    "font.family": "DejaVu Sans",
+    "font.size": 10,
+    "figure.figsize": (10, 6),
+    "figure.dpi": 100,
+    "savefig.dpi": 100,
+    "figure.facecolor": "#ffffff",
+    "axes.facecolor": "#ffffff",
+    "savefig.facecolor": "#ffffff",
+})
+
+data = pd.read_csv(pathlib.Path(__file__).with_name("histogram_sale_values.render.csv"), skipinitialspace=True)
+
+fig, ax = plt.subplots()
+ax.bar([12.5, 318, 623.5, 929], [2, 1, 0, 1], width=[305.5, 305.5, 305.5, 305.5], align="edge", edgecolor="white")
+ax.set_title("Distribution of sale values")
+ax.set_xlabel("Total_Sale_Value")
+ax.set_ylabel("count")
+plt.tight_layout()
+image = pathlib.Path(__file__).with_suffix(".png")
+plt.savefig(image)
+
+page = [
+    "<!DOCTYPE html>",
+    f'<html><head><meta charset="utf-8"><title>{html.escape("Distribution of sale values")}</title></head><body>',
+    f'<figure><img src="{image.name}" alt="{html.escape("Distribution of sale values: histogram of Total_Sale_Value")}"></figure>',
+]
+page.append("<table><tr><th>from</th><th>to</th><th>count</th></tr><tr><td>12.5</td><td>318</td><td>2</td></tr><tr><td>318</td><td>623.5</td><td>1</td></tr><tr><td>623.5</td><td>929</td><td>0</td></tr><tr><td>929</td><td>1234.5</td><td>1</td></tr></table>")
+page.append("</body></html>")
+image.with_suffix(".html").write_text("\n".join(page) + "\n")
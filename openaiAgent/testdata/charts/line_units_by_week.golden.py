@@ -0,0 +1,38 @@
+import html
+import pathlib
+
+import pandas as pd
+import matplotlib.pyplot as plt
+from cycler import cycler
+
+plt.rcParams.update({
+    "axes.prop_cycle": cycler(color=["#000000", "#0072B2", "#D55E00", "#009E73"]),
+    "font.family": "DejaVu Sans",
+    "font.size": 14,
+    "figure.figsize": (12, 7),
+    "figure.dpi": 150,
+    "savefig.dpi": 150,
+    "figure.facecolor": "#ffffff",
+    "axes.facecolor": "#ffffff",
+    "savefig.facecolor": "#ffffff",
+})
+
+data = pd.read_csv(pathlib.Path(__file__).with_name("line_units_by_week.render.csv"), skipinitialspace=True)
+
+fig, ax = plt.subplots()
+ax.plot(data["Sold_Date"], data["units"])
+ax.set_title("Units sold per week")
+ax.set_xlabel("Sold_Date")
+ax.set_ylabel("units")
+plt.tight_layout()
+image = pathlib.Path(__file__).with_suffix(".png")
+plt.savefig(image)
+
+page = [
+    "<!DOCTYPE html>",
+    f'<html><head><meta charset="utf-8"><title>{html.escape("Units sold per week")}</title></head><body>',
+    f'<figure><img src="{image.name}" alt="{html.escape("Units sold per week: line chart of units by Sold_Date")}"></figure>',
+]
+page.append(data.to_html(index=False))
+page.append("</body></html>")
+image.with_suffix(".html").write_text("\n".join(page) + "\n")
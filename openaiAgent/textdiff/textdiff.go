@@ -0,0 +1,128 @@
+// Package textdiff compares two texts word by word, like an answer and the one regenerated in its place,
+// and formats the changes for the terminal.
+package textdiff
+
+import (
+	"strings"
+)
+
+/*
+----------
+Word diffs
+----------
+*/
+
+// Kinds of diff operations
+const (
+	Equal  = "equal"
+	Insert = "insert"
+	Delete = "delete"
+)
+
+// Max words compared pairwise. Longer texts are diffed as a whole replacement
+const maxComparisons = 4_000_000
+
+// Run of consecutive words kept, added or removed
+type Op struct {
+	Kind  string
+	Words []string
+}
+
+// Word level diff turning old into new. Words are split on unicode whitespace, which isn't kept.
+// Consecutive words of the same kind are merged into one operation
+func Words(old string, new string) []Op {
+	oldWords, newWords := strings.Fields(old), strings.Fields(new)
+	if len(oldWords)*len(newWords) > maxComparisons {
+		return merge([]Op{{Kind: Delete, Words: oldWords}, {Kind: Insert, Words: newWords}})
+	}
+
+	// Longest common subsequence lengths of every pair of suffixes
+	lengths := make([][]int, len(oldWords)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(newWords)+1)
+	}
+	for i := len(oldWords) - 1; i >= 0; i-- {
+		for j := len(newWords) - 1; j >= 0; j-- {
+			if oldWords[i] == newWords[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	ops := []Op{}
+	i, j := 0, 0
+	for i < len(oldWords) || j < len(newWords) {
+		switch {
+		case i < len(oldWords) && j < len(newWords) && oldWords[i] == newWords[j]:
+			ops = append(ops, Op{Kind: Equal, Words: []string{oldWords[i]}})
+			i, j = i+1, j+1
+		case j < len(newWords) && (i == len(oldWords) || lengths[i][j+1] >= lengths[i+1][j]):
+			ops = append(ops, Op{Kind: Insert, Words: []string{newWords[j]}})
+			j++
+		default:
+			ops = append(ops, Op{Kind: Delete, Words: []string{oldWords[i]}})
+			i++
+		}
+	}
+
+	return merge(ops)
+}
+
+// Merge consecutive operations of the same kind, dropping empty ones
+func merge(ops []Op) []Op {
+	merged := []Op{}
+	for _, op := range ops {
+		if len(op.Words) == 0 {
+			continue
+		}
+		if last := len(merged) - 1; last >= 0 && merged[last].Kind == op.Kind {
+			merged[last].Words = append(merged[last].Words, op.Words...)
+			continue
+		}
+		merged = append(merged, Op{Kind: op.Kind, Words: append([]string{}, op.Words...)})
+	}
+
+	return merged
+}
+
+// Whether a diff changes anything
+func Changed(ops []Op) bool {
+	for _, op := range ops {
+		if op.Kind != Equal {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ANSI colors of the changes
+const (
+	colorDelete = "\x1b[31;9m" // Red, struck through
+	colorInsert = "\x1b[32m"   // Green
+	colorReset  = "\x1b[0m"
+)
+
+// Diff as text, words separated by single spaces. With color, removals are red and struck through and
+// additions green. Without it they're marked [-like this-] and {+like this+}
+func Format(ops []Op, color bool) string {
+	parts := []string{}
+	for _, op := range ops {
+		text := strings.Join(op.Words, " ")
+		switch {
+		case op.Kind == Delete && color:
+			text = colorDelete + text + colorReset
+		case op.Kind == Insert && color:
+			text = colorInsert + text + colorReset
+		case op.Kind == Delete:
+			text = "[-" + text + "-]"
+		case op.Kind == Insert:
+			text = "{+" + text + "+}"
+		}
+		parts = append(parts, text)
+	}
+
+	return strings.Join(parts, " ")
+}
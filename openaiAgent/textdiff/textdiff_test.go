@@ -0,0 +1,120 @@
+package textdiff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Word diffs of unicode text: accented, combined, CJK and emoji words, split on any unicode whitespace
+func TestWords(t *testing.T) {
+	cases := []struct {
+		name string
+		old  string
+		new  string
+		want []Op
+	}{
+		{name: "both empty", want: []Op{}},
+		{
+			name: "only whitespace changed",
+			old:  "Störe 1320 sold  48 210 units",
+			new:  "Störe\t1320 sold\n48 210 units",
+			want: []Op{{Kind: Equal, Words: []string{"Störe", "1320", "sold", "48", "210", "units"}}},
+		},
+		{name: "from empty", new: "Tokyo 東京", want: []Op{{Kind: Insert, Words: []string{"Tokyo", "東京"}}}},
+		{name: "to empty", old: "Tokyo 東京", want: []Op{{Kind: Delete, Words: []string{"Tokyo", "東京"}}}},
+		{
+			name: "word added in the middle",
+			old:  "Store 1320 sold most",
+			new:  "Store 1320 sold the most",
+			want: []Op{
+				{Kind: Equal, Words: []string{"Store", "1320", "sold"}},
+				{Kind: Insert, Words: []string{"the"}},
+				{Kind: Equal, Words: []string{"most"}},
+			},
+		},
+		{
+			name: "accented words replaced",
+			old:  "Le café était fermé",
+			new:  "Le café est ouvert",
+			want: []Op{
+				{Kind: Equal, Words: []string{"Le", "café"}},
+				{Kind: Insert, Words: []string{"est", "ouvert"}},
+				{Kind: Delete, Words: []string{"était", "fermé"}},
+			},
+		},
+		{
+			name: "ideographic space",
+			old:  "東京　大阪　名古屋",
+			new:  "東京 名古屋",
+			want: []Op{
+				{Kind: Equal, Words: []string{"東京"}},
+				{Kind: Delete, Words: []string{"大阪"}},
+				{Kind: Equal, Words: []string{"名古屋"}},
+			},
+		},
+		{
+			name: "emoji",
+			old:  "Sales 📈 up",
+			new:  "Sales 📉 down",
+			want: []Op{
+				{Kind: Equal, Words: []string{"Sales"}},
+				{Kind: Insert, Words: []string{"📉", "down"}},
+				{Kind: Delete, Words: []string{"📈", "up"}},
+			},
+		},
+		{
+			// Words are compared byte by byte, without unicode normalization
+			name: "combining mark",
+			old:  "cafe\u0301 open",
+			new:  "café open",
+			want: []Op{
+				{Kind: Insert, Words: []string{"café"}},
+				{Kind: Delete, Words: []string{"cafe\u0301"}},
+				{Kind: Equal, Words: []string{"open"}},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ops := Words(c.old, c.new)
+			if !reflect.DeepEqual(ops, c.want) {
+				t.Fatalf("diff %v, expected %v", ops, c.want)
+			}
+			if changed := c.old != c.new && c.name != "only whitespace changed"; Changed(ops) != changed {
+				t.Errorf("diff changed %t, expected %t", Changed(ops), changed)
+			}
+		})
+	}
+}
+
+// Texts with too many words to compare pairwise are diffed as a whole replacement, even when they're the same
+func TestWordsLongTexts(t *testing.T) {
+	text := strings.Repeat("sales ", 2001)
+	ops := Words(text, text)
+	if len(ops) != 2 || ops[0].Kind != Delete || ops[1].Kind != Insert || len(ops[0].Words) != 2001 || len(ops[1].Words) != 2001 {
+		t.Fatalf("expected the 2001 words deleted and inserted back, got %d operations", len(ops))
+	}
+}
+
+// Changes are colored, or marked when color is disabled, and kept words are left as they are
+func TestFormat(t *testing.T) {
+	ops := Words("Le café était fermé hier", "Le café est ouvert hier")
+	cases := []struct {
+		name  string
+		color bool
+		want  string
+	}{
+		{name: "plain", want: "Le café {+est ouvert+} [-était fermé-] hier"},
+		{name: "color", color: true, want: "Le café \x1b[32mest ouvert\x1b[0m \x1b[31;9métait fermé\x1b[0m hier"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if formatted := Format(ops, c.color); formatted != c.want {
+				t.Errorf("formatted %q, expected %q", formatted, c.want)
+			}
+		})
+	}
+}
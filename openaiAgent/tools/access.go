@@ -0,0 +1,149 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+--------------------
+Dataset access rules
+--------------------
+*/
+
+// Header carrying the API key of server requests
+const APIKeyHeader = "X-API-Key"
+
+// Roles of the API keys and the roles allowed on each dataset, loaded from an access config file.
+// Datasets listed here override the roles of their dataset schema
+type AccessPolicy struct {
+	APIKeys  map[string]string   `json:"apiKeys"`  // Role of each API key
+	Datasets map[string][]string `json:"datasets"` // Roles allowed to query each dataset. An empty list allows every role
+}
+
+// Read an access config file, like {"apiKeys": {"key": "analyst"}, "datasets": {"sales": ["analyst"]}}
+func LoadAccessPolicy(path string) (*AccessPolicy, error) {
+	jsonBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access config: %w", err)
+	}
+
+	policy := &AccessPolicy{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(policy); err != nil {
+		return nil, fmt.Errorf("failed to decode access config %s: %w", path, err)
+	}
+	for key, role := range policy.APIKeys {
+		if strings.TrimSpace(key) == "" || strings.TrimSpace(role) == "" {
+			return nil, fmt.Errorf("access config %s maps an empty API key or role", path)
+		}
+	}
+
+	return policy, nil
+}
+
+// Role of an API key. Unknown keys fail, they must not fall back to the full access of runs without a role
+func (p *AccessPolicy) RoleForAPIKey(apiKey string) (string, error) {
+	if p == nil {
+		return "", fmt.Errorf("no access config to resolve API keys with")
+	}
+
+	role, ok := p.APIKeys[strings.TrimSpace(apiKey)]
+	if !ok {
+		return "", fmt.Errorf("unknown API key")
+	}
+
+	return role, nil
+}
+
+// Role of the API key a server request carries on its APIKeyHeader
+func (p *AccessPolicy) RoleForRequest(request *http.Request) (string, error) {
+	apiKey := request.Header.Get(APIKeyHeader)
+	if apiKey == "" {
+		return "", fmt.Errorf("missing %s header", APIKeyHeader)
+	}
+
+	return p.RoleForAPIKey(apiKey)
+}
+
+// Roles allowed on a dataset, from the policy or else its dataset schema. False for unknown datasets
+func (p *AccessPolicy) datasetRoles(dataset string) ([]string, bool) {
+	if p != nil {
+		if roles, ok := p.Datasets[dataset]; ok {
+			return roles, true
+		}
+	}
+	schema, ok := datasetSchemas[dataset]
+	return schema.Roles, ok
+}
+
+// Whether a role may query a dataset. An empty role has full access, like CLI runs.
+// Returns why it may not otherwise
+func (p *AccessPolicy) Allows(role string, dataset string) (bool, string) {
+	if role == "" {
+		return true, ""
+	}
+
+	roles, known := p.datasetRoles(dataset)
+	switch {
+	case !known:
+		return false, "unknown dataset"
+	case len(roles) != 0 && !slices.Contains(roles, role):
+		return false, fmt.Sprintf("role '%s' may not query it", role)
+	}
+
+	return true, ""
+}
+
+// Check the role of the run may query a dataset before touching it. Denials are audit logged
+// and set on the span of ctx, and return an ErrAccessDenied for the model
+func (t *Toolbox) authorizeDataset(ctx context.Context, tool string, dataset string) error {
+	allowed, reason := t.config.Access.Allows(t.config.Role, dataset)
+	if allowed {
+		return nil
+	}
+
+	err := &ErrAccessDenied{Tool: tool, Dataset: dataset, Reason: reason}
+	tracing.SetSpanAttrFromMap(trace.SpanFromContext(ctx), map[string]any{
+		"access.denied":  true,
+		"access.dataset": dataset,
+		"access.role":    t.config.Role,
+	})
+	t.audit.write(AuditEntry{
+		RunID:     t.RunID,
+		Tool:      tool,
+		Source:    AuditSourceAccess,
+		Error:     err.Error(),
+		User:      completion.EndUserFrom(ctx),
+		Principal: t.config.Principal,
+		Role:      t.config.Role,
+		Dataset:   dataset,
+		Tags:      t.config.Tags,
+	})
+
+	return err
+}
+
+// Tables of a live database a query references, matched on whole words
+func referencedTables(query string, tables []string) []string {
+	referenced := []string{}
+	for _, table := range tables {
+		if regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(table) + `\b`).MatchString(query) {
+			referenced = append(referenced, table)
+		}
+	}
+
+	return referenced
+}
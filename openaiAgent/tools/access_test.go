@@ -0,0 +1,151 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+)
+
+func loadExamplePolicy(t *testing.T) *AccessPolicy {
+	t.Helper()
+	policy, err := LoadAccessPolicy("../testdata/access.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+func TestAccessPolicyAllows(t *testing.T) {
+	policy := loadExamplePolicy(t)
+	cases := []struct {
+		name    string
+		policy  *AccessPolicy
+		role    string
+		dataset string
+		allowed bool
+	}{
+		{name: "allowed role", policy: policy, role: "analyst", dataset: tableName, allowed: true},
+		{name: "denied role", policy: policy, role: "intern", dataset: tableName},
+		{name: "no role", policy: policy, dataset: tableName, allowed: true},
+		{name: "unknown dataset", policy: policy, role: "admin", dataset: "payroll"},
+		{name: "unknown dataset without role", policy: policy, dataset: "payroll", allowed: true},
+		{name: "schema roles", role: "intern", dataset: tableName, allowed: true},
+		{name: "schema unknown dataset", role: "intern", dataset: "payroll"},
+		{name: "dataset open to every role", policy: &AccessPolicy{Datasets: map[string][]string{"payroll": {}}}, role: "intern", dataset: "payroll", allowed: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			allowed, reason := c.policy.Allows(c.role, c.dataset)
+			if allowed != c.allowed || (allowed == (reason != "")) {
+				t.Fatalf("expected allowed %t, got %t with reason '%s'", c.allowed, allowed, reason)
+			}
+		})
+	}
+}
+
+func TestRoleForRequest(t *testing.T) {
+	policy := loadExamplePolicy(t)
+	cases := []struct {
+		name   string
+		policy *AccessPolicy
+		apiKey string
+		role   string // Empty when the request must fail
+	}{
+		{name: "known key", policy: policy, apiKey: "example-analyst-key", role: "analyst"},
+		{name: "padded key", policy: policy, apiKey: " example-intern-key ", role: "intern"},
+		{name: "unknown key", policy: policy, apiKey: "example-guest-key"},
+		{name: "missing key", policy: policy},
+		{name: "no access config", apiKey: "example-analyst-key"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			request, _ := http.NewRequest(http.MethodPost, "/v1/runs", nil)
+			if c.apiKey != "" {
+				request.Header.Set(APIKeyHeader, c.apiKey)
+			}
+			role, err := c.policy.RoleForRequest(request)
+			if role != c.role || (err == nil) != (c.role != "") {
+				t.Fatalf("expected role '%s', got '%s': %v", c.role, role, err)
+			}
+		})
+	}
+}
+
+func TestLoadAccessPolicyRejectsEmptyRoles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.json")
+	if err := os.WriteFile(path, []byte(`{"apiKeys": {"key": " "}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAccessPolicy(path); err == nil {
+		t.Fatal("expected an API key without role to be rejected")
+	}
+}
+
+// Lookups check the role of the run against every dataset the generated query reaches, table functions
+// reading files can't get around it by naming no dataset
+func TestLookupAccess(t *testing.T) {
+	policy := &AccessPolicy{Datasets: map[string][]string{tableName: {"analyst"}, "payroll": {"admin"}}}
+	cases := []struct {
+		name    string
+		role    string
+		query   string // Query generated by the model, empty when the lookup must be denied before generating one
+		denied  string // Dataset the lookup is denied on
+		invalid bool   // Whether the query is rejected as invalid
+	}{
+		{name: "allowed", role: "analyst", query: "SELECT sum(Total_Sale_Value) FROM sales"},
+		{name: "denied dataset", role: "intern", denied: tableName},
+		{name: "denied live table", role: "analyst", query: "SELECT * FROM sales JOIN payroll USING (Store_Number)", denied: "payroll"},
+		{name: "table function bypass", role: "analyst", query: "SELECT * FROM read_parquet('data/hr.parquet')", invalid: true},
+		{name: "text file bypass", role: "analyst", query: "SELECT * FROM read_text('/etc/passwd')", invalid: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			store, err := openFixtureStore(t, StorageDuckDB, "sales.parquet")
+			if err != nil {
+				t.Fatal(err)
+			}
+			completer := mock.NewCompleter()
+			if c.query != "" {
+				completer = mock.NewCompleter(mock.TextResponse(c.query))
+			}
+			tracer, _ := tracing.NewRecordingTracer()
+			auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+			config := Config{Role: c.role, Access: policy, LiveTables: []string{"payroll"}, OutputDir: t.TempDir()}
+			toolbox := NewToolbox(config, completer, store, tracer, NewAuditLog(auditPath, true))
+
+			_, err = toolbox.Lookup(context.Background(), LookupRequest{Prompt: "Total sales value"})
+			if len(completer.Requests) != len(completer.Responses) {
+				t.Fatalf("expected %d completions, got %d", len(completer.Responses), len(completer.Requests))
+			}
+
+			var deniedErr *ErrAccessDenied
+			var invalidErr *ErrSQLGeneration
+			switch {
+			case c.denied != "":
+				if !errors.As(err, &deniedErr) || deniedErr.Dataset != c.denied {
+					t.Fatalf("expected access to %s denied, got %v", c.denied, err)
+				}
+				auditLog, _ := os.ReadFile(auditPath)
+				if !strings.Contains(string(auditLog), `"source":"access"`) {
+					t.Fatalf("expected the denial audited, got: %s", auditLog)
+				}
+			case c.invalid:
+				if !errors.As(err, &invalidErr) || !strings.Contains(invalidErr.Reason, "file function") {
+					t.Fatalf("expected the file function rejected, got %v", err)
+				}
+			case err != nil:
+				t.Fatalf("expected the lookup to run, got %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package tools
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+-----------------------
+Run artifacts directory
+-----------------------
+*/
+
+// Create the working directory for a new run at <OutputDir>/<run_id>.
+// The run ID is built from the current timestamp and a short trace ID
+func (t *Toolbox) StartRun(shortTraceID string) (string, error) {
+	t.RunID = fmt.Sprintf("%s-%s", time.Now().Format(runIDTimeLayout), shortTraceID)
+	t.RunDir = filepath.Join(t.config.OutputDir, t.RunID)
+	t.artifacts = []string{}
+	t.artifactURLs = map[string]string{}
+	t.touchedDatasets = map[string][]string{}
+	t.lookups = 0
+	t.retrieval = RetrievalStats{}
+	t.queries = []AuditEntry{}
+	t.results = map[string]string{}
+	t.resultQueries = map[string]string{}
+	t.latestResultRef = ""
+
+	log.Printf("Creating run directory at %s\n", t.RunDir)
+	if err := os.MkdirAll(t.RunDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create run directory: %w", err)
+	}
+
+	return t.RunID, nil
+}
+
+// Continue a run in its existing directory, like when resuming it from its transcript.
+// Every artifact of the run must still exist. Lookup CSVs are stored again, so their result refs resolve
+func (t *Toolbox) ResumeRun(runID string, runDir string, artifacts []string) error {
+	t.RunID = runID
+	t.RunDir = runDir
+	t.artifacts = []string{}
+	t.artifactURLs = map[string]string{}
+	t.touchedDatasets = map[string][]string{}
+	t.lookups = 0
+	t.retrieval = RetrievalStats{}
+	t.queries = []AuditEntry{}
+	t.results = map[string]string{}
+	t.resultQueries = map[string]string{}
+	t.latestResultRef = ""
+
+	for _, name := range artifacts {
+		content, err := os.ReadFile(filepath.Join(runDir, name))
+		if err != nil {
+			return fmt.Errorf("artifact '%s' of run '%s' is missing: %w", name, runID, err)
+		}
+
+		if strings.HasPrefix(name, "lookup-") && strings.HasSuffix(name, ".csv") {
+			t.latestResultRef = t.storeResult(string(content))
+		}
+		t.artifacts = append(t.artifacts, name)
+	}
+
+	log.Printf("Resuming run '%s' with %d artifacts\n", runID, len(t.artifacts))
+	return nil
+}
+
+// Write an artifact into the run directory.
+// Returns the artifact path relative to the run directory
+func (t *Toolbox) WriteArtifact(name string, content []byte) (string, error) {
+	if t.RunDir == "" {
+		return "", fmt.Errorf("no run started, can't write artifact '%s'", name)
+	}
+
+	if err := os.WriteFile(filepath.Join(t.RunDir, name), content, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write artifact '%s': %w", name, err)
+	}
+
+	log.Printf("Wrote artifact '%s' for run '%s'\n", name, t.RunID)
+	t.artifacts = append(t.artifacts, name)
+	return name, nil
+}
+
+// Name for the next artifact with the given prefix and extension, unique within the run
+func (t *Toolbox) nextArtifactName(prefix string, extension string) string {
+	return fmt.Sprintf("%s-%d.%s", prefix, len(t.artifacts)+1, extension)
+}
+
+// Artifacts produced so far on the run, relative to RunDir
+func (t *Toolbox) Artifacts() []string {
+	return slices.Clone(t.artifacts)
+}
+
+// Layout of the timestamp starting every run ID
+const runIDTimeLayout = "20060102T150405"
+
+// Start time of a run, read from its ID. False for names that aren't run IDs, like the reports directory
+func RunStartTime(runID string) (time.Time, bool) {
+	timestamp, _, found := strings.Cut(runID, "-")
+	if !found {
+		return time.Time{}, false
+	}
+
+	startedAt, err := time.ParseInLocation(runIDTimeLayout, timestamp, time.Local)
+	return startedAt, err == nil
+}
+
+// Remove the run directories under outputDir started before cutoff, leaving anything else there alone.
+// Returns the IDs of the removed runs
+func PruneRunsBefore(outputDir string, cutoff time.Time) ([]string, error) {
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list runs: %w", err)
+	}
+
+	pruned := []string{}
+	for _, entry := range entries {
+		startedAt, ok := RunStartTime(entry.Name())
+		if !entry.IsDir() || !ok || !startedAt.Before(cutoff) {
+			continue
+		}
+
+		log.Printf("Pruning run '%s' started %s\n", entry.Name(), startedAt.Format(time.DateTime))
+		if err := os.RemoveAll(filepath.Join(outputDir, entry.Name())); err != nil {
+			return pruned, fmt.Errorf("failed to prune run '%s': %w", entry.Name(), err)
+		}
+		pruned = append(pruned, entry.Name())
+	}
+
+	return pruned, nil
+}
+
+// Remove the oldest run directories under outputDir, keeping the newest `keep` ones.
+// A non positive `keep` keeps every run
+func PruneRuns(outputDir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to list runs: %w", err)
+	}
+
+	// Run IDs start with a timestamp, so lexical order is chronological order
+	runs := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			runs = append(runs, entry.Name())
+		}
+	}
+	slices.Sort(runs)
+
+	for len(runs) > keep {
+		log.Printf("Pruning old run '%s'\n", runs[0])
+		if err := os.RemoveAll(filepath.Join(outputDir, runs[0])); err != nil {
+			return fmt.Errorf("failed to prune run '%s': %w", runs[0], err)
+		}
+		runs = runs[1:]
+	}
+
+	return nil
+}
@@ -0,0 +1,209 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+)
+
+/*
+-------------
+SQL audit log
+-------------
+*/
+
+// Origin of an audited SQL statement
+const AuditSourceGenerated = "generated"
+const AuditSourceUser = "user"
+const AuditSourceGuardrail = "guardrail"
+const AuditSourceReport = "report"
+const AuditSourceAccess = "access"             // Denied dataset access, with no SQL
+const AuditSourceEstimate = "estimate"         // Row count of a query shown for confirmation
+const AuditSourceConfirmation = "confirmation" // Decision on a query shown for confirmation, with no execution
+const AuditSourcePlan = "plan"                 // EXPLAIN of a generated query, estimating its scans without running it
+
+// One line of the SQL audit log
+type AuditEntry struct {
+	TimeStamp  string `json:"timeStamp"`
+	RunID      string `json:"runId"`
+	Tool       string `json:"tool"`
+	Source     string `json:"source"`
+	SQL        string `json:"sql"`
+	RowCount   int    `json:"rowCount"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+	User       string `json:"user,omitempty"`      // End user of the run, redacted like on completions
+	Principal  string `json:"principal,omitempty"` // User running the agent
+	Role       string `json:"role,omitempty"`      // Role of the run, on access denials
+	Dataset    string `json:"dataset,omitempty"`   // Dataset the run was denied, on access denials
+
+	Decision     string `json:"decision,omitempty"`     // Confirmation decision, one of the SQL* decisions
+	GeneratedSQL string `json:"generatedSql,omitempty"` // Query the model generated, when the user edited it
+
+	Tags map[string]string `json:"tags,omitempty"` // Metadata tags of the run
+}
+
+// Append only SQL audit log, safe for concurrent runs.
+// The log rotates to Path.1 ... Path.<MaxBackups> once it grows over MaxBytes
+type AuditLog struct {
+	Path       string
+	Enabled    bool
+	MaxBytes   int64
+	MaxBackups int
+
+	// Serializes writes so concurrent runs don't interleave lines
+	mutex sync.Mutex
+}
+
+// Create an audit log at path with the default rotation settings
+func NewAuditLog(path string, enabled bool) *AuditLog {
+	return &AuditLog{
+		Path:       path,
+		Enabled:    enabled,
+		MaxBytes:   10 * 1024 * 1024,
+		MaxBackups: 3,
+	}
+}
+
+// Rotate the audit log if it is over the size limit. Expects the mutex to be held
+func (a *AuditLog) rotate() error {
+	info, err := os.Stat(a.Path)
+	if err != nil || info.Size() < a.MaxBytes {
+		return nil
+	}
+
+	log.Printf("Rotating audit log at %s\n", a.Path)
+	for i := a.MaxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", a.Path, i), fmt.Sprintf("%s.%d", a.Path, i+1))
+	}
+
+	if a.MaxBackups <= 0 {
+		return os.Remove(a.Path)
+	}
+
+	return os.Rename(a.Path, a.Path+".1")
+}
+
+// Append an entry to the audit log. Failures are logged but never stop the tool
+func (a *AuditLog) write(entry AuditEntry) {
+	if a == nil || !a.Enabled {
+		return
+	}
+
+	if entry.TimeStamp == "" {
+		entry.TimeStamp = time.Now().Format(time.RFC3339)
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("WARNING: Failed to marshal audit entry: %s\n", err)
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if err := a.rotate(); err != nil {
+		log.Printf("WARNING: Failed to rotate audit log: %s\n", err)
+	}
+
+	auditFile, err := os.OpenFile(a.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Printf("WARNING: Failed to open audit log: %s\n", err)
+		return
+	}
+	defer auditFile.Close()
+
+	if _, err := auditFile.Write(append(line, '\n')); err != nil {
+		log.Printf("WARNING: Failed to write audit log: %s\n", err)
+	}
+}
+
+// Execute a query and record it on the audit log.
+// Returns the result columns and rows
+func (t *Toolbox) runAuditedQuery(
+	ctx context.Context,
+	tool string,
+	source string,
+	query string,
+) ([]string, [][]any, error) {
+	db, err := t.queryer()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entry := AuditEntry{RunID: t.RunID, Tool: tool, Source: source, SQL: query, User: completion.EndUserFrom(ctx), Tags: t.config.Tags}
+	start := time.Now()
+
+	columns, resultRows, err := func() ([]string, [][]any, error) {
+		rows, err := db.QueryContext(ctx, query)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to select data from database: %w", err)
+		}
+		defer rows.Close()
+
+		columns, err := rows.Columns()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch query result columns: %w", err)
+		}
+
+		// Rows read before a failure are kept, so cancelled queries can report partial results
+		resultRows, err := extractFromRows(rows, len(columns))
+		if err != nil {
+			return columns, resultRows, fmt.Errorf("failed to extract data from columns: %w", err)
+		}
+
+		return columns, resultRows, nil
+	}()
+
+	TimingsFrom(ctx).Since(TimingDuckDB, start)
+	entry.TimeStamp = time.Now().Format(time.RFC3339)
+	entry.DurationMs = time.Since(start).Milliseconds()
+	entry.RowCount = len(resultRows)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	t.audit.write(entry)
+	t.queries = append(t.queries, entry)
+	return columns, resultRows, err
+}
+
+// SQL statements executed on the run so far, in order, whether or not the audit log is enabled
+func (t *Toolbox) Queries() []AuditEntry {
+	return slices.Clone(t.queries)
+}
+
+// Read the last `amount` entries of the audit log at auditPath, oldest first
+func AuditTail(auditPath string, amount int) ([]AuditEntry, error) {
+	auditFile, err := os.Open(auditPath)
+	if err != nil {
+		return nil, err
+	}
+	defer auditFile.Close()
+
+	entries := []AuditEntry{}
+	scanner := bufio.NewScanner(auditFile)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		entry := AuditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Printf("WARNING: Skipping malformed audit line: %s\n", err)
+			continue
+		}
+
+		entries = append(entries, entry)
+		if len(entries) > amount {
+			entries = entries[1:]
+		}
+	}
+
+	return entries, scanner.Err()
+}
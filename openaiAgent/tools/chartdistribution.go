@@ -0,0 +1,303 @@
+package tools
+
+import (
+	"cmp"
+	"fmt"
+	"html"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+-------------------
+Chart distributions
+-------------------
+*/
+
+// Bins of a histogram unless the config names an amount, fewer when there are fewer values
+const defaultBinCount = 20
+
+// Most bins a histogram gets, larger amounts of the config are capped
+const maxBinCount = 200
+
+// Histogram bin holding the values from Low up to High. The last bin holds High too
+type HistogramBin struct {
+	Low   float64
+	High  float64
+	Count int
+}
+
+// Box of a box plot. Whiskers reach the furthest values within 1.5 IQR of the quartiles,
+// values past them are outliers
+type BoxStats struct {
+	Group       string // BucketBy value of the box, empty without buckets
+	Count       int
+	WhiskerLow  float64
+	Q1          float64
+	Median      float64
+	Q3          float64
+	WhiskerHigh float64
+	Outliers    []float64
+}
+
+// What a histogram or box plot draws, computed from the chart rows so neither the model nor the chart
+// code bins the data. Values that aren't finite numbers are skipped
+type ChartDistribution struct {
+	Config  ChartConfig
+	Bins    []HistogramBin // Histograms only
+	Boxes   []BoxStats     // Box plots only, in the order their groups first appear
+	Skipped int
+}
+
+// Distribution a histogram or box plot config draws from rows of the columns.
+// Other chart types don't have one
+func NewChartDistribution(config ChartConfig, columns []string, rows [][]string) (ChartDistribution, bool) {
+	if config.ChartType != histogramChart && config.ChartType != boxplotChart {
+		return ChartDistribution{}, false
+	}
+
+	distribution := ChartDistribution{Config: config, Bins: []HistogramBin{}, Boxes: []BoxStats{}}
+	valueIndex := matchColumn(columns, config.XAxis)
+	groupIndex := -1
+	if config.ChartType == boxplotChart && config.BucketBy != "" {
+		groupIndex = matchColumn(columns, config.BucketBy)
+	}
+
+	groups := []string{}
+	groupValues := map[string][]float64{}
+	for _, row := range rows {
+		value, ok := distributionValue(row, valueIndex)
+		if !ok {
+			distribution.Skipped++
+			continue
+		}
+
+		group := ""
+		if groupIndex >= 0 && groupIndex < len(row) {
+			group = strings.TrimSpace(row[groupIndex])
+		}
+		if _, ok := groupValues[group]; !ok {
+			groups = append(groups, group)
+		}
+		groupValues[group] = append(groupValues[group], value)
+	}
+
+	if config.ChartType == histogramChart {
+		distribution.Bins = HistogramBins(groupValues[""], config.BinCount)
+		return distribution, true
+	}
+
+	for _, group := range groups {
+		box := NewBoxStats(groupValues[group])
+		box.Group = group
+		distribution.Boxes = append(distribution.Boxes, box)
+	}
+	return distribution, true
+}
+
+// Distribution of the series of a chart config and its tool data as text, empty for other chart types
+// or when the data can't be charted
+func chartDistributionText(config visualizationConfigData) string {
+	series, err := NewChartSeries(config.Config, config.Data)
+	if err != nil {
+		return ""
+	}
+	distribution, ok := NewChartDistribution(series.Config, series.Columns, series.Rows)
+	if !ok {
+		return ""
+	}
+	return distribution.String()
+}
+
+// Finite number of the row at index, NaN and infinite values don't have a place on the axis
+func distributionValue(row []string, index int) (float64, bool) {
+	if index < 0 || index >= len(row) {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(row[index]), 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, false
+	}
+	return value, true
+}
+
+// Split the range of the values in binCount bins of the same width, the default amount when 0.
+// There are never more bins than values, and all equal values get a single bin around them
+func HistogramBins(values []float64, binCount int) []HistogramBin {
+	if len(values) == 0 {
+		return []HistogramBin{}
+	}
+	if binCount <= 0 {
+		binCount = defaultBinCount
+	}
+	binCount = min(binCount, maxBinCount, len(values))
+
+	low, high := slices.Min(values), slices.Max(values)
+	if low == high {
+		return []HistogramBin{{Low: low - 0.5, High: high + 0.5, Count: len(values)}}
+	}
+
+	width := (high - low) / float64(binCount)
+	bins := make([]HistogramBin, binCount)
+	for i := range bins {
+		bins[i].Low = low + float64(i)*width
+		bins[i].High = low + float64(i+1)*width
+	}
+	bins[binCount-1].High = high
+
+	for _, value := range values {
+		bins[min(int((value-low)/width), binCount-1)].Count++
+	}
+	return bins
+}
+
+// Quartiles, whiskers and outliers of the values. Quartiles interpolate between the closest values,
+// like numpy does by default
+func NewBoxStats(values []float64) BoxStats {
+	sorted := slices.Sorted(slices.Values(values))
+	box := BoxStats{Count: len(sorted), Outliers: []float64{}}
+	if len(sorted) == 0 {
+		return box
+	}
+
+	box.Q1, box.Median, box.Q3 = quantile(sorted, 0.25), quantile(sorted, 0.5), quantile(sorted, 0.75)
+	fenceLow, fenceHigh := box.Q1-1.5*(box.Q3-box.Q1), box.Q3+1.5*(box.Q3-box.Q1)
+	box.WhiskerLow, box.WhiskerHigh = box.Q1, box.Q3
+	for _, value := range sorted {
+		switch {
+		case value < fenceLow || value > fenceHigh:
+			box.Outliers = append(box.Outliers, value)
+		case value < box.WhiskerLow:
+			box.WhiskerLow = value
+		case value > box.WhiskerHigh:
+			box.WhiskerHigh = value
+		}
+	}
+	return box
+}
+
+// Quantile q of sorted values, interpolated linearly between the values around it
+func quantile(sorted []float64, q float64) float64 {
+	position := q * float64(len(sorted)-1)
+	lower := int(position)
+	if lower+1 >= len(sorted) {
+		return sorted[lower]
+	}
+	return sorted[lower] + (position-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+// Label of a box, the value axis for the single box of unbucketed plots
+func (d ChartDistribution) boxLabel(box BoxStats) string {
+	if d.Config.BucketBy == "" {
+		return d.Config.XAxis
+	}
+	return box.Group
+}
+
+// Distribution as text, one line per bin or box, for the chart code prompt and the checks
+func (d ChartDistribution) String() string {
+	lines := []string{}
+	if d.Config.ChartType == histogramChart {
+		lines = append(lines, fmt.Sprintf("histogram of %s in %d bins, %d values skipped", d.Config.XAxis, len(d.Bins), d.Skipped))
+		for _, bin := range d.Bins {
+			lines = append(lines, fmt.Sprintf("%s to %s: %d", formatChartNumber(bin.Low), formatChartNumber(bin.High), bin.Count))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	title := "box plot of " + d.Config.XAxis
+	if d.Config.BucketBy != "" {
+		title += " by " + d.Config.BucketBy
+	}
+	lines = append(lines, fmt.Sprintf("%s, %d values skipped", title, d.Skipped))
+	for _, box := range d.Boxes {
+		line := fmt.Sprintf(
+			"%s: %d values, whiskers %s to %s, quartiles %s, %s, %s",
+			d.boxLabel(box), box.Count, formatChartNumber(box.WhiskerLow), formatChartNumber(box.WhiskerHigh),
+			formatChartNumber(box.Q1), formatChartNumber(box.Median), formatChartNumber(box.Q3),
+		)
+		if len(box.Outliers) != 0 {
+			line += ", outliers " + strings.Join(formatChartNumbers(box.Outliers), " ")
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Python drawing the distribution on ax, nothing is drawn without values
+func (d ChartDistribution) plotCode() string {
+	if d.Config.ChartType == histogramChart {
+		if len(d.Bins) == 0 {
+			return "# No values to plot"
+		}
+
+		lows, widths, counts := []float64{}, []float64{}, []string{}
+		for _, bin := range d.Bins {
+			lows = append(lows, bin.Low)
+			widths = append(widths, bin.High-bin.Low)
+			counts = append(counts, strconv.Itoa(bin.Count))
+		}
+		return fmt.Sprintf(
+			`ax.bar([%s], [%s], width=[%s], align="edge", edgecolor="white")`,
+			strings.Join(formatChartNumbers(lows), ", "), strings.Join(counts, ", "), strings.Join(formatChartNumbers(widths), ", "),
+		)
+	}
+
+	if len(d.Boxes) == 0 {
+		return "# No values to plot"
+	}
+	boxes := []string{}
+	for _, box := range d.Boxes {
+		boxes = append(boxes, fmt.Sprintf(
+			`    {"label": %s, "whislo": %s, "q1": %s, "med": %s, "q3": %s, "whishi": %s, "fliers": [%s]},`,
+			strconv.Quote(d.boxLabel(box)), formatChartNumber(box.WhiskerLow), formatChartNumber(box.Q1), formatChartNumber(box.Median),
+			formatChartNumber(box.Q3), formatChartNumber(box.WhiskerHigh), strings.Join(formatChartNumbers(box.Outliers), ", "),
+		))
+	}
+	return fmt.Sprintf("ax.bxp([\n%s\n], showfliers=True)", strings.Join(boxes, "\n"))
+}
+
+// HTML table of the bins or boxes, shown under the chart so the figures can be read without the image
+func (d ChartDistribution) htmlTable() string {
+	cell := func(tag string, values ...string) string {
+		row := ""
+		for _, value := range values {
+			row += fmt.Sprintf("<%s>%s</%s>", tag, html.EscapeString(value), tag)
+		}
+		return "<tr>" + row + "</tr>"
+	}
+
+	rows := []string{}
+	if d.Config.ChartType == histogramChart {
+		rows = append(rows, cell("th", "from", "to", "count"))
+		for _, bin := range d.Bins {
+			rows = append(rows, cell("td", formatChartNumber(bin.Low), formatChartNumber(bin.High), strconv.Itoa(bin.Count)))
+		}
+	} else {
+		rows = append(rows, cell("th", cmp.Or(d.Config.BucketBy, "values"), "count", "low whisker", "Q1", "median", "Q3", "high whisker", "outliers"))
+		for _, box := range d.Boxes {
+			rows = append(rows, cell(
+				"td", d.boxLabel(box), strconv.Itoa(box.Count), formatChartNumber(box.WhiskerLow), formatChartNumber(box.Q1),
+				formatChartNumber(box.Median), formatChartNumber(box.Q3), formatChartNumber(box.WhiskerHigh),
+				strings.Join(formatChartNumbers(box.Outliers), " "),
+			))
+		}
+	}
+
+	return "<table>" + strings.Join(rows, "") + "</table>"
+}
+
+// Shortest text of a number that reads back the same, also a valid Python literal
+func formatChartNumber(number float64) string {
+	return strconv.FormatFloat(number, 'g', -1, 64)
+}
+
+func formatChartNumbers(numbers []float64) []string {
+	formatted := []string{}
+	for _, number := range numbers {
+		formatted = append(formatted, formatChartNumber(number))
+	}
+	return formatted
+}
@@ -0,0 +1,104 @@
+package tools
+
+import "testing"
+
+// Numeric edge cases of the distributions: skipped values, all equal values and fewer values than bins.
+// The last cases infer the chart from the data and goal, like when the chart config call fails
+func TestNewChartDistribution(t *testing.T) {
+	histogram := ChartConfig{ChartType: histogramChart, XAxis: "units", YAxis: "count", BinCount: 5}
+	boxplot := ChartConfig{ChartType: boxplotChart, XAxis: "units"}
+	cases := []struct {
+		name   string
+		config ChartConfig
+		goal   string // Visualization goal the config is inferred from instead, when set
+		data   string
+		want   string // Distribution as text
+	}{
+		{
+			name:   "even bins",
+			config: histogram,
+			data:   "units\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10",
+			want:   "histogram of units in 5 bins, 0 values skipped\n1 to 2.8: 2\n2.8 to 4.6: 2\n4.6 to 6.4: 2\n6.4 to 8.2: 2\n8.2 to 10: 2",
+		},
+		{
+			name:   "all equal values",
+			config: histogram,
+			data:   "units\n7\n7\n7",
+			want:   "histogram of units in 1 bins, 0 values skipped\n6.5 to 7.5: 3",
+		},
+		{
+			name:   "fewer values than bins",
+			config: ChartConfig{ChartType: histogramChart, XAxis: "units", BinCount: 10},
+			data:   "units\n0\n3\n9",
+			want:   "histogram of units in 3 bins, 0 values skipped\n0 to 3: 1\n3 to 6: 1\n6 to 9: 1",
+		},
+		{
+			name:   "default bins",
+			config: ChartConfig{ChartType: histogramChart, XAxis: "units"},
+			data:   "units\n1\n2",
+			want:   "histogram of units in 2 bins, 0 values skipped\n1 to 1.5: 1\n1.5 to 2: 1",
+		},
+		{
+			name:   "skipped values",
+			config: histogram,
+			data:   "units, store\nNaN, Centro\n4, Centro\n+Inf, Sur\nn/a, Sur\n<nil>, Sur\n4, Norte\n, Oeste",
+			want:   "histogram of units in 1 bins, 5 values skipped\n3.5 to 4.5: 2",
+		},
+		{
+			name:   "no values",
+			config: histogram,
+			data:   "units\nNaN\nNaN",
+			want:   "histogram of units in 0 bins, 2 values skipped",
+		},
+		{
+			name:   "box with outlier",
+			config: boxplot,
+			data:   "units\n100\n3\n1\n4\n2",
+			want:   "box plot of units, 0 values skipped\nunits: 5 values, whiskers 1 to 4, quartiles 2, 3, 4, outliers 100",
+		},
+		{
+			name:   "interpolated quartiles",
+			config: boxplot,
+			data:   "units\n1\n2\n3\n4",
+			want:   "box plot of units, 0 values skipped\nunits: 4 values, whiskers 1 to 4, quartiles 1.75, 2.5, 3.25",
+		},
+		{
+			name:   "boxes by bucket",
+			config: ChartConfig{ChartType: boxplotChart, XAxis: "units", BucketBy: "store"},
+			data:   "units, store\n3, Sur\n1, Centro\n2, Centro\nNaN, Norte\n3, Centro\n3, Sur",
+			want: "box plot of units by store, 1 values skipped\n" +
+				"Sur: 2 values, whiskers 3 to 3, quartiles 3, 3, 3\n" +
+				"Centro: 3 values, whiskers 1 to 3, quartiles 1.5, 2, 2.5",
+		},
+		{
+			name: "inferred histogram",
+			goal: "Units per sale",
+			data: "units\n1\n2",
+			want: "histogram of units in 2 bins, 0 values skipped\n1 to 1.5: 1\n1.5 to 2: 1",
+		},
+		{
+			name: "inferred box plot",
+			goal: "Spread of the units sold by each store",
+			data: "store, units\nSur, 1\nCentro, 2",
+			want: "box plot of units by store, 0 values skipped\nSur: 1 values, whiskers 1 to 1, quartiles 1, 1, 1\nCentro: 1 values, whiskers 2 to 2, quartiles 2, 2, 2",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := c.config
+			if c.goal != "" {
+				config, _ = inferChartConfig(c.data, c.goal)
+			}
+
+			parsed := parseTabularData(c.data)
+			distribution, ok := NewChartDistribution(config, parsed.Columns, parsed.Rows)
+			if !ok {
+				t.Fatalf("%s charts have no distribution", config.ChartType)
+			}
+			if text := distribution.String(); text != c.want {
+				t.Fatalf("got distribution\n%s\nexpected\n%s", text, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package tools
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+----------------------
+Chart config inference
+----------------------
+*/
+
+// Chart types the code generation step supports
+const (
+	barChart       = "bar"
+	lineChart      = "line"
+	scatterChart   = "scatter"
+	histogramChart = "histogram"
+	boxplotChart   = "boxplot"
+)
+
+var supportedChartTypes = []string{barChart, lineChart, scatterChart, histogramChart, boxplotChart}
+
+// Sources of a chart config, recorded on the ExtractChart span
+const (
+	chartConfigFromLLM       = "llm"
+	chartConfigFromHeuristic = "heuristic"
+)
+
+// Goals asking how values spread rather than for totals, charted as histograms or box plots
+var distributionGoalRegex = regexp.MustCompile(`(?i)\b(distribution|distributed|spread|outliers?|quartiles?|box ?plots?|histograms?)\b`)
+
+// Check if the model picked a chart type the code generation step supports
+func isSupportedChartType(chartType string) bool {
+	return slices.Contains(supportedChartTypes, strings.ToLower(strings.TrimSpace(chartType)))
+}
+
+// Infer a chart config from the column types of the data, and from the goal when it asks for a distribution.
+// Returns the config and the rule that picked it
+func inferChartConfig(data string, visualizationGoal string) (ChartConfig, string) {
+	config := ChartConfig{ChartType: lineChart, XAxis: "date", YAxis: "value", Title: visualizationGoal}
+
+	parsed := parseTabularData(data)
+	if len(parsed.Rows) == 0 {
+		return config, "data is not a table, using a line chart"
+	}
+
+	// First column of each type, in data order
+	firstOfType := map[string]string{}
+	numericColumns := []string{}
+	for _, summary := range summarizeColumns(parsed, nil) {
+		if _, ok := firstOfType[summary.Type]; !ok {
+			firstOfType[summary.Type] = summary.Name
+		}
+		if summary.Type == numericColumn {
+			numericColumns = append(numericColumns, summary.Name)
+		}
+	}
+
+	date, hasDate := firstOfType[dateColumn]
+	text, hasText := firstOfType[textColumn]
+	distribution := distributionGoalRegex.MatchString(visualizationGoal)
+	switch {
+	case distribution && hasText && len(numericColumns) > 0:
+		config.ChartType, config.XAxis, config.YAxis, config.BucketBy = boxplotChart, numericColumns[0], "", text
+		return config, fmt.Sprintf("distribution of numeric column '%s' by categorical column '%s'", numericColumns[0], text)
+	case distribution && len(numericColumns) > 0:
+		config.ChartType, config.XAxis, config.YAxis = histogramChart, numericColumns[0], "count"
+		return config, fmt.Sprintf("distribution of numeric column '%s'", numericColumns[0])
+	case hasDate && len(numericColumns) > 0:
+		config.ChartType, config.XAxis, config.YAxis = lineChart, date, numericColumns[0]
+		return config, fmt.Sprintf("date column '%s' with numeric column '%s'", date, numericColumns[0])
+	case hasText && len(numericColumns) > 0:
+		config.ChartType, config.XAxis, config.YAxis = barChart, text, numericColumns[0]
+		return config, fmt.Sprintf("categorical column '%s' with numeric column '%s'", text, numericColumns[0])
+	case len(numericColumns) >= 2:
+		config.ChartType, config.XAxis, config.YAxis = scatterChart, numericColumns[0], numericColumns[1]
+		return config, fmt.Sprintf("numeric columns '%s' and '%s'", numericColumns[0], numericColumns[1])
+	case len(numericColumns) == 1:
+		config.ChartType, config.XAxis, config.YAxis = histogramChart, numericColumns[0], "count"
+		return config, fmt.Sprintf("single numeric column '%s'", numericColumns[0])
+	}
+
+	return config, "no numeric column, using a line chart"
+}
+
+// Chart config inferred from the data, for when the model config is missing or implausible.
+// The reason and the rule used are recorded on the ExtractChart span
+func fallbackChartConfig(span trace.Span, data string, visualizationGoal string, reason string) visualizationConfigData {
+	config, rule := inferChartConfig(data, visualizationGoal)
+	log.Printf("WARNING: Inferring %s chart config, %s: %s\n", config.ChartType, reason, rule)
+
+	tracing.SetSpanAttrFromMap(span, map[string]any{
+		"chart.config_source":   chartConfigFromHeuristic,
+		"chart.fallback_reason": reason,
+		"chart.heuristic_rule":  rule,
+		"chart.type":            config.ChartType,
+	})
+
+	return visualizationConfigData{Config: config, Data: data}
+}
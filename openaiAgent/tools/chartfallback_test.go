@@ -0,0 +1,147 @@
+package tools
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+)
+
+// Chart configs inferred from representative column shapes of lookup results
+func TestInferChartConfig(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		goal string
+		want ChartConfig // Title is the goal
+	}{
+		{
+			name: "categorical and numeric",
+			data: "Product_Class_Code, Total_Qty_Sold\nA, 10\nB, 4\nC, 7",
+			want: ChartConfig{ChartType: barChart, XAxis: "Product_Class_Code", YAxis: "Total_Qty_Sold"},
+		},
+		{
+			name: "two numerics",
+			data: "Qty_Sold, Total_Sale_Value\n1, 2.5\n3, 7.5\n2, 5",
+			want: ChartConfig{ChartType: scatterChart, XAxis: "Qty_Sold", YAxis: "Total_Sale_Value"},
+		},
+		{
+			name: "date and numeric",
+			data: "Sold_Date, Total_Sale_Value\n2021-11-01, 12.5\n2021-11-02, 8",
+			want: ChartConfig{ChartType: lineChart, XAxis: "Sold_Date", YAxis: "Total_Sale_Value"},
+		},
+		{
+			name: "date before categorical",
+			data: "Sale_Month, Product_Class_Code, Total_Qty_Sold\n2021-11-01, A, 10\n2021-12-01, B, 4",
+			want: ChartConfig{ChartType: lineChart, XAxis: "Sale_Month", YAxis: "Total_Qty_Sold"},
+		},
+		{
+			name: "single numeric",
+			data: "Total_Sale_Value\n12.5\n8\n3.25",
+			want: ChartConfig{ChartType: histogramChart, XAxis: "Total_Sale_Value", YAxis: "count"},
+		},
+		{
+			name: "distribution goal",
+			data: "Qty_Sold, Total_Sale_Value\n1, 2.5\n3, 7.5",
+			goal: "Distribution of sale values",
+			want: ChartConfig{ChartType: histogramChart, XAxis: "Qty_Sold", YAxis: "count"},
+		},
+		{
+			name: "distribution by category",
+			data: "Product_Class_Code, Total_Sale_Value\nA, 2.5\nB, 7.5\nA, 4",
+			goal: "Spread of sale values per class",
+			want: ChartConfig{ChartType: boxplotChart, XAxis: "Total_Sale_Value", BucketBy: "Product_Class_Code"},
+		},
+		{
+			name: "no numeric column",
+			data: "Product_Class_Code, Store_Name\nA, North\nB, South",
+			want: ChartConfig{ChartType: lineChart, XAxis: "date", YAxis: "value"},
+		},
+		{
+			name: "not a table",
+			data: "No sales matched the filters",
+			want: ChartConfig{ChartType: lineChart, XAxis: "date", YAxis: "value"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config, rule := inferChartConfig(c.data, c.goal)
+			c.want.Title = c.goal
+			if config != c.want {
+				t.Errorf("inferred %+v, expected %+v", config, c.want)
+			}
+			if rule == "" {
+				t.Error("no rule given for the inferred config")
+			}
+			if !isSupportedChartType(config.ChartType) {
+				t.Errorf("inferred unsupported chart type '%s'", config.ChartType)
+			}
+		})
+	}
+}
+
+// The ExtractChart span records whether the config came from the model or the heuristic, and why
+func TestChartConfigSource(t *testing.T) {
+	const data = "Product_Class_Code, Total_Qty_Sold\nA, 10\nB, 4"
+	cases := []struct {
+		name     string
+		response *openai.ChatCompletion // Nil fails the completion
+		source   string
+		reason   string // Start of the fallback reason, empty for model configs
+		want     ChartConfig
+	}{
+		{
+			name:     "model config",
+			response: mock.TextResponse(`{"chartType": "Line", "xAxis": "Product_Class_Code", "yAxis": "Total_Qty_Sold", "title": "Units", "binCount": 0, "bucketBy": ""}`),
+			source:   chartConfigFromLLM,
+			want:     ChartConfig{ChartType: lineChart, XAxis: "Product_Class_Code", YAxis: "Total_Qty_Sold", Title: "Units"},
+		},
+		{
+			name:   "failed call",
+			source: chartConfigFromHeuristic,
+			reason: "chart config call failed",
+			want:   ChartConfig{ChartType: barChart, XAxis: "Product_Class_Code", YAxis: "Total_Qty_Sold", Title: "Units per class"},
+		},
+		{
+			name:     "unsupported chart type",
+			response: mock.TextResponse(`{"chartType": "pie", "xAxis": "Product_Class_Code", "yAxis": "Total_Qty_Sold", "title": "Units", "binCount": 0, "bucketBy": ""}`),
+			source:   chartConfigFromHeuristic,
+			reason:   "unsupported chart type 'pie'",
+			want:     ChartConfig{ChartType: barChart, XAxis: "Product_Class_Code", YAxis: "Total_Qty_Sold", Title: "Units"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tracer, recorder := tracing.NewRecordingTracer()
+			toolbox := NewToolbox(Config{}, mock.NewCompleter(c.response), nil, tracer, nil)
+
+			config := toolbox.extractChartConfig(context.Background(), data, "Units per class")
+			if config.Config != c.want {
+				t.Errorf("config %+v, expected %+v", config.Config, c.want)
+			}
+
+			attrs := map[string]string{}
+			for _, span := range recorder.Ended() {
+				if span.Name() != "ExtractChart" {
+					continue
+				}
+				for _, attr := range span.Attributes() {
+					attrs[string(attr.Key)] = attr.Value.Emit()
+				}
+			}
+			if attrs["chart.config_source"] != c.source || attrs["chart.type"] != c.want.ChartType {
+				t.Errorf("span records a %s config from %s, expected a %s one from %s",
+					attrs["chart.type"], attrs["chart.config_source"], c.want.ChartType, c.source)
+			}
+			if !strings.HasPrefix(attrs["chart.fallback_reason"], c.reason) || (c.reason == "") != (attrs["chart.heuristic_rule"] == "") {
+				t.Errorf("span records fallback reason '%s' and rule '%s', expected a reason starting with '%s'",
+					attrs["chart.fallback_reason"], attrs["chart.heuristic_rule"], c.reason)
+			}
+		})
+	}
+}
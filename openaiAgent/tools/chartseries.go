@@ -0,0 +1,234 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+/*
+------------
+Chart series
+------------
+*/
+
+// Suffix of the series CSV written next to a chart code artifact
+const chartSeriesSuffix = ".series.csv"
+
+// Row of the tool data left out of a chart, with its line in the data
+type DroppedRow struct {
+	Line   int
+	Reason string
+}
+
+// Data points a chart plots: the axis columns of the rows that parse, with bar categories summed.
+// It's what the chart spec renders, so a chart that looks wrong can be checked against it
+type ChartSeries struct {
+	Config  ChartConfig // Chart config with the axes named as the matched columns
+	Columns []string    // Columns of the chart axes, see chartAxes
+	Rows    [][]string
+	Dropped []DroppedRow // Rows left out because they didn't parse, in data order
+}
+
+// Series a chart config plots from tool data. Axes match columns ignoring case, spaces and underscores.
+// Rows without a value for an axis, with a number axis that isn't a number, or with the wrong amount of
+// values are dropped with their reason. Fails when an axis matches no column
+func NewChartSeries(config ChartConfig, data string) (ChartSeries, error) {
+	series := ChartSeries{Config: config, Rows: [][]string{}, Dropped: []DroppedRow{}}
+
+	columns := []string{}
+	axisIndexes := []int{}
+	for lineNumber, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || tagLineRegex.MatchString(line) || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		values := strings.Split(line, ", ")
+		if len(columns) == 0 {
+			columns = values
+			var err error
+			if axisIndexes, err = series.matchAxes(columns); err != nil {
+				return series, err
+			}
+			continue
+		}
+
+		row, reason := series.parseRow(columns, axisIndexes, values)
+		if reason != "" {
+			series.Dropped = append(series.Dropped, DroppedRow{Line: lineNumber + 1, Reason: reason})
+			continue
+		}
+		series.Rows = append(series.Rows, row)
+	}
+
+	if len(columns) == 0 {
+		return series, fmt.Errorf("no table to chart in the data")
+	}
+
+	if series.Config.ChartType == barChart {
+		series.Rows = sumCategories(series.Rows)
+	}
+
+	return series, nil
+}
+
+// Columns a chart config plots: the X axis then the Y axis. Histograms and box plots only plot the numeric
+// X axis, box plots bucketed by a column have it second
+func chartAxes(config ChartConfig) []string {
+	axes := []string{}
+	for _, axis := range configAxes(&config) {
+		axes = append(axes, *axis)
+	}
+	return axes
+}
+
+func configAxes(config *ChartConfig) []*string {
+	switch config.ChartType {
+	case histogramChart:
+		return []*string{&config.XAxis}
+	case boxplotChart:
+		if config.BucketBy == "" {
+			return []*string{&config.XAxis}
+		}
+		return []*string{&config.XAxis, &config.BucketBy}
+	}
+	return []*string{&config.XAxis, &config.YAxis}
+}
+
+// Match the axes of the config to columns, renaming them as the columns. Returns the column indexes
+func (s *ChartSeries) matchAxes(columns []string) ([]int, error) {
+	axes := configAxes(&s.Config)
+
+	indexes := []int{}
+	for _, axis := range axes {
+		index := matchColumn(columns, *axis)
+		if index < 0 {
+			return nil, fmt.Errorf("axis '%s' isn't a column, columns are %s", *axis, strings.Join(columns, ", "))
+		}
+		*axis = columns[index]
+		s.Columns = append(s.Columns, columns[index])
+		indexes = append(indexes, index)
+	}
+
+	return indexes, nil
+}
+
+// Index of the column an axis names, exact matches first. -1 when none matches
+func matchColumn(columns []string, axis string) int {
+	for i, column := range columns {
+		if column == axis {
+			return i
+		}
+	}
+
+	fold := strings.NewReplacer(" ", "", "_", "")
+	for i, column := range columns {
+		if strings.EqualFold(fold.Replace(column), fold.Replace(axis)) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Axis values of a data row, or why the row is dropped
+func (s *ChartSeries) parseRow(columns []string, axisIndexes []int, values []string) ([]string, string) {
+	if len(values) != len(columns) {
+		return nil, fmt.Sprintf("expected %d values, got %d", len(columns), len(values))
+	}
+
+	row := []string{}
+	for i, index := range axisIndexes {
+		value := strings.TrimSpace(values[index])
+		if value == "" || value == "<nil>" {
+			return nil, fmt.Sprintf("no value for '%s'", columns[index])
+		}
+		if s.isNumberAxis(i) && !isChartNumber(value) {
+			return nil, fmt.Sprintf("'%s' of '%s' isn't a number", value, columns[index])
+		}
+		row = append(row, value)
+	}
+
+	return row, ""
+}
+
+// Whether the axis at index of chartAxes must be numeric to plot
+func (s *ChartSeries) isNumberAxis(index int) bool {
+	switch s.Config.ChartType {
+	case scatterChart:
+		return true
+	case histogramChart, boxplotChart:
+		return index == 0
+	}
+	return index == 1
+}
+
+// Finite number that pandas reads and that sums exactly
+func isChartNumber(value string) bool {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return false
+	}
+	_, ok := new(big.Rat).SetString(value)
+	return ok
+}
+
+// Sum the values of repeated bar categories exactly, keeping the order categories first appear in.
+// Categories shown once keep their value as written, sums get the most decimals of their values
+func sumCategories(rows [][]string) [][]string {
+	summed := [][]string{}
+	indexes := map[string]int{}
+	sums := map[string]*big.Rat{}
+	decimals := map[string]int{}
+	for _, row := range rows {
+		category, value := row[0], row[1]
+		sum, ok := sums[category]
+		if !ok {
+			sum = new(big.Rat)
+			sums[category] = sum
+			indexes[category] = len(summed)
+			summed = append(summed, row)
+		}
+
+		number, _ := new(big.Rat).SetString(value)
+		sum.Add(sum, number)
+		if _, fraction, found := strings.Cut(value, "."); found {
+			decimals[category] = max(decimals[category], len(fraction))
+		}
+		if ok {
+			summed[indexes[category]] = []string{category, sum.FloatString(decimals[category])}
+		}
+	}
+
+	return summed
+}
+
+// Series as CSV, after comment lines counting the dropped rows and giving their reasons
+func (s ChartSeries) CSV() string {
+	buffer := bytes.Buffer{}
+	if len(s.Dropped) != 0 {
+		fmt.Fprintf(&buffer, "# %d rows dropped\n", len(s.Dropped))
+		for _, dropped := range s.Dropped {
+			fmt.Fprintf(&buffer, "# line %d: %s\n", dropped.Line, dropped.Reason)
+		}
+	}
+
+	writer := csv.NewWriter(&buffer)
+	writer.Write(s.Columns)
+	writer.WriteAll(s.Rows)
+	return buffer.String()
+}
+
+// Series as tool data, the way the chart spec stores it
+func (s ChartSeries) data() string {
+	lines := []string{strings.Join(s.Columns, ", ")}
+	for _, row := range s.Rows {
+		lines = append(lines, strings.Join(row, ", "))
+	}
+	return strings.Join(lines, "\n")
+}
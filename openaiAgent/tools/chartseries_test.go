@@ -0,0 +1,90 @@
+package tools
+
+import "testing"
+
+// Messy tool data charted with a config: rows without a number or with the wrong amount of values are dropped
+// and listed on the series CSV, repeated categories are summed
+func TestNewChartSeries(t *testing.T) {
+	cases := []struct {
+		name   string
+		config ChartConfig
+		data   string
+		want   string // Series CSV
+	}{
+		{
+			name:   "bar with repeated categories",
+			config: ChartConfig{ChartType: "bar", XAxis: "Store Name", YAxis: "total_sales"},
+			data: `# Lookup result ref: result-1
+<data>
+store_name, region, total_sales
+Centro, north, 120.50
+Norte, north, <nil>
+Centro, north, 10.25
+Sur, south, n/a
+Sur, south, 80
+Oeste, west
+Norte, north, 1e2
+</data>
+Centro sold the most.`,
+			want: `# 4 rows dropped
+# line 5: no value for 'total_sales'
+# line 7: 'n/a' of 'total_sales' isn't a number
+# line 9: expected 3 values, got 2
+# line 12: expected 3 values, got 1
+store_name,total_sales
+Centro,130.75
+Sur,80
+Norte,1e2
+`,
+		},
+		{
+			name:   "line with gaps",
+			config: ChartConfig{ChartType: "line", XAxis: "month", YAxis: "Revenue"},
+			data: `month, revenue, note
+2024-01, 1000, ok
+2024-02, , missing
+, 900, no month
+2024-03, NaN, bad export
+2024-04, 1100.5, "quoted, with comma"
+2024-05, -20, refund`,
+			want: `# 4 rows dropped
+# line 3: no value for 'revenue'
+# line 4: no value for 'month'
+# line 5: 'NaN' of 'revenue' isn't a number
+# line 6: expected 3 values, got 4
+month,revenue
+2024-01,1000
+2024-05,-20
+`,
+		},
+		{
+			name:   "clean histogram",
+			config: ChartConfig{ChartType: "histogram", XAxis: "units", YAxis: "count"},
+			data:   "units, store\n3, Centro\n5, Sur",
+			want:   "units\n3\n5\n",
+		},
+		{
+			name:   "box plot by bucket",
+			config: ChartConfig{ChartType: "boxplot", XAxis: "Units", BucketBy: "Store"},
+			data:   "store, units, week\nCentro, 3, 1\nSur, NaN, 1\nSur, 5, 2",
+			want: `# 1 rows dropped
+# line 3: 'NaN' of 'units' isn't a number
+units,store
+3,Centro
+5,Sur
+`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			series, err := NewChartSeries(c.config, c.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if csv := series.CSV(); csv != c.want {
+				t.Errorf("got CSV\n%s\nexpected\n%s", csv, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+-----------
+Chart specs
+-----------
+*/
+
+// Version of the chart spec format, bumped when older specs can't be read anymore
+const ChartSpecVersion = 2
+
+// Version of the chart code rendered from specs, bumped on any change to chartCode.
+// A spec only renders with the code it was written for, so the image comes out the same
+const ChartRendererVersion = "matplotlib-3"
+
+// Suffixes of chart specs and of the files rendered from them
+const (
+	chartSpecSuffix = ".spec.json"
+	renderedSuffix  = ".render"
+)
+
+// Everything a chart is rendered from, written next to its code as <name>.spec.json
+type ChartSpec struct {
+	Version  int         `json:"version"`
+	Renderer string      `json:"renderer"` // ChartRendererVersion the spec was written for
+	Config   ChartConfig `json:"config"`
+	Theme    ChartTheme  `json:"theme"` // Palette, fonts, size and resolution of the figure
+	Columns  []string    `json:"columns"`
+	Rows     [][]string  `json:"rows"`    // Series data as parsed from the tool data, see ChartSeries
+	RunID    string      `json:"runId"`   // Run the chart was made on
+	TraceID  string      `json:"traceId"` // Trace of the run, to find it in Phoenix
+}
+
+// Files rendered from a chart spec. The code saves the chart as a PNG next to itself
+type ChartRender struct {
+	Spec     ChartSpec
+	Data     string // CSV data the code reads
+	Code     string
+	DataPath string
+	CodePath string
+}
+
+// Spec of a chart of the given config, tool data and theme, made on the current run of ctx
+func (t *Toolbox) chartSpec(ctx context.Context, config ChartConfig, data string, theme ChartTheme) ChartSpec {
+	parsed := parseTabularData(data)
+	return ChartSpec{
+		Version:  ChartSpecVersion,
+		Renderer: ChartRendererVersion,
+		Config:   config,
+		Theme:    theme,
+		Columns:  parsed.Columns,
+		Rows:     parsed.Rows,
+		RunID:    t.RunID,
+		TraceID:  trace.SpanContextFromContext(ctx).TraceID().String(),
+	}
+}
+
+// Write the spec of a chart next to its code artifact. Returns the spec path relative to the run directory
+func (t *Toolbox) writeChartSpec(ctx context.Context, codeArtifact string, config ChartConfig, data string, theme ChartTheme) (string, error) {
+	jsonBytes, err := json.MarshalIndent(t.chartSpec(ctx, config, data, theme), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal chart spec: %w", err)
+	}
+
+	return t.WriteArtifact(strings.TrimSuffix(codeArtifact, filepath.Ext(codeArtifact))+chartSpecSuffix, jsonBytes)
+}
+
+// Load a chart spec, checking it still renders with this build: unknown fields, other spec or renderer
+// versions, unsupported chart types, invalid themes, axes missing from the columns and rows of the wrong length all fail
+func LoadChartSpec(specPath string) (ChartSpec, error) {
+	jsonBytes, err := os.ReadFile(specPath)
+	if err != nil {
+		return ChartSpec{}, err
+	}
+
+	spec := ChartSpec{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&spec); err != nil {
+		return ChartSpec{}, fmt.Errorf("failed to decode chart spec %s, written by another spec version? %w", specPath, err)
+	}
+
+	if err := spec.validate(); err != nil {
+		return ChartSpec{}, fmt.Errorf("chart spec %s: %w", specPath, err)
+	}
+
+	return spec, nil
+}
+
+// Check the spec renders with this build, see LoadChartSpec
+func (s ChartSpec) validate() error {
+	switch {
+	case s.Version == 0:
+		return errors.New("missing version, is it a chart spec?")
+	case s.Version != ChartSpecVersion:
+		return fmt.Errorf("spec version %d, this build reads version %d", s.Version, ChartSpecVersion)
+	case s.Renderer != ChartRendererVersion:
+		return fmt.Errorf("written for renderer '%s', this build renders with '%s' and the chart would differ", s.Renderer, ChartRendererVersion)
+	case !slices.Contains(supportedChartTypes, s.Config.ChartType):
+		return fmt.Errorf("unsupported chart type '%s', expected one of %s", s.Config.ChartType, strings.Join(supportedChartTypes, ", "))
+	}
+	if err := s.Theme.validate(); err != nil {
+		return fmt.Errorf("theme '%s': %w", s.Theme.Name, err)
+	}
+
+	for _, axis := range chartAxes(s.Config) {
+		if !slices.Contains(s.Columns, axis) {
+			return fmt.Errorf("axis '%s' isn't a column, columns are %s", axis, strings.Join(s.Columns, ", "))
+		}
+	}
+
+	for i, row := range s.Rows {
+		if len(row) != len(s.Columns) {
+			return fmt.Errorf("row %d has %d values for %d columns", i+1, len(row), len(s.Columns))
+		}
+	}
+
+	return nil
+}
+
+// CSV data and chart code of a spec, the code reading the data from dataFile next to it.
+// No completion is made, so the same spec always gives the same files
+func renderChartSpec(spec ChartSpec, dataFile string) (string, string) {
+	lines := []string{strings.Join(spec.Columns, ", ")}
+	for _, row := range spec.Rows {
+		lines = append(lines, strings.Join(row, ", "))
+	}
+
+	return strings.Join(lines, "\n"), chartCode(spec.Config, spec.Columns, spec.Rows, dataFile, spec.Theme)
+}
+
+// Render the chart of a spec file again, writing <name>.render.csv and <name>.render.py next to it.
+// Running the code saves the chart as <name>.render.png
+func RenderFromSpec(specPath string) (ChartRender, error) {
+	spec, err := LoadChartSpec(specPath)
+	if err != nil {
+		return ChartRender{}, err
+	}
+
+	base := strings.TrimSuffix(specPath, chartSpecSuffix) + renderedSuffix
+	chartRender := ChartRender{Spec: spec, DataPath: base + ".csv", CodePath: base + ".py"}
+	chartRender.Data, chartRender.Code = renderChartSpec(spec, filepath.Base(chartRender.DataPath))
+
+	if err := os.WriteFile(chartRender.DataPath, []byte(chartRender.Data), 0o644); err != nil {
+		return chartRender, fmt.Errorf("failed to write chart data: %w", err)
+	}
+	if err := os.WriteFile(chartRender.CodePath, []byte(chartRender.Code), 0o644); err != nil {
+		return chartRender, fmt.Errorf("failed to write chart code: %w", err)
+	}
+
+	log.Printf("Rendered chart spec %s to %s\n", specPath, chartRender.CodePath)
+	return chartRender, nil
+}
@@ -0,0 +1,108 @@
+package tools
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Rewrite the golden chart code and schemas after an intended change, with go test ./tools -update
+var updateGolden = flag.Bool("update", false, "rewrite the golden chart code of the sample chart specs and the golden schemas")
+
+// Directory of the sample chart specs, each with its golden chart code
+const chartSpecsDir = "../testdata/charts"
+
+// Suffix of the golden chart code of a sample, which pins the figure size, resolution and palette
+const chartGoldenSuffix = ".golden.py"
+
+// Sample chart specs, in name order
+func chartSpecSamples(t *testing.T) []string {
+	t.Helper()
+	specPaths, err := filepath.Glob(filepath.Join(chartSpecsDir, "*"+chartSpecSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(specPaths) == 0 {
+		t.Fatalf("expected chart spec samples on %s", chartSpecsDir)
+	}
+	return specPaths
+}
+
+// Rendering a sample, reading the rendered data back into a spec and rendering that again gives the same
+// dimensions, series and files
+func TestChartSpecRoundTrip(t *testing.T) {
+	for _, specPath := range chartSpecSamples(t) {
+		t.Run(filepath.Base(specPath), func(t *testing.T) {
+			spec, err := LoadChartSpec(specPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dataFile := filepath.Base(strings.TrimSuffix(specPath, chartSpecSuffix) + renderedSuffix + ".csv")
+			data, code := renderChartSpec(spec, dataFile)
+
+			parsed := parseTabularData(data)
+			reread := spec
+			reread.Columns, reread.Rows = parsed.Columns, parsed.Rows
+			if !slices.Equal(reread.Columns, spec.Columns) {
+				t.Fatalf("rendered data has columns %s, the spec %s", strings.Join(reread.Columns, ", "), strings.Join(spec.Columns, ", "))
+			}
+			if !slices.EqualFunc(reread.Rows, spec.Rows, slices.Equal) {
+				t.Fatalf("rendered data has %d rows that differ from the %d of the spec", len(reread.Rows), len(spec.Rows))
+			}
+
+			rereadData, rereadCode := renderChartSpec(reread, dataFile)
+			if rereadData != data {
+				t.Error("rendering the rendered data again changes it")
+			}
+			if rereadCode != code {
+				t.Error("rendering the rendered data again changes the chart code")
+			}
+		})
+	}
+}
+
+// The chart code rendered from each sample matches its golden file
+func TestChartSpecGolden(t *testing.T) {
+	for _, specPath := range chartSpecSamples(t) {
+		t.Run(filepath.Base(specPath), func(t *testing.T) {
+			spec, err := LoadChartSpec(specPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			base := strings.TrimSuffix(specPath, chartSpecSuffix)
+			_, code := renderChartSpec(spec, filepath.Base(base+renderedSuffix+".csv"))
+			goldenPath := base + chartGoldenSuffix
+			if *updateGolden {
+				if err := os.WriteFile(goldenPath, []byte(code), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			golden, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatalf("failed to read golden chart code: %s", err)
+			}
+
+			goldenLines, codeLines := strings.Split(string(golden), "\n"), strings.Split(code, "\n")
+			for i := range max(len(goldenLines), len(codeLines)) {
+				goldenLine, codeLine := "", ""
+				if i < len(goldenLines) {
+					goldenLine = goldenLines[i]
+				}
+				if i < len(codeLines) {
+					codeLine = codeLines[i]
+				}
+				if goldenLine != codeLine {
+					t.Fatalf("rendered code differs from %s on line %d: '%s', golden '%s'. Run go test ./tools -update if the change is intended",
+						goldenPath, i+1, codeLine, goldenLine)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+------------
+Chart themes
+------------
+*/
+
+// Directory of the chart theme files, relative to the workspace
+const DefaultThemesDir = "themes"
+
+// Name of the built in theme, used when no other is selected
+const DefaultChartThemeName = "default"
+
+// Suffix of chart theme files, a theme is named after its file
+const chartThemeSuffix = ".json"
+
+// Limits of theme values, so a theme can't ask for unreadable or huge charts
+const (
+	maxPaletteColors = 32
+	minFontSize      = 6
+	maxFontSize      = 48
+	minChartInches   = 2
+	maxChartInches   = 40
+	minChartDPI      = 50
+	maxChartDPI      = 600
+)
+
+var (
+	themeNameRegex  = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	themeColorRegex = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+	fontFamilyRegex = regexp.MustCompile(`^[\w .-]+$`)
+)
+
+// Okabe-Ito palette, told apart with the common color vision deficiencies
+var colorblindPalette = []string{"#0072B2", "#E69F00", "#009E73", "#D55E00", "#CC79A7", "#56B4E9", "#F0E442", "#000000"}
+
+// Look of the rendered charts. Theme files set any of the fields, the rest keep the default theme values
+type ChartTheme struct {
+	Name       string   `json:"name"`       // File name without extension, set when loading
+	Palette    []string `json:"palette"`    // Series colors as #rrggbb, cycled in order
+	FontFamily string   `json:"fontFamily"` // Font family known to matplotlib, like "DejaVu Sans"
+	FontSize   float64  `json:"fontSize"`   // Base font size in points
+	Width      float64  `json:"width"`      // Figure width in inches
+	Height     float64  `json:"height"`     // Figure height in inches
+	DPI        int      `json:"dpi"`        // Dots per inch of the PNG, so it's Width*DPI pixels wide
+	Background string   `json:"background"` // Figure and axes background as #rrggbb
+	DataTable  bool     `json:"dataTable"`  // Append the charted data as a table under the chart of the HTML page
+}
+
+// Built in theme: colorblind safe palette on a white 10x6 inches figure
+func DefaultChartTheme() ChartTheme {
+	return ChartTheme{
+		Name:       DefaultChartThemeName,
+		Palette:    slices.Clone(colorblindPalette),
+		FontFamily: "DejaVu Sans",
+		FontSize:   10,
+		Width:      10,
+		Height:     6,
+		DPI:        100,
+		Background: "#ffffff",
+	}
+}
+
+// Load the theme <name>.json of themesDir over the default theme. An empty name or DefaultChartThemeName
+// gives the default theme. Unknown fields and values out of range fail
+func LoadChartTheme(themesDir string, name string) (ChartTheme, error) {
+	if name == "" || name == DefaultChartThemeName {
+		return DefaultChartTheme(), nil
+	}
+	if !themeNameRegex.MatchString(name) {
+		return ChartTheme{}, fmt.Errorf("invalid chart theme name '%s', expected lowercase letters, digits, '-' and '_'", name)
+	}
+
+	themePath := filepath.Join(themesDir, name+chartThemeSuffix)
+	jsonBytes, err := os.ReadFile(themePath)
+	if errors.Is(err, os.ErrNotExist) {
+		available, _ := ChartThemeNames(themesDir)
+		return ChartTheme{}, fmt.Errorf("no chart theme '%s' in %s, available themes are %s",
+			name, themesDir, strings.Join(append([]string{DefaultChartThemeName}, available...), ", "))
+	}
+	if err != nil {
+		return ChartTheme{}, err
+	}
+
+	theme := DefaultChartTheme()
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&theme); err != nil {
+		return ChartTheme{}, fmt.Errorf("failed to decode chart theme %s: %w", themePath, err)
+	}
+	theme.Name = name
+
+	if err := theme.validate(); err != nil {
+		return ChartTheme{}, fmt.Errorf("chart theme %s: %w", themePath, err)
+	}
+
+	return theme, nil
+}
+
+// Names of the themes of themesDir, in name order. A missing directory has none
+func ChartThemeNames(themesDir string) ([]string, error) {
+	themePaths, err := filepath.Glob(filepath.Join(themesDir, "*"+chartThemeSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, themePath := range themePaths {
+		names = append(names, strings.TrimSuffix(filepath.Base(themePath), chartThemeSuffix))
+	}
+	return names, nil
+}
+
+// Check the theme values are in range and safe to write into chart code
+func (t ChartTheme) validate() error {
+	switch {
+	case len(t.Palette) == 0 || len(t.Palette) > maxPaletteColors:
+		return fmt.Errorf("palette has %d colors, expected 1 to %d", len(t.Palette), maxPaletteColors)
+	case !fontFamilyRegex.MatchString(t.FontFamily):
+		return fmt.Errorf("invalid font family '%s'", t.FontFamily)
+	case t.FontSize < minFontSize || t.FontSize > maxFontSize:
+		return fmt.Errorf("font size %g out of the %d to %d points range", t.FontSize, minFontSize, maxFontSize)
+	case t.Width < minChartInches || t.Width > maxChartInches || t.Height < minChartInches || t.Height > maxChartInches:
+		return fmt.Errorf("size %gx%g out of the %d to %d inches range", t.Width, t.Height, minChartInches, maxChartInches)
+	case t.DPI < minChartDPI || t.DPI > maxChartDPI:
+		return fmt.Errorf("dpi %d out of the %d to %d range", t.DPI, minChartDPI, maxChartDPI)
+	case !themeColorRegex.MatchString(t.Background):
+		return fmt.Errorf("invalid background color '%s', expected #rrggbb", t.Background)
+	}
+
+	for _, color := range t.Palette {
+		if !themeColorRegex.MatchString(color) {
+			return fmt.Errorf("invalid palette color '%s', expected #rrggbb", color)
+		}
+	}
+
+	return nil
+}
+
+// Theme a chart is rendered with: the named one, or the configured theme for an empty name. A theme that
+// doesn't load falls back to the configured one, with a warning for the tool result
+func (t *Toolbox) ChartTheme(name string) (ChartTheme, string) {
+	configured := t.config.ChartTheme
+	if configured.Name == "" {
+		configured = DefaultChartTheme()
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || name == configured.Name {
+		return configured, ""
+	}
+
+	theme, err := LoadChartTheme(t.config.ThemesDir, name)
+	if err != nil {
+		return configured, fmt.Sprintf("theme '%s' not used, charted with the '%s' theme instead: %s", name, configured.Name, err)
+	}
+
+	return theme, ""
+}
+
+// Python code applying a theme to every matplotlib figure made after it, charts made by the model included
+func chartThemeCode(theme ChartTheme) string {
+	palette := []string{}
+	for _, color := range theme.Palette {
+		palette = append(palette, strconv.Quote(color))
+	}
+	background := strconv.Quote(theme.Background)
+
+	return fmt.Sprintf(`import matplotlib.pyplot as plt
+from cycler import cycler
+
+plt.rcParams.update({
+    "axes.prop_cycle": cycler(color=[%s]),
+    "font.family": %s,
+    "font.size": %s,
+    "figure.figsize": (%s, %s),
+    "figure.dpi": %d,
+    "savefig.dpi": %d,
+    "figure.facecolor": %s,
+    "axes.facecolor": %s,
+    "savefig.facecolor": %s,
+})
+`, strings.Join(palette, ", "), strconv.Quote(theme.FontFamily), formatInches(theme.FontSize),
+		formatInches(theme.Width), formatInches(theme.Height), theme.DPI, theme.DPI, background, background, background)
+}
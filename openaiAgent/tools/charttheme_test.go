@@ -0,0 +1,64 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Themes a visualization call names, loading or falling back to the configured one, which is the default theme
+func TestChartTheme(t *testing.T) {
+	cases := []struct {
+		name  string
+		theme string // Theme name of the call
+		file  string // Content of <theme>.json, none when empty
+		want  string // Name of the theme used
+		warns bool   // Whether the call gets a warning on its result
+	}{
+		{name: "configured theme", want: DefaultChartThemeName},
+		{name: "default by name", theme: DefaultChartThemeName, want: DefaultChartThemeName},
+		{name: "partial theme", theme: "large", file: `{"fontSize": 16, "dpi": 200}`, want: "large"},
+		{name: "missing theme", theme: "brand", want: DefaultChartThemeName, warns: true},
+		{name: "path as name", theme: "../brand", want: DefaultChartThemeName, warns: true},
+		{name: "invalid color", theme: "short", file: `{"palette": ["#12345"]}`, want: DefaultChartThemeName, warns: true},
+		{name: "unknown field", theme: "colour", file: `{"colours": ["#123456"]}`, want: DefaultChartThemeName, warns: true},
+		{name: "huge figure", theme: "poster", file: `{"width": 100, "dpi": 600}`, want: DefaultChartThemeName, warns: true},
+		{name: "empty palette", theme: "blank", file: `{"palette": []}`, want: DefaultChartThemeName, warns: true},
+		{name: "code in font", theme: "font", file: `{"fontFamily": "x\", \"y"}`, want: DefaultChartThemeName, warns: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			themesDir := t.TempDir()
+			if c.file != "" {
+				if err := os.WriteFile(filepath.Join(themesDir, c.theme+".json"), []byte(c.file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			toolbox := NewToolbox(Config{ThemesDir: themesDir}, nil, nil, nil, nil)
+			theme, warning := toolbox.ChartTheme(c.theme)
+			if theme.Name != c.want || (warning != "") != c.warns {
+				t.Errorf("got theme '%s' with warning '%s', expected '%s' with a warning %t", theme.Name, warning, c.want, c.warns)
+			}
+		})
+	}
+}
+
+// Every theme shipped on the themes directory loads
+func TestShippedChartThemesLoad(t *testing.T) {
+	themesDir := filepath.Join("..", DefaultThemesDir)
+	names, err := ChartThemeNames(themesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) == 0 {
+		t.Fatalf("expected themes on %s", themesDir)
+	}
+
+	for _, name := range names {
+		if _, err := LoadChartTheme(themesDir, name); err != nil {
+			t.Errorf("chart theme '%s' doesn't load: %s", name, err)
+		}
+	}
+}
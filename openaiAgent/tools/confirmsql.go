@@ -0,0 +1,132 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+)
+
+/*
+-----------------
+SQL confirmations
+-----------------
+*/
+
+// Decisions on a generated query shown for confirmation
+const (
+	SQLApproved = "approved"
+	SQLEdited   = "edited"
+	SQLRejected = "rejected"
+)
+
+// Generated query shown for confirmation before it runs
+type SQLReview struct {
+	Query         string
+	EstimatedRows int64  // Rows the query returns, -1 when unknown, like for temporary tables
+	Problem       string // Why the previous edit was refused, empty on the first review
+}
+
+// Decision on a reviewed query. Query holds the edited query for SQLEdited
+type SQLConfirmation struct {
+	Decision string
+	Query    string
+}
+
+// Asks a person whether a generated query may run, like the -confirm-sql terminal prompt.
+// The exchange stays out of the LLM conversation, only the outcome of the lookup reaches the router
+type SQLConfirmer interface {
+	ConfirmSQL(ctx context.Context, review SQLReview) (SQLConfirmation, error)
+}
+
+// The user rejected the generated query, it didn't run
+type ErrSQLRejected struct {
+	Query string
+}
+
+func (e *ErrSQLRejected) Error() string {
+	return "the user rejected the query, it didn't run. Ask them what to look up instead of running it again unchanged"
+}
+func (e *ErrSQLRejected) Class() string             { return ErrorClassSQLRejected }
+func (e *ErrSQLRejected) IsRetryable() bool         { return true }
+func (e *ErrSQLRejected) ExitClass() exitcode.Class { return exitcode.ClassData }
+
+// Show a checked query for confirmation until it's approved, rejected, or edited into a query passing check.
+// Returns the query to run, whether it's the checked one or an edit, and the decision made
+func (t *Toolbox) confirmSQL(ctx context.Context, query string, check func(string) (string, error)) (string, string, error) {
+	review := SQLReview{Query: query}
+	for {
+		review.EstimatedRows = t.estimateRows(ctx, review.Query, check)
+		confirmation, err := t.config.ConfirmSQL.ConfirmSQL(ctx, review)
+		if err != nil {
+			return "", "", err
+		}
+
+		edited := strings.TrimSpace(confirmation.Query)
+		switch {
+		case confirmation.Decision == SQLRejected:
+			t.recordSQLDecision(ctx, SQLRejected, query, review.Query)
+			return review.Query, SQLRejected, nil
+		case confirmation.Decision == SQLEdited && edited != "" && edited != review.Query:
+			// Edits are checked like generated queries, refused ones are shown again
+			review.Query, review.Problem = edited, ""
+			if _, err := check(edited); err != nil {
+				review.Problem = err.Error()
+			}
+		case review.Problem != "":
+			// An edit failing its check can't run, it must be edited again or rejected
+		default:
+			decision := SQLApproved
+			if review.Query != query {
+				decision = SQLEdited
+			}
+			t.recordSQLDecision(ctx, decision, query, review.Query)
+			return review.Query, decision, nil
+		}
+	}
+}
+
+// Rows a query returns, through a count wrapper. -1 when the query creates a table, fails its check or the count fails
+func (t *Toolbox) estimateRows(ctx context.Context, query string, check func(string) (string, error)) int64 {
+	createdTable, err := check(query)
+	if err != nil || createdTable != "" {
+		return -1
+	}
+
+	countQuery := fmt.Sprintf("SELECT count(*) FROM (%s) AS estimated_rows", strings.TrimRight(strings.TrimSpace(query), "; \n"))
+	_, rows, err := t.runAuditedQuery(ctx, LookUpFuncName, AuditSourceEstimate, countQuery)
+	if err != nil || len(rows) != 1 || len(rows[0]) != 1 {
+		log.Printf("WARNING: Failed to estimate the rows of the query: %v\n", err)
+		return -1
+	}
+
+	var count int64
+	if _, err := fmt.Sscan(fmt.Sprint(rows[0][0]), &count); err != nil {
+		return -1
+	}
+	return count
+}
+
+// Record a confirmation decision on the audit log, with the generated query when it was edited
+func (t *Toolbox) recordSQLDecision(ctx context.Context, decision string, generated string, query string) {
+	entry := AuditEntry{
+		TimeStamp: time.Now().Format(time.RFC3339),
+		RunID:     t.RunID,
+		Tool:      LookUpFuncName,
+		Source:    AuditSourceConfirmation,
+		SQL:       query,
+		Decision:  decision,
+		User:      completion.EndUserFrom(ctx),
+		Principal: t.config.Principal,
+		Tags:      t.config.Tags,
+	}
+	if decision == SQLEdited {
+		entry.GeneratedSQL = generated
+	}
+
+	t.audit.write(entry)
+}
@@ -0,0 +1,340 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+/*
+--------------------------------
+Dataset bootstrap and validation
+--------------------------------
+*/
+
+// Named view materialized over a dataset table at bootstrap
+type datasetView struct {
+	Name        string
+	Description string
+	SQL         string
+}
+
+// Expected columns for a dataset. Missing required columns fail validation,
+// missing optional columns only produce a warning.
+// Views are created on bootstrap so the model can select from them directly.
+// Monetary and identifier columns tell how answers write their numbers.
+// The glossary maps the business terms users say to the columns they name, units tell what the values of columns measure.
+// Roles lists the roles allowed to query it when runs have one, empty allows every role
+type datasetSchema struct {
+	RequiredColumns   []string
+	OptionalColumns   []string
+	MonetaryColumns   []string // Amounts, written with a currency symbol
+	IdentifierColumns []string // Numeric codes, never written with separators
+	Views             []datasetView
+	Glossary          []GlossaryTerm
+	Units             []ColumnUnit
+	Roles             []string
+}
+
+// Expected schema per dataset, keyed by table name
+var datasetSchemas = map[string]datasetSchema{
+	tableName: {
+		RequiredColumns:   []string{"Store_Number", "SKU_Coded", "Sold_Date", "Qty_Sold", "Total_Sale_Value"},
+		OptionalColumns:   []string{"Product_Class_Code", "On_Promo"},
+		MonetaryColumns:   []string{"Total_Sale_Value"},
+		IdentifierColumns: []string{"Store_Number", "SKU_Coded", "Product_Class_Code"},
+		Views: []datasetView{
+			{
+				Name:        "sales_by_store",
+				Description: "Total quantity sold and sales value per store (Store_Number, Total_Qty_Sold, Total_Sale_Value)",
+				SQL: `SELECT Store_Number, sum(Qty_Sold) AS Total_Qty_Sold, sum(Total_Sale_Value::DECIMAL(18, 2)) AS Total_Sale_Value
+					FROM sales GROUP BY Store_Number`,
+			},
+			{
+				Name:        "sales_by_product_class",
+				Description: "Total quantity sold and sales value per product class (Product_Class_Code, Total_Qty_Sold, Total_Sale_Value)",
+				SQL: `SELECT Product_Class_Code, sum(Qty_Sold) AS Total_Qty_Sold, sum(Total_Sale_Value::DECIMAL(18, 2)) AS Total_Sale_Value
+					FROM sales GROUP BY Product_Class_Code`,
+			},
+			{
+				Name:        "sales_by_month",
+				Description: "Total quantity sold and sales value per month (Sale_Month, Total_Qty_Sold, Total_Sale_Value)",
+				SQL: `SELECT date_trunc('month', Sold_Date) AS Sale_Month, sum(Qty_Sold) AS Total_Qty_Sold,
+					sum(Total_Sale_Value::DECIMAL(18, 2)) AS Total_Sale_Value
+					FROM sales GROUP BY Sale_Month`,
+			},
+			{
+				Name:        "sales_by_promo",
+				Description: "Sales split by promotion status (On_Promo, Transactions, Total_Qty_Sold, Total_Sale_Value)",
+				SQL: `SELECT On_Promo, count(*) AS Transactions, sum(Qty_Sold) AS Total_Qty_Sold,
+					sum(Total_Sale_Value::DECIMAL(18, 2)) AS Total_Sale_Value
+					FROM sales GROUP BY On_Promo`,
+			},
+		},
+		Glossary: []GlossaryTerm{
+			{Term: "revenue", Column: "Total_Sale_Value", Description: "sales value in currency"},
+			{Term: "turnover", Column: "Total_Sale_Value", Description: "sales value in currency"},
+			{Term: "units sold", Column: "Qty_Sold", Description: "units of the product sold on the sale"},
+			{Term: "quantity sold", Column: "Qty_Sold", Description: "units of the product sold on the sale"},
+			{Term: "store number", Column: "Store_Number"},
+			{Term: "SKU", Column: "SKU_Coded", Description: "coded product SKU"},
+			{Term: "sale date", Column: "Sold_Date"},
+		},
+		Units: []ColumnUnit{
+			{Column: "Total_Sale_Value", Unit: UnitDollars},
+			{Column: "Qty_Sold", Unit: UnitCount},
+		},
+	},
+}
+
+// Open the DuckDB database at databasePath, creating the dataset table from dataPath if missing.
+// Local data files are fingerprinted, so the table is reloaded when the file changes.
+// A non zero sample loads only part of the file, switching samples reloads the table too.
+// forceRefresh always reloads the table. Returns whether the table was reloaded.
+// A live database dataPath (see IsLiveDatabase) is opened as is instead, databasePath and sample are unused.
+// The caller owns the returned database and must close it
+func OpenDatabase(databasePath string, dataPath string, sample Sample, forceRefresh bool, readOnly bool) (*sql.DB, bool, error) {
+	if readOnly {
+		return openReadOnlyDatabase(databasePath, dataPath, sample, forceRefresh)
+	}
+
+	if IsLiveDatabase(dataPath) {
+		db, err := openLiveDatabase(dataPath)
+		return db, false, err
+	}
+
+	log.Printf("Opening database at %s\n", databasePath)
+	db, err := sql.Open("duckdb", databasePath)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	refreshed, err := bootstrapDataset(db, dataPath, sample, forceRefresh)
+	if err != nil {
+		db.Close()
+		return nil, false, err
+	}
+
+	return db, refreshed, nil
+}
+
+// Create or reload the dataset table and its views. Returns whether the table was reloaded
+func bootstrapDataset(db *sql.DB, dataPath string, sample Sample, forceRefresh bool) (bool, error) {
+	if err := createFingerprintTable(db); err != nil {
+		return false, err
+	}
+
+	// Remote data needs the httpfs extension before reading it
+	if IsRemotePath(dataPath) {
+		if err := loadRemoteExtensions(db); err != nil {
+			return false, err
+		}
+
+		// Remote files can't be fingerprinted, only a forced refresh or another sample reloads them
+		refresh, err := sampleChanged(db, sample)
+		if err != nil {
+			return false, err
+		}
+		refresh = refresh || forceRefresh
+		if err := loadTable(db, dataPath, sample, refresh); err != nil {
+			return false, err
+		}
+		if refresh {
+			return true, saveFingerprint(db, tableName, dataFingerprint{DataPath: dataPath, Sample: sample.String()})
+		}
+
+		return false, nil
+	}
+
+	refresh, fingerprint := true, dataFingerprint{}
+	var err error
+	if forceRefresh {
+		log.Printf("Forcing refresh of dataset '%s'\n", tableName)
+		fingerprint, err = statFingerprint(dataPath)
+		if err == nil {
+			fingerprint.Hash, err = hashDataFile(dataPath)
+		}
+		fingerprint.Sample = sample.String()
+	} else {
+		refresh, fingerprint, err = checkFingerprint(db, dataPath, sample)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if err := loadTable(db, dataPath, sample, refresh); err != nil {
+		return false, err
+	}
+
+	if refresh {
+		log.Printf("Refreshed dataset '%s' from %s\n", tableName, dataPath)
+		if err := saveFingerprint(db, tableName, fingerprint); err != nil {
+			return false, err
+		}
+	}
+
+	return refresh, nil
+}
+
+// Load the dataset table from dataPath and create its views.
+// An existing table is only replaced if replace is set
+func loadTable(db *sql.DB, dataPath string, sample Sample, replace bool) error {
+	createStatement := "CREATE TABLE IF NOT EXISTS"
+	if replace {
+		log.Printf("Reloading table '%s' from %s\n", tableName, dataPath)
+		createStatement = "CREATE OR REPLACE TABLE"
+		if !sample.IsZero() {
+			log.Printf("Sampling %s of %s into table '%s'\n", sample, dataPath, tableName)
+		}
+	}
+
+	_, err := db.Exec(
+		fmt.Sprintf("%s %s AS SELECT * FROM read_parquet('%s')%s", createStatement, tableName, dataPath, sample.clause()),
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to execute table creation SQL: %w", err)
+	}
+
+	return createViews(db)
+}
+
+// Create or replace the views declared for the dataset table. Views are skipped when the table lacks
+// required columns, so ValidateDataset reports the missing columns instead of a view failing on them
+func createViews(db *sql.DB) error {
+	columns, err := datasetColumns(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	if missing := missingRequiredColumns(columns); len(missing) != 0 {
+		log.Printf("WARNING: Dataset '%s' is missing required columns %v. Skipping its views\n", tableName, missing)
+		return nil
+	}
+
+	for _, view := range datasetSchemas[tableName].Views {
+		log.Printf("Creating view '%s'\n", view.Name)
+		_, err := db.Exec(fmt.Sprintf("CREATE OR REPLACE VIEW %s AS %s", view.Name, view.SQL))
+		if err != nil {
+			return fmt.Errorf("failed to create view '%s': %w", view.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Reload the dataset table from dataPath, with sample, and recreate its views.
+// Used when the underlying parquet file changes
+func RefreshViews(db *sql.DB, dataPath string, sample Sample) error {
+	if IsLiveDatabase(dataPath) {
+		log.Println("Live databases are queried in place, nothing to refresh")
+		return nil
+	}
+
+	_, err := bootstrapDataset(db, dataPath, sample, true)
+	return err
+}
+
+// Check that the dataset table can be read, for health checks
+func PingDataset(ctx context.Context, store Store) error {
+	if _, err := store.RowCount(ctx); err != nil {
+		return fmt.Errorf("failed to read dataset '%s': %w", tableName, err)
+	}
+
+	return nil
+}
+
+// Columns of the dataset table and its views holding amounts
+func MonetaryColumns() []string {
+	return datasetSchemas[tableName].MonetaryColumns
+}
+
+// Columns of the dataset table and its views holding numeric codes
+func IdentifierColumns() []string {
+	return datasetSchemas[tableName].IdentifierColumns
+}
+
+// Model facing description of the views available for the dataset table
+func viewsDescription() string {
+	views := datasetSchemas[tableName].Views
+	if len(views) == 0 {
+		return "none"
+	}
+
+	lines := []string{}
+	for _, view := range views {
+		lines = append(lines, fmt.Sprintf("- %s: %s", view.Name, view.Description))
+	}
+
+	return "\n" + strings.Join(lines, "\n")
+}
+
+// Validate the loaded dataset before any LLM call is made.
+// Returns an error if the table has no rows or lacks required columns.
+func ValidateDataset(store Store, dataPath string) error {
+	log.Printf("Validating dataset '%s'\n", tableName)
+	rowCount, err := store.RowCount(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to count rows for dataset '%s': %w", tableName, err)
+	}
+
+	if rowCount == 0 {
+		return fmt.Errorf("dataset '%s' loaded 0 rows from %s", tableName, RedactDSN(dataPath))
+	}
+
+	columns, err := store.Columns(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to fetch columns for dataset '%s': %w", tableName, err)
+	}
+
+	schema, ok := datasetSchemas[tableName]
+	if !ok {
+		log.Printf("WARNING: No expected schema for dataset '%s'. Skipping column checks\n", tableName)
+		return nil
+	}
+
+	if missing := missingRequiredColumns(columns); len(missing) != 0 {
+		return fmt.Errorf("dataset '%s' loaded from %s is missing required columns: %v", tableName, RedactDSN(dataPath), missing)
+	}
+
+	for _, column := range schema.OptionalColumns {
+		if !slices.Contains(columns, column) {
+			log.Printf("WARNING: Dataset '%s' is missing optional column '%s'\n", tableName, column)
+		}
+	}
+
+	log.Printf("Dataset '%s' loaded %d rows with %d columns\n", tableName, rowCount, len(columns))
+	return nil
+}
+
+// Required columns of the dataset table absent from columns
+func missingRequiredColumns(columns []string) []string {
+	missing := []string{}
+	for _, column := range datasetSchemas[tableName].RequiredColumns {
+		if !slices.Contains(columns, column) {
+			missing = append(missing, column)
+		}
+	}
+
+	return missing
+}
+
+// Columns of the dataset table
+func datasetColumns(ctx context.Context, db *sql.DB) ([]string, error) {
+	query := fmt.Sprintf("SELECT * FROM %s WHERE 1=2", tableName)
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, &ErrSQLExecution{Query: query, DBErr: err}
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch table columns: %w", err)
+	}
+
+	return columns, nil
+}
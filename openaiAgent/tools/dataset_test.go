@@ -0,0 +1,75 @@
+package tools
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Parquet fixtures of the sales dataset: a few valid rows, the same columns without rows,
+// only two of the required columns, and the first half of the valid file
+const datasetFixtures = "../testdata/datasets"
+
+var storageBackends = []string{StorageMemory, StorageDuckDB}
+
+// Open a dataset fixture on a backend, the DuckDB database lives in a temp dir
+func openFixtureStore(t *testing.T, backend string, fixture string) (Store, error) {
+	t.Helper()
+	if backend == StorageDuckDB {
+		if err := duckDBAvailable(); err != nil {
+			t.Skipf("DuckDB can't initialize: %s", err)
+		}
+	}
+
+	databasePath := filepath.Join(t.TempDir(), "data.db")
+	store, _, err := OpenStore(backend, databasePath, filepath.Join(datasetFixtures, fixture), Sample{}, true, false)
+	if err == nil {
+		t.Cleanup(func() { store.Close() })
+	}
+	return store, err
+}
+
+func TestValidateDataset(t *testing.T) {
+	cases := []struct {
+		name    string
+		fixture string
+		wantErr string
+	}{
+		{name: "valid", fixture: "sales.parquet"},
+		{name: "empty", fixture: "empty.parquet", wantErr: "loaded 0 rows"},
+		{name: "missing columns", fixture: "missing_columns.parquet", wantErr: "missing required columns: [SKU_Coded Qty_Sold Total_Sale_Value]"},
+	}
+
+	for _, backend := range storageBackends {
+		for _, c := range cases {
+			t.Run(backend+"/"+c.name, func(t *testing.T) {
+				dataPath := filepath.Join(datasetFixtures, c.fixture)
+				store, err := openFixtureStore(t, backend, c.fixture)
+				if err != nil {
+					t.Fatalf("failed to open %s: %s", dataPath, err)
+				}
+
+				err = ValidateDataset(store, dataPath)
+				switch {
+				case c.wantErr == "" && err != nil:
+					t.Fatalf("expected %s to validate, got: %s", c.fixture, err)
+				case c.wantErr != "" && err == nil:
+					t.Fatalf("expected %s to fail validation with '%s'", c.fixture, c.wantErr)
+				case c.wantErr != "" && !strings.Contains(err.Error(), c.wantErr):
+					t.Fatalf("expected error containing '%s', got: %s", c.wantErr, err)
+				}
+			})
+		}
+	}
+}
+
+// A truncated file never reaches validation, opening it fails before any LLM call
+func TestOpenTruncatedDataset(t *testing.T) {
+	for _, backend := range storageBackends {
+		t.Run(backend, func(t *testing.T) {
+			if _, err := openFixtureStore(t, backend, "truncated.parquet"); err == nil {
+				t.Fatal("expected opening a truncated parquet file to fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package tools
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+)
+
+/*
+----------------
+Date normalizing
+----------------
+*/
+
+// Order of the day and month of numeric dates like 01/02/2021
+type DateOrder string
+
+const (
+	DateOrderUnset DateOrder = ""    // Ambiguous dates are asked about instead of guessed
+	DateOrderDMY   DateOrder = "dmy" // 01/02/2021 is the 1st of February
+	DateOrderMDY   DateOrder = "mdy" // 01/02/2021 is the 2nd of January
+)
+
+// Parse a -date-format value: dmy, mdy, or empty to ask about ambiguous dates
+func ParseDateOrder(order string) (DateOrder, error) {
+	switch DateOrder(strings.ToLower(strings.TrimSpace(order))) {
+	case DateOrderUnset:
+		return DateOrderUnset, nil
+	case DateOrderDMY:
+		return DateOrderDMY, nil
+	case DateOrderMDY:
+		return DateOrderMDY, nil
+	}
+
+	return DateOrderUnset, fmt.Errorf("invalid date format '%s', expected dmy or mdy", order)
+}
+
+// Date mentioned on a prompt and the ISO-8601 day or period it stands for
+type NormalizedDate struct {
+	Text  string // As written on the prompt, like "01/02/2021" or "last week"
+	Start string // ISO-8601 day
+	End   string // Last day of a period, like a week or a month. Empty for single days
+}
+
+func (d NormalizedDate) String() string {
+	if d.End == "" {
+		return fmt.Sprintf("%s means %s", d.Text, d.Start)
+	}
+	return fmt.Sprintf("%s means %s to %s", d.Text, d.Start, d.End)
+}
+
+// A numeric date reads as two different days and no date order is configured. The user has to say which one
+type ErrAmbiguousDate struct {
+	Text       string
+	DayFirst   string // ISO-8601 day of the dmy reading
+	MonthFirst string // ISO-8601 day of the mdy reading
+}
+
+func (e *ErrAmbiguousDate) Error() string {
+	return fmt.Sprintf(
+		"the date '%s' is ambiguous, it may be %s (day first) or %s (month first). Ask the user which one they mean",
+		e.Text, e.DayFirst, e.MonthFirst,
+	)
+}
+func (e *ErrAmbiguousDate) Class() string             { return ErrorClassClarification }
+func (e *ErrAmbiguousDate) IsRetryable() bool         { return false }
+func (e *ErrAmbiguousDate) ExitClass() exitcode.Class { return exitcode.ClassData }
+
+// Month names and their abbreviations, keyed by their first three letters in monthOf
+const monthPattern = `(jan(?:uary)?|feb(?:ruary)?|mar(?:ch)?|apr(?:il)?|may|june?|july?|aug(?:ust)?|sept?(?:ember)?|oct(?:ober)?|nov(?:ember)?|dec(?:ember)?)\.?`
+
+var monthOf = map[string]time.Month{
+	"jan": time.January, "feb": time.February, "mar": time.March, "apr": time.April,
+	"may": time.May, "jun": time.June, "jul": time.July, "aug": time.August,
+	"sep": time.September, "oct": time.October, "nov": time.November, "dec": time.December,
+}
+
+// Date-like tokens of a prompt and how each one is read. Parsers return false for tokens that aren't dates
+type datePattern struct {
+	matcher *regexp.Regexp
+	parse   func(match []string, order DateOrder, today time.Time) (NormalizedDate, bool, error)
+}
+
+var datePatterns = []datePattern{
+	// 2021-02-01, 2021/2/1
+	{regexp.MustCompile(`\b(\d{4})[-/.](\d{1,2})[-/.](\d{1,2})\b`), parseYearFirstDate},
+	// 01/02/2021, 1-2-21, 01.02.2021
+	{regexp.MustCompile(`\b(\d{1,2})([-/.])(\d{1,2})([-/.])(\d{4}|\d{2})\b`), parseNumericDate},
+	// February 1, 2021, Feb 1st, feb. 1 2021
+	{regexp.MustCompile(`(?i)\b` + monthPattern + `\s+(\d{1,2})(?:st|nd|rd|th)?(?:,?\s+(\d{4}))?\b`), parseMonthDayDate},
+	// 1 February 2021, 1st of Feb
+	{regexp.MustCompile(`(?i)\b(\d{1,2})(?:st|nd|rd|th)?\s+(?:of\s+)?` + monthPattern + `(?:,?\s+(\d{4}))?\b`), parseDayMonthDate},
+	// February 2021
+	{regexp.MustCompile(`(?i)\b` + monthPattern + `,?\s+(\d{4})\b`), parseMonthYear},
+	// today, yesterday
+	{regexp.MustCompile(`(?i)\b(today|yesterday)\b`), parseRelativeDay},
+	// last week, this quarter, previous year
+	{regexp.MustCompile(`(?i)\b(this|last|past|previous)\s+(week|month|quarter|year)\b`), parseRelativePeriod},
+	// last 7 days, past 3 months
+	{regexp.MustCompile(`(?i)\b(?:last|past|previous)\s+(\d{1,3})\s+(days?|weeks?|months?)\b`), parseTrailingPeriod},
+	// 3 days ago, 2 weeks ago
+	{regexp.MustCompile(`(?i)\b(\d{1,3})\s+(days?|weeks?|months?)\s+ago\b`), parseAgo},
+}
+
+// Find the dates of a prompt and normalize them to ISO-8601 days or periods. Numeric dates are read in the
+// order given, or the only valid one; relative ones like "last week" resolve against today, weeks starting on Monday.
+// Fails with ErrAmbiguousDate on numeric dates with two readings and no order
+func NormalizeDates(prompt string, order DateOrder, today time.Time) ([]NormalizedDate, error) {
+	type candidate struct {
+		start, end int
+		match      []string
+		pattern    datePattern
+	}
+
+	candidates := []candidate{}
+	for _, pattern := range datePatterns {
+		for _, indexes := range pattern.matcher.FindAllStringSubmatchIndex(prompt, -1) {
+			match := []string{}
+			for i := 0; i < len(indexes); i += 2 {
+				if indexes[i] < 0 {
+					match = append(match, "")
+					continue
+				}
+				match = append(match, prompt[indexes[i]:indexes[i+1]])
+			}
+			candidates = append(candidates, candidate{start: indexes[0], end: indexes[1], match: match, pattern: pattern})
+		}
+	}
+
+	// Earlier and then longer tokens win, so "1 March 2021" isn't also read as "March 2021"
+	slices.SortStableFunc(candidates, func(a candidate, b candidate) int {
+		if a.start != b.start {
+			return a.start - b.start
+		}
+		return b.end - a.end
+	})
+
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	dates := []NormalizedDate{}
+	covered := 0
+	for _, candidate := range candidates {
+		if candidate.start < covered {
+			continue
+		}
+
+		date, ok, err := candidate.pattern.parse(candidate.match, order, today)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		date.Text = candidate.match[0]
+		dates = append(dates, date)
+		covered = candidate.end
+	}
+
+	return dates, nil
+}
+
+// Line appended to a lookup prompt stating its normalized dates, empty when it has none
+func DateClarification(dates []NormalizedDate) string {
+	if len(dates) == 0 {
+		return ""
+	}
+
+	normalized := []string{}
+	for _, date := range dates {
+		normalized = append(normalized, date.String())
+	}
+	return fmt.Sprintf("\nDates in this request, normalized to ISO-8601: %s.", strings.Join(normalized, "; "))
+}
+
+// Day of a year, month and day, false when it doesn't exist, like the 31st of February
+func validDate(year int, month int, day int) (time.Time, bool) {
+	if month < 1 || month > 12 || day < 1 {
+		return time.Time{}, false
+	}
+
+	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	return date, date.Day() == day
+}
+
+// Year of a date token. Two digit years are 2000 to 2069, or 1970 to 1999
+func fullYear(year string) int {
+	value, _ := strconv.Atoi(year)
+	switch {
+	case len(year) == 4:
+		return value
+	case value < 70:
+		return 2000 + value
+	}
+	return 1900 + value
+}
+
+// Normalized single day
+func singleDay(date time.Time) NormalizedDate {
+	return NormalizedDate{Start: date.Format(time.DateOnly)}
+}
+
+// Normalized period, both days included
+func datePeriod(start time.Time, end time.Time) NormalizedDate {
+	return NormalizedDate{Start: start.Format(time.DateOnly), End: end.Format(time.DateOnly)}
+}
+
+func parseYearFirstDate(match []string, _ DateOrder, _ time.Time) (NormalizedDate, bool, error) {
+	month, _ := strconv.Atoi(match[2])
+	dayOfMonth, _ := strconv.Atoi(match[3])
+	date, ok := validDate(fullYear(match[1]), month, dayOfMonth)
+	return singleDay(date), ok, nil
+}
+
+func parseNumericDate(match []string, order DateOrder, _ time.Time) (NormalizedDate, bool, error) {
+	// Mixed separators, or dotted numbers with a two digit year, are more likely versions or ranges than dates
+	if match[2] != match[4] || (match[2] == "." && len(match[5]) == 2) {
+		return NormalizedDate{}, false, nil
+	}
+
+	first, _ := strconv.Atoi(match[1])
+	second, _ := strconv.Atoi(match[3])
+	year := fullYear(match[5])
+	dayFirst, dayFirstOk := validDate(year, second, first)
+	monthFirst, monthFirstOk := validDate(year, first, second)
+
+	switch {
+	case !dayFirstOk && !monthFirstOk:
+		return NormalizedDate{}, false, nil
+	case !monthFirstOk:
+		return singleDay(dayFirst), true, nil
+	case !dayFirstOk, dayFirst.Equal(monthFirst):
+		return singleDay(monthFirst), true, nil
+	case order == DateOrderDMY:
+		return singleDay(dayFirst), true, nil
+	case order == DateOrderMDY:
+		return singleDay(monthFirst), true, nil
+	}
+
+	return NormalizedDate{}, false, &ErrAmbiguousDate{
+		Text:       match[0],
+		DayFirst:   dayFirst.Format(time.DateOnly),
+		MonthFirst: monthFirst.Format(time.DateOnly),
+	}
+}
+
+// Day of a month name, a day and an optional year, the current year when missing
+func monthNameDate(monthName string, dayOfMonth string, year string, today time.Time) (NormalizedDate, bool, error) {
+	dayValue, _ := strconv.Atoi(dayOfMonth)
+	yearValue := today.Year()
+	if year != "" {
+		yearValue = fullYear(year)
+	}
+
+	date, ok := validDate(yearValue, int(monthOf[strings.ToLower(monthName[:3])]), dayValue)
+	return singleDay(date), ok, nil
+}
+
+func parseMonthDayDate(match []string, _ DateOrder, today time.Time) (NormalizedDate, bool, error) {
+	return monthNameDate(match[1], match[2], match[3], today)
+}
+
+func parseDayMonthDate(match []string, _ DateOrder, today time.Time) (NormalizedDate, bool, error) {
+	return monthNameDate(match[2], match[1], match[3], today)
+}
+
+func parseMonthYear(match []string, _ DateOrder, _ time.Time) (NormalizedDate, bool, error) {
+	start := time.Date(fullYear(match[2]), monthOf[strings.ToLower(match[1][:3])], 1, 0, 0, 0, 0, time.UTC)
+	return datePeriod(start, start.AddDate(0, 1, -1)), true, nil
+}
+
+func parseRelativeDay(match []string, _ DateOrder, today time.Time) (NormalizedDate, bool, error) {
+	if strings.EqualFold(match[1], "yesterday") {
+		return singleDay(today.AddDate(0, 0, -1)), true, nil
+	}
+	return singleDay(today), true, nil
+}
+
+func parseRelativePeriod(match []string, _ DateOrder, today time.Time) (NormalizedDate, bool, error) {
+	var start, previous time.Time
+	switch strings.ToLower(match[2]) {
+	case "week":
+		start = today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+		previous = start.AddDate(0, 0, -7)
+	case "month":
+		start = time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+		previous = start.AddDate(0, -1, 0)
+	case "quarter":
+		start = time.Date(today.Year(), (today.Month()-1)/3*3+1, 1, 0, 0, 0, 0, time.UTC)
+		previous = start.AddDate(0, -3, 0)
+	case "year":
+		start = time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+		previous = start.AddDate(-1, 0, 0)
+	}
+
+	// The current period runs up to today, the last one is the whole period before it
+	if strings.EqualFold(match[1], "this") {
+		return datePeriod(start, today), true, nil
+	}
+	return datePeriod(previous, start.AddDate(0, 0, -1)), true, nil
+}
+
+// Amount of days, weeks or months of a relative token, false for zero
+func relativeAmount(amount string, unit string) (int, string, bool) {
+	value, _ := strconv.Atoi(amount)
+	return value, strings.TrimSuffix(strings.ToLower(unit), "s"), value > 0
+}
+
+// Periods of the last days, weeks or months end today
+func parseTrailingPeriod(match []string, _ DateOrder, today time.Time) (NormalizedDate, bool, error) {
+	amount, unit, ok := relativeAmount(match[1], match[2])
+	if !ok {
+		return NormalizedDate{}, false, nil
+	}
+
+	switch unit {
+	case "day":
+		return datePeriod(today.AddDate(0, 0, 1-amount), today), true, nil
+	case "week":
+		return datePeriod(today.AddDate(0, 0, 1-7*amount), today), true, nil
+	}
+	return datePeriod(today.AddDate(0, -amount, 1), today), true, nil
+}
+
+func parseAgo(match []string, _ DateOrder, today time.Time) (NormalizedDate, bool, error) {
+	amount, unit, ok := relativeAmount(match[1], match[2])
+	if !ok {
+		return NormalizedDate{}, false, nil
+	}
+
+	switch unit {
+	case "day":
+		return singleDay(today.AddDate(0, 0, -amount)), true, nil
+	case "week":
+		return singleDay(today.AddDate(0, 0, -7*amount)), true, nil
+	}
+	return singleDay(today.AddDate(0, -amount, 0)), true, nil
+}
@@ -0,0 +1,88 @@
+package tools
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// Numeric, named and relative dates, with and without a date order. Dates are compared as NormalizedDate.String
+func TestNormalizeDates(t *testing.T) {
+	// Day relative dates resolve against, a Wednesday
+	today := time.Date(2024, time.March, 13, 15, 4, 5, 0, time.UTC)
+
+	cases := []struct {
+		name      string
+		prompt    string
+		order     DateOrder
+		want      []string
+		wantError string
+	}{
+		{name: "no dates", prompt: "total sales by store", want: []string{}},
+		{name: "iso", prompt: "sales on 2021-02-01", want: []string{"2021-02-01 means 2021-02-01"}},
+		{name: "year first slashes", prompt: "sales on 2021/2/1", want: []string{"2021/2/1 means 2021-02-01"}},
+		{name: "ambiguous slashes", prompt: "sales on 01/02/2021", wantError: "the date '01/02/2021' is ambiguous, it may be 2021-02-01 (day first) or 2021-01-02 (month first). Ask the user which one they mean"},
+		{name: "slashes dmy", prompt: "sales on 01/02/2021", order: DateOrderDMY, want: []string{"01/02/2021 means 2021-02-01"}},
+		{name: "slashes mdy", prompt: "sales on 01/02/2021", order: DateOrderMDY, want: []string{"01/02/2021 means 2021-01-02"}},
+		{name: "day over 12", prompt: "sales on 25/12/2021", want: []string{"25/12/2021 means 2021-12-25"}},
+		{name: "month over 12", prompt: "sales on 12/25/2021", want: []string{"12/25/2021 means 2021-12-25"}},
+		{name: "only valid reading wins over order", prompt: "sales on 12/25/2021", order: DateOrderDMY, want: []string{"12/25/2021 means 2021-12-25"}},
+		{name: "same day both ways", prompt: "sales on 03/03/2021", want: []string{"03/03/2021 means 2021-03-03"}},
+		{name: "dashes two digit year", prompt: "sales on 1-2-21", order: DateOrderMDY, want: []string{"1-2-21 means 2021-01-02"}},
+		{name: "two digit year of the 90s", prompt: "sales on 31-12-99", want: []string{"31-12-99 means 1999-12-31"}},
+		{name: "dots", prompt: "sales on 01.02.2021", order: DateOrderDMY, want: []string{"01.02.2021 means 2021-02-01"}},
+		{name: "dotted version", prompt: "compare model 1.2.21 results", want: []string{}},
+		{name: "mixed separators", prompt: "stores 1/2-2021", want: []string{}},
+		{name: "invalid day", prompt: "sales on 31/02/2021", want: []string{}},
+		{name: "fraction", prompt: "the top 1/2 of stores", want: []string{}},
+		{name: "month day year", prompt: "sales on February 1, 2021", want: []string{"February 1, 2021 means 2021-02-01"}},
+		{name: "abbreviated month and ordinal", prompt: "sales on feb. 1st 2021", want: []string{"feb. 1st 2021 means 2021-02-01"}},
+		{name: "day month year", prompt: "sales on 1 February 2021", want: []string{"1 February 2021 means 2021-02-01"}},
+		{name: "day of month", prompt: "sales on the 3rd of Sept", want: []string{"3rd of Sept means 2024-09-03"}},
+		{name: "month without year", prompt: "sales on March 5", want: []string{"March 5 means 2024-03-05"}},
+		{name: "month and year", prompt: "sales in June 2021", want: []string{"June 2021 means 2021-06-01 to 2021-06-30"}},
+		{name: "leap february", prompt: "sales in Feb 2024", want: []string{"Feb 2024 means 2024-02-01 to 2024-02-29"}},
+		{name: "month word in other words", prompt: "top 2 markets by decimals of margin", want: []string{}},
+		{name: "today and yesterday", prompt: "sales today vs yesterday", want: []string{"today means 2024-03-13", "yesterday means 2024-03-12"}},
+		{name: "last week", prompt: "sales last week", want: []string{"last week means 2024-03-04 to 2024-03-10"}},
+		{name: "this week", prompt: "sales this week", want: []string{"this week means 2024-03-11 to 2024-03-13"}},
+		{name: "last month", prompt: "sales last month", want: []string{"last month means 2024-02-01 to 2024-02-29"}},
+		{name: "this month", prompt: "sales this month", want: []string{"this month means 2024-03-01 to 2024-03-13"}},
+		{name: "previous quarter", prompt: "sales in the previous quarter", want: []string{"previous quarter means 2023-10-01 to 2023-12-31"}},
+		{name: "this quarter", prompt: "sales this quarter", want: []string{"this quarter means 2024-01-01 to 2024-03-13"}},
+		{name: "last year", prompt: "sales LAST YEAR", want: []string{"LAST YEAR means 2023-01-01 to 2023-12-31"}},
+		{name: "last days", prompt: "sales in the last 7 days", want: []string{"last 7 days means 2024-03-07 to 2024-03-13"}},
+		{name: "past weeks", prompt: "sales in the past 2 weeks", want: []string{"past 2 weeks means 2024-02-29 to 2024-03-13"}},
+		{name: "last months", prompt: "sales in the last 3 months", want: []string{"last 3 months means 2023-12-14 to 2024-03-13"}},
+		{name: "days ago", prompt: "sales 3 days ago", want: []string{"3 days ago means 2024-03-10"}},
+		{name: "weeks ago", prompt: "sales 2 weeks ago", want: []string{"2 weeks ago means 2024-02-28"}},
+		{name: "zero days", prompt: "sales in the last 0 days", want: []string{}},
+		{name: "range", prompt: "sales from 2021-01-01 to Feb 3, 2021 vs last year", want: []string{
+			"2021-01-01 means 2021-01-01", "Feb 3, 2021 means 2021-02-03", "last year means 2023-01-01 to 2023-12-31",
+		}},
+		{name: "ambiguous after a clear date", prompt: "sales on 25/12/2021 and 01/02/2021", wantError: "the date '01/02/2021' is ambiguous, it may be 2021-02-01 (day first) or 2021-01-02 (month first). Ask the user which one they mean"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dates, err := NormalizeDates(c.prompt, c.order, today)
+			if c.wantError != "" {
+				if err == nil || err.Error() != c.wantError {
+					t.Fatalf("failed with %v, expected %s", err, c.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			found := []string{}
+			for _, date := range dates {
+				found = append(found, date.String())
+			}
+			if !slices.Equal(found, c.want) {
+				t.Fatalf("found %q, expected %q", found, c.want)
+			}
+		})
+	}
+}
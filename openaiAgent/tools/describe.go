@@ -0,0 +1,139 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/prompts"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+)
+
+/*
+-------------------
+Dataset description
+-------------------
+*/
+
+// Names of integer columns identifying something, like Store_Number or SKU_Coded, rather than measuring it
+var identifierColumnRegex = regexp.MustCompile(`(?i)(^id|_id|_number|_no|_code|_coded|_key)$`)
+
+// Describe the dataset in a few sentences for the system prompt, from its profile. With useModel the model
+// writes the description, otherwise it's templated from the profile alone.
+// The span is a child of the span carried by parentCtx
+func (t *Toolbox) DescribeDataset(parentCtx context.Context, useModel bool) (string, error) {
+	ctx, span := t.tracer.StartOpenInferenceSpan(parentCtx, "DescribeDatasetTool", tracing.ToolKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	tracing.SetSpanInput(span, tableName)
+	tracing.SetSpanAttr(span, "description.model_written", useModel)
+
+	profile, err := t.ProfileDataset(ctx, false)
+	if err != nil {
+		tracing.SetSpanErrorCode(span)
+		return "", err
+	}
+
+	description := TemplatedDescription(profile)
+	if useModel {
+		grain := strings.Join(apparentGrain(profile), ", ")
+		if grain == "" {
+			grain = "unknown"
+		}
+		written, err := t.profileCompletion(ctx, prompts.RenderDescribeDataset(prompts.DescribeDatasetRequest{Grain: grain, Profile: profile.Markdown()}))
+		if err != nil {
+			tracing.SetSpanErrorCode(span)
+			return "", err
+		}
+		// Settings are single line values
+		description = strings.Join(strings.Fields(written), " ")
+	}
+
+	tracing.SetSpanOutput(span, description)
+	tracing.SetSpanSuccessCode(span)
+	return description, nil
+}
+
+// Description of the dataset built from its profile alone: size and period, apparent grain, measures,
+// flags and categories, one sentence each when the profile has them
+func TemplatedDescription(profile DatasetProfile) string {
+	sentences := []string{}
+
+	overview := fmt.Sprintf("The %s dataset has %d rows over %d columns", profile.Table, profile.Rows, len(profile.Columns))
+	if dates := profile.columnsOfKind(profileDate); len(dates) > 0 {
+		overview += fmt.Sprintf(", with %s from %s to %s", dates[0].Name, dates[0].FirstDate, dates[0].LastDate)
+	}
+	sentences = append(sentences, overview+".")
+
+	if grain := apparentGrain(profile); len(grain) > 0 {
+		sentences = append(sentences, fmt.Sprintf("Each row appears to record one %s combination.", joinWords(grain)))
+	}
+
+	measures, flags := []string{}, []string{}
+	for _, column := range profile.columnsOfKind(profileNumeric) {
+		switch {
+		case isFlagColumn(column):
+			flags = append(flags, fmt.Sprintf("%s (set on %s%% of the rows)", column.Name, roundedNumber(column.Mean*100)))
+		case !isIdentifierColumn(column):
+			measures = append(measures, fmt.Sprintf("%s (%s to %s)", column.Name, roundedNumber(column.Min), roundedNumber(column.Max)))
+		}
+	}
+	if len(measures) > 0 {
+		sentences = append(sentences, fmt.Sprintf("Its measures are %s.", joinWords(measures)))
+	}
+	if len(flags) > 0 {
+		sentences = append(sentences, fmt.Sprintf("Its 0/1 flags are %s.", joinWords(flags)))
+	}
+
+	categories := []string{}
+	for _, column := range profile.columnsOfKind(profileCategorical) {
+		categories = append(categories, fmt.Sprintf("%s (%d values)", column.Name, column.Distinct))
+	}
+	if len(categories) > 0 {
+		sentences = append(sentences, fmt.Sprintf("Its categories are %s.", joinWords(categories)))
+	}
+
+	return strings.Join(sentences, " ")
+}
+
+// Columns a row appears to be one combination of: identifier and categorical columns, then date ones
+func apparentGrain(profile DatasetProfile) []string {
+	keys, dates := []string{}, []string{}
+	for _, column := range profile.Columns {
+		switch {
+		case column.Kind == profileDate:
+			dates = append(dates, column.Name)
+		case column.Kind == profileCategorical, isIdentifierColumn(column):
+			keys = append(keys, column.Name)
+		}
+	}
+
+	return append(keys, dates...)
+}
+
+// Integer column named like an identifier
+func isIdentifierColumn(column ColumnProfile) bool {
+	return column.Kind == profileNumeric && strings.Contains(column.Type, "INT") && identifierColumnRegex.MatchString(column.Name)
+}
+
+// Numeric column holding only 0 and 1
+func isFlagColumn(column ColumnProfile) bool {
+	return column.Kind == profileNumeric && column.Distinct == 2 && column.Min == 0 && column.Max == 1
+}
+
+// Number rounded to 2 decimals, without trailing zeros
+func roundedNumber(value float64) string {
+	return strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+}
+
+// Words joined as "a, b and c"
+func joinWords(words []string) string {
+	if len(words) < 2 {
+		return strings.Join(words, "")
+	}
+
+	return strings.Join(words[:len(words)-1], ", ") + " and " + words[len(words)-1]
+}
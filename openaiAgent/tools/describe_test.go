@@ -0,0 +1,29 @@
+package tools
+
+import "testing"
+
+// Profile of the sales dataset with a categorical column added
+func TestTemplatedDescription(t *testing.T) {
+	profile := DatasetProfile{
+		Table: DatasetName,
+		Rows:  697894,
+		Columns: []ColumnProfile{
+			{Name: "Store_Number", Type: "SMALLINT", Kind: "numeric", Distinct: 35, Min: 330, Max: 4840},
+			{Name: "SKU_Coded", Type: "INTEGER", Kind: "numeric", Distinct: 659, Min: 6172800, Max: 6205900},
+			{Name: "Product_Class_Code", Type: "SMALLINT", Kind: "numeric", Distinct: 11, Min: 22800, Max: 25500},
+			{Name: "Sold_Date", Type: "DATE", Kind: "date", Distinct: 873, FirstDate: "2021-11-01", LastDate: "2024-03-31"},
+			{Name: "Qty_Sold", Type: "SMALLINT", Kind: "numeric", Distinct: 128, Min: 1, Max: 239},
+			{Name: "Total_Sale_Value", Type: "FLOAT", Kind: "numeric", Distinct: 3377, Min: 0.01, Max: 2094.09},
+			{Name: "On_Promo", Type: "TINYINT", Kind: "numeric", Distinct: 2, Min: 0, Max: 1, Mean: 0.0063},
+			{Name: "Region", Type: "VARCHAR", Kind: "categorical", Distinct: 4},
+		},
+	}
+	want := "The sales dataset has 697894 rows over 8 columns, with Sold_Date from 2021-11-01 to 2024-03-31. " +
+		"Each row appears to record one Store_Number, SKU_Coded, Product_Class_Code, Region and Sold_Date combination. " +
+		"Its measures are Qty_Sold (1 to 239) and Total_Sale_Value (0.01 to 2094.09). " +
+		"Its 0/1 flags are On_Promo (set on 0.63% of the rows). Its categories are Region (4 values)."
+
+	if description := TemplatedDescription(profile); description != want {
+		t.Fatalf("templated description is %q, expected %q", description, want)
+	}
+}
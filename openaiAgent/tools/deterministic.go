@@ -0,0 +1,56 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+----------------------------
+Deterministic chat completer
+----------------------------
+*/
+
+// Seed used by deterministic runs when none is provided
+const DefaultSeed int64 = 42
+
+// Chat completer setting a fixed seed and zero temperature on every request it forwards
+type deterministicCompleter struct {
+	completer ChatCompleter
+	seed      int64
+}
+
+// Wrap a completer so every completion (router, SQL generation, analysis, chart config and code)
+// is requested with the given seed and zero temperature
+func NewDeterministicCompleter(completer ChatCompleter, seed int64) ChatCompleter {
+	log.Printf("Using deterministic completions with seed %d\n", seed)
+	return &deterministicCompleter{completer: completer, seed: seed}
+}
+
+func (d *deterministicCompleter) New(
+	ctx context.Context,
+	body openai.ChatCompletionNewParams,
+	opts ...option.RequestOption,
+) (*openai.ChatCompletion, error) {
+	body.Seed = openai.Int(d.seed)
+	body.Temperature = openai.Float(0)
+
+	// Record the seed on the LLM span started for this completion
+	span := trace.SpanFromContext(ctx)
+	tracing.SetSpanAttrFromMap(span, map[string]any{
+		"llm.seed": int(d.seed),
+		"llm.invocation_parameters": fmt.Sprintf(
+			"{\"model\": \"%s\", \"seed\": %d, \"temperature\": 0}",
+			body.Model.Value,
+			d.seed,
+		),
+	})
+
+	return d.completer.New(ctx, body, opts...)
+}
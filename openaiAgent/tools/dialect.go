@@ -0,0 +1,79 @@
+package tools
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/*
+------------------
+DuckDB SQL dialect
+------------------
+*/
+
+// A dialect rule the generated SQL must follow, rendered into the generation prompt
+type dialectCapability struct {
+	Topic    string // What the rule is about
+	Guidance string // How to write it for DuckDB
+}
+
+// DuckDB capabilities and restrictions the model usually gets wrong
+var duckdbCapabilities = []dialectCapability{
+	{Topic: "Identifiers", Guidance: `quote identifiers with double quotes ("Store_Number"), never with backticks`},
+	{Topic: "Strings", Guidance: "quote string literals with single quotes ('2023-01-01')"},
+	{Topic: "Date formatting", Guidance: "use strftime(date, '%Y-%m') instead of DATE_FORMAT or TO_CHAR"},
+	{Topic: "Date parts", Guidance: "use date_trunc('month', date) or extract(year FROM date), there is no YEAR_MONTH"},
+	{Topic: "Date arithmetic", Guidance: "use date + INTERVAL 7 DAY or date_diff('day', a, b) instead of DATE_ADD or DATEDIFF"},
+	{Topic: "Null handling", Guidance: "use COALESCE instead of IFNULL, NVL or ISNULL"},
+	{Topic: "Limits", Guidance: "use LIMIT n OFFSET m, never LIMIT m, n or TOP n"},
+	{Topic: "Amount sums", Guidance: "cast amounts to DECIMAL before adding them up, like sum(Total_Sale_Value::DECIMAL(18, 2)), float sums drift"},
+}
+
+// Render the dialect capabilities as a prompt section
+func dialectDescription() string {
+	lines := []string{}
+	for _, capability := range duckdbCapabilities {
+		lines = append(lines, fmt.Sprintf("- %s: %s", capability.Topic, capability.Guidance))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+/*
+----------
+SQL linter
+----------
+*/
+
+// A trivially fixable dialect issue and its rewrite
+type sqlLintRule struct {
+	Name    string         // Name reported when the rule rewrites a query
+	Pattern *regexp.Regexp // Match of the issue
+	Replace string         // Replacement, can reference pattern groups
+}
+
+// Rewrites applied to generated SQL before it's executed
+var sqlLintRules = []sqlLintRule{
+	{Name: "backtick_identifier", Pattern: regexp.MustCompile("`([^`]*)`"), Replace: `"$1"`},
+	{Name: "ifnull", Pattern: regexp.MustCompile(`(?i)\b(?:IFNULL|NVL)\s*\(`), Replace: "COALESCE("},
+}
+
+// Rewrite trivially fixable dialect issues of a query.
+// Returns the rewritten query and the names of the rules that changed it, once per rewrite
+func LintSQL(query string) (string, []string) {
+	rewrites := []string{}
+	for _, rule := range sqlLintRules {
+		matches := rule.Pattern.FindAllStringIndex(query, -1)
+		if len(matches) == 0 {
+			continue
+		}
+
+		query = rule.Pattern.ReplaceAllString(query, rule.Replace)
+		for range matches {
+			rewrites = append(rewrites, rule.Name)
+		}
+	}
+
+	return query, rewrites
+}
@@ -0,0 +1,78 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Queries generated for the sales dataset that DuckDB rejected, with their linted form and whether it runs.
+// Rewrites the linter can't make are left to the dialect section of the generation prompt
+const sqlLintCorpus = "../testdata/sql/lint_corpus.json"
+
+// Query of the lint corpus
+type lintCorpusQuery struct {
+	Name     string   `json:"name"`
+	Query    string   `json:"query"`
+	Linted   string   `json:"linted"`
+	Rewrites []string `json:"rewrites"`
+	Runs     bool     `json:"runs"`
+}
+
+// Every previously failing query of the corpus lints to its expected form, with the rules that rewrote it,
+// and the linted query runs on DuckDB unless it needs more than a rewrite
+func TestLintSQLCorpus(t *testing.T) {
+	jsonBytes, err := os.ReadFile(sqlLintCorpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corpus := []lintCorpusQuery{}
+	if err := json.Unmarshal(jsonBytes, &corpus); err != nil {
+		t.Fatalf("failed to decode %s: %s", sqlLintCorpus, err)
+	}
+
+	store, err := openFixtureStore(t, StorageDuckDB, "sales.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := store.SQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range corpus {
+		t.Run(c.Name, func(t *testing.T) {
+			linted, rewrites := LintSQL(c.Query)
+			if linted != c.Linted {
+				t.Errorf("linted to '%s', expected '%s'", linted, c.Linted)
+			}
+			if !slices.Equal(rewrites, c.Rewrites) {
+				t.Errorf("rewrote with %v, expected %v", rewrites, c.Rewrites)
+			}
+
+			rows, err := db.QueryContext(context.Background(), linted)
+			if err == nil {
+				rows.Close()
+			}
+			if runs := err == nil; runs != c.Runs {
+				t.Errorf("linted query runs is %t, expected %t: %v", runs, c.Runs, err)
+			}
+		})
+	}
+}
+
+// Each capability is rendered as a line of the dialect section, in order
+func TestDialectDescription(t *testing.T) {
+	lines := strings.Split(dialectDescription(), "\n")
+	if len(lines) != len(duckdbCapabilities) {
+		t.Fatalf("rendered %d lines for %d capabilities", len(lines), len(duckdbCapabilities))
+	}
+	for i, capability := range duckdbCapabilities {
+		if want := "- " + capability.Topic + ": " + capability.Guidance; lines[i] != want {
+			t.Errorf("line %d is '%s', expected '%s'", i+1, lines[i], want)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build cgo && !noduckdb
+
+package tools
+
+import "github.com/marcboeker/go-duckdb"
+
+// The DuckDB driver needs cgo. Builds without cgo, or with the noduckdb tag, fall back to the memory storage backend
+
+func init() {
+	decimalValue = func(value any) (string, bool) {
+		decimal, ok := value.(duckdb.Decimal)
+		if !ok || decimal.Value == nil {
+			return "", false
+		}
+		return decimal.String(), true
+	}
+}
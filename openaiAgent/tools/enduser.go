@@ -0,0 +1,41 @@
+package tools
+
+import (
+	"context"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+-----------------------------
+End user attributed completer
+-----------------------------
+*/
+
+// Chat completer setting the end user of the context on every completion it forwards
+type endUserCompleter struct {
+	completer ChatCompleter
+}
+
+// Wrap a completer so completions carry the end user of their context, see completion.WithEndUser
+func NewEndUserCompleter(completer ChatCompleter) ChatCompleter {
+	return &endUserCompleter{completer: completer}
+}
+
+func (e *endUserCompleter) New(
+	ctx context.Context,
+	body openai.ChatCompletionNewParams,
+	opts ...option.RequestOption,
+) (*openai.ChatCompletion, error) {
+	// Requests attributed by their caller keep their user
+	if user := completion.EndUserFrom(ctx); user != "" && !body.User.Present {
+		body.User = openai.F(user)
+		tracing.SetSpanAttr(trace.SpanFromContext(ctx), "user.id", user)
+	}
+
+	return e.completer.New(ctx, body, opts...)
+}
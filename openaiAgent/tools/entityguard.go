@@ -0,0 +1,166 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+)
+
+/*
+----------------------
+Sensitive entity guard
+----------------------
+*/
+
+// Name of the guard on spans and audit entries
+const EntityGuardName = "EntityGuard"
+
+// Trailing column words users often leave out, like "customer 42" for Customer_ID
+var optionalColumnSuffixes = []string{"id", "code", "number", "name", "key"}
+
+// Words between a column mention and its value, like "customer id = 42" or "employee named Ana"
+const mentionConnectors = `(?:\s*(?:=|:|#|(?i:is|was|equals|number|no\.?|named|called)\b))*\s*`
+
+// Values following a column mention: quoted text, tokens with digits or capitalized names
+const mentionValue = `("[^"]+"|'[^']+'|[\w@.-]*\d[\w@.-]*|\p{Lu}[\p{L}'-]+(?:\s+\p{Lu}[\p{L}'-]+)*)`
+
+// Quoted phrases of a prompt, checked against the values of every sensitive column
+var quotedPhrase = regexp.MustCompile(`"([^"]+)"|'([^']{2,})'|“([^”]+)”`)
+
+// Sensitive column value a question filters on
+type EntityMatch struct {
+	Column string `json:"column"`
+	Value  string `json:"value"`
+	Source string `json:"source"` // "mention" for a column named next to a value, "value" for a quoted value found in the column
+}
+
+// Keep the configured sensitive columns the dataset table has, matched case insensitively.
+// Returns them with the table casing, missing ones are logged and left out
+func ResolveSensitiveColumns(ctx context.Context, store Store, columns []string) ([]string, error) {
+	if strings.TrimSpace(strings.Join(columns, "")) == "" {
+		return []string{}, nil
+	}
+
+	tableColumns, err := store.Columns(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := []string{}
+	for _, column := range columns {
+		column = strings.TrimSpace(column)
+		if column == "" {
+			continue
+		}
+
+		index := slices.IndexFunc(tableColumns, func(tableColumn string) bool {
+			return strings.EqualFold(tableColumn, column)
+		})
+		if index < 0 {
+			log.Printf("WARNING: Sensitive column '%s' isn't on the dataset, not guarding it\n", column)
+			continue
+		}
+		if !slices.Contains(resolved, tableColumns[index]) {
+			resolved = append(resolved, tableColumns[index])
+		}
+	}
+
+	return resolved, nil
+}
+
+// Build the pattern matching a column named next to a value. The value is the first group
+func columnMentionPattern(column string) *regexp.Regexp {
+	words := strings.FieldsFunc(strings.ToLower(column), func(r rune) bool {
+		return r == '_' || r == '-' || r == ' '
+	})
+	for i, word := range words {
+		words[i] = regexp.QuoteMeta(word)
+	}
+
+	name := strings.Join(words, `[\s_-]*`)
+	if len(words) > 1 && slices.Contains(optionalColumnSuffixes, words[len(words)-1]) {
+		name = fmt.Sprintf(`%s(?:[\s_-]*%s)?`, strings.Join(words[:len(words)-1], `[\s_-]*`), words[len(words)-1])
+	}
+
+	return regexp.MustCompile(`(?i:\b` + name + `\b)` + mentionConnectors + mentionValue)
+}
+
+// Find a sensitive column value the prompt filters on: a column named next to a value,
+// or a quoted phrase that is a value of the column. Returns nil if there is none
+func (t *Toolbox) findSensitiveEntity(ctx context.Context, prompt string, columns []string) (*EntityMatch, error) {
+	for _, column := range columns {
+		if match := columnMentionPattern(column).FindStringSubmatch(prompt); match != nil {
+			return &EntityMatch{Column: column, Value: strings.Trim(match[1], `"'`), Source: "mention"}, nil
+		}
+	}
+
+	for _, match := range quotedPhrase.FindAllStringSubmatch(prompt, -1) {
+		phrase := strings.TrimSpace(match[1] + match[2] + match[3])
+		if phrase == "" {
+			continue
+		}
+
+		for _, column := range columns {
+			found, err := t.store.ContainsValue(ctx, column, phrase)
+			if err != nil {
+				return nil, err
+			}
+			if found {
+				return &EntityMatch{Column: column, Value: phrase, Source: "value"}, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// Check whether a question targets an individual through a sensitive column of the configured ones.
+// Matches are recorded on the audit log and the guard span gets a refused status.
+// Returns nil when the question can run
+func (t *Toolbox) GuardSensitiveEntities(ctx context.Context, prompt string, columns []string) (*EntityMatch, error) {
+	if len(columns) == 0 {
+		return nil, nil
+	}
+
+	guardCtx, span := t.tracer.StartOpenInferenceSpan(ctx, EntityGuardName, tracing.GuardrailKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	tracing.SetSpanInput(span, prompt)
+	tracing.SetSpanAttr(span, "guardrail.sensitive_columns", columns)
+
+	match, err := t.findSensitiveEntity(guardCtx, prompt, columns)
+	if err != nil {
+		tracing.SetSpanErrorCode(span)
+		return nil, err
+	}
+
+	if match == nil {
+		tracing.SetSpanAttr(span, "guardrail.refused", false)
+		tracing.SetSpanSuccessCode(span)
+		return nil, nil
+	}
+
+	log.Printf("WARNING: Refusing question filtering on sensitive column '%s'\n", match.Column)
+	t.audit.write(AuditEntry{
+		RunID:  t.RunID,
+		Tool:   EntityGuardName,
+		Source: AuditSourceGuardrail,
+		Error:  fmt.Sprintf("refused: question filters on sensitive column '%s' (%s)", match.Column, match.Source),
+		User:   completion.EndUserFrom(ctx),
+	})
+
+	tracing.SetSpanAttrFromMap(span, map[string]any{
+		"guardrail.refused":      true,
+		"guardrail.column":       match.Column,
+		"guardrail.match_source": match.Source,
+	})
+	tracing.SetSpanOutput(span, match.Column)
+	tracing.SetSpanRefusedCode(span)
+	return match, nil
+}
@@ -0,0 +1,151 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestColumnMentionPattern(t *testing.T) {
+	cases := []struct {
+		column string
+		prompt string
+		want   string // Empty when nothing should match
+	}{
+		{column: "Customer_ID", prompt: "What did customer 42 buy?", want: "42"},
+		{column: "Customer_ID", prompt: "Sales where customer id = C-1042", want: "C-1042"},
+		{column: "Customer_ID", prompt: "Orders of customer_id: 'abc 1'", want: "'abc 1'"},
+		{column: "Employee_Name", prompt: "Sales by the employee named Ana Lopez", want: "Ana Lopez"},
+		{column: "Store_Number", prompt: "How much did store #110 sell?", want: "110"},
+		{column: "Store_Number", prompt: "store number is 220", want: "220"},
+		{column: "Customer_ID", prompt: "Total sales per customer"},
+		{column: "Customer_ID", prompt: "How many customers bought 3 items?"},
+		{column: "Store_Number", prompt: "Which store sold the most?"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.column+"/"+c.prompt, func(t *testing.T) {
+			match := columnMentionPattern(c.column).FindStringSubmatch(c.prompt)
+			switch {
+			case c.want == "" && match != nil:
+				t.Fatalf("expected no mention, matched '%s'", match[1])
+			case c.want != "" && match == nil:
+				t.Fatalf("expected '%s' to be matched", c.want)
+			case c.want != "" && match[1] != c.want:
+				t.Fatalf("expected '%s', matched '%s'", c.want, match[1])
+			}
+		})
+	}
+}
+
+func TestResolveSensitiveColumns(t *testing.T) {
+	store, err := openFixtureStore(t, StorageMemory, "sales.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolved, err := ResolveSensitiveColumns(context.Background(), store, []string{"store_number", " ", "Customer_ID", "STORE_NUMBER"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Store_Number"}; !reflect.DeepEqual(resolved, want) {
+		t.Fatalf("expected %v, got %v", want, resolved)
+	}
+}
+
+func TestGuardSensitiveEntities(t *testing.T) {
+	cases := []struct {
+		name   string
+		prompt string
+		want   *EntityMatch
+	}{
+		{
+			name:   "column mention",
+			prompt: "How much did store 110 sell?",
+			want:   &EntityMatch{Column: "Store_Number", Value: "110", Source: "mention"},
+		},
+		{
+			name:   "quoted value",
+			prompt: `What were the sales for "220"?`,
+			want:   &EntityMatch{Column: "Store_Number", Value: "220", Source: "value"},
+		},
+		{name: "quoted value not in the column", prompt: `What were the sales for "999"?`},
+		{name: "aggregate question", prompt: "Which store sold the most?"},
+	}
+
+	for _, backend := range storageBackends {
+		for _, c := range cases {
+			t.Run(backend+"/"+c.name, func(t *testing.T) {
+				store, err := openFixtureStore(t, backend, "sales.parquet")
+				if err != nil {
+					t.Fatal(err)
+				}
+				tracer, recorder := tracing.NewRecordingTracer()
+				auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+				toolbox := NewToolbox(Config{}, nil, store, tracer, NewAuditLog(auditPath, true))
+
+				match, err := toolbox.GuardSensitiveEntities(context.Background(), c.prompt, []string{"Store_Number"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(match, c.want) {
+					t.Fatalf("expected %+v, got %+v", c.want, match)
+				}
+
+				spans := recorder.Ended()
+				if len(spans) != 1 || spans[0].Name() != EntityGuardName {
+					t.Fatalf("expected one %s span, got %d", EntityGuardName, len(spans))
+				}
+				refused := attribute.Key("guardrail.refused").Bool(c.want != nil)
+				if !hasAttribute(spans[0].Attributes(), refused) {
+					t.Fatalf("expected span attribute %v, got %v", refused, spans[0].Attributes())
+				}
+
+				auditLog, _ := os.ReadFile(auditPath)
+				if c.want == nil {
+					if len(auditLog) != 0 {
+						t.Fatalf("expected nothing audited, got: %s", auditLog)
+					}
+					return
+				}
+				entry := AuditEntry{}
+				if err := json.Unmarshal(auditLog, &entry); err != nil {
+					t.Fatalf("expected one audit entry, got %s: %s", auditLog, err)
+				}
+				if entry.Tool != EntityGuardName || entry.Source != AuditSourceGuardrail || !strings.Contains(entry.Error, "'Store_Number'") {
+					t.Fatalf("expected a refused guardrail entry, got %+v", entry)
+				}
+			})
+		}
+	}
+}
+
+// Questions can't be refused without sensitive columns, the guard doesn't even start a span
+func TestGuardWithoutSensitiveColumns(t *testing.T) {
+	tracer, recorder := tracing.NewRecordingTracer()
+	toolbox := NewToolbox(Config{}, nil, nil, tracer, nil)
+
+	match, err := toolbox.GuardSensitiveEntities(context.Background(), "How much did store 110 sell?", nil)
+	if err != nil || match != nil {
+		t.Fatalf("expected no refusal, got %+v: %v", match, err)
+	}
+	if spans := recorder.Ended(); len(spans) != 0 {
+		t.Fatalf("expected no spans, got %d", len(spans))
+	}
+}
+
+func hasAttribute(attributes []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, attr := range attributes {
+		if attr == want {
+			return true
+		}
+	}
+	return false
+}
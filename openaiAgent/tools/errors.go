@@ -0,0 +1,206 @@
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+)
+
+/*
+-----------
+Tool errors
+-----------
+*/
+
+// Error classes reported to the router and on spans
+const (
+	ErrorClassSQLGeneration    = "sql_generation"
+	ErrorClassSQLExecution     = "sql_execution"
+	ErrorClassDataTooLarge     = "data_too_large"
+	ErrorClassLLMUnavailable   = "llm_unavailable"
+	ErrorClassInvalidArguments = "invalid_arguments"
+	ErrorClassTimeout          = "timeout"
+	ErrorClassSQLUnavailable   = "sql_unavailable"
+	ErrorClassAccessDenied     = "access_denied"
+	ErrorClassReadOnly         = "read_only"
+	ErrorClassMissingResult    = "missing_result"
+	ErrorClassSQLRejected      = "sql_rejected"
+	ErrorClassClarification    = "clarification_needed"
+	ErrorClassSchemaValidation = "schema_validation"
+	ErrorClassUnknown          = "unknown"
+)
+
+// Error returned by the tools. The class tells callers what failed,
+// and IsRetryable whether calling the tool again with other input may succeed
+type ToolError interface {
+	error
+	Class() string
+	IsRetryable() bool
+}
+
+// The model didn't produce a usable SQL query
+type ErrSQLGeneration struct {
+	Reason string
+}
+
+func (e *ErrSQLGeneration) Error() string {
+	return fmt.Sprintf("failed to generate SQL query: %s", e.Reason)
+}
+func (e *ErrSQLGeneration) Class() string             { return ErrorClassSQLGeneration }
+func (e *ErrSQLGeneration) IsRetryable() bool         { return true }
+func (e *ErrSQLGeneration) ExitClass() exitcode.Class { return exitcode.ClassData }
+
+// The database rejected or failed a query
+type ErrSQLExecution struct {
+	Query string
+	DBErr error
+}
+
+func (e *ErrSQLExecution) Error() string {
+	return fmt.Sprintf("failed to run query: %s", e.DBErr)
+}
+func (e *ErrSQLExecution) Unwrap() error             { return e.DBErr }
+func (e *ErrSQLExecution) Class() string             { return ErrorClassSQLExecution }
+func (e *ErrSQLExecution) IsRetryable() bool         { return true }
+func (e *ErrSQLExecution) ExitClass() exitcode.Class { return exitcode.ClassData }
+
+// A result is too large to hand to the model. A narrower or aggregated request may fit
+type ErrDataTooLarge struct {
+	Rows     int
+	Bytes    int
+	MaxBytes int
+}
+
+func (e *ErrDataTooLarge) Error() string {
+	return fmt.Sprintf("result of %d rows is %d bytes, over the %d bytes limit. Aggregate or filter the data", e.Rows, e.Bytes, e.MaxBytes)
+}
+func (e *ErrDataTooLarge) Class() string             { return ErrorClassDataTooLarge }
+func (e *ErrDataTooLarge) IsRetryable() bool         { return true }
+func (e *ErrDataTooLarge) ExitClass() exitcode.Class { return exitcode.ClassData }
+
+// A chat completion failed. The client already retries transient failures, so it's final
+type ErrLLMUnavailable struct {
+	Err error
+}
+
+func (e *ErrLLMUnavailable) Error() string {
+	return fmt.Sprintf("chat completion failed: %s", e.Err)
+}
+func (e *ErrLLMUnavailable) Unwrap() error             { return e.Err }
+func (e *ErrLLMUnavailable) Class() string             { return ErrorClassLLMUnavailable }
+func (e *ErrLLMUnavailable) IsRetryable() bool         { return false }
+func (e *ErrLLMUnavailable) ExitClass() exitcode.Class { return exitcode.ClassUpstream }
+
+// A tool needs to write to a database opened read-only. Requests without the write may succeed
+type ErrReadOnly struct {
+	Operation string // What needed the write, like "creating temporary tables"
+}
+
+func (e *ErrReadOnly) Error() string {
+	return fmt.Sprintf("%s is disabled in read-only mode, the database connection can't write", e.Operation)
+}
+func (e *ErrReadOnly) Class() string             { return ErrorClassReadOnly }
+func (e *ErrReadOnly) IsRetryable() bool         { return true }
+func (e *ErrReadOnly) ExitClass() exitcode.Class { return exitcode.ClassData }
+
+// A tool call got no result, like one dropped by a bug before the next router call. Calling it again may succeed
+type ErrMissingToolResult struct {
+	ToolCallID string
+}
+
+func (e *ErrMissingToolResult) Error() string {
+	return fmt.Sprintf("tool call '%s' got no result, call it again if it's still needed", e.ToolCallID)
+}
+func (e *ErrMissingToolResult) Class() string             { return ErrorClassMissingResult }
+func (e *ErrMissingToolResult) IsRetryable() bool         { return true }
+func (e *ErrMissingToolResult) ExitClass() exitcode.Class { return exitcode.ClassData }
+
+// A tool was called with missing or malformed arguments
+type ErrInvalidArguments struct {
+	Tool   string
+	Reason string
+}
+
+func (e *ErrInvalidArguments) Error() string {
+	return fmt.Sprintf("invalid arguments for '%s': %s", e.Tool, e.Reason)
+}
+func (e *ErrInvalidArguments) Class() string             { return ErrorClassInvalidArguments }
+func (e *ErrInvalidArguments) IsRetryable() bool         { return true }
+func (e *ErrInvalidArguments) ExitClass() exitcode.Class { return exitcode.ClassData }
+
+// A tool ran over its timeout. A simpler request may finish in time
+type ErrToolTimeout struct {
+	Tool    string
+	Timeout time.Duration
+}
+
+func (e *ErrToolTimeout) Error() string {
+	return fmt.Sprintf("'%s' was cancelled after %s", e.Tool, e.Timeout)
+}
+func (e *ErrToolTimeout) Class() string             { return ErrorClassTimeout }
+func (e *ErrToolTimeout) IsRetryable() bool         { return true }
+func (e *ErrToolTimeout) ExitClass() exitcode.Class { return exitcode.ClassData }
+
+// The storage backend can't run SQL, like the memory backend. No request will succeed on it
+type ErrSQLBackendUnavailable struct {
+	Backend string
+}
+
+func (e *ErrSQLBackendUnavailable) Error() string {
+	return fmt.Sprintf("SQL backend unavailable: the %s storage backend can't run SQL queries", e.Backend)
+}
+func (e *ErrSQLBackendUnavailable) Class() string             { return ErrorClassSQLUnavailable }
+func (e *ErrSQLBackendUnavailable) IsRetryable() bool         { return false }
+func (e *ErrSQLBackendUnavailable) ExitClass() exitcode.Class { return exitcode.ClassData }
+
+// The role of the run may not query a dataset. No request on it will succeed
+type ErrAccessDenied struct {
+	Tool    string
+	Dataset string
+	Reason  string
+}
+
+func (e *ErrAccessDenied) Error() string {
+	return fmt.Sprintf("access denied to dataset '%s': %s", e.Dataset, e.Reason)
+}
+func (e *ErrAccessDenied) Class() string             { return ErrorClassAccessDenied }
+func (e *ErrAccessDenied) IsRetryable() bool         { return false }
+func (e *ErrAccessDenied) ExitClass() exitcode.Class { return exitcode.ClassData }
+
+// Get the class of a tool error, looking through wrapped errors
+func ErrorClass(err error) string {
+	var toolError ToolError
+	if errors.As(err, &toolError) {
+		return toolError.Class()
+	}
+
+	return ErrorClassUnknown
+}
+
+// Whether a tool error may succeed on a new call. Unclassified errors are retryable
+func IsRetryable(err error) bool {
+	var toolError ToolError
+	if errors.As(err, &toolError) {
+		return toolError.IsRetryable()
+	}
+
+	return true
+}
+
+// Model facing JSON description of a tool error, sent as the tool message
+func FormatToolError(err error) string {
+	jsonBytes, marshalErr := json.Marshal(map[string]any{
+		"error":     err.Error(),
+		"class":     ErrorClass(err),
+		"retryable": IsRetryable(err),
+	})
+
+	if marshalErr != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+
+	return string(jsonBytes)
+}
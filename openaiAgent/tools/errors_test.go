@@ -0,0 +1,66 @@
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+)
+
+// Each tool error reports its class, whether calling the tool again may succeed and the exit class of a run
+// failing on it, also through wrapping
+func TestToolErrors(t *testing.T) {
+	cases := []struct {
+		name      string
+		err       error
+		class     string
+		retryable bool
+		exitClass exitcode.Class
+	}{
+		{name: "sql generation", err: &ErrSQLGeneration{Reason: "no query"}, class: ErrorClassSQLGeneration, retryable: true, exitClass: exitcode.ClassData},
+		{name: "sql execution", err: &ErrSQLExecution{Query: "SELECT x", DBErr: errors.New("no column x")}, class: ErrorClassSQLExecution, retryable: true, exitClass: exitcode.ClassData},
+		{name: "data too large", err: &ErrDataTooLarge{Rows: 10, Bytes: 2048, MaxBytes: 1024}, class: ErrorClassDataTooLarge, retryable: true, exitClass: exitcode.ClassData},
+		{name: "llm unavailable", err: &ErrLLMUnavailable{Err: errors.New("connection reset")}, class: ErrorClassLLMUnavailable, exitClass: exitcode.ClassUpstream},
+		{name: "read only", err: &ErrReadOnly{Operation: "creating temporary tables"}, class: ErrorClassReadOnly, retryable: true, exitClass: exitcode.ClassData},
+		{name: "missing tool result", err: &ErrMissingToolResult{ToolCallID: "call_1"}, class: ErrorClassMissingResult, retryable: true, exitClass: exitcode.ClassData},
+		{name: "invalid arguments", err: &ErrInvalidArguments{Tool: LookUpFuncName, Reason: "no prompt"}, class: ErrorClassInvalidArguments, retryable: true, exitClass: exitcode.ClassData},
+		{name: "tool timeout", err: &ErrToolTimeout{Tool: LookUpFuncName}, class: ErrorClassTimeout, retryable: true, exitClass: exitcode.ClassData},
+		{name: "sql backend unavailable", err: &ErrSQLBackendUnavailable{Backend: StorageMemory}, class: ErrorClassSQLUnavailable, exitClass: exitcode.ClassData},
+		{name: "access denied", err: &ErrAccessDenied{Tool: LookUpFuncName, Dataset: "sales", Reason: "role viewer"}, class: ErrorClassAccessDenied, exitClass: exitcode.ClassData},
+		{name: "ambiguous date", err: &ErrAmbiguousDate{Text: "03/04/2021"}, class: ErrorClassClarification, exitClass: exitcode.ClassData},
+		{name: "schema validation", err: &ErrSchemaValidation{Schema: "chart"}, class: ErrorClassSchemaValidation, retryable: true, exitClass: exitcode.ClassUpstream},
+		{name: "sql rejected", err: &ErrSQLRejected{Query: "SELECT 1"}, class: ErrorClassSQLRejected, retryable: true, exitClass: exitcode.ClassData},
+		{name: "wrapped", err: fmt.Errorf("tool 'lookup' failed: %w", &ErrAccessDenied{Dataset: "sales"}), class: ErrorClassAccessDenied, exitClass: exitcode.ClassData},
+		// Errors the tools don't classify are worth another call, and fail a run as generic errors
+		{name: "unclassified", err: errors.New("something broke"), class: ErrorClassUnknown, retryable: true, exitClass: exitcode.ClassError},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if class := ErrorClass(c.err); class != c.class {
+				t.Errorf("classified as %s, expected %s", class, c.class)
+			}
+			if retryable := IsRetryable(c.err); retryable != c.retryable {
+				t.Errorf("retryable is %t, expected %t", retryable, c.retryable)
+			}
+			if exitClass := exitcode.Classify(c.err); exitClass != c.exitClass {
+				t.Errorf("exits as %s, expected %s", exitClass, c.exitClass)
+			}
+
+			// The router reads the same class and retryability from the tool message
+			formatted := struct {
+				Error     string `json:"error"`
+				Class     string `json:"class"`
+				Retryable bool   `json:"retryable"`
+			}{}
+			if err := json.Unmarshal([]byte(FormatToolError(c.err)), &formatted); err != nil {
+				t.Fatal(err)
+			}
+			if formatted.Error != c.err.Error() || formatted.Class != c.class || formatted.Retryable != c.retryable {
+				t.Errorf("formatted as %+v", formatted)
+			}
+		})
+	}
+}
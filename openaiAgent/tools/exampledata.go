@@ -0,0 +1,151 @@
+package tools
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+/*
+---------------
+Example dataset
+---------------
+*/
+
+// Rows and seed of the example dataset unless the quickstart names others
+const (
+	DefaultExampleRows = 5000
+	DefaultExampleSeed = 42
+)
+
+// Stores, products and days the example sales are spread over
+const (
+	exampleSKUs      = 60
+	exampleClasses   = 6
+	exampleDays      = 182
+	examplePromoRate = 0.2  // Share of the sales made on promotion
+	examplePromoCut  = 0.25 // Price cut of the products on promotion
+)
+
+var exampleStores = []int{110, 220, 330, 440, 550, 660, 770, 880}
+
+// First day of the example sales, the half year after it is covered
+var exampleStart = time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Glossary terms of the example dataset, besides the dataset ones
+var ExampleGlossary = []GlossaryTerm{
+	{Term: "store", Column: "Store_Number"},
+	{Term: "product class", Column: "Product_Class_Code"},
+	{Term: "promotion", Column: "On_Promo"},
+}
+
+// Description of the example dataset, for the model
+const ExampleDescription = "Synthetic sales of 8 stores and 60 products over the first half of 2023, one row per sale. " +
+	"Products on promotion sell with a 25% price cut."
+
+// Synthetic sales with the columns of the sales dataset. Generated rows only depend on the seed,
+// so documentation and checks can rely on exact figures
+type ExampleDataset struct {
+	Rows int
+	Seed int64
+}
+
+// Example product, the price is the regular one
+type exampleProduct struct {
+	SKU   int
+	Class int
+	Price float64
+}
+
+// Write the dataset as a parquet file at dataPath, through a CSV of the rows loaded and copied by DuckDB.
+// The column types are the ones of the Store_Sales parquet file
+func (d ExampleDataset) Write(dataPath string) error {
+	if d.Rows <= 0 {
+		return fmt.Errorf("invalid example dataset rows %d, expected a positive amount", d.Rows)
+	}
+	if err := os.MkdirAll(filepath.Dir(dataPath), 0o755); err != nil {
+		return err
+	}
+
+	csvPath := dataPath + ".csv"
+	if err := d.writeCSV(csvPath); err != nil {
+		return err
+	}
+	defer os.Remove(csvPath)
+
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(fmt.Sprintf(`COPY (
+		SELECT * FROM read_csv('%s', header = true, columns = {
+			'Store_Number': 'SMALLINT', 'SKU_Coded': 'INTEGER', 'Product_Class_Code': 'SMALLINT', 'Sold_Date': 'DATE',
+			'Qty_Sold': 'SMALLINT', 'Total_Sale_Value': 'FLOAT', 'On_Promo': 'TINYINT'
+		})
+	) TO '%s' (FORMAT parquet)`, csvPath, dataPath))
+	if err != nil {
+		return fmt.Errorf("failed to write example dataset: %w", err)
+	}
+
+	return nil
+}
+
+// Generate the rows in order from the seed, prices rounded to cents
+func (d ExampleDataset) writeCSV(csvPath string) error {
+	csvFile, err := os.Create(csvPath)
+	if err != nil {
+		return err
+	}
+	defer csvFile.Close()
+
+	random := rand.New(rand.NewPCG(uint64(d.Seed), uint64(d.Seed)))
+	products := []exampleProduct{}
+	for i := range exampleSKUs {
+		products = append(products, exampleProduct{
+			SKU:   100001 + i,
+			Class: 20 + random.IntN(exampleClasses),
+			Price: roundCents(2 + random.Float64()*48),
+		})
+	}
+
+	writer := csv.NewWriter(csvFile)
+	writer.Write([]string{"Store_Number", "SKU_Coded", "Product_Class_Code", "Sold_Date", "Qty_Sold", "Total_Sale_Value", "On_Promo"})
+	for range d.Rows {
+		store := exampleStores[random.IntN(len(exampleStores))]
+		product := products[random.IntN(len(products))]
+		day := exampleStart.AddDate(0, 0, random.IntN(exampleDays))
+		quantity := 1 + random.IntN(6)
+		promo, price := 0, product.Price
+		if random.Float64() < examplePromoRate {
+			promo, price = 1, roundCents(price*(1-examplePromoCut))
+		}
+
+		writer.Write([]string{
+			strconv.Itoa(store),
+			strconv.Itoa(product.SKU),
+			strconv.Itoa(product.Class),
+			day.Format(time.DateOnly),
+			strconv.Itoa(quantity),
+			strconv.FormatFloat(roundCents(price*float64(quantity)), 'f', 2, 64),
+			strconv.Itoa(promo),
+		})
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return csvFile.Close()
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
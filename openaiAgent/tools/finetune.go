@@ -0,0 +1,286 @@
+package tools
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+--------------------
+Fine tuning captures
+--------------------
+*/
+
+// Internal steps whose completions can be captured
+const FineTuneStepSQLGen = "sqlgen"
+
+var fineTuneSteps = []string{FineTuneStepSQLGen}
+
+// Capture file under the output directory, used by the export unless another is given
+const DefaultFineTuneFile = "finetune.jsonl"
+
+// Records waiting to be written before new ones are dropped, so capturing never blocks a tool
+const fineTuneBuffer = 256
+
+// System message of captured SQL generations. A model fine tuned on them must be prompted with it too
+const sqlGenSystemPrompt = "You write DuckDB SQL queries answering requests about the Store Sales Price Elasticity Promotions dataset." +
+	" Answer with the query only."
+
+// Personal data redacted from captured messages
+var (
+	piiEmailRegex = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	piiPhoneRegex = regexp.MustCompile(`\+?\d{1,3}[\s.-]\(?\d{3}\)?[\s.-]\d{3}[\s.-]\d{4}\b`)
+)
+
+// Message in the OpenAI fine tuning chat format
+type FineTuneMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Captured completion of an internal step, with how its output fared
+type FineTuneRecord struct {
+	TimeStamp string            `json:"timeStamp"`
+	Step      string            `json:"step"` // One of the FineTuneStep* constants
+	RunID     string            `json:"runId"`
+	Model     string            `json:"model"`
+	Succeeded bool              `json:"succeeded"` // Whether the output worked, like the SQL query executing without errors
+	Messages  []FineTuneMessage `json:"messages"`  // System, user and assistant messages, with personal data redacted
+}
+
+// Opt in capture of internal completions to a JSONL file, for fine tuning datasets. Records are sampled, redacted
+// and written by a background writer through a buffered channel, so capturing adds no file I/O to the tools.
+// A nil capture records nothing. Close flushes the pending records
+type FineTuneCapture struct {
+	Path       string
+	SampleRate float64 // Share of the completions captured, in (0, 1]
+
+	records chan FineTuneRecord
+	done    chan struct{}
+	dropped atomic.Int64
+
+	// Guards sends against Close
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// Start capturing to path, appending to it. A zero sample rate captures everything
+func NewFineTuneCapture(path string, sampleRate float64) (*FineTuneCapture, error) {
+	if sampleRate == 0 {
+		sampleRate = 1
+	}
+	if sampleRate < 0 || sampleRate > 1 {
+		return nil, fmt.Errorf("invalid fine tuning sample rate %g, expected a share in (0, 1]", sampleRate)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open fine tuning capture: %w", err)
+	}
+
+	capture := &FineTuneCapture{
+		Path:       path,
+		SampleRate: sampleRate,
+		records:    make(chan FineTuneRecord, fineTuneBuffer),
+		done:       make(chan struct{}),
+	}
+	go capture.writeLoop(file)
+
+	log.Printf("Capturing %g of the SQL generations for fine tuning to %s\n", sampleRate, path)
+	return capture, nil
+}
+
+// Write records as they come, flushing whenever none is pending, until the channel closes
+func (c *FineTuneCapture) writeLoop(file *os.File) {
+	defer close(c.done)
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for record := range c.records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			log.Printf("WARNING: Failed to marshal fine tuning record: %s\n", err)
+			continue
+		}
+		if _, err := writer.Write(append(line, '\n')); err != nil {
+			log.Printf("WARNING: Failed to write fine tuning record: %s\n", err)
+		}
+		if len(c.records) == 0 {
+			if err := writer.Flush(); err != nil {
+				log.Printf("WARNING: Failed to flush fine tuning capture: %s\n", err)
+			}
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		log.Printf("WARNING: Failed to flush fine tuning capture: %s\n", err)
+	}
+}
+
+// Queue a record for writing if it's sampled, redacting its messages. Never blocks: records are dropped
+// when the buffer is full or the capture is closed
+func (c *FineTuneCapture) capture(record FineTuneRecord) {
+	if c == nil || rand.Float64() >= c.SampleRate {
+		return
+	}
+
+	record.TimeStamp = time.Now().Format(time.RFC3339)
+	for i, message := range record.Messages {
+		record.Messages[i].Content = RedactPII(message.Content)
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.closed {
+		return
+	}
+
+	select {
+	case c.records <- record:
+	default:
+		if c.dropped.Add(1) == 1 {
+			log.Println("WARNING: Fine tuning capture buffer is full, dropping records")
+		}
+	}
+}
+
+// Capture a SQL generation: its prompt, the query accepted after linting and validation, and whether it executed
+func (c *FineTuneCapture) CaptureSQLGeneration(runID string, model string, prompt string, query string, succeeded bool) {
+	c.capture(FineTuneRecord{
+		Step:      FineTuneStepSQLGen,
+		RunID:     runID,
+		Model:     model,
+		Succeeded: succeeded,
+		Messages: []FineTuneMessage{
+			{Role: "system", Content: sqlGenSystemPrompt},
+			{Role: "user", Content: prompt},
+			{Role: "assistant", Content: query},
+		},
+	})
+}
+
+// Stop capturing and wait for the pending records to be written
+func (c *FineTuneCapture) Close() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mutex.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.records)
+	}
+	c.mutex.Unlock()
+
+	<-c.done
+	if dropped := c.dropped.Load(); dropped != 0 {
+		log.Printf("WARNING: Dropped %d fine tuning records on a full buffer\n", dropped)
+	}
+	return nil
+}
+
+// Redact email addresses and phone numbers of a text
+func RedactPII(text string) string {
+	text = piiEmailRegex.ReplaceAllString(text, "[email]")
+	return piiPhoneRegex.ReplaceAllString(text, "[phone]")
+}
+
+/*
+-------------------
+Fine tuning exports
+-------------------
+*/
+
+// Records of a capture file exported to a fine tuning dataset, and the ones left out
+type FineTuneExport struct {
+	Read       int // Records of the capture file
+	Exported   int
+	OtherStep  int // Records of other steps
+	TooOld     int // Records captured before the since date
+	Failed     int // Records whose output didn't work
+	Duplicates int // Records with the same messages as an exported one
+}
+
+// Export the succeeded records of a step captured since a date to a JSONL fine tuning dataset, one
+// {"messages": [...]} line per record. Records with the same messages are only exported once
+func ExportFineTune(capturePath string, step string, since time.Time, outputPath string) (FineTuneExport, error) {
+	export := FineTuneExport{}
+	if !slices.Contains(fineTuneSteps, step) {
+		return export, fmt.Errorf("unknown fine tuning step '%s', expected one of %s", step, strings.Join(fineTuneSteps, ", "))
+	}
+
+	captureFile, err := os.Open(capturePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return export, fmt.Errorf("no fine tuning capture at %s, run the agent with -capture-finetune first", capturePath)
+	}
+	if err != nil {
+		return export, err
+	}
+	defer captureFile.Close()
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return export, fmt.Errorf("failed to create fine tuning dataset: %w", err)
+	}
+	defer outputFile.Close()
+	writer := bufio.NewWriter(outputFile)
+
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(captureFile)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		record := FineTuneRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return export, fmt.Errorf("invalid fine tuning record on line %d of %s: %w", line, capturePath, err)
+		}
+		export.Read++
+
+		capturedAt, err := time.Parse(time.RFC3339, record.TimeStamp)
+		switch {
+		case record.Step != step:
+			export.OtherStep++
+			continue
+		case err != nil || capturedAt.Before(since):
+			export.TooOld++
+			continue
+		case !record.Succeeded:
+			export.Failed++
+			continue
+		}
+
+		jsonBytes, err := json.Marshal(map[string][]FineTuneMessage{"messages": record.Messages})
+		if err != nil {
+			return export, err
+		}
+		hash := sha256.Sum256(jsonBytes)
+		key := hex.EncodeToString(hash[:])
+		if seen[key] {
+			export.Duplicates++
+			continue
+		}
+		seen[key] = true
+
+		if _, err := writer.Write(append(jsonBytes, '\n')); err != nil {
+			return export, fmt.Errorf("failed to write fine tuning dataset: %w", err)
+		}
+		export.Exported++
+	}
+	if err := scanner.Err(); err != nil {
+		return export, err
+	}
+
+	return export, writer.Flush()
+}
@@ -0,0 +1,175 @@
+package tools
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+/*
+-------------------------
+Dataset file fingerprints
+-------------------------
+*/
+
+// Reload of a dataset table after its data file changed, see Store.RefreshIfChanged
+type DataChange struct {
+	Reloaded  bool
+	UpdatedAt time.Time // Modification time of the changed data file
+}
+
+// Metadata table holding the fingerprint of the file each dataset table was loaded from
+const fingerprintTableName = "dataset_fingerprints"
+
+// Identity of a local data file. The content hash is only computed when size matches
+// but modification time doesn't, so touched but identical files don't trigger a reload.
+// Sample is the one the table was loaded with, see Sample.String
+type dataFingerprint struct {
+	DataPath string
+	Size     int64
+	ModTime  time.Time
+	Hash     string
+	Sample   string
+}
+
+// Stat the data file at dataPath. The hash is left empty until needed
+func statFingerprint(dataPath string) (dataFingerprint, error) {
+	info, err := os.Stat(dataPath)
+	if err != nil {
+		return dataFingerprint{}, fmt.Errorf("failed to stat data file: %w", err)
+	}
+
+	// DuckDB timestamps have microsecond precision
+	modTime := info.ModTime().UTC().Truncate(time.Microsecond)
+	return dataFingerprint{DataPath: dataPath, Size: info.Size(), ModTime: modTime}, nil
+}
+
+// Compute the SHA-256 hash of the file content
+func hashDataFile(dataPath string) (string, error) {
+	file, err := os.Open(dataPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open data file: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to hash data file: %w", err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Create the fingerprints metadata table if missing
+func createFingerprintTable(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			table_name VARCHAR PRIMARY KEY,
+			data_path VARCHAR,
+			size BIGINT,
+			mod_time TIMESTAMP,
+			hash VARCHAR,
+			sample VARCHAR DEFAULT ''
+		)`,
+		fingerprintTableName,
+	))
+
+	// Databases predating samples only ever loaded whole files
+	if err == nil {
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS sample VARCHAR DEFAULT ''", fingerprintTableName))
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to create fingerprints table: %w", err)
+	}
+
+	return nil
+}
+
+// Read the stored fingerprint for a table. Returns false if there is none
+func loadFingerprint(db *sql.DB, table string) (dataFingerprint, bool, error) {
+	stored := dataFingerprint{}
+	err := db.QueryRow(
+		fmt.Sprintf("SELECT data_path, size, mod_time, hash, coalesce(sample, '') FROM %s WHERE table_name = ?", fingerprintTableName),
+		table,
+	).Scan(&stored.DataPath, &stored.Size, &stored.ModTime, &stored.Hash, &stored.Sample)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return stored, false, nil
+	}
+	if err != nil {
+		return stored, false, fmt.Errorf("failed to read fingerprint for '%s': %w", table, err)
+	}
+
+	stored.ModTime = stored.ModTime.UTC()
+	return stored, true, nil
+}
+
+// Store the fingerprint of the file a table was loaded from
+func saveFingerprint(db *sql.DB, table string, fingerprint dataFingerprint) error {
+	_, err := db.Exec(
+		fmt.Sprintf("INSERT OR REPLACE INTO %s VALUES (?, ?, ?, ?, ?, ?)", fingerprintTableName),
+		table, fingerprint.DataPath, fingerprint.Size, fingerprint.ModTime, fingerprint.Hash, fingerprint.Sample,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to save fingerprint for '%s': %w", table, err)
+	}
+
+	return nil
+}
+
+// Compare the current data file and sample against the stored fingerprint.
+// Returns whether the table must be reloaded and the fingerprint to store afterwards
+func checkFingerprint(db *sql.DB, dataPath string, sample Sample) (bool, dataFingerprint, error) {
+	current, err := statFingerprint(dataPath)
+	if err != nil {
+		return false, current, err
+	}
+	current.Sample = sample.String()
+
+	stored, found, err := loadFingerprint(db, tableName)
+	if err != nil {
+		return false, current, err
+	}
+
+	switch {
+	case !found:
+		log.Printf("No fingerprint stored for dataset '%s'\n", tableName)
+	case stored.DataPath != current.DataPath:
+		log.Printf("Dataset '%s' data path changed from %s\n", tableName, stored.DataPath)
+	case stored.Sample != current.Sample:
+		// Sampled tables must never answer for the whole data, nor the other way around
+		log.Printf("Dataset '%s' sample changed from '%s' to '%s'\n", tableName, stored.Sample, current.Sample)
+	case stored.Size != current.Size:
+		log.Printf("Dataset '%s' data file size changed\n", tableName)
+	case stored.ModTime.Equal(current.ModTime):
+		return false, stored, nil
+	default:
+		// Same size but touched, only the content tells if it changed
+		current.Hash, err = hashDataFile(dataPath)
+		if err != nil {
+			return false, current, err
+		}
+
+		if current.Hash == stored.Hash {
+			log.Printf("Dataset '%s' data file was touched but is unchanged\n", tableName)
+			return false, current, saveFingerprint(db, tableName, current)
+		}
+
+		log.Printf("Dataset '%s' data file content changed\n", tableName)
+		return true, current, nil
+	}
+
+	if current.Hash, err = hashDataFile(dataPath); err != nil {
+		return false, current, err
+	}
+
+	return true, current, nil
+}
@@ -0,0 +1,140 @@
+package tools
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Open an in-memory DuckDB database with the fingerprints table
+func openFingerprintDB(t *testing.T) *sql.DB {
+	t.Helper()
+	if err := duckDBAvailable(); err != nil {
+		t.Skipf("DuckDB can't initialize: %s", err)
+	}
+
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := createFingerprintTable(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// Copy the sales fixture to a temp dir, so tests can touch and change it
+func copyDatasetFixture(t *testing.T) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(datasetFixtures, "sales.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataPath := filepath.Join(t.TempDir(), "sales.parquet")
+	if err := os.WriteFile(dataPath, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dataPath
+}
+
+// Store the fingerprint of the file as loaded, like a bootstrap does
+func storeFingerprint(t *testing.T, db *sql.DB, dataPath string, sample Sample) {
+	t.Helper()
+	reload, fingerprint, err := checkFingerprint(db, dataPath, sample)
+	if err != nil || !reload {
+		t.Fatalf("expected a first check to reload, got %t: %v", reload, err)
+	}
+	if err := saveFingerprint(db, tableName, fingerprint); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckFingerprint(t *testing.T) {
+	later := time.Now().Add(time.Hour)
+	cases := []struct {
+		name   string
+		change func(t *testing.T, dataPath string)
+		sample Sample
+		reload bool
+	}{
+		{name: "unchanged", change: func(t *testing.T, dataPath string) {}},
+		{
+			name: "touched but identical",
+			change: func(t *testing.T, dataPath string) {
+				if err := os.Chtimes(dataPath, later, later); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "changed with the same size",
+			change: func(t *testing.T, dataPath string) {
+				content, _ := os.ReadFile(dataPath)
+				content[len(content)/2] ^= 0xff
+				os.WriteFile(dataPath, content, 0o644)
+				os.Chtimes(dataPath, later, later)
+			},
+			reload: true,
+		},
+		{
+			name: "changed size",
+			change: func(t *testing.T, dataPath string) {
+				content, _ := os.ReadFile(dataPath)
+				os.WriteFile(dataPath, append(content, 0), 0o644)
+			},
+			reload: true,
+		},
+		{name: "other sample", change: func(t *testing.T, dataPath string) {}, sample: Sample{Rows: 2}, reload: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db := openFingerprintDB(t)
+			dataPath := copyDatasetFixture(t)
+			storeFingerprint(t, db, dataPath, Sample{})
+
+			c.change(t, dataPath)
+			reload, _, err := checkFingerprint(db, dataPath, c.sample)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reload != c.reload {
+				t.Fatalf("expected reload %t, got %t", c.reload, reload)
+			}
+		})
+	}
+}
+
+// Touched files are hashed once: the new modification time is stored, so the next check doesn't hash again
+func TestTouchedFingerprintIsStored(t *testing.T) {
+	db := openFingerprintDB(t)
+	dataPath := copyDatasetFixture(t)
+	storeFingerprint(t, db, dataPath, Sample{})
+
+	later := time.Now().Add(time.Hour).UTC().Truncate(time.Microsecond)
+	if err := os.Chtimes(dataPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if reload, _, err := checkFingerprint(db, dataPath, Sample{}); err != nil || reload {
+		t.Fatalf("expected a touched file not to reload, got %t: %v", reload, err)
+	}
+
+	stored, found, err := loadFingerprint(db, tableName)
+	if err != nil || !found {
+		t.Fatalf("expected a stored fingerprint: %v", err)
+	}
+	if !stored.ModTime.Equal(later) || stored.Hash == "" {
+		t.Fatalf("expected the touched modification time and a hash stored, got %s and '%s'", stored.ModTime, stored.Hash)
+	}
+}
+
+func TestCheckFingerprintMissingFile(t *testing.T) {
+	db := openFingerprintDB(t)
+	if _, _, err := checkFingerprint(db, filepath.Join(t.TempDir(), "missing.parquet"), Sample{}); err == nil {
+		t.Fatal("expected a missing data file to fail")
+	}
+}
@@ -0,0 +1,123 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/language"
+	"github.com/EzequielGhR/goProjects/openaiAgent/prompts"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+)
+
+/*
+----------------------------
+Follow up question suggester
+----------------------------
+*/
+
+type followUpSuggestions struct {
+	FollowUps []string `json:"followUps" jsonschema_description:"Two or three short follow up questions"`
+}
+
+var followUpSchema = lazyStrictSchema[followUpSuggestions]()
+
+// Record a dataset and its result columns as touched by the run
+func (t *Toolbox) recordTouchedDataset(name string, columns []string) {
+	t.touchedDatasets[name] = columns
+}
+
+// Suggest follow up questions for an answer with one structured output call.
+// The span is a child of the span carried by parentCtx
+func (t *Toolbox) SuggestFollowUps(parentCtx context.Context, question string, answer string) ([]string, error) {
+	datasets := []string{}
+	for name, columns := range t.touchedDatasets {
+		datasets = append(datasets, fmt.Sprintf("- %s: %s", name, strings.Join(columns, ", ")))
+	}
+	if len(datasets) == 0 {
+		datasets = append(datasets, fmt.Sprintf("- %s", tableName))
+	}
+
+	formattedPrompt := prompts.RenderFollowUp(prompts.FollowUpRequest{Question: question, Answer: answer, Datasets: datasets})
+	if instruction := language.Instruction(t.config.Language); instruction != "" {
+		formattedPrompt += instruction + "\n"
+	}
+
+	ctx, span := t.tracer.StartOpenInferenceSpan(parentCtx, "FollowUpSuggestions", tracing.ChainKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	tracing.SetSpanInput(span, formattedPrompt)
+
+	// Start OpenAI manual tracing
+	llmCtx, llmSpan := t.tracer.StartOpenAISpan(ctx, t.config.Model)
+	defer llmSpan.End()
+
+	inputMessage := openai.F([]openai.ChatCompletionMessageParamUnion{
+		openai.UserMessage(formattedPrompt),
+	})
+
+	responseFormat := openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
+		openai.ResponseFormatJSONSchemaParam{
+			Type: openai.F(openai.ResponseFormatJSONSchemaTypeJSONSchema),
+			JSONSchema: openai.F(openai.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:        openai.F("followUpSuggestions"),
+				Description: openai.F("Suggested follow up questions"),
+				Schema:      openai.F(followUpSchema()),
+				Strict:      openai.Bool(true),
+			}),
+		},
+	)
+
+	// Add input attributes to llm span
+	tracing.SetSpanAttrFromMap(llmSpan, map[string]any{
+		"llm.response_format": responseFormat.String(),
+	})
+	tracing.SetSpanInputMessages(llmSpan, inputMessage.Value)
+
+	response, err := t.completer.New(
+		llmCtx,
+		openai.ChatCompletionNewParams{
+			Model:          openai.F(t.config.Model),
+			Messages:       inputMessage,
+			ResponseFormat: responseFormat,
+		},
+	)
+
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		tracing.SetSpanErrorCode(span)
+		return nil, err
+	}
+
+	responseMessage, err := completion.Message(response)
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		tracing.SetSpanErrorCode(span)
+		return nil, err
+	}
+
+	// Set llm span output attributes
+	tracing.SetSpanAttrFromMap(llmSpan, map[string]any{
+		"llm.token_count.prompt":     response.Usage.PromptTokens,
+		"llm.token_count.completion": response.Usage.CompletionTokens,
+		"llm.token_count.total":      response.Usage.TotalTokens,
+		"llm.tools":                  []string{},
+	})
+	tracing.SetSpanOutputMessages(llmSpan, []openai.ChatCompletionMessage{responseMessage})
+
+	suggestions := followUpSuggestions{}
+	err = json.Unmarshal([]byte(cleanLlmBlockResponse(responseMessage.Content)), &suggestions)
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		tracing.SetSpanErrorCode(span)
+		return nil, err
+	}
+
+	tracing.SetSpanSuccessCode(llmSpan)
+	tracing.SetSpanOutput(span, suggestions.FollowUps)
+	tracing.SetSpanSuccessCode(span)
+	return suggestions.FollowUps, nil
+}
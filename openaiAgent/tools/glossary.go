@@ -0,0 +1,140 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+/*
+-----------------
+Business glossary
+-----------------
+*/
+
+// Business term users say for a column, like "revenue" for Total_Sale_Value
+type GlossaryTerm struct {
+	Term        string // Word or phrase, matched case insensitively on whole words
+	Column      string // Column of the dataset table it names
+	Description string // Optional meaning of the term, shown to the model
+}
+
+// Glossary of a rewrite pass, with its terms matched longest first
+type Glossary struct {
+	terms   []GlossaryTerm
+	matcher *regexp.Regexp
+	columns map[string]string // Column of each lowercased term
+}
+
+// Glossary of the dataset table, used unless the data is a live database with its own tables
+func DatasetGlossary() []GlossaryTerm {
+	return datasetSchemas[tableName].Glossary
+}
+
+// Parse a comma separated glossary, like 'turnover=Total_Sale_Value,units=Qty_Sold'
+func ParseGlossary(glossary string) ([]GlossaryTerm, error) {
+	terms := []GlossaryTerm{}
+	for entry := range strings.SplitSeq(glossary, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		term, column, found := strings.Cut(entry, "=")
+		term, column = strings.TrimSpace(term), strings.TrimSpace(column)
+		if !found || term == "" || column == "" {
+			return nil, fmt.Errorf("invalid glossary term '%s', expected term=column", entry)
+		}
+		terms = append(terms, GlossaryTerm{Term: term, Column: column})
+	}
+
+	return terms, nil
+}
+
+// Check every term names a column of the dataset table, fixing the case of their column names.
+// Terms mapped to two different columns are ambiguous and fail too
+func NewGlossary(ctx context.Context, store Store, terms []GlossaryTerm) (*Glossary, error) {
+	tableColumns, err := store.Columns(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch columns for the glossary: %w", err)
+	}
+
+	glossary := &Glossary{terms: []GlossaryTerm{}, columns: map[string]string{}}
+	for _, term := range terms {
+		term.Term = strings.Join(strings.Fields(term.Term), " ")
+		index := slices.IndexFunc(tableColumns, func(column string) bool { return strings.EqualFold(column, term.Column) })
+		if index < 0 {
+			return nil, fmt.Errorf("glossary term '%s' maps to column '%s', which dataset '%s' doesn't have", term.Term, term.Column, tableName)
+		}
+		term.Column = tableColumns[index]
+
+		key := strings.ToLower(term.Term)
+		if column, ok := glossary.columns[key]; ok {
+			if column != term.Column {
+				return nil, fmt.Errorf("glossary term '%s' maps to both '%s' and '%s'", term.Term, column, term.Column)
+			}
+			continue
+		}
+		glossary.columns[key] = term.Column
+		glossary.terms = append(glossary.terms, term)
+	}
+
+	// Longer terms go first, so "units sold" wins over "units"
+	patterns := []string{}
+	for _, term := range glossary.terms {
+		patterns = append(patterns, strings.ReplaceAll(regexp.QuoteMeta(term.Term), " ", `\s+`))
+	}
+	slices.SortStableFunc(patterns, func(a string, b string) int { return len(b) - len(a) })
+	if len(patterns) > 0 {
+		glossary.matcher = regexp.MustCompile(`(?i)\b(?:` + strings.Join(patterns, "|") + `)\b`)
+	}
+
+	return glossary, nil
+}
+
+// Terms of the glossary, in their configured order
+func (g *Glossary) Terms() []GlossaryTerm {
+	if g == nil {
+		return nil
+	}
+
+	return g.terms
+}
+
+// Replace the glossary terms of a prompt by the columns they name. Terms are matched on whole words
+// only, so "revenue" is rewritten but "revenues" or "revenue_share" aren't, and a match is never rewritten twice.
+// Returns the rewritten prompt and the rewrites made, like "revenue -> Total_Sale_Value"
+func (g *Glossary) Rewrite(prompt string) (string, []string) {
+	if g == nil || g.matcher == nil {
+		return prompt, nil
+	}
+
+	rewrites := []string{}
+	rewritten := g.matcher.ReplaceAllStringFunc(prompt, func(match string) string {
+		column := g.columns[strings.ToLower(strings.Join(strings.Fields(match), " "))]
+		rewrites = append(rewrites, fmt.Sprintf("%s -> %s", match, column))
+		return column
+	})
+
+	return rewritten, rewrites
+}
+
+// Model facing lines of the glossary, "none" when it's empty
+func (g *Glossary) Description() string {
+	if len(g.Terms()) == 0 {
+		return "none"
+	}
+
+	lines := []string{}
+	for _, term := range g.terms {
+		line := fmt.Sprintf("- %s: %s", term.Term, term.Column)
+		if term.Description != "" {
+			line += fmt.Sprintf(" (%s)", term.Description)
+		}
+		lines = append(lines, line)
+	}
+
+	return "\n" + strings.Join(lines, "\n")
+}
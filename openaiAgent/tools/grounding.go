@@ -0,0 +1,112 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+/*
+-----------------
+Grounded analysis
+-----------------
+*/
+
+// Rows of the analyzed data supporting a claim of the analysis.
+// Rows are referenced by the index column prepended to the data, both ends included
+type Citation struct {
+	Claim    string `json:"claim" jsonschema_description:"Claim of the analysis supported by the rows"`
+	FirstRow int    `json:"firstRow" jsonschema_description:"row_index of the first cited row"`
+	LastRow  int    `json:"lastRow" jsonschema_description:"row_index of the last cited row, same as firstRow for a single row"`
+}
+
+// Structured answer of a grounded analysis
+type analysisResult struct {
+	Analysis  string     `json:"analysis" jsonschema_description:"Answer to the question"`
+	Citations []Citation `json:"citations" jsonschema_description:"Rows supporting each claim of the answer"`
+}
+
+// Name of the index column prepended to grounded analysis data
+const rowIndexColumn = "row_index"
+
+var analysisSchema = lazyStrictSchema[analysisResult]()
+
+// Response format of grounded analysis calls
+func analysisResponseFormat() openai.ChatCompletionNewParamsResponseFormatUnion {
+	return openai.ResponseFormatJSONSchemaParam{
+		Type: openai.F(openai.ResponseFormatJSONSchemaTypeJSONSchema),
+		JSONSchema: openai.F(openai.ResponseFormatJSONSchemaJSONSchemaParam{
+			Name:        openai.F("analysisResult"),
+			Description: openai.F("Analysis of the data with the rows supporting it"),
+			Schema:      openai.F(analysisSchema()),
+			Strict:      openai.Bool(true),
+		}),
+	}
+}
+
+// Prepend an index column to tabular data, so rows can be cited.
+// Returns the indexed data and its amount of rows
+func indexRows(data string) (string, int) {
+	parsed := parseTabularData(data)
+	if len(parsed.Columns) == 0 {
+		return data, 0
+	}
+
+	lines := []string{strings.Join(append([]string{rowIndexColumn}, parsed.Columns...), ", ")}
+	for i, row := range parsed.Rows {
+		lines = append(lines, strings.Join(append([]string{strconv.Itoa(i)}, row...), ", "))
+	}
+
+	return strings.Join(lines, "\n"), len(parsed.Rows)
+}
+
+// Keep the citations referencing rows that exist in the data.
+// Returns the valid citations and the amount dropped
+func verifyCitations(citations []Citation, rowCount int) ([]Citation, int) {
+	verified := []Citation{}
+	for _, citation := range citations {
+		if citation.FirstRow < 0 || citation.LastRow < citation.FirstRow || citation.LastRow >= rowCount {
+			continue
+		}
+
+		verified = append(verified, citation)
+	}
+
+	return verified, len(citations) - len(verified)
+}
+
+// Parse a grounded analysis response and verify its citations against the data rows
+func parseGroundedAnalysis(content string, rowCount int) (AnalyzeResult, error) {
+	result := analysisResult{}
+	if err := json.Unmarshal([]byte(content), &result); err != nil {
+		return AnalyzeResult{}, fmt.Errorf("failed to parse grounded analysis: %w", err)
+	}
+
+	citations, dropped := verifyCitations(result.Citations, rowCount)
+	return AnalyzeResult{
+		Analysis:         strings.Trim(result.Analysis, "\n "),
+		Citations:        citations,
+		DroppedCitations: dropped,
+	}, nil
+}
+
+// Format citations for the tool result
+func formatCitations(citations []Citation, dropped int) string {
+	lines := []string{fmt.Sprintf("Citations (%s of the data):", rowIndexColumn)}
+	for _, citation := range citations {
+		rows := fmt.Sprintf("row %d", citation.FirstRow)
+		if citation.LastRow != citation.FirstRow {
+			rows = fmt.Sprintf("rows %d-%d", citation.FirstRow, citation.LastRow)
+		}
+		lines = append(lines, fmt.Sprintf("- %s: %s", rows, citation.Claim))
+	}
+
+	if dropped > 0 {
+		lines = append(lines, fmt.Sprintf("%d citations referencing rows not in the data were dropped", dropped))
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,119 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/prompts"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+)
+
+/*
+----------------
+Answer evaluator
+----------------
+*/
+
+// Answer to a question produced by one candidate, like a model
+type JudgeCandidate struct {
+	Label  string
+	Answer string
+}
+
+// Verdict of the evaluator on a set of candidate answers
+type Judgement struct {
+	Winner    string `json:"winner" jsonschema_description:"Label of the best answer"`
+	Reasoning string `json:"reasoning" jsonschema_description:"Short explanation of the choice"`
+}
+
+var judgementSchema = lazyStrictSchema[Judgement]()
+
+// Judge which candidate answers the question best with one structured output call.
+// The evaluator span is a child of the span carried by parentCtx
+func (t *Toolbox) JudgeAnswers(parentCtx context.Context, question string, candidates []JudgeCandidate) (Judgement, error) {
+	answers := []string{}
+	for _, candidate := range candidates {
+		answers = append(answers, fmt.Sprintf("[%s]\n%s", candidate.Label, candidate.Answer))
+	}
+
+	formattedPrompt := prompts.RenderJudge(prompts.JudgeRequest{Question: question, Answers: answers})
+
+	ctx, span := t.tracer.StartOpenInferenceSpan(parentCtx, "JudgeAnswers", tracing.EvaluatorKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	tracing.SetSpanInput(span, formattedPrompt)
+
+	// Start OpenAI manual tracing
+	llmCtx, llmSpan := t.tracer.StartOpenAISpan(ctx, t.config.Model)
+	defer llmSpan.End()
+
+	inputMessage := openai.F([]openai.ChatCompletionMessageParamUnion{
+		openai.UserMessage(formattedPrompt),
+	})
+
+	responseFormat := openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
+		openai.ResponseFormatJSONSchemaParam{
+			Type: openai.F(openai.ResponseFormatJSONSchemaTypeJSONSchema),
+			JSONSchema: openai.F(openai.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:        openai.F("judgement"),
+				Description: openai.F("The best answer and why"),
+				Schema:      openai.F(judgementSchema()),
+				Strict:      openai.Bool(true),
+			}),
+		},
+	)
+
+	// Add input attributes to llm span
+	tracing.SetSpanAttrFromMap(llmSpan, map[string]any{
+		"llm.response_format": responseFormat.String(),
+	})
+	tracing.SetSpanInputMessages(llmSpan, inputMessage.Value)
+
+	response, err := t.completer.New(
+		llmCtx,
+		openai.ChatCompletionNewParams{
+			Model:          openai.F(t.config.Model),
+			Messages:       inputMessage,
+			ResponseFormat: responseFormat,
+		},
+	)
+
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		tracing.SetSpanErrorCode(span)
+		return Judgement{}, err
+	}
+
+	responseMessage, err := completion.Message(response)
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		tracing.SetSpanErrorCode(span)
+		return Judgement{}, err
+	}
+
+	// Set llm span output attributes
+	tracing.SetSpanAttrFromMap(llmSpan, map[string]any{
+		"llm.token_count.prompt":     response.Usage.PromptTokens,
+		"llm.token_count.completion": response.Usage.CompletionTokens,
+		"llm.token_count.total":      response.Usage.TotalTokens,
+		"llm.tools":                  []string{},
+	})
+	tracing.SetSpanOutputMessages(llmSpan, []openai.ChatCompletionMessage{responseMessage})
+
+	judgement := Judgement{}
+	err = json.Unmarshal([]byte(cleanLlmBlockResponse(responseMessage.Content)), &judgement)
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		tracing.SetSpanErrorCode(span)
+		return Judgement{}, err
+	}
+
+	tracing.SetSpanSuccessCode(llmSpan)
+	tracing.SetSpanOutput(span, judgement.Winner)
+	tracing.SetSpanAttr(span, "eval.explanation", judgement.Reasoning)
+	tracing.SetSpanSuccessCode(span)
+	return judgement, nil
+}
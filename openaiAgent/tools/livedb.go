@@ -0,0 +1,126 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+/*
+----------------------------------
+Live DuckDB and MotherDuck support
+----------------------------------
+*/
+
+// DSN prefixes of MotherDuck databases
+var motherDuckPrefixes = []string{"md:", "motherduck:"}
+
+// File extensions of existing DuckDB databases, used instead of parquet data
+var duckdbFileExtensions = []string{".duckdb", ".ddb"}
+
+// Credential parameters of a DSN, their values are hidden before logging
+var dsnCredentialRegex = regexp.MustCompile(`(?i)((?:token|password|secret)[a-z_]*=)[^&\s'"]*`)
+
+// Check if a data path is a MotherDuck DSN, like md:my_db?motherduck_token=...
+func IsMotherDuckDSN(dataPath string) bool {
+	for _, prefix := range motherDuckPrefixes {
+		if strings.HasPrefix(strings.ToLower(dataPath), prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check if a data path is a live database (MotherDuck DSN or DuckDB file) instead of parquet data.
+// Live databases are queried in place, without the read_parquet bootstrap
+func IsLiveDatabase(dataPath string) bool {
+	if IsMotherDuckDSN(dataPath) {
+		return true
+	}
+
+	for _, extension := range duckdbFileExtensions {
+		if strings.HasSuffix(strings.ToLower(dataPath), extension) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Hide the credentials of a DSN, or of any text holding one, so it can be logged or traced
+func RedactDSN(dsn string) string {
+	return dsnCredentialRegex.ReplaceAllString(dsn, "${1}REDACTED")
+}
+
+// Check that a live database path can be used before opening it
+func validateLiveDatabasePath(dataPath string) error {
+	if IsMotherDuckDSN(dataPath) {
+		// Reachability is checked when connecting, there's nothing to stat
+		return nil
+	}
+
+	if _, err := os.Stat(dataPath); err != nil {
+		return fmt.Errorf("no DuckDB database file found at %s", dataPath)
+	}
+
+	return nil
+}
+
+// Connect to a live database. Connection errors never include the DSN credentials
+func openLiveDatabase(dsn string) (*sql.DB, error) {
+	log.Printf("Connecting to live database %s\n", RedactDSN(dsn))
+	db, err := sql.Open("duckdb", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open live database %s: %s", RedactDSN(dsn), RedactDSN(err.Error()))
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to live database %s: %s", RedactDSN(dsn), RedactDSN(err.Error()))
+	}
+
+	return db, nil
+}
+
+// List the tables of a live database, so the model knows what it can query
+func DiscoverTables(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SHOW TABLES")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list database tables: %s", RedactDSN(err.Error()))
+	}
+	defer rows.Close()
+
+	tables := []string{}
+	for rows.Next() {
+		name := ""
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to read database table name: %w", err)
+		}
+		tables = append(tables, name)
+	}
+
+	return tables, rows.Err()
+}
+
+// Model facing description of the tables of a live database
+func tablesDescription(tables []string) string {
+	if len(tables) == 0 {
+		return "none"
+	}
+
+	return "\n- " + strings.Join(tables, "\n- ")
+}
+
+// Views of the dataset, or the tables of a live database, for the SQL generation prompt
+func (t *Toolbox) schemaDescription() string {
+	if len(t.config.LiveTables) > 0 {
+		return tablesDescription(t.config.LiveTables)
+	}
+
+	return viewsDescription()
+}
@@ -0,0 +1,474 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+/*
+------------
+Memory store
+------------
+*/
+
+// Rows read from the parquet file per batch
+const memoryBatchSize = 64 * 1024
+
+// Column of the memory store. Values live in the slice of the column kind, with nulls marked on valid
+type memoryColumn struct {
+	name     string
+	dataType string      // DuckDB name of the column type, like the DuckDB backend reports it
+	kind     string      // Profile kind of the column
+	valid    []bool      // Whether each row has a value
+	numbers  []float64   // Values of numeric columns
+	dates    []time.Time // Values of date columns
+	texts    []string    // Values of categorical columns
+}
+
+// Store holding the dataset table in memory, read from a local parquet file without DuckDB.
+// It can't run SQL, but covers what the agent needs besides lookups: validation, profiles and entity checks
+type memoryStore struct {
+	columns  []*memoryColumn
+	rowCount int64
+	file     dataFingerprint // Size and modification time of the data file read, without its hash
+	mutex    sync.RWMutex
+}
+
+// Read a local parquet data file into a memory store
+func OpenMemoryStore(dataPath string) (Store, error) {
+	store := &memoryStore{}
+	if err := store.Refresh(dataPath); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (s *memoryStore) Backend() string {
+	return StorageMemory
+}
+
+func (s *memoryStore) SQL() (*sql.DB, error) {
+	return nil, &ErrSQLBackendUnavailable{Backend: StorageMemory}
+}
+
+func (s *memoryStore) RowCount(ctx context.Context) (int64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.rowCount, nil
+}
+
+func (s *memoryStore) Columns(ctx context.Context) ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	columns := []string{}
+	for _, column := range s.columns {
+		columns = append(columns, column.name)
+	}
+
+	return columns, nil
+}
+
+func (s *memoryStore) ContainsValue(ctx context.Context, columnName string, value string) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	column := s.column(columnName)
+	if column == nil {
+		return false, fmt.Errorf("dataset '%s' has no column '%s'", tableName, columnName)
+	}
+
+	for row := range column.valid {
+		if column.valid[row] && strings.EqualFold(column.text(row), value) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Compute the same statistics as the DuckDB backend, in memory
+func (s *memoryStore) Profile(ctx context.Context) (DatasetProfile, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	log.Printf("Profiling dataset '%s' in memory\n", tableName)
+	profile := DatasetProfile{
+		Table:       tableName,
+		Rows:        s.rowCount,
+		Columns:     []ColumnProfile{},
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, column := range s.columns {
+		if err := ctx.Err(); err != nil {
+			return DatasetProfile{}, err
+		}
+		profile.Columns = append(profile.Columns, column.profile(s.rowCount))
+	}
+
+	return profile, nil
+}
+
+func (s *memoryStore) Ping(ctx context.Context) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.columns == nil {
+		return fmt.Errorf("memory store is closed")
+	}
+
+	return nil
+}
+
+// The rows are read from the parquet file, nothing is ever written
+func (s *memoryStore) ReadOnly(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// Read the parquet file at dataPath, replacing the rows held
+func (s *memoryStore) Refresh(dataPath string) error {
+	if IsLiveDatabase(dataPath) || IsRemotePath(dataPath) {
+		return fmt.Errorf("the %s storage backend only reads local parquet files, not %s", StorageMemory, RedactDSN(dataPath))
+	}
+
+	file, err := statFingerprint(dataPath)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Reading %s into memory\n", dataPath)
+	columns, rowCount, err := readParquetColumns(dataPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s into memory: %w", dataPath, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.columns = columns
+	s.rowCount = rowCount
+	s.file = file
+	return nil
+}
+
+// Read the parquet file at dataPath again when its size or modification time changed
+func (s *memoryStore) RefreshIfChanged(dataPath string) (DataChange, error) {
+	if IsLiveDatabase(dataPath) || IsRemotePath(dataPath) {
+		return DataChange{}, nil
+	}
+
+	current, err := statFingerprint(dataPath)
+	if err != nil {
+		return DataChange{}, err
+	}
+	s.mutex.RLock()
+	unchanged := current.DataPath == s.file.DataPath && current.Size == s.file.Size && current.ModTime.Equal(s.file.ModTime)
+	s.mutex.RUnlock()
+	if unchanged {
+		return DataChange{}, nil
+	}
+
+	if err := s.Refresh(dataPath); err != nil {
+		return DataChange{}, err
+	}
+	return DataChange{Reloaded: true, UpdatedAt: current.ModTime}, nil
+}
+
+func (s *memoryStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.columns = nil
+	s.rowCount = 0
+	return nil
+}
+
+// Column named name, matched case insensitively. Nil if there is none
+func (s *memoryStore) column(name string) *memoryColumn {
+	index := slices.IndexFunc(s.columns, func(column *memoryColumn) bool {
+		return strings.EqualFold(column.name, name)
+	})
+	if index < 0 {
+		return nil
+	}
+
+	return s.columns[index]
+}
+
+// Read every column of a parquet file, batch by batch
+func readParquetColumns(dataPath string) ([]*memoryColumn, int64, error) {
+	dataFile, err := os.Open(dataPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer dataFile.Close()
+
+	parquetReader, err := file.NewParquetReader(dataFile)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer parquetReader.Close()
+
+	fileReader, err := pqarrow.NewFileReader(
+		parquetReader,
+		pqarrow.ArrowReadProperties{BatchSize: memoryBatchSize},
+		memory.DefaultAllocator,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	recordReader, err := fileReader.GetRecordReader(context.Background(), nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer recordReader.Release()
+
+	columns := []*memoryColumn{}
+	for _, field := range recordReader.Schema().Fields() {
+		dataType := duckDBTypeName(field.Type)
+		columns = append(columns, &memoryColumn{name: field.Name, dataType: dataType, kind: profileKind(dataType)})
+	}
+
+	rowCount := int64(0)
+	for recordReader.Next() {
+		record := recordReader.Record()
+		for i, column := range columns {
+			column.append(record.Column(i))
+		}
+		rowCount += record.NumRows()
+	}
+	// The reader reports the end of the file as an error
+	if err := recordReader.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return nil, 0, err
+	}
+
+	return columns, rowCount, nil
+}
+
+// DuckDB name of an arrow type, so profiles read the same on both backends
+func duckDBTypeName(dataType arrow.DataType) string {
+	switch dataType.ID() {
+	case arrow.BOOL:
+		return "BOOLEAN"
+	case arrow.INT8:
+		return "TINYINT"
+	case arrow.INT16:
+		return "SMALLINT"
+	case arrow.INT32:
+		return "INTEGER"
+	case arrow.INT64:
+		return "BIGINT"
+	case arrow.UINT8:
+		return "UTINYINT"
+	case arrow.UINT16:
+		return "USMALLINT"
+	case arrow.UINT32:
+		return "UINTEGER"
+	case arrow.UINT64:
+		return "UBIGINT"
+	case arrow.FLOAT32:
+		return "FLOAT"
+	case arrow.FLOAT64:
+		return "DOUBLE"
+	case arrow.DATE32, arrow.DATE64:
+		return "DATE"
+	case arrow.TIMESTAMP:
+		return "TIMESTAMP"
+	}
+
+	return "VARCHAR"
+}
+
+// Add the values of a batch to the column
+func (c *memoryColumn) append(values arrow.Array) {
+	for row := range values.Len() {
+		valid := values.IsValid(row)
+		c.valid = append(c.valid, valid)
+
+		switch c.kind {
+		case profileNumeric:
+			number := 0.0
+			if valid {
+				number = numericValue(values, row)
+			}
+			c.numbers = append(c.numbers, number)
+		case profileDate:
+			date := time.Time{}
+			if valid {
+				date = dateValue(values, row)
+			}
+			c.dates = append(c.dates, date)
+		default:
+			text := ""
+			if valid {
+				text = values.ValueStr(row)
+			}
+			c.texts = append(c.texts, text)
+		}
+	}
+}
+
+func numericValue(values arrow.Array, row int) float64 {
+	switch typed := values.(type) {
+	case *array.Int8:
+		return float64(typed.Value(row))
+	case *array.Int16:
+		return float64(typed.Value(row))
+	case *array.Int32:
+		return float64(typed.Value(row))
+	case *array.Int64:
+		return float64(typed.Value(row))
+	case *array.Uint8:
+		return float64(typed.Value(row))
+	case *array.Uint16:
+		return float64(typed.Value(row))
+	case *array.Uint32:
+		return float64(typed.Value(row))
+	case *array.Uint64:
+		return float64(typed.Value(row))
+	case *array.Float32:
+		return float64(typed.Value(row))
+	case *array.Float64:
+		return typed.Value(row)
+	}
+
+	number, _ := strconv.ParseFloat(values.ValueStr(row), 64)
+	return number
+}
+
+func dateValue(values arrow.Array, row int) time.Time {
+	switch typed := values.(type) {
+	case *array.Date32:
+		return typed.Value(row).ToTime()
+	case *array.Date64:
+		return typed.Value(row).ToTime()
+	case *array.Timestamp:
+		unit := typed.DataType().(*arrow.TimestampType).Unit
+		return typed.Value(row).ToTime(unit)
+	}
+
+	return time.Time{}
+}
+
+// Value of a row as text, like a VARCHAR cast on DuckDB
+func (c *memoryColumn) text(row int) string {
+	switch c.kind {
+	case profileNumeric:
+		return strconv.FormatFloat(c.numbers[row], 'f', -1, 64)
+	case profileDate:
+		if c.dataType == "DATE" {
+			return c.dates[row].Format(time.DateOnly)
+		}
+		return c.dates[row].Format(time.DateTime)
+	}
+
+	return c.texts[row]
+}
+
+// Statistics of the column, see profileColumn
+func (c *memoryColumn) profile(rowCount int64) ColumnProfile {
+	profile := ColumnProfile{Name: c.name, Type: c.dataType, Kind: c.kind}
+
+	nulls := int64(0)
+	distinct := map[string]int64{}
+	for row, valid := range c.valid {
+		if !valid {
+			nulls++
+			continue
+		}
+		distinct[c.text(row)]++
+	}
+	profile.Distinct = int64(len(distinct))
+	if rowCount > 0 {
+		profile.NullRate = float64(nulls) / float64(rowCount)
+	}
+
+	// Columns without values have no distribution
+	if nulls == rowCount {
+		return profile
+	}
+
+	switch c.kind {
+	case profileNumeric:
+		numbers := []float64{}
+		sum := 0.0
+		for row, valid := range c.valid {
+			if valid {
+				numbers = append(numbers, c.numbers[row])
+				sum += c.numbers[row]
+			}
+		}
+		slices.Sort(numbers)
+		profile.Min = numbers[0]
+		profile.Max = numbers[len(numbers)-1]
+		profile.Mean = sum / float64(len(numbers))
+		profile.P25 = continuousQuantile(numbers, 0.25)
+		profile.P50 = continuousQuantile(numbers, 0.5)
+		profile.P75 = continuousQuantile(numbers, 0.75)
+		profile.P95 = continuousQuantile(numbers, 0.95)
+		return profile
+	case profileDate:
+		days := map[string]bool{}
+		first, last := time.Time{}, time.Time{}
+		for row, valid := range c.valid {
+			if !valid {
+				continue
+			}
+			day := c.dates[row].UTC().Truncate(24 * time.Hour)
+			days[day.Format(time.DateOnly)] = true
+			if first.IsZero() || day.Before(first) {
+				first = day
+			}
+			if day.After(last) {
+				last = day
+			}
+		}
+		profile.FirstDate = first.Format(time.DateOnly)
+		profile.LastDate = last.Format(time.DateOnly)
+		profile.DateDays = int64(len(days))
+		profile.SpanDays = int64(last.Sub(first).Hours()/24) + 1
+		return profile
+	}
+
+	for value, count := range distinct {
+		profile.TopValues = append(profile.TopValues, ValueCount{Value: value, Count: count})
+	}
+	slices.SortFunc(profile.TopValues, func(a ValueCount, b ValueCount) int {
+		if a.Count != b.Count {
+			return int(b.Count - a.Count)
+		}
+		return strings.Compare(a.Value, b.Value)
+	})
+	profile.TopValues = profile.TopValues[:min(len(profile.TopValues), profileTopValues)]
+
+	return profile
+}
+
+// Interpolated quantile of sorted values, like quantile_cont on DuckDB
+func continuousQuantile(sorted []float64, quantile float64) float64 {
+	position := quantile * float64(len(sorted)-1)
+	lower := math.Floor(position)
+	upper := math.Ceil(position)
+
+	return sorted[int(lower)] + (sorted[int(upper)]-sorted[int(lower)])*(position-lower)
+}
@@ -0,0 +1,138 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+/*
+-----------
+Tool models
+-----------
+*/
+
+// Chat model of the completions made by each tool. Empty values use Config.Model
+type ToolModels struct {
+	Lookup    string // SQL generation
+	Analyze   string // Data analysis
+	Visualize string // Chart config and chart code
+}
+
+// Model of a tool by function name, falling back to defaultModel
+func (tm ToolModels) For(functionName string, defaultModel string) string {
+	model := ""
+	switch functionName {
+	case LookUpFuncName:
+		model = tm.Lookup
+	case AnalyzeFuncName:
+		model = tm.Analyze
+	case VisualizeFuncName:
+		model = tm.Visualize
+	}
+
+	if model == "" {
+		return defaultModel
+	}
+
+	return model
+}
+
+// Models set on any tool
+func (tm ToolModels) Models() []string {
+	models := []string{}
+	for _, model := range []string{tm.Lookup, tm.Analyze, tm.Visualize} {
+		if model != "" {
+			models = append(models, model)
+		}
+	}
+
+	return models
+}
+
+// Normalize the models set on tools and check they're on the completion model registry, see completion.ValidateModel
+func (tm ToolModels) Validate(allowUnknown bool) (ToolModels, error) {
+	for _, model := range []*string{&tm.Lookup, &tm.Analyze, &tm.Visualize} {
+		if *model == "" {
+			continue
+		}
+
+		validated, err := completion.ValidateModel(*model, allowUnknown)
+		if err != nil {
+			return ToolModels{}, fmt.Errorf("tool models: %w", err)
+		}
+		*model = validated
+	}
+
+	return tm, nil
+}
+
+// Parse comma separated tool models like "analyze=claude-3-5-sonnet-latest". Tools left out use the agent model
+func ParseToolModels(models string) (ToolModels, error) {
+	parsed := ToolModels{}
+	for entry := range strings.SplitSeq(models, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, model, found := strings.Cut(entry, "=")
+		model = strings.TrimSpace(model)
+		if !found || model == "" {
+			return ToolModels{}, fmt.Errorf("invalid tool model '%s', expected tool=model", entry)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "lookup":
+			parsed.Lookup = model
+		case "analyze":
+			parsed.Analyze = model
+		case "visualize":
+			parsed.Visualize = model
+		default:
+			return ToolModels{}, fmt.Errorf("unknown tool '%s' on tool models", name)
+		}
+	}
+
+	return parsed, nil
+}
+
+// Model of the completions made by a tool on this run
+func (t *Toolbox) toolModel(functionName string) string {
+	return t.config.ToolModels.For(functionName, t.config.Model)
+}
+
+/*
+------------
+Model router
+------------
+*/
+
+// Chat completer sending each completion to the backend of its model
+type modelRouter struct {
+	completer ChatCompleter            // Backend of models without a route, usually OpenAI
+	routes    map[string]ChatCompleter // Backends keyed by model name prefix, like "claude"
+}
+
+// Wrap a completer so models starting with a route prefix are completed by that route's backend
+func NewModelRouter(completer ChatCompleter, routes map[string]ChatCompleter) ChatCompleter {
+	return &modelRouter{completer: completer, routes: routes}
+}
+
+func (m *modelRouter) New(
+	ctx context.Context,
+	body openai.ChatCompletionNewParams,
+	opts ...option.RequestOption,
+) (*openai.ChatCompletion, error) {
+	for prefix, completer := range m.routes {
+		if strings.HasPrefix(body.Model.Value, prefix) {
+			return completer.New(ctx, body, opts...)
+		}
+	}
+
+	return m.completer.New(ctx, body, opts...)
+}
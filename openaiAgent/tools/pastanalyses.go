@@ -0,0 +1,299 @@
+package tools
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/shared"
+)
+
+/*
+-------------
+Past analyses
+-------------
+*/
+
+const SearchPastAnalysesFuncName = "SearchPastAnalyses"
+const DefaultEmbeddingModel = openai.EmbeddingModelTextEmbedding3Small
+
+// Past analyses a search returns
+const pastAnalysesTopK = 3
+
+// Runes of a past answer shown on a search result
+const pastAnswerSnippetRunes = 400
+
+// Embeddings client. Satisfied by the OpenAI client's Embeddings service
+type Embedder interface {
+	New(ctx context.Context, body openai.EmbeddingNewParams, opts ...option.RequestOption) (*openai.CreateEmbeddingResponse, error)
+}
+
+// Question and answer of a past run, with the embedding they're searched by
+type PastAnalysis struct {
+	RunID    string    `json:"runId"`
+	Date     time.Time `json:"date"`
+	Question string    `json:"question"`
+	Answer   string    `json:"answer"`
+	Vector   []float64 `json:"vector"`
+}
+
+// Past analysis found by a search, with its cosine similarity to the query
+type PastAnalysisMatch struct {
+	PastAnalysis
+	Score float64
+}
+
+// The index file can't be read back, like after a partial write or a change of embedding model
+type ErrCorruptIndex struct {
+	Path   string
+	Reason string
+}
+
+func (e *ErrCorruptIndex) Error() string {
+	return fmt.Sprintf("past analyses index %s is corrupt: %s", e.Path, e.Reason)
+}
+func (e *ErrCorruptIndex) ExitClass() exitcode.Class { return exitcode.ClassData }
+
+// Flat file of past analyses, one JSON line each, searched by brute force cosine similarity.
+// Safe for concurrent runs of one process
+type AnalysisIndex struct {
+	path     string
+	embedder Embedder
+	model    string
+	mutex    sync.Mutex
+}
+
+// Create an index on a JSONL file, created on the first analysis added. Empty model uses DefaultEmbeddingModel
+func NewAnalysisIndex(path string, embedder Embedder, model string) *AnalysisIndex {
+	if model == "" {
+		model = DefaultEmbeddingModel
+	}
+	return &AnalysisIndex{path: path, embedder: embedder, model: model}
+}
+
+// Embedding model of the index
+func (i *AnalysisIndex) Model() string {
+	return i.model
+}
+
+// Embed a text with the index model, on behalf of the end user of ctx
+func (i *AnalysisIndex) embed(ctx context.Context, text string) ([]float64, error) {
+	params := openai.EmbeddingNewParams{
+		Input: openai.F[openai.EmbeddingNewParamsInputUnion](shared.UnionString(text)),
+		Model: openai.F(openai.EmbeddingModel(i.model)),
+	}
+	if user := completion.EndUserFrom(ctx); user != "" {
+		params.User = openai.F(user)
+	}
+
+	response, err := i.embedder.New(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("embedding failed: %w", err)
+	}
+	if len(response.Data) == 0 || len(response.Data[0].Embedding) == 0 {
+		return nil, fmt.Errorf("embedding response has no vector")
+	}
+
+	return response.Data[0].Embedding, nil
+}
+
+// Text a past analysis is embedded from
+func pastAnalysisText(question string, answer string) string {
+	return fmt.Sprintf("Question: %s\nAnswer: %s", question, answer)
+}
+
+// Embed the question and answer of a run and append them to the index
+func (i *AnalysisIndex) Add(ctx context.Context, analysis PastAnalysis) error {
+	vector, err := i.embed(ctx, pastAnalysisText(analysis.Question, analysis.Answer))
+	if err != nil {
+		return err
+	}
+	analysis.Vector = vector
+
+	line, err := json.Marshal(analysis)
+	if err != nil {
+		return err
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(i.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create past analyses index directory: %w", err)
+	}
+	file, err := os.OpenFile(i.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open past analyses index: %w", err)
+	}
+	defer file.Close()
+
+	// One write per line, so concurrent appends don't interleave
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write past analyses index: %w", err)
+	}
+
+	return nil
+}
+
+// Past analyses of the index. A missing file has none, and lines that don't decode or vectors of
+// another dimension than the first one fail with ErrCorruptIndex
+func (i *AnalysisIndex) load() ([]PastAnalysis, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	file, err := os.Open(i.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []PastAnalysis{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open past analyses index: %w", err)
+	}
+	defer file.Close()
+
+	analyses := []PastAnalysis{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		analysis := PastAnalysis{}
+		if err := json.Unmarshal(scanner.Bytes(), &analysis); err != nil {
+			return nil, &ErrCorruptIndex{Path: i.path, Reason: fmt.Sprintf("line %d: %s", lineNumber, err)}
+		}
+		if len(analysis.Vector) == 0 || (len(analyses) != 0 && len(analysis.Vector) != len(analyses[0].Vector)) {
+			return nil, &ErrCorruptIndex{Path: i.path, Reason: fmt.Sprintf("line %d has a vector of %d dimensions", lineNumber, len(analysis.Vector))}
+		}
+		analyses = append(analyses, analysis)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &ErrCorruptIndex{Path: i.path, Reason: err.Error()}
+	}
+
+	return analyses, nil
+}
+
+// Past analyses most similar to a query, most similar first. At most k are returned
+func (i *AnalysisIndex) Search(ctx context.Context, query string, k int) ([]PastAnalysisMatch, error) {
+	analyses, err := i.load()
+	if err != nil {
+		return nil, err
+	}
+	if len(analyses) == 0 {
+		return []PastAnalysisMatch{}, nil
+	}
+
+	vector, err := i.embed(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(vector) != len(analyses[0].Vector) {
+		return nil, &ErrCorruptIndex{Path: i.path, Reason: fmt.Sprintf("vectors have %d dimensions, %s embeds %d", len(analyses[0].Vector), i.model, len(vector))}
+	}
+
+	matches := []PastAnalysisMatch{}
+	for _, analysis := range analyses {
+		matches = append(matches, PastAnalysisMatch{PastAnalysis: analysis, Score: cosineSimilarity(vector, analysis.Vector)})
+	}
+	slices.SortStableFunc(matches, func(a, b PastAnalysisMatch) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+
+	return matches[:min(k, len(matches))], nil
+}
+
+// Cosine similarity of two vectors of the same dimension. 0 when either is all zeros
+func cosineSimilarity(a []float64, b []float64) float64 {
+	dot, normA, normB := 0.0, 0.0, 0.0
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// Tool searching the analyses of past runs. A corrupt index answers like an empty one, so the router
+// goes on without past analyses instead of failing the run
+func (t *Toolbox) SearchPastAnalyses(parentCtx context.Context, query string) (string, error) {
+	if strings.TrimSpace(query) == "" {
+		return "", &ErrInvalidArguments{Tool: SearchPastAnalysesFuncName, Reason: "missing query"}
+	}
+	if t.config.PastAnalyses == nil {
+		return "", &ErrInvalidArguments{Tool: SearchPastAnalysesFuncName, Reason: "no past analyses index is configured"}
+	}
+
+	// Start span as sub span of the handleToolCalls span
+	ctx, span := t.tracer.StartOpenInferenceSpan(parentCtx, "SearchPastAnalysesTool", tracing.RetrieverKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	tracing.SetSpanInput(span, query)
+	tracing.SetSpanAttr(span, "embedding.model_name", t.config.PastAnalyses.Model())
+
+	matches, err := t.config.PastAnalyses.Search(ctx, query, pastAnalysesTopK)
+	var corrupt *ErrCorruptIndex
+	if errors.As(err, &corrupt) {
+		log.Printf("WARNING: %s, searching it finds nothing\n", err)
+		tracing.SetSpanAttr(span, "retrieval.index_error", err.Error())
+		matches, err = []PastAnalysisMatch{}, nil
+	}
+	if err != nil {
+		tracing.SetSpanErrorCode(span)
+		return "", err
+	}
+
+	for i, match := range matches {
+		prefix := fmt.Sprintf("retrieval.documents.%d.document.", i)
+		tracing.SetSpanAttrFromMap(span, map[string]any{
+			prefix + "id":      match.RunID,
+			prefix + "content": pastAnalysisText(match.Question, match.Answer),
+			prefix + "score":   match.Score,
+		})
+	}
+
+	formatted := formatPastAnalyses(matches)
+	tracing.SetSpanOutput(span, formatted)
+	tracing.SetSpanSuccessCode(span)
+	return formatted, nil
+}
+
+// Past analyses for the model, with their dates and run ids. Answers are cut to pastAnswerSnippetRunes
+func formatPastAnalyses(matches []PastAnalysisMatch) string {
+	if len(matches) == 0 {
+		return "No past analyses found."
+	}
+
+	lines := []string{fmt.Sprintf("%d past analyses, most similar first. Their figures are as of their date:", len(matches))}
+	for _, match := range matches {
+		answer := []rune(match.Answer)
+		snippet := string(answer[:min(len(answer), pastAnswerSnippetRunes)])
+		if len(answer) > pastAnswerSnippetRunes {
+			snippet += "..."
+		}
+		lines = append(lines, fmt.Sprintf("- %s (run %s, similarity %.2f)\n  Question: %s\n  Answer: %s",
+			match.Date.Format(time.DateOnly), match.RunID, match.Score, match.Question, snippet))
+	}
+
+	return strings.Join(lines, "\n")
+}
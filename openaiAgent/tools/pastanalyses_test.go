@@ -0,0 +1,114 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+)
+
+// Write an index of past analyses to a temp dir, embedded by the mock embedder
+func seedPastAnalyses(t *testing.T) string {
+	t.Helper()
+	indexPath := filepath.Join(t.TempDir(), "analyses.jsonl")
+	index := NewAnalysisIndex(indexPath, &mock.Embedder{}, "")
+	analyses := []PastAnalysis{
+		{RunID: "fixture-promotions", Question: "What did promotions do to units sold?", Answer: "Promotions lifted units sold at every store, 18% on average."},
+		{RunID: "fixture-stores", Question: "Which store sold the most?", Answer: "Store 1320 had the highest total sales value."},
+		{RunID: "fixture-weekly", Question: "How did weekly sales trend in 2021?", Answer: "Weekly sales grew through the year and peaked in December."},
+	}
+	for i, analysis := range analyses {
+		analysis.Date = time.Date(2026, time.September, 1+i, 0, 0, 0, 0, time.UTC)
+		if err := index.Add(context.Background(), analysis); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return indexPath
+}
+
+// Searching a seeded index finds the most similar past analysis first
+func TestSearchPastAnalyses(t *testing.T) {
+	index := NewAnalysisIndex(seedPastAnalyses(t), &mock.Embedder{}, "")
+	cases := []struct {
+		name  string
+		query string
+		want  string // Run id of the best match
+	}{
+		{name: "promotions", query: "what did we learn about promotions last month", want: "fixture-promotions"},
+		{name: "best store", query: "which store sold the most", want: "fixture-stores"},
+		{name: "weekly trend", query: "weekly sales trend", want: "fixture-weekly"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			matches, err := index.Search(context.Background(), c.query, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(matches) != 2 {
+				t.Fatalf("found %d past analyses, expected the top 2", len(matches))
+			}
+			if matches[0].RunID != c.want {
+				t.Errorf("found run '%s' first, expected '%s'", matches[0].RunID, c.want)
+			}
+			if matches[0].Score < matches[1].Score {
+				t.Error("matches aren't sorted by similarity")
+			}
+		})
+	}
+}
+
+// A corrupt index fails searches with ErrCorruptIndex, and the tool answers it like an empty one
+func TestSearchCorruptPastAnalyses(t *testing.T) {
+	seeded, err := os.ReadFile(seedPastAnalyses(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		content string
+	}{
+		{name: "truncated line", content: string(seeded) + `{"runId": "fixture-cut", "vect`},
+		{name: "other dimension", content: string(seeded) + `{"runId": "fixture-small", "vector": [0.5, 0.5]}` + "\n"},
+	}
+
+	ctx := context.Background()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			indexPath := filepath.Join(t.TempDir(), "analyses.jsonl")
+			if err := os.WriteFile(indexPath, []byte(c.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			index := NewAnalysisIndex(indexPath, &mock.Embedder{}, "")
+			var corrupt *ErrCorruptIndex
+			if _, err := index.Search(ctx, "promotions", 3); !errors.As(err, &corrupt) {
+				t.Fatalf("search failed with %v, expected a corrupt index", err)
+			}
+
+			toolbox := NewToolbox(Config{PastAnalyses: index}, nil, nil, tracing.NewNoopTracer(), nil)
+			result, err := toolbox.SearchPastAnalyses(ctx, "promotions")
+			if err != nil {
+				t.Fatalf("tool failed: %s", err)
+			}
+			if !strings.HasPrefix(result, "No past analyses found") {
+				t.Errorf("tool answered %q, expected no results", result)
+			}
+		})
+	}
+}
+
+// An index that wasn't written yet finds nothing
+func TestSearchMissingPastAnalyses(t *testing.T) {
+	index := NewAnalysisIndex(filepath.Join(t.TempDir(), "missing.jsonl"), &mock.Embedder{}, "")
+	matches, err := index.Search(context.Background(), "promotions", 3)
+	if err != nil || len(matches) != 0 {
+		t.Fatalf("found %d past analyses: %v", len(matches), err)
+	}
+}
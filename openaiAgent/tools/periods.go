@@ -0,0 +1,458 @@
+package tools
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+-----------------
+Period comparison
+-----------------
+*/
+
+const ComparePeriodsFuncName = "ComparePeriods"
+
+// Groups listed on the comparison table shown to the model, largest changes first
+const comparisonPreviewRows = 20
+
+// Which side of a comparison a group has no rows on. Missing sides count as 0
+const (
+	MissingPeriod   = "period"
+	MissingBaseline = "baseline"
+)
+
+// A metric grouped by a column, compared between a period and the baseline period it's measured against,
+// like this month against last month
+type ComparePeriodsRequest struct {
+	Metric   string // What to aggregate, like "total sales value"
+	GroupBy  string // Column grouping the metric, like "Store_Number". Empty compares the totals only
+	Period   string // Period expression, like "this month" or "2021-03"
+	Baseline string // Period expression the period is compared against, like "last month"
+}
+
+// Metric of a group on both periods
+type PeriodDelta struct {
+	Group    string
+	Value    float64  // On the period
+	Baseline float64  // On the baseline period
+	Delta    float64  // Value minus Baseline
+	Percent  *float64 // Delta over Baseline, in percent. Nil when the baseline is 0
+	Missing  string   // MissingPeriod or MissingBaseline when the group only has rows on the other period
+}
+
+// Formatted delta, like "7: 80 -> 100 +20 (+25.0%)"
+func (d PeriodDelta) String() string {
+	text := fmt.Sprintf("%s: %s -> %s %s", d.Group, formatMetric(d.Baseline), formatMetric(d.Value), formatDelta(d))
+	if d.Missing != "" {
+		text += fmt.Sprintf(", no %s rows", d.Missing)
+	}
+	return text
+}
+
+// Outcome of a period comparison
+type ComparePeriodsResult struct {
+	Request       ComparePeriodsRequest
+	PeriodSQL     string
+	BaselineSQL   string
+	PeriodDates   []NormalizedDate
+	BaselineDates []NormalizedDate
+	Deltas        []PeriodDelta // Largest absolute delta first
+	Total         PeriodDelta   // Sum of every group
+	Headline      string
+	ResultRef     string
+}
+
+// Compare the metric of each group between two periods. Each period gets its own SQL aggregation, generated and
+// validated like a lookup one and run on a PeriodQuery span, and the results are joined here.
+// The comparison table is stored as a result, so it can be analyzed or charted by reference
+func (t *Toolbox) Compare(parentCtx context.Context, request ComparePeriodsRequest) (ComparePeriodsResult, error) {
+	// Start span as sub span of the handleToolCalls span
+	ctx, span := t.tracer.StartOpenInferenceSpan(parentCtx, "ComparePeriodsTool", tracing.ToolKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	tracing.SetSpanInput(span, fmt.Sprintf("%s by %s, %s vs %s", request.Metric, cmp.Or(request.GroupBy, "total"), request.Period, request.Baseline))
+
+	result, err := t.comparePeriods(ctx, request)
+	if err != nil && timedOut(ctx) {
+		tracing.SetSpanAttr(span, "tool.timeout", true)
+		err = &ErrToolTimeout{Tool: ComparePeriodsFuncName, Timeout: t.config.ToolTimeouts.For(ComparePeriodsFuncName)}
+	}
+	if err != nil {
+		log.Printf("WARNING: %s\n", err)
+		tracing.SetSpanAttr(span, "tool.error.class", ErrorClass(err))
+		tracing.SetSpanErrorCode(span)
+		return result, err
+	}
+
+	columns, rows := periodDeltaRows(result)
+	result.ResultRef = t.storeResult(formatRows(columns, rows))
+	t.latestResultRef = result.ResultRef
+
+	tracing.SetSpanAttrFromMap(span, map[string]any{
+		"tool.result_ref":     result.ResultRef,
+		"comparison.groups":   len(result.Deltas),
+		"comparison.headline": result.Headline,
+	})
+	tracing.SetSpanOutput(span, result.Headline)
+	tracing.SetSpanSuccessCode(span)
+
+	return result, nil
+}
+
+func (t *Toolbox) comparePeriods(ctx context.Context, request ComparePeriodsRequest) (ComparePeriodsResult, error) {
+	result := ComparePeriodsResult{Request: request}
+	if strings.TrimSpace(request.Metric) == "" || strings.TrimSpace(request.Period) == "" || strings.TrimSpace(request.Baseline) == "" {
+		return result, &ErrInvalidArguments{Tool: ComparePeriodsFuncName, Reason: "missing metric, period or baselinePeriod"}
+	}
+
+	// Denied runs fail before touching the database
+	if err := t.authorizeDataset(ctx, ComparePeriodsFuncName, tableName); err != nil {
+		return result, err
+	}
+	if _, err := t.store.SQL(); err != nil {
+		return result, err
+	}
+
+	columns, err := t.store.Columns(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	period, err := t.periodQuery(ctx, request, request.Period, columns)
+	result.PeriodSQL, result.PeriodDates = period.SQL, period.Dates
+	if err != nil {
+		return result, err
+	}
+	baseline, err := t.periodQuery(ctx, request, request.Baseline, columns)
+	result.BaselineSQL, result.BaselineDates = baseline.SQL, baseline.Dates
+	if err != nil {
+		return result, err
+	}
+
+	result.Deltas, err = ComparePeriodRows(period.Rows, baseline.Rows)
+	if err != nil {
+		return result, &ErrSQLGeneration{Reason: err.Error()}
+	}
+	result.Total = totalPeriodDelta(result.Deltas)
+	result.Headline = comparisonHeadline(request, result.Total, result.Deltas)
+
+	return result, nil
+}
+
+// Aggregation of one period of a comparison
+type periodResult struct {
+	SQL   string
+	Dates []NormalizedDate
+	Rows  [][]any
+}
+
+// Generate, validate and run the aggregation of one period, on its own PeriodQuery span
+func (t *Toolbox) periodQuery(parentCtx context.Context, request ComparePeriodsRequest, period string, columns []string) (periodResult, error) {
+	ctx, span := t.tracer.StartOpenInferenceSpan(parentCtx, "PeriodQuery", tracing.ChainKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	result, err := t.runPeriodQuery(ctx, span, request, period, columns)
+	if err != nil {
+		tracing.SetSpanErrorCode(span)
+		return result, err
+	}
+
+	tracing.SetSpanAttr(span, "retrieval.row_count", len(result.Rows))
+	tracing.SetSpanSuccessCode(span)
+	return result, nil
+}
+
+func (t *Toolbox) runPeriodQuery(
+	ctx context.Context,
+	span trace.Span,
+	request ComparePeriodsRequest,
+	period string,
+	columns []string,
+) (periodResult, error) {
+	result := periodResult{}
+
+	// Periods like "last month" are stated in ISO-8601, ambiguous ones need the user to clarify them
+	dates, err := NormalizeDates(period, t.config.DateOrder, cmp.Or(t.config.Today, time.Now()))
+	if err != nil {
+		return result, err
+	}
+	result.Dates = dates
+
+	prompt := periodQueryPrompt(request, period) + DateClarification(dates)
+	tracing.SetSpanInput(span, prompt)
+
+	_, generated, err := t.generateSqlQuery(ctx, prompt, columns, tableName)
+	if err != nil {
+		return result, fmt.Errorf("failed to generate SQL query: %w", err)
+	}
+	result.SQL, _ = LintSQL(cleanLlmBlockResponse(generated))
+	if result.SQL == "" {
+		return result, &ErrSQLGeneration{Reason: "the model returned no query"}
+	}
+	tracing.SetSpanAttr(span, "tool.sql", result.SQL)
+
+	// Queries on live databases may reach other tables than the dataset one. Comparisons only read
+	for _, table := range referencedTables(result.SQL, t.config.LiveTables) {
+		if err := t.authorizeDataset(ctx, ComparePeriodsFuncName, table); err != nil {
+			return result, err
+		}
+	}
+	createdTable, err := ValidateSQL(result.SQL, t.TempNamespace())
+	if err != nil {
+		return result, err
+	}
+	if createdTable != "" {
+		return result, &ErrSQLGeneration{Reason: "comparison queries can't create tables"}
+	}
+
+	resultColumns, rows, err := t.runAuditedQuery(ctx, ComparePeriodsFuncName, AuditSourceGenerated, result.SQL)
+	if err != nil {
+		return result, &ErrSQLExecution{Query: result.SQL, DBErr: err}
+	}
+	t.recordTouchedDataset(tableName, resultColumns)
+	t.retrieval.Lookups++
+	t.retrieval.RowsReturned += len(rows)
+
+	result.Rows = rows
+	tracing.SetSpanOutput(span, formatRows(resultColumns, rows))
+	return result, nil
+}
+
+// Prompt generating the aggregation of one period, asking for the group and the metric only
+func periodQueryPrompt(request ComparePeriodsRequest, period string) string {
+	if strings.TrimSpace(request.GroupBy) == "" {
+		return fmt.Sprintf(
+			"%s for %s. Return one row with exactly two columns: the label 'total' first and the metric second.",
+			request.Metric, period,
+		)
+	}
+
+	return fmt.Sprintf(
+		"%s by %s for %s. Return exactly two columns, %s first and the metric second, one row per %s.",
+		request.Metric, request.GroupBy, period, request.GroupBy, request.GroupBy,
+	)
+}
+
+// Join the rows of two period aggregations, each a group and its metric, into the delta of every group.
+// Groups repeated on a period are summed, NULL metrics count as 0, and groups with rows on one period only
+// count as 0 on the other. Deltas are sorted by their absolute value, largest first, then by group
+func ComparePeriodRows(periodRows [][]any, baselineRows [][]any) ([]PeriodDelta, error) {
+	period, err := periodTotals(periodRows)
+	if err != nil {
+		return nil, fmt.Errorf("period result: %w", err)
+	}
+	baseline, err := periodTotals(baselineRows)
+	if err != nil {
+		return nil, fmt.Errorf("baseline result: %w", err)
+	}
+
+	deltas := []PeriodDelta{}
+	for group, value := range period {
+		baselineValue, ok := baseline[group]
+		delta := newPeriodDelta(group, value, baselineValue)
+		if !ok {
+			delta.Missing = MissingBaseline
+		}
+		deltas = append(deltas, delta)
+	}
+	for group, baselineValue := range baseline {
+		if _, ok := period[group]; !ok {
+			delta := newPeriodDelta(group, 0, baselineValue)
+			delta.Missing = MissingPeriod
+			deltas = append(deltas, delta)
+		}
+	}
+
+	slices.SortFunc(deltas, func(a PeriodDelta, b PeriodDelta) int {
+		return cmp.Or(cmp.Compare(math.Abs(b.Delta), math.Abs(a.Delta)), strings.Compare(a.Group, b.Group))
+	})
+	return deltas, nil
+}
+
+// Delta of a group, without a percentage when the baseline is 0
+func newPeriodDelta(group string, value float64, baseline float64) PeriodDelta {
+	delta := PeriodDelta{Group: group, Value: value, Baseline: baseline, Delta: value - baseline}
+	if baseline != 0 {
+		percent := delta.Delta / math.Abs(baseline) * 100
+		delta.Percent = &percent
+	}
+	return delta
+}
+
+// Sum of the deltas of every group
+func totalPeriodDelta(deltas []PeriodDelta) PeriodDelta {
+	value, baseline := 0.0, 0.0
+	for _, delta := range deltas {
+		value += delta.Value
+		baseline += delta.Baseline
+	}
+	return newPeriodDelta("total", value, baseline)
+}
+
+// Metric of each group of an aggregation result
+func periodTotals(rows [][]any) (map[string]float64, error) {
+	totals := map[string]float64{}
+	for i, row := range rows {
+		if len(row) != 2 {
+			return nil, fmt.Errorf("row %d has %d columns, expected the group and the metric", i+1, len(row))
+		}
+		value, ok := metricValue(row[1])
+		if !ok {
+			return nil, fmt.Errorf("row %d metric %v isn't a number", i+1, row[1])
+		}
+		totals[groupKey(row[0])] += value
+	}
+	return totals, nil
+}
+
+// Group of a row as text. NULL groups are their own group
+func groupKey(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "NULL"
+	case time.Time:
+		return value.Format(time.DateOnly)
+	}
+	return fmt.Sprint(value)
+}
+
+// Numeric value of a metric as the driver returns it. Sums of integers are big integers
+// and decimals are strings, see decimalValue
+func metricValue(value any) (float64, bool) {
+	switch value := value.(type) {
+	case nil:
+		return 0, true
+	case int:
+		return float64(value), true
+	case int8:
+		return float64(value), true
+	case int16:
+		return float64(value), true
+	case int32:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case uint8:
+		return float64(value), true
+	case uint16:
+		return float64(value), true
+	case uint32:
+		return float64(value), true
+	case uint64:
+		return float64(value), true
+	case float32:
+		return float64(value), true
+	case float64:
+		return value, true
+	case *big.Int:
+		float, _ := new(big.Float).SetInt(value).Float64()
+		return float, true
+	case string:
+		float, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		return float, err == nil
+	}
+	return 0, false
+}
+
+// Numbers rounded to 2 decimals, without trailing zeros
+func formatMetric(value float64) string {
+	return strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+}
+
+// Signed delta and its percentage, like "+20 (+25.0%)", or "+20 (n/a)" from a 0 baseline
+func formatDelta(delta PeriodDelta) string {
+	sign := "+"
+	if delta.Delta < 0 {
+		sign = "-"
+	}
+	percent := "n/a"
+	if delta.Percent != nil {
+		percent = fmt.Sprintf("%+.1f%%", *delta.Percent)
+	}
+	return fmt.Sprintf("%s%s (%s)", sign, formatMetric(math.Abs(delta.Delta)), percent)
+}
+
+// One line summing up a comparison: the total change and the group that changed the most
+func comparisonHeadline(request ComparePeriodsRequest, total PeriodDelta, deltas []PeriodDelta) string {
+	direction := "rose"
+	switch {
+	case total.Delta < 0:
+		direction = "fell"
+	case total.Delta == 0:
+		direction = "didn't change"
+	}
+
+	headline := fmt.Sprintf("%s %s", request.Metric, direction)
+	if total.Delta != 0 {
+		headline += " by " + formatMetric(math.Abs(total.Delta))
+	}
+	if total.Delta != 0 && total.Percent != nil {
+		headline += fmt.Sprintf(" (%+.1f%%)", *total.Percent)
+	}
+	headline += fmt.Sprintf(" from %s to %s, %s to %s",
+		request.Baseline, request.Period, formatMetric(total.Baseline), formatMetric(total.Value))
+
+	if request.GroupBy != "" && len(deltas) != 0 && deltas[0].Delta != 0 {
+		headline += fmt.Sprintf(". Largest change: %s %s, %s", request.GroupBy, deltas[0].Group, formatDelta(deltas[0]))
+	}
+
+	return strings.ToUpper(headline[:1]) + headline[1:]
+}
+
+// Comparison table columns and rows, a row per group after the total
+func periodDeltaRows(result ComparePeriodsResult) ([]string, [][]any) {
+	columns := []string{cmp.Or(result.Request.GroupBy, "group"), result.Request.Period, result.Request.Baseline, "delta", "delta_pct"}
+	rows := [][]any{}
+	for _, delta := range append([]PeriodDelta{result.Total}, result.Deltas...) {
+		percent := "n/a"
+		if delta.Percent != nil {
+			percent = fmt.Sprintf("%.1f", *delta.Percent)
+		}
+		group := delta.Group
+		if delta.Missing != "" {
+			group = fmt.Sprintf("%s (no %s rows)", delta.Group, delta.Missing)
+		}
+		rows = append(rows, []any{group, formatMetric(delta.Value), formatMetric(delta.Baseline), formatMetric(delta.Delta), percent})
+	}
+	return columns, rows
+}
+
+// Model facing comparison: the headline, the periods as dates and the first groups of the table
+func formatComparison(result ComparePeriodsResult) string {
+	lines := []string{result.Headline + "."}
+	for _, date := range slices.Concat(result.PeriodDates, result.BaselineDates) {
+		lines = append(lines, date.String())
+	}
+
+	columns, rows := periodDeltaRows(result)
+	header := fmt.Sprintf("result_ref: %q (%d groups)", result.ResultRef, len(result.Deltas))
+	if len(rows) > comparisonPreviewRows+1 {
+		header = fmt.Sprintf("result_ref: %q (%d groups, showing the %d largest changes)", result.ResultRef, len(result.Deltas), comparisonPreviewRows)
+		rows = rows[:comparisonPreviewRows+1]
+	}
+	lines = append(lines, header, formatRows(columns, rows))
+
+	return strings.Join(lines, "\n")
+}
+
+// Tool comparing a metric between two periods. Shows the headline and the largest changes to the model,
+// along with the reference of the full comparison table
+func (t *Toolbox) ComparePeriods(parentCtx context.Context, metric string, groupBy string, period string, baseline string) (string, error) {
+	result, err := t.Compare(parentCtx, ComparePeriodsRequest{Metric: metric, GroupBy: groupBy, Period: period, Baseline: baseline})
+	if err != nil {
+		return "", err
+	}
+	return formatComparison(result), nil
+}
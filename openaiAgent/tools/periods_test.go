@@ -0,0 +1,141 @@
+package tools
+
+import (
+	"math/big"
+	"slices"
+	"testing"
+)
+
+// Matching groups, zero baselines, groups missing on either period, driver value types and headlines.
+// Deltas are compared as formatted by PeriodDelta.String
+func TestComparePeriodRows(t *testing.T) {
+	// Request the headlines are written for
+	request := ComparePeriodsRequest{Metric: "sales", GroupBy: "store", Period: "March", Baseline: "February"}
+
+	cases := []struct {
+		name         string
+		periodRows   [][]any
+		baselineRows [][]any
+		want         []string
+		total        string // Expected total, skipped when empty
+		headline     string // Expected headline of request, skipped when empty
+		failure      string // Expected error, instead of deltas
+	}{
+		{
+			name:         "matching groups",
+			periodRows:   [][]any{{int64(1), int64(100)}, {int64(2), int64(50)}},
+			baselineRows: [][]any{{int64(1), int64(80)}, {int64(2), int64(50)}},
+			want:         []string{"1: 80 -> 100 +20 (+25.0%)", "2: 50 -> 50 +0 (+0.0%)"},
+			total:        "total: 130 -> 150 +20 (+15.4%)",
+			headline:     "Sales rose by 20 (+15.4%) from February to March, 130 to 150. Largest change: store 1, +20 (+25.0%)",
+		},
+		{
+			name:         "decrease",
+			periodRows:   [][]any{{"A", 30.0}},
+			baselineRows: [][]any{{"A", 40.0}},
+			want:         []string{"A: 40 -> 30 -10 (-25.0%)"},
+			headline:     "Sales fell by 10 (-25.0%) from February to March, 40 to 30. Largest change: store A, -10 (-25.0%)",
+		},
+		{
+			name:         "zero baseline",
+			periodRows:   [][]any{{"A", 25.5}},
+			baselineRows: [][]any{{"A", 0.0}},
+			want:         []string{"A: 0 -> 25.5 +25.5 (n/a)"},
+			headline:     "Sales rose by 25.5 from February to March, 0 to 25.5. Largest change: store A, +25.5 (n/a)",
+		},
+		{
+			name:         "zero on both",
+			periodRows:   [][]any{{"A", int32(0)}},
+			baselineRows: [][]any{{"A", int32(0)}},
+			want:         []string{"A: 0 -> 0 +0 (n/a)"},
+			total:        "total: 0 -> 0 +0 (n/a)",
+			headline:     "Sales didn't change from February to March, 0 to 0",
+		},
+		{
+			name:         "negative baseline",
+			periodRows:   [][]any{{"A", -5.0}},
+			baselineRows: [][]any{{"A", -10.0}},
+			want:         []string{"A: -10 -> -5 +5 (+50.0%)"},
+		},
+		{
+			name:         "missing on baseline",
+			periodRows:   [][]any{{"A", 10.0}, {"B", 7.0}},
+			baselineRows: [][]any{{"A", 10.0}},
+			want:         []string{"B: 0 -> 7 +7 (n/a), no baseline rows", "A: 10 -> 10 +0 (+0.0%)"},
+		},
+		{
+			name:         "missing on period",
+			periodRows:   [][]any{{"A", 10.0}},
+			baselineRows: [][]any{{"A", 10.0}, {"B", 4.0}},
+			want:         []string{"B: 4 -> 0 -4 (-100.0%), no period rows", "A: 10 -> 10 +0 (+0.0%)"},
+			total:        "total: 14 -> 10 -4 (-28.6%)",
+		},
+		{
+			name:         "empty periods",
+			periodRows:   [][]any{},
+			baselineRows: [][]any{},
+			want:         []string{},
+			total:        "total: 0 -> 0 +0 (n/a)",
+		},
+		{
+			name:         "driver types",
+			periodRows:   [][]any{{int64(1), big.NewInt(1000)}, {int64(2), "12.50"}, {nil, nil}},
+			baselineRows: [][]any{{int64(1), uint16(500)}, {int64(2), float32(10)}, {nil, int8(3)}},
+			want:         []string{"1: 500 -> 1000 +500 (+100.0%)", "NULL: 3 -> 0 -3 (-100.0%)", "2: 10 -> 12.5 +2.5 (+25.0%)"},
+		},
+		{
+			name:         "repeated groups",
+			periodRows:   [][]any{{"A", 1.0}, {"A", 2.0}},
+			baselineRows: [][]any{{"A", 3.0}},
+			want:         []string{"A: 3 -> 3 +0 (+0.0%)"},
+		},
+		{
+			name:         "ties by group",
+			periodRows:   [][]any{{"B", 2.0}, {"A", 2.0}},
+			baselineRows: [][]any{{"B", 1.0}, {"A", 3.0}},
+			want:         []string{"A: 3 -> 2 -1 (-33.3%)", "B: 1 -> 2 +1 (+100.0%)"},
+		},
+		{
+			name:         "text metric",
+			periodRows:   [][]any{{"A", "many"}},
+			baselineRows: [][]any{{"A", 1.0}},
+			failure:      "period result: row 1 metric many isn't a number",
+		},
+		{
+			name:         "single column",
+			periodRows:   [][]any{{"A", 1.0}},
+			baselineRows: [][]any{{1.0}},
+			failure:      "baseline result: row 1 has 1 columns, expected the group and the metric",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			deltas, err := ComparePeriodRows(c.periodRows, c.baselineRows)
+			if c.failure != "" {
+				if err == nil || err.Error() != c.failure {
+					t.Fatalf("got error %v, expected '%s'", err, c.failure)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			found := []string{}
+			for _, delta := range deltas {
+				found = append(found, delta.String())
+			}
+			if !slices.Equal(found, c.want) {
+				t.Fatalf("found %q, expected %q", found, c.want)
+			}
+			total := totalPeriodDelta(deltas)
+			if c.total != "" && total.String() != c.total {
+				t.Fatalf("total is '%s', expected '%s'", total, c.total)
+			}
+			if headline := comparisonHeadline(request, total, deltas); c.headline != "" && headline != c.headline {
+				t.Fatalf("headline is '%s', expected '%s'", headline, c.headline)
+			}
+		})
+	}
+}
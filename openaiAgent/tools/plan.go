@@ -0,0 +1,139 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/prompts"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+)
+
+/*
+------------
+Run planning
+------------
+*/
+
+// Tool call the router intends to make
+type PlanStep struct {
+	Tool      string `json:"tool" jsonschema_description:"Function name of the tool to call"`
+	Arguments string `json:"arguments" jsonschema_description:"Short sketch of the call arguments"`
+	Purpose   string `json:"purpose" jsonschema_description:"Why the call is needed"`
+}
+
+// Intended tool calls to answer a question, in order
+type Plan struct {
+	Steps []PlanStep `json:"steps" jsonschema_description:"Tool calls in order, empty if no tool is needed"`
+}
+
+var planSchema = lazyStrictSchema[Plan]()
+
+// Function names of the plan steps, in order
+func (p Plan) Tools() []string {
+	names := []string{}
+	for _, step := range p.Steps {
+		names = append(names, step.Tool)
+	}
+
+	return names
+}
+
+// Numbered description of the plan steps
+func (p Plan) String() string {
+	if len(p.Steps) == 0 {
+		return "No tool calls needed"
+	}
+
+	lines := []string{}
+	for i, step := range p.Steps {
+		lines = append(lines, fmt.Sprintf("%d. %s(%s): %s", i+1, step.Tool, step.Arguments, step.Purpose))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Plan the tool calls to answer a question with one structured output call, without running any tool.
+// toolDescriptions list the available tools. The planning span is a child of the span carried by parentCtx
+func (t *Toolbox) PlanRun(parentCtx context.Context, question string, toolDescriptions []string) (Plan, error) {
+	formattedPrompt := prompts.RenderPlan(prompts.PlanRequest{Question: question, Tools: toolDescriptions})
+
+	ctx, span := t.tracer.StartOpenInferenceSpan(parentCtx, "PlanRun", tracing.ChainKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	tracing.SetSpanInput(span, formattedPrompt)
+
+	// Start OpenAI manual tracing
+	llmCtx, llmSpan := t.tracer.StartOpenAISpan(ctx, t.config.Model)
+	defer llmSpan.End()
+
+	inputMessage := openai.F([]openai.ChatCompletionMessageParamUnion{
+		openai.UserMessage(formattedPrompt),
+	})
+
+	responseFormat := openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
+		openai.ResponseFormatJSONSchemaParam{
+			Type: openai.F(openai.ResponseFormatJSONSchemaTypeJSONSchema),
+			JSONSchema: openai.F(openai.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:        openai.F("plan"),
+				Description: openai.F("Tool calls intended to answer the question"),
+				Schema:      openai.F(planSchema()),
+				Strict:      openai.Bool(true),
+			}),
+		},
+	)
+
+	// Add input attributes to llm span
+	tracing.SetSpanAttrFromMap(llmSpan, map[string]any{
+		"llm.response_format": responseFormat.String(),
+	})
+	tracing.SetSpanInputMessages(llmSpan, inputMessage.Value)
+
+	response, err := t.completer.New(
+		llmCtx,
+		openai.ChatCompletionNewParams{
+			Model:          openai.F(t.config.Model),
+			Messages:       inputMessage,
+			ResponseFormat: responseFormat,
+		},
+	)
+
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		tracing.SetSpanErrorCode(span)
+		return Plan{}, &ErrLLMUnavailable{Err: err}
+	}
+
+	responseMessage, err := completion.Message(response)
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		tracing.SetSpanErrorCode(span)
+		return Plan{}, err
+	}
+
+	// Set llm span output attributes
+	tracing.SetSpanAttrFromMap(llmSpan, map[string]any{
+		"llm.token_count.prompt":     response.Usage.PromptTokens,
+		"llm.token_count.completion": response.Usage.CompletionTokens,
+		"llm.token_count.total":      response.Usage.TotalTokens,
+		"llm.tools":                  []string{},
+	})
+	tracing.SetSpanOutputMessages(llmSpan, []openai.ChatCompletionMessage{responseMessage})
+
+	plan := Plan{}
+	err = json.Unmarshal([]byte(cleanLlmBlockResponse(responseMessage.Content)), &plan)
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		tracing.SetSpanErrorCode(span)
+		return Plan{}, fmt.Errorf("failed to parse plan: %w", err)
+	}
+
+	tracing.SetSpanSuccessCode(llmSpan)
+	tracing.SetSpanOutput(span, plan.String())
+	tracing.SetSpanAttr(span, "plan.tools", plan.Tools())
+	tracing.SetSpanSuccessCode(span)
+	return plan, nil
+}
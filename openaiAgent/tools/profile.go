@@ -0,0 +1,449 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/language"
+	"github.com/EzequielGhR/goProjects/openaiAgent/prompts"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+)
+
+/*
+---------------
+Dataset profile
+---------------
+*/
+
+// Metadata table caching the profile of each dataset table, next to the dataset fingerprints
+const profileTableName = "dataset_profiles"
+
+// Most frequent values listed per categorical column
+const profileTopValues = 10
+
+// Column kinds of a profile, picking the statistics computed for it
+const (
+	profileNumeric     = "numeric"
+	profileDate        = "date"
+	profileCategorical = "categorical"
+)
+
+// Value of a categorical column and how many rows hold it
+type ValueCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// Statistics of a dataset column. Only the fields of its kind are set
+type ColumnProfile struct {
+	Name     string  `json:"name"`
+	Type     string  `json:"type"` // DuckDB column type
+	Kind     string  `json:"kind"` // numeric, date or categorical
+	NullRate float64 `json:"nullRate"`
+	Distinct int64   `json:"distinct"`
+
+	Min  float64 `json:"min,omitempty"`
+	Max  float64 `json:"max,omitempty"`
+	Mean float64 `json:"mean,omitempty"`
+	P25  float64 `json:"p25,omitempty"`
+	P50  float64 `json:"p50,omitempty"`
+	P75  float64 `json:"p75,omitempty"`
+	P95  float64 `json:"p95,omitempty"`
+
+	TopValues []ValueCount `json:"topValues,omitempty"`
+
+	FirstDate string `json:"firstDate,omitempty"`
+	LastDate  string `json:"lastDate,omitempty"`
+	DateDays  int64  `json:"dateDays,omitempty"` // Distinct days with rows
+	SpanDays  int64  `json:"spanDays,omitempty"` // Days between the first and last date, both included
+}
+
+// Overview of the dataset table, computed in DuckDB
+type DatasetProfile struct {
+	Table       string          `json:"table"`
+	Rows        int64           `json:"rows"`
+	Columns     []ColumnProfile `json:"columns"`
+	Narrative   string          `json:"narrative,omitempty"` // LLM summary of the profile, only when requested
+	Fingerprint string          `json:"fingerprint"`         // Data file content hash the profile was computed for
+	GeneratedAt string          `json:"generatedAt"`
+	Cached      bool            `json:"-"` // Loaded from the cache instead of computed
+}
+
+// Profile the dataset table, reusing the cached profile while the data file fingerprint is unchanged.
+// narrative asks the model for a one paragraph summary, cached along the profile.
+// The span is a child of the span carried by parentCtx
+func (t *Toolbox) ProfileDataset(parentCtx context.Context, narrative bool) (DatasetProfile, error) {
+	ctx, span := t.tracer.StartOpenInferenceSpan(parentCtx, "ProfileDatasetTool", tracing.ToolKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	tracing.SetSpanInput(span, tableName)
+
+	if err := t.authorizeDataset(ctx, "ProfileDataset", tableName); err != nil {
+		tracing.SetSpanErrorCode(span)
+		return DatasetProfile{}, err
+	}
+
+	// Profiles are cached on the database, backends without SQL compute them every time
+	db, sqlErr := t.store.SQL()
+	fingerprint := ""
+	profile, found := DatasetProfile{}, false
+	if sqlErr == nil {
+		fingerprint = cacheFingerprint(db)
+		profile, found = loadProfile(db, fingerprint)
+	}
+	if !found {
+		var err error
+		profile, err = t.store.Profile(ctx)
+		if err != nil {
+			tracing.SetSpanErrorCode(span)
+			return DatasetProfile{}, err
+		}
+		profile.Fingerprint = fingerprint
+	}
+
+	if narrative && profile.Narrative == "" {
+		summary, err := t.profileNarrative(ctx, profile)
+		if err != nil {
+			tracing.SetSpanErrorCode(span)
+			return DatasetProfile{}, err
+		}
+		profile.Narrative = summary
+		found = false
+	}
+
+	if !found && sqlErr == nil {
+		saveProfile(db, profile)
+	}
+
+	tracing.SetSpanAttrFromMap(span, map[string]any{
+		"profile.cached":  profile.Cached,
+		"profile.columns": len(profile.Columns),
+		"profile.rows":    int(profile.Rows),
+	})
+	tracing.SetSpanOutput(span, profile.Markdown())
+	tracing.SetSpanSuccessCode(span)
+	return profile, nil
+}
+
+// Compute the profile of a table, one query per column
+func profileTable(ctx context.Context, db *sql.DB, table string) (DatasetProfile, error) {
+	log.Printf("Profiling dataset '%s'\n", table)
+	profile := DatasetProfile{Table: table, Columns: []ColumnProfile{}, GeneratedAt: time.Now().UTC().Format(time.RFC3339)}
+
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", table)).Scan(&profile.Rows); err != nil {
+		return DatasetProfile{}, fmt.Errorf("failed to count rows of '%s': %w", table, err)
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT column_name, column_type FROM (DESCRIBE %s)", table))
+	if err != nil {
+		return DatasetProfile{}, fmt.Errorf("failed to describe '%s': %w", table, err)
+	}
+	for rows.Next() {
+		column := ColumnProfile{}
+		if err := rows.Scan(&column.Name, &column.Type); err != nil {
+			rows.Close()
+			return DatasetProfile{}, fmt.Errorf("failed to describe '%s': %w", table, err)
+		}
+		column.Kind = profileKind(column.Type)
+		profile.Columns = append(profile.Columns, column)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return DatasetProfile{}, fmt.Errorf("failed to describe '%s': %w", table, err)
+	}
+
+	for i := range profile.Columns {
+		if err := profileColumn(ctx, db, table, profile.Rows, &profile.Columns[i]); err != nil {
+			return DatasetProfile{}, fmt.Errorf("failed to profile column '%s': %w", profile.Columns[i].Name, err)
+		}
+	}
+
+	return profile, nil
+}
+
+// Kind of a DuckDB column type
+func profileKind(columnType string) string {
+	columnType = strings.ToUpper(columnType)
+	switch {
+	case strings.HasPrefix(columnType, "DATE"), strings.HasPrefix(columnType, "TIMESTAMP"):
+		return profileDate
+	case strings.Contains(columnType, "INT"), strings.HasPrefix(columnType, "DECIMAL"),
+		columnType == "DOUBLE", columnType == "FLOAT", columnType == "REAL":
+		return profileNumeric
+	}
+
+	return profileCategorical
+}
+
+// Compute the statistics of a column's kind
+func profileColumn(ctx context.Context, db *sql.DB, table string, rowCount int64, column *ColumnProfile) error {
+	name := fmt.Sprintf(`"%s"`, strings.ReplaceAll(column.Name, `"`, `""`))
+
+	nulls := int64(0)
+	err := db.QueryRowContext(
+		ctx,
+		fmt.Sprintf("SELECT count(*) - count(%[1]s), count(DISTINCT %[1]s) FROM %[2]s", name, table),
+	).Scan(&nulls, &column.Distinct)
+	if err != nil {
+		return err
+	}
+	if rowCount > 0 {
+		column.NullRate = float64(nulls) / float64(rowCount)
+	}
+
+	// Columns without values have no distribution
+	if nulls == rowCount {
+		return nil
+	}
+
+	switch column.Kind {
+	case profileNumeric:
+		return db.QueryRowContext(ctx, fmt.Sprintf(
+			`SELECT min(%[1]s)::DOUBLE, max(%[1]s)::DOUBLE, avg(%[1]s)::DOUBLE,
+				quantile_cont(%[1]s, 0.25)::DOUBLE, quantile_cont(%[1]s, 0.5)::DOUBLE,
+				quantile_cont(%[1]s, 0.75)::DOUBLE, quantile_cont(%[1]s, 0.95)::DOUBLE
+			FROM %[2]s`,
+			name, table,
+		)).Scan(&column.Min, &column.Max, &column.Mean, &column.P25, &column.P50, &column.P75, &column.P95)
+	case profileDate:
+		return db.QueryRowContext(ctx, fmt.Sprintf(
+			`SELECT min(%[1]s)::DATE::VARCHAR, max(%[1]s)::DATE::VARCHAR, count(DISTINCT %[1]s::DATE),
+				date_diff('day', min(%[1]s)::DATE, max(%[1]s)::DATE) + 1
+			FROM %[2]s`,
+			name, table,
+		)).Scan(&column.FirstDate, &column.LastDate, &column.DateDays, &column.SpanDays)
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(
+		`SELECT %[1]s::VARCHAR, count(*) AS amount FROM %[2]s WHERE %[1]s IS NOT NULL
+		GROUP BY %[1]s ORDER BY amount DESC, 1 LIMIT %[3]d`,
+		name, table, profileTopValues,
+	))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		value := ValueCount{}
+		if err := rows.Scan(&value.Value, &value.Count); err != nil {
+			return err
+		}
+		column.TopValues = append(column.TopValues, value)
+	}
+
+	return rows.Err()
+}
+
+// Ask the model for a one paragraph summary of a profile
+func (t *Toolbox) profileNarrative(ctx context.Context, profile DatasetProfile) (string, error) {
+	return t.profileCompletion(ctx, prompts.RenderProfileNarrative(prompts.ProfileNarrativeRequest{Profile: profile.Markdown()}))
+}
+
+// Ask the model a prompt about the profile, answered in the configured language
+func (t *Toolbox) profileCompletion(ctx context.Context, formattedPrompt string) (string, error) {
+	if instruction := language.Instruction(t.config.Language); instruction != "" {
+		formattedPrompt += instruction + "\n"
+	}
+
+	llmCtx, llmSpan := t.tracer.StartOpenAISpan(ctx, t.config.Model)
+	defer llmSpan.End()
+
+	inputMessage := openai.F([]openai.ChatCompletionMessageParamUnion{
+		openai.UserMessage(formattedPrompt),
+	})
+
+	tracing.SetSpanInputMessages(llmSpan, inputMessage.Value)
+
+	response, err := t.completer.New(
+		llmCtx,
+		openai.ChatCompletionNewParams{
+			Model:    openai.F(t.config.Model),
+			Messages: inputMessage,
+		},
+	)
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		return "", &ErrLLMUnavailable{Err: err}
+	}
+
+	responseMessage, err := completion.Message(response)
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		return "", err
+	}
+
+	tracing.SetSpanAttrFromMap(llmSpan, map[string]any{
+		"llm.token_count.prompt":     response.Usage.PromptTokens,
+		"llm.token_count.completion": response.Usage.CompletionTokens,
+		"llm.token_count.total":      response.Usage.TotalTokens,
+	})
+	tracing.SetSpanOutputMessages(llmSpan, []openai.ChatCompletionMessage{responseMessage})
+	tracing.SetSpanSuccessCode(llmSpan)
+
+	return strings.TrimSpace(responseMessage.Content), nil
+}
+
+/*
+-------------
+Profile cache
+-------------
+*/
+
+// Content hash of the data file the dataset table was loaded from, followed by its sample if any.
+// Empty when there is no fingerprint, like for remote files and live databases, which disables the cache
+func cacheFingerprint(db *sql.DB) string {
+	stored, found, err := loadFingerprint(db, tableName)
+	if err != nil || !found || stored.Hash == "" {
+		return ""
+	}
+
+	// Profiles of a sample don't describe the whole file
+	if stored.Sample != "" {
+		return stored.Hash + "@" + stored.Sample
+	}
+
+	return stored.Hash
+}
+
+// Read the cached profile of the dataset table. Returns false if there is none for fingerprint
+func loadProfile(db *sql.DB, fingerprint string) (DatasetProfile, bool) {
+	if fingerprint == "" {
+		return DatasetProfile{}, false
+	}
+
+	rawProfile := ""
+	err := db.QueryRow(
+		fmt.Sprintf("SELECT profile FROM %s WHERE table_name = ? AND fingerprint = ?", profileTableName),
+		tableName, fingerprint,
+	).Scan(&rawProfile)
+	if err != nil {
+		// A missing table is just an empty cache
+		if !errors.Is(err, sql.ErrNoRows) && !strings.Contains(err.Error(), "does not exist") {
+			log.Printf("WARNING: Failed to read cached profile: %s\n", err)
+		}
+		return DatasetProfile{}, false
+	}
+
+	profile := DatasetProfile{}
+	if err := json.Unmarshal([]byte(rawProfile), &profile); err != nil {
+		log.Printf("WARNING: Ignoring invalid cached profile: %s\n", err)
+		return DatasetProfile{}, false
+	}
+
+	log.Printf("Using cached profile of dataset '%s'\n", tableName)
+	profile.Cached = true
+	return profile, true
+}
+
+// Cache the profile of the dataset table. Failures are logged, the profile is still usable
+func saveProfile(db *sql.DB, profile DatasetProfile) {
+	if profile.Fingerprint == "" {
+		return
+	}
+
+	rawProfile, err := json.Marshal(profile)
+	if err == nil {
+		_, err = db.Exec(fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS %s (table_name VARCHAR PRIMARY KEY, fingerprint VARCHAR, profile VARCHAR)",
+			profileTableName,
+		))
+	}
+	if err == nil {
+		_, err = db.Exec(
+			fmt.Sprintf("INSERT OR REPLACE INTO %s VALUES (?, ?, ?)", profileTableName),
+			profile.Table, profile.Fingerprint, string(rawProfile),
+		)
+	}
+
+	if err != nil {
+		log.Printf("WARNING: Failed to cache profile: %s\n", err)
+	}
+}
+
+/*
+------------------
+Markdown rendering
+------------------
+*/
+
+// Render the profile as a Markdown document
+func (p DatasetProfile) Markdown() string {
+	builder := strings.Builder{}
+	fmt.Fprintf(&builder, "# Dataset profile: %s\n\n%d rows, %d columns.\n\n", p.Table, p.Rows, len(p.Columns))
+
+	builder.WriteString("| Column | Type | Kind | Null rate | Distinct |\n|---|---|---|---|---|\n")
+	for _, column := range p.Columns {
+		fmt.Fprintf(
+			&builder, "| %s | %s | %s | %.1f%% | %d |\n",
+			column.Name, column.Type, column.Kind, column.NullRate*100, column.Distinct,
+		)
+	}
+
+	if columns := p.columnsOfKind(profileNumeric); len(columns) > 0 {
+		builder.WriteString("\n## Numeric columns\n\n| Column | Min | Max | Mean | P25 | P50 | P75 | P95 |\n|---|---|---|---|---|---|---|---|\n")
+		for _, column := range columns {
+			values := []string{column.Name}
+			for _, value := range []float64{column.Min, column.Max, column.Mean, column.P25, column.P50, column.P75, column.P95} {
+				values = append(values, strconv.FormatFloat(math.Round(value*1e4)/1e4, 'f', -1, 64))
+			}
+			fmt.Fprintf(&builder, "| %s |\n", strings.Join(values, " | "))
+		}
+	}
+
+	if columns := p.columnsOfKind(profileDate); len(columns) > 0 {
+		builder.WriteString("\n## Date columns\n\n| Column | First | Last | Days with rows | Coverage |\n|---|---|---|---|---|\n")
+		for _, column := range columns {
+			coverage := 0.0
+			if column.SpanDays > 0 {
+				coverage = float64(column.DateDays) / float64(column.SpanDays) * 100
+			}
+			fmt.Fprintf(
+				&builder, "| %s | %s | %s | %d of %d | %.1f%% |\n",
+				column.Name, column.FirstDate, column.LastDate, column.DateDays, column.SpanDays, coverage,
+			)
+		}
+	}
+
+	if columns := p.columnsOfKind(profileCategorical); len(columns) > 0 {
+		builder.WriteString("\n## Categorical columns\n")
+		for _, column := range columns {
+			fmt.Fprintf(&builder, "\n### %s\n\n| Value | Rows | Share |\n|---|---|---|\n", column.Name)
+			for _, value := range column.TopValues {
+				share := 0.0
+				if p.Rows > 0 {
+					share = float64(value.Count) / float64(p.Rows) * 100
+				}
+				fmt.Fprintf(&builder, "| %s | %d | %.1f%% |\n", value.Value, value.Count, share)
+			}
+		}
+	}
+
+	if p.Narrative != "" {
+		fmt.Fprintf(&builder, "\n## Summary\n\n%s\n", p.Narrative)
+	}
+
+	return builder.String()
+}
+
+// Columns of a kind, in table order
+func (p DatasetProfile) columnsOfKind(kind string) []ColumnProfile {
+	columns := []ColumnProfile{}
+	for _, column := range p.Columns {
+		if column.Kind == kind {
+			columns = append(columns, column)
+		}
+	}
+
+	return columns
+}
@@ -0,0 +1,353 @@
+package tools
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+------------------
+Artifact publisher
+------------------
+*/
+
+// Environment variables configuring where artifacts are published. Without ArtifactPublishURLEnv artifacts stay local
+const (
+	ArtifactPublishURLEnv = "ARTIFACT_PUBLISH_URL"     // s3://bucket/prefix, or file:///dir for a local copy
+	ArtifactS3EndpointEnv = "ARTIFACT_S3_ENDPOINT"     // S3 compatible endpoint, like http://localhost:9000. Defaults to AWS
+	ArtifactPublicURLEnv  = "ARTIFACT_PUBLIC_BASE_URL" // Base URL of public objects. Without it presigned URLs are returned
+	ArtifactURLExpiryEnv  = "ARTIFACT_URL_EXPIRY"      // Lifetime of presigned URLs, like 24h. At most 7 days
+)
+
+// Lifetime of presigned artifact URLs when ArtifactURLExpiryEnv isn't set
+const DefaultArtifactURLExpiry = 24 * time.Hour
+
+// Longest lifetime S3 accepts for a presigned URL
+const maxArtifactURLExpiry = 7 * 24 * time.Hour
+
+// Copies run artifacts to storage that outlives the run directory, like object storage on server deployments
+type ArtifactPublisher interface {
+	// Upload the file at localPath, returning the URL it can be downloaded from
+	Publish(ctx context.Context, localPath string, contentType string) (string, error)
+}
+
+// Publisher configured from ArtifactPublishURLEnv and the variables next to it. Nil when it's unset
+func NewPublisherFromEnv() (ArtifactPublisher, error) {
+	publishURL := strings.TrimSpace(os.Getenv(ArtifactPublishURLEnv))
+	if publishURL == "" {
+		return nil, nil
+	}
+
+	parsed, err := url.Parse(publishURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ArtifactPublishURLEnv, err)
+	}
+
+	switch parsed.Scheme {
+	case "file":
+		return NewDirPublisher(parsed.Path), nil
+	case "s3":
+		return newS3PublisherFromEnv(parsed.Host, strings.Trim(parsed.Path, "/"))
+	}
+
+	return nil, fmt.Errorf("%s must be an s3:// or file:// URL, not '%s'", ArtifactPublishURLEnv, publishURL)
+}
+
+// Object key of an artifact: the run directory and file name, under prefix
+func artifactKey(prefix string, localPath string) string {
+	return path.Join(prefix, filepath.Base(filepath.Dir(localPath)), filepath.Base(localPath))
+}
+
+// Content type of an artifact from its extension
+func ArtifactContentType(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return "text/csv"
+	case ".py":
+		return "text/x-python"
+	case ".json":
+		return "application/json"
+	}
+
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// Publish an artifact of the run, traced as a client span. Returns its URL, or an empty string
+// if there's no publisher or the upload failed, so callers keep the local path
+func (t *Toolbox) PublishArtifact(ctx context.Context, name string) string {
+	if t.config.Publisher == nil {
+		return ""
+	}
+
+	publishedURL, err := PublishTraced(ctx, t.tracer, t.config.Publisher, filepath.Join(t.RunDir, name))
+	if err != nil {
+		log.Printf("WARNING: Keeping artifact '%s' local: %s\n", name, err)
+		return ""
+	}
+
+	t.artifactURLs[name] = publishedURL
+	return publishedURL
+}
+
+// URLs of the artifacts published on the run, keyed by their name
+func (t *Toolbox) ArtifactURLs() map[string]string {
+	urls := map[string]string{}
+	maps.Copy(urls, t.artifactURLs)
+	return urls
+}
+
+// Publish a file with publisher on a client span
+func PublishTraced(ctx context.Context, tracer *tracing.Tracer, publisher ArtifactPublisher, localPath string) (string, error) {
+	ctx, span := tracer.StartOpenInferenceSpan(ctx, "PublishArtifact", tracing.ChainKind, trace.WithSpanKind(trace.SpanKindClient))
+	defer tracing.EndOpenInferenceSpan(span)
+
+	contentType := ArtifactContentType(localPath)
+	tracing.SetSpanAttrFromMap(span, map[string]any{
+		"artifact.name":         filepath.Base(localPath),
+		"artifact.content_type": contentType,
+	})
+	tracing.SetSpanInput(span, localPath)
+
+	publishedURL, err := publisher.Publish(ctx, localPath, contentType)
+	if err != nil {
+		tracing.SetSpanAttr(span, "artifact.error", err.Error())
+		tracing.SetSpanErrorCode(span)
+		return "", fmt.Errorf("failed to publish '%s': %w", filepath.Base(localPath), err)
+	}
+
+	// Presigned URLs hold a signature, only the object location is traced
+	tracing.SetSpanOutput(span, strings.SplitN(publishedURL, "?", 2)[0])
+	tracing.SetSpanSuccessCode(span)
+	return publishedURL, nil
+}
+
+/*
+-------------
+Dir publisher
+-------------
+*/
+
+// Publisher copying artifacts into a local directory, standing in for object storage
+type DirPublisher struct {
+	dir string
+}
+
+// Publisher copying artifacts under dir, keyed like the S3 publisher
+func NewDirPublisher(dir string) *DirPublisher {
+	return &DirPublisher{dir: dir}
+}
+
+func (p *DirPublisher) Publish(ctx context.Context, localPath string, contentType string) (string, error) {
+	content, err := os.ReadFile(localPath)
+	if err != nil {
+		return "", err
+	}
+
+	publishedPath := filepath.Join(p.dir, filepath.FromSlash(artifactKey("", localPath)))
+	if err := os.MkdirAll(filepath.Dir(publishedPath), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(publishedPath, content, 0o644); err != nil {
+		return "", err
+	}
+
+	return (&url.URL{Scheme: "file", Path: publishedPath}).String(), nil
+}
+
+/*
+------------
+S3 publisher
+------------
+*/
+
+// Publisher uploading artifacts to an S3 compatible bucket with presigned requests, addressed path style
+type S3Publisher struct {
+	Bucket        string
+	Prefix        string        // Key prefix of the artifacts
+	Endpoint      string        // Scheme and host of the storage
+	Region        string        // Signing region
+	AccessKey     string        // Access key ID
+	SecretKey     string        // Secret access key
+	SessionToken  string        // Session token of temporary credentials, if any
+	PublicBaseURL string        // Base URL of public objects. Empty returns presigned URLs
+	Expiry        time.Duration // Lifetime of presigned URLs
+	Client        *http.Client
+}
+
+// S3 publisher of bucket, with the endpoint, region and credentials of the environment
+func newS3PublisherFromEnv(bucket string, prefix string) (*S3Publisher, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("%s has no bucket", ArtifactPublishURLEnv)
+	}
+
+	publisher := &S3Publisher{
+		Bucket:        bucket,
+		Prefix:        prefix,
+		Endpoint:      strings.TrimRight(os.Getenv(ArtifactS3EndpointEnv), "/"),
+		Region:        os.Getenv("AWS_REGION"),
+		AccessKey:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:     os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:  os.Getenv("AWS_SESSION_TOKEN"),
+		PublicBaseURL: strings.TrimRight(os.Getenv(ArtifactPublicURLEnv), "/"),
+		Expiry:        DefaultArtifactURLExpiry,
+		Client:        &http.Client{Timeout: time.Minute},
+	}
+	if publisher.AccessKey == "" || publisher.SecretKey == "" {
+		return nil, fmt.Errorf("publishing to s3://%s needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY", bucket)
+	}
+	if publisher.Region == "" {
+		publisher.Region = "us-east-1"
+	}
+	if publisher.Endpoint == "" {
+		publisher.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", publisher.Region)
+	}
+
+	if expiry := os.Getenv(ArtifactURLExpiryEnv); expiry != "" {
+		parsed, err := time.ParseDuration(expiry)
+		if err != nil || parsed <= 0 || parsed > maxArtifactURLExpiry {
+			return nil, fmt.Errorf("%s must be a duration up to %s, not '%s'", ArtifactURLExpiryEnv, maxArtifactURLExpiry, expiry)
+		}
+		publisher.Expiry = parsed
+	}
+
+	return publisher, nil
+}
+
+func (p *S3Publisher) Publish(ctx context.Context, localPath string, contentType string) (string, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	key := artifactKey(p.Prefix, localPath)
+	uploadURL, err := p.presign(http.MethodPut, key, 15*time.Minute, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, uploadURL, file)
+	if err != nil {
+		return "", err
+	}
+	request.ContentLength = info.Size()
+	request.Header.Set("Content-Type", contentType)
+
+	// Request errors quote the URL, the signature of the presigned one is left out of them
+	response, err := p.Client.Do(request)
+	if urlErr, ok := err.(*url.Error); ok {
+		return "", fmt.Errorf("upload of '%s' failed: %w", key, urlErr.Err)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return "", fmt.Errorf("upload of '%s' failed with %s: %s", key, response.Status, strings.TrimSpace(string(body)))
+	}
+
+	if p.PublicBaseURL != "" {
+		return p.PublicBaseURL + "/" + s3Escape(key, false), nil
+	}
+	return p.presign(http.MethodGet, key, p.Expiry, time.Now())
+}
+
+// URL of a request on key signed with AWS signature version 4 query parameters, valid for expiry
+func (p *S3Publisher) presign(method string, key string, expiry time.Duration, now time.Time) (string, error) {
+	endpoint, err := url.Parse(p.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return "", fmt.Errorf("invalid S3 endpoint '%s'", p.Endpoint)
+	}
+
+	now = now.UTC()
+	date := now.Format("20060102")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, p.Region)
+
+	query := map[string]string{
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    p.AccessKey + "/" + scope,
+		"X-Amz-Date":          now.Format("20060102T150405Z"),
+		"X-Amz-Expires":       fmt.Sprint(int(expiry.Seconds())),
+		"X-Amz-SignedHeaders": "host",
+	}
+	if p.SessionToken != "" {
+		query["X-Amz-Security-Token"] = p.SessionToken
+	}
+	canonicalQuery := []string{}
+	for _, name := range slices.Sorted(maps.Keys(query)) {
+		canonicalQuery = append(canonicalQuery, s3Escape(name, true)+"="+s3Escape(query[name], true))
+	}
+
+	canonicalPath := "/" + s3Escape(p.Bucket, true) + "/" + s3Escape(key, false)
+	canonicalRequest := strings.Join([]string{
+		method, canonicalPath, strings.Join(canonicalQuery, "&"),
+		"host:" + endpoint.Host + "\n", "host", "UNSIGNED-PAYLOAD",
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256", query["X-Amz-Date"], scope, hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	signingKey := []byte("AWS4" + p.SecretKey)
+	for _, part := range []string{date, p.Region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	return fmt.Sprintf("%s://%s%s?%s&X-Amz-Signature=%s", endpoint.Scheme, endpoint.Host, canonicalPath, strings.Join(canonicalQuery, "&"), signature), nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Escape a value the way AWS signatures expect: every byte but unreserved characters, and slashes unless escapeSlash is false
+func s3Escape(value string, escapeSlash bool) string {
+	escaped := strings.Builder{}
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			escaped.WriteByte(b)
+		case b == '/' && !escapeSlash:
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+
+	return escaped.String()
+}
+
+// Restore the URLs of the artifacts published before a run was resumed
+func (t *Toolbox) RestoreArtifactURLs(urls map[string]string) {
+	maps.Copy(t.artifactURLs, urls)
+}
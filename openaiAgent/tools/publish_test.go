@@ -0,0 +1,238 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Environment variables read by NewPublisherFromEnv, cleared on every case
+var publisherEnv = []string{
+	ArtifactPublishURLEnv, ArtifactS3EndpointEnv, ArtifactPublicURLEnv, ArtifactURLExpiryEnv,
+	"AWS_REGION", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN",
+}
+
+// Publishers configured from the environment: none, a local directory, or S3 with its credentials
+func TestNewPublisherFromEnv(t *testing.T) {
+	credentials := map[string]string{"AWS_ACCESS_KEY_ID": "key", "AWS_SECRET_ACCESS_KEY": "secret"}
+	cases := []struct {
+		name    string
+		env     map[string]string
+		want    string // Type of the publisher, empty for none
+		wantErr string
+	}{
+		{name: "unset"},
+		{name: "directory", env: map[string]string{ArtifactPublishURLEnv: "file:///tmp/artifacts"}, want: "*tools.DirPublisher"},
+		{name: "s3", env: map[string]string{ArtifactPublishURLEnv: "s3://reports/runs"}, want: "*tools.S3Publisher"},
+		{name: "s3 without credentials", env: map[string]string{ArtifactPublishURLEnv: "s3://reports", "AWS_ACCESS_KEY_ID": ""}, wantErr: "needs AWS_ACCESS_KEY_ID"},
+		{name: "s3 without bucket", env: map[string]string{ArtifactPublishURLEnv: "s3:///runs"}, wantErr: "has no bucket"},
+		{name: "expiry over a week", env: map[string]string{ArtifactPublishURLEnv: "s3://reports", ArtifactURLExpiryEnv: "200h"}, wantErr: ArtifactURLExpiryEnv + " must be a duration"},
+		{name: "other scheme", env: map[string]string{ArtifactPublishURLEnv: "gs://reports"}, wantErr: "must be an s3:// or file:// URL"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, name := range publisherEnv {
+				value, ok := c.env[name]
+				if !ok {
+					value = credentials[name]
+				}
+				t.Setenv(name, value)
+			}
+
+			publisher, err := NewPublisherFromEnv()
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("expected error containing '%s', got %v", c.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.want == "" {
+				if publisher != nil {
+					t.Fatalf("expected no publisher, got a %T", publisher)
+				}
+				return
+			}
+			if got := fmt.Sprintf("%T", publisher); got != c.want {
+				t.Fatalf("expected a %s, got a %s", c.want, got)
+			}
+
+			// S3 defaults to the AWS endpoint of the default region
+			if s3, ok := publisher.(*S3Publisher); ok && (s3.Region != "us-east-1" || s3.Endpoint != "https://s3.us-east-1.amazonaws.com" || s3.Prefix != "runs") {
+				t.Errorf("S3 publisher on region %s, endpoint %s and prefix %s", s3.Region, s3.Endpoint, s3.Prefix)
+			}
+		})
+	}
+}
+
+// Artifacts are published after being written, on a client span, and the lookup result points at their URL.
+// A failed upload keeps the local path
+func TestPublishArtifact(t *testing.T) {
+	cases := []struct {
+		name      string
+		publisher func(t *testing.T) ArtifactPublisher
+		uploads   int // Traced uploads
+		published bool
+	}{
+		{name: "no publisher", publisher: func(t *testing.T) ArtifactPublisher { return nil }},
+		{name: "directory", publisher: func(t *testing.T) ArtifactPublisher { return NewDirPublisher(t.TempDir()) }, uploads: 1, published: true},
+		{
+			name: "failed upload",
+			publisher: func(t *testing.T) ArtifactPublisher {
+				// The directory can't be created under a file
+				blocker := filepath.Join(t.TempDir(), "blocker")
+				if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return NewDirPublisher(blocker)
+			},
+			uploads: 1,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			store, err := openFixtureStore(t, StorageDuckDB, "sales.parquet")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tracer, recorder := tracing.NewRecordingTracer()
+			completer := mock.NewCompleter(mock.TextResponse("SELECT DISTINCT Store_Number FROM sales ORDER BY Store_Number"))
+			toolbox := NewToolbox(Config{OutputDir: t.TempDir(), Publisher: c.publisher(t)}, completer, store, tracer, nil)
+			if _, err := toolbox.StartRun("publish"); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := toolbox.LookUpSalesData(context.Background(), "Stores with sales")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			urls := toolbox.ArtifactURLs()
+			publishedURL := urls["lookup-1.csv"]
+			if (publishedURL != "") != c.published || (len(urls) == 1) != c.published {
+				t.Fatalf("published URLs %v, expected lookup-1.csv published %t", urls, c.published)
+			}
+			if strings.Contains(result, "# Published run artifact: ") != c.published {
+				t.Errorf("lookup result doesn't point at the published artifact as expected:\n%s", result)
+			}
+
+			uploads := 0
+			for _, span := range recorder.Ended() {
+				if span.Name() == "PublishArtifact" {
+					uploads++
+					if span.SpanKind() != trace.SpanKindClient {
+						t.Errorf("upload traced as a %s span, expected a client one", span.SpanKind())
+					}
+				}
+			}
+			if uploads != c.uploads {
+				t.Errorf("traced %d uploads, expected %d", uploads, c.uploads)
+			}
+			if !c.published {
+				return
+			}
+
+			parsed, err := url.Parse(publishedURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			published, err := os.ReadFile(parsed.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			local, err := os.ReadFile(filepath.Join(toolbox.RunDir, "lookup-1.csv"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(published) != string(local) || filepath.Base(filepath.Dir(parsed.Path)) != filepath.Base(toolbox.RunDir) {
+				t.Errorf("published %s isn't the artifact of the run", parsed.Path)
+			}
+		})
+	}
+}
+
+// The S3 publisher uploads with a presigned PUT and returns a presigned or public URL of the object.
+// Failed uploads don't leak the signature
+func TestS3Publisher(t *testing.T) {
+	cases := []struct {
+		name          string
+		status        int
+		publicBaseURL string
+		want          string // Start of the returned URL, after the server URL when it isn't public
+		wantErr       string
+	}{
+		{name: "presigned", status: http.StatusOK, want: "/reports/runs/run-1/lookup-1.csv?X-Amz-Algorithm=AWS4-HMAC-SHA256"},
+		{name: "public", status: http.StatusOK, publicBaseURL: "https://cdn.example.com/", want: "https://cdn.example.com/runs/run-1/lookup-1.csv"},
+		{name: "rejected", status: http.StatusForbidden, wantErr: "upload of 'runs/run-1/lookup-1.csv' failed with 403 Forbidden: AccessDenied"},
+	}
+
+	localPath := filepath.Join(t.TempDir(), "run-1", "lookup-1.csv")
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(localPath, []byte("Store_Number\n110\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			uploaded := ""
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if r.Method != http.MethodPut || r.URL.Path != "/reports/runs/run-1/lookup-1.csv" || r.Header.Get("Content-Type") != "text/csv" {
+					t.Errorf("unexpected %s of %s as %s", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+				}
+				if r.URL.Query().Get("X-Amz-Signature") == "" || !strings.HasPrefix(r.URL.Query().Get("X-Amz-Credential"), "key/") {
+					t.Errorf("upload isn't presigned: %s", r.URL.RawQuery)
+				}
+				uploaded = string(body)
+				w.WriteHeader(c.status)
+				if c.status != http.StatusOK {
+					w.Write([]byte("AccessDenied"))
+				}
+			}))
+			defer server.Close()
+
+			publisher := &S3Publisher{
+				Bucket: "reports", Prefix: "runs", Endpoint: server.URL, Region: "us-east-1", AccessKey: "key", SecretKey: "secret",
+				PublicBaseURL: strings.TrimRight(c.publicBaseURL, "/"), Expiry: DefaultArtifactURLExpiry, Client: server.Client(),
+			}
+			publishedURL, err := publisher.Publish(context.Background(), localPath, ArtifactContentType(localPath))
+			if c.wantErr != "" {
+				if err == nil || err.Error() != c.wantErr {
+					t.Fatalf("expected error '%s', got %v", c.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if uploaded != "Store_Number\n110\n" {
+				t.Errorf("uploaded %q", uploaded)
+			}
+			want := c.want
+			if c.publicBaseURL == "" {
+				want = server.URL + want
+			}
+			if !strings.HasPrefix(publishedURL, want) {
+				t.Errorf("published at %s, expected %s", publishedURL, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package tools
+
+import (
+	"context"
+	"math"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+---------------------------
+Rate limited chat completer
+---------------------------
+*/
+
+// Chat completer acquiring rate limiter budget before every completion it forwards
+type rateLimitedCompleter struct {
+	completer ChatCompleter
+	limiter   *completion.RateLimiter
+}
+
+// Wrap a completer so completions wait for budget on limiter. Nil limiters return the completer as is
+func NewRateLimitedCompleter(completer ChatCompleter, limiter *completion.RateLimiter) ChatCompleter {
+	if limiter == nil {
+		return completer
+	}
+
+	return &rateLimitedCompleter{completer: completer, limiter: limiter}
+}
+
+func (r *rateLimitedCompleter) New(
+	ctx context.Context,
+	body openai.ChatCompletionNewParams,
+	opts ...option.RequestOption,
+) (*openai.ChatCompletion, error) {
+	estimate := completion.EstimateTokens(body)
+	waited, err := r.limiter.Acquire(ctx, estimate)
+	if err != nil {
+		return nil, err
+	}
+
+	// Waits are recorded on the LLM span started for this completion, and on the timings of its run
+	span := trace.SpanFromContext(ctx)
+	if waited > 0 {
+		TimingsFrom(ctx).Add(TimingRateLimitWait, waited)
+		tracing.AddSpanEvent(span, "rate_limit.wait", map[string]any{
+			"rate_limit.wait_ms":          int(waited.Milliseconds()),
+			"rate_limit.estimated_tokens": estimate,
+		})
+	}
+
+	response, err := r.completer.New(ctx, body, opts...)
+
+	// Failed requests may still have used tokens, keep the estimate for them
+	actual := estimate
+	if err == nil && response != nil {
+		actual = int(response.Usage.TotalTokens)
+	}
+	r.limiter.Reconcile(estimate, actual)
+
+	tracing.SetSpanAttr(span, "llm.rate_limit.saturation_pct", int(math.Round(r.limiter.Stats().Saturation*100)))
+	return response, err
+}
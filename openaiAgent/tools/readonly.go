@@ -0,0 +1,80 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+/*
+--------------
+Read-only mode
+--------------
+*/
+
+// DuckDB option opening a database without writes
+const readOnlyAccessMode = "access_mode=READ_ONLY"
+
+// DSN of a database file or live database opened read-only
+func readOnlyDSN(dsn string) string {
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+
+	return dsn + separator + readOnlyAccessMode
+}
+
+// Whether the connections of db can't write, read from the DuckDB access mode
+func IsReadOnly(ctx context.Context, db *sql.DB) (bool, error) {
+	mode := ""
+	if err := db.QueryRowContext(ctx, "SELECT current_setting('access_mode')").Scan(&mode); err != nil {
+		return false, fmt.Errorf("failed to read the database access mode: %w", err)
+	}
+
+	return strings.EqualFold(mode, "read_only"), nil
+}
+
+// Open the database read-only, after bootstrapping the dataset on a preparatory read-write connection
+// that's closed first. Live databases are opened read-only right away. Returns whether the table was reloaded
+func openReadOnlyDatabase(databasePath string, dataPath string, sample Sample, forceRefresh bool) (*sql.DB, bool, error) {
+	if IsLiveDatabase(dataPath) {
+		db, err := openLiveDatabase(readOnlyDSN(dataPath))
+		return db, false, err
+	}
+	if databasePath == "" {
+		return nil, false, fmt.Errorf("read-only mode needs a database file, in memory databases can't be opened read-only")
+	}
+
+	log.Printf("Bootstrapping database at %s before opening it read-only\n", databasePath)
+	db, err := sql.Open("duckdb", databasePath)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open database: %w", err)
+	}
+	refreshed, err := bootstrapDataset(db, dataPath, sample, forceRefresh)
+	db.Close()
+	if err != nil {
+		return nil, false, err
+	}
+
+	db, err = OpenReadOnlyDatabase(databasePath, dataPath)
+	return db, refreshed, err
+}
+
+// Open a database built before read-only, without bootstrapping it. Live databases are opened read-only too.
+// Fails when the database file doesn't exist yet
+func OpenReadOnlyDatabase(databasePath string, dataPath string) (*sql.DB, error) {
+	if IsLiveDatabase(dataPath) {
+		return openLiveDatabase(readOnlyDSN(dataPath))
+	}
+
+	log.Printf("Opening database at %s read-only\n", databasePath)
+	db, err := sql.Open("duckdb", readOnlyDSN(databasePath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s read-only, was it built by a run yet? %w", databasePath, err)
+	}
+
+	return db, nil
+}
@@ -0,0 +1,117 @@
+package tools
+
+import (
+	"context"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/openai/openai-go"
+)
+
+// Response refusing to answer
+func refusalResponse(refusal string) *openai.ChatCompletion {
+	return &openai.ChatCompletion{Choices: []openai.ChatCompletionChoice{{
+		Message:      openai.ChatCompletionMessage{Refusal: refusal},
+		FinishReason: openai.ChatCompletionChoicesFinishReasonStop,
+	}}}
+}
+
+// Response stopped by the content filter
+func filteredResponse() *openai.ChatCompletion {
+	return &openai.ChatCompletion{Choices: []openai.ChatCompletionChoice{{
+		FinishReason: openai.ChatCompletionChoicesFinishReasonContentFilter,
+	}}}
+}
+
+// Refusals, filtered content and empty choices fail the tools with non retryable errors instead of empty
+// results. Only the chart config has a fallback, the inferred config, and the chart is still made from it
+func TestToolRefusals(t *testing.T) {
+	chartCode := mock.TextResponse("```python\nplt.savefig('chart.png')\n```")
+	cases := []struct {
+		name      string
+		responses []*openai.ChatCompletion
+		call      func(toolbox *Toolbox) error
+		errClass  string // Empty when the tool succeeds anyway
+	}{
+		{
+			name:      "lookup query refused",
+			responses: []*openai.ChatCompletion{refusalResponse("I can't write that query.")},
+			call: func(toolbox *Toolbox) error {
+				_, err := toolbox.Lookup(context.Background(), LookupRequest{Prompt: "Total sales value"})
+				return err
+			},
+			errClass: completion.ErrorClassModelRefusal,
+		},
+		{
+			name:      "lookup without choices",
+			responses: []*openai.ChatCompletion{{}},
+			call: func(toolbox *Toolbox) error {
+				_, err := toolbox.Lookup(context.Background(), LookupRequest{Prompt: "Total sales value"})
+				return err
+			},
+			errClass: completion.ErrorClassNoChoices,
+		},
+		{
+			name:      "analysis refused",
+			responses: []*openai.ChatCompletion{refusalResponse("I can't help with analyzing this data.")},
+			call: func(toolbox *Toolbox) error {
+				_, err := toolbox.Analyze(context.Background(), AnalyzeRequest{Question: "Which store sold the most?", Data: typedToolData})
+				return err
+			},
+			errClass: completion.ErrorClassModelRefusal,
+		},
+		{
+			name:      "analysis filtered",
+			responses: []*openai.ChatCompletion{filteredResponse()},
+			call: func(toolbox *Toolbox) error {
+				_, err := toolbox.Analyze(context.Background(), AnalyzeRequest{Question: "Which store sold the most?", Data: typedToolData})
+				return err
+			},
+			errClass: completion.ErrorClassContentFilter,
+		},
+		{
+			name:      "chart config refused",
+			responses: []*openai.ChatCompletion{refusalResponse("I can't configure that chart."), chartCode},
+			call: func(toolbox *Toolbox) error {
+				result, err := toolbox.Visualize(context.Background(), VisualizeRequest{Data: typedToolData, Goal: "Sales per store"})
+				if err == nil && result.Config.ChartType == "" {
+					t.Error("the refused chart config wasn't replaced by the inferred one")
+				}
+				return err
+			},
+		},
+		{
+			name:      "chart code filtered",
+			responses: []*openai.ChatCompletion{mock.TextResponse(`{"chartType": "bar", "xAxis": "Store_Number", "yAxis": "Total_Sale_Value", "title": "Sales per store", "binCount": 0, "bucketBy": ""}`), filteredResponse()},
+			call: func(toolbox *Toolbox) error {
+				_, err := toolbox.Visualize(context.Background(), VisualizeRequest{Data: typedToolData, Goal: "Sales per store"})
+				return err
+			},
+			errClass: completion.ErrorClassContentFilter,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			toolbox, completer := newTypedToolbox(t, c.responses...)
+
+			err := c.call(toolbox)
+			if len(completer.Requests) != len(c.responses) {
+				t.Fatalf("tool made %d completions, expected %d", len(completer.Requests), len(c.responses))
+			}
+			if c.errClass == "" {
+				if err != nil {
+					t.Fatalf("expected the tool to succeed, got: %s", err)
+				}
+				return
+			}
+			if ErrorClass(err) != c.errClass {
+				t.Fatalf("expected a %s error, got %v", c.errClass, err)
+			}
+			if IsRetryable(err) {
+				t.Errorf("%s errors must not be retried", c.errClass)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package tools
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+/*
+---------------------------
+Remote parquet data support
+---------------------------
+*/
+
+// Schemes that DuckDB reads through the httpfs extension
+var remoteSchemes = []string{"s3://", "gs://", "gcs://", "https://", "http://"}
+
+// S3 settings applied to DuckDB from the first defined environment variable of each list
+var s3SettingsFromEnv = map[string][]string{
+	"s3_region":            {"S3_REGION", "AWS_REGION"},
+	"s3_endpoint":          {"S3_ENDPOINT"},
+	"s3_access_key_id":     {"AWS_ACCESS_KEY_ID"},
+	"s3_secret_access_key": {"AWS_SECRET_ACCESS_KEY"},
+	"s3_session_token":     {"AWS_SESSION_TOKEN"},
+}
+
+// Check if a data path points to a remote location instead of a local file
+func IsRemotePath(dataPath string) bool {
+	for _, scheme := range remoteSchemes {
+		if strings.HasPrefix(strings.ToLower(dataPath), scheme) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Install and load the httpfs extension, then configure S3 credentials from environment variables
+func loadRemoteExtensions(db *sql.DB) error {
+	log.Println("Installing and loading httpfs extension")
+	if _, err := db.Exec("INSTALL httpfs"); err != nil {
+		return fmt.Errorf(
+			"failed to install the httpfs extension, it must be downloadable or already installed when offline: %w",
+			err,
+		)
+	}
+
+	if _, err := db.Exec("LOAD httpfs"); err != nil {
+		return fmt.Errorf("failed to load the httpfs extension: %w", err)
+	}
+
+	for setting, envVars := range s3SettingsFromEnv {
+		envVar, value := "", ""
+		for _, name := range envVars {
+			if value = os.Getenv(name); value != "" {
+				envVar = name
+				break
+			}
+		}
+
+		if value == "" {
+			continue
+		}
+
+		log.Printf("Setting '%s' from '%s'\n", setting, envVar)
+		if _, err := db.Exec(fmt.Sprintf("SET %s = '%s'", setting, strings.ReplaceAll(value, "'", "''"))); err != nil {
+			return fmt.Errorf("failed to set '%s': %w", setting, err)
+		}
+	}
+
+	return nil
+}
+
+// Lightweight check that remote data can be read, used instead of os.Stat for remote paths
+func probeRemoteDataPath(dataPath string) error {
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		return fmt.Errorf("failed to open in memory database: %w", err)
+	}
+	defer db.Close()
+
+	if err := loadRemoteExtensions(db); err != nil {
+		return err
+	}
+
+	count := 0
+	err = db.QueryRow(
+		fmt.Sprintf("SELECT count(*) FROM (SELECT * FROM read_parquet('%s') LIMIT 1)", dataPath),
+	).Scan(&count)
+
+	if err != nil {
+		return fmt.Errorf("failed to read remote parquet data at %s: %w", dataPath, err)
+	}
+
+	return nil
+}
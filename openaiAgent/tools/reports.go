@@ -0,0 +1,428 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+)
+
+/*
+-------------
+Named reports
+-------------
+*/
+
+// Name of the report runs on spans and audit entries
+const RunReportName = "RunReport"
+
+// Report names double as file names
+var reportNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// Chart saved under a name to be rendered again on fresh data, without any completion
+type Report struct {
+	Name         string      `json:"name"`
+	Goal         string      `json:"goal"` // Visualization goal the chart was made for
+	SQL          string      `json:"sql"`  // Lookup query producing the chart data
+	Config       ChartConfig `json:"config"`
+	Theme        string      `json:"theme,omitempty"` // Chart theme name, empty for the configured one
+	Columns      []string    `json:"columns"`         // Result columns when saved
+	TableColumns []string    `json:"tableColumns"`    // Dataset columns when saved, to explain schema drift
+	RunID        string      `json:"runId"`           // Run the chart was made on
+	CreatedAt    string      `json:"createdAt"`
+}
+
+// Rendering of a report on the current data
+type ReportResult struct {
+	Report       Report
+	Columns      []string
+	Rows         [][]any
+	DataPath     string // CSV artifact of the data, relative to the run directory
+	DataURL      string // URL the CSV artifact was published at, empty if it's only local
+	Code         string // Python chart code reading the CSV artifact
+	ArtifactPath string // Python artifact of the code, relative to the run directory
+	ArtifactURL  string // URL the Python artifact was published at, empty if it's only local
+	SpecPath     string // Chart spec artifact next to the code, relative to the run directory
+}
+
+// The data changed since a report was saved and its query or chart no longer fit it
+type ErrReportSchemaDrift struct {
+	Report    string
+	Missing   []string // Columns the report uses that aren't there anymore
+	Available []string // Columns there are now
+	Where     string   // "dataset" or "query result"
+}
+
+func (e *ErrReportSchemaDrift) Error() string {
+	return fmt.Sprintf(
+		"report '%s' uses columns missing from the %s: %s. Available columns: %s. Save the report again from a new visualization",
+		e.Report, e.Where, strings.Join(e.Missing, ", "), strings.Join(e.Available, ", "),
+	)
+}
+func (e *ErrReportSchemaDrift) ExitClass() exitcode.Class { return exitcode.ClassData }
+
+// JSON files of saved reports, one per name
+type ReportStore struct {
+	Dir string
+}
+
+func NewReportStore(dir string) *ReportStore {
+	return &ReportStore{Dir: dir}
+}
+
+// Check that a report name is usable as a file name
+func ValidateReportName(name string) error {
+	if !reportNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid report name '%s', use up to 64 letters, digits, '_', '-' or '.'", name)
+	}
+
+	return nil
+}
+
+func (s *ReportStore) path(name string) string {
+	return filepath.Join(s.Dir, name+".json")
+}
+
+// Save a report, replacing any report of the same name. Returns the report file path
+func (s *ReportStore) Save(report Report) (string, error) {
+	if err := ValidateReportName(report.Name); err != nil {
+		return "", err
+	}
+
+	jsonBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal report: %w", err)
+	}
+
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create reports directory: %w", err)
+	}
+
+	reportPath := s.path(report.Name)
+	if err := os.WriteFile(reportPath, jsonBytes, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write report '%s': %w", report.Name, err)
+	}
+
+	return reportPath, nil
+}
+
+// Load a saved report by name
+func (s *ReportStore) Load(name string) (Report, error) {
+	if err := ValidateReportName(name); err != nil {
+		return Report{}, err
+	}
+
+	jsonBytes, err := os.ReadFile(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return Report{}, fmt.Errorf("no report named '%s' in %s", name, s.Dir)
+	}
+	if err != nil {
+		return Report{}, err
+	}
+
+	report := Report{}
+	if err := json.Unmarshal(jsonBytes, &report); err != nil {
+		return Report{}, fmt.Errorf("failed to decode report '%s': %w", name, err)
+	}
+
+	return report, nil
+}
+
+// Saved reports sorted by name. Unreadable files are logged and skipped
+func (s *ReportStore) List() ([]Report, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Report{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []Report{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok {
+			continue
+		}
+
+		report, err := s.Load(name)
+		if err != nil {
+			log.Printf("WARNING: Skipping report file '%s': %s\n", entry.Name(), err)
+			continue
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+// Delete a saved report
+func (s *ReportStore) Delete(name string) error {
+	if err := ValidateReportName(name); err != nil {
+		return err
+	}
+
+	err := os.Remove(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no report named '%s' in %s", name, s.Dir)
+	}
+
+	return err
+}
+
+// SQL of the lookup a chart was made from: the one that stored dataRef, or else the last successful lookup of the run
+func (t *Toolbox) chartQuery(dataRef string) (string, bool) {
+	if query, ok := t.resultQueries[strings.Trim(strings.TrimSpace(dataRef), `"`)]; ok {
+		return query, true
+	}
+
+	for _, entry := range slices.Backward(t.queries) {
+		if entry.Tool == LookUpFuncName && entry.Error == "" {
+			return entry.SQL, true
+		}
+	}
+
+	return "", false
+}
+
+// Save a visualization of the run as a named report, along with the lookup query behind its data.
+// Returns the report file path
+func (t *Toolbox) SaveReport(ctx context.Context, name string, request VisualizeRequest, dataRef string, config ChartConfig) (string, error) {
+	if err := ValidateReportName(name); err != nil {
+		return "", err
+	}
+
+	query, ok := t.chartQuery(dataRef)
+	if !ok {
+		return "", fmt.Errorf("no lookup query on this run to save report '%s' from", name)
+	}
+
+	tableColumns, err := t.store.Columns(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	report := Report{
+		Name:         name,
+		Goal:         request.Goal,
+		SQL:          query,
+		Config:       config,
+		Theme:        request.Theme,
+		Columns:      parseTabularData(request.Data).Columns,
+		TableColumns: tableColumns,
+		RunID:        t.RunID,
+		CreatedAt:    time.Now().Format(time.RFC3339),
+	}
+
+	reportPath, err := NewReportStore(t.config.ReportsDir).Save(report)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("Saved report '%s' to %s\n", name, reportPath)
+	return reportPath, nil
+}
+
+// Dataset columns the report query mentions that the dataset doesn't have anymore
+func driftedColumns(report Report, tableColumns []string) []string {
+	missing := []string{}
+	for _, column := range report.TableColumns {
+		mentioned := regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(column) + `\b`).MatchString(report.SQL)
+		present := slices.ContainsFunc(tableColumns, func(tableColumn string) bool {
+			return strings.EqualFold(tableColumn, column)
+		})
+		if mentioned && !present {
+			missing = append(missing, column)
+		}
+	}
+
+	return missing
+}
+
+// Render a saved report on the current data: run its query again and write its data and chart code as
+// artifacts of the run. No completion is made. Returns ErrReportSchemaDrift when the data no longer fits it
+func (t *Toolbox) RunReport(parentCtx context.Context, report Report) (ReportResult, error) {
+	ctx, span := t.tracer.StartOpenInferenceSpan(parentCtx, RunReportName, tracing.ToolKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	tracing.SetSpanInput(span, report.Name)
+	tracing.SetSpanAttrFromMap(span, map[string]any{
+		"tool.sql":   report.SQL,
+		"chart.type": report.Config.ChartType,
+	})
+
+	reportResult, err := t.runReport(ctx, report)
+	if err != nil {
+		tracing.SetSpanAttr(span, "tool.error.class", ErrorClass(err))
+		tracing.SetSpanErrorCode(span)
+		return reportResult, err
+	}
+
+	tracing.SetSpanAttrFromMap(span, map[string]any{
+		"retrieval.row_count": len(reportResult.Rows),
+		"tool.artifact":       reportResult.ArtifactPath,
+	})
+	tracing.SetSpanOutput(span, reportResult.Code)
+	tracing.SetSpanSuccessCode(span)
+	return reportResult, nil
+}
+
+func (t *Toolbox) runReport(ctx context.Context, report Report) (ReportResult, error) {
+	reportResult := ReportResult{Report: report}
+
+	// Point at the missing columns instead of surfacing a binder error
+	tableColumns, err := t.store.Columns(ctx)
+	if err != nil {
+		return reportResult, err
+	}
+	if missing := driftedColumns(report, tableColumns); len(missing) > 0 {
+		return reportResult, &ErrReportSchemaDrift{Report: report.Name, Missing: missing, Available: tableColumns, Where: "dataset"}
+	}
+
+	columns, rows, err := t.runAuditedQuery(ctx, RunReportName, AuditSourceReport, report.SQL)
+	if err != nil {
+		return reportResult, &ErrSQLExecution{Query: report.SQL, DBErr: err}
+	}
+	reportResult.Columns = columns
+	reportResult.Rows = rows
+
+	// The query can still run while returning other columns than the chart needs
+	missing := []string{}
+	for _, axis := range chartAxes(report.Config) {
+		if !slices.Contains(columns, axis) {
+			missing = append(missing, axis)
+		}
+	}
+	if len(missing) > 0 {
+		return reportResult, &ErrReportSchemaDrift{Report: report.Name, Missing: missing, Available: columns, Where: "query result"}
+	}
+
+	data := formatRows(columns, rows)
+	reportResult.DataPath, err = t.WriteArtifact(t.nextArtifactName("report", "csv"), []byte(data))
+	if err != nil {
+		return reportResult, err
+	}
+
+	// Published code reads the published data, pandas reads URLs as well as local files
+	reportResult.DataURL = t.PublishArtifact(ctx, reportResult.DataPath)
+	dataFile := reportResult.DataPath
+	if reportResult.DataURL != "" {
+		dataFile = reportResult.DataURL
+	}
+
+	theme, warning := t.ChartTheme(report.Theme)
+	if warning != "" {
+		log.Printf("WARNING: Report '%s' %s\n", report.Name, warning)
+	}
+	parsed := parseTabularData(data)
+	reportResult.Code = chartCode(report.Config, parsed.Columns, parsed.Rows, dataFile, theme)
+	reportResult.ArtifactPath, err = t.WriteArtifact(t.nextArtifactName("report", "py"), []byte(reportResult.Code))
+	if err != nil {
+		return reportResult, err
+	}
+	reportResult.ArtifactURL = t.PublishArtifact(ctx, reportResult.ArtifactPath)
+
+	// The spec renders the chart again without the database, a failure only loses that
+	reportResult.SpecPath, err = t.writeChartSpec(ctx, reportResult.ArtifactPath, report.Config, data, theme)
+	if err != nil {
+		log.Printf("WARNING: %s\n", err)
+	}
+
+	return reportResult, nil
+}
+
+// Python code charting a data CSV next to it, or at its published URL, with a chart config and theme.
+// The chart is saved as a PNG next to the code, along an HTML page showing it with alt text, and with the
+// data as a table under it when the theme asks for one. Histograms and box plots draw the distribution of
+// the rows, computed here and tabled on the page too. Changes must bump ChartRendererVersion
+func chartCode(config ChartConfig, columns []string, rows [][]string, dataFile string, theme ChartTheme) string {
+	x, y := strconv.Quote(config.XAxis), strconv.Quote(config.YAxis)
+
+	plot := fmt.Sprintf("ax.plot(data[%s], data[%s])", x, y)
+	distributionTable := ""
+	switch config.ChartType {
+	case barChart:
+		plot = fmt.Sprintf("ax.bar(data[%s].astype(str), data[%s])", x, y)
+	case scatterChart:
+		plot = fmt.Sprintf("ax.scatter(data[%s], data[%s])", x, y)
+	case histogramChart, boxplotChart:
+		distribution, _ := NewChartDistribution(config, columns, rows)
+		plot = distribution.plotCode()
+		distributionTable = fmt.Sprintf("page.append(%s)\n", strconv.Quote(distribution.htmlTable()))
+	}
+
+	// Box plots spread the values along the Y axis, one box per bucket on the X axis
+	xLabel, yLabel := x, y
+	if config.ChartType == boxplotChart {
+		xLabel, yLabel = strconv.Quote(config.BucketBy), x
+	}
+
+	source := fmt.Sprintf("pathlib.Path(__file__).with_name(%s)", strconv.Quote(dataFile))
+	if strings.Contains(dataFile, "://") {
+		source = strconv.Quote(dataFile)
+	}
+
+	dataTable := ""
+	if theme.DataTable {
+		dataTable = "page.append(data.to_html(index=False))\n"
+	}
+
+	return fmt.Sprintf(`import html
+import pathlib
+
+import pandas as pd
+%s
+data = pd.read_csv(%s, skipinitialspace=True)
+
+fig, ax = plt.subplots()
+%s
+ax.set_title(%s)
+ax.set_xlabel(%s)
+ax.set_ylabel(%s)
+plt.tight_layout()
+image = pathlib.Path(__file__).with_suffix(".png")
+plt.savefig(image)
+
+page = [
+    "<!DOCTYPE html>",
+    f'<html><head><meta charset="utf-8"><title>{html.escape(%s)}</title></head><body>',
+    f'<figure><img src="{image.name}" alt="{html.escape(%s)}"></figure>',
+]
+%s%spage.append("</body></html>")
+image.with_suffix(".html").write_text("\n".join(page) + "\n")
+`, chartThemeCode(theme), source, plot, strconv.Quote(config.Title), xLabel, yLabel, strconv.Quote(config.Title), strconv.Quote(chartAltText(config)),
+		distributionTable, dataTable)
+}
+
+// Text describing a chart to screen readers, in place of its image
+func chartAltText(config ChartConfig) string {
+	description := fmt.Sprintf("%s chart of %s by %s", config.ChartType, config.YAxis, config.XAxis)
+	switch {
+	case config.ChartType == histogramChart:
+		description = fmt.Sprintf("histogram of %s", config.XAxis)
+	case config.ChartType == boxplotChart && config.BucketBy != "":
+		description = fmt.Sprintf("box plot of %s by %s", config.XAxis, config.BucketBy)
+	case config.ChartType == boxplotChart:
+		description = fmt.Sprintf("box plot of %s", config.XAxis)
+	}
+
+	if strings.TrimSpace(config.Title) == "" {
+		return description
+	}
+	return fmt.Sprintf("%s: %s", config.Title, description)
+}
+
+func formatInches(inches float64) string {
+	return strconv.FormatFloat(inches, 'g', -1, 64)
+}
@@ -0,0 +1,76 @@
+package tools
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+--------------------------
+Completion request records
+--------------------------
+*/
+
+// Completion request made on a run, as listed on its transcript
+type CompletionCall struct {
+	RequestID   string `json:"requestId,omitempty"` // Empty for clients that don't send the header, like mocks
+	Model       string `json:"model"`
+	TotalTokens int64  `json:"totalTokens"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Chat completer recording the OpenAI request ID of every completion it forwards.
+// The ID is set as llm.request_id on the LLM span of the completion and added to failure errors
+type RequestRecorder struct {
+	completer ChatCompleter
+	calls     []CompletionCall
+	mutex     sync.Mutex
+}
+
+// Wrap a completer to record its completion requests. Meant to be created per run
+func NewRequestRecorder(completer ChatCompleter) *RequestRecorder {
+	return &RequestRecorder{completer: completer, calls: []CompletionCall{}}
+}
+
+func (r *RequestRecorder) New(
+	ctx context.Context,
+	body openai.ChatCompletionNewParams,
+	opts ...option.RequestOption,
+) (*openai.ChatCompletion, error) {
+	var httpResponse *http.Response
+	response, err := r.completer.New(ctx, body, append(opts, option.WithResponseInto(&httpResponse))...)
+
+	requestID := completion.RequestID(httpResponse, err)
+	if requestID != "" {
+		tracing.SetSpanAttr(trace.SpanFromContext(ctx), "llm.request_id", requestID)
+	}
+
+	call := CompletionCall{RequestID: requestID, Model: body.Model.Value}
+	if response != nil {
+		call.TotalTokens = response.Usage.TotalTokens
+	}
+	if err != nil {
+		call.Error = err.Error()
+	}
+
+	r.mutex.Lock()
+	r.calls = append(r.calls, call)
+	r.mutex.Unlock()
+
+	return response, completion.WithRequestID(err, requestID)
+}
+
+// Completion requests recorded so far, in order
+func (r *RequestRecorder) Calls() []CompletionCall {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return slices.Clone(r.calls)
+}
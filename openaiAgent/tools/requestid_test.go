@@ -0,0 +1,94 @@
+package tools
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// Completion body the stub API replies with on success
+const stubCompletion = `{"id": "chatcmpl-stub", "object": "chat.completion", "created": 0, "model": "gpt-4o-mini",
+"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "Total sales were 66.75."}}],
+"usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}}`
+
+// The request ID header of the API is recorded on the completion call and the LLM span, and named on failure errors
+func TestRequestRecorder(t *testing.T) {
+	cases := []struct {
+		name      string
+		status    int
+		requestID string // x-request-id header of the reply, empty to leave it out
+		wantErr   bool
+	}{
+		{name: "success", status: http.StatusOK, requestID: "req_success"},
+		{name: "failure", status: http.StatusBadRequest, requestID: "req_failure", wantErr: true},
+		{name: "no header", status: http.StatusOK},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if c.requestID != "" {
+					w.Header().Set(completion.RequestIDHeader, c.requestID)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(c.status)
+				if c.status != http.StatusOK {
+					w.Write([]byte(`{"error": {"message": "invalid model", "type": "invalid_request_error"}}`))
+					return
+				}
+				w.Write([]byte(stubCompletion))
+			}))
+			defer server.Close()
+
+			client := openai.NewClient(option.WithBaseURL(server.URL), option.WithAPIKey("sk-test"), option.WithMaxRetries(0))
+			recorder := NewRequestRecorder(client.Chat.Completions)
+			tracer, spans := tracing.NewRecordingTracer()
+			ctx, span := tracer.StartOpenAISpan(context.Background(), "gpt-4o-mini")
+
+			_, err := recorder.New(ctx, openai.ChatCompletionNewParams{
+				Model:    openai.F("gpt-4o-mini"),
+				Messages: openai.F([]openai.ChatCompletionMessageParamUnion{openai.UserMessage("What were the total sales?")}),
+			})
+			span.End()
+
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("expected the completion to fail")
+				}
+				if completion.RequestIDOf(err) != c.requestID || !strings.Contains(err.Error(), "(request id "+c.requestID+")") {
+					t.Errorf("error doesn't name request %s: %s", c.requestID, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			calls := recorder.Calls()
+			if len(calls) != 1 || calls[0].RequestID != c.requestID || calls[0].Model != "gpt-4o-mini" {
+				t.Fatalf("recorded calls %+v, expected one of request '%s'", calls, c.requestID)
+			}
+			if !c.wantErr && calls[0].TotalTokens != 15 {
+				t.Errorf("recorded %d tokens, expected 15", calls[0].TotalTokens)
+			}
+			if c.wantErr && calls[0].Error == "" {
+				t.Error("the failed call has no error recorded")
+			}
+
+			spanRequestID := ""
+			for _, attr := range spans.Ended()[0].Attributes() {
+				if attr.Key == "llm.request_id" {
+					spanRequestID = attr.Value.AsString()
+				}
+			}
+			if spanRequestID != c.requestID {
+				t.Errorf("span has request id '%s', expected '%s'", spanRequestID, c.requestID)
+			}
+		})
+	}
+}
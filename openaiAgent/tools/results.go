@@ -0,0 +1,121 @@
+package tools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+/*
+-----------------
+Run results store
+-----------------
+*/
+
+// Prefix of the references to stored results
+const resultRefPrefix = "res_"
+
+// References to stored results on a tool result
+var resultRefRegex = regexp.MustCompile(`result_ref: "(` + resultRefPrefix + `[0-9a-f]+)"`)
+
+// Hex characters of the content hash used on result references
+const resultRefHashLength = 6
+
+// Rows of a stored lookup result shown to the model, the full result is reached through its reference
+const resultPreviewRows = 20
+
+// Save a tool result for the rest of the run under a short content hash.
+// The same content always gets the same reference, so repeated lookups aren't stored twice
+func (t *Toolbox) storeResult(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	hash := hex.EncodeToString(sum[:])
+
+	// Lengthen the reference on the unlikely case of a short hash collision
+	for length := resultRefHashLength; length <= len(hash); length++ {
+		ref := resultRefPrefix + hash[:length]
+		stored, ok := t.results[ref]
+		if !ok {
+			t.results[ref] = content
+			return ref
+		}
+		if stored == content {
+			return ref
+		}
+	}
+
+	return resultRefPrefix + hash
+}
+
+// Get the data a tool works on, either inline or from a result stored on this run.
+// References from other runs, or made up ones, are invalid arguments
+func (t *Toolbox) ResolveData(tool string, data string, dataRef string) (string, error) {
+	dataRef = strings.Trim(strings.TrimSpace(dataRef), `"`)
+	if dataRef == "" {
+		return data, nil
+	}
+
+	stored, ok := t.results[dataRef]
+	if !ok {
+		return "", &ErrInvalidArguments{
+			Tool:   tool,
+			Reason: fmt.Sprintf("unknown dataRef '%s', use a result_ref returned by %s on this run", dataRef, LookUpFuncName),
+		}
+	}
+
+	log.Printf("Resolved dataRef '%s' for '%s' (%d bytes)\n", dataRef, tool, len(stored))
+	return stored, nil
+}
+
+// Check if a result is stored on the run
+func (t *Toolbox) HasResult(ref string) bool {
+	_, ok := t.results[ref]
+	return ok
+}
+
+// References to stored results shown on a tool result, in order
+func ResultRefs(toolResult string) []string {
+	refs := []string{}
+	for _, match := range resultRefRegex.FindAllStringSubmatch(toolResult, -1) {
+		refs = append(refs, match[1])
+	}
+
+	return refs
+}
+
+// Whether ref is the reference content was stored under, like a lookup artifact of a run and the
+// result_ref shown for it. Refs lengthened on a hash collision match too
+func ResultRefMatches(ref string, content []byte) bool {
+	sum := sha256.Sum256(content)
+	hash, ok := strings.CutPrefix(ref, resultRefPrefix)
+	return ok && len(hash) >= resultRefHashLength && strings.HasPrefix(hex.EncodeToString(sum[:]), hash)
+}
+
+// Drop the results stored on the run, once no tool can reference them anymore
+func (t *Toolbox) ReleaseResults() {
+	if len(t.results) != 0 {
+		log.Printf("Releasing %d stored results of run '%s'\n", len(t.results), t.RunID)
+	}
+
+	t.results = map[string]string{}
+	t.resultQueries = map[string]string{}
+	t.latestResultRef = ""
+}
+
+// Reference of the last lookup result stored on the run, if any
+func (t *Toolbox) LatestResultRef() (string, bool) {
+	return t.latestResultRef, t.latestResultRef != ""
+}
+
+// Model facing lookup result: the first rows of the data and the reference to the full result
+func formatResultPreview(ref string, columns []string, rows [][]any) string {
+	header := fmt.Sprintf("result_ref: %q (%d rows)", ref, len(rows))
+	if len(rows) > resultPreviewRows {
+		header = fmt.Sprintf("result_ref: %q (%d rows, showing the first %d)", ref, len(rows), resultPreviewRows)
+		rows = rows[:resultPreviewRows]
+	}
+
+	return fmt.Sprintf("%s\nPass result_ref as dataRef to analyze or visualize the full result.\n%s", header, formatRows(columns, rows))
+}
@@ -0,0 +1,70 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+/*
+-----------------
+Retrieval metrics
+-----------------
+*/
+
+// Amount of data read by the lookups of a run
+type RetrievalStats struct {
+	Lookups      int `json:"lookups"`
+	RowsScanned  int `json:"rowsScanned"` // Only measured when Config.ExplainQueries is set
+	RowsReturned int `json:"rowsReturned"`
+	Bytes        int `json:"bytes"` // Size of the formatted results
+}
+
+// Operator of a DuckDB JSON query profile
+type explainNode struct {
+	OperatorRowsScanned int           `json:"operator_rows_scanned"`
+	Children            []explainNode `json:"children"`
+}
+
+// Sum the rows scanned by an operator and its children
+func (n explainNode) rowsScanned() int {
+	total := n.OperatorRowsScanned
+	for _, child := range n.Children {
+		total += child.rowsScanned()
+	}
+
+	return total
+}
+
+// Profile a query with EXPLAIN ANALYZE and return the rows its scans read.
+// Runs the query again, so it doubles its cost
+func (t *Toolbox) explainRowsScanned(ctx context.Context, query string) (int, error) {
+	_, rows, err := t.runAuditedQuery(ctx, LookUpFuncName, AuditSourceGenerated, "EXPLAIN (ANALYZE, FORMAT JSON) "+query)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(rows) == 0 || len(rows[0]) < 2 {
+		return 0, fmt.Errorf("empty query profile")
+	}
+
+	profile := explainNode{}
+	if err := json.Unmarshal([]byte(fmt.Sprintf("%v", rows[0][1])), &profile); err != nil {
+		return 0, fmt.Errorf("failed to parse query profile: %w", err)
+	}
+
+	return profile.rowsScanned(), nil
+}
+
+// Add a lookup result to the run retrieval stats
+func (t *Toolbox) recordRetrieval(lookupResult LookupResult) {
+	t.retrieval.Lookups++
+	t.retrieval.RowsScanned += lookupResult.RowsScanned
+	t.retrieval.RowsReturned += lookupResult.TotalRows
+	t.retrieval.Bytes += lookupResult.Bytes
+}
+
+// Retrieval stats of the lookups made on the run so far
+func (t *Toolbox) Retrieval() RetrievalStats {
+	return t.retrieval
+}
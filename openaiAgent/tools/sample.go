@@ -0,0 +1,84 @@
+package tools
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+/*
+----------------
+Dataset sampling
+----------------
+*/
+
+// Seed of the sample, so a sampled table holds the same rows on every reload
+const sampleSeed = 42
+
+// Part of the data file the dataset table is loaded with, to cut costs while developing.
+// The zero value loads the whole file
+type Sample struct {
+	Fraction float64 // Fraction of the rows kept, between 0 and 1
+	Rows     int     // Amount of rows kept
+}
+
+// Check a sample keeps a fraction or an amount of rows, not both
+func (s Sample) Validate() error {
+	switch {
+	case s.Fraction != 0 && s.Rows != 0:
+		return fmt.Errorf("sample by fraction or by rows, not both")
+	case s.Fraction < 0 || s.Fraction >= 1:
+		return fmt.Errorf("invalid sample fraction %g, expected a value between 0 and 1 like 0.05", s.Fraction)
+	case s.Rows < 0:
+		return fmt.Errorf("invalid sample rows %d, expected a positive amount", s.Rows)
+	}
+
+	return nil
+}
+
+// Whether the whole data file is loaded
+func (s Sample) IsZero() bool {
+	return s == Sample{}
+}
+
+// Readable size of the sample, like "5%" or "10000 rows". Empty for the whole data file.
+// Also stored on the dataset fingerprint, so switching samples reloads the table
+func (s Sample) String() string {
+	switch {
+	case s.Rows > 0:
+		return fmt.Sprintf("%d rows", s.Rows)
+	case s.Fraction > 0:
+		return strconv.FormatFloat(s.Fraction*100, 'g', -1, 64) + "%"
+	}
+
+	return ""
+}
+
+// DuckDB sample clause of the table creation query, empty for the whole data file
+func (s Sample) clause() string {
+	switch {
+	case s.Rows > 0:
+		return fmt.Sprintf(" USING SAMPLE reservoir(%d ROWS) REPEATABLE (%d)", s.Rows, sampleSeed)
+	case s.Fraction > 0:
+		return fmt.Sprintf(" USING SAMPLE bernoulli(%s PERCENT) REPEATABLE (%d)", strconv.FormatFloat(s.Fraction*100, 'g', -1, 64), sampleSeed)
+	}
+
+	return ""
+}
+
+// Whether the dataset table was loaded with another sample than sample.
+// Tables without a fingerprint predate samples, so they hold the whole data file
+func sampleChanged(db *sql.DB, sample Sample) (bool, error) {
+	stored, found, err := loadFingerprint(db, tableName)
+	if err != nil {
+		return false, err
+	}
+
+	if stored.Sample != sample.String() && (found || !sample.IsZero()) {
+		log.Printf("Dataset '%s' sample changed from '%s' to '%s'\n", tableName, stored.Sample, sample)
+		return true, nil
+	}
+
+	return false, nil
+}
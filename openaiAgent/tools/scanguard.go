@@ -0,0 +1,374 @@
+package tools
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+----------
+Scan guard
+----------
+*/
+
+// What lookups do when the plan of their query estimates full scans over ScanGuard.MaxRows
+const (
+	ScanGuardWarn       = "warn"       // Run the query, with a warning on the tool result
+	ScanGuardRegenerate = "regenerate" // Generate the query once more asking for filters, then warn if it still scans too much
+)
+
+// Check of the rows lookup queries scan, estimated by DuckDB's EXPLAIN before they run.
+// The zero value checks nothing
+type ScanGuard struct {
+	MaxRows int64  // Estimated rows scanned over which queries with full scans are acted on. Zero disables the guard
+	Action  string // ScanGuardWarn or ScanGuardRegenerate. Empty warns
+}
+
+// Check a scan guard action. Empty is ScanGuardWarn
+func (g ScanGuard) Validate() error {
+	switch {
+	case g.MaxRows < 0:
+		return fmt.Errorf("invalid scan guard rows %d, expected a positive amount", g.MaxRows)
+	case g.Action != "" && g.Action != ScanGuardWarn && g.Action != ScanGuardRegenerate:
+		return fmt.Errorf("unknown scan guard action '%s', expected %s or %s", g.Action, ScanGuardWarn, ScanGuardRegenerate)
+	}
+
+	return nil
+}
+
+// Whether a query plan reads too much: over MaxRows estimated, with a scan that has no filters.
+// Filtered scans over it read what the question needs, filters can't cut them further
+func (g ScanGuard) Exceeded(estimate ScanEstimate) bool {
+	return g.MaxRows > 0 && estimate.Rows() > g.MaxRows && len(estimate.FullScans()) > 0
+}
+
+// Table scan operator of a query plan
+type PlanScan struct {
+	Operator      string // Like SEQ_SCAN or READ_PARQUET
+	Table         string // Scanned table or table function, empty when the plan doesn't name it
+	EstimatedRows int64  // Estimated cardinality, -1 when the plan has none for the scan
+	Filtered      bool   // Filters were pushed down into the scan
+}
+
+// Scans of a query plan, see ParseScanEstimate
+type ScanEstimate struct {
+	Scans []PlanScan
+}
+
+// Estimated rows read by the scans, leaving out the ones without an estimate
+func (e ScanEstimate) Rows() int64 {
+	total := int64(0)
+	for _, scan := range e.Scans {
+		total += max(scan.EstimatedRows, 0)
+	}
+	return total
+}
+
+// Tables scanned without filters, once each
+func (e ScanEstimate) FullScans() []string {
+	tables := []string{}
+	for _, scan := range e.Scans {
+		name := cmp.Or(scan.Table, strings.ToLower(scan.Operator))
+		if !scan.Filtered && !slices.Contains(tables, name) {
+			tables = append(tables, name)
+		}
+	}
+	return tables
+}
+
+// Estimated cardinality, as "~1234 Rows" on recent DuckDB text plans, "EC: 1234" on older ones
+// and "Estimated Cardinality" keys on JSON plans
+var cardinalityRegex = regexp.MustCompile(`(?i)~\s*([0-9][0-9,]*)\s*rows|\b(?:EC|estimated[ _]cardinality)\s*[:=]\s*"?([0-9][0-9,]*)`)
+
+// Filters pushed into a scan, like "Filters: Store_Number=1320" or "Table Filters:"
+var scanFiltersRegex = regexp.MustCompile(`(?i)^(?:table[ _])?filters?\s*:\s*(.*)$`)
+
+// Key naming the table of a scan, its value may be on the next line
+var scanTableRegex = regexp.MustCompile(`(?i)^(?:table|text|function)\s*:\s*(.*)$`)
+
+// Scans reading intermediate results instead of a table
+var internalScans = []string{"DUMMY_SCAN", "COLUMN_DATA_SCAN", "CHUNK_SCAN", "CTE_SCAN", "DELIM_SCAN", "EMPTY_RESULT", "EXPRESSION_SCAN"}
+
+// Parse the table scans of a DuckDB EXPLAIN output, either the text plan drawn with boxes or a JSON one.
+// Operator names, estimate formats and extra info layouts changed between DuckDB versions, so scans are
+// found by name and their estimates and filters by pattern. Plans with no scans, like the ones of other
+// databases, fail
+func ParseScanEstimate(plan string) (ScanEstimate, error) {
+	estimate := ScanEstimate{}
+	trimmed := strings.TrimSpace(plan)
+
+	var nodes []any
+	switch {
+	case strings.HasPrefix(trimmed, "["):
+		if err := json.Unmarshal([]byte(trimmed), &nodes); err != nil {
+			return estimate, fmt.Errorf("failed to parse query plan: %w", err)
+		}
+	case strings.HasPrefix(trimmed, "{"):
+		var node any
+		if err := json.Unmarshal([]byte(trimmed), &node); err != nil {
+			return estimate, fmt.Errorf("failed to parse query plan: %w", err)
+		}
+		nodes = []any{node}
+	default:
+		for _, box := range planBoxes(trimmed) {
+			if len(box) != 0 {
+				estimate.addScan(box[0], box[1:])
+			}
+		}
+	}
+	for _, node := range nodes {
+		estimate.addJSONScans(node)
+	}
+
+	if len(estimate.Scans) == 0 {
+		return estimate, fmt.Errorf("no table scans found in the query plan")
+	}
+	return estimate, nil
+}
+
+// Add the scans of a JSON plan node and its children. Extra info is an object on recent versions
+// and a text block on older ones
+func (e *ScanEstimate) addJSONScans(node any) {
+	fields, ok := node.(map[string]any)
+	if !ok {
+		return
+	}
+
+	name, _ := fields["name"].(string)
+	lines := []string{}
+	switch extraInfo := fields["extra_info"].(type) {
+	case string:
+		lines = strings.Split(extraInfo, "\n")
+	case map[string]any:
+		keys := []string{}
+		for key := range extraInfo {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			value := fmt.Sprint(extraInfo[key])
+			if values, ok := extraInfo[key].([]any); ok {
+				parts := []string{}
+				for _, part := range values {
+					parts = append(parts, fmt.Sprint(part))
+				}
+				value = strings.Join(parts, ", ")
+			}
+			lines = append(lines, fmt.Sprintf("%s: %s", key, value))
+		}
+	}
+	// Some versions set the estimate next to the extra info
+	for key, value := range fields {
+		switch value.(type) {
+		case string, float64:
+			if line := fmt.Sprintf("%s: %v", key, value); cardinalityRegex.MatchString(line) {
+				lines = append(lines, line)
+			}
+		}
+	}
+	e.addScan(name, lines)
+
+	children, _ := fields["children"].([]any)
+	for _, child := range children {
+		e.addJSONScans(child)
+	}
+}
+
+// Add an operator of the plan when it scans a table, reading its table, estimate and filters from its info lines
+func (e *ScanEstimate) addScan(operator string, lines []string) {
+	operator = strings.ToUpper(strings.TrimSpace(operator))
+	isScan := strings.Contains(operator, "SCAN") || strings.HasPrefix(operator, "READ_")
+	if !isScan || slices.Contains(internalScans, operator) {
+		return
+	}
+
+	scan := PlanScan{Operator: operator, EstimatedRows: -1}
+	contentLines := 0
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.Trim(line, "─- ") == "" || line == "[INFOSEPARATOR]" {
+			continue
+		}
+		contentLines++
+
+		if match := cardinalityRegex.FindStringSubmatch(line); match != nil {
+			rows, err := strconv.ParseInt(strings.ReplaceAll(match[1]+match[2], ",", ""), 10, 64)
+			if err == nil {
+				scan.EstimatedRows = rows
+			}
+			continue
+		}
+		if match := scanFiltersRegex.FindStringSubmatch(line); match != nil {
+			// Filters listed on the following lines leave the key line empty
+			scan.Filtered = scan.Filtered || strings.TrimSpace(match[1]) != "" || nextContentLine(lines, i) != ""
+			continue
+		}
+		if match := scanTableRegex.FindStringSubmatch(line); match != nil && scan.Table == "" {
+			scan.Table = cmp.Or(strings.TrimSpace(match[1]), nextContentLine(lines, i))
+			continue
+		}
+		// Text plans name the table on the first line after the operator
+		if contentLines == 1 && scan.Table == "" && !strings.Contains(line, ":") {
+			scan.Table = line
+		}
+	}
+
+	e.Scans = append(e.Scans, scan)
+}
+
+// First non blank line after line i, empty when there's none or it's a key of its own
+func nextContentLine(lines []string, i int) string {
+	for _, line := range lines[i+1:] {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return ""
+		}
+		if !strings.HasSuffix(line, ":") {
+			return line
+		}
+		return ""
+	}
+	return ""
+}
+
+// Lines of the boxes of a text plan, by operator. Boxes of sibling operators are drawn side by side,
+// so each one is followed by the column of its top left corner until its bottom corner
+func planBoxes(plan string) [][]string {
+	boxes := [][]string{}
+	open := map[int]int{} // Column of the left border of each open box, to its index on boxes
+	isBorder := func(r rune) bool { return r == '│' || r == '├' || r == '┤' || r == '|' }
+
+	for _, line := range strings.Split(plan, "\n") {
+		runes := []rune(line)
+		for column := 0; column < len(runes); column++ {
+			switch {
+			case runes[column] == '┌':
+				open[column] = len(boxes)
+				boxes = append(boxes, []string{})
+			case runes[column] == '└':
+				delete(open, column)
+			case isBorder(runes[column]):
+				index, ok := open[column]
+				if !ok {
+					continue
+				}
+				end := column + 1
+				for end < len(runes) && !isBorder(runes[end]) {
+					end++
+				}
+				boxes[index] = append(boxes[index], strings.TrimSpace(string(runes[column+1:end])))
+				column = end - 1
+			}
+		}
+	}
+
+	// Drop the blank lines the boxes start with
+	for i, box := range boxes {
+		for len(box) != 0 && box[0] == "" {
+			box = box[1:]
+		}
+		boxes[i] = box
+	}
+	return boxes
+}
+
+// Estimate the scans of a query with EXPLAIN, which plans it without running it
+func (t *Toolbox) estimateScans(ctx context.Context, query string) (ScanEstimate, error) {
+	_, rows, err := t.runAuditedQuery(ctx, LookUpFuncName, AuditSourcePlan, "EXPLAIN "+query)
+	if err != nil {
+		return ScanEstimate{}, err
+	}
+
+	plans := []string{}
+	for _, row := range rows {
+		if len(row) >= 2 {
+			plans = append(plans, fmt.Sprint(row[1]))
+		}
+	}
+	if len(plans) == 0 {
+		return ScanEstimate{}, fmt.Errorf("empty query plan")
+	}
+
+	return ParseScanEstimate(strings.Join(plans, "\n"))
+}
+
+// Hint asking the model to filter a query that scans too much, added to the lookup prompt on regeneration
+func scanGuardHint(query string, estimate ScanEstimate) string {
+	return fmt.Sprintf(
+		"\nThe query generated for this request before, %s, scans about %d rows reading all of %s. "+
+			"Add WHERE filters matching the constraints of the request, like its stores, products or dates, "+
+			"so only the rows it needs are read. Leave it unfiltered only if the request is about the whole table.",
+		query, estimate.Rows(), strings.Join(estimate.FullScans(), ", "),
+	)
+}
+
+// Warning attached to the result of a lookup whose query still scans too much
+func scanGuardWarning(estimate ScanEstimate, maxRows int64) string {
+	return fmt.Sprintf(
+		"the query scans about %d rows reading all of %s, over the %d rows threshold. "+
+			"If the question is about fewer stores, products or dates, look it up again naming them",
+		estimate.Rows(), strings.Join(estimate.FullScans(), ", "), maxRows,
+	)
+}
+
+// Estimate the scans of a checked lookup query, and regenerate it or warn about it when it exceeds
+// Config.ScanGuard. A regenerated query replaces the original only if it passes check, creates no table and
+// the model returned one. Plans that fail or don't parse leave the query as is. Returns the SQL generation
+// prompt of the query to run
+func (t *Toolbox) guardScans(
+	ctx context.Context,
+	lookupResult *LookupResult,
+	prompt string,
+	sqlPrompt string,
+	columns []string,
+	check func(string) (string, error),
+) string {
+	guard := t.config.ScanGuard
+	estimate, err := t.estimateScans(ctx, lookupResult.SQL)
+	if err != nil {
+		log.Printf("WARNING: Failed to estimate the scans of the lookup query: %s\n", err)
+		return sqlPrompt
+	}
+	lookupResult.ScanEstimate = estimate.Rows()
+	if !guard.Exceeded(estimate) {
+		return sqlPrompt
+	}
+
+	if guard.Action == ScanGuardRegenerate {
+		log.Printf("Lookup query scans about %d rows, generating it again with filters\n", estimate.Rows())
+		regeneratedPrompt, query, err := t.generateSqlQuery(ctx, prompt+scanGuardHint(lookupResult.SQL, estimate), columns, tableName)
+		query, rewrites := LintSQL(cleanLlmBlockResponse(query))
+		createdTable, checkErr := check(query)
+		switch {
+		case err != nil:
+			log.Printf("WARNING: Failed to generate the lookup query again, keeping the original: %s\n", err)
+		case query == "" || checkErr != nil || createdTable != "":
+			log.Printf("WARNING: Regenerated lookup query can't run, keeping the original: %v\n", checkErr)
+		default:
+			lookupResult.SQL, lookupResult.SQLRewrites, lookupResult.ScanRegenerated = query, rewrites, true
+			sqlPrompt = regeneratedPrompt
+			log.Printf("Query to be used: %s\n", lookupResult.SQL)
+
+			estimate, err = t.estimateScans(ctx, query)
+			if err != nil {
+				log.Printf("WARNING: Failed to estimate the scans of the regenerated lookup query: %s\n", err)
+				return sqlPrompt
+			}
+			lookupResult.ScanEstimate = estimate.Rows()
+			if !guard.Exceeded(estimate) {
+				return sqlPrompt
+			}
+		}
+	}
+
+	lookupResult.ScanWarning = scanGuardWarning(estimate, guard.MaxRows)
+	log.Printf("WARNING: Scan guard: %s\n", lookupResult.ScanWarning)
+	return sqlPrompt
+}
@@ -0,0 +1,197 @@
+package tools
+
+import (
+	"slices"
+	"testing"
+)
+
+// Text plan of DuckDB 1.1 for a query filtered on a store
+const filteredTextPlan = `┌───────────────────────────┐
+│    UNGROUPED_AGGREGATE    │
+│    ────────────────────   │
+│    Aggregates: sum(#0)    │
+└─────────────┬─────────────┘
+┌─────────────┴─────────────┐
+│         PROJECTION        │
+│    ────────────────────   │
+│  CAST(Total_Sale_Value AS │
+│           DOUBLE)         │
+│                           │
+│        ~21149 Rows        │
+└─────────────┬─────────────┘
+┌─────────────┴─────────────┐
+│         SEQ_SCAN          │
+│    ────────────────────   │
+│           sales           │
+│                           │
+│        Projections:       │
+│      Total_Sale_Value     │
+│                           │
+│          Filters:         │
+│   Store_Number=1320 AND   │
+│  Store_Number IS NOT NULL │
+│                           │
+│        ~21149 Rows        │
+└───────────────────────────┘`
+
+// Text plan of DuckDB 1.1 grouping the whole parquet file
+const parquetTextPlan = `┌───────────────────────────┐
+│       HASH_GROUP_BY       │
+│    ────────────────────   │
+│         Groups: #0        │
+│    Aggregates: sum(#1)    │
+│                           │
+│          ~33 Rows         │
+└─────────────┬─────────────┘
+┌─────────────┴─────────────┐
+│       READ_PARQUET        │
+│    ────────────────────   │
+│         Function:         │
+│        READ_PARQUET       │
+│                           │
+│        Projections:       │
+│        Store_Number       │
+│      Total_Sale_Value     │
+│                           │
+│        ~697894 Rows       │
+└───────────────────────────┘`
+
+// Text plan of DuckDB 1.1 joining the table with itself, the scans are drawn side by side
+const joinTextPlan = `┌───────────────────────────┐
+│         HASH_JOIN         │
+│    ────────────────────   │
+│      Join Type: INNER     │
+│                           │
+│        Conditions:        │
+│Store_Number = Store_Number├──────────────┐
+│                           │              │
+│      ~2951837840 Rows     │              │
+└─────────────┬─────────────┘              │
+┌─────────────┴─────────────┐┌─────────────┴─────────────┐
+│         SEQ_SCAN          ││         SEQ_SCAN          │
+│    ────────────────────   ││    ────────────────────   │
+│           sales           ││          stores           │
+│                           ││                           │
+│        Projections:       ││          Filters:         │
+│        Store_Number       ││      Store_Number>300     │
+│                           ││                           │
+│        ~697894 Rows       ││        ~139578 Rows       │
+└───────────────────────────┘└───────────────────────────┘`
+
+// Text plan of DuckDB 0.9, with EC estimates and filters on the same line
+const legacyTextPlan = `┌───────────────────────────┐
+│       HASH_GROUP_BY       │
+│   ─ ─ ─ ─ ─ ─ ─ ─ ─ ─ ─   │
+│             #0            │
+│          sum(#1)          │
+└─────────────┬─────────────┘
+┌─────────────┴─────────────┐
+│         SEQ_SCAN          │
+│   ─ ─ ─ ─ ─ ─ ─ ─ ─ ─ ─   │
+│           sales           │
+│   ─ ─ ─ ─ ─ ─ ─ ─ ─ ─ ─   │
+│        Store_Number       │
+│      Total_Sale_Value     │
+│   ─ ─ ─ ─ ─ ─ ─ ─ ─ ─ ─   │
+│       EC: 697,894         │
+└───────────────────────────┘`
+
+// JSON plan of DuckDB 1.1, with extra info objects
+const objectJSONPlan = `[
+    {
+        "name": "HASH_GROUP_BY",
+        "children": [
+            {
+                "name": "SEQ_SCAN ",
+                "children": [],
+                "extra_info": {
+                    "Text": "sales",
+                    "Projections": ["Store_Number", "Total_Sale_Value"],
+                    "Estimated Cardinality": "697894"
+                }
+            }
+        ],
+        "extra_info": {"Groups": "#0", "Estimated Cardinality": "33"}
+    }
+]`
+
+// JSON plan of DuckDB 0.10, with extra info text blocks
+const textJSONPlan = `{
+    "name": "PROJECTION",
+    "timing": 0.0,
+    "children": [
+        {
+            "name": "TABLE_SCAN",
+            "extra_info": "sales\nTotal_Sale_Value\n[INFOSEPARATOR]\nFilters: Store_Number=1320 AND Store_Number IS NOT NULL\n[INFOSEPARATOR]\nEC: 21149",
+            "children": []
+        }
+    ]
+}`
+
+// Text and JSON plans of several DuckDB versions, thresholds and actions, and unparsed plans
+func TestParseScanEstimate(t *testing.T) {
+	guard := ScanGuard{MaxRows: 100000}
+	cases := []struct {
+		name      string
+		plan      string
+		guard     ScanGuard
+		rows      int64    // Expected estimated rows
+		fullScans []string // Expected tables scanned without filters
+		exceeded  bool
+		failure   string // Expected parse error, instead of an estimate
+	}{
+		{name: "filtered text plan", plan: filteredTextPlan, guard: guard, rows: 21149, fullScans: []string{}},
+		{name: "full parquet scan", plan: parquetTextPlan, guard: guard, rows: 697894, fullScans: []string{"READ_PARQUET"}, exceeded: true},
+		{
+			name:      "full parquet scan regenerated",
+			plan:      parquetTextPlan,
+			guard:     ScanGuard{MaxRows: 100000, Action: ScanGuardRegenerate},
+			rows:      697894,
+			fullScans: []string{"READ_PARQUET"},
+			exceeded:  true,
+		},
+		{name: "full scan under the threshold", plan: parquetTextPlan, guard: ScanGuard{MaxRows: 1000000}, rows: 697894, fullScans: []string{"READ_PARQUET"}},
+		{name: "guard disabled", plan: parquetTextPlan, rows: 697894, fullScans: []string{"READ_PARQUET"}},
+		{name: "side by side scans", plan: joinTextPlan, guard: guard, rows: 837472, fullScans: []string{"sales"}, exceeded: true},
+		{name: "legacy text plan", plan: legacyTextPlan, guard: guard, rows: 697894, fullScans: []string{"sales"}, exceeded: true},
+		{name: "json plan", plan: objectJSONPlan, guard: guard, rows: 697894, fullScans: []string{"sales"}, exceeded: true},
+		{name: "legacy json plan", plan: textJSONPlan, guard: ScanGuard{MaxRows: 1000}, rows: 21149, fullScans: []string{}},
+		{
+			name:      "scan without estimate",
+			plan:      `[{"name": "SEQ_SCAN", "children": [], "extra_info": {"Table": "sales"}}]`,
+			guard:     ScanGuard{MaxRows: 1},
+			fullScans: []string{"sales"},
+		},
+		{name: "plan without scans", plan: "┌───────────────┐\n│  DUMMY_SCAN   │\n└───────────────┘", guard: guard, failure: "no table scans found in the query plan"},
+		{name: "other database plan", plan: "Seq Scan on sales  (cost=0.00..18334.00 rows=697894 width=8)", guard: guard, failure: "no table scans found in the query plan"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.guard.Validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			estimate, err := ParseScanEstimate(c.plan)
+			if c.failure != "" {
+				if err == nil || err.Error() != c.failure {
+					t.Fatalf("got error %v, expected '%s'", err, c.failure)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if estimate.Rows() != c.rows {
+				t.Fatalf("estimated %d rows, expected %d", estimate.Rows(), c.rows)
+			}
+			if !slices.Equal(estimate.FullScans(), c.fullScans) {
+				t.Fatalf("found full scans of %q, expected %q", estimate.FullScans(), c.fullScans)
+			}
+			if exceeded := c.guard.Exceeded(estimate); exceeded != c.exceeded {
+				t.Fatalf("guard exceeded is %t, expected %t", exceeded, c.exceeded)
+			}
+		})
+	}
+}
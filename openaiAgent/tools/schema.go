@@ -0,0 +1,109 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+/*
+--------------------------------
+Strict structured output schemas
+--------------------------------
+*/
+
+// Keywords OpenAI strict structured outputs reject
+var unsupportedSchemaKeywords = []string{
+	"$schema", "$id", "format", "pattern", "minLength", "maxLength", "minimum", "maximum",
+	"exclusiveMinimum", "exclusiveMaximum", "multipleOf", "minItems", "maxItems", "uniqueItems",
+	"patternProperties", "unevaluatedProperties", "propertyNames", "minProperties", "maxProperties",
+}
+
+// Generate a schema accepted by OpenAI strict structured outputs: every property required,
+// additionalProperties false at every level and no unsupported keywords.
+// Returns an error if T can't be represented, like maps or references
+func generateStrictSchema[T any]() (any, error) {
+	jsonBytes, err := json.Marshal(generateSchema[T]())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal schema: %w", err)
+	}
+
+	schema := map[string]any{}
+	if err := json.Unmarshal(jsonBytes, &schema); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal schema: %w", err)
+	}
+
+	if schema["type"] != "object" {
+		return nil, fmt.Errorf("strict schema root must be an object, got '%v'", schema["type"])
+	}
+
+	if err := makeStrict(schema, "#"); err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+// Generate a strict schema for a structured output call site. Panics if T can't be represented,
+// so invalid structs fail loudly instead of sending a broken schema
+func mustGenerateStrictSchema[T any]() any {
+	schema, err := generateStrictSchema[T]()
+	if err != nil {
+		panic(fmt.Sprintf("invalid structured output schema for %T: %s", *new(T), err))
+	}
+
+	return schema
+}
+
+// Strict schema accessor for a structured output call site. The schema is generated on the first call,
+// so call sites that never run don't pay for the reflection. Safe for concurrent callers
+func lazyStrictSchema[T any]() func() any {
+	return sync.OnceValue(mustGenerateStrictSchema[T])
+}
+
+// Recursively apply strict mode rules to a schema node. path locates the node on errors
+func makeStrict(node map[string]any, path string) error {
+	for _, keyword := range unsupportedSchemaKeywords {
+		delete(node, keyword)
+	}
+
+	if _, ok := node["$ref"]; ok {
+		return fmt.Errorf("%s: references aren't supported", path)
+	}
+
+	switch node["type"] {
+	case "object":
+		properties, ok := node["properties"].(map[string]any)
+		if !ok || len(properties) == 0 {
+			return fmt.Errorf("%s: objects need fixed properties, maps aren't supported", path)
+		}
+
+		required := []string{}
+		for name, property := range properties {
+			propertyNode, ok := property.(map[string]any)
+			if !ok {
+				return fmt.Errorf("%s.%s: invalid property schema", path, name)
+			}
+			if err := makeStrict(propertyNode, path+"."+name); err != nil {
+				return err
+			}
+			required = append(required, name)
+		}
+
+		slices.Sort(required)
+		node["required"] = required
+		node["additionalProperties"] = false
+	case "array":
+		items, ok := node["items"].(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: arrays need an items schema", path)
+		}
+		return makeStrict(items, path+"[]")
+	case "string", "number", "integer", "boolean", "null":
+	default:
+		return fmt.Errorf("%s: unsupported or missing type '%v'", path, node["type"])
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Directory of the golden strict schemas, one per structured output call site
+const schemasDir = "../testdata/schemas"
+
+// Structured output call sites and their schema accessors
+var schemaAccessors = map[string]func() any{
+	"follow ups":    followUpSchema,
+	"analysis":      analysisSchema,
+	"judgement":     judgementSchema,
+	"plan":          planSchema,
+	"visual config": visualConfigSchema,
+}
+
+// The strict schema of each call site matches its golden file, so a struct change shows up as a schema diff
+func TestStrictSchemaGolden(t *testing.T) {
+	for name, accessor := range schemaAccessors {
+		t.Run(name, func(t *testing.T) {
+			schema, err := json.MarshalIndent(accessor(), "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			schema = append(schema, '\n')
+
+			goldenPath := filepath.Join(schemasDir, strings.ReplaceAll(name, " ", "_")+".json")
+			if *updateGolden {
+				if err := os.WriteFile(goldenPath, schema, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			golden, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatalf("failed to read golden schema: %s", err)
+			}
+			if !bytes.Equal(schema, golden) {
+				t.Errorf("schema differs from %s:\n%s\nRun go test ./tools -update if the change is intended", goldenPath, schema)
+			}
+		})
+	}
+}
+
+// Accessors racing on their first call generate the schema once and hand everyone the same one. Run with -race
+func TestSchemaAccessorsConcurrently(t *testing.T) {
+	const callers = 64
+	for name, accessor := range schemaAccessors {
+		t.Run(name, func(t *testing.T) {
+			schemas := make([]any, callers)
+			start := make(chan struct{})
+			var wg sync.WaitGroup
+			for i := range callers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					schemas[i] = accessor()
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			for i, schema := range schemas {
+				if fmt.Sprintf("%p", schema) != fmt.Sprintf("%p", schemas[0]) {
+					t.Fatalf("caller %d got another schema", i)
+				}
+			}
+			assertStrict(t, schemas[0].(map[string]any), "#")
+		})
+	}
+}
+
+// Check every object of a schema requires all its properties and allows no others
+func assertStrict(t *testing.T, node map[string]any, path string) {
+	t.Helper()
+	for _, keyword := range unsupportedSchemaKeywords {
+		if _, ok := node[keyword]; ok {
+			t.Errorf("%s: unsupported keyword '%s' left", path, keyword)
+		}
+	}
+
+	switch node["type"] {
+	case "object":
+		properties := node["properties"].(map[string]any)
+		names := []string{}
+		for name, property := range properties {
+			names = append(names, name)
+			assertStrict(t, property.(map[string]any), path+"."+name)
+		}
+		slices.Sort(names)
+		if !slices.Equal(node["required"].([]string), names) {
+			t.Errorf("%s: expected every property required, got %v", path, node["required"])
+		}
+		if node["additionalProperties"] != false {
+			t.Errorf("%s: expected additionalProperties false", path)
+		}
+	case "array":
+		assertStrict(t, node["items"].(map[string]any), path+"[]")
+	}
+}
+
+func TestGenerateStrictSchemaRejects(t *testing.T) {
+	type withMap struct {
+		Counts map[string]int `json:"counts"`
+	}
+
+	if _, err := generateStrictSchema[withMap](); err == nil {
+		t.Error("expected a map property to be rejected")
+	}
+	if _, err := generateStrictSchema[[]string](); err == nil {
+		t.Error("expected a non object root to be rejected")
+	}
+}
@@ -0,0 +1,285 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+/*
+---------------
+Dataset storage
+---------------
+*/
+
+// Storage backends of the dataset
+const (
+	StorageDuckDB = "duckdb" // Full SQL on DuckDB, needs cgo
+	StorageMemory = "memory" // Parquet rows held in memory, without SQL. Profiles and entity checks still work
+)
+
+// Environment variable picking the storage backend when none is configured
+const StorageBackendEnv = "STORAGE_BACKEND"
+
+// Dataset the tools read. Backends without SQL return ErrSQLBackendUnavailable from SQL,
+// tools needing full queries fail with it instead of the whole agent
+type Store interface {
+	// Name of the backend, StorageDuckDB or StorageMemory
+	Backend() string
+	// SQL database holding the dataset table
+	SQL() (*sql.DB, error)
+	// Rows of the dataset table
+	RowCount(ctx context.Context) (int64, error)
+	// Columns of the dataset table, in order
+	Columns(ctx context.Context) ([]string, error)
+	// Whether a column holds a value, compared as text and case insensitively
+	ContainsValue(ctx context.Context, column string, value string) (bool, error)
+	// Statistics of each column of the dataset table
+	Profile(ctx context.Context) (DatasetProfile, error)
+	// Check the storage answers
+	Ping(ctx context.Context) error
+	// Reload the dataset table from dataPath, with the sample it was opened with.
+	// Fails with ErrReadOnly on read-only databases
+	Refresh(dataPath string) error
+	// Reload the dataset table when the data file at dataPath changed since it was loaded. Data that can't
+	// be fingerprinted, like remote files and live databases, and read-only databases are never reloaded
+	RefreshIfChanged(dataPath string) (DataChange, error)
+	// Whether the storage can't write, like a database opened read-only
+	ReadOnly(ctx context.Context) (bool, error)
+	Close() error
+}
+
+// Open the dataset storage of backend, StorageBackendEnv when empty. Without a backend DuckDB is used,
+// falling back to the memory backend when DuckDB can't initialize, like on builds without cgo.
+// Arguments are the ones of OpenDatabase, only DuckDB takes samples. Returns whether the dataset table was reloaded
+func OpenStore(backend string, databasePath string, dataPath string, sample Sample, forceRefresh bool, readOnly bool) (Store, bool, error) {
+	if backend == "" {
+		backend = strings.ToLower(strings.TrimSpace(os.Getenv(StorageBackendEnv)))
+	}
+
+	switch backend {
+	case StorageMemory:
+		if !sample.IsZero() {
+			return nil, false, fmt.Errorf("the %s storage backend can't sample the dataset, use %s", StorageMemory, StorageDuckDB)
+		}
+		store, err := OpenMemoryStore(dataPath)
+		return store, false, err
+	case "":
+		if err := duckDBAvailable(); err != nil {
+			if !sample.IsZero() {
+				return nil, false, fmt.Errorf("DuckDB can't initialize to sample the dataset: %w", err)
+			}
+			log.Printf("WARNING: DuckDB can't initialize, using the %s storage backend without SQL: %s\n", StorageMemory, err)
+			store, err := OpenMemoryStore(dataPath)
+			return store, false, err
+		}
+	case StorageDuckDB:
+	default:
+		return nil, false, fmt.Errorf("unknown storage backend '%s', use %s or %s", backend, StorageDuckDB, StorageMemory)
+	}
+
+	db, refreshed, err := OpenDatabase(databasePath, dataPath, sample, forceRefresh, readOnly)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// MotherDuck is queried over the network, every other database is locked once the dataset is loaded
+	store := &duckDBStore{db: db, databasePath: databasePath, sample: sample, readOnly: readOnly}
+	if !IsMotherDuckDSN(dataPath) {
+		if err := lockExternalAccess(db); err != nil {
+			db.Close()
+			return nil, false, err
+		}
+		store.locked = true
+	}
+
+	return store, refreshed, nil
+}
+
+// Stop the database from reading files and URLs, so lookups can only query what the bootstrap loaded.
+// The configuration is locked too, a lookup can't enable access again
+func lockExternalAccess(db *sql.DB) error {
+	for _, statement := range []string{"SET enable_external_access = false", "SET lock_configuration = true"} {
+		if _, err := db.Exec(statement); err != nil {
+			return fmt.Errorf("failed to lock the database configuration: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Check the DuckDB driver is built in and starts
+func duckDBAvailable() error {
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Ping()
+}
+
+/*
+------------
+DuckDB store
+------------
+*/
+
+type duckDBStore struct {
+	db           *sql.DB
+	databasePath string // Database file, empty in memory
+	sample       Sample // Part of the data file refreshes load
+	readOnly     bool   // Opened read-only, refreshes can't reload the table
+	locked       bool   // External access locked, refreshes reopen the database to read the data file
+	mutex        sync.RWMutex
+}
+
+// Store of a DuckDB database holding the whole dataset table, see OpenDatabase. Closing it closes db
+func NewDuckDBStore(db *sql.DB) Store {
+	return &duckDBStore{db: db}
+}
+
+// Database of the store, replaced when a locked store reloads the dataset
+func (s *duckDBStore) database() *sql.DB {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.db
+}
+
+func (s *duckDBStore) Backend() string {
+	return StorageDuckDB
+}
+
+func (s *duckDBStore) SQL() (*sql.DB, error) {
+	return s.database(), nil
+}
+
+func (s *duckDBStore) RowCount(ctx context.Context) (int64, error) {
+	rowCount := int64(0)
+	err := s.database().QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", tableName)).Scan(&rowCount)
+	return rowCount, err
+}
+
+func (s *duckDBStore) Columns(ctx context.Context) ([]string, error) {
+	return datasetColumns(ctx, s.database())
+}
+
+func (s *duckDBStore) ContainsValue(ctx context.Context, column string, value string) (bool, error) {
+	query := fmt.Sprintf(
+		`SELECT 1 FROM %s WHERE lower(CAST("%s" AS VARCHAR)) = lower(?) LIMIT 1`,
+		tableName, strings.ReplaceAll(column, `"`, `""`),
+	)
+	found := 0
+	err := s.database().QueryRowContext(ctx, query, value).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, &ErrSQLExecution{Query: query, DBErr: err}
+	}
+
+	return true, nil
+}
+
+func (s *duckDBStore) Profile(ctx context.Context) (DatasetProfile, error) {
+	return profileTable(ctx, s.database(), tableName)
+}
+
+func (s *duckDBStore) Ping(ctx context.Context) error {
+	return s.database().PingContext(ctx)
+}
+
+func (s *duckDBStore) Refresh(dataPath string) error {
+	if s.readOnly {
+		return &ErrReadOnly{Operation: "reloading the dataset"}
+	}
+	if !s.locked || IsLiveDatabase(dataPath) {
+		return RefreshViews(s.database(), dataPath, s.sample)
+	}
+
+	return s.reopen(dataPath)
+}
+
+func (s *duckDBStore) RefreshIfChanged(dataPath string) (DataChange, error) {
+	if s.readOnly || IsLiveDatabase(dataPath) || IsRemotePath(dataPath) {
+		return DataChange{}, nil
+	}
+
+	changed, fingerprint, err := checkFingerprint(s.database(), dataPath, s.sample)
+	if err != nil || !changed {
+		return DataChange{}, err
+	}
+	if s.locked {
+		if err := s.reopen(dataPath); err != nil {
+			return DataChange{}, err
+		}
+		return DataChange{Reloaded: true, UpdatedAt: fingerprint.ModTime}, nil
+	}
+
+	if err := loadTable(s.database(), dataPath, s.sample, true); err != nil {
+		return DataChange{}, err
+	}
+	if err := saveFingerprint(s.database(), tableName, fingerprint); err != nil {
+		return DataChange{}, err
+	}
+
+	log.Printf("Refreshed dataset '%s' from %s\n", tableName, dataPath)
+	return DataChange{Reloaded: true, UpdatedAt: fingerprint.ModTime}, nil
+}
+
+// Reload the dataset table on a new handle of the database, locked again once loaded. A locked database
+// can't read the data file anymore. Queries must not be running, the previous handle is closed
+func (s *duckDBStore) reopen(dataPath string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Handles of a database file in the same process overwrite each other, the previous one is closed first.
+	// In memory the previous database is kept until the new one loads
+	if s.databasePath != "" {
+		s.db.Close()
+	}
+
+	db, _, err := OpenDatabase(s.databasePath, dataPath, s.sample, true, false)
+	if err == nil {
+		if err = lockExternalAccess(db); err != nil {
+			db.Close()
+		}
+	}
+	if err != nil {
+		if s.databasePath == "" {
+			return err
+		}
+
+		// Keep serving the table the file had before the failed reload
+		previous, openErr := sql.Open("duckdb", s.databasePath)
+		if openErr == nil {
+			if openErr = lockExternalAccess(previous); openErr != nil {
+				previous.Close()
+			}
+		}
+		if openErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to reopen database %s: %w", s.databasePath, openErr))
+		}
+		s.db = previous
+		return err
+	}
+
+	if s.databasePath == "" {
+		s.db.Close()
+	}
+	s.db = db
+	return nil
+}
+
+func (s *duckDBStore) ReadOnly(ctx context.Context) (bool, error) {
+	return IsReadOnly(ctx, s.database())
+}
+
+func (s *duckDBStore) Close() error {
+	return s.database().Close()
+}
@@ -0,0 +1,221 @@
+package tools
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+-------------------------
+Column statistics summary
+-------------------------
+*/
+
+// Amount of data rows sent to the model alongside the column summary
+const summarySampleRows = 20
+
+// Amount of most frequent values listed for text columns
+const summaryTopValues = 3
+
+// Column type guesses
+const (
+	numericColumn = "numeric"
+	dateColumn    = "date"
+	textColumn    = "text"
+)
+
+// Compact statistics for one column of tool data
+type columnSummary struct {
+	Name      string
+	Type      string
+	Unit      string // Unit of Min and Max on numeric columns with one, already scaled
+	Distinct  int
+	Min       string
+	Max       string
+	Sum       string // Sum of numeric columns, scaled like Min and Max
+	TopValues []string
+}
+
+// Parsed tool data: header columns and rows of values
+type tabularData struct {
+	Columns []string
+	Rows    [][]string
+}
+
+// Lines that are only tags, like the delimiters wrapped around tool output
+var tagLineRegex = regexp.MustCompile(`^</?[A-Za-z_]+>$`)
+var dateValueRegex = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}`)
+
+// Parse data in the LookUpSalesData output format: a comma separated header line,
+// then one comma separated line per row. Tag lines and comments are ignored
+func parseTabularData(data string) tabularData {
+	parsed := tabularData{Columns: []string{}, Rows: [][]string{}}
+	for line := range strings.SplitSeq(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || tagLineRegex.MatchString(line) || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		values := strings.Split(line, ", ")
+		if len(parsed.Columns) == 0 {
+			parsed.Columns = values
+			continue
+		}
+
+		// Skip lines that aren't rows of the table, like free text after it
+		if len(values) != len(parsed.Columns) {
+			continue
+		}
+
+		parsed.Rows = append(parsed.Rows, values)
+	}
+
+	return parsed
+}
+
+// Guess the type of a column from its values
+func guessColumnType(values []string) string {
+	isNumeric, isDate := true, true
+	for _, value := range values {
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			isNumeric = false
+		}
+		if !dateValueRegex.MatchString(value) {
+			isDate = false
+		}
+	}
+
+	switch {
+	case len(values) == 0:
+		return textColumn
+	case isNumeric:
+		return numericColumn
+	case isDate:
+		return dateColumn
+	}
+
+	return textColumn
+}
+
+// Compute statistics for each column of parsed data. Numeric columns with a unit are scaled to the values shown to users
+func summarizeColumns(data tabularData, units *ColumnUnits) []columnSummary {
+	summaries := []columnSummary{}
+	for i, name := range data.Columns {
+		values := []string{}
+		counts := map[string]int{}
+		for _, row := range data.Rows {
+			if row[i] == "" || row[i] == "<nil>" {
+				continue
+			}
+			values = append(values, row[i])
+			counts[row[i]]++
+		}
+
+		summary := columnSummary{Name: name, Type: guessColumnType(values), Distinct: len(counts)}
+		switch summary.Type {
+		case numericColumn:
+			unit, hasUnit := units.For(name)
+			numbers := []float64{}
+			sum := 0.0
+			for _, value := range values {
+				number, _ := strconv.ParseFloat(value, 64)
+				numbers = append(numbers, unit.Display(number))
+				sum += unit.Display(number)
+			}
+			if hasUnit {
+				summary.Unit = unit.DisplayUnit()
+			}
+			summary.Min = strconv.FormatFloat(slices.Min(numbers), 'g', 6, 64)
+			summary.Max = strconv.FormatFloat(slices.Max(numbers), 'g', 6, 64)
+			summary.Sum = strconv.FormatFloat(sum, 'g', 6, 64)
+		case dateColumn:
+			// Dates in ISO format sort lexically
+			summary.Min = slices.Min(values)
+			summary.Max = slices.Max(values)
+		default:
+			distinct := []string{}
+			for value := range counts {
+				distinct = append(distinct, value)
+			}
+			slices.SortFunc(distinct, func(a string, b string) int {
+				if counts[a] != counts[b] {
+					return counts[b] - counts[a]
+				}
+				return strings.Compare(a, b)
+			})
+			summary.TopValues = distinct[:min(summaryTopValues, len(distinct))]
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	return summaries
+}
+
+// Model facing summary of tool data: one line of statistics per column, in the display units of units,
+// followed by the header and at most sampleRows data rows, as stored.
+// Data that can't be parsed as a table is returned unchanged
+func SummarizeData(data string, sampleRows int, units *ColumnUnits) string {
+	parsed := parseTabularData(data)
+	if len(parsed.Rows) == 0 {
+		return data
+	}
+
+	lines := []string{fmt.Sprintf("Rows: %d", len(parsed.Rows)), "Columns:"}
+	for _, summary := range summarizeColumns(parsed, units) {
+		line := fmt.Sprintf("- %s (%s, %d distinct", summary.Name, summary.Type, summary.Distinct)
+		if summary.Unit != "" {
+			line = fmt.Sprintf("- %s (%s in %s, %d distinct", summary.Name, summary.Type, summary.Unit, summary.Distinct)
+		}
+		if summary.Min != "" {
+			line += fmt.Sprintf(", min %s, max %s", summary.Min, summary.Max)
+		}
+		if len(summary.TopValues) != 0 {
+			line += fmt.Sprintf(", top values: %s", strings.Join(summary.TopValues, " | "))
+		}
+		lines = append(lines, line+")")
+	}
+
+	shownRows := min(sampleRows, len(parsed.Rows))
+	lines = append(lines, fmt.Sprintf("First %d rows:", shownRows), strings.Join(parsed.Columns, ", "))
+	for _, row := range parsed.Rows[:shownRows] {
+		lines = append(lines, strings.Join(row, ", "))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Label starting the summaries made without a model
+const fallbackSummaryLabel = "Automated summary (LLM unavailable)"
+
+// Factual summary of tool data computed without a model, for when the analysis completion fails:
+// row count, columns, min, max and sum of numeric columns and the range of date columns.
+// False when the data can't be parsed as a table, so there is nothing grounded to say
+func FallbackSummary(data string, units *ColumnUnits) (string, bool) {
+	parsed := parseTabularData(data)
+	if len(parsed.Rows) == 0 {
+		return "", false
+	}
+
+	lines := []string{
+		fmt.Sprintf("%s, computed from the data without analysis:", fallbackSummaryLabel),
+		fmt.Sprintf("- %d rows with columns %s", len(parsed.Rows), strings.Join(parsed.Columns, ", ")),
+	}
+	for _, summary := range summarizeColumns(parsed, units) {
+		switch summary.Type {
+		case numericColumn:
+			line := fmt.Sprintf("- %s: min %s, max %s, sum %s", summary.Name, summary.Min, summary.Max, summary.Sum)
+			if summary.Unit != "" {
+				line += " " + summary.Unit
+			}
+			lines = append(lines, line)
+		case dateColumn:
+			lines = append(lines, fmt.Sprintf("- %s: from %s to %s", summary.Name, summary.Min, summary.Max))
+		}
+	}
+
+	return strings.Join(lines, "\n"), true
+}
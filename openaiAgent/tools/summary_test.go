@@ -0,0 +1,129 @@
+package tools
+
+import (
+	"strings"
+	"testing"
+)
+
+// Lookup output with a numeric, a date and a text column, wrapped in the tool tags
+const summaryData = `<data>
+Store_Number, Sold_Date, Product_Class_Code, Price_Cents
+1, 2024-01-03, A, 250
+2, 2024-01-01, B, 1000
+3, 2024-02-10, A, 50
+4, 2024-01-20, C, <nil>
+</data>`
+
+// Statistics of each column type, the rows shown after them, and data that isn't a table
+func TestSummarizeData(t *testing.T) {
+	cases := []struct {
+		name       string
+		data       string
+		sampleRows int
+		units      *ColumnUnits
+		want       string
+	}{
+		{
+			name:       "column statistics",
+			data:       summaryData,
+			sampleRows: summarySampleRows,
+			want: `Rows: 4
+Columns:
+- Store_Number (numeric, 4 distinct, min 1, max 4)
+- Sold_Date (date, 4 distinct, min 2024-01-01, max 2024-02-10)
+- Product_Class_Code (text, 3 distinct, top values: A | B | C)
+- Price_Cents (numeric, 3 distinct, min 50, max 1000)
+First 4 rows:
+Store_Number, Sold_Date, Product_Class_Code, Price_Cents
+1, 2024-01-03, A, 250
+2, 2024-01-01, B, 1000
+3, 2024-02-10, A, 50
+4, 2024-01-20, C, <nil>`,
+		},
+		{
+			name:       "scaled unit and first rows",
+			data:       summaryData,
+			sampleRows: 2,
+			units:      &ColumnUnits{units: []ColumnUnit{{Column: "price_cents", Unit: UnitCents, Scale: 0.01}}},
+			want: `Rows: 4
+Columns:
+- Store_Number (numeric, 4 distinct, min 1, max 4)
+- Sold_Date (date, 4 distinct, min 2024-01-01, max 2024-02-10)
+- Product_Class_Code (text, 3 distinct, top values: A | B | C)
+- Price_Cents (numeric in dollars, 3 distinct, min 0.5, max 10)
+First 2 rows:
+Store_Number, Sold_Date, Product_Class_Code, Price_Cents
+1, 2024-01-03, A, 250
+2, 2024-01-01, B, 1000`,
+		},
+		{
+			name:       "free text after the table",
+			data:       "Region, Total\nNorth, 10\nSouth, 5\nNorth and South are the only regions",
+			sampleRows: summarySampleRows,
+			want: `Rows: 2
+Columns:
+- Region (text, 2 distinct, top values: North | South)
+- Total (numeric, 2 distinct, min 5, max 10)
+First 2 rows:
+Region, Total
+North, 10
+South, 5`,
+		},
+		{name: "no rows", data: "Region, Total", sampleRows: summarySampleRows, want: "Region, Total"},
+		{name: "not a table", data: "No sales matched the filters", sampleRows: summarySampleRows, want: "No sales matched the filters"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if summary := SummarizeData(c.data, c.sampleRows, c.units); summary != c.want {
+				t.Errorf("summary:\n%s\nexpected:\n%s", summary, c.want)
+			}
+		})
+	}
+}
+
+// Only the most frequent text values are listed, ties in alphabetical order
+func TestSummaryTopValues(t *testing.T) {
+	data := strings.Join([]string{"Product_Class_Code", "D", "B", "A", "C", "B", "D", "E"}, "\n")
+	summaries := summarizeColumns(parseTabularData(data), nil)
+	if len(summaries) != 1 {
+		t.Fatalf("summarized %d columns, expected 1", len(summaries))
+	}
+	if top := strings.Join(summaries[0].TopValues, " | "); top != "B | D | A" {
+		t.Errorf("top values %s, expected B | D | A", top)
+	}
+	if summaries[0].Distinct != 5 {
+		t.Errorf("%d distinct values, expected 5", summaries[0].Distinct)
+	}
+}
+
+// The summary made without a model states the numeric and date columns only, in display units
+func TestFallbackSummary(t *testing.T) {
+	cases := []struct {
+		name  string
+		data  string
+		units *ColumnUnits
+		want  string // Empty when there is nothing to summarize
+	}{
+		{
+			name:  "numeric and date columns",
+			data:  summaryData,
+			units: &ColumnUnits{units: []ColumnUnit{{Column: "Price_Cents", Unit: UnitCents, Scale: 0.01}}},
+			want: fallbackSummaryLabel + `, computed from the data without analysis:
+- 4 rows with columns Store_Number, Sold_Date, Product_Class_Code, Price_Cents
+- Store_Number: min 1, max 4, sum 10
+- Sold_Date: from 2024-01-01 to 2024-02-10
+- Price_Cents: min 0.5, max 10, sum 13 dollars`,
+		},
+		{name: "not a table", data: "The query returned no rows"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			summary, ok := FallbackSummary(c.data, c.units)
+			if ok != (c.want != "") || summary != c.want {
+				t.Errorf("summary (%t):\n%s\nexpected:\n%s", ok, summary, c.want)
+			}
+		})
+	}
+}
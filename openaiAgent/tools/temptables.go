@@ -0,0 +1,252 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+/*
+-------------------
+Statement validator
+-------------------
+*/
+
+// Statements a lookup can run besides temporary tables, by their first keyword
+var readStatements = []string{"SELECT", "WITH", "FROM", "VALUES", "TABLE", "DESCRIBE", "SUMMARIZE", "SHOW", "PIVOT", "UNPIVOT"}
+
+// Keywords of statements writing to the database or reaching outside of it, rejected anywhere in a lookup
+var writeKeywordRegex = regexp.MustCompile(
+	`(?i)\b(INSERT|UPDATE|DELETE|DROP|ALTER|CREATE|ATTACH|DETACH|COPY|EXPORT|IMPORT|INSTALL|LOAD|PRAGMA|SET|RESET|CALL|TRUNCATE|VACUUM|CHECKPOINT)\b`,
+)
+
+// Table functions reading files and URLs, like read_text('/etc/passwd'), glob('/root/**') or parquet_scan(...)
+var fileFunctionRegex = regexp.MustCompile(`(?i)\b(read_\w+|glob|\w+_scan)\s*\(`)
+
+// Literals and comments, masked before looking at the keywords of a statement
+var sqlMaskRegex = regexp.MustCompile(`(?s)'(?:[^']|'')*'|"(?:[^"]|"")*"|--[^\n]*|/\*.*?\*/`)
+
+// Double-quoted identifier that could be written unquoted
+var plainIdentifierRegex = regexp.MustCompile(`^"\w+"$`)
+
+// The only statement creating a table a lookup can run. Groups are the table name and its query
+var createTempTableRegex = regexp.MustCompile(
+	`(?is)^CREATE\s+(?:OR\s+REPLACE\s+)?TEMP(?:ORARY)?\s+TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?([A-Za-z_][A-Za-z0-9_]*)\s+AS\s+(.+)$`,
+)
+
+// Check a generated query only reads data, besides creating a temporary table named with namespace.
+// Returns the name of the table the query creates, empty for reads
+func ValidateSQL(query string, namespace string) (string, error) {
+	masked := strings.TrimSpace(strings.TrimRight(maskSQL(query, false), "; \t\n"))
+	if masked == "" {
+		return "", &ErrSQLGeneration{Reason: "the model returned no query"}
+	}
+	if strings.Contains(masked, ";") {
+		return "", &ErrSQLGeneration{Reason: "a lookup runs a single statement"}
+	}
+
+	createdTable := ""
+	if match := createTempTableRegex.FindStringSubmatch(masked); match != nil {
+		createdTable = strings.ToLower(match[1])
+		if !strings.HasPrefix(createdTable, namespace) {
+			return "", &ErrSQLGeneration{
+				Reason: fmt.Sprintf("temporary table '%s' must be named with the prefix %s", match[1], namespace),
+			}
+		}
+		masked = strings.TrimSpace(match[2])
+	} else if strings.HasPrefix(strings.ToUpper(masked), "CREATE") {
+		return "", &ErrSQLGeneration{
+			Reason: fmt.Sprintf("only temporary tables named with the prefix %s can be created, with CREATE TEMPORARY TABLE ... AS SELECT", namespace),
+		}
+	}
+
+	firstKeyword := strings.ToUpper(strings.TrimLeft(strings.Fields(masked)[0], "("))
+	if !slices.Contains(readStatements, firstKeyword) {
+		return "", &ErrSQLGeneration{Reason: fmt.Sprintf("the query must only read data, found a statement starting with %s", firstKeyword)}
+	}
+	if keyword := writeKeywordRegex.FindString(masked); keyword != "" {
+		return "", &ErrSQLGeneration{Reason: fmt.Sprintf("the query must only read data, found %s", strings.ToUpper(keyword))}
+	}
+	// Functions may be called by their quoted name, like "read_csv"('/etc/passwd'), which masking hides
+	if match := fileFunctionRegex.FindStringSubmatch(maskSQL(query, true)); match != nil {
+		return "", &ErrSQLGeneration{
+			Reason: fmt.Sprintf("the query must only read the dataset tables, found the file function %s", strings.ToLower(match[1])),
+		}
+	}
+
+	return createdTable, nil
+}
+
+// Blank out the literals and comments of a query, leaving empty quotes. With unquoteIdentifiers, double-quoted
+// identifiers that are plain names, like "read_csv", are unquoted instead
+func maskSQL(query string, unquoteIdentifiers bool) string {
+	return strings.TrimSpace(sqlMaskRegex.ReplaceAllStringFunc(query, func(match string) string {
+		switch {
+		case strings.HasPrefix(match, "--") || strings.HasPrefix(match, "/*"):
+			return " "
+		case unquoteIdentifiers && plainIdentifierRegex.MatchString(match):
+			return match[1 : len(match)-1]
+		}
+		return match[:1] + match[:1]
+	}))
+}
+
+/*
+----------------
+Temporary tables
+----------------
+*/
+
+// Table created by a lookup of the run, so later lookups can build on it
+type TempTable struct {
+	Name    string   // Name of the table, starting with the namespace of the run
+	Columns []string // Columns of the table, in order
+	Rows    int64    // Rows of the table when created
+}
+
+// Runs of the process that created temporary tables, numbering their namespaces
+var tempNamespaces atomic.Int64
+
+// Anything running queries, the database or the connection pinned by the run
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// Prefix the temporary tables of the run must be named with. Temporary tables only live on the
+// connection of the process creating them, so numbering the runs of the process keeps them apart
+func (t *Toolbox) TempNamespace() string {
+	if t.tempNamespace == "" {
+		t.tempNamespace = fmt.Sprintf("tmp_r%d_", tempNamespaces.Add(1))
+	}
+
+	return t.tempNamespace
+}
+
+// Temporary tables created on the run so far
+func (t *Toolbox) TempTables() []TempTable {
+	return slices.Clone(t.tempTables)
+}
+
+// Where the queries of the run go. Temporary tables only exist on the connection creating them,
+// so once the run creates one every later query uses that connection
+func (t *Toolbox) queryer() (queryer, error) {
+	if t.tempConn != nil {
+		return t.tempConn, nil
+	}
+
+	return t.store.SQL()
+}
+
+// Pin a connection to the run, before creating its first temporary table
+func (t *Toolbox) pinConnection(ctx context.Context) error {
+	if t.tempConn != nil {
+		return nil
+	}
+
+	db, err := t.store.SQL()
+	if err != nil {
+		return err
+	}
+
+	t.tempConn, err = db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to reserve a connection for temporary tables: %w", err)
+	}
+
+	return nil
+}
+
+// Record a temporary table created by a lookup, reading its columns and rows
+func (t *Toolbox) recordTempTable(ctx context.Context, name string) (TempTable, error) {
+	table := TempTable{Name: name}
+
+	rows, err := t.tempConn.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s LIMIT 0", name))
+	if err != nil {
+		return table, fmt.Errorf("failed to read temporary table '%s': %w", name, err)
+	}
+	table.Columns, err = rows.Columns()
+	rows.Close()
+	if err != nil {
+		return table, fmt.Errorf("failed to read columns of temporary table '%s': %w", name, err)
+	}
+
+	err = t.tempConn.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", name)).Scan(&table.Rows)
+	if err != nil {
+		return table, fmt.Errorf("failed to count rows of temporary table '%s': %w", name, err)
+	}
+
+	// Replaced tables keep their place
+	index := slices.IndexFunc(t.tempTables, func(existing TempTable) bool { return existing.Name == name })
+	if index < 0 {
+		t.tempTables = append(t.tempTables, table)
+	} else {
+		t.tempTables[index] = table
+	}
+
+	return table, nil
+}
+
+// Model facing description of the temporary tables of the run, for the SQL generation prompt
+func (t *Toolbox) tempTablesDescription() string {
+	if len(t.tempTables) == 0 {
+		return "none"
+	}
+
+	lines := []string{}
+	for _, table := range t.tempTables {
+		lines = append(lines, fmt.Sprintf("- %s (%d rows): %s", table.Name, table.Rows, strings.Join(table.Columns, ", ")))
+	}
+
+	return "\n" + strings.Join(lines, "\n")
+}
+
+// Drop the temporary tables of the run and release its connection, once the run ends.
+// Failures are only logged, closing the run doesn't depend on them
+func (t *Toolbox) DropTempTables(ctx context.Context) {
+	if t.tempConn == nil {
+		return
+	}
+
+	log.Printf("Dropping %d temporary tables of run '%s'\n", len(t.tempTables), t.RunID)
+	for _, table := range t.tempTables {
+		if _, err := t.tempConn.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", table.Name)); err != nil {
+			log.Printf("WARNING: Failed to drop temporary table '%s': %s\n", table.Name, err)
+		}
+	}
+
+	if err := t.tempConn.Close(); err != nil {
+		log.Printf("WARNING: Failed to release the connection of run '%s': %s\n", t.RunID, err)
+	}
+	t.tempConn = nil
+	t.tempTables = nil
+}
+
+// Run a lookup creating a temporary table. Its result describes the table instead of holding its rows,
+// which later lookups read from the table
+func (t *Toolbox) createTempTable(ctx context.Context, lookupResult LookupResult, name string) (LookupResult, error) {
+	if err := t.pinConnection(ctx); err != nil {
+		return lookupResult, err
+	}
+
+	if _, _, err := t.runAuditedQuery(ctx, LookUpFuncName, AuditSourceGenerated, lookupResult.SQL); err != nil {
+		return lookupResult, &ErrSQLExecution{Query: lookupResult.SQL, DBErr: err}
+	}
+
+	table, err := t.recordTempTable(ctx, name)
+	if err != nil {
+		return lookupResult, &ErrSQLExecution{Query: lookupResult.SQL, DBErr: err}
+	}
+	log.Printf("Created temporary table '%s' with %d rows\n", table.Name, table.Rows)
+
+	t.recordTouchedDataset(tableName, table.Columns)
+	lookupResult.Columns = []string{"temporary_table", "rows", "columns"}
+	lookupResult.Rows = [][]any{{table.Name, table.Rows, strings.Join(table.Columns, ", ")}}
+	lookupResult.TotalRows = 1
+
+	return lookupResult, nil
+}
@@ -0,0 +1,136 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testNamespace = "tmp_r1_"
+
+func TestValidateSQL(t *testing.T) {
+	cases := []struct {
+		name    string
+		query   string
+		created string
+		reason  string // Part of the rejection reason, empty when the query is valid
+	}{
+		{name: "select", query: "SELECT sum(Total_Sale_Value) FROM sales;"},
+		{name: "with", query: "WITH s AS (SELECT * FROM sales) SELECT count(*) FROM s"},
+		{name: "function names in literals", query: "SELECT 'read_text(x)' AS label, \"glob(\" FROM sales"},
+		{name: "function names in comments", query: "-- glob('/root/**')\nSELECT * FROM sales"},
+		{name: "similar column names", query: "SELECT read_count, scan_date FROM sales"},
+		{name: "quoted column names", query: "SELECT \"read_count\", \"glob\" FROM sales"},
+		{name: "temporary table", query: "CREATE TEMP TABLE tmp_r1_stores AS SELECT DISTINCT Store_Number FROM sales", created: "tmp_r1_stores"},
+
+		{name: "empty", query: " ; ", reason: "no query"},
+		{name: "several statements", query: "SELECT 1; SELECT 2", reason: "single statement"},
+		{name: "write", query: "DELETE FROM sales", reason: "starting with DELETE"},
+		{name: "write in a read", query: "SELECT * FROM sales WHERE 1 = (DROP TABLE sales)", reason: "found DROP"},
+		{name: "enable access", query: "SELECT 1; SET enable_external_access = true", reason: "single statement"},
+		{name: "table outside the namespace", query: "CREATE TEMP TABLE stores AS SELECT 1", reason: "prefix tmp_r1_"},
+		{name: "persistent table", query: "CREATE TABLE tmp_r1_stores AS SELECT 1", reason: "only temporary tables"},
+
+		{name: "read_text", query: "SELECT * FROM read_text('/etc/passwd')", reason: "file function read_text"},
+		{name: "glob", query: "SELECT * FROM glob('/root/**')", reason: "file function glob"},
+		{name: "remote read_csv", query: "SELECT * FROM read_csv('https://example.com/data.csv')", reason: "file function read_csv"},
+		{name: "upper case read_parquet", query: "SELECT count(*) FROM READ_PARQUET ('data.parquet')", reason: "file function read_parquet"},
+		{name: "quoted read_csv", query: "SELECT * FROM \"read_csv\"('/etc/passwd')", reason: "file function read_csv"},
+		{name: "quoted schema and glob", query: "SELECT * FROM \"main\".\"GLOB\" ('/root/**')", reason: "file function glob"},
+		{name: "scan", query: "SELECT * FROM sales JOIN parquet_scan('other.parquet') USING (SKU_Coded)", reason: "file function parquet_scan"},
+		{
+			name:   "file function in a temporary table",
+			query:  "CREATE TEMP TABLE tmp_r1_keys AS SELECT * FROM read_json_auto('/root/.ssh/keys.json')",
+			reason: "file function read_json_auto",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			created, err := ValidateSQL(c.query, testNamespace)
+			if c.reason == "" {
+				if err != nil || created != c.created {
+					t.Fatalf("expected a valid query creating '%s', got '%s': %v", c.created, created, err)
+				}
+				return
+			}
+
+			var generationErr *ErrSQLGeneration
+			if !errors.As(err, &generationErr) {
+				t.Fatalf("expected a SQL generation error, got %v", err)
+			}
+			if !strings.Contains(generationErr.Reason, c.reason) {
+				t.Fatalf("expected the reason to mention '%s', got '%s'", c.reason, generationErr.Reason)
+			}
+		})
+	}
+}
+
+// Whatever gets past the validator, the database of the store can't read files or be unlocked
+func TestStoreLocksExternalAccess(t *testing.T) {
+	store, err := openFixtureStore(t, StorageDuckDB, "sales.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := store.SQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	denied := []string{
+		"SELECT * FROM read_text('/etc/passwd')",
+		"SELECT * FROM glob('/root/**')",
+		"SELECT * FROM '" + filepath.Join(datasetFixtures, "sales.parquet") + "'",
+		"SET enable_external_access = true",
+		"SET lock_configuration = false",
+	}
+	for _, query := range denied {
+		if _, err := db.Exec(query); err == nil {
+			t.Errorf("expected '%s' to be denied", query)
+		}
+	}
+
+	if rows, err := store.RowCount(context.Background()); err != nil || rows != 3 {
+		t.Fatalf("expected lookups to still read the 3 rows of the dataset, got %d: %v", rows, err)
+	}
+}
+
+// Reloads reopen the locked database to read the changed file, and lock it again
+func TestLockedStoreRefreshes(t *testing.T) {
+	if err := duckDBAvailable(); err != nil {
+		t.Skipf("DuckDB can't initialize: %s", err)
+	}
+	dataPath := copyDatasetFixture(t)
+	store, _, err := OpenStore(StorageDuckDB, filepath.Join(t.TempDir(), "data.db"), dataPath, Sample{}, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	empty, err := os.ReadFile(filepath.Join(datasetFixtures, "empty.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dataPath, empty, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	change, err := store.RefreshIfChanged(dataPath)
+	if err != nil || !change.Reloaded {
+		t.Fatalf("expected the changed file to be reloaded, got %+v: %v", change, err)
+	}
+	if rows, err := store.RowCount(context.Background()); err != nil || rows != 0 {
+		t.Fatalf("expected the reloaded table to have no rows, got %d: %v", rows, err)
+	}
+
+	if err := store.Refresh(dataPath); err != nil {
+		t.Fatal(err)
+	}
+	db, _ := store.SQL()
+	if _, err := db.Exec("SELECT * FROM read_text('/etc/passwd')"); err == nil {
+		t.Fatal("expected the reopened database to be locked")
+	}
+}
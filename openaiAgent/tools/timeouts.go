@@ -0,0 +1,97 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+-------------
+Tool timeouts
+-------------
+*/
+
+// Max duration of each tool call. Zero values fall back to DefaultToolTimeouts
+type ToolTimeouts struct {
+	Lookup    time.Duration
+	Analyze   time.Duration
+	Visualize time.Duration
+}
+
+var DefaultToolTimeouts = ToolTimeouts{
+	Lookup:    30 * time.Second,
+	Analyze:   60 * time.Second,
+	Visualize: 45 * time.Second,
+}
+
+// Fill zero timeouts with their defaults
+func (tt ToolTimeouts) WithDefaults() ToolTimeouts {
+	if tt.Lookup <= 0 {
+		tt.Lookup = DefaultToolTimeouts.Lookup
+	}
+	if tt.Analyze <= 0 {
+		tt.Analyze = DefaultToolTimeouts.Analyze
+	}
+	if tt.Visualize <= 0 {
+		tt.Visualize = DefaultToolTimeouts.Visualize
+	}
+
+	return tt
+}
+
+// Timeout of a tool by function name. Unknown tools get the longest one
+func (tt ToolTimeouts) For(functionName string) time.Duration {
+	tt = tt.WithDefaults()
+	switch functionName {
+	case LookUpFuncName:
+		return tt.Lookup
+	case AnalyzeFuncName:
+		return tt.Analyze
+	case VisualizeFuncName:
+		return tt.Visualize
+	}
+
+	return max(tt.Lookup, tt.Analyze, tt.Visualize)
+}
+
+// Parse comma separated timeouts like "lookup=10s,visualize=1m". Tools left out keep their default
+func ParseToolTimeouts(timeouts string) (ToolTimeouts, error) {
+	parsed := ToolTimeouts{}
+	for entry := range strings.SplitSeq(timeouts, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, value, found := strings.Cut(entry, "=")
+		if !found {
+			return ToolTimeouts{}, fmt.Errorf("invalid tool timeout '%s', expected tool=duration", entry)
+		}
+
+		duration, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || duration <= 0 {
+			return ToolTimeouts{}, fmt.Errorf("invalid duration for tool timeout '%s'", entry)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "lookup":
+			parsed.Lookup = duration
+		case "analyze":
+			parsed.Analyze = duration
+		case "visualize":
+			parsed.Visualize = duration
+		default:
+			return ToolTimeouts{}, fmt.Errorf("unknown tool '%s' on tool timeouts", name)
+		}
+	}
+
+	return parsed.WithDefaults(), nil
+}
+
+// Whether the tool context ran out of time, as opposed to being cancelled
+func timedOut(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
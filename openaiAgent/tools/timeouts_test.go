@@ -0,0 +1,68 @@
+package tools
+
+import (
+	"testing"
+	"time"
+)
+
+// Tool timeouts parsed from the flag value, tools left out keep their default
+func TestParseToolTimeouts(t *testing.T) {
+	cases := []struct {
+		name    string
+		value   string
+		want    ToolTimeouts
+		wantErr bool
+	}{
+		{name: "defaults", value: "", want: DefaultToolTimeouts},
+		{name: "one tool", value: "lookup=10s", want: ToolTimeouts{Lookup: 10 * time.Second, Analyze: time.Minute, Visualize: 45 * time.Second}},
+		{
+			name:  "every tool",
+			value: " Lookup = 1m , analyze=90s,visualize=2m30s,",
+			want:  ToolTimeouts{Lookup: time.Minute, Analyze: 90 * time.Second, Visualize: 150 * time.Second},
+		},
+		{name: "missing duration", value: "lookup", wantErr: true},
+		{name: "invalid duration", value: "lookup=soon", wantErr: true},
+		{name: "negative duration", value: "analyze=-5s", wantErr: true},
+		{name: "unknown tool", value: "chart=5s", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			timeouts, err := ParseToolTimeouts(c.value)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected '%s' to be rejected, got %+v", c.value, timeouts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if timeouts != c.want {
+				t.Errorf("parsed %+v, expected %+v", timeouts, c.want)
+			}
+		})
+	}
+}
+
+// Timeouts by tool name: zero values use the default, tools without their own timeout get the longest one
+func TestToolTimeoutFor(t *testing.T) {
+	timeouts := ToolTimeouts{Lookup: 5 * time.Second, Visualize: 2 * time.Minute}
+	cases := []struct {
+		tool string
+		want time.Duration
+	}{
+		{tool: LookUpFuncName, want: 5 * time.Second},
+		{tool: AnalyzeFuncName, want: DefaultToolTimeouts.Analyze},
+		{tool: VisualizeFuncName, want: 2 * time.Minute},
+		{tool: ComparePeriodsFuncName, want: 2 * time.Minute},
+	}
+
+	for _, c := range cases {
+		t.Run(c.tool, func(t *testing.T) {
+			if timeout := timeouts.For(c.tool); timeout != c.want {
+				t.Errorf("timeout %s, expected %s", timeout, c.want)
+			}
+		})
+	}
+}
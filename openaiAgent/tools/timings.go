@@ -0,0 +1,115 @@
+package tools
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+-----------
+Run timings
+-----------
+*/
+
+// Steps timed on every run. Steps overlap: router calls include their rate limit waits,
+// and tools include their queries and completions
+const (
+	TimingTotal         = "total"           // Whole run
+	TimingRouter        = "router"          // Router completions
+	TimingDuckDB        = "duckdb"          // SQL executed by the tools
+	TimingRateLimitWait = "rate_limit_wait" // Completions waiting for rate limit budget
+)
+
+// Step name of a tool, like "tool.LookUpSalesData"
+func ToolTiming(functionName string) string {
+	return "tool." + functionName
+}
+
+// Time spent on a step of a run
+type StepTiming struct {
+	Count   int   `json:"count"`   // Times the step ran
+	TotalMs int64 `json:"totalMs"` // Time spent over every run of the step
+	MaxMs   int64 `json:"maxMs"`   // Longest run of the step
+}
+
+// Durations of the steps of a run, added up per step. Safe for concurrent steps.
+// Methods of nil collectors do nothing, so untimed callers need no checks
+type Timings struct {
+	steps map[string]stepDurations
+	now   func() time.Time // Time source of Since, replaceable to control durations
+	mutex sync.Mutex
+}
+
+type stepDurations struct {
+	count   int
+	total   time.Duration
+	longest time.Duration
+}
+
+func NewTimings() *Timings {
+	return newTimings(time.Now)
+}
+
+func newTimings(now func() time.Time) *Timings {
+	return &Timings{steps: map[string]stepDurations{}, now: now}
+}
+
+// Add a run of a step that took duration
+func (t *Timings) Add(step string, duration time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	durations := t.steps[step]
+	durations.count++
+	durations.total += duration
+	durations.longest = max(durations.longest, duration)
+	t.steps[step] = durations
+}
+
+// Add a run of a step started at start and ending now
+func (t *Timings) Since(step string, start time.Time) {
+	if t == nil {
+		return
+	}
+
+	t.Add(step, t.now().Sub(start))
+}
+
+// Time spent on each step so far
+func (t *Timings) Summary() map[string]StepTiming {
+	if t == nil {
+		return nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	summary := make(map[string]StepTiming, len(t.steps))
+	for step, durations := range t.steps {
+		summary[step] = StepTiming{
+			Count:   durations.count,
+			TotalMs: durations.total.Milliseconds(),
+			MaxMs:   durations.longest.Milliseconds(),
+		}
+	}
+
+	return summary
+}
+
+type timingsKey struct{}
+
+// Carry the timings of a run on its context, for steps made by components shared between runs
+func WithTimings(ctx context.Context, timings *Timings) context.Context {
+	return context.WithValue(ctx, timingsKey{}, timings)
+}
+
+// Timings of the run of a context, nil when it has none
+func TimingsFrom(ctx context.Context) *Timings {
+	timings, _ := ctx.Value(timingsKey{}).(*Timings)
+	return timings
+}
@@ -0,0 +1,124 @@
+package tools
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Step runs timed against a fake clock, each taking the given duration
+type timedStep struct {
+	step     string
+	duration time.Duration
+}
+
+// Steps are added up per step, with their count and longest run
+func TestTimings(t *testing.T) {
+	cases := []struct {
+		name  string
+		steps []timedStep
+		want  map[string]StepTiming
+	}{
+		{name: "no steps", want: map[string]StepTiming{}},
+		{
+			name: "single step",
+			steps: []timedStep{
+				{step: TimingTotal, duration: 1500 * time.Millisecond},
+			},
+			want: map[string]StepTiming{TimingTotal: {Count: 1, TotalMs: 1500, MaxMs: 1500}},
+		},
+		{
+			name: "repeated steps",
+			steps: []timedStep{
+				{step: TimingRouter, duration: 300 * time.Millisecond},
+				{step: ToolTiming(LookUpFuncName), duration: 2 * time.Second},
+				{step: TimingDuckDB, duration: 1200 * time.Millisecond},
+				{step: TimingRouter, duration: 700 * time.Millisecond},
+				{step: TimingRouter, duration: 250 * time.Millisecond},
+			},
+			want: map[string]StepTiming{
+				TimingRouter:             {Count: 3, TotalMs: 1250, MaxMs: 700},
+				"tool." + LookUpFuncName: {Count: 1, TotalMs: 2000, MaxMs: 2000},
+				TimingDuckDB:             {Count: 1, TotalMs: 1200, MaxMs: 1200},
+			},
+		},
+		{
+			name: "sub millisecond steps",
+			steps: []timedStep{
+				{step: TimingRateLimitWait, duration: 400 * time.Microsecond},
+				{step: TimingRateLimitWait, duration: 800 * time.Microsecond},
+			},
+			want: map[string]StepTiming{TimingRateLimitWait: {Count: 2, TotalMs: 1, MaxMs: 0}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+			timings := newTimings(func() time.Time { return now })
+			for _, step := range c.steps {
+				start := now
+				now = now.Add(step.duration)
+				timings.Since(step.step, start)
+			}
+
+			if summary := timings.Summary(); !reflect.DeepEqual(summary, c.want) {
+				t.Errorf("summary %+v, expected %+v", summary, c.want)
+			}
+		})
+	}
+}
+
+// Parallel tools add to the same collector, run with -race
+func TestTimingsConcurrently(t *testing.T) {
+	const steps = 64
+	timings := NewTimings()
+	var wg sync.WaitGroup
+	for i := range steps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			timings.Add(ToolTiming(LookUpFuncName), time.Duration(i+1)*time.Millisecond)
+			timings.Add(TimingDuckDB, time.Millisecond)
+		}()
+	}
+	wg.Wait()
+
+	summary := timings.Summary()
+	if lookup := summary[ToolTiming(LookUpFuncName)]; lookup != (StepTiming{Count: steps, TotalMs: steps * (steps + 1) / 2, MaxMs: steps}) {
+		t.Errorf("lookup timing %+v after %d parallel steps", lookup, steps)
+	}
+	if duckDB := summary[TimingDuckDB]; duckDB != (StepTiming{Count: steps, TotalMs: steps, MaxMs: 1}) {
+		t.Errorf("DuckDB timing %+v after %d parallel steps", duckDB, steps)
+	}
+}
+
+// Steps already timed once are added without allocating
+func TestTimingsAllocations(t *testing.T) {
+	timings := NewTimings()
+	timings.Add(TimingRouter, time.Millisecond)
+	start := time.Now()
+	if allocs := testing.AllocsPerRun(100, func() { timings.Since(TimingRouter, start) }); allocs != 0 {
+		t.Errorf("timing a step allocates %.0f times", allocs)
+	}
+}
+
+// Runs without a collector time nothing, and callers need no checks
+func TestNilTimings(t *testing.T) {
+	timings := TimingsFrom(context.Background())
+	if timings != nil {
+		t.Fatal("a context without timings has a collector")
+	}
+	timings.Add(TimingRouter, time.Second)
+	timings.Since(TimingDuckDB, time.Now())
+	if summary := timings.Summary(); summary != nil {
+		t.Errorf("nil collector summary %+v", summary)
+	}
+
+	collector := NewTimings()
+	if TimingsFrom(WithTimings(context.Background(), collector)) != collector {
+		t.Error("the context doesn't carry its collector")
+	}
+}
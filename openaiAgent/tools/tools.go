@@ -0,0 +1,1149 @@
+package tools
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/language"
+	"github.com/EzequielGhR/goProjects/openaiAgent/prompts"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/invopop/jsonschema"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+/*
+--------------------------
+Important type definitions
+--------------------------
+*/
+
+// Chart configuration extracted from data by the visualization tool
+type ChartConfig struct {
+	ChartType string `json:"chartType" jsonschema_description:"Type of chart to generate"`
+	XAxis     string `json:"xAxis" jsonschema_description:"Name of the X Axis column, the numeric column for histograms and box plots"`
+	YAxis     string `json:"yAxis" jsonschema_description:"Name of the Y Axis column"`
+	Title     string `json:"title" jsonschema_description:"Title of the chart"`
+	BinCount  int    `json:"binCount" jsonschema_description:"Number of bins of a histogram, 0 for the default"`
+	BucketBy  string `json:"bucketBy" jsonschema_description:"Column splitting a box plot in one box per value, empty for a single box"`
+}
+
+type visualizationConfigData struct {
+	Config ChartConfig
+	Data   string
+}
+
+// Input of the typed lookup tool
+type LookupRequest struct {
+	Prompt  string // Natural language description of the data to look up
+	MaxRows int    // Max amount of rows to return. 0 returns every row
+}
+
+// Output of the typed lookup tool
+type LookupResult struct {
+	Columns      []string // Result column names
+	Rows         [][]any  // Result rows, typed as returned by the database driver
+	SQL          string   // Generated query that produced the result
+	TotalRows    int      // Amount of rows the query returned, before truncation
+	RowsScanned  int      // Rows read by the query scans, only measured with Config.ExplainQueries
+	Bytes        int      // Size of the formatted result
+	Truncated    bool     // Rows were cut to the request MaxRows
+	Partial      bool     // The query timed out, rows are the ones read before it was cancelled
+	SQLRewrites  []string // Lint rules that rewrote the generated query before running it
+	ArtifactPath string   // CSV artifact of the result, empty if it couldn't be written
+	ArtifactURL  string   // URL the CSV artifact was published at, empty if it's only local
+	ResultRef    string   // Reference to the formatted result, stored for the rest of the run
+	SQLDecision  string   // Confirmation decision on the query, empty when queries run unconfirmed
+
+	ScanEstimate    int64  // Rows the query plan estimates its scans read, only measured with Config.ScanGuard
+	ScanWarning     string // Why the query may read more rows than the question needs, see ScanGuard
+	ScanRegenerated bool   // The query was generated again with a hint to filter its scans
+
+	Dates []NormalizedDate // Dates of the prompt, normalized to ISO-8601 and stated on the prompt sent to the model
+}
+
+// Input of the typed analysis tool
+type AnalyzeRequest struct {
+	Question string // Question to answer about the data
+	Data     string // Data to analyze, usually a formatted lookup result
+}
+
+// Output of the typed analysis tool
+type AnalyzeResult struct {
+	Analysis         string
+	Citations        []Citation // Verified rows supporting the analysis, only with Config.GroundedAnalysis
+	DroppedCitations int        // Citations dropped for referencing rows not in the data
+	Fallback         bool       // Analysis is an automated summary, the completion failed
+}
+
+// Input of the typed visualization tool
+type VisualizeRequest struct {
+	Data  string // Data to chart, usually a formatted lookup result
+	Goal  string // What the chart should show
+	Theme string // Chart theme name, empty uses Config.ChartTheme
+}
+
+// Output of the typed visualization tool
+type VisualizeResult struct {
+	Config       ChartConfig  // Chart configuration the code was generated from
+	Code         string       // Python chart code
+	ArtifactPath string       // Python artifact of the code, empty if it couldn't be written
+	ArtifactURL  string       // URL the Python artifact was published at, empty if it's only local
+	SpecPath     string       // Chart spec artifact next to the code, see RenderFromSpec
+	SeriesPath   string       // CSV artifact of the plotted series next to the code, see ChartSeries
+	Dropped      []DroppedRow // Data rows left out of the chart because they didn't parse
+	Theme        ChartTheme   // Theme the code applies
+	ThemeWarning string       // Why the requested theme wasn't used, empty when it was
+}
+
+// Chat completion client. Satisfied by the OpenAI client's Chat.Completions service
+type ChatCompleter interface {
+	New(ctx context.Context, body openai.ChatCompletionNewParams, opts ...option.RequestOption) (*openai.ChatCompletion, error)
+}
+
+// Settings shared by every tool
+type Config struct {
+	Model        string     // Chat model used by the tools
+	ToolModels   ToolModels // Models of single tools, overriding Model
+	DataPath     string     // Local path or s3://, gs://, https:// URL of the parquet data, or a live database
+	OutputDir    string     // Base directory for per run artifacts
+	DatabasePath string     // DuckDB database file
+	ReportsDir   string     // Directory of the saved reports
+	ThemesDir    string     // Directory of the chart themes visualization calls can name
+	ChartTheme   ChartTheme // Theme of the charts unless a call names another. Zero uses DefaultChartTheme
+
+	DataRefreshed    bool      // Dataset table was reloaded before this run, reported on the first lookup
+	ExplainQueries   bool      // Profile lookup queries to measure the rows they scan. Doubles their cost
+	GroundedAnalysis bool      // Ask analyses to cite the data rows supporting them, then verify the citations
+	ScanGuard        ScanGuard // Estimated full scans over which lookup queries are regenerated or warned about
+
+	LiveTables []string     // Tables discovered on a live database, described to the model instead of the views
+	Glossary   *Glossary    // Business terms rewritten to their columns on lookup prompts. Nil rewrites nothing
+	Units      *ColumnUnits // Units of the columns, described to the model and applied to statistics. Nil has none
+	Language   string       // Language of the user, analyses and canned strings use it. Empty means English
+	Verbosity  string       // Answer verbosity level, analyses follow it. Empty is normal
+	DateOrder  DateOrder    // Day and month order of numeric dates on lookup prompts. Unset asks about ambiguous ones
+	Today      time.Time    // Day relative dates on lookup prompts resolve against. Zero is the current day
+
+	ToolTimeouts ToolTimeouts      // Deadlines callers set on tool contexts, used to report timeouts
+	Publisher    ArtifactPublisher // Where artifacts are published after being written. Nil keeps them local
+
+	Principal string        // User running the agent, recorded on access denials
+	Role      string        // Role of the run, checked against the roles of each dataset it queries. Empty has full access
+	Access    *AccessPolicy // Roles allowed per dataset over the dataset schemas. Nil uses the schemas only
+
+	Tags       map[string]string // Metadata tags of the run, already redacted, recorded on its audit entries
+	FineTune   *FineTuneCapture  // Capture of the SQL generations for fine tuning. Nil captures nothing
+	ConfirmSQL SQLConfirmer      // Asked before generated queries run. Nil runs them unconfirmed
+
+	PastAnalyses *AnalysisIndex // Index SearchPastAnalyses searches. Nil when the tool is off
+}
+
+// Tools and their dependencies for a single agent run.
+// Client, dataset store, tracer and audit log are shared, run state is not
+type Toolbox struct {
+	config    Config
+	completer ChatCompleter
+	store     Store
+	tracer    *tracing.Tracer
+	audit     *AuditLog
+
+	RunID           string              // Identifier of the run, set by StartRun
+	RunDir          string              // Directory of the run artifacts, set by StartRun
+	artifacts       []string            // Artifacts produced on the run, relative to RunDir
+	artifactURLs    map[string]string   // URLs of the artifacts published on the run, keyed by their name
+	touchedDatasets map[string][]string // Datasets read on the run, mapped to their result columns
+	lookups         int                 // Amount of lookups made on the run
+	retrieval       RetrievalStats      // Data read by the lookups of the run
+	results         map[string]string   // Formatted results stored on the run, keyed by their reference
+	resultQueries   map[string]string   // SQL of the lookup results stored on the run, keyed by their reference
+	latestResultRef string              // Reference of the last lookup result stored on the run
+	queries         []AuditEntry        // SQL statements executed on the run, in order
+	tempTables      []TempTable         // Temporary tables created by the lookups of the run
+	tempConn        *sql.Conn           // Connection holding the temporary tables of the run, if any
+	tempNamespace   string              // Prefix of the temporary tables of the run, see TempNamespace
+}
+
+/*
+---------------------------
+Prompts and other constants
+---------------------------
+*/
+
+const tableName = "sales"
+const DatasetName = tableName // Name the dataset is known by on the command line
+const DefaultModel = openai.ChatModelGPT4oMini
+const DefaultDataPath = "data/Store_Sales_Price_Elasticity_Promotions_Data.parquet"
+const DefaultToolsJsonPath = "data/tools.json"
+
+// Share of the model context window a lookup result handed to the model can fill. 200KB on 128k token models
+const lookupResultContextPercent = 40
+const LookUpFuncName = "LookUpSalesData"
+const AnalyzeFuncName = "AnalyzeSalesData"
+const VisualizeFuncName = "GenerateVisualization"
+
+/*
+------------------
+Global definitions
+------------------
+*/
+
+var visualConfigSchema = lazyStrictSchema[ChartConfig]()
+
+/*
+-------------
+Aux functions
+-------------
+*/
+
+// Create the tools for a run. The run directory is created by StartRun
+func NewToolbox(config Config, completer ChatCompleter, store Store, tracer *tracing.Tracer, audit *AuditLog) *Toolbox {
+	return &Toolbox{
+		config:          config,
+		completer:       completer,
+		store:           store,
+		tracer:          tracer,
+		audit:           audit,
+		artifacts:       []string{},
+		artifactURLs:    map[string]string{},
+		touchedDatasets: map[string][]string{},
+		results:         map[string]string{},
+		resultQueries:   map[string]string{},
+		queries:         []AuditEntry{},
+	}
+}
+
+// Language of the run user, empty means English
+func (t *Toolbox) Language() string {
+	return t.config.Language
+}
+
+// Check that data exists at the provided path.
+// Remote paths are checked with a lightweight query instead
+func ValidateDataPath(dataPath string) error {
+	if IsLiveDatabase(dataPath) {
+		return validateLiveDatabasePath(dataPath)
+	}
+
+	if IsRemotePath(dataPath) {
+		if err := probeRemoteDataPath(dataPath); err != nil {
+			return fmt.Errorf("no parquet data readable at %s: %w", dataPath, err)
+		}
+		return nil
+	}
+
+	if _, err := os.Stat(dataPath); err != nil {
+		return fmt.Errorf("no parquet data file found at %s", dataPath)
+	}
+
+	return nil
+}
+
+// Check that the tools json exists at the provided path
+func ValidateToolsPath(toolsJsonPath string) error {
+	if _, err := os.Stat(toolsJsonPath); err != nil {
+		return fmt.Errorf("no json file found at %s", toolsJsonPath)
+	}
+
+	return nil
+}
+
+// Necessary for structured outputs
+func generateSchema[T any]() any {
+	reflector := jsonschema.Reflector{
+		AllowAdditionalProperties: false,
+		DoNotReference:            true,
+	}
+
+	var v T
+	schema := reflector.Reflect(v)
+	return schema
+}
+
+// Clean up code block response from the LLM
+func cleanLlmBlockResponse(llmResponse string) string {
+	for _, t := range []string{"```json", "```sql", "```python"} {
+		llmResponse = strings.ReplaceAll(llmResponse, t, "")
+	}
+
+	return strings.Trim(llmResponse, "`\n ")
+}
+
+// Exact decimal text of a DECIMAL value of the database driver, set by the driver file of builds with DuckDB.
+// Monetary sums are DECIMAL, going through float64 would lose cents on large totals
+var decimalValue = func(value any) (string, bool) { return "", false }
+
+// Extract rows as arrays of values, typed as returned by the database driver. DECIMAL values are kept
+// as their exact decimal text
+func extractFromRows(rows *sql.Rows, columnsAmount int) ([][]any, error) {
+	log.Println("Processing rows")
+	resultData := [][]any{}
+	for rows.Next() {
+		// Create two arrays of interfaces with the size being the amount of columns
+		// One will be defined as pointers to the values of the other. That way providing
+		// it to rows.Scan, will alter the other one's values by reference
+		dynamicValues := make([]any, columnsAmount)
+		pointers := make([]any, columnsAmount)
+		for i := range dynamicValues {
+			pointers[i] = &dynamicValues[i]
+		}
+
+		// Scan row values into previously created interface pointers
+		err := rows.Scan(pointers...)
+		if err != nil {
+			return resultData, err
+		}
+
+		// dynamicValues' values were altered by reference, so it now contains the fields
+		for i, value := range dynamicValues {
+			if decimal, ok := decimalValue(value); ok {
+				dynamicValues[i] = decimal
+			}
+		}
+		resultData = append(resultData, dynamicValues)
+	}
+
+	return resultData, rows.Err()
+}
+
+// Format columns and rows as the model facing table: a comma separated header line,
+// then one comma separated line per row
+func formatRows(columns []string, rows [][]any) string {
+	resultData := []string{strings.Join(columns, ", ")}
+	for _, row := range rows {
+		rowValues := []string{}
+		for _, value := range row {
+			// TODO: Find better ways to do it. For now just lazy print interface to convert to string
+			rowValues = append(rowValues, fmt.Sprintf("%v", value))
+		}
+
+		resultData = append(resultData, strings.Join(rowValues, ", "))
+	}
+
+	return strings.Join(resultData, "\n")
+}
+
+// First part of data visualization tool. Extract a chart config to create code for visualization.
+// Failed calls and unsupported chart types fall back to a config inferred from the data columns
+func (t *Toolbox) extractChartConfig(toolCtx context.Context, data string, visualizationGoal string) visualizationConfigData {
+	// Send column statistics and a sample of rows instead of the whole data
+	formattedPrompt := prompts.RenderChartConfig(prompts.ChartConfigRequest{
+		Summary: SummarizeData(data, summarySampleRows, t.config.Units),
+		Goal:    visualizationGoal,
+	})
+
+	// Initialize span as subspan of the tool span
+	ctx, span := t.tracer.StartOpenInferenceSpan(toolCtx, "ExtractChart", tracing.ChainKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	tracing.SetSpanInput(span, formattedPrompt)
+
+	// Start OpenAI manual tracing
+	llmCtx, llmSpan := t.tracer.StartOpenAISpan(ctx, t.toolModel(VisualizeFuncName))
+	defer llmSpan.End()
+
+	inputMessage := openai.F([]openai.ChatCompletionMessageParamUnion{
+		openai.UserMessage(formattedPrompt),
+	})
+
+	responseFormat := openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
+		openai.ResponseFormatJSONSchemaParam{
+			Type: openai.F(openai.ResponseFormatJSONSchemaTypeJSONSchema),
+			JSONSchema: openai.F(openai.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:        openai.F("chartConfiguration"),
+				Description: openai.F("A simple configuration for a chart"),
+				Schema:      openai.F(visualConfigSchema()),
+				Strict:      openai.Bool(true),
+			}),
+		},
+	)
+
+	// Add input attributes to llm span
+	tracing.SetSpanAttrFromMap(llmSpan, map[string]any{
+		"llm.response_format": responseFormat.String(),
+	})
+	tracing.SetSpanInputMessages(llmSpan, inputMessage.Value)
+
+	// Use structure outputs to get the chart config as expected
+	// For this use ResponseFormat Param with the desired json schema
+	params := openai.ChatCompletionNewParams{
+		Model:          openai.F(t.toolModel(VisualizeFuncName)),
+		Messages:       inputMessage,
+		ResponseFormat: responseFormat,
+	}
+	response, err := t.completer.New(llmCtx, params)
+
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		tracing.SetSpanErrorCode(span)
+		log.Printf("WARNING: %s\n", err)
+		return fallbackChartConfig(span, data, visualizationGoal, fmt.Sprintf("chart config call failed: %s", err))
+	}
+
+	// Refusals fall back to the inferred config, the code generation step can still use it
+	responseMessage, err := completion.Message(response)
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		tracing.SetSpanErrorCode(span)
+		log.Printf("WARNING: %s\n", err)
+		return fallbackChartConfig(span, data, visualizationGoal, fmt.Sprintf("no usable chart config: %s", err))
+	}
+
+	jsonData := cleanLlmBlockResponse(responseMessage.Content)
+
+	// Set llm span output attributes
+	tracing.SetSpanAttrFromMap(llmSpan, map[string]any{
+		"llm.token_count.prompt":     response.Usage.PromptTokens,
+		"llm.token_count.completion": response.Usage.CompletionTokens,
+		"llm.token_count.total":      response.Usage.TotalTokens,
+		"llm.tools":                  []string{},
+	})
+	tracing.SetSpanOutputMessages(llmSpan, []openai.ChatCompletionMessage{responseMessage})
+
+	// Missing fields would unmarshal as an untitled chart of no type, so the JSON is checked first
+	jsonData, err = t.validateStructuredOutput(ctx, span, params, "chartConfiguration", visualConfigSchema(), jsonData)
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		log.Printf("WARNING: %s\n", err)
+		return fallbackChartConfig(span, data, visualizationGoal, err.Error())
+	}
+
+	// Convert response to json
+	vconf := ChartConfig{}
+	err = json.Unmarshal([]byte(jsonData), &vconf)
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		tracing.SetSpanErrorCode(span)
+		log.Printf("WARNING: %s\n", err)
+		return fallbackChartConfig(span, data, visualizationGoal, fmt.Sprintf("failed to parse chart config: %s", err))
+	}
+
+	tracing.SetSpanSuccessCode(llmSpan)
+	tracing.SetSpanOutput(span, jsonData)
+
+	// The call worked but the config can't be charted, keep the model title on the inferred one
+	if !isSupportedChartType(vconf.ChartType) {
+		fallback := fallbackChartConfig(span, data, visualizationGoal, fmt.Sprintf("unsupported chart type '%s'", vconf.ChartType))
+		if vconf.Title != "" {
+			fallback.Config.Title = vconf.Title
+		}
+		tracing.SetSpanSuccessCode(span)
+		return fallback
+	}
+
+	vconf.ChartType = strings.ToLower(strings.TrimSpace(vconf.ChartType))
+	tracing.SetSpanAttrFromMap(span, map[string]any{
+		"chart.config_source": chartConfigFromLLM,
+		"chart.type":          vconf.ChartType,
+	})
+	tracing.SetSpanSuccessCode(span)
+
+	return visualizationConfigData{Config: vconf, Data: data}
+}
+
+// Second part of the visualization tool. Generate code from chart
+func (t *Toolbox) createChart(toolCtx context.Context, config visualizationConfigData) (string, error) {
+	formattedPrompt := prompts.RenderChartCode(prompts.ChartCodeRequest{Config: config, Distribution: chartDistributionText(config)})
+	// Initialize span as subspan of the tool span
+	ctx, span := t.tracer.StartOpenInferenceSpan(toolCtx, "CreateChart", tracing.ChainKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	tracing.SetSpanInput(span, formattedPrompt)
+
+	// Start OpenAI manual tracing
+	llmCtx, llmSpan := t.tracer.StartOpenAISpan(ctx, t.toolModel(VisualizeFuncName))
+	defer llmSpan.End()
+
+	inputMessage := openai.F([]openai.ChatCompletionMessageParamUnion{
+		openai.UserMessage(formattedPrompt),
+	})
+
+	// Add input attributes to llm span
+	tracing.SetSpanInputMessages(llmSpan, inputMessage.Value)
+
+	response, err := t.completer.New(
+		llmCtx,
+		openai.ChatCompletionNewParams{
+			Model:    openai.F(t.toolModel(VisualizeFuncName)),
+			Messages: inputMessage,
+		},
+	)
+
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		tracing.SetSpanErrorCode(span)
+		log.Printf("WARNING: Failed OpenAI interaction: %s\n", err)
+		return "", &ErrLLMUnavailable{Err: err}
+	}
+
+	responseMessage, err := completion.Message(response)
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		tracing.SetSpanErrorCode(span)
+		log.Printf("WARNING: %s\n", err)
+		return "", err
+	}
+
+	// Add output attributes to llm span
+	tracing.SetSpanAttrFromMap(llmSpan, map[string]any{
+		"llm.token_count.prompt":     response.Usage.PromptTokens,
+		"llm.token_count.completion": response.Usage.CompletionTokens,
+		"llm.token_count.total":      response.Usage.TotalTokens,
+		"llm.tools":                  []string{},
+	})
+	tracing.SetSpanOutputMessages(llmSpan, []openai.ChatCompletionMessage{responseMessage})
+	tracing.SetSpanSuccessCode(llmSpan)
+
+	pythonCode := cleanLlmBlockResponse(responseMessage.Content)
+	tracing.SetSpanOutput(span, pythonCode)
+	tracing.SetSpanSuccessCode(span)
+
+	return pythonCode, nil
+}
+
+// Create a query from a user prompt
+// Returns the prompt sent along the answer
+func (t *Toolbox) generateSqlQuery(toolCtx context.Context, prompt string, columns []string, tableName string) (string, string, error) {
+	// Known business terms become their columns before the model has to guess them
+	prompt, glossaryRewrites := t.config.Glossary.Rewrite(prompt)
+
+	formattedPrompt := prompts.RenderSQLGeneration(prompts.SQLGenRequest{
+		Prompt:        prompt,
+		Columns:       columns,
+		Glossary:      t.config.Glossary.Description(),
+		Units:         t.config.Units.Description(),
+		Table:         tableName,
+		Views:         t.schemaDescription(),
+		TempTables:    t.tempTablesDescription(),
+		TempNamespace: t.TempNamespace(),
+		Dialect:       dialectDescription(),
+	})
+
+	// Initialize span as subspan of the tool span
+	ctx, span := t.tracer.StartOpenInferenceSpan(toolCtx, "SqlGeneration", tracing.ChainKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	tracing.SetSpanInput(span, formattedPrompt)
+	if len(glossaryRewrites) != 0 {
+		tracing.SetSpanAttr(span, "sql.glossary_rewrites", glossaryRewrites)
+	}
+
+	// Manually trace OpenAI calls
+	llmCtx, llmSpan := t.tracer.StartOpenAISpan(ctx, t.toolModel(LookUpFuncName))
+	defer llmSpan.End()
+
+	inputMessage := openai.F([]openai.ChatCompletionMessageParamUnion{
+		openai.UserMessage(formattedPrompt),
+	})
+
+	// Add input attributes to llm span
+	tracing.SetSpanInputMessages(llmSpan, inputMessage.Value)
+
+	response, err := t.completer.New(
+		llmCtx,
+		openai.ChatCompletionNewParams{
+			Messages: inputMessage,
+			Model:    openai.F(t.toolModel(LookUpFuncName)),
+		},
+	)
+
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		tracing.SetSpanErrorCode(span)
+		log.Printf("WARNING: Failed OpenAI interaction: %s\n", err)
+		return formattedPrompt, "", &ErrLLMUnavailable{Err: err}
+	}
+
+	answer, err := completion.Message(response)
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		tracing.SetSpanErrorCode(span)
+		log.Printf("WARNING: %s\n", err)
+		return formattedPrompt, "", err
+	}
+
+	// Add output attributes to llm span
+	tracing.SetSpanAttrFromMap(llmSpan, map[string]any{
+		"llm.token_count.prompt":     response.Usage.PromptTokens,
+		"llm.token_count.completion": response.Usage.CompletionTokens,
+		"llm.token_count.total":      response.Usage.TotalTokens,
+		"llm.tools":                  []string{},
+	})
+	tracing.SetSpanOutputMessages(llmSpan, []openai.ChatCompletionMessage{answer})
+	tracing.SetSpanSuccessCode(llmSpan)
+	tracing.SetSpanOutput(span, answer.Content)
+	tracing.SetSpanSuccessCode(span)
+
+	return formattedPrompt, answer.Content, nil
+}
+
+/*
+-----------
+Agent tools
+-----------
+*/
+
+// Look up sales data with an SQL query generated from the request prompt.
+// The result is exported as a CSV artifact of the run
+func (t *Toolbox) Lookup(parentCtx context.Context, request LookupRequest) (LookupResult, error) {
+	// Start span as sub span of the handleToolCalls span
+	ctx, span := t.tracer.StartOpenInferenceSpan(parentCtx, "LookUpTool", tracing.ToolKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	tracing.SetSpanInput(span, request.Prompt)
+
+	// Report a dataset reload on the first lookup of the run
+	t.lookups++
+	if t.lookups == 1 {
+		tracing.SetSpanAttr(span, "dataset.refreshed", t.config.DataRefreshed)
+	}
+
+	lookupResult, err := t.lookup(ctx, request)
+	if lookupResult.SQLDecision != "" {
+		tracing.SetSpanAttr(span, "tool.sql.decision", lookupResult.SQLDecision)
+	}
+	if t.config.ScanGuard.MaxRows > 0 {
+		tracing.SetSpanAttrFromMap(span, map[string]any{
+			"retrieval.scan_estimate":   lookupResult.ScanEstimate,
+			"tool.sql.scan_warning":     lookupResult.ScanWarning,
+			"tool.sql.scan_regenerated": lookupResult.ScanRegenerated,
+		})
+	}
+	if len(lookupResult.Dates) != 0 {
+		dates := []string{}
+		for _, date := range lookupResult.Dates {
+			dates = append(dates, date.String())
+		}
+		tracing.SetSpanAttr(span, "tool.dates", dates)
+	}
+	if err != nil && timedOut(ctx) {
+		tracing.SetSpanAttr(span, "tool.timeout", true)
+		err = &ErrToolTimeout{Tool: LookUpFuncName, Timeout: t.config.ToolTimeouts.For(LookUpFuncName)}
+	}
+	if err != nil {
+		log.Printf("WARNING: %s\n", err)
+		tracing.SetSpanAttr(span, "tool.error.class", ErrorClass(err))
+		tracing.SetSpanErrorCode(span)
+		return lookupResult, err
+	}
+
+	// Export the lookup result as a CSV artifact of the run
+	formatted := formatRows(lookupResult.Columns, lookupResult.Rows)
+	lookupResult.Bytes = len(formatted)
+	artifactPath, err := t.WriteArtifact(t.nextArtifactName("lookup", "csv"), []byte(formatted))
+	if err != nil {
+		log.Printf("WARNING: %s\n", err)
+	} else {
+		lookupResult.ArtifactPath = artifactPath
+		lookupResult.ArtifactURL = t.PublishArtifact(ctx, artifactPath)
+		tracing.SetSpanAttr(span, "tool.artifact", artifactPath)
+	}
+
+	// Later tool calls reference the result instead of copying it
+	lookupResult.ResultRef = t.storeResult(formatted)
+	t.resultQueries[lookupResult.ResultRef] = lookupResult.SQL
+	t.latestResultRef = lookupResult.ResultRef
+
+	t.recordRetrieval(lookupResult)
+	tracing.SetSpanAttrFromMap(span, map[string]any{
+		"tool.sql":               lookupResult.SQL,
+		"tool.truncated":         lookupResult.Truncated,
+		"tool.timeout":           lookupResult.Partial,
+		"tool.sql.rewrites":      len(lookupResult.SQLRewrites),
+		"tool.result_ref":        lookupResult.ResultRef,
+		"retrieval.row_count":    lookupResult.TotalRows,
+		"retrieval.bytes":        lookupResult.Bytes,
+		"retrieval.rows_scanned": lookupResult.RowsScanned,
+	})
+	tracing.SetSpanOutput(span, formatted)
+	tracing.SetSpanSuccessCode(span)
+
+	return lookupResult, nil
+}
+
+// Generate and run the lookup query
+func (t *Toolbox) lookup(ctx context.Context, request LookupRequest) (LookupResult, error) {
+	lookupResult := LookupResult{}
+	if strings.TrimSpace(request.Prompt) == "" {
+		return lookupResult, &ErrInvalidArguments{Tool: LookUpFuncName, Reason: "missing prompt"}
+	}
+
+	// Denied runs fail before touching the database
+	if err := t.authorizeDataset(ctx, LookUpFuncName, tableName); err != nil {
+		return lookupResult, err
+	}
+
+	// Backends without SQL fail before spending a completion on the query
+	if _, err := t.store.SQL(); err != nil {
+		return lookupResult, err
+	}
+
+	// Dates are stated in ISO-8601 so the model doesn't guess their day and month order.
+	// Ambiguous ones fail before spending a completion, the user has to clarify them
+	dates, err := NormalizeDates(request.Prompt, t.config.DateOrder, cmp.Or(t.config.Today, time.Now()))
+	if err != nil {
+		return lookupResult, err
+	}
+	lookupResult.Dates = dates
+
+	columns, err := t.store.Columns(ctx)
+	if err != nil {
+		return lookupResult, err
+	}
+
+	sqlPrompt, sqlQuery, err := t.generateSqlQuery(ctx, request.Prompt+DateClarification(lookupResult.Dates), columns, tableName)
+	if err != nil {
+		return lookupResult, fmt.Errorf("failed to generate SQL query: %w", err)
+	}
+
+	lookupResult.SQL = cleanLlmBlockResponse(sqlQuery)
+	if lookupResult.SQL == "" {
+		return lookupResult, &ErrSQLGeneration{Reason: "the model returned no query"}
+	}
+	// Fix trivial dialect issues instead of wasting a retry on them
+	lookupResult.SQL, lookupResult.SQLRewrites = LintSQL(lookupResult.SQL)
+	if len(lookupResult.SQLRewrites) > 0 {
+		log.Printf("Rewrote generated query: %s\n", strings.Join(lookupResult.SQLRewrites, ", "))
+	}
+	log.Printf("Query to be used: %s\n", lookupResult.SQL)
+
+	check := func(query string) (string, error) {
+		// Queries on live databases may reach other tables than the dataset one
+		for _, table := range referencedTables(query, t.config.LiveTables) {
+			if err := t.authorizeDataset(ctx, LookUpFuncName, table); err != nil {
+				return "", err
+			}
+		}
+
+		return ValidateSQL(query, t.TempNamespace())
+	}
+
+	createdTable, err := check(lookupResult.SQL)
+	if err != nil {
+		return lookupResult, err
+	}
+
+	// Queries scanning far more rows than the question needs are generated again or warned about before they run
+	if t.config.ScanGuard.MaxRows > 0 && createdTable == "" {
+		sqlPrompt = t.guardScans(ctx, &lookupResult, request.Prompt+DateClarification(lookupResult.Dates), sqlPrompt, columns, check)
+	}
+
+	// Confirmed queries are checked again when edited
+	if t.config.ConfirmSQL != nil {
+		lookupResult.SQL, lookupResult.SQLDecision, err = t.confirmSQL(ctx, lookupResult.SQL, check)
+		if err != nil {
+			return lookupResult, err
+		}
+		if lookupResult.SQLDecision == SQLRejected {
+			return lookupResult, &ErrSQLRejected{Query: lookupResult.SQL}
+		}
+		if createdTable, err = check(lookupResult.SQL); err != nil {
+			return lookupResult, err
+		}
+	}
+	if createdTable != "" {
+		readOnly, err := t.store.ReadOnly(ctx)
+		if err != nil {
+			return lookupResult, err
+		}
+		if readOnly {
+			return lookupResult, &ErrReadOnly{Operation: "creating temporary tables"}
+		}
+		tempResult, err := t.createTempTable(ctx, lookupResult, createdTable)
+		t.config.FineTune.CaptureSQLGeneration(t.RunID, t.toolModel(LookUpFuncName), sqlPrompt, lookupResult.SQL, err == nil)
+		return tempResult, err
+	}
+
+	// A query cancelled by the tool timeout still returns the rows read until then
+	columns, rows, err := t.runAuditedQuery(ctx, LookUpFuncName, AuditSourceGenerated, lookupResult.SQL)
+	t.config.FineTune.CaptureSQLGeneration(t.RunID, t.toolModel(LookUpFuncName), sqlPrompt, lookupResult.SQL, err == nil)
+	if err != nil && timedOut(ctx) {
+		log.Printf("WARNING: Lookup query timed out, keeping %d rows: %s\n", len(rows), err)
+		lookupResult.Partial = true
+	} else if err != nil {
+		return lookupResult, &ErrSQLExecution{Query: lookupResult.SQL, DBErr: err}
+	}
+
+	t.recordTouchedDataset(tableName, columns)
+	lookupResult.Columns = columns
+	lookupResult.Rows = rows
+	lookupResult.TotalRows = len(rows)
+	if request.MaxRows > 0 && len(rows) > request.MaxRows {
+		lookupResult.Rows = rows[:request.MaxRows]
+		lookupResult.Truncated = true
+	}
+
+	// Profiling is optional, a failure only leaves rows scanned unmeasured
+	if t.config.ExplainQueries && !lookupResult.Partial {
+		lookupResult.RowsScanned, err = t.explainRowsScanned(ctx, lookupResult.SQL)
+		if err != nil {
+			log.Printf("WARNING: Failed to profile lookup query: %s\n", err)
+		}
+	}
+
+	return lookupResult, nil
+}
+
+// Answer a question about the request data
+func (t *Toolbox) Analyze(parentCtx context.Context, request AnalyzeRequest) (AnalyzeResult, error) {
+	if strings.TrimSpace(request.Question) == "" || strings.TrimSpace(request.Data) == "" {
+		return AnalyzeResult{}, &ErrInvalidArguments{Tool: AnalyzeFuncName, Reason: "missing prompt or data"}
+	}
+
+	formatedPrompt := prompts.RenderAnalysis(prompts.AnalysisRequest{
+		Data:       request.Data,
+		Statistics: SummarizeData(request.Data, 0, t.config.Units),
+		Units:      t.config.Units.Description(),
+		Question:   request.Question,
+		Verbosity:  t.config.Verbosity,
+	})
+	params := openai.ChatCompletionNewParams{Model: openai.F(t.toolModel(AnalyzeFuncName))}
+
+	// Grounded analysis cites rows by an index column, answering with structured output
+	rowCount := 0
+	if t.config.GroundedAnalysis {
+		var indexedData string
+		indexedData, rowCount = indexRows(request.Data)
+		formatedPrompt = prompts.RenderAnalysis(prompts.AnalysisRequest{
+			Data:        indexedData,
+			Statistics:  SummarizeData(request.Data, 0, t.config.Units),
+			Units:       t.config.Units.Description(),
+			Question:    request.Question,
+			IndexColumn: rowIndexColumn,
+			Verbosity:   t.config.Verbosity,
+		})
+		params.ResponseFormat = openai.F(analysisResponseFormat())
+	}
+
+	if instruction := language.Instruction(t.config.Language); instruction != "" {
+		formatedPrompt += instruction + "\n"
+	}
+
+	// Start span as sub span of the handleToolCalls span
+	ctx, span := t.tracer.StartOpenInferenceSpan(parentCtx, "AnalyzeTool", tracing.ToolKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	tracing.SetSpanInput(span, formatedPrompt)
+	inputMessage := openai.F([]openai.ChatCompletionMessageParamUnion{
+		openai.UserMessage(formatedPrompt),
+	})
+	params.Messages = inputMessage
+
+	// Start OpenAI manual tracing
+	llmCtx, llmSpan := t.tracer.StartOpenAISpan(ctx, t.toolModel(AnalyzeFuncName))
+	defer llmSpan.End()
+
+	// Add input attributes to llm span
+	tracing.SetSpanInputMessages(llmSpan, inputMessage.Value)
+
+	response, err := t.completer.New(llmCtx, params)
+
+	analyzeResult := AnalyzeResult{}
+	finalAnalysis := ""
+	responseMessages := []openai.ChatCompletionMessage{}
+	promptTokens, completionTokens, totalTokens := int64(0), int64(0), int64(0)
+	if err != nil {
+		err = &ErrLLMUnavailable{Err: err}
+	} else {
+		promptTokens = response.Usage.PromptTokens
+		completionTokens = response.Usage.CompletionTokens
+		totalTokens = response.Usage.TotalTokens
+
+		var responseMessage openai.ChatCompletionMessage
+		responseMessage, err = completion.Message(response)
+		if err == nil {
+			finalAnalysis = strings.Trim(responseMessage.Content, "\n ")
+			responseMessages = []openai.ChatCompletionMessage{responseMessage}
+		}
+
+		if err == nil && t.config.GroundedAnalysis {
+			finalAnalysis, err = t.validateStructuredOutput(ctx, span, params, "analysisResult", analysisSchema(), finalAnalysis)
+		}
+		if err == nil && t.config.GroundedAnalysis {
+			analyzeResult, err = parseGroundedAnalysis(finalAnalysis, rowCount)
+			finalAnalysis = analyzeResult.Analysis
+		}
+	}
+
+	if err != nil {
+		log.Printf("WARNING: There was an issue with the OpenAI interaction: %s\n", err)
+	}
+
+	// Set output attributes to llm span
+	tracing.SetSpanAttrFromMap(llmSpan, map[string]any{
+		"llm.token_count.prompt":     promptTokens,
+		"llm.token_count.completion": completionTokens,
+		"llm.token_count.total":      totalTokens,
+		"llm.tools":                  []string{},
+	})
+	tracing.SetSpanOutputMessages(llmSpan, responseMessages)
+
+	if finalAnalysis == "" {
+		tracing.SetSpanErrorCode(llmSpan)
+		if err == nil {
+			err = fmt.Errorf("empty analysis response")
+		}
+		if timedOut(ctx) {
+			tracing.SetSpanAttr(span, "tool.timeout", true)
+			err = &ErrToolTimeout{Tool: AnalyzeFuncName, Timeout: t.config.ToolTimeouts.For(AnalyzeFuncName)}
+		}
+
+		// A failed completion still gets an answer grounded on the data. Refusals and filtered
+		// answers aren't failures of the API, they reach the router as errors
+		if summary, ok := analysisFallback(request.Data, t.config.Units, err); ok {
+			log.Printf("WARNING: Analysis failed, answering with an automated summary: %s\n", err)
+			tracing.SetSpanAttrFromMap(span, map[string]any{
+				"analysis.fallback":        true,
+				"analysis.fallback_reason": ErrorClass(err),
+			})
+			tracing.SetSpanOutput(span, summary)
+			tracing.SetSpanSuccessCode(span)
+			return AnalyzeResult{Analysis: summary, Fallback: true}, nil
+		}
+
+		tracing.SetSpanErrorCode(span)
+		tracing.SetSpanAttr(span, "tool.error.class", ErrorClass(err))
+		return AnalyzeResult{}, fmt.Errorf("%s: %w", language.Message(t.config.Language, language.NoAnalysis), err)
+	}
+
+	// Phoenix shows the grounding quality of the analysis from these
+	if t.config.GroundedAnalysis {
+		citations, _ := json.Marshal(analyzeResult.Citations)
+		tracing.SetSpanAttrFromMap(span, map[string]any{
+			"analysis.citations":         string(citations),
+			"analysis.citations_dropped": analyzeResult.DroppedCitations,
+		})
+	}
+
+	tracing.SetSpanOutput(span, finalAnalysis)
+	tracing.SetSpanSuccessCode(llmSpan)
+	tracing.SetSpanSuccessCode(span)
+	analyzeResult.Analysis = finalAnalysis
+	return analyzeResult, nil
+}
+
+// Generate python chart code for the request data and goal.
+// The code is saved as an artifact of the run
+func (t *Toolbox) Visualize(parentCtx context.Context, request VisualizeRequest) (VisualizeResult, error) {
+	if strings.TrimSpace(request.Data) == "" {
+		return VisualizeResult{}, &ErrInvalidArguments{Tool: VisualizeFuncName, Reason: "missing data"}
+	}
+
+	// Start span as sub span of the handleToolCalls span
+	ctx, span := t.tracer.StartOpenInferenceSpan(parentCtx, "VisualizationTool", tracing.ToolKind)
+	defer tracing.EndOpenInferenceSpan(span)
+
+	tracing.SetSpanInput(span, []string{request.Data, request.Goal})
+
+	theme, themeWarning := t.ChartTheme(request.Theme)
+	tracing.SetSpanAttr(span, "chart.theme", theme.Name)
+	if themeWarning != "" {
+		log.Printf("WARNING: %s\n", themeWarning)
+	}
+
+	config := t.extractChartConfig(ctx, request.Data, request.Goal)
+	code, err := t.createChart(ctx, config)
+	visualizeResult := VisualizeResult{Config: config.Config, Theme: theme, ThemeWarning: themeWarning}
+	// The theme is set up before the model code runs, so its figures follow it
+	if err == nil {
+		visualizeResult.Code = chartThemeCode(theme) + "\n" + code
+	}
+	if err != nil && timedOut(ctx) {
+		tracing.SetSpanAttr(span, "tool.timeout", true)
+		err = &ErrToolTimeout{Tool: VisualizeFuncName, Timeout: t.config.ToolTimeouts.For(VisualizeFuncName)}
+	}
+	if err != nil {
+		tracing.SetSpanAttr(span, "tool.error.class", ErrorClass(err))
+		tracing.SetSpanErrorCode(span)
+		return visualizeResult, fmt.Errorf("%s: %w", language.Message(t.config.Language, language.NoChart), err)
+	}
+
+	// The series is what the spec plots, data that can't be charted keeps the spec on the whole table
+	specConfig, specData := config.Config, config.Data
+	series, seriesErr := NewChartSeries(config.Config, config.Data)
+	if seriesErr != nil {
+		log.Printf("WARNING: No chart series: %s\n", seriesErr)
+	} else {
+		specConfig, specData = series.Config, series.data()
+		visualizeResult.Dropped = series.Dropped
+		tracing.SetSpanAttr(span, "chart.dropped_rows", len(series.Dropped))
+	}
+
+	// Save the chart code as an artifact of the run
+	artifactPath, err := t.WriteArtifact(t.nextArtifactName("chart", "py"), []byte(visualizeResult.Code))
+	if err != nil {
+		log.Printf("WARNING: %s\n", err)
+	} else {
+		visualizeResult.ArtifactPath = artifactPath
+		visualizeResult.ArtifactURL = t.PublishArtifact(ctx, artifactPath)
+		tracing.SetSpanAttr(span, "tool.artifact", artifactPath)
+
+		// The model wrote the code, the spec renders the same chart deterministically
+		visualizeResult.SpecPath, err = t.writeChartSpec(ctx, artifactPath, specConfig, specData, theme)
+		if err != nil {
+			log.Printf("WARNING: %s\n", err)
+		}
+
+		if seriesErr == nil {
+			seriesPath := strings.TrimSuffix(artifactPath, filepath.Ext(artifactPath)) + chartSeriesSuffix
+			visualizeResult.SeriesPath, err = t.WriteArtifact(seriesPath, []byte(series.CSV()))
+			if err != nil {
+				log.Printf("WARNING: %s\n", err)
+			}
+		}
+	}
+
+	tracing.SetSpanOutput(span, visualizeResult.Code)
+	tracing.SetSpanSuccessCode(span)
+
+	return visualizeResult, nil
+}
+
+/*
+-------------------------------
+LLM facing string tool adapters
+-------------------------------
+*/
+
+// Tool for sales lookup. Shows the first rows of the lookup result as a table for the model,
+// along with the reference the other tools resolve the full result from.
+// Results over lookupResultContextPercent of the model context window are refused so the router narrows the request
+func (t *Toolbox) LookUpSalesData(parentCtx context.Context, prompt string) (string, error) {
+	lookupResult, err := t.Lookup(parentCtx, LookupRequest{Prompt: prompt})
+	if err != nil {
+		return "", err
+	}
+
+	if maxBytes := completion.ContextBytes(t.config.Model, lookupResultContextPercent); lookupResult.Bytes > maxBytes {
+		return "", &ErrDataTooLarge{Rows: len(lookupResult.Rows), Bytes: lookupResult.Bytes, MaxBytes: maxBytes}
+	}
+
+	formatted := formatResultPreview(lookupResult.ResultRef, lookupResult.Columns, lookupResult.Rows)
+	if lookupResult.ArtifactURL != "" {
+		formatted = fmt.Sprintf("# Published run artifact: %s\n%s", lookupResult.ArtifactURL, formatted)
+	}
+	if lookupResult.ScanWarning != "" {
+		formatted = fmt.Sprintf("# Warning: %s\n%s", lookupResult.ScanWarning, formatted)
+	}
+
+	if lookupResult.Partial {
+		timeout := t.config.ToolTimeouts.For(LookUpFuncName)
+		return fmt.Sprintf("# partial results, query cancelled after %s\n%s", timeout, formatted), nil
+	}
+
+	return formatted, nil
+}
+
+// Tool for data analysis. The data is given inline or as the dataRef of a stored lookup result
+func (t *Toolbox) AnalyzeSalesData(parentCtx context.Context, prompt string, data string, dataRef string) (string, error) {
+	data, err := t.ResolveData(AnalyzeFuncName, data, dataRef)
+	if err != nil {
+		return "", err
+	}
+
+	analyzeResult, err := t.Analyze(parentCtx, AnalyzeRequest{Question: prompt, Data: data})
+	if err != nil {
+		return "", err
+	}
+
+	if len(analyzeResult.Citations) == 0 && analyzeResult.DroppedCitations == 0 {
+		return analyzeResult.Analysis, nil
+	}
+
+	return fmt.Sprintf("%s\n\n%s", analyzeResult.Analysis, formatCitations(analyzeResult.Citations, analyzeResult.DroppedCitations)), nil
+}
+
+// Automated summary of the data standing in for an analysis whose completion failed or timed out.
+// False for other failures, like refusals, and for data that isn't a table
+func analysisFallback(data string, units *ColumnUnits, err error) (string, bool) {
+	var unavailable *ErrLLMUnavailable
+	var timeout *ErrToolTimeout
+	var invalid *ErrSchemaValidation
+	if !errors.As(err, &unavailable) && !errors.As(err, &timeout) && !errors.As(err, &invalid) {
+		return "", false
+	}
+
+	return FallbackSummary(data, units)
+}
+
+// Tool for data visualization. The data is given inline or as the dataRef of a stored lookup result.
+// Without either, the latest lookup result of the run is charted, like for "now chart that" follow ups.
+// Points to the saved chart code on the result. A report name saves the chart as a named report too.
+// A theme that can't be used is reported on the result, the chart is made with the configured one
+func (t *Toolbox) GenerateVisualization(
+	parentCtx context.Context,
+	data string,
+	dataRef string,
+	visualizationGoal string,
+	reportName string,
+	theme string,
+) (string, error) {
+	if strings.TrimSpace(data) == "" && strings.TrimSpace(dataRef) == "" {
+		latestRef, ok := t.LatestResultRef()
+		if !ok {
+			return "", &ErrInvalidArguments{
+				Tool:   VisualizeFuncName,
+				Reason: fmt.Sprintf("no data to chart: pass data or dataRef, or call %s first", LookUpFuncName),
+			}
+		}
+
+		log.Printf("No data given to '%s', charting the latest lookup result '%s'\n", VisualizeFuncName, latestRef)
+		dataRef = latestRef
+	}
+
+	data, err := t.ResolveData(VisualizeFuncName, data, dataRef)
+	if err != nil {
+		return "", err
+	}
+
+	request := VisualizeRequest{Data: data, Goal: visualizationGoal, Theme: theme}
+	visualizeResult, err := t.Visualize(parentCtx, request)
+	if err != nil {
+		return "", err
+	}
+
+	notes := []string{}
+	if visualizeResult.ThemeWarning != "" {
+		notes = append(notes, fmt.Sprintf("# Warning: %s", visualizeResult.ThemeWarning))
+	}
+	if dropped := len(visualizeResult.Dropped); dropped != 0 {
+		note := fmt.Sprintf("# Warning: %d rows dropped from the chart, the first one for %s", dropped, visualizeResult.Dropped[0].Reason)
+		if visualizeResult.SeriesPath != "" {
+			note += fmt.Sprintf(". The charted rows are in the run artifact %s", visualizeResult.SeriesPath)
+		}
+		notes = append(notes, note)
+	}
+	if visualizeResult.ArtifactURL != "" {
+		notes = append(notes, fmt.Sprintf("# Published run artifact: %s", visualizeResult.ArtifactURL))
+	} else if visualizeResult.ArtifactPath != "" {
+		notes = append(notes, fmt.Sprintf("# Saved to run artifact: %s", visualizeResult.ArtifactPath))
+	}
+
+	// The chart is done, a report that can't be saved is only reported
+	if reportName = strings.TrimSpace(reportName); reportName != "" {
+		// The report renders again with the theme the chart was made with
+		request.Theme = visualizeResult.Theme.Name
+		if _, err := t.SaveReport(parentCtx, reportName, request, dataRef, visualizeResult.Config); err != nil {
+			log.Printf("WARNING: Failed to save report: %s\n", err)
+			notes = append(notes, fmt.Sprintf("# Report not saved: %s", err))
+		} else {
+			notes = append(notes, fmt.Sprintf("# Saved as report '%s'", reportName))
+		}
+	}
+
+	if len(notes) == 0 {
+		return visualizeResult.Code, nil
+	}
+
+	return fmt.Sprintf("%s\n\n%s", visualizeResult.Code, strings.Join(notes, "\n")), nil
+}
@@ -0,0 +1,237 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/mock"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+)
+
+// Data passed to the analysis and visualization tools, as formatted by lookups
+const typedToolData = "Store_Number, Total_Sale_Value\n1, 30.5\n2, 12.25\n3, 24"
+
+// Toolbox on the sales fixture replying with the given completions
+func newTypedToolbox(t *testing.T, responses ...*openai.ChatCompletion) (*Toolbox, *mock.Completer) {
+	t.Helper()
+	store, err := openFixtureStore(t, StorageDuckDB, "sales.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	completer := mock.NewCompleter(responses...)
+	toolbox := NewToolbox(Config{OutputDir: t.TempDir()}, completer, store, tracing.NewNoopTracer(), nil)
+	if _, err := toolbox.StartRun("typedtools"); err != nil {
+		t.Fatal(err)
+	}
+	return toolbox, completer
+}
+
+// The typed lookup returns columns, typed rows, the query it ran and how the rows were truncated
+func TestLookup(t *testing.T) {
+	cases := []struct {
+		name        string
+		request     LookupRequest
+		query       string // Query generated by the model, empty when the lookup fails before generating one
+		wantSQL     string
+		wantColumns []string
+		wantRows    int
+		wantTotal   int
+		truncated   bool
+		errClass    string // Class of the error the lookup fails with, empty when it succeeds
+	}{
+		{
+			name:        "every row",
+			request:     LookupRequest{Prompt: "Stores with sales"},
+			query:       "SELECT DISTINCT Store_Number FROM sales ORDER BY Store_Number",
+			wantSQL:     "SELECT DISTINCT Store_Number FROM sales ORDER BY Store_Number",
+			wantColumns: []string{"Store_Number"},
+			wantRows:    3,
+			wantTotal:   3,
+		},
+		{
+			name:        "truncated",
+			request:     LookupRequest{Prompt: "Stores with sales", MaxRows: 2},
+			query:       "```sql\nSELECT DISTINCT Store_Number FROM sales ORDER BY Store_Number\n```",
+			wantSQL:     "SELECT DISTINCT Store_Number FROM sales ORDER BY Store_Number",
+			wantColumns: []string{"Store_Number"},
+			wantRows:    2,
+			wantTotal:   3,
+			truncated:   true,
+		},
+		{name: "missing prompt", request: LookupRequest{Prompt: " "}, errClass: ErrorClassInvalidArguments},
+		{
+			name:     "failing query",
+			request:  LookupRequest{Prompt: "Sales per region"},
+			query:    "SELECT Region, sum(Total_Sale_Value) FROM sales GROUP BY Region",
+			wantSQL:  "SELECT Region, sum(Total_Sale_Value) FROM sales GROUP BY Region",
+			errClass: ErrorClassSQLExecution,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			responses := []*openai.ChatCompletion{}
+			if c.query != "" {
+				responses = append(responses, mock.TextResponse(c.query))
+			}
+			toolbox, completer := newTypedToolbox(t, responses...)
+
+			result, err := toolbox.Lookup(context.Background(), c.request)
+			if len(completer.Requests) != len(responses) {
+				t.Fatalf("lookup made %d completions, expected %d", len(completer.Requests), len(responses))
+			}
+			if result.SQL != c.wantSQL {
+				t.Errorf("lookup ran '%s', expected '%s'", result.SQL, c.wantSQL)
+			}
+			if c.errClass != "" {
+				if err == nil || ErrorClass(err) != c.errClass {
+					t.Fatalf("expected a %s error, got %v", c.errClass, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(result.Columns, c.wantColumns) {
+				t.Errorf("lookup returned columns %v, expected %v", result.Columns, c.wantColumns)
+			}
+			if len(result.Rows) != c.wantRows || result.TotalRows != c.wantTotal || result.Truncated != c.truncated {
+				t.Errorf("lookup returned %d of %d rows (truncated %t), expected %d of %d (truncated %t)",
+					len(result.Rows), result.TotalRows, result.Truncated, c.wantRows, c.wantTotal, c.truncated)
+			}
+			for _, row := range result.Rows {
+				if _, ok := row[0].(int16); !ok {
+					t.Errorf("store number %v is a %T, expected the SMALLINT of the column", row[0], row[0])
+				}
+			}
+
+			// The CSV artifact and the stored result hold every row, truncation only applies to the typed rows
+			artifact, err := os.ReadFile(filepath.Join(toolbox.RunDir, result.ArtifactPath))
+			if err != nil {
+				t.Fatalf("lookup wrote no artifact: %s", err)
+			}
+			if lines := strings.Count(strings.TrimSpace(string(artifact)), "\n") + 1; lines != c.wantRows+1 {
+				t.Errorf("artifact has %d lines, expected the header and %d rows", lines, c.wantRows)
+			}
+			if result.ResultRef == "" || result.Bytes != len(artifact) {
+				t.Errorf("lookup stored result '%s' of %d bytes, expected a reference to the %d artifact bytes", result.ResultRef, result.Bytes, len(artifact))
+			}
+		})
+	}
+}
+
+// The typed analysis answers from the completion, and falls back to a summary of the data when it fails
+func TestAnalyze(t *testing.T) {
+	cases := []struct {
+		name     string
+		request  AnalyzeRequest
+		response *openai.ChatCompletion // Nil fails the completion
+		want     string                 // Start of the analysis
+		fallback bool
+		wantErr  bool
+	}{
+		{
+			name:     "analysis",
+			request:  AnalyzeRequest{Question: "Which store sold the most?", Data: typedToolData},
+			response: mock.TextResponse("\nStore 1 sold the most, 30.5.\n"),
+			want:     "Store 1 sold the most, 30.5.",
+		},
+		{
+			name:     "failed completion",
+			request:  AnalyzeRequest{Question: "Which store sold the most?", Data: typedToolData},
+			want:     fallbackSummaryLabel,
+			fallback: true,
+		},
+		{name: "missing data", request: AnalyzeRequest{Question: "Which store sold the most?"}, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			toolbox, _ := newTypedToolbox(t, c.response)
+
+			result, err := toolbox.Analyze(context.Background(), c.request)
+			if c.wantErr {
+				var invalidErr *ErrInvalidArguments
+				if !errors.As(err, &invalidErr) {
+					t.Fatalf("expected invalid arguments, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(result.Analysis, c.want) || result.Fallback != c.fallback {
+				t.Errorf("analysis '%s' (fallback %t), expected it to start with '%s' (fallback %t)", result.Analysis, result.Fallback, c.want, c.fallback)
+			}
+		})
+	}
+}
+
+// The typed visualization returns the chart config, the themed code and the artifacts written for them
+func TestVisualize(t *testing.T) {
+	cases := []struct {
+		name       string
+		request    VisualizeRequest
+		config     string // Chart config replied by the model, empty when it isn't asked for one
+		wantConfig ChartConfig
+		wantErr    bool
+	}{
+		{
+			name:       "model config",
+			request:    VisualizeRequest{Data: typedToolData, Goal: "Sales per store"},
+			config:     `{"chartType": "Bar", "xAxis": "Store_Number", "yAxis": "Total_Sale_Value", "title": "Sales per store", "binCount": 0, "bucketBy": ""}`,
+			wantConfig: ChartConfig{ChartType: "bar", XAxis: "Store_Number", YAxis: "Total_Sale_Value", Title: "Sales per store"},
+		},
+		{
+			name:       "unsupported chart type keeps the title",
+			request:    VisualizeRequest{Data: typedToolData, Goal: "Sales per store"},
+			config:     `{"chartType": "radar", "xAxis": "Store_Number", "yAxis": "Total_Sale_Value", "title": "Store sales", "binCount": 0, "bucketBy": ""}`,
+			wantConfig: ChartConfig{ChartType: scatterChart, XAxis: "Store_Number", YAxis: "Total_Sale_Value", Title: "Store sales"},
+		},
+		{name: "missing data", request: VisualizeRequest{Goal: "Sales per store"}, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			responses := []*openai.ChatCompletion{}
+			if c.config != "" {
+				responses = append(responses, mock.TextResponse(c.config), mock.TextResponse("```python\nplt.savefig('chart.png')\n```"))
+			}
+			toolbox, completer := newTypedToolbox(t, responses...)
+
+			result, err := toolbox.Visualize(context.Background(), c.request)
+			if len(completer.Requests) != len(responses) {
+				t.Fatalf("visualization made %d completions, expected %d", len(completer.Requests), len(responses))
+			}
+			if c.wantErr {
+				var invalidErr *ErrInvalidArguments
+				if !errors.As(err, &invalidErr) {
+					t.Fatalf("expected invalid arguments, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.Config != c.wantConfig {
+				t.Errorf("chart config %+v, expected %+v", result.Config, c.wantConfig)
+			}
+			if !strings.HasPrefix(result.Code, chartThemeCode(result.Theme)) || !strings.HasSuffix(result.Code, "plt.savefig('chart.png')") {
+				t.Errorf("chart code doesn't apply the theme before the model code:\n%s", result.Code)
+			}
+			for _, path := range []string{result.ArtifactPath, result.SpecPath, result.SeriesPath} {
+				if _, err := os.Stat(filepath.Join(toolbox.RunDir, path)); err != nil {
+					t.Errorf("missing chart artifact: %s", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+------------
+Column units
+------------
+*/
+
+// Units a column of the dataset can hold its values in
+const (
+	UnitCents   = "cents"
+	UnitDollars = "dollars"
+	UnitPercent = "percent"
+	UnitCount   = "count"
+)
+
+var knownUnits = []string{UnitCents, UnitDollars, UnitPercent, UnitCount}
+
+// Unit of the values of a column, and the scale turning them into the values shown to users.
+// A cents column with scale 0.01 is shown in dollars
+type ColumnUnit struct {
+	Column string  // Column of the dataset table, or of its views
+	Unit   string  // Unit the values are stored in, one of the Unit* constants
+	Scale  float64 // Multiplier from stored to shown values. 0 leaves them as stored
+}
+
+// Column units of a run, keyed by column. A nil ColumnUnits has no units
+type ColumnUnits struct {
+	units []ColumnUnit
+}
+
+// Units of the dataset table, used unless the data is a live database with its own tables
+func DatasetUnits() []ColumnUnit {
+	return datasetSchemas[tableName].Units
+}
+
+// Parse comma separated column units, like 'Price_Cents=cents*0.01,Discount=percent'
+func ParseColumnUnits(units string) ([]ColumnUnit, error) {
+	parsed := []ColumnUnit{}
+	for entry := range strings.SplitSeq(units, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		column, unit, found := strings.Cut(entry, "=")
+		column, unit = strings.TrimSpace(column), strings.TrimSpace(unit)
+		if !found || column == "" || unit == "" {
+			return nil, fmt.Errorf("invalid column unit '%s', expected column=unit or column=unit*scale", entry)
+		}
+
+		columnUnit := ColumnUnit{Column: column, Unit: strings.ToLower(unit)}
+		if unit, scale, scaled := strings.Cut(unit, "*"); scaled {
+			value, err := strconv.ParseFloat(strings.TrimSpace(scale), 64)
+			if err != nil || value <= 0 {
+				return nil, fmt.Errorf("invalid scale '%s' of column unit '%s', expected a positive number", scale, entry)
+			}
+			columnUnit.Unit, columnUnit.Scale = strings.ToLower(strings.TrimSpace(unit)), value
+		}
+		parsed = append(parsed, columnUnit)
+	}
+
+	return parsed, nil
+}
+
+// Check the units against the dataset table, logging a warning for each unknown unit, unknown column
+// or unit the column name or schema contradicts. Unknown units are left out, later units of a column win
+func NewColumnUnits(ctx context.Context, store Store, units []ColumnUnit) (*ColumnUnits, error) {
+	tableColumns, err := store.Columns(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch columns for the column units: %w", err)
+	}
+
+	columnUnits := &ColumnUnits{units: []ColumnUnit{}}
+	for _, unit := range units {
+		if !slices.Contains(knownUnits, unit.Unit) {
+			log.Printf("WARNING: Column '%s' has unknown unit '%s', expected one of %s. Ignoring it\n", unit.Column, unit.Unit, strings.Join(knownUnits, ", "))
+			continue
+		}
+
+		if !slices.ContainsFunc(tableColumns, func(column string) bool { return strings.EqualFold(column, unit.Column) }) {
+			log.Printf("WARNING: Column '%s' with unit '%s' isn't a column of dataset '%s', only results naming it are scaled\n", unit.Column, unit.Unit, tableName)
+		}
+		if mismatch := unitMismatch(unit); mismatch != "" {
+			log.Printf("WARNING: Column '%s' has unit '%s', but %s\n", unit.Column, unit.Unit, mismatch)
+		}
+
+		columnUnits.units = slices.DeleteFunc(columnUnits.units, func(known ColumnUnit) bool {
+			return strings.EqualFold(known.Column, unit.Column)
+		})
+		columnUnits.units = append(columnUnits.units, unit)
+	}
+
+	return columnUnits, nil
+}
+
+// Why the name of a column or the dataset schema contradicts its unit, empty if nothing does
+func unitMismatch(unit ColumnUnit) string {
+	name := strings.ToLower(unit.Column)
+	switch {
+	case strings.Contains(name, "cents") && unit.Unit != UnitCents:
+		return "its name says cents"
+	case (strings.Contains(name, "dollars") || strings.Contains(name, "usd")) && unit.Unit != UnitDollars:
+		return "its name says dollars"
+	case (strings.Contains(name, "percent") || strings.Contains(name, "pct")) && unit.Unit != UnitPercent:
+		return "its name says percent"
+	case slices.Contains(MonetaryColumns(), unit.Column) && unit.Unit != UnitCents && unit.Unit != UnitDollars:
+		return "the dataset schema flags it as monetary"
+	case unit.Unit == UnitCents && unit.Scale == 0:
+		return "it has no scale, so amounts are shown in cents"
+	}
+
+	return ""
+}
+
+// Unit of a column, false when it has none. Columns are matched case insensitively
+func (u *ColumnUnits) For(column string) (ColumnUnit, bool) {
+	if u == nil {
+		return ColumnUnit{}, false
+	}
+
+	index := slices.IndexFunc(u.units, func(unit ColumnUnit) bool { return strings.EqualFold(unit.Column, column) })
+	if index < 0 {
+		return ColumnUnit{}, false
+	}
+
+	return u.units[index], true
+}
+
+// Model facing description of the column units, "none" when there are none
+func (u *ColumnUnits) Description() string {
+	if u == nil || len(u.units) == 0 {
+		return "none"
+	}
+
+	lines := []string{}
+	for _, unit := range u.units {
+		line := fmt.Sprintf("- %s: %s", unit.Column, unit.Unit)
+		if unit.scaled() {
+			line += fmt.Sprintf(", shown in %s (multiply by %s)", unit.DisplayUnit(), strconv.FormatFloat(unit.Scale, 'g', -1, 64))
+		}
+		lines = append(lines, line)
+	}
+
+	return "\n" + strings.Join(lines, "\n")
+}
+
+// Whether shown values differ from stored ones
+func (u ColumnUnit) scaled() bool {
+	return u.Scale != 0 && u.Scale != 1
+}
+
+// Value as shown to users
+func (u ColumnUnit) Display(value float64) float64 {
+	if !u.scaled() {
+		return value
+	}
+
+	return value * u.Scale
+}
+
+// Unit of the shown values: dollars for cents scaled by 0.01, else the stored unit
+func (u ColumnUnit) DisplayUnit() string {
+	if u.Unit == UnitCents && u.Scale == 0.01 {
+		return UnitDollars
+	}
+
+	return u.Unit
+}
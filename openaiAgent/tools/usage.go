@@ -0,0 +1,80 @@
+package tools
+
+import (
+	"context"
+	"math"
+	"sync"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+/*
+----------------------
+Token usage accounting
+----------------------
+*/
+
+// Token usage summed over several completions. Counts are int64 end to end, like the API reports them
+type Usage struct {
+	PromptTokens     int64 `json:"promptTokens"`
+	CompletionTokens int64 `json:"completionTokens"`
+	TotalTokens      int64 `json:"totalTokens"`
+	Completions      int   `json:"completions"`
+}
+
+// Add the token counts of a completion, saturating at math.MaxInt64 instead of wrapping around.
+// Negative counts from a misbehaving endpoint are ignored
+func (u *Usage) Add(promptTokens int64, completionTokens int64, totalTokens int64) {
+	u.PromptTokens = AddTokens(u.PromptTokens, promptTokens)
+	u.CompletionTokens = AddTokens(u.CompletionTokens, completionTokens)
+	u.TotalTokens = AddTokens(u.TotalTokens, totalTokens)
+	u.Completions++
+}
+
+// Sum of two non negative token counts, math.MaxInt64 when it would overflow. Negative counts add nothing
+func AddTokens(sum int64, tokens int64) int64 {
+	if tokens <= 0 {
+		return sum
+	}
+	if sum > math.MaxInt64-tokens {
+		return math.MaxInt64
+	}
+	return sum + tokens
+}
+
+// Chat completer summing the token usage of every completion it forwards
+type UsageCounter struct {
+	completer ChatCompleter
+	usage     Usage
+	mutex     sync.Mutex
+}
+
+// Wrap a completer to count the token usage of its completions. Meant to be created per run
+func NewUsageCounter(completer ChatCompleter) *UsageCounter {
+	return &UsageCounter{completer: completer}
+}
+
+func (u *UsageCounter) New(
+	ctx context.Context,
+	body openai.ChatCompletionNewParams,
+	opts ...option.RequestOption,
+) (*openai.ChatCompletion, error) {
+	response, err := u.completer.New(ctx, body, opts...)
+	if err != nil {
+		return response, err
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.usage.Add(response.Usage.PromptTokens, response.Usage.CompletionTokens, response.Usage.TotalTokens)
+
+	return response, nil
+}
+
+// Token usage counted so far
+func (u *UsageCounter) Usage() Usage {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.usage
+}
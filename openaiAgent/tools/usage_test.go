@@ -0,0 +1,35 @@
+package tools
+
+import (
+	"math"
+	"testing"
+)
+
+// Sums past the int32 range, past the integers float64 holds exactly, and at the int64 limit
+func TestUsageAdd(t *testing.T) {
+	cases := []struct {
+		name   string
+		counts []int64
+		want   int64
+	}{
+		{name: "int32 boundary", counts: []int64{math.MaxInt32, 1}, want: math.MaxInt32 + 1},
+		{name: "float64 exact integers", counts: []int64{1 << 53, 1}, want: 1<<53 + 1},
+		{name: "int64 saturation", counts: []int64{math.MaxInt64 - 1, 5}, want: math.MaxInt64},
+		{name: "negative counts", counts: []int64{10, -3}, want: 10},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			usage := Usage{}
+			for _, count := range c.counts {
+				usage.Add(count, count, count)
+			}
+			if usage.PromptTokens != c.want || usage.CompletionTokens != c.want || usage.TotalTokens != c.want {
+				t.Fatalf("expected every count to sum %d, got %+v", c.want, usage)
+			}
+			if usage.Completions != len(c.counts) {
+				t.Fatalf("expected %d completions, got %d", len(c.counts), usage.Completions)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+/*
+------------------
+Database lifecycle
+------------------
+*/
+
+// Tables named like the temporary tables of a run, see TempNamespace. Lookups only create TEMP tables,
+// which DuckDB drops with their connection, so persisted ones were left behind and nothing reads them
+var runTableRegex = regexp.MustCompile(`^tmp_r\d+_`)
+
+// Tables of the agent database a vacuum drops
+type VacuumTargets struct {
+	OrphanTables  []string `json:"orphanTables"`  // Persisted tables named like the temporary tables of a run
+	StaleDatasets []string `json:"staleDatasets"` // Fingerprinted tables of datasets that are no longer configured, with their fingerprint
+}
+
+// Outcome of a vacuum, with the database file size around it
+type VacuumReport struct {
+	VacuumTargets
+	SizeBefore int64 `json:"sizeBefore"`
+	SizeAfter  int64 `json:"sizeAfter"`
+}
+
+// Whether there's any table to drop
+func (t VacuumTargets) Empty() bool {
+	return len(t.OrphanTables) == 0 && len(t.StaleDatasets) == 0
+}
+
+// Bytes of a DuckDB database file and its write ahead log. A database not created yet has 0
+func DatabaseSize(databasePath string) (int64, error) {
+	size := int64(0)
+	for _, path := range []string{databasePath, databasePath + ".wal"} {
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to stat database file: %w", err)
+		}
+		size += info.Size()
+	}
+
+	return size, nil
+}
+
+// Find the tables a vacuum drops: persisted run tables, and tables fingerprinted for a dataset that isn't
+// configured anymore. Fingerprints of configured datasets, the metadata tables and tables the agent didn't
+// create are left alone
+func FindVacuumTargets(ctx context.Context, db *sql.DB) (VacuumTargets, error) {
+	targets := VacuumTargets{OrphanTables: []string{}, StaleDatasets: []string{}}
+
+	tables, err := queryNames(ctx, db,
+		"SELECT table_name FROM duckdb_tables() WHERE database_name = current_database() AND schema_name = 'main' AND NOT temporary ORDER BY table_name")
+	if err != nil {
+		return targets, err
+	}
+
+	for _, table := range tables {
+		if runTableRegex.MatchString(strings.ToLower(table)) {
+			targets.OrphanTables = append(targets.OrphanTables, table)
+		}
+	}
+
+	if !slices.Contains(tables, fingerprintTableName) {
+		return targets, nil
+	}
+
+	fingerprinted, err := queryNames(ctx, db, fmt.Sprintf("SELECT table_name FROM %s ORDER BY table_name", fingerprintTableName))
+	if err != nil {
+		return targets, err
+	}
+	for _, table := range fingerprinted {
+		if _, configured := datasetSchemas[table]; !configured {
+			targets.StaleDatasets = append(targets.StaleDatasets, table)
+		}
+	}
+
+	return targets, nil
+}
+
+// Drop the vacuum targets of the database and checkpoint it, so the write ahead log is folded in and the
+// freed blocks are reused. Returns the targets with the size of databasePath before and after
+func VacuumDatabase(ctx context.Context, db *sql.DB, databasePath string) (VacuumReport, error) {
+	report := VacuumReport{}
+	var err error
+	if report.SizeBefore, err = DatabaseSize(databasePath); err != nil {
+		return report, err
+	}
+
+	report.VacuumTargets, err = FindVacuumTargets(ctx, db)
+	if err != nil {
+		return report, err
+	}
+
+	dropped := append(slices.Clone(report.OrphanTables), report.StaleDatasets...)
+	for _, table := range dropped {
+		statement := fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, strings.ReplaceAll(table, `"`, `""`))
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return report, &ErrSQLExecution{Query: statement, DBErr: err}
+		}
+	}
+
+	// Stale datasets lose their fingerprint, and their cached profile if one was computed
+	metadataTables := []string{fingerprintTableName}
+	if tableExists(ctx, db, profileTableName) {
+		metadataTables = append(metadataTables, profileTableName)
+	}
+	for _, table := range report.StaleDatasets {
+		for _, metadataTable := range metadataTables {
+			statement := fmt.Sprintf("DELETE FROM %s WHERE table_name = ?", metadataTable)
+			if _, err := db.ExecContext(ctx, statement, table); err != nil {
+				return report, &ErrSQLExecution{Query: statement, DBErr: err}
+			}
+		}
+	}
+
+	if _, err := db.ExecContext(ctx, "CHECKPOINT"); err != nil {
+		return report, &ErrSQLExecution{Query: "CHECKPOINT", DBErr: err}
+	}
+
+	if report.SizeAfter, err = DatabaseSize(databasePath); err != nil {
+		return report, err
+	}
+
+	log.Printf("Vacuumed database %s: dropped %d orphan tables and %d stale datasets, %d bytes to %d\n",
+		databasePath, len(report.OrphanTables), len(report.StaleDatasets), report.SizeBefore, report.SizeAfter)
+	return report, nil
+}
+
+// First column of every row of a query
+func queryNames(ctx context.Context, db *sql.DB, query string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, &ErrSQLExecution{Query: query, DBErr: err}
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		name := ""
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// Whether a persisted table exists on the main schema
+func tableExists(ctx context.Context, db *sql.DB, table string) bool {
+	found := 0
+	err := db.QueryRowContext(ctx,
+		"SELECT count(*) FROM duckdb_tables() WHERE database_name = current_database() AND schema_name = 'main' AND table_name = ?", table,
+	).Scan(&found)
+	return err == nil && found > 0
+}
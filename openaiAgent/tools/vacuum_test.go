@@ -0,0 +1,122 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Outcomes of a table on a vacuum
+const (
+	vacuumKept   = "kept"
+	vacuumOrphan = "orphan"
+	vacuumStale  = "stale"
+)
+
+// Fingerprints the case statements insert, see the dataset_fingerprints table
+const caseFingerprint = "INSERT OR REPLACE INTO dataset_fingerprints (table_name, data_path) VALUES ('%s', 'old/%[1]s.parquet')"
+
+// Tables of a database the agent used for a while, besides the dataset table it bootstraps, found and dropped
+// by a vacuum of a temp database on the sales fixture
+func TestVacuumDatabase(t *testing.T) {
+	if err := duckDBAvailable(); err != nil {
+		t.Skipf("DuckDB can't initialize: %s", err)
+	}
+
+	cases := []struct {
+		name   string
+		table  string
+		setup  []string // Statements creating the table or its fingerprint
+		onDisk bool     // Whether the table is persisted before the vacuum, unlike temporary tables and bare fingerprints
+		want   string   // vacuumKept, vacuumOrphan or vacuumStale
+	}{
+		{name: "persisted run table", onDisk: true, table: "tmp_r3_top_stores", setup: []string{"CREATE TABLE tmp_r3_top_stores AS SELECT 1 AS Store_Number"}, want: vacuumOrphan},
+		{name: "uppercase run table", onDisk: true, table: "TMP_R12_Totals", setup: []string{`CREATE TABLE "TMP_R12_Totals" (total INTEGER)`}, want: vacuumOrphan},
+		{name: "run prefix inside the name", onDisk: true, table: "my_tmp_r1_copy", setup: []string{"CREATE TABLE my_tmp_r1_copy (x INTEGER)"}, want: vacuumKept},
+		{name: "temporary run table", table: "tmp_r4_live", setup: []string{"CREATE TEMP TABLE tmp_r4_live (x INTEGER)"}, want: vacuumKept},
+		{name: "dataset no longer configured", onDisk: true, table: "returns", setup: []string{"CREATE TABLE returns AS SELECT 1 AS Store_Number", fmt.Sprintf(caseFingerprint, "returns")}, want: vacuumStale},
+		{name: "fingerprint without its table", table: "inventory", setup: []string{fmt.Sprintf(caseFingerprint, "inventory")}, want: vacuumStale},
+		{name: "user table", onDisk: true, table: "notes", setup: []string{"CREATE TABLE notes (note VARCHAR)"}, want: vacuumKept},
+		{name: "configured dataset", onDisk: true, table: tableName, want: vacuumKept},
+		{name: "fingerprints table", onDisk: true, table: "dataset_fingerprints", want: vacuumKept},
+	}
+
+	databasePath := filepath.Join(t.TempDir(), "data.db")
+	db, _, err := OpenDatabase(databasePath, filepath.Join(datasetFixtures, "sales.parquet"), Sample{}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// Temporary tables only live on the connection creating them, like on a run
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	for _, c := range cases {
+		for _, statement := range c.setup {
+			if _, err := conn.ExecContext(ctx, statement); err != nil {
+				t.Fatalf("setting up '%s': %s", c.name, err)
+			}
+		}
+	}
+
+	targets, err := FindVacuumTargets(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VacuumDatabase(ctx, db, databasePath); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			found := vacuumKept
+			switch {
+			case slices.Contains(targets.OrphanTables, c.table):
+				found = vacuumOrphan
+			case slices.Contains(targets.StaleDatasets, c.table):
+				found = vacuumStale
+			}
+			if found != c.want {
+				t.Fatalf("found as %s, expected %s", found, c.want)
+			}
+
+			if c.want == vacuumStale {
+				fingerprints := 0
+				err := db.QueryRowContext(ctx, "SELECT count(*) FROM dataset_fingerprints WHERE table_name = ?", c.table).Scan(&fingerprints)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fingerprints != 0 {
+					t.Error("the vacuum kept the fingerprint of a stale dataset")
+				}
+			}
+			if !c.onDisk {
+				return
+			}
+
+			exists := 0
+			err := db.QueryRowContext(ctx, "SELECT count(*) FROM duckdb_tables() WHERE table_name = ? AND NOT temporary", c.table).Scan(&exists)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept := exists > 0; kept != (c.want == vacuumKept) {
+				t.Errorf("table kept is %t after the vacuum, expected %t", kept, c.want == vacuumKept)
+			}
+		})
+	}
+
+	// Nothing is left to drop after a vacuum
+	remaining, err := FindVacuumTargets(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !remaining.Empty() {
+		t.Errorf("vacuum left tables to drop: %+v", remaining)
+	}
+}
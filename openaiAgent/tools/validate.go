@@ -0,0 +1,243 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/tracing"
+	"github.com/openai/openai-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+----------------------------
+Structured output validation
+----------------------------
+*/
+
+// Outcomes of a structured output validation, set as structured.validation on spans
+const (
+	ValidationValid     = "valid"     // The answer matched its schema
+	ValidationCorrected = "corrected" // The answer didn't match, the corrective request's answer did
+	ValidationInvalid   = "invalid"   // Neither answer matched, callers fall back
+)
+
+// A structured output answer doesn't match its schema. Each problem names a failing path,
+// like "$.chartType: expected string, got number"
+type ErrSchemaValidation struct {
+	Schema   string
+	Problems []string
+}
+
+func (e *ErrSchemaValidation) Error() string {
+	return fmt.Sprintf("answer doesn't match schema '%s': %s", e.Schema, strings.Join(e.Problems, "; "))
+}
+func (e *ErrSchemaValidation) Class() string             { return ErrorClassSchemaValidation }
+func (e *ErrSchemaValidation) IsRetryable() bool         { return true }
+func (e *ErrSchemaValidation) ExitClass() exitcode.Class { return exitcode.ClassUpstream }
+
+// Check a JSON document against a schema made by generateStrictSchema: types, enums, required properties
+// and additionalProperties. Returns a problem per failing path, sorted, and none when it matches
+func ValidateJSON(schema any, document []byte) []string {
+	schemaNode, ok := schema.(map[string]any)
+	if !ok {
+		return []string{"$: invalid schema"}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return []string{fmt.Sprintf("$: invalid JSON: %s", err)}
+	}
+	if decoder.More() {
+		return []string{"$: invalid JSON: more than one value"}
+	}
+
+	problems := validateValue(schemaNode, value, "$")
+	slices.Sort(problems)
+	return problems
+}
+
+// Problems of a decoded value against a schema node, path locating it
+func validateValue(node map[string]any, value any, path string) []string {
+	actual := jsonType(value)
+	if !slices.ContainsFunc(schemaTypes(node), func(expected string) bool {
+		return expected == actual || (expected == "number" && actual == "integer")
+	}) {
+		return []string{fmt.Sprintf("%s: expected %s, got %s", path, strings.Join(schemaTypes(node), " or "), actual)}
+	}
+
+	if enum, ok := node["enum"].([]any); ok && !slices.ContainsFunc(enum, func(allowed any) bool { return sameJSON(allowed, value) }) {
+		return []string{fmt.Sprintf("%s: %s isn't one of the allowed values", path, compactJSON(value))}
+	}
+
+	problems := []string{}
+	switch value := value.(type) {
+	case map[string]any:
+		properties, _ := node["properties"].(map[string]any)
+		for _, name := range schemaStrings(node["required"]) {
+			if _, ok := value[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s: missing required property", path, name))
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(value)) {
+			propertyNode, ok := properties[name].(map[string]any)
+			if !ok {
+				if node["additionalProperties"] == false {
+					problems = append(problems, fmt.Sprintf("%s.%s: unexpected property", path, name))
+				}
+				continue
+			}
+			problems = append(problems, validateValue(propertyNode, value[name], path+"."+name)...)
+		}
+	case []any:
+		items, _ := node["items"].(map[string]any)
+		for i, item := range value {
+			if items != nil {
+				problems = append(problems, validateValue(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+
+	return problems
+}
+
+// Types a schema node allows, any when it has none
+func schemaTypes(node map[string]any) []string {
+	if types := schemaStrings(node["type"]); len(types) != 0 {
+		return types
+	}
+	return []string{"object", "array", "string", "number", "integer", "boolean", "null"}
+}
+
+// Strings of a schema keyword, given as a string or a list. Lists are []string on generated schemas
+// and []any on decoded ones
+func schemaStrings(keyword any) []string {
+	switch keyword := keyword.(type) {
+	case string:
+		return []string{keyword}
+	case []string:
+		return keyword
+	case []any:
+		values := []string{}
+		for _, value := range keyword {
+			values = append(values, fmt.Sprint(value))
+		}
+		return values
+	}
+	return nil
+}
+
+// JSON Schema type of a value decoded with UseNumber
+func jsonType(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	}
+	return "object"
+}
+
+func compactJSON(value any) string {
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(jsonBytes)
+}
+
+func sameJSON(a any, b any) bool {
+	return compactJSON(a) == compactJSON(b)
+}
+
+// Check a structured output answer against its schema before it's unmarshalled. A failing answer gets one
+// corrective request: params with the answer and the validator errors appended, checked the same way.
+// Returns the valid JSON, or ErrSchemaValidation when the corrected answer fails too. The outcome and the
+// problems found are set on span
+func (t *Toolbox) validateStructuredOutput(
+	ctx context.Context,
+	span trace.Span,
+	params openai.ChatCompletionNewParams,
+	schemaName string,
+	schema any,
+	answer string,
+) (string, error) {
+	problems := ValidateJSON(schema, []byte(answer))
+	if len(problems) == 0 {
+		tracing.SetSpanAttr(span, "structured.validation", ValidationValid)
+		return answer, nil
+	}
+	tracing.SetSpanAttr(span, "structured.validation_errors", problems)
+
+	corrected, correctionErr := t.correctStructuredOutput(ctx, params, answer, problems)
+	if correctionErr == nil {
+		problems = ValidateJSON(schema, []byte(corrected))
+		if len(problems) == 0 {
+			tracing.SetSpanAttr(span, "structured.validation", ValidationCorrected)
+			return corrected, nil
+		}
+	}
+
+	tracing.SetSpanAttr(span, "structured.validation", ValidationInvalid)
+	if correctionErr != nil {
+		tracing.SetSpanAttr(span, "structured.correction_error", correctionErr.Error())
+	}
+	return "", &ErrSchemaValidation{Schema: schemaName, Problems: problems}
+}
+
+// Ask the model to fix a structured output answer, telling it the problems the validator found
+func (t *Toolbox) correctStructuredOutput(ctx context.Context, params openai.ChatCompletionNewParams, answer string, problems []string) (string, error) {
+	messages := append(
+		slices.Clone(params.Messages.Value),
+		openai.AssistantMessage(answer),
+		openai.UserMessage(fmt.Sprintf(
+			"Your JSON doesn't match the required schema:\n- %s\nAnswer again with the whole JSON fixed.",
+			strings.Join(problems, "\n- "),
+		)),
+	)
+	params.Messages = openai.F(messages)
+
+	llmCtx, llmSpan := t.tracer.StartOpenAISpan(ctx, params.Model.Value)
+	defer llmSpan.End()
+	tracing.SetSpanInputMessages(llmSpan, messages)
+
+	response, err := t.completer.New(llmCtx, params)
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		return "", &ErrLLMUnavailable{Err: err}
+	}
+	message, err := completion.Message(response)
+	if err != nil {
+		tracing.SetSpanErrorCode(llmSpan)
+		return "", err
+	}
+
+	tracing.SetSpanAttrFromMap(llmSpan, map[string]any{
+		"llm.token_count.prompt":     response.Usage.PromptTokens,
+		"llm.token_count.completion": response.Usage.CompletionTokens,
+		"llm.token_count.total":      response.Usage.TotalTokens,
+		"llm.tools":                  []string{},
+	})
+	tracing.SetSpanOutputMessages(llmSpan, []openai.ChatCompletionMessage{message})
+	tracing.SetSpanSuccessCode(llmSpan)
+
+	return cleanLlmBlockResponse(message.Content), nil
+}
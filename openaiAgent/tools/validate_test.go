@@ -0,0 +1,83 @@
+package tools
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Schema shaped like the structured outputs, with nested objects, arrays and an enum
+type validationConfig struct {
+	Title  string   `json:"title"`
+	Kind   string   `json:"kind" jsonschema:"enum=bar,enum=line"`
+	Values []int    `json:"values"`
+	Ratio  float64  `json:"ratio"`
+	Labels []string `json:"labels"`
+	Axis   struct {
+		Label string `json:"label"`
+		Log   bool   `json:"log"`
+	} `json:"axis"`
+}
+
+var validationSchema = lazyStrictSchema[validationConfig]()
+
+const validDocument = `{"title": "Sales", "kind": "bar", "values": [1, 2], "ratio": 0.5, "labels": ["a", "b"], "axis": {"label": "x", "log": false}}`
+
+// Valid documents, missing required properties, wrong types and extra properties
+func TestValidateJSON(t *testing.T) {
+	cases := []struct {
+		name     string
+		document string
+		chart    bool // Validate against the chart config schema instead of validationSchema
+		want     []string
+	}{
+		{name: "valid", document: validDocument, want: []string{}},
+		{name: "integer as number", document: strings.Replace(validDocument, `0.5`, `1`, 1), want: []string{}},
+		{name: "missing required", document: `{"title": "Sales", "kind": "bar", "values": [], "ratio": 1, "labels": []}`, want: []string{
+			"$.axis: missing required property",
+		}},
+		{name: "missing nested required", document: strings.Replace(validDocument, `"label": "x", `, ``, 1), want: []string{
+			"$.axis.label: missing required property",
+		}},
+		{name: "wrong types", document: `{"title": 3, "kind": "bar", "values": [1, "2", 3.5], "ratio": "half", "labels": null, "axis": {"label": "x", "log": "no"}}`, want: []string{
+			"$.axis.log: expected boolean, got string",
+			"$.labels: expected array, got null",
+			"$.ratio: expected number, got string",
+			"$.title: expected string, got integer",
+			"$.values[1]: expected integer, got string",
+			"$.values[2]: expected integer, got number",
+		}},
+		{name: "enum", document: strings.Replace(validDocument, `"bar"`, `"pie"`, 1), want: []string{
+			`$.kind: "pie" isn't one of the allowed values`,
+		}},
+		{name: "extra properties", document: strings.Replace(validDocument, `"log": false}`, `"log": false, "min": 0}, "color": "red"`, 1), want: []string{
+			"$.axis.min: unexpected property",
+			"$.color: unexpected property",
+		}},
+		{name: "root type", document: `["Sales"]`, want: []string{"$: expected object, got array"}},
+		{name: "invalid JSON", document: `{"title": "Sales",`, want: []string{"$: invalid JSON: unexpected EOF"}},
+		{name: "trailing value", document: validDocument + ` {}`, want: []string{"$: invalid JSON: more than one value"}},
+		{name: "chart config", chart: true, document: `{"chartType": "bar", "xAxis": "Store", "yAxis": "Sales", "title": "Sales by store", "binCount": 0, "bucketBy": ""}`, want: []string{}},
+		{name: "empty chart config", chart: true, document: `{}`, want: []string{
+			"$.binCount: missing required property",
+			"$.bucketBy: missing required property",
+			"$.chartType: missing required property",
+			"$.title: missing required property",
+			"$.xAxis: missing required property",
+			"$.yAxis: missing required property",
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			schema := validationSchema()
+			if c.chart {
+				schema = visualConfigSchema()
+			}
+
+			if problems := ValidateJSON(schema, []byte(c.document)); !slices.Equal(problems, c.want) {
+				t.Fatalf("found %q, expected %q", problems, c.want)
+			}
+		})
+	}
+}
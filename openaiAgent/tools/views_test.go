@@ -0,0 +1,167 @@
+package tools
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Every declared view is created at bootstrap and adds up to the totals of the dataset table
+func TestDatasetViews(t *testing.T) {
+	store, err := openFixtureStore(t, StorageDuckDB, "sales.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := store.SQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	totalQty := 0.0
+	if err := db.QueryRow("SELECT sum(Qty_Sold) FROM sales").Scan(&totalQty); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		view string
+	}{
+		{name: "per store", view: "sales_by_store"},
+		{name: "per product class", view: "sales_by_product_class"},
+		{name: "per month", view: "sales_by_month"},
+		{name: "per promotion status", view: "sales_by_promo"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			viewQty := 0.0
+			if err := db.QueryRow(fmt.Sprintf("SELECT sum(Total_Qty_Sold) FROM %s", c.view)).Scan(&viewQty); err != nil {
+				t.Fatalf("failed to select from view '%s': %s", c.view, err)
+			}
+			if viewQty != totalQty {
+				t.Errorf("view '%s' sums %v sold units, the table sums %v", c.view, viewQty, totalQty)
+			}
+		})
+	}
+
+	// Refreshing replaces the views along with the table, reopening the database they live on
+	if err := store.Refresh(filepath.Join(datasetFixtures, "sales.parquet")); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = store.SQL(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		if !viewExists(t, db, c.view) {
+			t.Errorf("view '%s' is gone after a refresh", c.view)
+		}
+	}
+}
+
+// Views are skipped on a table missing required columns, and a view that can't be created is named in the error
+func TestCreateViewsFailures(t *testing.T) {
+	cases := []struct {
+		name    string
+		fixture string
+		views   []datasetView
+		wantErr string
+	}{
+		{name: "missing columns", fixture: "missing_columns.parquet"},
+		{
+			name:    "invalid view",
+			fixture: "sales.parquet",
+			views:   []datasetView{{Name: "sales_by_region", SQL: "SELECT Region, count(*) FROM sales GROUP BY Region"}},
+			wantErr: "failed to create view 'sales_by_region'",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			store, err := openFixtureStore(t, StorageDuckDB, c.fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			db, err := store.SQL()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c.views != nil {
+				schema := datasetSchemas[tableName]
+				original := schema.Views
+				schema.Views = c.views
+				datasetSchemas[tableName] = schema
+				t.Cleanup(func() {
+					schema.Views = original
+					datasetSchemas[tableName] = schema
+				})
+			}
+
+			err = createViews(db)
+			switch {
+			case c.wantErr == "" && err != nil:
+				t.Fatalf("expected the views to be skipped, got: %s", err)
+			case c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)):
+				t.Fatalf("expected error containing '%s', got: %v", c.wantErr, err)
+			case c.wantErr == "":
+				if viewExists(t, db, "sales_by_store") {
+					t.Error("views were created on a table missing required columns")
+				}
+			}
+		})
+	}
+}
+
+// The SQL generation prompt lists the declared views, or the tables of a live database instead
+func TestSchemaDescription(t *testing.T) {
+	cases := []struct {
+		name       string
+		liveTables []string
+		want       []string
+		notWant    []string
+	}{
+		{
+			name: "dataset views",
+			want: []string{
+				"\n- sales_by_store: Total quantity sold and sales value per store",
+				"\n- sales_by_product_class: ",
+				"\n- sales_by_month: ",
+				"\n- sales_by_promo: Sales split by promotion status",
+			},
+		},
+		{
+			name:       "live tables",
+			liveTables: []string{"orders", "customers"},
+			want:       []string{"\n- orders\n- customers"},
+			notWant:    []string{"sales_by_store"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			toolbox := NewToolbox(Config{LiveTables: c.liveTables}, nil, nil, nil, nil)
+			description := toolbox.schemaDescription()
+			for _, want := range c.want {
+				if !strings.Contains(description, want) {
+					t.Errorf("description %q doesn't contain %q", description, want)
+				}
+			}
+			for _, notWant := range c.notWant {
+				if strings.Contains(description, notWant) {
+					t.Errorf("description %q contains %q", description, notWant)
+				}
+			}
+		})
+	}
+}
+
+func viewExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	count := 0
+	if err := db.QueryRow("SELECT count(*) FROM duckdb_views() WHERE view_name = ?", name).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count > 0
+}
@@ -0,0 +1,126 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/llmdebug"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+----------------------
+LLM message attributes
+----------------------
+*/
+
+// Environment variable with the max characters of each message content kept on LLM spans
+const PreviewCharsEnv = "TRACE_PREVIEW_CHARS"
+
+// Characters of each message content kept on LLM spans when PreviewCharsEnv isn't set
+const DefaultPreviewChars = 1000
+
+// Max characters of each message content kept on LLM spans
+func PreviewChars() int {
+	previewChars, err := strconv.Atoi(os.Getenv(PreviewCharsEnv))
+	if err != nil || previewChars < 1 {
+		return DefaultPreviewChars
+	}
+
+	return previewChars
+}
+
+// Fields of a chat message kept on spans. Messages without text content, like tool calls, keep those instead
+type spanMessage struct {
+	Role      string          `json:"role"`
+	Content   json.RawMessage `json:"content"`
+	ToolCalls json.RawMessage `json:"tool_calls"`
+}
+
+// Set the input messages of an LLM call as size limited attributes, see setSpanMessages
+func SetSpanInputMessages[M any](span trace.Span, messages []M) {
+	setSpanMessages(span, "llm.input", messages)
+}
+
+// Set the output messages of an LLM call as size limited attributes, see setSpanMessages
+func SetSpanOutputMessages[M any](span trace.Span, messages []M) {
+	setSpanMessages(span, "llm.output", messages)
+}
+
+// Set the role and a content preview of each message, their count, total content characters and
+// a hash of the full messages, so identical prompts can be correlated without storing them
+func setSpanMessages[M any](span trace.Span, prefix string, messages []M) {
+	previewChars := PreviewChars()
+	totalChars := 0
+	serialized := []string{}
+
+	for index, message := range messages {
+		messageBytes, err := json.Marshal(message)
+		if err != nil {
+			messageBytes = fmt.Appendf(nil, "%v", message)
+		}
+		serialized = append(serialized, string(messageBytes))
+
+		parsed := spanMessage{}
+		json.Unmarshal(messageBytes, &parsed)
+		content := messageContent(parsed)
+		totalChars += utf8.RuneCountInString(content)
+
+		preview, truncated := llmdebug.Truncate(content, previewChars)
+		messageKey := fmt.Sprintf("%s_messages.%d.message", prefix, index)
+		SetSpanAttrFromMap(span, map[string]any{
+			messageKey + ".role":      parsed.Role,
+			messageKey + ".content":   preview,
+			messageKey + ".truncated": truncated,
+		})
+	}
+
+	SetSpanAttrFromMap(span, map[string]any{
+		prefix + "_message_count": len(messages),
+		prefix + "_chars":         totalChars,
+		prefix + "_sha256":        llmdebug.ContentHash(strings.Join(serialized, "\n")),
+	})
+}
+
+// Text content of a message. Text parts are joined, other parts and tool calls are kept as their JSON
+func messageContent(message spanMessage) string {
+	content := ""
+	if err := json.Unmarshal(message.Content, &content); err != nil && !isNullJson(message.Content) {
+		content = textParts(message.Content)
+	}
+
+	if content == "" && !isNullJson(message.ToolCalls) {
+		return string(message.ToolCalls)
+	}
+
+	return content
+}
+
+// Text of content parts, or the raw parts if any of them isn't text
+func textParts(rawParts json.RawMessage) string {
+	parts := []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}{}
+	if err := json.Unmarshal(rawParts, &parts); err != nil {
+		return string(rawParts)
+	}
+
+	texts := []string{}
+	for _, part := range parts {
+		if part.Type != "text" {
+			return string(rawParts)
+		}
+		texts = append(texts, part.Text)
+	}
+
+	return strings.Join(texts, "\n")
+}
+
+func isNullJson(raw json.RawMessage) bool {
+	return len(raw) == 0 || string(raw) == "null"
+}
@@ -0,0 +1,117 @@
+package tracing
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/llmdebug"
+	"github.com/openai/openai-go"
+)
+
+// Attributes an LLM span gets for its input messages, keyed by attribute name
+func inputMessageAttrs(t *testing.T, messages []openai.ChatCompletionMessageParamUnion) map[string]string {
+	t.Helper()
+	tracer, recorder := NewRecordingTracer()
+	_, span := tracer.StartOpenAISpan(context.Background(), "gpt-4o-mini")
+	SetSpanInputMessages(span, messages)
+	span.End()
+
+	attrs := map[string]string{}
+	for _, attr := range recorder.Ended()[0].Attributes() {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	return attrs
+}
+
+// Each message keeps its role and a preview cut to the configured characters, the span keeps
+// their count, total characters and a hash of the full messages
+func TestSpanInputMessages(t *testing.T) {
+	data := strings.Repeat("Store_Number, Total_Sale_Value\n110, 12.5\n", 100)
+	toolCall := openai.ChatCompletionMessageToolCallParam{
+		ID:       openai.F("call_1"),
+		Type:     openai.F(openai.ChatCompletionMessageToolCallTypeFunction),
+		Function: openai.F(openai.ChatCompletionMessageToolCallFunctionParam{Name: openai.F("LookUpSalesData"), Arguments: openai.F(`{}`)}),
+	}
+	assistant := openai.AssistantMessage("")
+	assistant.ToolCalls = openai.F([]openai.ChatCompletionMessageToolCallParam{toolCall})
+
+	cases := []struct {
+		name         string
+		previewChars string // Value of PreviewCharsEnv
+		message      openai.ChatCompletionMessageParamUnion
+		role         string
+		preview      string
+		truncated    bool
+		chars        int
+	}{
+		{name: "short", message: openai.UserMessage("What were the total sales?"), role: "user", preview: "What were the total sales?", chars: 26},
+		{
+			name:      "default limit",
+			message:   openai.UserMessage("Analyze:\n" + data),
+			role:      "user",
+			preview:   ("Analyze:\n" + data)[:DefaultPreviewChars],
+			truncated: true,
+			chars:     len(data) + 9,
+		},
+		{
+			name:         "multi-byte boundary",
+			previewChars: "3",
+			message:      openai.SystemMessage("売上合計"),
+			role:         "system",
+			preview:      "売上合",
+			truncated:    true,
+			chars:        4,
+		},
+		{name: "invalid limit", previewChars: "none", message: openai.SystemMessage("売上合計"), role: "system", preview: "売上合計", chars: 4},
+		{
+			name:    "tool calls",
+			message: assistant,
+			role:    "assistant",
+			preview: `[{"function":{"arguments":"{}","name":"LookUpSalesData"},"id":"call_1","type":"function"}]`,
+			chars:   90,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Setenv(PreviewCharsEnv, c.previewChars)
+			attrs := inputMessageAttrs(t, []openai.ChatCompletionMessageParamUnion{c.message})
+
+			want := map[string]string{
+				"llm.input_messages.0.message.role":      c.role,
+				"llm.input_messages.0.message.content":   c.preview,
+				"llm.input_messages.0.message.truncated": strconv.FormatBool(c.truncated),
+				"llm.input_message_count":                "1",
+				"llm.input_chars":                        strconv.Itoa(c.chars),
+			}
+			for key, value := range want {
+				if attrs[key] != value {
+					t.Errorf("%s is %q, expected %q", key, attrs[key], value)
+				}
+			}
+			if len(attrs["llm.input_sha256"]) != 64 {
+				t.Errorf("llm.input_sha256 %q isn't a SHA-256", attrs["llm.input_sha256"])
+			}
+		})
+	}
+}
+
+// Identical prompts share their hash even when their previews are cut, different ones don't
+func TestSpanMessagesHash(t *testing.T) {
+	t.Setenv(PreviewCharsEnv, "5")
+	prompt := []openai.ChatCompletionMessageParamUnion{openai.SystemMessage("You answer sales questions"), openai.UserMessage("Total sales?")}
+	same := inputMessageAttrs(t, prompt)["llm.input_sha256"]
+	other := inputMessageAttrs(t, []openai.ChatCompletionMessageParamUnion{openai.SystemMessage("You answer sales questions"), openai.UserMessage("Total units?")})["llm.input_sha256"]
+
+	if again := inputMessageAttrs(t, prompt)["llm.input_sha256"]; again != same {
+		t.Errorf("the same prompt hashed to %s and %s", same, again)
+	}
+	if other == same {
+		t.Error("different prompts share their hash")
+	}
+	if same == llmdebug.ContentHash("") {
+		t.Error("the hash doesn't cover the messages")
+	}
+}
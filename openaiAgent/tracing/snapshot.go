@@ -0,0 +1,226 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	traceSdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+/*
+-----------------------
+Span attribute snapshot
+-----------------------
+*/
+
+// Keys Phoenix reads to render the spans, by openinference span kind. Phoenix doesn't fail on a missing or
+// renamed key, it silently stops rendering what the key fed, so these are checked on every snapshot.
+// They're spelled out instead of using the key constants, so renaming a constant is caught too:
+//   - openinference.span.kind, on every span, picks the span icon and the view its attributes are shown in
+//   - input.value and output.value fill the input and output panes of agent, chain and tool spans
+//   - llm.model_name fills the model column of LLM spans, and with llm.token_count.* the token and cost columns
+//   - llm.input_messages.N.message.* and llm.output_messages.N.message.* render the chat view of LLM spans
+var phoenixRequiredKeys = map[string][]string{
+	"":      {"openinference.span.kind"},
+	"AGENT": {"input.value", "output.value"},
+	"CHAIN": {"input.value", "output.value"},
+	"TOOL":  {"input.value", "output.value"},
+	"LLM": {
+		"llm.model_name",
+		"llm.token_count.prompt",
+		"llm.token_count.completion",
+		"llm.token_count.total",
+		"llm.input_messages.N.message.role",
+		"llm.input_messages.N.message.content",
+		"llm.output_messages.N.message.role",
+		"llm.output_messages.N.message.content",
+	},
+}
+
+// Index segments of attribute keys, like the message index of "llm.input_messages.3.message.role"
+var keyIndexRegex = regexp.MustCompile(`\.\d+(\.|$)`)
+
+// Name, openinference kind and attribute keys of an exported span. Values change on every run, keys only on code changes
+type SpanSnapshot struct {
+	Name string   `json:"name"`
+	Kind string   `json:"kind"`
+	Keys []string `json:"keys"`
+}
+
+// Tracer recording the spans it ends in memory instead of exporting them, for snapshots of a run
+func NewRecordingTracer() (*Tracer, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := traceSdk.NewTracerProvider(traceSdk.WithSpanProcessor(recorder))
+	return &Tracer{provider: provider, tracer: provider.Tracer(DefaultProjectName)}, recorder
+}
+
+// Canonical snapshot of ended spans: index segments of keys become "N", keys are sorted and deduplicated,
+// and spans are sorted by name, kind and keys, so concurrent tool calls snapshot the same way on every run
+func SnapshotSpans(spans []traceSdk.ReadOnlySpan) []SpanSnapshot {
+	snapshots := []SpanSnapshot{}
+	for _, span := range spans {
+		snapshot := SpanSnapshot{Name: span.Name(), Keys: []string{}}
+		for _, attr := range span.Attributes() {
+			key := keyIndexRegex.ReplaceAllString(string(attr.Key), ".N$1")
+			if key == openInferenceSpanKindKey {
+				snapshot.Kind = attr.Value.Emit()
+			}
+			if !slices.Contains(snapshot.Keys, key) {
+				snapshot.Keys = append(snapshot.Keys, key)
+			}
+		}
+		slices.Sort(snapshot.Keys)
+		snapshots = append(snapshots, snapshot)
+	}
+
+	slices.SortStableFunc(snapshots, func(a SpanSnapshot, b SpanSnapshot) int {
+		return strings.Compare(snapshotID(a)+"\x00"+strings.Join(a.Keys, ","), snapshotID(b)+"\x00"+strings.Join(b.Keys, ","))
+	})
+	return snapshots
+}
+
+// Name and kind of a snapshot span, like "RouterCall (CHAIN)"
+func snapshotID(snapshot SpanSnapshot) string {
+	return fmt.Sprintf("%s (%s)", snapshot.Name, snapshot.Kind)
+}
+
+// Phoenix required keys missing from the snapshot spans, like "RouterCall (CHAIN): output.value"
+func MissingPhoenixKeys(snapshots []SpanSnapshot) []string {
+	missing := []string{}
+	for _, snapshot := range snapshots {
+		required := slices.Concat(phoenixRequiredKeys[""], phoenixRequiredKeys[snapshot.Kind])
+		for _, key := range required {
+			entry := fmt.Sprintf("%s: %s", snapshotID(snapshot), key)
+			if !slices.Contains(snapshot.Keys, key) && !slices.Contains(missing, entry) {
+				missing = append(missing, entry)
+			}
+		}
+	}
+
+	return missing
+}
+
+// Changes between a snapshot and the one it's compared to, one per line like
+// "+ RouterCall (CHAIN): agent.new_key", "- LookUpTool (TOOL): tool.sql" or "+ span NewTool (TOOL)".
+// Spans are matched by name and kind, keys anywhere on the spans of a name and kind count once
+func DiffSnapshots(golden []SpanSnapshot, current []SpanSnapshot) []string {
+	goldenKeys, currentKeys := snapshotKeys(golden), snapshotKeys(current)
+	changes := []string{}
+	for _, id := range sortedIDs(goldenKeys, currentKeys) {
+		keys, inGolden := goldenKeys[id]
+		newKeys, inCurrent := currentKeys[id]
+		switch {
+		case !inCurrent:
+			changes = append(changes, fmt.Sprintf("- span %s", id))
+			continue
+		case !inGolden:
+			changes = append(changes, fmt.Sprintf("+ span %s", id))
+			continue
+		}
+
+		for _, key := range keys {
+			if !slices.Contains(newKeys, key) {
+				changes = append(changes, fmt.Sprintf("- %s: %s", id, key))
+			}
+		}
+		for _, key := range newKeys {
+			if !slices.Contains(keys, key) {
+				changes = append(changes, fmt.Sprintf("+ %s: %s", id, key))
+			}
+		}
+	}
+
+	return changes
+}
+
+// Sorted union of the attribute keys of the spans of each name and kind
+func snapshotKeys(snapshots []SpanSnapshot) map[string][]string {
+	keys := map[string][]string{}
+	for _, snapshot := range snapshots {
+		id := snapshotID(snapshot)
+		for _, key := range snapshot.Keys {
+			if !slices.Contains(keys[id], key) {
+				keys[id] = append(keys[id], key)
+			}
+		}
+		if _, ok := keys[id]; !ok {
+			keys[id] = []string{}
+		}
+	}
+	for id := range keys {
+		slices.Sort(keys[id])
+	}
+
+	return keys
+}
+
+// Sorted span IDs of either snapshot
+func sortedIDs(a map[string][]string, b map[string][]string) []string {
+	ids := []string{}
+	for _, keys := range []map[string][]string{a, b} {
+		for id := range keys {
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	slices.Sort(ids)
+
+	return ids
+}
+
+// Read a snapshot file. Missing files fail with an error wrapping os.ErrNotExist
+func LoadSnapshot(path string) ([]SpanSnapshot, error) {
+	jsonBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read span snapshot: %w", err)
+	}
+
+	snapshots := []SpanSnapshot{}
+	if err := json.Unmarshal(jsonBytes, &snapshots); err != nil {
+		return nil, fmt.Errorf("failed to decode span snapshot %s: %w", path, err)
+	}
+
+	return snapshots, nil
+}
+
+// Write a snapshot file as indented JSON, one key per line so reviews show key level diffs
+func SaveSnapshot(path string, snapshots []SpanSnapshot) error {
+	jsonBytes, err := json.MarshalIndent(snapshots, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(jsonBytes, '\n'), 0o644)
+}
+
+// Read the intentional snapshot changes listed on an allowlist file, one per line as printed by DiffSnapshots.
+// Blank lines and lines starting with # are skipped. A missing file allows no change
+func LoadSnapshotAllowlist(path string) ([]string, error) {
+	allowlistFile, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read span snapshot allowlist: %w", err)
+	}
+	defer allowlistFile.Close()
+
+	allowed := []string{}
+	scanner := bufio.NewScanner(allowlistFile)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			allowed = append(allowed, line)
+		}
+	}
+
+	return allowed, scanner.Err()
+}
@@ -0,0 +1,402 @@
+// Package tracing holds helper functions and types for easily handling openinference-like spans,
+// tracer providers, and other telemetry stuff.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/llmdebug"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	traceSdk "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Interface to use when setting attributes on spans
+type SpanAttributeDataType interface {
+	string | []string | int | []int | int64 | float64 | bool
+}
+
+// Span kind datatype for replicated openinference spans
+type OpenInferenceSpanKind string
+
+// Replication of openinference span kinds
+const (
+	AgentKind     OpenInferenceSpanKind = "agent"
+	ChainKind     OpenInferenceSpanKind = "chain"
+	EmbeddingKind OpenInferenceSpanKind = "embedding"
+	EvaluatorKind OpenInferenceSpanKind = "evaluator"
+	GuardrailKind OpenInferenceSpanKind = "guardrail"
+	LLMKind       OpenInferenceSpanKind = "llm"
+	RerankerKind  OpenInferenceSpanKind = "reranker"
+	RetrieverKind OpenInferenceSpanKind = "retriever"
+	ToolKind      OpenInferenceSpanKind = "tool"
+	UnknownKind   OpenInferenceSpanKind = "unknown"
+)
+
+// Constants for replication of openinference traces
+// Used to display traces and spans properly on phoenix
+const DefaultProjectName = "Zeke-Go-OpenAI-Agent"
+const openInferenceProjectNameKey = "openinference.project.name"
+const openInferenceSpanKindKey = "openinference.span.kind"
+const openInferenceInputKey = "input.value"
+const openInferenceOutputKey = "output.value"
+
+// Environment variables of the Phoenix exporter
+const (
+	PhoenixEndpointEnv = "PHOENIX_COLLECTOR_ENDPOINT"
+	PhoenixHeadersEnv  = "PHOENIX_CLIENT_HEADERS"
+)
+
+// Tracer starting openinference replicated spans.
+// Parent spans are carried on the context passed to each Start function
+type Tracer struct {
+	provider *traceSdk.TracerProvider
+	tracer   trace.Tracer
+}
+
+// Create a tracer exporting to Phoenix. Reads 'PHOENIX_COLLECTOR_ENDPOINT' and
+// 'PHOENIX_CLIENT_HEADERS' environment variables. Resource attributes are added to every span exported
+func NewPhoenixTracer(projectName string, resourceAttributes ...attribute.KeyValue) (*Tracer, error) {
+	log.Println("Initializing tracer provider")
+	endpoint := os.Getenv(PhoenixEndpointEnv)
+	headers := os.Getenv(PhoenixHeadersEnv)
+	if endpoint == "" || headers == "" {
+		return nil, fmt.Errorf("'%s' or '%s' environment variables are not defined", PhoenixEndpointEnv, PhoenixHeadersEnv)
+	}
+
+	headerMap := make(map[string]string)
+	for h := range strings.SplitSeq(headers, ",") {
+		parts := strings.Split(h, "=")
+		if len(parts) == 2 {
+			headerMap[parts[0]] = parts[1]
+		}
+	}
+
+	// Create an OpenTelemetry HTTP exporter to send traces to Phoenix AI
+	exporter, err := otlptracehttp.New(
+		context.Background(),
+		otlptracehttp.WithEndpointURL(endpoint+"/v1/traces"),
+		otlptracehttp.WithHeaders(headerMap),
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize exporter: %w", err)
+	}
+
+	// Create a new tracer provider
+	provider := traceSdk.NewTracerProvider(
+		traceSdk.WithBatcher(exporter),
+		traceSdk.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			append([]attribute.KeyValue{attribute.String(openInferenceProjectNameKey, projectName)}, resourceAttributes...)...,
+		)),
+	)
+
+	return &Tracer{provider: provider, tracer: provider.Tracer(projectName)}, nil
+}
+
+// Check that the Phoenix collector answers. Any HTTP response counts, since the collector only
+// accepts exports, so only undefined variables and connection failures are errors
+func PingPhoenix(ctx context.Context) error {
+	endpoint := os.Getenv(PhoenixEndpointEnv)
+	if endpoint == "" || os.Getenv(PhoenixHeadersEnv) == "" {
+		return fmt.Errorf("'%s' or '%s' environment variables are not defined", PhoenixEndpointEnv, PhoenixHeadersEnv)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("invalid '%s': %w", PhoenixEndpointEnv, err)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("phoenix collector at %s is unreachable: %w", endpoint, err)
+	}
+	response.Body.Close()
+
+	return nil
+}
+
+// Resource attribute naming the environment a tracer runs on, like a config profile
+func DeploymentEnvironment(name string) attribute.KeyValue {
+	return semconv.DeploymentEnvironmentKey.String(name)
+}
+
+// Create a tracer from any tracer provider. Shutdown is left to the provider's owner
+func NewTracer(provider trace.TracerProvider, projectName string) *Tracer {
+	return &Tracer{tracer: provider.Tracer(projectName)}
+}
+
+// Create a tracer that records nothing. Useful when embedding the agent without telemetry
+func NewNoopTracer() *Tracer {
+	return NewTracer(noop.NewTracerProvider(), DefaultProjectName)
+}
+
+// Flush pending spans and shut down the tracer provider, if this tracer owns one
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.provider == nil {
+		return nil
+	}
+
+	log.Println("Shutting down tracer provider")
+	return t.provider.Shutdown(ctx)
+}
+
+// Start a new Span configured similarly to openinference spans
+// Used to displaying correctly on phoenix. Extra options, like links, are applied last
+func (t *Tracer) StartOpenInferenceSpan(
+	parentSpanContext context.Context,
+	spanName string,
+	openInferenceSpanKind OpenInferenceSpanKind,
+	options ...trace.SpanStartOption,
+) (context.Context, trace.Span) {
+	if parentSpanContext == nil {
+		parentSpanContext = context.Background()
+	}
+
+	options = append([]trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String(openInferenceSpanKindKey, strings.ToUpper(string(openInferenceSpanKind))),
+		),
+	}, options...)
+	ctx, span := t.tracer.Start(parentSpanContext, spanName, options...)
+
+	log.Printf("Starting '%s' OpenInference Span with kind '%s'\n", spanName, openInferenceSpanKind)
+	return context.WithValue(ctx, stepNameKey{}, spanName), span
+}
+
+// Context key of the name of the innermost openinference span
+type stepNameKey struct{}
+
+// Get the name of the innermost openinference span started on a context, like "RouterCall".
+// LLM spans started under it share the name. Returns an empty string if none was started
+func StepName(spanContext context.Context) string {
+	if spanContext == nil {
+		return ""
+	}
+
+	name, _ := spanContext.Value(stepNameKey{}).(string)
+	return name
+}
+
+// End an openinference replicated span
+func EndOpenInferenceSpan(span trace.Span) {
+	log.Printf("Ending OpenInference Span with: ID: %s\n", span.SpanContext().SpanID().String())
+	span.End(
+		trace.WithStackTrace(true),
+		trace.WithTimestamp(time.Now()),
+	)
+}
+
+func (t *Tracer) StartOpenAISpan(parentSpanContext context.Context, openaiModel string) (context.Context, trace.Span) {
+	if parentSpanContext == nil {
+		parentSpanContext = context.Background()
+	}
+
+	ctx, span := t.tracer.Start(
+		parentSpanContext,
+		"ChatCompletion",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String(openInferenceSpanKindKey, strings.ToUpper(string(LLMKind))),
+			attribute.String("llm.provider", "openai"),
+			attribute.String("llm.invocation_parameters", fmt.Sprintf("{\"model\": \"%s\"}", openaiModel)),
+			attribute.String("llm.system", "openai"),
+			attribute.String("llm.model_name", openaiModel),
+		),
+	)
+
+	log.Println("Starting openai chat completion LLM span")
+
+	// Name the LLM debug files of the completion after its step and span
+	return llmdebug.WithStep(ctx, StepName(parentSpanContext), span.SpanContext().SpanID().String()), span
+}
+
+// Get the first 8 hex characters of the trace ID carried by a span context.
+// Returns "notrace" if the context has no valid span
+func ShortTraceID(spanContext context.Context) string {
+	if spanContext == nil {
+		return "notrace"
+	}
+
+	traceID := trace.SpanContextFromContext(spanContext).TraceID()
+	if !traceID.IsValid() {
+		return "notrace"
+	}
+
+	return traceID.String()[:8]
+}
+
+// Link a span to another trace, like the one of the run it resumes.
+// The trace ID is also kept as a link attribute, so it survives even without a span ID to link to
+func LinkTrace(traceID string) (trace.SpanStartOption, error) {
+	parsed, err := trace.TraceIDFromHex(traceID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trace ID '%s': %w", traceID, err)
+	}
+
+	return trace.WithLinks(trace.Link{
+		SpanContext: trace.NewSpanContext(trace.SpanContextConfig{TraceID: parsed}),
+		Attributes:  []attribute.KeyValue{attribute.String("link.trace_id", traceID)},
+	}), nil
+}
+
+// Get the hex span ID carried by a span context.
+// Returns an empty string if the context has no valid span
+func SpanID(spanContext context.Context) string {
+	if spanContext == nil {
+		return ""
+	}
+
+	spanID := trace.SpanContextFromContext(spanContext).SpanID()
+	if !spanID.IsValid() {
+		return ""
+	}
+
+	return spanID.String()
+}
+
+// Get the full hex trace ID carried by a span context.
+// Returns an empty string if the context has no valid span
+func TraceID(spanContext context.Context) string {
+	if spanContext == nil {
+		return ""
+	}
+
+	traceID := trace.SpanContextFromContext(spanContext).TraceID()
+	if !traceID.IsValid() {
+		return ""
+	}
+
+	return traceID.String()
+}
+
+/*
+-----------------------------------------
+Functions for setting attributes on spans
+-----------------------------------------
+*/
+
+func SetSpanAttr[T SpanAttributeDataType](span trace.Span, key string, input T) {
+	var attr attribute.KeyValue
+	switch r := any(input).(type) {
+	case string:
+		attr = attribute.String(key, r)
+	case []string:
+		attr = attribute.StringSlice(key, r)
+	case bool:
+		attr = attribute.Bool(key, r)
+	case int:
+		attr = attribute.Int(key, r)
+	case []int:
+		attr = attribute.IntSlice(key, r)
+	case int64:
+		attr = attribute.Int64(key, r)
+	case float64:
+		attr = attribute.Float64(key, r)
+	}
+
+	span.SetAttributes(attr)
+}
+
+func SetSpanInput[T SpanAttributeDataType](span trace.Span, input T) {
+	SetSpanAttr(span, openInferenceInputKey, input)
+}
+
+func SetSpanOutput[T SpanAttributeDataType](span trace.Span, output T) {
+	SetSpanAttr(span, openInferenceOutputKey, output)
+}
+
+func SetSpanModel(span trace.Span, model string) {
+	SetSpanAttr(span, "llm.model_name", model)
+}
+
+func SetSpanAttrFromMap(span trace.Span, kvMap map[string]any) {
+	for k, v := range kvMap {
+		switch r := v.(type) {
+		case string:
+			SetSpanAttr(span, k, r)
+		case int:
+			SetSpanAttr(span, k, r)
+		case []string:
+			SetSpanAttr(span, k, r)
+		case []int:
+			SetSpanAttr(span, k, r)
+		case int64:
+			SetSpanAttr(span, k, r)
+		case float64:
+			SetSpanAttr(span, k, r)
+		case bool:
+			SetSpanAttr(span, k, r)
+		default:
+			log.Printf("Value for key '%s' is not an expected type. Ignoring\n", k)
+			continue
+		}
+	}
+}
+
+// Add an event with attributes to a span. Takes the same value types as SetSpanAttrFromMap
+func AddSpanEvent(span trace.Span, name string, kvMap map[string]any) {
+	attributes := []attribute.KeyValue{}
+	for k, v := range kvMap {
+		switch r := v.(type) {
+		case string:
+			attributes = append(attributes, attribute.String(k, r))
+		case int:
+			attributes = append(attributes, attribute.Int(k, r))
+		case []string:
+			attributes = append(attributes, attribute.StringSlice(k, r))
+		case []int:
+			attributes = append(attributes, attribute.IntSlice(k, r))
+		case int64:
+			attributes = append(attributes, attribute.Int64(k, r))
+		case float64:
+			attributes = append(attributes, attribute.Float64(k, r))
+		case bool:
+			attributes = append(attributes, attribute.Bool(k, r))
+		default:
+			log.Printf("Value for key '%s' is not an expected type. Ignoring\n", k)
+		}
+	}
+
+	span.AddEvent(name, trace.WithAttributes(attributes...))
+}
+
+/*
+---------------
+set span status
+---------------
+*/
+
+func SetSpanGenericStatus(span trace.Span, statusCode codes.Code, message string) {
+	span.SetStatus(statusCode, fmt.Sprintf(
+		"Span ID: '%s'. Status: %s",
+		span.SpanContext().SpanID().String(),
+		message,
+	))
+}
+
+func SetSpanSuccessCode(span trace.Span) {
+	SetSpanGenericStatus(span, codes.Ok, "Successful")
+}
+
+func SetSpanErrorCode(span trace.Span) {
+	SetSpanGenericStatus(span, codes.Error, "Failed")
+}
+
+// Status of spans stopped by a guardrail, told apart from failures by its message
+func SetSpanRefusedCode(span trace.Span) {
+	SetSpanGenericStatus(span, codes.Error, "Refused")
+}
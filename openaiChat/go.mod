@@ -2,7 +2,10 @@ module main
 
 go 1.24.0
 
-require github.com/openai/openai-go v0.1.0-alpha.59
+require (
+	github.com/EzequielGhR/goProjects/openaiAgent v0.0.0
+	github.com/openai/openai-go v0.1.0-alpha.59
+)
 
 require (
 	github.com/tidwall/gjson v1.14.4 // indirect
@@ -10,3 +13,5 @@ require (
 	github.com/tidwall/pretty v1.2.1 // indirect
 	github.com/tidwall/sjson v1.2.5 // indirect
 )
+
+replace github.com/EzequielGhR/goProjects/openaiAgent => ../openaiAgent
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+----------------------
+<<< stderr logging >>>
+----------------------
+*/
+
+// Leading flags picking the log level: --verbose adds debug messages, like the ones of the shared packages,
+// and --quiet keeps warnings and errors only
+const (
+	VERBOSE_FLAG = "--verbose"
+	QUIET_FLAG   = "--quiet"
+)
+
+// Repeats of the same message within this window are dropped, and counted on the next one let through
+const LOG_THROTTLE_WINDOW = 10 * time.Second
+
+// Operational messages, on stderr so stdout only carries the conversation. Set up by setupLogging
+var logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+// Log to w from level up, and send the log package output of the shared packages through the same logger:
+// lines starting with "WARNING: " as warnings, the rest as debug messages
+func setupLogging(w io.Writer, level slog.Level) {
+	handler := slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})
+	seen := &throttleState{last: map[string]time.Time{}, suppressed: map[string]int{}}
+	logger = slog.New(&throttledHandler{Handler: handler, window: LOG_THROTTLE_WINDOW, seen: seen})
+
+	log.SetFlags(0)
+	log.SetOutput(packageLogWriter{logger: logger})
+}
+
+// Writer of the log package, turning each line into a chat log message
+type packageLogWriter struct {
+	logger *slog.Logger
+}
+
+func (w packageLogWriter) Write(line []byte) (int, error) {
+	message := strings.TrimSpace(string(line))
+	if warning, ok := strings.CutPrefix(message, "WARNING: "); ok {
+		w.logger.Warn(warning)
+	} else {
+		w.logger.Debug(message)
+	}
+	return len(line), nil
+}
+
+// Messages last let through and repeats dropped since, keyed by level and message
+type throttleState struct {
+	mu         sync.Mutex
+	last       map[string]time.Time
+	suppressed map[string]int
+}
+
+// Handler dropping repeats of a message within window, like a failing history write on every turn.
+// The next repeat let through carries the amount dropped as suppressed
+type throttledHandler struct {
+	slog.Handler
+	window time.Duration
+	seen   *throttleState
+}
+
+func (h *throttledHandler) Handle(ctx context.Context, record slog.Record) error {
+	key := record.Level.String() + " " + record.Message
+
+	h.seen.mu.Lock()
+	last, ok := h.seen.last[key]
+	if ok && record.Time.Sub(last) < h.window {
+		h.seen.suppressed[key]++
+		h.seen.mu.Unlock()
+		return nil
+	}
+	suppressed := h.seen.suppressed[key]
+	h.seen.last[key] = record.Time
+	delete(h.seen.suppressed, key)
+	h.seen.mu.Unlock()
+
+	if suppressed != 0 {
+		record = record.Clone()
+		record.AddAttrs(slog.Int("suppressed", suppressed))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *throttledHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &throttledHandler{Handler: h.Handler.WithAttrs(attrs), window: h.window, seen: h.seen}
+}
+
+func (h *throttledHandler) WithGroup(name string) slog.Handler {
+	return &throttledHandler{Handler: h.Handler.WithGroup(name), window: h.window, seen: h.seen}
+}
@@ -2,14 +2,31 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
-	"time"
-
+	"unicode"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/config"
+	"github.com/EzequielGhR/goProjects/openaiAgent/exitcode"
+	"github.com/EzequielGhR/goProjects/openaiAgent/history"
+	"github.com/EzequielGhR/goProjects/openaiAgent/terminal"
+	"github.com/EzequielGhR/goProjects/openaiAgent/textdiff"
 	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
 )
 
 /*
@@ -18,8 +35,60 @@ import (
 -----------------
 */
 
-// Path to json with message history
-const HISTORY_PATH = "./history.json"
+// Path to json with message history, relative to the workspace
+const HISTORY_PATH = "history.json"
+
+// Environment variables of the history storage
+const (
+	HISTORY_PATH_ENV   = "CHAT_HISTORY_PATH"   // History file relative to the workspace, HISTORY_PATH by default. A .jsonl file is an append only log
+	HISTORY_FORMAT_ENV = "CHAT_HISTORY_FORMAT" // Set to json or jsonl to pick the storage whatever the file extension
+)
+
+// Workspace marker: the nearest directory holding it, up to the git root, is the workspace unless AGENT_WORKSPACE sets one
+const WORKSPACE_MARKER = "go.mod"
+
+// Environment variables of the JSON response mode
+const (
+	JSON_MODE_ENV    = "CHAT_JSON_MODE"    // Set to 1 to ask for JSON object answers
+	JSON_REPAIRS_ENV = "CHAT_JSON_REPAIRS" // Repair requests sent for a broken JSON answer, 1 by default and 0 to disable them
+)
+
+// Instruction sent with every JSON mode request. The API requires the word JSON in the messages
+const JSON_MODE_INSTRUCTION = "Answer with a single JSON object."
+
+// Set to 1 to keep the answers replaced by <regenerate> as variants of the new answer in the history
+const KEEP_VARIANTS_ENV = "CHAT_KEEP_VARIANTS"
+
+// Set to 1 to print answers as they're generated. Escape or Ctrl+C stops an answer and keeps what it said so far.
+// JSON mode answers are decoded whole, so they aren't streamed
+const STREAM_ENV = "CHAT_STREAM"
+
+// Environment variables of the chat model
+const (
+	MODEL_ENV               = "CHAT_MODEL"               // Chat model of the answers, gpt-4o-mini by default
+	ALLOW_UNKNOWN_MODEL_ENV = "CHAT_ALLOW_UNKNOWN_MODEL" // Set to 1 to use a model missing from the model registry, like a fine tuned one
+)
+
+// Set to anything to print diffs without colors, see https://no-color.org
+const NO_COLOR_ENV = "NO_COLOR"
+
+// Inputs answering the last question again, the second one printing what changed
+const (
+	REGENERATE_COMMAND      = "<regenerate>"
+	REGENERATE_DIFF_COMMAND = "<regenerate --diff>"
+)
+
+// Commands run instead of a question: compacting the JSONL history, and converting a history file to another format
+const (
+	COMPACT_COMMAND = "--compact"
+	CONVERT_COMMAND = "--convert"
+)
+
+// Leading flag picking the format of the error ending the chat: text, or json for a {"code", "class", "message"} line
+const ERROR_FORMAT_FLAG = "--error-format"
+
+// Leading flag asking for JSON object answers, like CHAT_JSON_MODE=1. Stdout then carries one JSON object per answer
+const JSON_FLAG = "--json"
 
 /*
 -------------------------
@@ -27,17 +96,11 @@ const HISTORY_PATH = "./history.json"
 -------------------------
 */
 
-// Simple message structure
-type ChatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
+// Simple message structure, shared with the agent history format
+type ChatMessage = history.ChatMessage
 
-// Simple conversation structure for saving history to json
-type ConversationHistory struct {
-	TimeStamp string         `json:"timeStamp"`
-	Messages  []*ChatMessage `json:"messages"`
-}
+// Simple conversation structure for saving history to json, shared with the agent history format
+type ConversationHistory = history.ConversationHistory
 
 /*
 -------------------------------
@@ -45,9 +108,14 @@ type ConversationHistory struct {
 -------------------------------
 */
 
-var openaiClient *openai.Client = nil
 var historyMessages = []*ChatMessage{}                                // Track history
+var historyStore history.Store                                        // Storage of the history, every change is written to it
 var conversationMessages = []openai.ChatCompletionMessageParamUnion{} // Track openai messages
+var jsonMode = false                                                  // Ask for JSON object answers, set from CHAT_JSON_MODE
+var jsonRepairs = 1                                                   // Repair requests per broken JSON answer, set from CHAT_JSON_REPAIRS
+var streamMode = false                                                // Stream answers, set from CHAT_STREAM
+var errorFormat = exitcode.FormatText                                 // Format of the error ending the chat, set from --error-format
+var getOpenaiClient = completion.GetOpenaiClient                      // Client of the completions, tests swap in one of a mock API
 
 /*
 ---------------------
@@ -55,47 +123,29 @@ var conversationMessages = []openai.ChatCompletionMessageParamUnion{} // Track o
 ---------------------
 */
 
-// Save current message history to a Json at HISOTRY_PATH.
-// Returns an error which is nil on success
-func saveHistoryToJson() error {
-	history := ConversationHistory{
-		TimeStamp: time.Now().Format("2006-01-02T15:04:05"),
-		Messages:  historyMessages,
-	}
-
-	jsonBytes, err := json.MarshalIndent(history, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	jsonFile, err := os.Create(HISTORY_PATH)
-	if err != nil {
-		return err
-	}
-	defer jsonFile.Close()
-
-	_, err = jsonFile.Write(jsonBytes)
+// Open the history store of CHAT_HISTORY_PATH, or HISTORY_PATH, in the format of CHAT_HISTORY_FORMAT or of its extension
+func openHistoryStore() error {
+	historyPath := config.ResolvePath(cmp.Or(os.Getenv(HISTORY_PATH_ENV), HISTORY_PATH))
+	store, err := history.OpenStore(historyPath, os.Getenv(HISTORY_FORMAT_ENV))
 	if err != nil {
-		return err
+		return exitcode.New(exitcode.ClassConfig, fmt.Errorf("invalid %s: %w", HISTORY_FORMAT_ENV, err))
 	}
 
+	historyStore = store
 	return nil
 }
 
-// Load message history from HISTORY_PATH
+// Load message history from the history store
 // Returns an error which is nil on success
-func loadHistoryJson() error {
-	jsonFile, err := os.Open(HISTORY_PATH)
+func loadHistory() error {
+	loadedHistory, err := historyStore.Load()
 	if err != nil {
 		return err
 	}
-	defer jsonFile.Close()
 
-	history := ConversationHistory{}
-	json.NewDecoder(jsonFile).Decode(&history)
-	fmt.Printf("Loading conversation from: %s\n", history.TimeStamp)
+	logger.Info("loading conversation", "saved", loadedHistory.TimeStamp, "history", historyStore.Path())
 
-	historyMessages = history.Messages
+	historyMessages = loadedHistory.Messages
 	for _, message := range historyMessages {
 		addConversationMessage(message)
 	}
@@ -105,10 +155,13 @@ func loadHistoryJson() error {
 
 // Initialize message history and openai messages with a simple system message
 func initConversation() {
-	fmt.Println("Initializing new conversation")
+	logger.Info("initializing new conversation", "history", historyStore.Path())
 	content := "You are a useful assistant"
 	historyMessages = []*ChatMessage{{Role: "system", Content: content}}
 	conversationMessages = []openai.ChatCompletionMessageParamUnion{openai.SystemMessage(content)}
+	if err := historyStore.Save(history.New(historyMessages)); err != nil {
+		logger.Error("failed to save history", "error", err)
+	}
 }
 
 // Load the conversation if any.
@@ -116,32 +169,181 @@ func initConversation() {
 // If restart is true, initConversation is forcefully called.
 func loadConversation(restart bool) {
 	if restart {
-		fmt.Println("Forcefully started new conversation")
-		initConversation()
-	} else if err := loadHistoryJson(); err != nil || len(historyMessages) == 0 {
-		fmt.Fprintln(os.Stderr, "Failed to load history")
+		logger.Info("restarting conversation as requested")
 		initConversation()
+		return
+	}
+
+	err := loadHistory()
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		logger.Info("no history found")
+	case err != nil:
+		logger.Warn("failed to load history", "error", err)
+	case len(historyMessages) == 0:
+		logger.Info("history has no messages")
+	default:
+		return
 	}
+	initConversation()
 }
 
-// Add a message to tracked openai messages based on its role
+// Add a message to tracked openai messages based on its role. Tool calls and tool results are kept as such
 func addConversationMessage(newMessage *ChatMessage) {
-	switch newMessage.Role {
-	case "system":
-		conversationMessages = append(conversationMessages, openai.SystemMessage(newMessage.Content))
-	case "assistant":
-		conversationMessages = append(conversationMessages, openai.AssistantMessage(newMessage.Content))
-	case "user":
-		conversationMessages = append(conversationMessages, openai.UserMessage(newMessage.Content))
-	default:
-		fmt.Fprintf(os.Stderr, "Invalid message role: %s\n", newMessage.Role)
+	message, err := history.MessageParam(newMessage)
+	if err != nil {
+		logger.Warn("skipping history message", "role", newMessage.Role, "error", err)
+		return
 	}
+
+	conversationMessages = append(conversationMessages, message)
 }
 
-// Update both the history messages and the openai messages tracked
+// Update both the history messages and the openai messages tracked, and append the message to the history store
 func updateHistoryAndConversation(newMessage *ChatMessage) {
 	historyMessages = append(historyMessages, newMessage)
 	addConversationMessage(newMessage)
+	if err := historyStore.Append(newMessage); err != nil {
+		logger.Error("failed to save history", "error", err)
+	}
+}
+
+// Remove the last answer from the history and the openai messages, to answer its question again.
+// Returns nil, leaving both untouched, when the last message isn't a plain assistant answer
+func popLastAnswer() *ChatMessage {
+	last := len(historyMessages) - 1
+	if last < 0 || historyMessages[last].Role != "assistant" || len(historyMessages[last].ToolCalls) != 0 {
+		return nil
+	}
+
+	answer := historyMessages[last]
+	historyMessages = historyMessages[:last]
+	conversationMessages = conversationMessages[:len(conversationMessages)-1]
+	if err := historyStore.Undo(); err != nil {
+		logger.Error("failed to save history", "error", err)
+	}
+	return answer
+}
+
+// Rewrite the JSONL history without the answers <regenerate> replaced
+func compactHistory() error {
+	jsonlStore, ok := historyStore.(*history.JSONLStore)
+	if !ok {
+		return exitcode.Errorf(exitcode.ClassConfig, "only JSONL histories are compacted, %s is rewritten on every change", historyStore.Path())
+	}
+
+	dropped, err := jsonlStore.Compact()
+	if err != nil {
+		return err
+	}
+
+	logger.Info("compacted history", "history", jsonlStore.Path(), "dropped_lines", dropped)
+	return nil
+}
+
+// Convert a history file to another one, relative to the workspace. The formats are picked by extension
+func convertHistory(fromPath string, toPath string) error {
+	from, err := history.OpenStore(config.ResolvePath(fromPath), "")
+	if err != nil {
+		return err
+	}
+	to, err := history.OpenStore(config.ResolvePath(toPath), "")
+	if err != nil {
+		return err
+	}
+	if err := history.Convert(from, to); err != nil {
+		return err
+	}
+
+	logger.Info("converted history", "from", from.Path(), "to", to.Path())
+	return nil
+}
+
+// Run --compact, or --convert on its two history files
+func runHistoryCommand(command string, args []string) error {
+	if command == COMPACT_COMMAND {
+		return exitcode.Default(exitcode.ClassData, compactHistory())
+	}
+	if len(args) != 2 {
+		return exitcode.Errorf(exitcode.ClassUsage, "expected the history to convert and the one to write, like %s history.json history.jsonl", CONVERT_COMMAND)
+	}
+
+	return exitcode.Default(exitcode.ClassData, convertHistory(args[0], args[1]))
+}
+
+// Whether diffs can be colored: NO_COLOR isn't set and stdout is a terminal
+func colorEnabled() bool {
+	if _, set := os.LookupEnv(NO_COLOR_ENV); set {
+		return false
+	}
+
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Where the user prompts and answer diffs go: stdout along the answers, or stderr in JSON mode,
+// where stdout only carries the answers
+func promptOutput() io.Writer {
+	if jsonMode {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// Print a JSON mode answer on stdout as a single line. Answers that aren't the JSON object asked for,
+// like refusals, are wrapped as the string value of key
+func printJsonAnswer(answer string, key string) {
+	compacted := bytes.Buffer{}
+	if key == "" && json.Compact(&compacted, []byte(answer)) == nil {
+		fmt.Println(compacted.String())
+		return
+	}
+
+	wrapped, _ := json.Marshal(map[string]string{cmp.Or(key, "invalid_json"): answer})
+	fmt.Println(string(wrapped))
+}
+
+// Print the word diff from a replaced answer to the one regenerated in its place
+func printAnswerDiff(replaced string, regenerated string) {
+	ops := textdiff.Words(replaced, regenerated)
+	if !textdiff.Changed(ops) {
+		fmt.Fprintln(promptOutput(), "diff >> the regenerated answer is the same")
+		return
+	}
+
+	fmt.Fprintf(promptOutput(), "diff >> %s\n", textdiff.Format(ops, colorEnabled()))
+}
+
+// Read the JSON response mode settings from CHAT_JSON_MODE, unless --json set it, and CHAT_JSON_REPAIRS
+func loadJsonModeSettings() error {
+	jsonMode = jsonMode || os.Getenv(JSON_MODE_ENV) == "1"
+	if value := os.Getenv(JSON_REPAIRS_ENV); value != "" {
+		repairs, err := strconv.Atoi(value)
+		if err != nil || repairs < 0 {
+			return exitcode.Errorf(exitcode.ClassConfig, "invalid %s '%s', expected a non negative integer", JSON_REPAIRS_ENV, value)
+		}
+		jsonRepairs = repairs
+	}
+
+	return nil
+}
+
+// Read the chat model from CHAT_MODEL and check it's a known one, so a typo fails before the first request
+func loadModel() (string, error) {
+	model := cmp.Or(os.Getenv(MODEL_ENV), openai.ChatModelGPT4oMini)
+	model, err := completion.ValidateModel(model, os.Getenv(ALLOW_UNKNOWN_MODEL_ENV) == "1")
+	if err != nil {
+		return "", exitcode.Errorf(exitcode.ClassConfig, "invalid %s: %w. Set %s=1 to use it anyway", MODEL_ENV, err, ALLOW_UNKNOWN_MODEL_ENV)
+	}
+
+	return model, nil
+}
+
+// Add the usage of a completion to the usage of a turn
+func addUsage(total *openai.CompletionUsage, usage openai.CompletionUsage) {
+	total.PromptTokens += usage.PromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+	total.TotalTokens += usage.TotalTokens
 }
 
 /*
@@ -150,65 +352,313 @@ func updateHistoryAndConversation(newMessage *ChatMessage) {
 -----------------------------
 */
 
-// Get globally defined openaiClient, initialize it if nil
-func getOpenaiClient() *openai.Client {
-	if openaiClient == nil {
-		fmt.Println("Createing new OpenAI client")
-		openaiClient = openai.NewClient()
+// Parameters of a chat completion request. With jsonObject the response is requested as a JSON object
+func chatCompletionParams(messages []openai.ChatCompletionMessageParamUnion, model string, jsonObject bool) openai.ChatCompletionNewParams {
+	params := openai.ChatCompletionNewParams{
+		Messages: openai.F(messages),
+		Model:    openai.F(model),
+	}
+	if jsonObject {
+		params.Messages = openai.F(append(slices.Clone(messages), openai.SystemMessage(JSON_MODE_INSTRUCTION)))
+		params.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
+			openai.ResponseFormatJSONObjectParam{Type: openai.F(openai.ResponseFormatJSONObjectTypeJSONObject)},
+		)
+	}
+	// Attribute the completion to the end user, if any, for abuse monitoring
+	if user := completion.EndUserID(os.Getenv(completion.EndUserEnv)); user != "" {
+		params.User = openai.F(user)
 	}
-	return openaiClient
+
+	return params
 }
 
-// Main openai chat completion, provide messages and a model, return a response
-func openaiChatCompletion(messages []openai.ChatCompletionMessageParamUnion, model string) string {
-	openaiClient = getOpenaiClient()
+// Main openai chat completion, provide messages and a model, return a response and its token usage.
+// Refusals, filtered content and empty responses are returned as completion errors.
+// Failed requests carry their OpenAI request ID, cancelling ctx cancels the request.
+// With jsonObject the response is requested as a JSON object
+func openaiChatCompletion(
+	ctx context.Context,
+	messages []openai.ChatCompletionMessageParamUnion,
+	model string,
+	jsonObject bool,
+) (string, openai.CompletionUsage, error) {
+	openaiClient, err := getOpenaiClient()
+	if err != nil {
+		return "", openai.CompletionUsage{}, err
+	}
 
+	var httpResponse *http.Response
 	chatCompletion, err := openaiClient.Chat.Completions.New(
-		context.TODO(),
-		openai.ChatCompletionNewParams{
-			Messages: openai.F(messages),
-			Model:    openai.F(model),
-		},
+		ctx,
+		chatCompletionParams(messages, model, jsonObject),
+		option.WithResponseInto(&httpResponse),
 	)
 
 	if err != nil {
-		panic(err)
+		return "", openai.CompletionUsage{}, completion.WithRequestID(err, completion.RequestID(httpResponse, err))
+	}
+
+	message, err := completion.Message(chatCompletion)
+	if err != nil {
+		return "", chatCompletion.Usage, err
+	}
+
+	return message.Content, chatCompletion.Usage, nil
+}
+
+// Streamed openai chat completion, calling onDelta with each piece of the response as it arrives.
+// Cancelling ctx interrupts the response, which is returned with what was received so far, see completion.ReadStream
+func openaiChatCompletionStream(
+	ctx context.Context,
+	messages []openai.ChatCompletionMessageParamUnion,
+	model string,
+	onDelta func(string),
+) (completion.StreamResult, error) {
+	openaiClient, err := getOpenaiClient()
+	if err != nil {
+		return completion.StreamResult{}, err
+	}
+
+	params := chatCompletionParams(messages, model, false)
+	params.StreamOptions = openai.F(openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.F(true)})
+
+	var httpResponse *http.Response
+	stream := openaiClient.Chat.Completions.NewStreaming(ctx, params, option.WithResponseInto(&httpResponse))
+	defer stream.Close()
+
+	result, err := completion.ReadStream(ctx, stream, onDelta)
+	if err != nil {
+		return result, completion.WithRequestID(err, completion.RequestID(httpResponse, err))
+	}
+
+	return result, nil
+}
+
+// Stream an answer to the tracked conversation, printing it as it arrives. Escape, or Ctrl+C cancelling ctx,
+// interrupts it. Escape is watched while the answer streams only, so Ctrl+C still exits while waiting for input
+func streamAnswer(ctx context.Context, model string) (completion.StreamResult, error) {
+	streamCtx, interrupt := context.WithCancel(ctx)
+	defer interrupt()
+
+	// Without a terminal, like with piped input, only Ctrl+C interrupts
+	if stopWatching, err := terminal.WatchKeys(os.Stdin, []byte{terminal.KeyEscape}, interrupt); err == nil {
+		defer stopWatching()
+	}
+
+	printed := ""
+	result, err := openaiChatCompletionStream(streamCtx, conversationMessages, model, func(delta string) {
+		if printed == "" {
+			fmt.Print("assistant >> ")
+		}
+		fmt.Print(delta)
+		printed += delta
+	})
+
+	switch {
+	case result.Interrupted && printed == "":
+		fmt.Printf("assistant >> %s\n", completion.InterruptedMarker)
+	case result.Interrupted && strings.TrimRightFunc(printed, unicode.IsSpace) != printed:
+		fmt.Printf("%s\n", completion.InterruptedMarker)
+	case result.Interrupted:
+		fmt.Printf(" %s\n", completion.InterruptedMarker)
+	case printed != "":
+		fmt.Println()
 	}
 
-	return chatCompletion.Choices[0].Message.Content
+	return result, err
+}
+
+// Decode a JSON mode answer, extracting or repairing it when it's broken. Repair requests
+// are made without the conversation, and their usage is added to the usage of the turn
+func decodeJsonAnswer(ctx context.Context, answer string, model string, usage *openai.CompletionUsage) completion.JSONOutcome {
+	repair := func(ctx context.Context, broken string) (string, error) {
+		messages := []openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(completion.JSONRepairPrompt),
+			openai.UserMessage(broken),
+		}
+		repaired, repairUsage, err := openaiChatCompletion(ctx, messages, model, true)
+		addUsage(usage, repairUsage)
+		return repaired, err
+	}
+
+	return completion.DecodeJSONResponse(ctx, answer, repair, jsonRepairs)
+}
+
+// Log the token usage of a turn, with the JSON stage in JSON mode
+func logTurnUsage(model string, usage openai.CompletionUsage, outcome *completion.JSONOutcome) {
+	attrs := []any{
+		"model", model,
+		"prompt_tokens", usage.PromptTokens,
+		"completion_tokens", usage.CompletionTokens,
+		"total_tokens", usage.TotalTokens,
+	}
+	if outcome != nil {
+		attrs = append(attrs, "json_stage", outcome.Stage, "json_repairs", outcome.Repairs)
+	}
+	logger.Info("turn usage", attrs...)
+}
+
+// Complete one turn on the tracked conversation and add the answer to it. A replaced answer, the one
+// <regenerate> removed, is kept as a variant of the new one with CHAT_KEEP_VARIANTS, and diffed with it when showDiff is set.
+// Returns the error of the completion if it failed or, when not streamed, was interrupted with Ctrl+C
+func chatTurn(ctx context.Context, model string, replaced *ChatMessage, showDiff bool) error {
+	// Ctrl+C only cancels the pending completion, so the history is still saved. A streamed answer keeps
+	// what it said so far and the chat goes on
+	turnCtx, stopTurn := signal.NotifyContext(ctx, os.Interrupt)
+	var response string
+	var usage openai.CompletionUsage
+	var err error
+	var jsonOutcome *completion.JSONOutcome
+	if streamMode {
+		var result completion.StreamResult
+		result, err = streamAnswer(turnCtx, model)
+		response, usage = result.Text, result.Usage
+	} else {
+		response, usage, err = openaiChatCompletion(turnCtx, conversationMessages, model, jsonMode)
+		if err == nil && jsonMode {
+			outcome := decodeJsonAnswer(turnCtx, response, model, &usage)
+			jsonOutcome = &outcome
+		}
+	}
+	stopTurn()
+	logTurnUsage(model, usage, jsonOutcome)
+	var refusal *completion.ErrModelRefusal
+	switch {
+	case errors.As(err, &refusal) && jsonMode:
+		response = refusal.Message
+		printJsonAnswer(response, "refusal")
+	case errors.As(err, &refusal):
+		// Keep the refusal in the history so the conversation can go on
+		response = refusal.Message
+		fmt.Printf("assistant (refused) >> %s\n", response)
+	case jsonOutcome != nil && jsonOutcome.Err != nil:
+		// Keep the raw answer so the user still sees what the model said
+		logger.Error("invalid JSON answer", "model", model, "json_stage", jsonOutcome.Stage, "json_repairs", jsonOutcome.Repairs, "error", jsonOutcome.Err)
+		printJsonAnswer(response, "invalid_json")
+	case jsonOutcome != nil:
+		response = jsonOutcome.Text
+		printJsonAnswer(response, "")
+	case err != nil:
+		logger.Error("chat completion failed", "model", model, "request_id", completion.RequestIDOf(err), "error", err)
+		// A failed regeneration leaves the replaced answer where it was
+		if replaced != nil {
+			updateHistoryAndConversation(replaced)
+		}
+		return err
+	case streamMode:
+		// Printed while streaming
+	default:
+		fmt.Printf("assistant >> %s\n", response)
+	}
+
+	assistantMessage := ChatMessage{Role: "assistant", Content: response}
+	if replaced != nil {
+		if showDiff {
+			printAnswerDiff(replaced.Content, response)
+		}
+		if os.Getenv(KEEP_VARIANTS_ENV) == "1" {
+			assistantMessage.Variants = append(slices.Clone(replaced.Variants), replaced.Content)
+		}
+	}
+	updateHistoryAndConversation(&assistantMessage)
+
+	return nil
 }
 
 // Openai chat loop. Starts chatcompletion with `question`, then ask user input on loop.
-// Break the loop if user input is <exit>
-func openaiChat(question string, model string) {
+// <regenerate> answers the last question again, <regenerate --diff> also prints what changed.
+// Break the loop if user input is <exit>, or return the error of a completion that failed or, when not streamed,
+// was interrupted with Ctrl+C
+func openaiChat(ctx context.Context, question string, model string) error {
 	inputBuffer := bufio.NewReader(os.Stdin)
 	var err error
 
-	fmt.Printf("user >> %s\n", question)
+	fmt.Fprintf(promptOutput(), "user >> %s\n", question)
 	for {
-		userMessage := ChatMessage{Role: "user", Content: question}
-		updateHistoryAndConversation(&userMessage)
-
-		response := openaiChatCompletion(conversationMessages, model)
-		fmt.Printf("assistant >> %s\n", response)
-
-		assistantMessage := ChatMessage{Role: "assistant", Content: response}
-		updateHistoryAndConversation(&assistantMessage)
+		if question == REGENERATE_COMMAND || question == REGENERATE_DIFF_COMMAND {
+			if replaced := popLastAnswer(); replaced == nil {
+				logger.Warn("there is no answer to regenerate")
+			} else if err := chatTurn(ctx, model, replaced, question == REGENERATE_DIFF_COMMAND); err != nil {
+				return err
+			}
+		} else {
+			userMessage := ChatMessage{Role: "user", Content: question}
+			updateHistoryAndConversation(&userMessage)
+			if err := chatTurn(ctx, model, nil, false); err != nil {
+				return err
+			}
+		}
 
-		fmt.Printf("user >> ")
+		fmt.Fprint(promptOutput(), "user >> ")
 		question, err = inputBuffer.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			logger.Info("input ended, exiting")
+			break
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "There was an issue parsing user input. Error: %s\n", err)
+			logger.Error("failed to read user input", "error", err)
 			break
 		}
 
 		question = strings.Trim(question, "\n ")
 		if question == "<exit>" {
-			fmt.Println("User requested exit")
+			logger.Info("exiting as requested")
 			break
 		}
 
 	}
+
+	return nil
+}
+
+// Remove the leading flags from the arguments: --error-format json or --error-format=json, --json, and --verbose
+// or --quiet. Returns the remaining arguments and the log level the flags picked
+func parseLeadingFlags(args []string) ([]string, slog.Level, error) {
+	level := slog.LevelInfo
+	verbose, quiet := false, false
+	for len(args) > 0 {
+		switch {
+		case strings.HasPrefix(args[0], ERROR_FORMAT_FLAG+"="):
+			errorFormat, args = strings.TrimPrefix(args[0], ERROR_FORMAT_FLAG+"="), args[1:]
+		case len(args) > 1 && args[0] == ERROR_FORMAT_FLAG:
+			errorFormat, args = args[1], args[2:]
+		case args[0] == ERROR_FORMAT_FLAG:
+			errorFormat = exitcode.FormatText
+			return nil, level, exitcode.Errorf(exitcode.ClassUsage, "%s needs a value", ERROR_FORMAT_FLAG)
+		case args[0] == JSON_FLAG:
+			jsonMode, args = true, args[1:]
+		case args[0] == VERBOSE_FLAG:
+			verbose, level, args = true, slog.LevelDebug, args[1:]
+		case args[0] == QUIET_FLAG:
+			quiet, level, args = true, slog.LevelWarn, args[1:]
+		default:
+			return args, level, validateLeadingFlags(verbose, quiet)
+		}
+	}
+
+	return args, level, validateLeadingFlags(verbose, quiet)
+}
+
+func validateLeadingFlags(verbose bool, quiet bool) error {
+	if err := exitcode.ValidateFormat(errorFormat); err != nil {
+		errorFormat = exitcode.FormatText
+		return err
+	}
+	if verbose && quiet {
+		return exitcode.Errorf(exitcode.ClassUsage, "%s and %s can't be used together", VERBOSE_FLAG, QUIET_FLAG)
+	}
+	return nil
+}
+
+// Print the error ending the chat on the --error-format and exit with the code of its class
+func exitWithError(err error) {
+	report := exitcode.NewReport(err)
+	if errorFormat == exitcode.FormatJSON {
+		fmt.Fprintln(os.Stderr, report.JSON())
+	} else {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	os.Exit(report.Code)
 }
 
 /*
@@ -217,17 +667,60 @@ Entrypoint
 ----------
 */
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s [question] [optional-restart-conversation(bool)]\n", os.Args[0])
-		os.Exit(1)
+	args, level, err := parseLeadingFlags(os.Args[1:])
+	setupLogging(os.Stderr, level)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if len(args) < 1 {
+		flags := fmt.Sprintf("[%s text|json] [%s|%s]", ERROR_FORMAT_FLAG, VERBOSE_FLAG, QUIET_FLAG)
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [%s] [question] [optional-restart-conversation(bool)]\n", os.Args[0], flags, JSON_FLAG)
+		fmt.Fprintf(os.Stderr, "       %s %s %s\n", os.Args[0], flags, COMPACT_COMMAND)
+		fmt.Fprintf(os.Stderr, "       %s %s %s [from-history] [to-history]\n", os.Args[0], flags, CONVERT_COMMAND)
+		exitWithError(exitcode.Errorf(exitcode.ClassUsage, "missing question"))
 	}
 
 	restartConversation := false
-	if len(os.Args) > 2 {
-		restartConversation = strings.Contains(strings.ToLower(os.Args[2]), "true")
+	if len(args) > 1 {
+		restartConversation = strings.Contains(strings.ToLower(args[1]), "true")
+	}
+
+	// The history is found from any directory of the workspace, like src
+	if _, err := config.SetupWorkspace("", WORKSPACE_MARKER); err != nil {
+		exitWithError(exitcode.New(exitcode.ClassConfig, err))
+	}
+
+	if err := openHistoryStore(); err != nil {
+		exitWithError(err)
+	}
+
+	// History commands need no API key
+	if args[0] == COMPACT_COMMAND || args[0] == CONVERT_COMMAND {
+		if err := runHistoryCommand(args[0], args[1:]); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
+	// Fail before loading the conversation when no API key is set
+	completion.MissingAPIKeyHint = fmt.Sprintf("export %s before asking, the %s and %s commands run without it", completion.APIKeyEnv, COMPACT_COMMAND, CONVERT_COMMAND)
+	if _, err := completion.GetOpenaiClient(); err != nil {
+		exitWithError(exitcode.Errorf(exitcode.ClassConfig, "failed to create OpenAI client: %w", err))
+	}
+
+	if err := loadJsonModeSettings(); err != nil {
+		exitWithError(err)
+	}
+	streamMode = os.Getenv(STREAM_ENV) == "1" && !jsonMode
+
+	model, err := loadModel()
+	if err != nil {
+		exitWithError(err)
 	}
 
 	loadConversation(restartConversation)
-	openaiChat(os.Args[1], openai.ChatModelGPT4oMini)
-	saveHistoryToJson()
+	if err := openaiChat(context.Background(), args[0], model); err != nil {
+		exitWithError(err)
+	}
 }
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/EzequielGhR/goProjects/openaiAgent/completion"
+	"github.com/EzequielGhR/goProjects/openaiAgent/history"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// Prefixes of the stdout lines in text mode. Piped input isn't echoed, so a prompt may start the line of the answer
+var conversationPrefixes = []string{"user >> ", "assistant >> ", "assistant (refused) >> ", "diff >> "}
+
+// Scripted session: the history it starts from, the mock API answers and the input after the question.
+// Answers starting with "refusal:" are refused, and "fail" answers with a server error
+type streamsCase struct {
+	name     string
+	history  []*ChatMessage // Saved before the session, none starts a new conversation
+	restart  bool
+	jsonMode bool
+	stream   bool
+	question string
+	inputs   []string
+	answers  []string
+	logs     []string // Messages expected on stderr only
+	output   []string // Text expected on stdout
+	failure  bool     // The session ends with the error of a failed completion
+}
+
+var savedConversation = []*ChatMessage{
+	{Role: "system", Content: "You are a useful assistant"},
+	{Role: "user", Content: "hi"},
+	{Role: "assistant", Content: "hello there"},
+}
+
+var streamsCases = []streamsCase{
+	{
+		name:     "new conversation",
+		question: "first question",
+		inputs:   []string{"second question", "<exit>"},
+		answers:  []string{"first answer", "second answer"},
+		logs:     []string{"no history found", "initializing new conversation", "turn usage", "exiting as requested"},
+		output:   []string{"user >> first question", "assistant >> first answer", "assistant >> second answer"},
+	},
+	{
+		name:     "loaded conversation",
+		history:  savedConversation,
+		question: "next question",
+		answers:  []string{"next answer"},
+		logs:     []string{"loading conversation", "turn usage", "input ended, exiting"},
+		output:   []string{"assistant >> next answer"},
+	},
+	{
+		name:     "restarted conversation",
+		history:  savedConversation,
+		restart:  true,
+		question: "question",
+		inputs:   []string{"<exit>"},
+		answers:  []string{"answer"},
+		logs:     []string{"restarting conversation as requested", "initializing new conversation"},
+		output:   []string{"assistant >> answer"},
+	},
+	{
+		name:     "empty history",
+		history:  []*ChatMessage{},
+		question: "question",
+		answers:  []string{"answer"},
+		logs:     []string{"history has no messages", "initializing new conversation"},
+	},
+	{
+		name:     "regenerate with diff",
+		history:  savedConversation,
+		question: REGENERATE_DIFF_COMMAND,
+		inputs:   []string{"<exit>"},
+		answers:  []string{"hello again"},
+		logs:     []string{"loading conversation", "turn usage"},
+		output:   []string{"assistant >> hello again", "diff >> "},
+	},
+	{
+		name:     "nothing to regenerate",
+		question: REGENERATE_COMMAND,
+		inputs:   []string{"<exit>"},
+		logs:     []string{"there is no answer to regenerate"},
+	},
+	{
+		name:     "refusal",
+		question: "question",
+		answers:  []string{"refusal:I can't help with that."},
+		logs:     []string{"turn usage"},
+		output:   []string{"assistant (refused) >> I can't help with that."},
+	},
+	{
+		name:     "streamed",
+		stream:   true,
+		question: "question",
+		inputs:   []string{"<exit>"},
+		answers:  []string{"streamed answer"},
+		logs:     []string{"turn usage"},
+		output:   []string{"assistant >> streamed answer"},
+	},
+	{
+		name:     "failed completion",
+		question: "question",
+		answers:  []string{"fail"},
+		logs:     []string{"turn usage", "chat completion failed"},
+		failure:  true,
+	},
+	{
+		name:     "json mode",
+		jsonMode: true,
+		question: "question",
+		inputs:   []string{"again", "<exit>"},
+		answers:  []string{`{"answer": 1}`, "```json\n{\"answer\": 2}\n```"},
+		logs:     []string{"turn usage", "exiting as requested"},
+		output:   []string{`{"answer":1}`, `{"answer":2}`},
+	},
+	{
+		name:     "json mode invalid answer",
+		jsonMode: true,
+		question: "question",
+		answers:  []string{"not json", "still not json"},
+		logs:     []string{"invalid JSON answer"},
+		output:   []string{`{"invalid_json":"not json"}`},
+	},
+	{
+		name:     "json mode refusal",
+		jsonMode: true,
+		question: "question",
+		answers:  []string{"refusal:I can't help with that."},
+		output:   []string{`{"refusal":"I can't help with that."}`},
+	},
+}
+
+// Scripted sessions against a mock API keep only role prefixed conversation lines, or JSON lines in JSON mode,
+// on stdout and every operational message on stderr
+func TestSessionStreams(t *testing.T) {
+	for _, c := range streamsCases {
+		t.Run(c.name, func(t *testing.T) {
+			server := newMockChatServer(c.answers)
+			defer server.Close()
+			getOpenaiClient = func() (*openai.Client, error) {
+				return openai.NewClient(option.WithBaseURL(server.URL+"/"), option.WithAPIKey("test"), option.WithMaxRetries(0)), nil
+			}
+			defer func() { getOpenaiClient = completion.GetOpenaiClient }()
+
+			openTestHistory(t, c.history)
+			historyMessages, conversationMessages = []*ChatMessage{}, []openai.ChatCompletionMessageParamUnion{}
+			jsonMode, streamMode = c.jsonMode, c.stream
+			defer func() { jsonMode, streamMode = false, false }()
+
+			var sessionErr error
+			stdout, stderr := captureStreams(t, strings.Join(c.inputs, "\n"), func() {
+				loadConversation(c.restart)
+				sessionErr = openaiChat(context.Background(), c.question, openai.ChatModelGPT4oMini)
+			})
+			if c.failure != (sessionErr != nil) {
+				t.Fatalf("session ended with error %v", sessionErr)
+			}
+			if err := checkStreams(stdout, stderr, c.jsonMode, c.logs, c.output); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// Regenerated answers replace the last one in the saved history, keep the replaced answers as variants with
+// CHAT_KEEP_VARIANTS, and print the word diff with plain markers when color is disabled
+func TestRegenerateVariants(t *testing.T) {
+	cases := []struct {
+		name         string
+		keepVariants bool
+		inputs       []string
+		answers      []string
+		wantVariants []string
+		wantDiffs    []string
+	}{
+		{
+			name:      "variants dropped",
+			inputs:    []string{"<exit>"},
+			answers:   []string{"hello again"},
+			wantDiffs: []string{"diff >> hello {+again+} [-there-]"},
+		},
+		{
+			name:         "variants kept",
+			keepVariants: true,
+			inputs:       []string{"<exit>"},
+			answers:      []string{"hello again"},
+			wantVariants: []string{"hello there"},
+			wantDiffs:    []string{"diff >> hello {+again+} [-there-]"},
+		},
+		{
+			name:         "regenerated twice",
+			keepVariants: true,
+			inputs:       []string{REGENERATE_DIFF_COMMAND, REGENERATE_COMMAND, "<exit>"},
+			answers:      []string{"hello again", "hello again", "hi café"},
+			wantVariants: []string{"hello there", "hello again", "hello again"},
+			wantDiffs:    []string{"diff >> hello {+again+} [-there-]", "diff >> the regenerated answer is the same"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := newMockChatServer(c.answers)
+			defer server.Close()
+			getOpenaiClient = func() (*openai.Client, error) {
+				return openai.NewClient(option.WithBaseURL(server.URL+"/"), option.WithAPIKey("test"), option.WithMaxRetries(0)), nil
+			}
+			defer func() { getOpenaiClient = completion.GetOpenaiClient }()
+			if c.keepVariants {
+				t.Setenv(KEEP_VARIANTS_ENV, "1")
+			}
+			t.Setenv(NO_COLOR_ENV, "1")
+
+			openTestHistory(t, savedConversation)
+			historyMessages, conversationMessages = []*ChatMessage{}, []openai.ChatCompletionMessageParamUnion{}
+			var sessionErr error
+			stdout, _ := captureStreams(t, strings.Join(c.inputs, "\n"), func() {
+				loadConversation(false)
+				sessionErr = openaiChat(context.Background(), REGENERATE_DIFF_COMMAND, openai.ChatModelGPT4oMini)
+			})
+			if sessionErr != nil {
+				t.Fatal(sessionErr)
+			}
+
+			diffs := []string{}
+			for _, line := range strings.Split(stdout, "\n") {
+				if _, diff, ok := strings.Cut(line, "diff >> "); ok {
+					diffs = append(diffs, "diff >> "+diff)
+				}
+			}
+			if !slices.Equal(diffs, c.wantDiffs) {
+				t.Errorf("printed diffs %q, expected %q", diffs, c.wantDiffs)
+			}
+
+			saved, err := historyStore.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved.Messages) != len(savedConversation) {
+				t.Fatalf("saved %d messages, expected the regenerated answer to replace the last one", len(saved.Messages))
+			}
+			last := saved.Messages[len(saved.Messages)-1]
+			if last.Content != c.answers[len(c.answers)-1] || !slices.Equal(last.Variants, c.wantVariants) {
+				t.Errorf("saved answer '%s' with variants %q, expected '%s' with %q", last.Content, last.Variants, c.answers[len(c.answers)-1], c.wantVariants)
+			}
+		})
+	}
+}
+
+// Compacting and converting a history only log
+func TestHistoryCommandStreams(t *testing.T) {
+	historyPath := openTestHistory(t, savedConversation)
+
+	var commandErr error
+	stdout, stderr := captureStreams(t, "", func() {
+		commandErr = compactHistory()
+		if commandErr == nil {
+			commandErr = convertHistory(historyPath, filepath.Join(filepath.Dir(historyPath), "history.json"))
+		}
+	})
+	if commandErr != nil {
+		t.Fatal(commandErr)
+	}
+	if err := checkStreams(stdout, stderr, false, []string{"compacted history", "converted history"}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Open the history store on a temporary file holding messages, none when nil. Returns the path of the file
+func openTestHistory(t *testing.T, messages []*ChatMessage) string {
+	t.Helper()
+
+	historyPath := filepath.Join(t.TempDir(), "history.jsonl")
+	var err error
+	historyStore, err = history.OpenStore(historyPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messages != nil {
+		if err := historyStore.Save(history.New(messages)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return historyPath
+}
+
+// Check stdout only has conversation lines, or JSON lines in JSON mode, holding output, and every stderr line
+// is a log line, or a prompt in JSON mode, with the logs messages among them
+func checkStreams(stdout string, stderr string, jsonLines bool, logs []string, output []string) error {
+	for _, line := range strings.Split(strings.TrimSuffix(stdout, "\n"), "\n") {
+		switch {
+		case line == "" && stdout == "":
+		case jsonLines && !json.Valid([]byte(line)):
+			return fmt.Errorf("stdout line %q isn't JSON", line)
+		case !jsonLines && !slices.ContainsFunc(conversationPrefixes, func(prefix string) bool { return strings.HasPrefix(line, prefix) }):
+			return fmt.Errorf("stdout line %q isn't part of the conversation", line)
+		}
+	}
+
+	messages := []string{}
+	for _, line := range strings.Split(strings.TrimSuffix(stderr, "\n"), "\n") {
+		// In JSON mode the prompts are on stderr, and the log line of the input read may follow them
+		prompted := false
+		for jsonLines && strings.HasPrefix(line, "user >> ") {
+			line, prompted = strings.TrimPrefix(line, "user >> "), true
+		}
+		if jsonLines && strings.HasPrefix(line, "diff >> ") || line == "" {
+			continue
+		}
+		attrs := logAttrs(line)
+		if attrs["level"] == "" && prompted {
+			continue
+		}
+		if attrs["level"] == "" {
+			return fmt.Errorf("stderr line %q is neither a log line nor a prompt", line)
+		}
+		messages = append(messages, attrs["msg"])
+	}
+
+	for _, message := range logs {
+		if !slices.Contains(messages, message) {
+			return fmt.Errorf("message %q isn't logged on stderr, found %q", message, messages)
+		}
+		if strings.Contains(stdout, message) {
+			return fmt.Errorf("message %q is on stdout", message)
+		}
+	}
+	for _, text := range output {
+		if !strings.Contains(stdout, text) {
+			return fmt.Errorf("stdout doesn't hold %q: %q", text, stdout)
+		}
+	}
+
+	return nil
+}
+
+// Attributes of a slog text line, like level and msg. Empty for other lines
+func logAttrs(line string) map[string]string {
+	attrs := map[string]string{}
+	for line != "" {
+		key, rest, ok := strings.Cut(line, "=")
+		if !ok || strings.ContainsAny(key, " \"") {
+			return map[string]string{}
+		}
+		value := ""
+		if strings.HasPrefix(rest, `"`) {
+			quoted := rest[:1+strings.Index(rest[1:], `"`)+1]
+			for strings.HasSuffix(quoted, `\"`) && len(quoted) < len(rest) {
+				quoted = rest[:len(quoted)+strings.Index(rest[len(quoted):], `"`)+1]
+			}
+			if err := json.Unmarshal([]byte(quoted), &value); err != nil {
+				return map[string]string{}
+			}
+			rest = rest[len(quoted):]
+		} else {
+			value, rest, _ = strings.Cut(rest, " ")
+		}
+		attrs[key] = value
+		line = strings.TrimPrefix(rest, " ")
+	}
+	return attrs
+}
+
+// Run session with its stdout and stderr captured and input as its stdin. Logs go to the captured stderr
+// from the debug level up, and back to stderr once the session ends
+func captureStreams(t *testing.T, input string, session func()) (string, string) {
+	t.Helper()
+
+	stdin, stdinWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdoutReader, stdout, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderrReader, stderr, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	captured := make([]string, 2)
+	wait := sync.WaitGroup{}
+	for i, reader := range []*os.File{stdoutReader, stderrReader} {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			text, _ := io.ReadAll(reader)
+			captured[i] = string(text)
+		}()
+	}
+	go func() {
+		if input != "" {
+			io.WriteString(stdinWriter, input+"\n")
+		}
+		stdinWriter.Close()
+	}()
+
+	originalStdin, originalStdout, originalStderr := os.Stdin, os.Stdout, os.Stderr
+	os.Stdin, os.Stdout, os.Stderr = stdin, stdout, stderr
+	setupLogging(stderr, slog.LevelDebug)
+	session()
+	os.Stdin, os.Stdout, os.Stderr = originalStdin, originalStdout, originalStderr
+	setupLogging(os.Stderr, slog.LevelInfo)
+
+	stdout.Close()
+	stderr.Close()
+	wait.Wait()
+	stdin.Close()
+	return captured[0], captured[1]
+}
+
+// Mock chat completions API answering each request with the next answer, streamed when asked to
+func newMockChatServer(answers []string) *httptest.Server {
+	mu := sync.Mutex{}
+	next := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := struct {
+			Stream bool `json:"stream"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&request)
+
+		mu.Lock()
+		answer := "no more answers"
+		if next < len(answers) {
+			answer = answers[next]
+		}
+		next++
+		mu.Unlock()
+
+		if answer == "fail" {
+			http.Error(w, `{"error": {"message": "mock failure", "type": "server_error"}}`, http.StatusInternalServerError)
+			return
+		}
+
+		message := map[string]any{"role": "assistant", "content": answer}
+		if refusal, ok := strings.CutPrefix(answer, "refusal:"); ok {
+			message = map[string]any{"role": "assistant", "content": nil, "refusal": refusal}
+		}
+		usage := map[string]int{"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}
+
+		if !request.Stream {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{
+				"id": "mock", "object": "chat.completion", "created": 0, "model": openai.ChatModelGPT4oMini,
+				"choices": []any{map[string]any{"index": 0, "finish_reason": "stop", "logprobs": nil, "message": message}},
+				"usage":   usage,
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunks := []map[string]any{}
+		for _, word := range strings.SplitAfter(answer, " ") {
+			chunks = append(chunks, map[string]any{"index": 0, "delta": map[string]any{"content": word}, "finish_reason": nil})
+		}
+		chunks = append(chunks, map[string]any{"index": 0, "delta": map[string]any{}, "finish_reason": "stop"})
+		for _, choice := range chunks {
+			writeMockChunk(w, []any{choice}, nil)
+		}
+		writeMockChunk(w, []any{}, usage)
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+}
+
+func writeMockChunk(w io.Writer, choices []any, usage map[string]int) {
+	chunk, _ := json.Marshal(map[string]any{
+		"id": "mock", "object": "chat.completion.chunk", "created": 0, "model": openai.ChatModelGPT4oMini,
+		"choices": choices, "usage": usage,
+	})
+	fmt.Fprintf(w, "data: %s\n\n", chunk)
+}